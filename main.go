@@ -1,163 +1,167 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/kekePower/museweb/pkg/config"
-	"github.com/kekePower/museweb/pkg/errors"
-	"github.com/kekePower/museweb/pkg/middleware"
+	"github.com/kekePower/museweb/pkg/museweb"
 	"github.com/kekePower/museweb/pkg/server"
-	"github.com/kekePower/museweb/pkg/utils"
 )
 
-const version = "1.2.0-dev"
+// Set at build time with -ldflags "-X main.commit=... -X main.buildDate=..."
+var (
+	version   = "1.2.0-dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// shutdownTimeout is how long running generations may finish on shutdown
+const shutdownTimeout = 30 * time.Second
 
 func main() {
 	// --- Load Configuration ---
-	cfg, err := config.Load("config.yaml")
-	if err != nil {
-		log.Printf("⚠️  Could not load config.yaml: %v. Using defaults and flags only.", err)
+	// The file is read before the flags are parsed, since it sets their defaults
+	configPath := configFlag(os.Args[1:])
+	explicit := configPath != ""
+	if !explicit {
+		configPath = config.Find()
 	}
-
-	// Set reasoning model patterns from configuration
-	if len(cfg.Model.ReasoningModels) > 0 {
-		utils.SetReasoningModelPatterns(cfg.Model.ReasoningModels)
-		log.Printf("🧠 Loaded %d reasoning model patterns from config", len(cfg.Model.ReasoningModels))
+	cfg, err := config.Load(configPath)
+	switch {
+	case err != nil && explicit:
+		log.Fatalf("❌ Could not load %s: %v", configPath, err)
+	case configPath == "":
+		log.Printf("⚠️  No configuration file found (looked for %s). Using defaults and flags only.", strings.Join(config.SearchPaths(), ", "))
+	case err != nil:
+		log.Printf("⚠️  Could not load %s: %v. Using defaults and flags only.", configPath, err)
+	default:
+		log.Printf("📄 Loaded configuration from %s", configPath)
 	}
 
 	// --- Define Command-Line Flags ---
+	flag.String("config", "", "Configuration file (default: the first of "+strings.Join(config.SearchPaths(), ", ")+")")
 	showVersion := flag.Bool("version", false, "Display the version and exit")
-	host := flag.String("host", cfg.Server.Address, "Interface to bind to (e.g., 127.0.0.1 or 0.0.0.0)")
-	port := flag.String("port", cfg.Server.Port, "Port to run the web server on")
-	promptsDir := flag.String("prompts", cfg.Server.PromptsDir, "Directory containing prompt files")
-	backend := flag.String("backend", cfg.Model.Backend, "AI backend to use (ollama or openai)")
-	model := flag.String("model", cfg.Model.Name, "Model name to use")
-	// Default API key based on backend
-	var defaultAPIKey string
-	if strings.ToLower(cfg.Model.Backend) == "openai" {
-		defaultAPIKey = cfg.OpenAI.APIKey
-	} else {
-		defaultAPIKey = cfg.Ollama.APIKey
-	}
-	apiKey := flag.String("api-key", defaultAPIKey, "API key for the selected backend (ignored if not required)")
-
-	// Choose sensible default for api-base depending on backend in config
-	var defaultAPIBase string
-	if strings.ToLower(cfg.Model.Backend) == "openai" {
-		defaultAPIBase = cfg.OpenAI.APIBase
-	} else {
-		defaultAPIBase = cfg.Ollama.APIBase
-	}
-	apiBase := flag.String("api-base", defaultAPIBase, "Base URL for the selected backend")
-	debug := flag.Bool("debug", cfg.Server.Debug, "Enable debug mode")
+	flag.StringVar(&cfg.Server.Address, "host", cfg.Server.Address, "Interface to bind to (e.g., 127.0.0.1 or 0.0.0.0)")
+	flag.StringVar(&cfg.Server.Port, "port", cfg.Server.Port, "Port to run the web server on")
+	flag.StringVar(&cfg.Server.PromptsDir, "prompts", cfg.Server.PromptsDir, "Directory containing prompt files")
+	flag.StringVar(&cfg.Model.Backend, "backend", cfg.Model.Backend, "AI backend to use (ollama, openai, azure-openai, mistral, groq, vllm, tgi, anthropic, bedrock, llamacpp, or mock)")
+	flag.StringVar(&cfg.Model.Name, "model", cfg.Model.Name, "Model name to use")
+	apiKey := flag.String("api-key", "", "API key for the selected backend (default: from config.yaml or the backend's environment variable)")
+	apiBase := flag.String("api-base", "", "Base URL for the selected backend (default: from config.yaml)")
+	flag.BoolVar(&cfg.Server.Debug, "debug", cfg.Server.Debug, "Enable debug mode")
+	flag.BoolVar(&cfg.Server.DevMode, "dev", cfg.Server.DevMode, "Enable development mode with browser live-reload")
 	flag.Parse()
 
+	build := server.NewBuildInfo(version, commit, buildDate)
 	if *showVersion {
-		fmt.Printf("MuseWeb v%s\n", version)
+		fmt.Printf("MuseWeb v%s (commit %s, built %s, %s)\n", build.Version, orUnknown(build.Commit), orUnknown(build.BuildDate), build.GoVersion)
 		os.Exit(0)
 	}
 
-	// --- Final Configuration ---
-	// If the api-key flag is still empty, try backend-specific environment variable as a last resort.
-	if *apiKey == "" {
-		if strings.ToLower(*backend) == "openai" {
-			*apiKey = os.Getenv("OPENAI_API_KEY")
-		} else {
-			*apiKey = os.Getenv("OLLAMA_API_KEY")
-		}
+	// --- Setup HTTP Server ---
+	srv, err := museweb.New(cfg, museweb.Options{APIKey: *apiKey, APIBase: *apiBase, Build: build, LoadConfig: reloadConfig(configPath, cfg)})
+	if err != nil {
+		log.Fatalf("❌ %v", err)
 	}
-
-	// --- Validate OpenAI Config ---
-	if *backend == "openai" && *apiKey == "" {
-		log.Fatalf("❌ For the 'openai' backend, the API key must be provided via the -api-key flag, the config.yaml file, or the OPENAI_API_KEY environment variable.")
+	if err := srv.Start(); err != nil {
+		srv.Shutdown(context.Background())
+		log.Fatalf("❌ %v", err)
 	}
 
-	// --- Setup HTTP Server ---
-	serverHandler := server.HandleRequest(*backend, *model, *promptsDir, *apiKey, *apiBase, *debug)
-
-	// Main route handler with recovery middleware
-	mainHandler := middleware.WrapHandler(func(w http.ResponseWriter, r *http.Request) {
-		// Serve static files if the path contains a dot (file extension)
-		if strings.Contains(r.URL.Path, ".") {
-			// Determine static file paths
-			staticReqPath := strings.TrimPrefix(r.URL.Path, "/") // e.g. "logo.png" or "static/logo.png"
-			promptScopedPath := filepath.Join(*promptsDir, "public", staticReqPath)
-			globalPath := filepath.Join("public", staticReqPath)
-
-			// Try prompt-scoped public directory first
-			if _, err := os.Stat(promptScopedPath); err == nil {
-				http.ServeFile(w, r, promptScopedPath)
-				return
+	// Read the configuration file again on SIGHUP, as on POST /admin/reload
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			if err := srv.Reload(); err != nil {
+				log.Printf("⚠️  %v", err)
 			}
-			// Fall back to global public directory
-			if _, err := os.Stat(globalPath); err == nil {
-				http.ServeFile(w, r, globalPath)
-				return
-			}
-			// Not found in either location
-			errors.RenderErrorPage(w, r, http.StatusNotFound, fmt.Sprintf("Static file '%s' not found in prompt-scoped or global public directories", r.URL.Path))
-			return
 		}
-		// Otherwise, handle as a prompt request
-		serverHandler.ServeHTTP(w, r)
-	})
-
-	http.HandleFunc("/", mainHandler)
-
-	displayHost := *host
-	if *host == "0.0.0.0" {
-		displayHost = "localhost"
+	}()
+
+	// Let running generations finish when asked to stop
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	log.Printf("👋 Shutting down...")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("⚠️  Shutdown: %v", err)
 	}
+}
 
-	listenAddr := *host
-	if listenAddr == "0.0.0.0" {
-		listenAddr = ""
+// configFlag returns the value of the -config flag among args, or "" when
+// it is not given
+func configFlag(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if name != "config" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
 	}
+	return ""
+}
 
-	// Add a test route for error handling (can be removed in production)
-	if *debug {
-		http.HandleFunc("/error-test", middleware.WrapHandler(func(w http.ResponseWriter, r *http.Request) {
-			// Test different error types based on query parameter
-			errorType := r.URL.Query().Get("type")
-			switch errorType {
-			case "panic":
-				panic("Test panic for error handling")
-			case "404":
-				errors.NotFound(w, r)
-			case "500":
-				errors.InternalServerError(w, r, "Test internal server error")
-			case "405":
-				errors.MethodNotAllowed(w, r)
-			default:
-				errors.BadRequest(w, r, "Invalid error type. Use: panic, 404, 500, or 405")
+// reloadConfig returns a function that reads the configuration file at path
+// again, keeping the values of the flags given on the command line (stored
+// in flags)
+func reloadConfig(path string, flags *config.Config) func() (*config.Config, error) {
+	return func() (*config.Config, error) {
+		file := path
+		if file == "" {
+			file = config.Find()
+		}
+		cfg, err := config.Load(file)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", file, err)
+		}
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "host":
+				cfg.Server.Address = flags.Server.Address
+			case "port":
+				cfg.Server.Port = flags.Server.Port
+			case "prompts":
+				cfg.Server.PromptsDir = flags.Server.PromptsDir
+			case "backend":
+				cfg.Model.Backend = flags.Model.Backend
+			case "model":
+				cfg.Model.Name = flags.Model.Name
+			case "debug":
+				cfg.Server.Debug = flags.Server.Debug
+			case "dev":
+				cfg.Server.DevMode = flags.Server.DevMode
 			}
-		}))
-		log.Printf("📝 Debug mode: Error testing available at /error-test?type=[panic|404|500|405]")
-	}
-
-	// Create a custom HTTP server with longer timeouts for AI responses
-	server := &http.Server{
-		Addr:         listenAddr + ":" + *port,
-		ReadTimeout:  60 * time.Second,  // Time to read request
-		WriteTimeout: 300 * time.Second, // Time to write response (5 minutes for large AI responses)
-		IdleTimeout:  120 * time.Second, // Time to keep connections alive
-	}
-
-	log.Printf("✨ MuseWeb v%s is live at http://%s:%s", version, displayHost, *port)
-	log.Printf("   (Using backend '%s', model '%s', and prompts from '%s')", *backend, *model, *promptsDir)
-	if utils.IsThinkingEnabledModel(*model) {
-		log.Printf("   🧠 Thinking tag enabled for %s model", *model)
+		})
+		return cfg, nil
 	}
+}
 
-	err = server.ListenAndServe()
-	if err != nil {
-		log.Fatalf("❌ Failed to start server: %v", err)
+// orUnknown returns s, or "unknown" when it is empty
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
 	}
+	return s
 }
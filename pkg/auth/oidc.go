@@ -0,0 +1,501 @@
+// Package auth signs visitors in with an OpenID Connect provider (Google,
+// Microsoft, Keycloak, Authentik, ...), so sites can have per-user pages. It
+// runs the authorization code flow with PKCE, verifies the ID token against
+// the provider's published keys, and keeps the user in a signed cookie.
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultClaims are the ID token claims kept in the session when none are
+// configured
+var DefaultClaims = []string{"name", "preferred_username", "given_name", "family_name", "email", "locale", "groups"}
+
+// DefaultSessionTTL is how long a sign-in lasts by default
+const DefaultSessionTTL = 24 * time.Hour
+
+// Cookie names
+const (
+	sessionCookie = "museweb_session"
+	loginCookie   = "museweb_login" // The state of a sign-in in progress
+)
+
+// clockSkew is how far the provider's clock may be off
+const clockSkew = time.Minute
+
+// Options configure an OIDC login
+type Options struct {
+	// Issuer is the provider's issuer URL, whose
+	// /.well-known/openid-configuration describes it
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is where the provider sends users back, such as
+	// "https://example.com/auth/callback"; its path is served by Register
+	RedirectURL string
+	// Scopes are requested with "openid" (default "profile" and "email")
+	Scopes []string
+	// Claims are the ID token claims kept for prompts (DefaultClaims when empty)
+	Claims []string
+	// SessionSecret signs the cookies; with none, a random secret is used and
+	// users sign in again after a restart
+	SessionSecret string
+	// SessionTTL is how long a sign-in lasts (DefaultSessionTTL when 0)
+	SessionTTL time.Duration
+	// Client makes the requests to the provider (http.DefaultClient when nil)
+	Client *http.Client
+}
+
+// OIDC signs users in with an OpenID Connect provider
+type OIDC struct {
+	opts     Options
+	issuer   string
+	authURL  string
+	tokenURL string
+	jwksURL  string
+	callback string // Path of the redirect URL
+	secure   bool   // Whether cookies need HTTPS
+	signer   signer
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey // By key ID
+	fetched time.Time                   // When the keys were last fetched
+}
+
+// NewOIDC reads the provider's configuration from its discovery document
+func NewOIDC(ctx context.Context, opts Options) (*OIDC, error) {
+	if opts.Issuer == "" || opts.ClientID == "" || opts.RedirectURL == "" {
+		return nil, errors.New("oidc needs an issuer, a client_id, and a redirect_url")
+	}
+	redirect, err := url.Parse(opts.RedirectURL)
+	if err != nil || !redirect.IsAbs() || redirect.Path == "" {
+		return nil, fmt.Errorf("invalid redirect_url %q: must be an absolute URL", opts.RedirectURL)
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if len(opts.Scopes) == 0 {
+		opts.Scopes = []string{"profile", "email"}
+	}
+	if len(opts.Claims) == 0 {
+		opts.Claims = DefaultClaims
+	}
+	if opts.SessionTTL <= 0 {
+		opts.SessionTTL = DefaultSessionTTL
+	}
+	key := []byte(opts.SessionSecret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		rand.Read(key)
+		log.Printf("⚠️  No oidc.session_secret set: sign-ins end when MuseWeb restarts")
+	}
+
+	var discovery struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := getJSON(ctx, opts.Client, strings.TrimSuffix(opts.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("discovering the OIDC provider: %w", err)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("the OIDC provider's discovery document lacks endpoints")
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != strings.TrimSuffix(opts.Issuer, "/") {
+		return nil, fmt.Errorf("the OIDC provider calls itself %q, not %q", discovery.Issuer, opts.Issuer)
+	}
+	return &OIDC{
+		opts:     opts,
+		issuer:   discovery.Issuer,
+		authURL:  discovery.AuthorizationEndpoint,
+		tokenURL: discovery.TokenEndpoint,
+		jwksURL:  discovery.JWKSURI,
+		callback: redirect.Path,
+		secure:   redirect.Scheme == "https",
+		signer:   signer{key: key},
+	}, nil
+}
+
+// Register adds the sign-in endpoints to mux: /auth/login?next=/page,
+// /auth/logout, and the path of the redirect URL
+func (o *OIDC) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /auth/login", o.handleLogin)
+	mux.HandleFunc("GET "+o.callback, o.handleCallback)
+	mux.HandleFunc("/auth/logout", o.handleLogout)
+}
+
+// LoginURL returns the URL that signs the user in and brings them back to next
+func (o *OIDC) LoginURL(next string) string {
+	return "/auth/login?next=" + url.QueryEscape(next)
+}
+
+// User returns the signed-in user of r, or nil
+func (o *OIDC) User(r *http.Request) *User {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	var u User
+	if o.signer.open(sessionCookie, c.Value, &u) != nil || expired(u.Expires) {
+		return nil
+	}
+	return &u
+}
+
+// loginState is kept in a cookie between sending the user to the provider
+// and the provider sending them back
+type loginState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"` // PKCE code verifier
+	Next     string `json:"next"`
+	Expires  int64  `json:"exp"`
+}
+
+// handleLogin sends the user to the provider
+func (o *OIDC) handleLogin(w http.ResponseWriter, r *http.Request) {
+	pending := loginState{
+		State:    randomString(),
+		Nonce:    randomString(),
+		Verifier: randomString(),
+		Next:     localPath(r.URL.Query().Get("next")),
+		Expires:  time.Now().Add(10 * time.Minute).Unix(),
+	}
+	value, err := o.signer.sign(loginCookie, pending)
+	if err != nil {
+		http.Error(w, "Could not start signing in", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, o.cookie(loginCookie, value, o.callback, 10*time.Minute))
+
+	challenge := sha256.Sum256([]byte(pending.Verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {o.opts.ClientID},
+		"redirect_uri":          {o.opts.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, o.opts.Scopes...), " ")},
+		"state":                 {pending.State},
+		"nonce":                 {pending.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(o.authURL, "?") {
+		sep = "&"
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, o.authURL+sep+q.Encode(), http.StatusFound)
+}
+
+// handleCallback finishes signing in when the provider sends the user back
+func (o *OIDC) handleCallback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		log.Printf("🔑 Sign-in refused by the provider: %s %s", e, q.Get("error_description"))
+		http.Error(w, "Signing in failed: "+e, http.StatusUnauthorized)
+		return
+	}
+	var pending loginState
+	c, err := r.Cookie(loginCookie)
+	if err == nil {
+		err = o.signer.open(loginCookie, c.Value, &pending)
+	}
+	if err != nil || expired(pending.Expires) || q.Get("state") == "" || q.Get("state") != pending.State {
+		http.Error(w, "Signing in failed: the sign-in expired or did not start here. Please try again.", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, o.cookie(loginCookie, "", o.callback, -1))
+
+	idToken, err := o.exchange(r.Context(), q.Get("code"), pending.Verifier)
+	if err == nil {
+		var u *User
+		if u, err = o.verify(r.Context(), idToken, pending.Nonce); err == nil {
+			err = o.startSession(w, u)
+		}
+	}
+	if err != nil {
+		log.Printf("⚠️  Sign-in failed: %v", err)
+		http.Error(w, "Signing in failed", http.StatusUnauthorized)
+		return
+	}
+	next := pending.Next
+	if next == "" {
+		next = "/"
+	}
+	http.Redirect(w, r, next, http.StatusFound)
+}
+
+// handleLogout ends the session and returns to ?next= or the home page
+func (o *OIDC) handleLogout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, o.cookie(sessionCookie, "", "/", -1))
+	next := localPath(r.URL.Query().Get("next"))
+	if next == "" {
+		next = "/"
+	}
+	http.Redirect(w, r, next, http.StatusFound)
+}
+
+// startSession sets the session cookie of u
+func (o *OIDC) startSession(w http.ResponseWriter, u *User) error {
+	u.Expires = time.Now().Add(o.opts.SessionTTL).Unix()
+	value, err := o.signer.sign(sessionCookie, u)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, o.cookie(sessionCookie, value, "/", o.opts.SessionTTL))
+	return nil
+}
+
+// cookie returns a cookie for path; a negative maxAge deletes it
+func (o *OIDC) cookie(name, value, path string, maxAge time.Duration) *http.Cookie {
+	c := &http.Cookie{Name: name, Value: value, Path: path, HttpOnly: true, Secure: o.secure, SameSite: http.SameSiteLaxMode}
+	if maxAge < 0 {
+		c.MaxAge = -1
+	} else {
+		c.MaxAge = int(maxAge.Seconds())
+	}
+	return c
+}
+
+// exchange trades the authorization code for the ID token
+func (o *OIDC) exchange(ctx context.Context, code, verifier string) (string, error) {
+	if code == "" {
+		return "", errors.New("no authorization code")
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.opts.RedirectURL},
+		"client_id":     {o.opts.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if o.opts.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(o.opts.ClientID), url.QueryEscape(o.opts.ClientSecret))
+	}
+	resp, err := o.opts.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request: %w", err)
+	}
+	defer resp.Body.Close()
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokens); err != nil {
+		return "", fmt.Errorf("token response (status %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.Error != "" {
+		return "", fmt.Errorf("token request refused (status %d): %s %s", resp.StatusCode, tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.IDToken == "" {
+		return "", errors.New("the provider sent no ID token")
+	}
+	return tokens.IDToken, nil
+}
+
+// verify checks the ID token's signature, issuer, audience, lifetime, and
+// nonce, and returns its user
+func (o *OIDC) verify(ctx context.Context, token, nonce string) (*User, error) {
+	header, claims, signed, sig, err := parseJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	key, err := o.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, signed, sig); err != nil {
+		return nil, fmt.Errorf("ID token signature: %w", err)
+	}
+
+	if iss, _ := claims["iss"].(string); iss != o.issuer {
+		return nil, fmt.Errorf("ID token from issuer %q", iss)
+	}
+	if !audienceIncludes(claims["aud"], o.opts.ClientID) {
+		return nil, errors.New("ID token is for another client")
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != o.opts.ClientID {
+		return nil, errors.New("ID token was issued to another client")
+	}
+	now := time.Now()
+	exp, ok := numericClaim(claims["exp"])
+	if !ok || now.After(time.Unix(exp, 0).Add(clockSkew)) {
+		return nil, errors.New("ID token expired")
+	}
+	if iat, ok := numericClaim(claims["iat"]); ok && time.Unix(iat, 0).After(now.Add(clockSkew)) {
+		return nil, errors.New("ID token issued in the future")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, errors.New("ID token nonce does not match")
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+
+	u := &User{Subject: sub, Claims: map[string]string{}}
+	for _, name := range o.opts.Claims {
+		if v := claimString(claims[name]); v != "" {
+			u.Claims[name] = v
+		}
+	}
+	return u, nil
+}
+
+// key returns the provider's signing key with the ID kid, fetching the key
+// set again (at most once a minute) when the provider rotated its keys
+func (o *OIDC) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if key, ok := o.lookup(kid); ok {
+		return key, nil
+	}
+	if time.Since(o.fetched) < time.Minute {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	o.fetched = time.Now()
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := getJSON(ctx, o.opts.Client, o.jwksURL, &set); err != nil {
+		return nil, fmt.Errorf("fetching the provider's keys: %w", err)
+	}
+	o.keys = make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue // Keys of other types are not for us
+		}
+		o.keys[k.Kid] = key
+	}
+	if key, ok := o.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookup finds a key by ID; a token without one may use the only key there
+// is. Callers hold o.mu.
+func (o *OIDC) lookup(kid string) (crypto.PublicKey, bool) {
+	if key, ok := o.keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(o.keys) == 1 {
+		for _, key := range o.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// getJSON fetches a JSON document into v
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// audienceIncludes reports whether an aud claim (a string or a list) names
+// the client
+func audienceIncludes(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// numericClaim returns a claim holding seconds since the epoch
+func numericClaim(v interface{}) (int64, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	if i, err := n.Int64(); err == nil {
+		return i, true
+	}
+	f, err := n.Float64()
+	return int64(f), err == nil
+}
+
+// claimString turns a claim into text for prompts: lists are joined with
+// commas, and objects are left out
+func claimString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return fmt.Sprint(v)
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			if s := claimString(item); s != "" {
+				parts = append(parts, s)
+			}
+		}
+		return strings.Join(parts, ", ")
+	}
+	return ""
+}
+
+// randomString returns 32 random bytes, base64url-encoded
+func randomString() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// localPath returns next when it is a path on this site, so sign-ins cannot
+// be used to send users elsewhere, and "" otherwise
+func localPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return ""
+	}
+	return next
+}
@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// provider is a fake OpenID Connect provider issuing RS256 ID tokens
+type provider struct {
+	*httptest.Server
+	key      *rsa.PrivateKey
+	claims   map[string]interface{} // Claims of the next ID token
+	verifier string                 // PKCE verifier the token request sent
+}
+
+func newProvider(t *testing.T) *provider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &provider{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1", "use": "sig", "alg": "RS256",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "site" || secret != "s3cret" || r.FormValue("code") != "good-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		p.verifier = r.FormValue("code_verifier")
+		json.NewEncoder(w).Encode(map[string]string{"id_token": p.sign(t, p.claims)})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// sign returns an ID token with claims
+func (p *provider) sign(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// signIn starts a sign-in for next and returns the login cookie and the
+// query sent to the provider
+func signIn(t *testing.T, mux *http.ServeMux, next string) (*http.Cookie, url.Values) {
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/login?next="+url.QueryEscape(next), nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login: %d", rec.Code)
+	}
+	to, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != loginCookie {
+		t.Fatalf("login cookies: %v", cookies)
+	}
+	return cookies[0], to.Query()
+}
+
+// callback finishes a sign-in
+func callback(mux *http.ServeMux, cookie *http.Cookie, query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/auth/callback?"+query, nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestOIDCSignIn(t *testing.T) {
+	p := newProvider(t)
+	o, err := NewOIDC(context.Background(), Options{
+		Issuer: p.URL, ClientID: "site", ClientSecret: "s3cret",
+		RedirectURL: "https://example.com/auth/callback", SessionSecret: "session-key",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	o.Register(mux)
+
+	cookie, q := signIn(t, mux, "/members?tab=1")
+	if q.Get("client_id") != "site" || q.Get("scope") != "openid profile email" || q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("authorization request: %v", q)
+	}
+	p.claims = map[string]interface{}{
+		"iss": p.URL, "aud": "site", "sub": "42", "nonce": q.Get("nonce"),
+		"exp": time.Now().Add(time.Hour).Unix(), "iat": time.Now().Unix(),
+		"name": "Ada Lovelace", "email": "ada@example.com", "groups": []string{"staff", "admins"},
+		"address": map[string]string{"country": "UK"},
+	}
+
+	rec := callback(mux, cookie, "code=good-code&state="+q.Get("state"))
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/members?tab=1" {
+		t.Fatalf("callback: %d to %q: %s", rec.Code, rec.Header().Get("Location"), rec.Body)
+	}
+	challenge := sha256.Sum256([]byte(p.verifier))
+	if base64.RawURLEncoding.EncodeToString(challenge[:]) != q.Get("code_challenge") {
+		t.Error("the token request's code_verifier does not match the challenge")
+	}
+
+	var session *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sessionCookie {
+			session = c
+		}
+	}
+	if session == nil || !session.HttpOnly || !session.Secure {
+		t.Fatalf("session cookie: %+v", session)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/members", nil)
+	req.AddCookie(session)
+	u := o.User(req)
+	if u == nil {
+		t.Fatal("not signed in")
+	}
+	if u.Name() != "Ada Lovelace" || u.Claim("email") != "ada@example.com" || u.Claim("groups") != "staff, admins" || u.Claim("sub") != "42" {
+		t.Errorf("user: %+v", u)
+	}
+	if _, ok := u.Claims["address"]; ok {
+		t.Error("kept a claim that was not configured")
+	}
+
+	// Cookies cannot be edited
+	forged := *session
+	forged.Value = strings.Replace(forged.Value, ".", "x.", 1)
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&forged)
+	if o.User(req) != nil {
+		t.Error("accepted a forged session")
+	}
+}
+
+func TestOIDCRejectsBadSignIns(t *testing.T) {
+	p := newProvider(t)
+	o, err := NewOIDC(context.Background(), Options{
+		Issuer: p.URL, ClientID: "site", ClientSecret: "s3cret", RedirectURL: "http://localhost:8000/auth/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	o.Register(mux)
+
+	for name, tc := range map[string]struct {
+		claims func(nonce string) map[string]interface{}
+		query  func(state string) string
+		want   int
+	}{
+		"wrong state": {query: func(string) string { return "code=good-code&state=other" }, want: http.StatusBadRequest},
+		"bad code":    {query: func(s string) string { return "code=bad&state=" + s }, want: http.StatusUnauthorized},
+		"wrong nonce": {claims: func(string) map[string]interface{} {
+			return map[string]interface{}{"iss": p.URL, "aud": "site", "sub": "1", "nonce": "replayed", "exp": time.Now().Add(time.Hour).Unix()}
+		}, want: http.StatusUnauthorized},
+		"other client": {claims: func(n string) map[string]interface{} {
+			return map[string]interface{}{"iss": p.URL, "aud": "another", "sub": "1", "nonce": n, "exp": time.Now().Add(time.Hour).Unix()}
+		}, want: http.StatusUnauthorized},
+		"expired": {claims: func(n string) map[string]interface{} {
+			return map[string]interface{}{"iss": p.URL, "aud": []string{"site"}, "sub": "1", "nonce": n, "exp": time.Now().Add(-time.Hour).Unix()}
+		}, want: http.StatusUnauthorized},
+	} {
+		cookie, q := signIn(t, mux, "https://evil.example/")
+		p.claims = nil
+		if tc.claims != nil {
+			p.claims = tc.claims(q.Get("nonce"))
+		}
+		query := "code=good-code&state=" + q.Get("state")
+		if tc.query != nil {
+			query = tc.query(q.Get("state"))
+		}
+		if rec := callback(mux, cookie, query); rec.Code != tc.want {
+			t.Errorf("%s: %d, want %d", name, rec.Code, tc.want)
+		}
+	}
+
+	// Sign-ins only ever return to this site
+	cookie, q := signIn(t, mux, "//evil.example/")
+	p.claims = map[string]interface{}{"iss": p.URL, "aud": "site", "sub": "1", "nonce": q.Get("nonce"), "exp": time.Now().Add(time.Hour).Unix()}
+	if rec := callback(mux, cookie, "code=good-code&state="+q.Get("state")); rec.Header().Get("Location") != "/" {
+		t.Errorf("redirected to %q after signing in", rec.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// User is a signed-in visitor
+type User struct {
+	Subject string            `json:"sub"`
+	Claims  map[string]string `json:"claims"` // Selected ID token claims
+	Expires int64             `json:"exp"`    // Unix time the session ends
+}
+
+// Name returns what to call the user: the name claim, or the user name or
+// email address when the provider sent no name
+func (u *User) Name() string {
+	for _, claim := range []string{"name", "preferred_username", "nickname", "email"} {
+		if v := u.Claims[claim]; v != "" {
+			return v
+		}
+	}
+	return u.Subject
+}
+
+// Claim returns a claim of the user's ID token: "name" as Name does, "sub"
+// the subject, and other claims as kept in the session ("" when missing)
+func (u *User) Claim(name string) string {
+	switch name {
+	case "name":
+		return u.Name()
+	case "sub":
+		return u.Subject
+	}
+	return u.Claims[name]
+}
+
+// signer signs and checks cookie values, so visitors cannot forge or edit
+// their session
+type signer struct {
+	key []byte
+}
+
+// errBadCookie is returned for cookies that were not made by this signer
+var errBadCookie = errors.New("invalid or forged cookie")
+
+// sign encodes v and signs it for purpose; purpose keeps a value made for
+// one cookie from being accepted as another
+func (s signer) sign(purpose string, v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + s.mac(purpose, payload), nil
+}
+
+// open checks a signed value and decodes it into v
+func (s signer) open(purpose, value string, v interface{}) error {
+	payload, mac, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(s.mac(purpose, payload))) {
+		return errBadCookie
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return errBadCookie
+	}
+	return json.Unmarshal(data, v)
+}
+
+// mac returns the signature of payload for purpose
+func (s signer) mac(purpose, payload string) string {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(purpose))
+	h.Write([]byte{0})
+	h.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// expired reports whether a Unix time is past
+func expired(unix int64) bool {
+	return time.Now().Unix() >= unix
+}
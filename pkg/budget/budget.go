@@ -0,0 +1,279 @@
+// Package budget caps what MuseWeb spends on model calls. Token and cost
+// limits apply per UTC day and month, globally and per site (the request
+// host), so a popular page or a crawler cannot run up the API bill.
+package budget
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kekePower/museweb/pkg/store"
+)
+
+// ErrExhausted is returned by Check once a budget has been used up
+var ErrExhausted = errors.New("budget exhausted")
+
+// Limits caps spending for one scope; zero fields are unlimited
+type Limits struct {
+	DailyTokens   int64   `json:"daily_tokens,omitempty"`
+	MonthlyTokens int64   `json:"monthly_tokens,omitempty"`
+	DailyCost     float64 `json:"daily_cost,omitempty"`
+	MonthlyCost   float64 `json:"monthly_cost,omitempty"`
+}
+
+// IsZero reports whether no limit is set
+func (l Limits) IsZero() bool {
+	return l == Limits{}
+}
+
+// Pricing converts tokens to cost, per 1000 tokens
+type Pricing struct {
+	InputPer1K  float64
+	OutputPer1K float64
+}
+
+// Cost returns what the tokens cost
+func (p Pricing) Cost(promptTokens, completionTokens int) float64 {
+	return float64(promptTokens)/1000*p.InputPer1K + float64(completionTokens)/1000*p.OutputPer1K
+}
+
+// priceOf returns the prices of model, or fallback for models without their own
+func priceOf(prices map[string]Pricing, fallback Pricing, model string) Pricing {
+	if p, ok := prices[model]; ok {
+		return p
+	}
+	return fallback
+}
+
+// Spend is the usage of one scope in one period
+type Spend struct {
+	Tokens int64   `json:"tokens"`
+	Cost   float64 `json:"cost"`
+}
+
+// Status is the current usage and limits of one scope
+type Status struct {
+	Scope  string `json:"scope"` // "global" or the site host
+	Day    Spend  `json:"day"`
+	Month  Spend  `json:"month"`
+	Limits Limits `json:"limits"`
+}
+
+// global is the scope key for the global budget
+const global = ""
+
+// counter identifies the usage of one scope in one period ("2006-01-02" or "2006-01")
+type counter struct {
+	scope  string
+	period string
+}
+
+// Tracker records usage and enforces limits
+type Tracker struct {
+	global  Limits
+	sites   map[string]Limits
+	pricing Pricing
+	prices  map[string]Pricing // By model name, overriding pricing
+	db      *store.DB          // Optional; usage survives restarts when set
+
+	mu    sync.Mutex
+	spent map[counter]Spend
+	now   func() time.Time
+}
+
+// migrations creates the usage table
+var migrations = []store.Migration{
+	{Version: 1, Name: "create budget_usage", SQL: `
+		CREATE TABLE budget_usage (
+			scope  TEXT    NOT NULL,
+			period TEXT    NOT NULL,
+			tokens INTEGER NOT NULL DEFAULT 0,
+			cost   REAL    NOT NULL DEFAULT 0,
+			PRIMARY KEY (scope, period)
+		);`},
+}
+
+// New creates a tracker. Site keys are host names without a port. When db is
+// set, usage is persisted there and the current periods are loaded from it.
+func New(globalLimits Limits, sites map[string]Limits, pricing Pricing, db *store.DB) (*Tracker, error) {
+	t := &Tracker{
+		global:  globalLimits,
+		sites:   make(map[string]Limits, len(sites)),
+		pricing: pricing,
+		db:      db,
+		spent:   make(map[counter]Spend),
+		now:     time.Now,
+	}
+	for site, limits := range sites {
+		t.sites[SiteKey(site)] = limits
+	}
+	if db == nil {
+		return t, nil
+	}
+
+	if err := db.Migrate("budget", migrations); err != nil {
+		return nil, err
+	}
+	day, month := t.periods()
+	rows, err := db.Query(`SELECT scope, period, tokens, cost FROM budget_usage WHERE period IN (?, ?)`, day, month)
+	if err != nil {
+		return nil, fmt.Errorf("loading budget usage: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c counter
+		var s Spend
+		if err := rows.Scan(&c.scope, &c.period, &s.Tokens, &s.Cost); err != nil {
+			return nil, err
+		}
+		t.spent[c] = s
+	}
+	return t, rows.Err()
+}
+
+// SiteKey normalizes a request host to the key used for per-site limits
+func SiteKey(host string) string {
+	host = strings.ToLower(host)
+	if i := strings.LastIndexByte(host, ':'); i != -1 && !strings.HasSuffix(host, "]") {
+		host = host[:i]
+	}
+	return strings.Trim(host, "[]")
+}
+
+// periods returns the current day and month keys
+func (t *Tracker) periods() (day, month string) {
+	now := t.now().UTC()
+	return now.Format("2006-01-02"), now.Format("2006-01")
+}
+
+// scopes returns the scopes a generation for site counts against. Only sites
+// with configured limits are tracked, so arbitrary Host headers can't grow the map.
+func (t *Tracker) scopes(site string) []string {
+	if _, ok := t.sites[SiteKey(site)]; ok && site != "" {
+		return []string{global, SiteKey(site)}
+	}
+	return []string{global}
+}
+
+// limits returns the limits of scope
+func (t *Tracker) limits(scope string) Limits {
+	if scope == global {
+		return t.global
+	}
+	return t.sites[scope]
+}
+
+// scopeName returns the display name of scope
+func scopeName(scope string) string {
+	if scope == global {
+		return "global"
+	}
+	return scope
+}
+
+// Check returns an error wrapping ErrExhausted when the global budget or the
+// budget of site has been used up
+func (t *Tracker) Check(site string) error {
+	day, month := t.periods()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, scope := range t.scopes(site) {
+		l := t.limits(scope)
+		d, m := t.spent[counter{scope, day}], t.spent[counter{scope, month}]
+		switch {
+		case l.DailyTokens > 0 && d.Tokens >= l.DailyTokens,
+			l.DailyCost > 0 && d.Cost >= l.DailyCost:
+			return fmt.Errorf("%w: %s daily limit reached", ErrExhausted, scopeName(scope))
+		case l.MonthlyTokens > 0 && m.Tokens >= l.MonthlyTokens,
+			l.MonthlyCost > 0 && m.Cost >= l.MonthlyCost:
+			return fmt.Errorf("%w: %s monthly limit reached", ErrExhausted, scopeName(scope))
+		}
+	}
+	return nil
+}
+
+// SetModelPrices prices the tokens of the given models (by name) on their
+// own instead of with the tracker's pricing. Call it before recording.
+func (t *Tracker) SetModelPrices(prices map[string]Pricing) {
+	t.prices = prices
+}
+
+// Record adds the tokens of one generation to the budgets of site
+func (t *Tracker) Record(site string, promptTokens, completionTokens int) error {
+	return t.RecordModel(site, "", promptTokens, completionTokens)
+}
+
+// RecordModel adds the tokens of one generation by model to the budgets of
+// site, at the model's prices
+func (t *Tracker) RecordModel(site, model string, promptTokens, completionTokens int) error {
+	add := Spend{
+		Tokens: int64(promptTokens + completionTokens),
+		Cost:   priceOf(t.prices, t.pricing, model).Cost(promptTokens, completionTokens),
+	}
+	if add.Tokens == 0 {
+		return nil
+	}
+	day, month := t.periods()
+	scopes := t.scopes(site)
+
+	t.mu.Lock()
+	for c := range t.spent {
+		// Forget finished periods
+		if c.period != day && c.period != month {
+			delete(t.spent, c)
+		}
+	}
+	for _, scope := range scopes {
+		for _, period := range []string{day, month} {
+			c := counter{scope, period}
+			s := t.spent[c]
+			s.Tokens += add.Tokens
+			s.Cost += add.Cost
+			t.spent[c] = s
+		}
+	}
+	t.mu.Unlock()
+
+	if t.db == nil {
+		return nil
+	}
+	for _, scope := range scopes {
+		for _, period := range []string{day, month} {
+			_, err := t.db.Exec(`INSERT INTO budget_usage (scope, period, tokens, cost) VALUES (?, ?, ?, ?)
+				ON CONFLICT (scope, period) DO UPDATE SET tokens = tokens + excluded.tokens, cost = cost + excluded.cost`,
+				scope, period, add.Tokens, add.Cost)
+			if err != nil {
+				return fmt.Errorf("saving budget usage: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// Status returns the usage of the global budget followed by every site with limits
+func (t *Tracker) Status() []Status {
+	day, month := t.periods()
+	scopes := []string{global}
+	for site := range t.sites {
+		scopes = append(scopes, site)
+	}
+	sort.Strings(scopes[1:])
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	statuses := make([]Status, 0, len(scopes))
+	for _, scope := range scopes {
+		statuses = append(statuses, Status{
+			Scope:  scopeName(scope),
+			Day:    t.spent[counter{scope, day}],
+			Month:  t.spent[counter{scope, month}],
+			Limits: t.limits(scope),
+		})
+	}
+	return statuses
+}
@@ -0,0 +1,166 @@
+package budget
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kekePower/museweb/pkg/store"
+)
+
+// newTestTracker creates a tracker without a database whose clock reads *now
+func newTestTracker(t *testing.T, globalLimits Limits, sites map[string]Limits, now *time.Time) *Tracker {
+	t.Helper()
+	tr, err := New(globalLimits, sites, Pricing{InputPer1K: 1, OutputPer1K: 2}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.now = func() time.Time { return *now }
+	return tr
+}
+
+// checkBudget fails t unless Check(site) reports want, a part of the error
+// ("" for no error)
+func checkBudget(t *testing.T, tr *Tracker, site, want string) {
+	t.Helper()
+	err := tr.Check(site)
+	switch {
+	case want == "" && err != nil:
+		t.Errorf("%s: Check(%q) = %v, want no error", tr.now().UTC().Format(time.RFC3339), site, err)
+	case want != "" && (!errors.Is(err, ErrExhausted) || !strings.Contains(err.Error(), want)):
+		t.Errorf("%s: Check(%q) = %v, want %q", tr.now().UTC().Format(time.RFC3339), site, err, want)
+	}
+}
+
+func TestPeriodRollover(t *testing.T) {
+	now := time.Date(2025, 1, 30, 23, 59, 0, 0, time.UTC)
+	tr := newTestTracker(t, Limits{DailyTokens: 200, MonthlyTokens: 250}, nil, &now)
+
+	tr.Record("", 150, 50)
+	checkBudget(t, tr, "", "global daily limit reached")
+
+	now = time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)
+	checkBudget(t, tr, "", "")
+	if st := tr.Status()[0]; st.Day.Tokens != 0 || st.Month.Tokens != 200 {
+		t.Errorf("next day: day %d, month %d tokens", st.Day.Tokens, st.Month.Tokens)
+	}
+
+	tr.Record("", 40, 0)
+	checkBudget(t, tr, "", "")
+	now = time.Date(2025, 1, 31, 22, 0, 0, 0, time.UTC)
+	tr.Record("", 10, 0)
+	checkBudget(t, tr, "", "global monthly limit reached")
+
+	// Still January in UTC, though February where the clock is
+	now = time.Date(2025, 2, 1, 1, 30, 0, 0, time.FixedZone("EET", 2*60*60))
+	checkBudget(t, tr, "", "global monthly limit reached")
+
+	now = time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	checkBudget(t, tr, "", "")
+	tr.Record("", 10, 0)
+	if st := tr.Status()[0]; st.Day.Tokens != 10 || st.Month.Tokens != 10 {
+		t.Errorf("next month: day %d, month %d tokens", st.Day.Tokens, st.Month.Tokens)
+	}
+	if len(tr.spent) != 2 {
+		t.Errorf("%d counters kept after the rollover, want the current day and month", len(tr.spent))
+	}
+
+	// The year end rolls the month like any other
+	now = time.Date(2025, 12, 31, 23, 0, 0, 0, time.UTC)
+	tr.Record("", 250, 0)
+	checkBudget(t, tr, "", "global daily limit reached")
+	now = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	checkBudget(t, tr, "", "")
+}
+
+func TestSiteLimits(t *testing.T) {
+	now := time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+	tr := newTestTracker(t, Limits{DailyTokens: 1000}, map[string]Limits{
+		"A.example":  {DailyTokens: 100},
+		"b.example":  {MonthlyTokens: 5000},
+		"[::1]:8080": {DailyTokens: 10},
+	}, &now)
+
+	tr.Record("a.example:8080", 100, 0)
+	checkBudget(t, tr, "a.example", "a.example daily limit reached")
+	checkBudget(t, tr, "A.EXAMPLE:443", "a.example daily limit reached")
+	checkBudget(t, tr, "b.example", "")
+	checkBudget(t, tr, "unknown.example", "")
+	checkBudget(t, tr, "", "")
+
+	tr.Record("[::1]:8080", 10, 0)
+	checkBudget(t, tr, "[::1]", "::1 daily limit reached")
+
+	// Hosts without limits count against the global budget only, and the
+	// global budget stops every site
+	tr.Record("unknown.example", 890, 0)
+	for _, site := range []string{"", "a.example", "b.example", "unknown.example"} {
+		checkBudget(t, tr, site, "global daily limit reached")
+	}
+
+	want := map[string]int64{"global": 1000, "a.example": 100, "b.example": 0, "::1": 10}
+	statuses := tr.Status()
+	if len(statuses) != len(want) || statuses[0].Scope != "global" {
+		t.Fatalf("status %+v", statuses)
+	}
+	for _, st := range statuses {
+		if st.Day.Tokens != want[st.Scope] || st.Month.Tokens != want[st.Scope] {
+			t.Errorf("%s: day %d, month %d tokens, want %d", st.Scope, st.Day.Tokens, st.Month.Tokens, want[st.Scope])
+		}
+	}
+	if _, ok := tr.spent[counter{"unknown.example", "2025-06-15"}]; ok {
+		t.Error("a host without limits was tracked")
+	}
+}
+
+func TestCostLimits(t *testing.T) {
+	now := time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+	tr := newTestTracker(t, Limits{DailyCost: 5}, nil, &now)
+	tr.SetModelPrices(map[string]Pricing{"cheap": {InputPer1K: 0.1, OutputPer1K: 0.1}})
+
+	tr.RecordModel("", "cheap", 10000, 10000)
+	checkBudget(t, tr, "", "")
+	tr.Record("", 0, 0)
+	if n := len(tr.spent); n != 2 {
+		t.Errorf("recording nothing left %d counters", n)
+	}
+	tr.RecordModel("", "unpriced", 1000, 1000)
+	checkBudget(t, tr, "", "global daily limit reached")
+	if cost := tr.Status()[0].Day.Cost; math.Abs(cost-5) > 1e-9 {
+		t.Errorf("spent %v, want 5", cost)
+	}
+}
+
+func TestTrackerPersistence(t *testing.T) {
+	db, err := store.Open(filepath.Join(t.TempDir(), "museweb.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	sites := map[string]Limits{"a.example": {DailyTokens: 100}}
+	tr, err := New(Limits{}, sites, Pricing{}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Record("a.example", 60, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Record("a.example", 40, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, err := New(Limits{}, sites, Pricing{}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.Check("a.example"); !errors.Is(err, ErrExhausted) {
+		t.Errorf("after a restart: %v", err)
+	}
+	if st := restarted.Status(); st[0].Day.Tokens != 100 || st[1].Month.Tokens != 100 {
+		t.Errorf("after a restart: %+v", st)
+	}
+}
@@ -0,0 +1,219 @@
+package budget
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kekePower/museweb/pkg/store"
+)
+
+// DefaultKeepDays is how long a Ledger keeps daily totals by default
+const DefaultKeepDays = 90
+
+// Usage is the token usage and cost of one day, route, and model, or a sum
+// of them (with the fields summed over left empty)
+type Usage struct {
+	Day              string  `json:"day,omitempty"`
+	Route            string  `json:"route,omitempty"`
+	Model            string  `json:"model,omitempty"`
+	Generations      int64   `json:"generations"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// add sums u into the receiver
+func (s *Usage) add(u Usage) {
+	s.Generations += u.Generations
+	s.PromptTokens += u.PromptTokens
+	s.CompletionTokens += u.CompletionTokens
+	s.Cost += u.Cost
+}
+
+// Report sums the usage of a range of days
+type Report struct {
+	From   string  `json:"from"`
+	To     string  `json:"to"`
+	Total  Usage   `json:"total"`
+	Days   []Usage `json:"days"`   // Per day, oldest first
+	Routes []Usage `json:"routes"` // Per route, most expensive first
+	Models []Usage `json:"models"` // Per model, most expensive first
+}
+
+// ledgerKey identifies the usage of one route and model on one UTC day
+type ledgerKey struct {
+	day, route, model string
+}
+
+// Ledger accounts the tokens and cost of every generation per UTC day,
+// route, and model. Unlike a Tracker it limits nothing; it answers where the
+// tokens went.
+type Ledger struct {
+	pricing Pricing            // Prices of models without their own
+	prices  map[string]Pricing // By model name
+	keep    int                // Days of totals kept
+	db      *store.DB          // Optional; totals survive restarts when set
+
+	mu     sync.Mutex
+	totals map[ledgerKey]Usage
+	pruned string // Day the old totals were last dropped
+	now    func() time.Time
+}
+
+// ledgerMigrations creates the usage table
+var ledgerMigrations = []store.Migration{
+	{Version: 1, Name: "create usage_totals", SQL: `
+		CREATE TABLE usage_totals (
+			day               TEXT    NOT NULL,
+			route             TEXT    NOT NULL,
+			model             TEXT    NOT NULL,
+			generations       INTEGER NOT NULL DEFAULT 0,
+			prompt_tokens     INTEGER NOT NULL DEFAULT 0,
+			completion_tokens INTEGER NOT NULL DEFAULT 0,
+			cost              REAL    NOT NULL DEFAULT 0,
+			PRIMARY KEY (day, route, model)
+		);`},
+}
+
+// NewLedger creates a ledger keeping keepDays days of totals
+// (DefaultKeepDays when 0). Costs use the prices of the model, or pricing
+// for models without their own. When db is set, totals are persisted there
+// and loaded from it.
+func NewLedger(pricing Pricing, prices map[string]Pricing, keepDays int, db *store.DB) (*Ledger, error) {
+	if keepDays <= 0 {
+		keepDays = DefaultKeepDays
+	}
+	l := &Ledger{pricing: pricing, prices: prices, keep: keepDays, db: db, totals: make(map[ledgerKey]Usage), now: time.Now}
+	if db == nil {
+		return l, nil
+	}
+
+	if err := db.Migrate("usage", ledgerMigrations); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT day, route, model, generations, prompt_tokens, completion_tokens, cost
+		FROM usage_totals WHERE day >= ?`, l.oldestDay())
+	if err != nil {
+		return nil, fmt.Errorf("loading usage totals: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var u Usage
+		if err := rows.Scan(&u.Day, &u.Route, &u.Model, &u.Generations, &u.PromptTokens, &u.CompletionTokens, &u.Cost); err != nil {
+			return nil, err
+		}
+		l.totals[ledgerKey{u.Day, u.Route, u.Model}] = u
+	}
+	return l, rows.Err()
+}
+
+// today returns the current UTC day
+func (l *Ledger) today() string {
+	return l.now().UTC().Format("2006-01-02")
+}
+
+// oldestDay returns the first day still kept
+func (l *Ledger) oldestDay() string {
+	return l.now().UTC().AddDate(0, 0, 1-l.keep).Format("2006-01-02")
+}
+
+// Cost returns what the tokens cost with model
+func (l *Ledger) Cost(model string, promptTokens, completionTokens int) float64 {
+	return priceOf(l.prices, l.pricing, model).Cost(promptTokens, completionTokens)
+}
+
+// Record adds one generation of route by model and returns its cost
+func (l *Ledger) Record(route, model string, promptTokens, completionTokens int) (float64, error) {
+	cost := l.Cost(model, promptTokens, completionTokens)
+	add := Usage{Day: l.today(), Route: route, Model: model, Generations: 1,
+		PromptTokens: int64(promptTokens), CompletionTokens: int64(completionTokens), Cost: cost}
+	key := ledgerKey{add.Day, route, model}
+
+	l.mu.Lock()
+	u := l.totals[key]
+	if u.Day == "" {
+		u = Usage{Day: add.Day, Route: route, Model: model}
+	}
+	u.add(add)
+	l.totals[key] = u
+	prune := l.pruned != add.Day
+	oldest := l.oldestDay()
+	if prune {
+		l.pruned = add.Day
+		for k := range l.totals {
+			if k.day < oldest {
+				delete(l.totals, k)
+			}
+		}
+	}
+	l.mu.Unlock()
+
+	if l.db == nil {
+		return cost, nil
+	}
+	_, err := l.db.Exec(`INSERT INTO usage_totals (day, route, model, generations, prompt_tokens, completion_tokens, cost)
+		VALUES (?, ?, ?, 1, ?, ?, ?)
+		ON CONFLICT (day, route, model) DO UPDATE SET
+			generations = generations + 1,
+			prompt_tokens = prompt_tokens + excluded.prompt_tokens,
+			completion_tokens = completion_tokens + excluded.completion_tokens,
+			cost = cost + excluded.cost`,
+		add.Day, route, model, add.PromptTokens, add.CompletionTokens, cost)
+	if err != nil {
+		return cost, fmt.Errorf("saving usage totals: %w", err)
+	}
+	if prune {
+		if _, err := l.db.Exec(`DELETE FROM usage_totals WHERE day < ?`, oldest); err != nil {
+			return cost, fmt.Errorf("dropping old usage totals: %w", err)
+		}
+	}
+	return cost, nil
+}
+
+// Report sums the usage of the last days days, today included
+func (l *Ledger) Report(days int) Report {
+	if days <= 0 || days > l.keep {
+		days = l.keep
+	}
+	now := l.now().UTC()
+	r := Report{From: now.AddDate(0, 0, 1-days).Format("2006-01-02"), To: now.Format("2006-01-02")}
+
+	byDay, byRoute, byModel := map[string]Usage{}, map[string]Usage{}, map[string]Usage{}
+	l.mu.Lock()
+	for k, u := range l.totals {
+		if k.day < r.From || k.day > r.To {
+			continue
+		}
+		r.Total.add(u)
+		d, rt, m := byDay[k.day], byRoute[k.route], byModel[k.model]
+		d.Day, rt.Route, m.Model = k.day, k.route, k.model
+		d.add(u)
+		rt.add(u)
+		m.add(u)
+		byDay[k.day], byRoute[k.route], byModel[k.model] = d, rt, m
+	}
+	l.mu.Unlock()
+
+	r.Days = sortedUsage(byDay, func(a, b Usage) bool { return a.Day < b.Day })
+	mostExpensive := func(a, b Usage) bool {
+		if a.Cost != b.Cost {
+			return a.Cost > b.Cost
+		}
+		return a.PromptTokens+a.CompletionTokens > b.PromptTokens+b.CompletionTokens
+	}
+	r.Routes = sortedUsage(byRoute, mostExpensive)
+	r.Models = sortedUsage(byModel, mostExpensive)
+	return r
+}
+
+// sortedUsage returns the values of m in the order less defines
+func sortedUsage(m map[string]Usage, less func(a, b Usage) bool) []Usage {
+	list := make([]Usage, 0, len(m))
+	for _, u := range m {
+		list = append(list, u)
+	}
+	sort.SliceStable(list, func(i, j int) bool { return less(list[i], list[j]) })
+	return list
+}
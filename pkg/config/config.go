@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"strconv"
 
 	"gopkg.in/yaml.v3"
 )
@@ -9,25 +10,605 @@ import (
 // Config holds the application configuration
 type Config struct {
 	Server struct {
+		// Address is a host, or "unix:/path" for a Unix domain socket
 		Address    string `yaml:"address"`
 		Port       string `yaml:"port"`
 		PromptsDir string `yaml:"prompts_dir"`
 		Debug      bool   `yaml:"debug"`
+		// DevMode watches the prompts directory and live-reloads connected browsers
+		DevMode bool `yaml:"dev_mode"`
+		// EnableGraphQL exposes the /graphql endpoint for programmatic generation
+		EnableGraphQL bool `yaml:"enable_graphql"`
+		// EnablePprof exposes the Go profiler and /debug/stats, as debug mode does
+		EnablePprof bool `yaml:"enable_pprof"`
+		// RenderMode is "stream" (raw progressive HTML) or "morph" (client-side DOM morphing)
+		RenderMode string `yaml:"render_mode"`
+		// ClientBufferKB is how far the model may run ahead of a slow client (0 = unbuffered)
+		ClientBufferKB int `yaml:"client_buffer_kb"`
+		// StallTimeout drops clients that accept no data for this many seconds
+		StallTimeout int `yaml:"stall_timeout"`
+		// MaxGenerations caps the pages generated at once (0 = unlimited); up to
+		// QueueSize more requests wait at most QueueWait seconds for a slot
+		MaxGenerations int `yaml:"max_generations"`
+		QueueSize      int `yaml:"queue_size"`
+		QueueWait      int `yaml:"queue_wait"`
+		// BaseURL is the public site URL used for generated absolute URLs
+		BaseURL string `yaml:"base_url"`
+		// Metadata attaches generation metadata to pages: "comment", "trailers", "both", or ""
+		Metadata string `yaml:"metadata"`
+		// H2C accepts HTTP/2 without TLS (prior knowledge, as proxies send it)
+		// next to HTTP/1.1; HTTPS listeners always offer HTTP/2
+		H2C bool `yaml:"h2c"`
+		// TrustedProxies are the IPs and CIDR ranges of reverse proxies whose
+		// X-Forwarded-For and X-Real-IP headers name the visitor, for the
+		// access log, rate limits, IP filters, and prompts
+		TrustedProxies []string `yaml:"trusted_proxies"`
+		// AccessLog logs every request in the Combined Log Format to "stdout"
+		// or appends it to a file; empty disables it
+		AccessLog string `yaml:"access_log"`
+		// TLS serves HTTPS with the certificate in CertFile and its key in
+		// KeyFile, or with certificates obtained from Let's Encrypt for the
+		// Autocert domains; RedirectHTTP, when set, is an address whose plain
+		// HTTP listener redirects clients to HTTPS
+		TLS struct {
+			CertFile     string `yaml:"cert_file"`
+			KeyFile      string `yaml:"key_file"`
+			RedirectHTTP string `yaml:"redirect_http"`
+			Autocert     struct {
+				Domains []string `yaml:"domains"`
+				// CacheDir keeps the account key and certificates across restarts
+				CacheDir string `yaml:"cache_dir"`
+				// Email is where Let's Encrypt sends notices about the certificates
+				Email string `yaml:"email"`
+			} `yaml:"autocert"`
+		} `yaml:"tls"`
 	} `yaml:"server"`
 	Model struct {
 		Backend string `yaml:"backend"`
 		Name    string `yaml:"name"`
 		// ReasoningModels is a list of model name patterns that support reasoning/thinking tags
 		ReasoningModels []string `yaml:"reasoning_models"`
+		// AutoPull downloads the model at startup when the Ollama server lacks it
+		AutoPull bool `yaml:"auto_pull"`
+		// Overrides lists the models (or named backends) a request may pick with ?model=
+		Overrides []string `yaml:"overrides"`
+		// ResponseAdapter selects the stream parser for OpenAI-compatible APIs:
+		// "openai", "gemini", "perplexity", "raw", "auto", or "" to detect it
+		ResponseAdapter string `yaml:"response_adapter"`
+		// Reasoning controls how much reasoning models think before answering
+		Reasoning struct {
+			// Effort is "none", "minimal", "low", "medium", "high", or "" for the provider default
+			Effort string `yaml:"effort"`
+			// BudgetTokens caps thinking tokens where the provider supports it (0 = default)
+			BudgetTokens int `yaml:"budget_tokens"`
+		} `yaml:"reasoning"`
 	} `yaml:"model"`
+	// Backends are extra named backends that prompts can select in their front matter
+	Backends []NamedBackend `yaml:"backends"`
+	// Failover lists named backends to try when a page's backend fails before responding
+	Failover struct {
+		Backends []string `yaml:"backends"`
+		// FirstByteTimeout gives up on a backend after this many seconds without output (0 = never)
+		FirstByteTimeout int `yaml:"first_byte_timeout"`
+	} `yaml:"failover"`
+	// Capabilities describe models: context window, vision, tools, reasoning, price
+	Capabilities struct {
+		// Fetch asks Ollama and OpenRouter at startup what the configured models can do
+		Fetch bool `yaml:"fetch"`
+		// Models add to or correct the built-in descriptions; they win over fetched ones
+		Models []ModelCapabilities `yaml:"models"`
+	} `yaml:"capabilities"`
+	// Timeouts bound the requests to backends, in seconds (0 keeps the default)
+	Timeouts struct {
+		// Connect bounds connecting to the backend, TLS handshake included
+		Connect int `yaml:"connect"`
+		// FirstToken gives up on a backend that sends nothing for this long;
+		// it replaces failover.first_byte_timeout
+		FirstToken int `yaml:"first_token"`
+		// Total bounds a whole generation, streaming included
+		Total int `yaml:"total"`
+	} `yaml:"timeouts"`
+	// Routing sends pages without a backend of their own to named backends by size and complexity
+	Routing struct {
+		Rules []RoutingRule `yaml:"rules"`
+		// EscalateAfter skips a rule for a route after this many flawed pages in a row (0 = never)
+		EscalateAfter int `yaml:"escalate_after"`
+	} `yaml:"routing"`
+	// Retry retries rate limits and server errors before streaming starts
+	Retry struct {
+		// Attempts is the number of retries after the first try (0 = none)
+		Attempts int `yaml:"attempts"`
+		// BaseDelayMs is the first delay, doubled for each retry after it
+		BaseDelayMs int `yaml:"base_delay_ms"`
+		// MaxDelayMs caps a single delay
+		MaxDelayMs int `yaml:"max_delay_ms"`
+	} `yaml:"retry"`
+	// Shadow mirrors a sample of generations to a named backend for comparison
+	Shadow struct {
+		Backend string `yaml:"backend"`
+		// Sample is the share of generations mirrored, above 0 and at most 1
+		Sample float64 `yaml:"sample"`
+		// Dir, when set, keeps both outputs and their stats of every comparison
+		Dir string `yaml:"dir"`
+	} `yaml:"shadow"`
+	// Scrub masks personal data and unwanted terms in generated pages
+	Scrub struct {
+		Emails bool     `yaml:"emails"`
+		Phones bool     `yaml:"phones"`
+		Terms  []string `yaml:"terms"` // Whole words, case-insensitive
+		Allow  []string `yaml:"allow"` // Matches never masked, like the site's own address
+		Mask   string   `yaml:"mask"`
+	} `yaml:"scrub"`
+	// Input limits and cleans what visitors POST before it reaches a prompt
+	Input struct {
+		// MaxLength refuses longer input, in characters (0 = unlimited)
+		MaxLength int `yaml:"max_length"`
+		// StripControl removes control characters other than newlines and tabs
+		StripControl bool `yaml:"strip_control"`
+		// Deny refuses input matching any of these regular expressions
+		Deny []string `yaml:"deny"`
+		// Delimit wraps input in marked blocks the model is told to treat as data
+		Delimit bool `yaml:"delimit"`
+	} `yaml:"input"`
+	// Templates runs page prompts as Go text/template templates with the
+	// request's query parameters, path, headers, the time, and Vars
+	Templates struct {
+		Enabled bool              `yaml:"enabled"`
+		Vars    map[string]string `yaml:"vars"`
+	} `yaml:"templates"`
+	// Redirects send old routes to new ones (or other sites) instead of generating them
+	Redirects []struct {
+		From string `yaml:"from"`
+		To   string `yaml:"to"`
+		// Status is 301 (default), 302, 303, 307, or 308
+		Status int `yaml:"status"`
+	} `yaml:"redirects"`
+	// Proxies pass /api/... requests from generated pages on to external APIs,
+	// adding keys the pages must not contain
+	Proxies []Proxy `yaml:"proxies"`
+	// Routes adjusts headers, compression, and caching for matching routes
+	Routes []RouteRule `yaml:"routes"`
+	// Static sets how files in the public directories are cached
+	Static struct {
+		// MaxAge is the Cache-Control max-age of static files, in seconds (0 sends none)
+		MaxAge int `yaml:"max_age"`
+		// Fingerprint points asset URLs on generated pages at content-hashed
+		// names that are served as immutable
+		Fingerprint bool `yaml:"fingerprint"`
+	} `yaml:"static"`
+	// RateLimit caps the page and GraphQL requests per client IP address, or
+	// per API key for the configured keys
+	RateLimit struct {
+		Enabled   bool `yaml:"enabled"`
+		RateLimit `yaml:",inline"`
+		// Keys have limits of their own, for clients sending them as a Bearer
+		// token or in an X-API-Key header
+		Keys map[string]RateLimit `yaml:"keys"`
+	} `yaml:"rate_limit"`
+	// IPFilter turns clients away by address and country before anything
+	// is generated
+	IPFilter struct {
+		Enabled bool `yaml:"enabled"`
+		// Allow, when set, admits only these IPs and CIDR ranges; Deny turns
+		// them away, even allowed ones
+		Allow []string `yaml:"allow"`
+		Deny  []string `yaml:"deny"`
+		// CountryHeader names a header carrying the client's country, set by a
+		// CDN or proxy in server.trusted_proxies (e.g. "CF-IPCountry")
+		CountryHeader  string   `yaml:"country_header"`
+		AllowCountries []string `yaml:"allow_countries"`
+		DenyCountries  []string `yaml:"deny_countries"`
+	} `yaml:"ip_filter"`
+	// SecurityHeaders sets HSTS, X-Frame-Options, Referrer-Policy, and
+	// Content-Security-Policy on pages; unset values keep the defaults and
+	// empty ones leave the header out
+	SecurityHeaders struct {
+		Enabled               bool    `yaml:"enabled"`
+		HSTS                  *string `yaml:"hsts"`
+		FrameOptions          *string `yaml:"frame_options"`
+		ReferrerPolicy        *string `yaml:"referrer_policy"`
+		ContentSecurityPolicy *string `yaml:"content_security_policy"`
+	} `yaml:"security_headers"`
+	// Compression gzips responses, streamed pages included, for clients that accept it
+	Compression struct {
+		Enabled bool `yaml:"enabled"`
+		// Level is the gzip level, 1 (fastest) to 9 (smallest); 0 uses the default
+		Level int `yaml:"level"`
+		// MinSize skips responses of a known length below this many bytes
+		MinSize int `yaml:"min_size"`
+		// Types are the compressed content types; "text/" matches a whole family
+		Types []string `yaml:"types"`
+	} `yaml:"compression"`
+	// Metrics exposes Prometheus metrics about requests, generations, and the cache
+	Metrics struct {
+		Enabled bool `yaml:"enabled"`
+		// Path is where the metrics are served
+		Path string `yaml:"path"`
+		// Address, such as "127.0.0.1:9090", serves the metrics on a listener
+		// of their own instead of the site's port
+		Address string `yaml:"address"`
+	} `yaml:"metrics"`
+	// Tracing exports OpenTelemetry traces of requests, from loading the
+	// prompt to the end of the backend's stream, to a collector over OTLP/HTTP
+	Tracing struct {
+		Enabled bool `yaml:"enabled"`
+		// Endpoint is the collector's OTLP/HTTP address; spans go to its /v1/traces
+		Endpoint string `yaml:"endpoint"`
+		// Headers are sent with every export, e.g. a tracing vendor's API key
+		Headers     map[string]string `yaml:"headers"`
+		ServiceName string            `yaml:"service_name"`
+		// SampleRatio is the share of new traces recorded, 0 to 1
+		SampleRatio float64 `yaml:"sample_ratio"`
+	} `yaml:"tracing"`
+	PostProcess struct {
+		// Accessibility fixes common a11y problems (lang, alt text, heading order, labels)
+		Accessibility bool `yaml:"accessibility"`
+		// LinkValidation checks internal links against existing prompts: "rewrite", "drop", "flag", or "" to disable
+		LinkValidation string `yaml:"link_validation"`
+		// Spelling corrects obvious typos in generated text for the configured languages
+		Spelling struct {
+			Enabled   bool     `yaml:"enabled"`
+			Languages []string `yaml:"languages"`
+			// Dictionary is a file of "typo=correction" lines (built-in English list when empty)
+			Dictionary string `yaml:"dictionary"`
+			// Model enables a secondary proofreading call with this (cheap) model
+			Model string `yaml:"model"`
+		} `yaml:"spelling"`
+		// Sanitize strips scripts, event handlers, and unsafe URLs from pages
+		Sanitize struct {
+			Enabled bool `yaml:"enabled"`
+			// ScriptSources and FrameSources are URL prefixes of scripts and
+			// iframes to keep
+			ScriptSources []string `yaml:"script_sources"`
+			FrameSources  []string `yaml:"frame_sources"`
+			// URLSchemes may be used by links and sources (http, https, mailto, tel when empty)
+			URLSchemes []string `yaml:"url_schemes"`
+		} `yaml:"sanitize"`
+	} `yaml:"postprocess"`
+	// Composer selects how page prompts are assembled into the model's prompts
+	Composer struct {
+		// Strategy is "default" or "few-shot"
+		Strategy string `yaml:"strategy"`
+		// ExamplesDir holds the few-shot example pages, relative to prompts_dir
+		ExamplesDir string `yaml:"examples_dir"`
+		// MaxExamples caps the examples per page (0 = all)
+		MaxExamples int `yaml:"max_examples"`
+	} `yaml:"composer"`
+	Navigation struct {
+		// Enabled gives every page prompt the site navigation built from front matter
+		Enabled bool `yaml:"enabled"`
+		// Render also replaces the model's <nav> with a server-rendered menu
+		Render bool `yaml:"render"`
+	} `yaml:"navigation"`
+	Translation struct {
+		// Provider is "deepl" or "libretranslate"; empty lets the model translate
+		Provider string `yaml:"provider"`
+		APIKey   string `yaml:"api_key"`
+		APIBase  string `yaml:"api_base"`
+		// CacheTTL keeps translations per route and language for this many seconds (0 = no cache)
+		CacheTTL int `yaml:"cache_ttl"`
+	} `yaml:"translation"`
+	SEO struct {
+		// Enabled enforces titles, descriptions, canonical links, and robots meta
+		Enabled            bool   `yaml:"enabled"`
+		SiteName           string `yaml:"site_name"`
+		DefaultDescription string `yaml:"default_description"`
+		CanonicalBaseURL   string `yaml:"canonical_base_url"`
+		// NoIndex lists route glob patterns that get a robots noindex meta tag
+		NoIndex []string `yaml:"noindex"`
+	} `yaml:"seo"`
+	Snapshots struct {
+		// Enabled keeps the last Keep generations per route so they can be pinned or rolled back
+		Enabled bool   `yaml:"enabled"`
+		Dir     string `yaml:"dir"`
+		Keep    int    `yaml:"keep"`
+	} `yaml:"snapshots"`
+	Cache struct {
+		// Enabled keeps up to Size generated pages in memory for TTL seconds,
+		// per prompt, model, language, and user input
+		Enabled bool `yaml:"enabled"`
+		Size    int  `yaml:"size"`
+		TTL     int  `yaml:"ttl"`
+		// Driver is "memory" (this instance only) or "redis" (shared by
+		// every instance pointed at the same server)
+		Driver string `yaml:"driver"`
+		Redis  struct {
+			Address  string `yaml:"address"`
+			Password string `yaml:"password"`
+			DB       int    `yaml:"db"`
+			Prefix   string `yaml:"prefix"`
+			// Stale keeps expired pages this many more seconds, served
+			// while one instance regenerates them (0 waits for it instead)
+			Stale int `yaml:"stale"`
+		} `yaml:"redis"`
+		// Prewarm lists routes generated at startup, and again every
+		// RegenerateEvery (a duration such as "6h") when it is set
+		Prewarm         []string `yaml:"prewarm"`
+		RegenerateEvery string   `yaml:"regenerate_every"`
+	} `yaml:"cache"`
+	Warm struct {
+		// Enabled regenerates the Top most requested pages every Interval
+		// seconds in the background and serves them from memory
+		Enabled  bool `yaml:"enabled"`
+		Top      int  `yaml:"top"`
+		Interval int  `yaml:"interval"`
+	} `yaml:"warm"`
+	Budget struct {
+		// Enabled caps token spending; over budget, pages come from snapshots or a notice
+		Enabled bool `yaml:"enabled"`
+		// Global limits across all sites
+		BudgetLimits `yaml:",inline"`
+		// Prices per 1000 tokens, used for the cost limits
+		InputCostPer1K  float64 `yaml:"input_cost_per_1k"`
+		OutputCostPer1K float64 `yaml:"output_cost_per_1k"`
+		// Sites sets extra limits per request host (without port)
+		Sites map[string]BudgetLimits `yaml:"sites"`
+		// Fallback is an HTML file served over budget for pages without a
+		// snapshot, in place of the built-in notice
+		Fallback string `yaml:"fallback"`
+	} `yaml:"budget"`
+	// Usage accounts the tokens and cost of every generation per day, route,
+	// and model, reported at /admin/api/usage
+	Usage struct {
+		Enabled bool `yaml:"enabled"`
+		// Header sends each page's tokens and cost in the X-MuseWeb-Usage trailer
+		Header bool `yaml:"header"`
+		// KeepDays is how long daily totals are kept
+		KeepDays int `yaml:"keep_days"`
+		// Prices per 1000 tokens by model name; other models use the budget prices
+		Prices map[string]ModelPrice `yaml:"prices"`
+	} `yaml:"usage"`
+	Storage struct {
+		// SQLitePath enables the embedded database for durable state (audit log, ...); disabled when empty
+		SQLitePath string `yaml:"sqlite_path"`
+	} `yaml:"storage"`
+	KV struct {
+		// Enabled fills in {{kv "key"}} and {{incr "key"}} in prompts; needs storage.sqlite_path
+		Enabled bool `yaml:"enabled"`
+		// Writable lists the keys (path.Match patterns) forms may increment with POST /kv/<key>
+		Writable []string `yaml:"writable"`
+	} `yaml:"kv"`
+	Tools struct {
+		// DataDir holds the files the read_file tool may read, relative to prompts_dir
+		DataDir string `yaml:"data_dir"`
+		// AllowedHosts are the hosts the fetch_url tool may fetch from; it is off when empty
+		AllowedHosts []string `yaml:"allowed_hosts"`
+	} `yaml:"tools"`
+	Vision struct {
+		// MaxImageMB caps each image uploaded with a multipart form POST
+		MaxImageMB int `yaml:"max_image_mb"`
+	} `yaml:"vision"`
+	RAG struct {
+		// Enabled indexes DocsDir at startup and adds relevant chunks to page prompts
+		Enabled bool   `yaml:"enabled"`
+		DocsDir string `yaml:"docs_dir"`
+		// Backend ("ollama" or "openai") and Model compute the embeddings; the
+		// credentials default to those of the backend's section
+		Backend string `yaml:"backend"`
+		Model   string `yaml:"model"`
+		APIKey  string `yaml:"api_key"`
+		APIBase string `yaml:"api_base"`
+		// TopK is how many chunks each page gets; ChunkSize caps a chunk in characters
+		TopK      int `yaml:"top_k"`
+		ChunkSize int `yaml:"chunk_size"`
+	} `yaml:"rag"`
+	Admin struct {
+		// Token protects the /admin endpoints; they are disabled when empty
+		Token string `yaml:"token"`
+	} `yaml:"admin"`
+	// Auth makes the whole site private: requests need a Basic auth user or
+	// the shared token, except for the Exempt paths
+	Auth struct {
+		Enabled bool `yaml:"enabled"`
+		// Users maps user names to passwords, in plain text or bcrypt hashes
+		Users  map[string]string `yaml:"users"`
+		Token  string            `yaml:"token"`
+		Realm  string            `yaml:"realm"`
+		Exempt []string          `yaml:"exempt"`
+	} `yaml:"auth"`
+	// OIDC signs visitors in with an OpenID Connect provider; prompts can
+	// then use their claims, e.g. {{user "name"}}
+	OIDC struct {
+		Enabled      bool     `yaml:"enabled"`
+		Issuer       string   `yaml:"issuer"`
+		ClientID     string   `yaml:"client_id"`
+		ClientSecret string   `yaml:"client_secret"`
+		RedirectURL  string   `yaml:"redirect_url"`
+		Scopes       []string `yaml:"scopes"`
+		// Claims are the ID token claims kept for prompts
+		Claims        []string `yaml:"claims"`
+		SessionSecret string   `yaml:"session_secret"`
+		SessionHours  int      `yaml:"session_hours"`
+	} `yaml:"oidc"`
 	OpenAI struct {
 		APIKey  string `yaml:"api_key"`
 		APIBase string `yaml:"api_base"`
+		// Sampling tunes generation for the openai and azure-openai backends; unset values keep the provider default
+		Sampling struct {
+			Temperature      *float64 `yaml:"temperature"`
+			TopP             *float64 `yaml:"top_p"`
+			MaxTokens        int      `yaml:"max_tokens"`
+			PresencePenalty  *float64 `yaml:"presence_penalty"`
+			FrequencyPenalty *float64 `yaml:"frequency_penalty"`
+			Seed             *int     `yaml:"seed"`
+			Stop             []string `yaml:"stop"`
+		} `yaml:"sampling"`
 	} `yaml:"openai"`
+	// OpenRouter sets provider routing when openai.api_base is OpenRouter
+	OpenRouter struct {
+		// Provider is passed through as OpenRouter's provider object (order, allow_fallbacks, ...)
+		Provider   map[string]interface{} `yaml:"provider"`
+		Route      string                 `yaml:"route"`
+		Models     []string               `yaml:"models"`
+		Transforms []string               `yaml:"transforms"`
+	} `yaml:"openrouter"`
 	Ollama struct {
 		APIKey  string `yaml:"api_key"`
 		APIBase string `yaml:"api_base"`
+		// Options are passed to Ollama with every generation: num_ctx, temperature,
+		// top_p, repeat_penalty, keep_alive, ...
+		Options map[string]interface{} `yaml:"options"`
+		// GenerateModels maps model name patterns to /api/generate settings, for
+		// models whose chat templates mangle large prompts
+		GenerateModels map[string]struct {
+			Template string `yaml:"template"`
+			Raw      bool   `yaml:"raw"`
+		} `yaml:"generate_models"`
+		// Nodes balances Ollama generations over several servers running the same models
+		Nodes []struct {
+			APIBase string `yaml:"api_base"`
+			Weight  int    `yaml:"weight"`
+		} `yaml:"nodes"`
+		// NodeCooldown skips a failed node for this many seconds, doubling while it keeps failing
+		NodeCooldown int `yaml:"node_cooldown"`
 	} `yaml:"ollama"`
+	Anthropic struct {
+		APIKey  string `yaml:"api_key"`
+		APIBase string `yaml:"api_base"`
+	} `yaml:"anthropic"`
+	Mistral struct {
+		APIKey  string `yaml:"api_key"`
+		APIBase string `yaml:"api_base"`
+	} `yaml:"mistral"`
+	Groq struct {
+		APIKey  string `yaml:"api_key"`
+		APIBase string `yaml:"api_base"`
+	} `yaml:"groq"`
+	AzureOpenAI struct {
+		APIKey string `yaml:"api_key"`
+		// APIBase is the resource endpoint, e.g. https://my-resource.openai.azure.com
+		APIBase    string `yaml:"api_base"`
+		APIVersion string `yaml:"api_version"`
+	} `yaml:"azure_openai"`
+	Bedrock struct {
+		// APIKey is a Bedrock API key; without one, requests are signed with the AWS keys below
+		APIKey string `yaml:"api_key"`
+		// APIBase overrides the regional bedrock-runtime endpoint (e.g. a VPC endpoint)
+		APIBase         string `yaml:"api_base"`
+		Region          string `yaml:"region"`
+		AccessKeyID     string `yaml:"access_key_id"`
+		SecretAccessKey string `yaml:"secret_access_key"`
+		SessionToken    string `yaml:"session_token"`
+	} `yaml:"bedrock"`
+	LlamaCpp struct {
+		// APIKey is only needed when llama-server runs with --api-key
+		APIKey  string `yaml:"api_key"`
+		APIBase string `yaml:"api_base"`
+		// NPredict caps generated tokens (0 = server default)
+		NPredict int `yaml:"n_predict"`
+		// CachePrompt reuses the KV cache for the shared system prompt (unset = server default)
+		CachePrompt *bool `yaml:"cache_prompt"`
+		// Grammar is an inline GBNF grammar; GrammarFile reads one from disk
+		Grammar     string `yaml:"grammar"`
+		GrammarFile string `yaml:"grammar_file"`
+		// Template is a Go template over .System and .Prompt; empty uses the model's chat template
+		Template string `yaml:"template"`
+	} `yaml:"llamacpp"`
+	VLLM struct {
+		// APIKey is only needed when vLLM runs with --api-key
+		APIKey  string `yaml:"api_key"`
+		APIBase string `yaml:"api_base"`
+	} `yaml:"vllm"`
+	TGI struct {
+		// APIKey is only needed behind an authenticating proxy or Inference Endpoints
+		APIKey  string `yaml:"api_key"`
+		APIBase string `yaml:"api_base"`
+		// MaxNewTokens caps generated tokens (0 = server default)
+		MaxNewTokens int `yaml:"max_new_tokens"`
+		// Template is a Go template over .System and .Prompt; empty uses the model's chat template
+		Template string `yaml:"template"`
+	} `yaml:"tgi"`
+	// Mock replays recorded responses (backend: mock) and records live ones
+	Mock struct {
+		Dir    string `yaml:"dir"`
+		Record bool   `yaml:"record"`
+		// DelayMS pauses between replayed chunks to mimic a streaming model
+		DelayMS int `yaml:"delay_ms"`
+	} `yaml:"mock"`
+}
+
+// NamedBackend is one entry of the backends list. An empty api_key or
+// api_base falls back to the section for its type (openai:, ollama:, ...).
+type NamedBackend struct {
+	Name    string `yaml:"name"`
+	Type    string `yaml:"type"`
+	Model   string `yaml:"model"`
+	APIKey  string `yaml:"api_key"`
+	APIBase string `yaml:"api_base"`
+	// FirstByteTimeout overrides failover.first_byte_timeout for this backend (seconds)
+	FirstByteTimeout int `yaml:"first_byte_timeout"`
+}
+
+// RoutingRule is one entry of routing.rules; every condition set must hold
+type RoutingRule struct {
+	// Backend is the named backend matching pages are generated with
+	Backend string `yaml:"backend"`
+	// Routes are route globs such as "blog/*"
+	Routes []string `yaml:"routes"`
+	// Complexity lists values of the "complexity" front matter hint
+	Complexity      []string `yaml:"complexity"`
+	MinPromptTokens int      `yaml:"min_prompt_tokens"`
+	MaxPromptTokens int      `yaml:"max_prompt_tokens"`
+}
+
+// ModelCapabilities is one entry of capabilities.models
+type ModelCapabilities struct {
+	// Model is a model name or glob such as "my-finetune:*"
+	Model         string `yaml:"model"`
+	ContextWindow int    `yaml:"context_window"`
+	MaxOutput     int    `yaml:"max_output"`
+	Vision        bool   `yaml:"vision"`
+	Tools         bool   `yaml:"tools"`
+	// Reasoning is "none", "think-tags", "effort", "budget", or "" when unknown
+	Reasoning string `yaml:"reasoning"`
+	// PriceTier is "free", "low", "medium", "high", or "" when unknown
+	PriceTier string `yaml:"price_tier"`
+}
+
+// RouteRule is one entry of the routes list
+type RouteRule struct {
+	// Pattern is a route glob such as "dashboard" or "blog/*"
+	Pattern       string            `yaml:"pattern"`
+	Headers       map[string]string `yaml:"headers"`
+	NoCompression bool              `yaml:"no_compression"`
+	NoCache       bool              `yaml:"no_cache"`
+}
+
+// Proxy is one entry of the proxies list
+type Proxy struct {
+	Path     string            `yaml:"path"`
+	Upstream string            `yaml:"upstream"`
+	Headers  map[string]string `yaml:"headers"`
+	Query    map[string]string `yaml:"query"`
+	Methods  []string          `yaml:"methods"`
+	// CacheTTL caches successful GET responses for this many seconds (0 = no cache)
+	CacheTTL int `yaml:"cache_ttl"`
+	// Timeout gives up on the upstream after this many seconds (default 30)
+	Timeout int `yaml:"timeout"`
+}
+
+// RateLimit caps the requests of one client; 0 means unlimited
+type RateLimit struct {
+	// PerMinute is the sustained number of requests per minute
+	PerMinute int `yaml:"requests_per_minute"`
+	// Burst is how many requests may come at once (requests_per_minute when 0)
+	Burst int `yaml:"burst"`
+	// Streams caps the requests in progress at once
+	Streams int `yaml:"streams"`
+}
+
+// BudgetLimits caps spending per UTC day and month; zero means unlimited
+type BudgetLimits struct {
+	DailyTokens   int64   `yaml:"daily_tokens"`
+	MonthlyTokens int64   `yaml:"monthly_tokens"`
+	DailyCost     float64 `yaml:"daily_cost"`
+	MonthlyCost   float64 `yaml:"monthly_cost"`
+}
+
+// ModelPrice is what a model's tokens cost, per 1000
+type ModelPrice struct {
+	InputPer1K  float64 `yaml:"input_per_1k"`
+	OutputPer1K float64 `yaml:"output_per_1k"`
 }
 
 // Load reads the configuration from a YAML file
@@ -38,6 +619,9 @@ func Load(path string) (*Config, error) {
 	cfg.Server.Address = "127.0.0.1"
 	cfg.Server.Port = "8080"
 	cfg.Server.PromptsDir = "prompts"
+	cfg.Server.RenderMode = "stream"
+	cfg.Server.ClientBufferKB = 1024
+	cfg.Server.StallTimeout = 30
 	cfg.Model.Backend = "ollama"
 	cfg.Model.Name = "llama3"
 	cfg.Model.ReasoningModels = []string{
@@ -54,6 +638,57 @@ func Load(path string) (*Config, error) {
 		"qwen",                                // Qwen models (general, after specific)
 	}
 	cfg.Ollama.APIBase = "http://localhost:11434"
+	cfg.Anthropic.APIBase = "https://api.anthropic.com/v1"
+	cfg.Mistral.APIBase = "https://api.mistral.ai/v1"
+	cfg.Groq.APIBase = "https://api.groq.com/openai/v1"
+	cfg.LlamaCpp.APIBase = "http://localhost:8080"
+	cfg.VLLM.APIBase = "http://localhost:8000/v1"
+	cfg.TGI.APIBase = "http://localhost:8080"
+	cfg.TGI.MaxNewTokens = 4096
+	cfg.AzureOpenAI.APIVersion = "2024-10-21"
+	cfg.Translation.CacheTTL = 3600
+	cfg.Snapshots.Dir = "snapshots"
+	cfg.Snapshots.Keep = 10
+	cfg.Server.QueueSize = 20
+	cfg.Server.QueueWait = 60
+	cfg.Static.MaxAge = 3600
+	cfg.Compression.MinSize = 512
+	cfg.Input.StripControl = true
+	cfg.RateLimit.PerMinute = 60
+	cfg.Auth.Realm = "MuseWeb"
+	cfg.Auth.Exempt = []string{"/healthz", "/readyz", "/livez"}
+	cfg.OIDC.SessionHours = 24
+	cfg.RateLimit.Streams = 4
+	cfg.Metrics.Path = "/metrics"
+	cfg.Tracing.Endpoint = "http://localhost:4318"
+	cfg.Tracing.ServiceName = "museweb"
+	cfg.Tracing.SampleRatio = 1
+	cfg.Usage.KeepDays = 90
+	cfg.Server.TLS.Autocert.CacheDir = "certs"
+	cfg.Cache.Size = 500
+	cfg.Cache.TTL = 3600
+	cfg.Cache.Driver = "memory"
+	cfg.Cache.Redis.Address = "localhost:6379"
+	cfg.Cache.Redis.Prefix = "museweb:"
+	cfg.Cache.Redis.Stale = 3600
+	cfg.Warm.Top = 10
+	cfg.Warm.Interval = 600
+	cfg.Shadow.Sample = 0.1
+	cfg.Routing.EscalateAfter = 3
+	cfg.Tools.DataDir = "data"
+	cfg.Vision.MaxImageMB = 10
+	cfg.RAG.DocsDir = "docs"
+	cfg.RAG.Backend = "ollama"
+	cfg.RAG.Model = "nomic-embed-text"
+	cfg.RAG.TopK = 4
+	cfg.RAG.ChunkSize = 1500
+	cfg.Retry.Attempts = 2
+	cfg.Retry.BaseDelayMs = 500
+	cfg.Retry.MaxDelayMs = 8000
+	cfg.Composer.Strategy = "default"
+	cfg.Composer.ExamplesDir = "examples"
+	cfg.Composer.MaxExamples = 2
+	cfg.Mock.Dir = "recordings"
 
 	// Read the config file
 	data, err := os.ReadFile(path)
@@ -61,10 +696,85 @@ func Load(path string) (*Config, error) {
 		return &cfg, err
 	}
 
-	// Parse the YAML
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	// Parse the YAML, with environment variables and secret files filled in
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return &cfg, err
+	}
+	if doc.Kind == 0 {
+		return &cfg, nil // Empty file
+	}
+	if err := interpolate(&doc); err != nil {
+		return &cfg, err
+	}
+	if err := doc.Decode(&cfg); err != nil {
 		return &cfg, err
 	}
 
 	return &cfg, nil
 }
+
+// secretKeys are the config keys whose values never leave the process
+var secretKeys = map[string]bool{
+	"api_key": true, "token": true, "access_key_id": true, "secret_access_key": true, "session_token": true,
+	"password": true, "client_secret": true, "session_secret": true,
+}
+
+// Redacted returns the configuration as nested maps with credentials replaced
+// by "[redacted]", for display. Proxy and trace exporter headers and query
+// parameters are redacted as a whole, since they are where API keys go.
+func (c *Config) Redacted() (map[string]interface{}, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]interface{}
+	if err := yaml.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	redact(out, false)
+	return out, nil
+}
+
+// redact replaces secret values in v in place; all marks every value secret
+func redact(v interface{}, all bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if s, ok := value.(string); ok {
+				if s != "" && (all || secretKeys[key]) {
+					v[key] = "[redacted]"
+				}
+				continue
+			}
+			// Users have their passwords as values; rate limits are keyed by
+			// the API keys themselves
+			if key == "users" {
+				redact(value, true)
+				continue
+			}
+			if keys, ok := value.(map[string]interface{}); ok && key == "keys" {
+				redacted := make(map[string]interface{}, len(keys))
+				for _, limits := range keys {
+					redacted["[redacted "+strconv.Itoa(len(redacted)+1)+"]"] = limits
+				}
+				v[key] = redacted
+				continue
+			}
+			redact(value, all || ((key == "headers" || key == "query") && sendsCredentials(v)))
+		}
+	case []interface{}:
+		for _, item := range v {
+			redact(item, all)
+		}
+	}
+}
+
+// sendsCredentials reports whether a config entry's headers and query go to
+// another service, where API keys are put: a proxies entry or the tracing
+// exporter
+func sendsCredentials(entry map[string]interface{}) bool {
+	_, proxy := entry["upstream"]
+	_, exporter := entry["endpoint"]
+	return proxy || exporter
+}
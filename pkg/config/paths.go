@@ -0,0 +1,36 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// FileName is the name of the configuration file in each search path
+const FileName = "config.yaml"
+
+// SearchPaths returns where the configuration file is looked for, in order:
+// the working directory, $XDG_CONFIG_HOME/museweb (~/.config/museweb when
+// unset), and /etc/museweb
+func SearchPaths() []string {
+	paths := []string{FileName}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			dir = filepath.Join(home, ".config")
+		}
+	}
+	if dir != "" {
+		paths = append(paths, filepath.Join(dir, "museweb", FileName))
+	}
+	return append(paths, filepath.Join("/etc/museweb", FileName))
+}
+
+// Find returns the first of SearchPaths that exists, or "" when none does
+func Find() string {
+	for _, path := range SearchPaths() {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
@@ -0,0 +1,27 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	t.Chdir(t.TempDir())
+	xdg := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", xdg)
+
+	user := filepath.Join(xdg, "museweb", FileName)
+	if got := Find(); got != "" && got != filepath.Join("/etc/museweb", FileName) {
+		t.Errorf("Find() = %q with no configuration file", got)
+	}
+	os.MkdirAll(filepath.Dir(user), 0o755)
+	os.WriteFile(user, []byte("server:\n  port: \"9000\"\n"), 0o600)
+	if got := Find(); got != user {
+		t.Errorf("Find() = %q, want %q", got, user)
+	}
+	os.WriteFile(FileName, []byte("server:\n  port: \"9001\"\n"), 0o600)
+	if got := Find(); got != FileName {
+		t.Errorf("Find() = %q, want the working directory's %q", got, FileName)
+	}
+}
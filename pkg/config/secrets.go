@@ -0,0 +1,97 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPattern matches ${NAME} and ${NAME:-default} in config values; $${ is
+// a literal ${
+var envPattern = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// interpolate expands the environment variables in the values below n, and
+// replaces secrets given as files ("api_key_file: /run/secrets/openai") by
+// the files' contents, so keys need not be written into the config
+func interpolate(n *yaml.Node) error {
+	switch n.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, c := range n.Content {
+			if err := interpolate(c); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if err := interpolate(n.Content[i+1]); err != nil {
+				return err
+			}
+		}
+		return secretFiles(n)
+	case yaml.ScalarNode:
+		if value := expandEnv(n.Value); value != n.Value {
+			n.Value = value
+			if n.Style&(yaml.SingleQuotedStyle|yaml.DoubleQuotedStyle) == 0 {
+				n.Tag = "" // Unquoted values are numbers or booleans as they expand
+			}
+		}
+	}
+	return nil
+}
+
+// expandEnv replaces ${NAME} by the variable's value, or by the default in
+// ${NAME:-default} when it is unset or empty
+func expandEnv(s string) string {
+	if !strings.Contains(s, "${") {
+		return s
+	}
+	return envPattern.ReplaceAllStringFunc(s, func(m string) string {
+		if strings.HasPrefix(m, "$$") {
+			return m[1:]
+		}
+		parts := envPattern.FindStringSubmatch(m)
+		if v := os.Getenv(parts[1]); v != "" {
+			return v
+		}
+		return parts[2]
+	})
+}
+
+// secretFiles reads the <secret>_file entries of a mapping into <secret>,
+// for the keys in secretKeys
+func secretFiles(n *yaml.Node) error {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		secret, ok := strings.CutSuffix(key.Value, "_file")
+		if !ok || !secretKeys[secret] || value.Kind != yaml.ScalarNode || value.Value == "" {
+			continue
+		}
+		data, err := os.ReadFile(value.Value)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", key.Value, err)
+		}
+		content := strings.TrimRight(string(data), "\r\n")
+
+		replaced := false
+		for j := 0; j+1 < len(n.Content); j += 2 {
+			if n.Content[j].Value != secret {
+				continue
+			}
+			if n.Content[j+1].Value != "" {
+				return fmt.Errorf("both %s and %s are set (line %d)", secret, key.Value, key.Line)
+			}
+			n.Content[j+1].SetString(content)
+			replaced = true
+		}
+		if !replaced {
+			k, v := &yaml.Node{}, &yaml.Node{}
+			k.SetString(secret)
+			v.SetString(content)
+			n.Content = append(n.Content, k, v)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadInterpolatesSecrets(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "openai_key")
+	if err := os.WriteFile(keyFile, []byte("sk-from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MUSEWEB_TEST_MODEL", "gpt-test")
+	t.Setenv("MUSEWEB_TEST_QUEUE", "7")
+	path := filepath.Join(dir, "config.yaml")
+	os.WriteFile(path, []byte(`
+server:
+  port: ${MUSEWEB_TEST_PORT:-9000}
+  queue_size: ${MUSEWEB_TEST_QUEUE}
+model:
+  name: "${MUSEWEB_TEST_MODEL}"
+openai:
+  api_key_file: `+keyFile+`
+admin:
+  token: "literal $${NOT_EXPANDED}"
+`), 0o600)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != "9000" || cfg.Server.QueueSize != 7 || cfg.Model.Name != "gpt-test" {
+		t.Errorf("port %q, queue_size %d, model %q", cfg.Server.Port, cfg.Server.QueueSize, cfg.Model.Name)
+	}
+	if cfg.OpenAI.APIKey != "sk-from-file" {
+		t.Errorf("api_key = %q", cfg.OpenAI.APIKey)
+	}
+	if cfg.Admin.Token != "literal ${NOT_EXPANDED}" {
+		t.Errorf("token = %q", cfg.Admin.Token)
+	}
+
+	os.WriteFile(path, []byte("openai:\n  api_key: sk-inline\n  api_key_file: "+keyFile+"\n"), 0o600)
+	if _, err := Load(path); err == nil {
+		t.Error("accepted both api_key and api_key_file")
+	}
+}
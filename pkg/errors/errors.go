@@ -0,0 +1,77 @@
+// Package errors renders the error pages MuseWeb shows visitors.
+package errors
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+)
+
+// page is the error page; the status code, its text and the message are
+// filled in, escaped
+const page = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>%[1]d %[2]s</title>
+<style>
+body{font-family:system-ui,-apple-system,"Segoe UI",Roboto,sans-serif;background:#f7f7f9;color:#222;margin:0;display:flex;min-height:100vh;align-items:center;justify-content:center}
+main{max-width:36rem;padding:2rem;text-align:center}
+h1{font-size:4rem;margin:0;color:#6b4fbb}
+h2{font-weight:500;margin:.5rem 0 1.5rem}
+p{color:#555;line-height:1.5}
+a{color:#6b4fbb}
+</style>
+</head>
+<body>
+<main>
+<h1>%[1]d</h1>
+<h2>%[2]s</h2>
+<p>%[3]s</p>
+<p><a href="/">Back to the home page</a></p>
+</main>
+</body>
+</html>
+`
+
+// RenderErrorPage writes an HTML error page with the given status code and
+// message to w
+func RenderErrorPage(w http.ResponseWriter, r *http.Request, status int, message string) {
+	text := http.StatusText(status)
+	if message == "" {
+		message = text
+	}
+	if status >= http.StatusInternalServerError {
+		log.Printf("❌ %d %s for %s: %s", status, text, r.URL.Path, message)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	fmt.Fprintf(w, page, status, html.EscapeString(text), html.EscapeString(message))
+}
+
+// NotFound writes a 404 page
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	RenderErrorPage(w, r, http.StatusNotFound, fmt.Sprintf("The page '%s' could not be found.", r.URL.Path))
+}
+
+// BadRequest writes a 400 page
+func BadRequest(w http.ResponseWriter, r *http.Request, message string) {
+	RenderErrorPage(w, r, http.StatusBadRequest, message)
+}
+
+// MethodNotAllowed writes a 405 page
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	RenderErrorPage(w, r, http.StatusMethodNotAllowed, fmt.Sprintf("The method %s is not allowed here.", r.Method))
+}
+
+// InternalServerError writes a 500 page
+func InternalServerError(w http.ResponseWriter, r *http.Request, message string) {
+	RenderErrorPage(w, r, http.StatusInternalServerError, message)
+}
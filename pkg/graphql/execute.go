@@ -0,0 +1,245 @@
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Object resolves the fields of a GraphQL object type. The returned value may be
+// a scalar, an Object, or a slice of either; nested values are resolved against
+// the field's selection set.
+type Object func(field string, args map[string]interface{}) (interface{}, error)
+
+// Request is the standard GraphQL-over-HTTP request body
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// Error is a GraphQL error entry
+type Error struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// Response is the standard GraphQL response body
+type Response struct {
+	Data   *orderedMap `json:"data"`
+	Errors []Error     `json:"errors,omitempty"`
+}
+
+// orderedMap preserves selection order in the JSON output, as the spec requires
+type orderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newOrderedMap() *orderedMap {
+	return &orderedMap{values: make(map[string]interface{})}
+}
+
+func (m *orderedMap) set(key string, value interface{}) {
+	if _, exists := m.values[key]; !exists {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// MarshalJSON implements json.Marshaler
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Execute parses and runs a request against the root query object
+func Execute(root Object, req Request) *Response {
+	doc, err := Parse(req.Query)
+	if err != nil {
+		return &Response{Errors: []Error{{Message: err.Error()}}}
+	}
+
+	op, err := selectOperation(doc, req.OperationName)
+	if err != nil {
+		return &Response{Errors: []Error{{Message: err.Error()}}}
+	}
+
+	vars, err := coerceVariables(op, req.Variables)
+	if err != nil {
+		return &Response{Errors: []Error{{Message: err.Error()}}}
+	}
+
+	e := &executor{vars: vars}
+	data := e.resolveObject(root, op.SelectionSet, nil)
+	return &Response{Data: data, Errors: e.errors}
+}
+
+func selectOperation(doc *Document, name string) (*Operation, error) {
+	if name == "" {
+		if len(doc.Operations) > 1 {
+			return nil, fmt.Errorf("operationName is required when the document contains multiple operations")
+		}
+		return doc.Operations[0], nil
+	}
+	for _, op := range doc.Operations {
+		if op.Name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operation %q", name)
+}
+
+func coerceVariables(op *Operation, provided map[string]interface{}) (map[string]interface{}, error) {
+	vars := make(map[string]interface{})
+	for _, def := range op.Variables {
+		value, ok := provided[def.Name]
+		if !ok {
+			value = def.Default
+		}
+		if value == nil && len(def.Type) > 0 && def.Type[len(def.Type)-1] == '!' {
+			return nil, fmt.Errorf("variable $%s of required type %s was not provided", def.Name, def.Type)
+		}
+		vars[def.Name] = value
+	}
+	return vars, nil
+}
+
+type executor struct {
+	vars   map[string]interface{}
+	errors []Error
+}
+
+func (e *executor) addError(path []interface{}, err error) {
+	e.errors = append(e.errors, Error{Message: err.Error(), Path: path})
+}
+
+func (e *executor) resolveObject(obj Object, selection []*Field, path []interface{}) *orderedMap {
+	result := newOrderedMap()
+	for _, field := range selection {
+		key := field.ResponseKey()
+		fieldPath := extendPath(path, key)
+
+		args, err := e.resolveArguments(field.Arguments)
+		if err != nil {
+			e.addError(fieldPath, err)
+			result.set(key, nil)
+			continue
+		}
+
+		value, err := obj(field.Name, args)
+		if err != nil {
+			e.addError(fieldPath, err)
+			result.set(key, nil)
+			continue
+		}
+		result.set(key, e.completeValue(field, value, fieldPath))
+	}
+	return result
+}
+
+func (e *executor) completeValue(field *Field, value interface{}, path []interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case Object:
+		if len(field.SelectionSet) == 0 {
+			e.addError(path, fmt.Errorf("field %q of object type must have a selection of subfields", field.Name))
+			return nil
+		}
+		return e.resolveObject(v, field.SelectionSet, path)
+	case []Object:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = e.completeValue(field, item, extendPath(path, i))
+		}
+		return list
+	default:
+		if len(field.SelectionSet) > 0 {
+			e.addError(path, fmt.Errorf("field %q is a scalar and cannot have a selection set", field.Name))
+			return nil
+		}
+		return v
+	}
+}
+
+// extendPath copies path so sibling fields never share a backing array
+func extendPath(path []interface{}, elem interface{}) []interface{} {
+	extended := make([]interface{}, len(path), len(path)+1)
+	copy(extended, path)
+	return append(extended, elem)
+}
+
+func (e *executor) resolveArguments(args map[string]interface{}) (map[string]interface{}, error) {
+	resolved := make(map[string]interface{}, len(args))
+	for name, value := range args {
+		v, err := e.resolveValue(value)
+		if err != nil {
+			return nil, err
+		}
+		resolved[name] = v
+	}
+	return resolved, nil
+}
+
+func (e *executor) resolveValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case variableRef:
+		resolved, ok := e.vars[string(v)]
+		if !ok {
+			return nil, fmt.Errorf("variable $%s is not defined by the operation", string(v))
+		}
+		return resolved, nil
+	case enumValue:
+		return string(v), nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			r, err := e.resolveValue(item)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = r
+		}
+		return list, nil
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			r, err := e.resolveValue(item)
+			if err != nil {
+				return nil, err
+			}
+			obj[k] = r
+		}
+		return obj, nil
+	}
+	return value, nil
+}
+
+// StringArg returns a string argument, or def if it is missing or null
+func StringArg(args map[string]interface{}, name, def string) (string, error) {
+	value, ok := args[name]
+	if !ok || value == nil {
+		return def, nil
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("argument %q must be a String", name)
+	}
+	return s, nil
+}
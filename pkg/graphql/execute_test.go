@@ -0,0 +1,59 @@
+package graphql
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestExecute(t *testing.T) {
+	var root Object
+	root = func(field string, args map[string]interface{}) (interface{}, error) {
+		switch field {
+		case "echo":
+			return StringArg(args, "text", "default")
+		case "child":
+			return root, nil
+		case "children":
+			return []Object{root, root}, nil
+		}
+		return nil, &unknownField{field}
+	}
+	for _, tc := range []struct {
+		name string
+		req  Request
+		want string
+	}{
+		{"default argument", Request{Query: `{ echo }`}, `{"data":{"echo":"default"}}`},
+		{"variables and aliases", Request{
+			Query:     `query Q($t: String = "unused") { b: echo(text: $t) a: echo(text: "lit") }`,
+			Variables: map[string]interface{}{"t": "var"},
+		}, `{"data":{"b":"var","a":"lit"}}`},
+		{"variable default", Request{Query: `query ($t: String = "fallback") { echo(text: $t) }`}, `{"data":{"echo":"fallback"}}`},
+		{"nested objects and lists", Request{Query: `{ child { echo } children { echo(text: "x") } }`},
+			`{"data":{"child":{"echo":"default"},"children":[{"echo":"x"},{"echo":"x"}]}}`},
+		{"operation name", Request{Query: `query A { a: echo(text: "a") } query B { b: echo(text: "b") }`, OperationName: "B"},
+			`{"data":{"b":"b"}}`},
+		{"missing operation name", Request{Query: `query A { echo } query B { echo }`},
+			`{"data":null,"errors":[{"message":"operationName is required when the document contains multiple operations"}]}`},
+		{"missing required variable", Request{Query: `query ($t: String!) { echo(text: $t) }`},
+			`{"data":null,"errors":[{"message":"variable $t of required type String! was not provided"}]}`},
+		{"field errors keep their siblings", Request{Query: `{ echo nope child { nope } }`},
+			`{"data":{"echo":"default","nope":null,"child":{"nope":null}},"errors":[{"message":"unknown field nope","path":["nope"]},{"message":"unknown field nope","path":["child","nope"]}]}`},
+		{"object without a selection", Request{Query: `{ child }`},
+			`{"data":{"child":null},"errors":[{"message":"field \"child\" of object type must have a selection of subfields","path":["child"]}]}`},
+		{"scalar with a selection", Request{Query: `{ echo { x } }`},
+			`{"data":{"echo":null},"errors":[{"message":"field \"echo\" is a scalar and cannot have a selection set","path":["echo"]}]}`},
+	} {
+		got, err := json.Marshal(Execute(root, tc.req))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("%s:\n got %s\nwant %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+type unknownField struct{ name string }
+
+func (e *unknownField) Error() string { return "unknown field " + e.name }
@@ -0,0 +1,455 @@
+// Package graphql implements the small subset of GraphQL that MuseWeb needs to
+// expose its rendering and metadata API: query operations with variables,
+// aliases, arguments and nested selection sets. Fragments, directives and
+// mutations are rejected with a clear error instead of being half-supported.
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Document is a parsed GraphQL request document
+type Document struct {
+	Operations []*Operation
+}
+
+// Operation is a single query operation in a document
+type Operation struct {
+	Type         string
+	Name         string
+	Variables    []VariableDefinition
+	SelectionSet []*Field
+}
+
+// VariableDefinition declares a variable accepted by an operation
+type VariableDefinition struct {
+	Name    string
+	Type    string
+	Default interface{}
+}
+
+// Field is a selected field with its arguments and sub-selections
+type Field struct {
+	Alias        string
+	Name         string
+	Arguments    map[string]interface{}
+	SelectionSet []*Field
+}
+
+// ResponseKey returns the key the field's value is stored under in the result
+func (f *Field) ResponseKey() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+// variableRef marks an argument value that must be resolved from the request variables
+type variableRef string
+
+// enumValue marks a bare identifier used as an argument value
+type enumValue string
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokPunct
+	tokName
+	tokString
+	tokInt
+	tokFloat
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+// lex splits a GraphQL source document into tokens
+func lex(src string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == '#':
+			// Comments run to the end of the line
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == ',' || unicode.IsSpace(rune(c)) || c == 0xEF || c == 0xBB || c == 0xBF:
+			i++
+		case strings.HasPrefix(src[i:], "..."):
+			tokens = append(tokens, token{tokPunct, "...", i})
+			i += 3
+		case strings.ContainsRune("{}()[]:!$=@|&", rune(c)):
+			tokens = append(tokens, token{tokPunct, string(c), i})
+			i++
+		case c == '"':
+			s, n, err := lexString(src[i:])
+			if err != nil {
+				return nil, fmt.Errorf("syntax error at %d: %w", i, err)
+			}
+			tokens = append(tokens, token{tokString, s, i})
+			i += n
+		case c == '-' || (c >= '0' && c <= '9'):
+			start := i
+			i++
+			kind := tokInt
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.' || src[i] == 'e' || src[i] == 'E' || src[i] == '+' || src[i] == '-') {
+				if src[i] == '.' || src[i] == 'e' || src[i] == 'E' {
+					kind = tokFloat
+				}
+				i++
+			}
+			tokens = append(tokens, token{kind, src[start:i], start})
+		case c == '_' || unicode.IsLetter(rune(c)):
+			start := i
+			for i < len(src) && (src[i] == '_' || unicode.IsLetter(rune(src[i])) || unicode.IsDigit(rune(src[i]))) {
+				i++
+			}
+			tokens = append(tokens, token{tokName, src[start:i], start})
+		default:
+			return nil, fmt.Errorf("syntax error at %d: unexpected character %q", i, c)
+		}
+	}
+	tokens = append(tokens, token{tokEOF, "", len(src)})
+	return tokens, nil
+}
+
+// lexString reads a quoted string (regular or block) and returns its value and consumed length
+func lexString(src string) (string, int, error) {
+	if strings.HasPrefix(src, `"""`) {
+		end := strings.Index(src[3:], `"""`)
+		if end == -1 {
+			return "", 0, fmt.Errorf("unterminated block string")
+		}
+		return strings.TrimSpace(src[3 : 3+end]), end + 6, nil
+	}
+	for i := 1; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case '\n':
+			return "", 0, fmt.Errorf("unterminated string")
+		case '"':
+			value, err := strconv.Unquote(src[:i+1])
+			if err != nil {
+				return "", 0, fmt.Errorf("invalid string literal: %w", err)
+			}
+			return value, i + 1, nil
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// Parse parses a GraphQL request document
+func Parse(src string) (*Document, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	doc := &Document{}
+	for p.peek().kind != tokEOF {
+		op, err := p.parseOperation()
+		if err != nil {
+			return nil, err
+		}
+		doc.Operations = append(doc.Operations, op)
+	}
+	if len(doc.Operations) == 0 {
+		return nil, fmt.Errorf("document does not contain any operations")
+	}
+	return doc, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(value string) error {
+	t := p.next()
+	if t.kind != tokPunct || t.value != value {
+		return p.errorf(t, "expected %q", value)
+	}
+	return nil
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	found := t.value
+	if t.kind == tokEOF {
+		found = "end of document"
+	}
+	return fmt.Errorf("syntax error at %d: %s, found %q", t.pos, fmt.Sprintf(format, args...), found)
+}
+
+func (p *parser) isPunct(value string) bool {
+	t := p.peek()
+	return t.kind == tokPunct && t.value == value
+}
+
+func (p *parser) parseOperation() (*Operation, error) {
+	op := &Operation{Type: "query"}
+
+	// Shorthand query: { ... }
+	if p.isPunct("{") {
+		sel, err := p.parseSelectionSet()
+		if err != nil {
+			return nil, err
+		}
+		op.SelectionSet = sel
+		return op, nil
+	}
+
+	t := p.next()
+	if t.kind != tokName {
+		return nil, p.errorf(t, "expected operation")
+	}
+	switch t.value {
+	case "query":
+	case "mutation", "subscription":
+		return nil, fmt.Errorf("%s operations are not supported", t.value)
+	case "fragment":
+		return nil, fmt.Errorf("fragments are not supported")
+	default:
+		return nil, p.errorf(t, "expected operation")
+	}
+
+	if p.peek().kind == tokName {
+		op.Name = p.next().value
+	}
+	if p.isPunct("(") {
+		vars, err := p.parseVariableDefinitions()
+		if err != nil {
+			return nil, err
+		}
+		op.Variables = vars
+	}
+	if p.isPunct("@") {
+		return nil, fmt.Errorf("directives are not supported")
+	}
+	sel, err := p.parseSelectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.SelectionSet = sel
+	return op, nil
+}
+
+func (p *parser) parseVariableDefinitions() ([]VariableDefinition, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var defs []VariableDefinition
+	for !p.isPunct(")") {
+		if err := p.expect("$"); err != nil {
+			return nil, err
+		}
+		name := p.next()
+		if name.kind != tokName {
+			return nil, p.errorf(name, "expected variable name")
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		typ, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		def := VariableDefinition{Name: name.value, Type: typ}
+		if p.isPunct("=") {
+			p.next()
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			def.Default = value
+		}
+		defs = append(defs, def)
+	}
+	p.next()
+	return defs, nil
+}
+
+func (p *parser) parseType() (string, error) {
+	var typ string
+	if p.isPunct("[") {
+		p.next()
+		inner, err := p.parseType()
+		if err != nil {
+			return "", err
+		}
+		if err := p.expect("]"); err != nil {
+			return "", err
+		}
+		typ = "[" + inner + "]"
+	} else {
+		t := p.next()
+		if t.kind != tokName {
+			return "", p.errorf(t, "expected type")
+		}
+		typ = t.value
+	}
+	if p.isPunct("!") {
+		p.next()
+		typ += "!"
+	}
+	return typ, nil
+}
+
+func (p *parser) parseSelectionSet() ([]*Field, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var fields []*Field
+	for !p.isPunct("}") {
+		if p.isPunct("...") {
+			return nil, fmt.Errorf("fragments are not supported")
+		}
+		field, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+	p.next()
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("selection set must not be empty")
+	}
+	return fields, nil
+}
+
+func (p *parser) parseField() (*Field, error) {
+	t := p.next()
+	if t.kind != tokName {
+		return nil, p.errorf(t, "expected field name")
+	}
+	field := &Field{Name: t.value}
+	if p.isPunct(":") {
+		p.next()
+		name := p.next()
+		if name.kind != tokName {
+			return nil, p.errorf(name, "expected field name")
+		}
+		field.Alias = field.Name
+		field.Name = name.value
+	}
+	if p.isPunct("(") {
+		p.next()
+		field.Arguments = make(map[string]interface{})
+		for !p.isPunct(")") {
+			name := p.next()
+			if name.kind != tokName {
+				return nil, p.errorf(name, "expected argument name")
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			field.Arguments[name.value] = value
+		}
+		p.next()
+	}
+	if p.isPunct("@") {
+		return nil, fmt.Errorf("directives are not supported")
+	}
+	if p.isPunct("{") {
+		sel, err := p.parseSelectionSet()
+		if err != nil {
+			return nil, err
+		}
+		field.SelectionSet = sel
+	}
+	return field, nil
+}
+
+func (p *parser) parseValue() (interface{}, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString:
+		return t.value, nil
+	case tokInt:
+		n, err := strconv.ParseInt(t.value, 10, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid integer")
+		}
+		return n, nil
+	case tokFloat:
+		f, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid float")
+		}
+		return f, nil
+	case tokName:
+		switch t.value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return enumValue(t.value), nil
+	case tokPunct:
+		switch t.value {
+		case "$":
+			name := p.next()
+			if name.kind != tokName {
+				return nil, p.errorf(name, "expected variable name")
+			}
+			return variableRef(name.value), nil
+		case "[":
+			var list []interface{}
+			for !p.isPunct("]") {
+				value, err := p.parseValue()
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, value)
+			}
+			p.next()
+			return list, nil
+		case "{":
+			obj := make(map[string]interface{})
+			for !p.isPunct("}") {
+				name := p.next()
+				if name.kind != tokName {
+					return nil, p.errorf(name, "expected object field name")
+				}
+				if err := p.expect(":"); err != nil {
+					return nil, err
+				}
+				value, err := p.parseValue()
+				if err != nil {
+					return nil, err
+				}
+				obj[name.value] = value
+			}
+			p.next()
+			return obj, nil
+		}
+	}
+	return nil, p.errorf(t, "expected value")
+}
@@ -0,0 +1,124 @@
+package graphql
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		name, src string
+		want      []*Operation
+	}{
+		{
+			name: "shorthand",
+			src:  `{ page { html } }`,
+			want: []*Operation{{Type: "query", SelectionSet: []*Field{
+				{Name: "page", SelectionSet: []*Field{{Name: "html"}}},
+			}}},
+		},
+		{
+			name: "named with variables and defaults",
+			src:  `query Render($route: String!, $lang: String = "en", $ids: [Int!]) { render(route: $route, lang: $lang) }`,
+			want: []*Operation{{
+				Type: "query",
+				Name: "Render",
+				Variables: []VariableDefinition{
+					{Name: "route", Type: "String!"},
+					{Name: "lang", Type: "String", Default: "en"},
+					{Name: "ids", Type: "[Int!]"},
+				},
+				SelectionSet: []*Field{{Name: "render", Arguments: map[string]interface{}{
+					"route": variableRef("route"),
+					"lang":  variableRef("lang"),
+				}}},
+			}},
+		},
+		{
+			name: "aliases",
+			src:  `{ home: page(route: "home") { html } about: page(route: "about") { html } }`,
+			want: []*Operation{{Type: "query", SelectionSet: []*Field{
+				{Alias: "home", Name: "page", Arguments: map[string]interface{}{"route": "home"}, SelectionSet: []*Field{{Name: "html"}}},
+				{Alias: "about", Name: "page", Arguments: map[string]interface{}{"route": "about"}, SelectionSet: []*Field{{Name: "html"}}},
+			}}},
+		},
+		{
+			name: "argument values",
+			src: `{ f(s: "a\"b", block: """  multi
+line  """, i: -42, x: 1.5e3, t: true, n: null, e: DRAFT, l: [1, "two", [3]], o: {k: "v", nested: {b: false}}) }`,
+			want: []*Operation{{Type: "query", SelectionSet: []*Field{{Name: "f", Arguments: map[string]interface{}{
+				"s":     `a"b`,
+				"block": "multi\nline",
+				"i":     int64(-42),
+				"x":     1500.0,
+				"t":     true,
+				"n":     nil,
+				"e":     enumValue("DRAFT"),
+				"l":     []interface{}{int64(1), "two", []interface{}{int64(3)}},
+				"o":     map[string]interface{}{"k": "v", "nested": map[string]interface{}{"b": false}},
+			}}}}},
+		},
+		{
+			name: "comments, commas, and a byte order mark",
+			src:  "\ufeff# Pages\n{ a, b # trailing\n , c }",
+			want: []*Operation{{Type: "query", SelectionSet: []*Field{{Name: "a"}, {Name: "b"}, {Name: "c"}}}},
+		},
+		{
+			name: "several operations",
+			src:  `query A { a } query B { b }`,
+			want: []*Operation{
+				{Type: "query", Name: "A", SelectionSet: []*Field{{Name: "a"}}},
+				{Type: "query", Name: "B", SelectionSet: []*Field{{Name: "b"}}},
+			},
+		},
+	} {
+		doc, err := Parse(tc.src)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(doc.Operations, tc.want) {
+			t.Errorf("%s: got %s, want %s", tc.name, dump(doc.Operations), dump(tc.want))
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		src, want string
+	}{
+		{``, "does not contain any operations"},
+		{`# only a comment`, "does not contain any operations"},
+		{`mutation { swap }`, "mutation operations are not supported"},
+		{`subscription { pages }`, "subscription operations are not supported"},
+		{`fragment F on Page { html }`, "fragments are not supported"},
+		{`{ page { ...F } }`, "fragments are not supported"},
+		{`query @cached { a }`, "directives are not supported"},
+		{`{ a @skip(if: true) }`, "directives are not supported"},
+		{`{ }`, "selection set must not be empty"},
+		{`{ a`, `expected field name, found "end of document"`},
+		{`{ a(x: ) }`, "expected value"},
+		{`{ a(x: "unterminated) }`, "unterminated string"},
+		{"{ a(x: \"line\nbreak\") }", "unterminated string"},
+		{`{ a(x: """open) }`, "unterminated block string"},
+		{`{ a(x: 1.2.3) }`, "invalid float"},
+		{`{ a(x: 99999999999999999999) }`, "invalid integer"},
+		{`query ($: Int) { a }`, "expected variable name"},
+		{`query ($x Int) { a }`, `expected ":"`},
+		{`{ a ^ }`, "unexpected character '^'"},
+		{`page { a }`, "expected operation"},
+	} {
+		_, err := Parse(tc.src)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Parse(%q) = %v, want an error containing %q", tc.src, err, tc.want)
+		}
+	}
+}
+
+// dump formats operations for failure messages
+func dump(ops []*Operation) string {
+	b, _ := json.Marshal(ops)
+	return string(b)
+}
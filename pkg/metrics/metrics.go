@@ -0,0 +1,272 @@
+// Package metrics is a small Prometheus instrumentation library: counters,
+// histograms, and gauges with labels, served in the Prometheus text
+// exposition format, or in OpenMetrics to scrapers that ask for it. Only
+// OpenMetrics carries exemplars: the trace ID of a recent observation in
+// each histogram bucket.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets suit durations in seconds, from 5ms to a few minutes
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// Registry holds metrics and serves them to Prometheus
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// metric is anything the registry can expose, in OpenMetrics when om is set
+type metric interface {
+	write(w io.Writer, om bool)
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// ServeHTTP writes every metric in the text exposition format, or in
+// OpenMetrics when the scraper accepts it
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	om := strings.Contains(req.Header.Get("Accept"), "application/openmetrics-text")
+	if om {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	}
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+	for _, m := range metrics {
+		m.write(w, om)
+	}
+	if om {
+		fmt.Fprint(w, "# EOF\n")
+	}
+}
+
+// desc is a metric's name, help text, and label names
+type desc struct {
+	name, help string
+	labels     []string
+}
+
+// header writes the HELP and TYPE lines. OpenMetrics names a counter's
+// family without its _total suffix.
+func (d desc) header(w io.Writer, kind string, om bool) {
+	name, help := d.name, escapeHelp(d.help)
+	if om {
+		name, help = strings.TrimSuffix(name, "_total"), labelEscaper.Replace(d.help)
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// key joins label values into a map key
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labelPairs formats the labels for the series key, with extra pairs appended
+func (d desc) labelPairs(key string, extra ...string) string {
+	var pairs []string
+	if len(d.labels) > 0 {
+		for i, v := range strings.Split(key, "\xff") {
+			pairs = append(pairs, d.labels[i]+`="`+escapeLabel(v)+`"`)
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Counter is a labeled counter
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// Counter registers a counter
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{desc: desc{name, help, labels}, values: map[string]float64{}}
+	r.register(c)
+	return c
+}
+
+// Add adds v (at least 0) to the series with the label values
+func (c *Counter) Add(v float64, labelValues ...string) {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] += v
+}
+
+// Inc adds one to the series with the label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) write(w io.Writer, om bool) {
+	c.header(w, "counter", om)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(key), formatFloat(c.values[key]))
+	}
+}
+
+// Histogram is a labeled histogram
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts    []uint64 // Per bucket, not cumulative
+	count     uint64
+	sum       float64
+	exemplars []*exemplar // Latest per bucket, +Inf last; nil until observed
+}
+
+// exemplar is an observation linked to the trace it was made in
+type exemplar struct {
+	traceID string
+	value   float64
+	time    time.Time
+}
+
+// Histogram registers a histogram with the upper bounds buckets
+// (DefaultBuckets when nil)
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	h := &Histogram{desc: desc{name, help, labels}, buckets: buckets, series: map[string]*histogramSeries{}}
+	r.register(h)
+	return h
+}
+
+// Observe records v in the series with the label values
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.ObserveWithTrace(v, "", labelValues...)
+}
+
+// ObserveWithTrace records v like Observe and, with a trace ID, keeps it as
+// the exemplar of v's bucket, so a slow bucket links to a trace of it
+func (h *Histogram) ObserveWithTrace(v float64, traceID string, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	i := sort.SearchFloat64s(h.buckets, v)
+	if i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+	if traceID != "" {
+		if s.exemplars == nil {
+			s.exemplars = make([]*exemplar, len(h.buckets)+1)
+		}
+		s.exemplars[i] = &exemplar{traceID: traceID, value: v, time: time.Now()}
+	}
+}
+
+func (h *Histogram) write(w io.Writer, om bool) {
+	h.header(w, "histogram", om)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		// Exemplars are only part of OpenMetrics
+		ex := func(i int) string {
+			if !om || s.exemplars == nil || s.exemplars[i] == nil {
+				return ""
+			}
+			e := s.exemplars[i]
+			return fmt.Sprintf(` # {trace_id="%s"} %s %.3f`, escapeLabel(e.traceID), formatFloat(e.value), float64(e.time.UnixMilli())/1000)
+		}
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d%s\n", h.name, h.labelPairs(key, "le", formatFloat(bound)), cumulative, ex(i))
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d%s\n", h.name, h.labelPairs(key, "le", "+Inf"), s.count, ex(len(h.buckets)))
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(key), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(key), s.count)
+	}
+}
+
+// gaugeFunc is a gauge read when the metrics are scraped
+type gaugeFunc struct {
+	desc
+	fn func() float64
+}
+
+// GaugeFunc registers a gauge whose value fn returns at scrape time
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(&gaugeFunc{desc: desc{name: name, help: help}, fn: fn})
+}
+
+func (g *gaugeFunc) write(w io.Writer, om bool) {
+	g.header(w, "gauge", om)
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.fn()))
+}
+
+// sortedKeys returns the map's keys in order, so output is stable
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExposition(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("test_requests_total", "Requests by code.", "code")
+	h := r.Histogram("test_duration_seconds", "Durations.", []float64{0.1, 1}, "route")
+	r.GaugeFunc("test_up", "Whether it is up.", func() float64 { return 1 })
+
+	c.Inc("200")
+	c.Inc("200")
+	c.Add(3, `we"ird`)
+	h.Observe(0.05, "home")
+	h.Observe(0.5, "home")
+	h.Observe(5, "home")
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	got := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE test_requests_total counter\n",
+		`test_requests_total{code="200"} 2` + "\n",
+		`test_requests_total{code="we\"ird"} 3` + "\n",
+		"# TYPE test_duration_seconds histogram\n",
+		`test_duration_seconds_bucket{route="home",le="0.1"} 1` + "\n",
+		`test_duration_seconds_bucket{route="home",le="1"} 2` + "\n",
+		`test_duration_seconds_bucket{route="home",le="+Inf"} 3` + "\n",
+		`test_duration_seconds_sum{route="home"} 5.55` + "\n",
+		`test_duration_seconds_count{route="home"} 3` + "\n",
+		"# TYPE test_up gauge\ntest_up 1\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+func TestWrongLabelCountPanics(t *testing.T) {
+	c := NewRegistry().Counter("test_total", "Test.", "a", "b")
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic for a missing label value")
+		}
+	}()
+	c.Inc("only-one")
+}
+
+func TestExemplars(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("test_requests_total", "Requests.")
+	h := r.Histogram("test_duration_seconds", "Durations.", []float64{0.1, 1}, "route")
+	c.Inc()
+	h.ObserveWithTrace(0.05, "4bf92f3577b34da6a3ce929d0e0e4736", "home")
+	h.ObserveWithTrace(0.5, "", "home")
+	h.ObserveWithTrace(5, "0af7651916cd43dd8448eb211c80319c", "home")
+
+	for _, tc := range []struct {
+		accept, contentType string
+		want, unwanted      []string
+	}{
+		{
+			accept:      "text/plain",
+			contentType: "text/plain; version=0.0.4",
+			want: []string{
+				"# TYPE test_requests_total counter\n",
+				`test_duration_seconds_bucket{route="home",le="0.1"} 1` + "\n",
+			},
+			unwanted: []string{"trace_id", "# EOF"},
+		},
+		{
+			accept:      "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5",
+			contentType: "application/openmetrics-text; version=1.0.0",
+			want: []string{
+				"# TYPE test_requests counter\n",
+				"test_requests_total 1\n",
+				`test_duration_seconds_bucket{route="home",le="0.1"} 1 # {trace_id="4bf92f3577b34da6a3ce929d0e0e4736"} 0.05 `,
+				`test_duration_seconds_bucket{route="home",le="1"} 2` + "\n",
+				`test_duration_seconds_bucket{route="home",le="+Inf"} 3 # {trace_id="0af7651916cd43dd8448eb211c80319c"} 5 `,
+			},
+		},
+	} {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		req.Header.Set("Accept", tc.accept)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		got := rec.Body.String()
+		for _, want := range tc.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("Accept %q: missing %q in:\n%s", tc.accept, want, got)
+			}
+		}
+		for _, unwanted := range tc.unwanted {
+			if strings.Contains(got, unwanted) {
+				t.Errorf("Accept %q: unexpected %q in:\n%s", tc.accept, unwanted, got)
+			}
+		}
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, tc.contentType) {
+			t.Errorf("Accept %q: Content-Type = %q", tc.accept, ct)
+		}
+		if strings.HasPrefix(tc.contentType, "application/openmetrics-text") && !strings.HasSuffix(got, "# EOF\n") {
+			t.Errorf("OpenMetrics output does not end with # EOF:\n%s", got)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAuth(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("hashed-secret"), bcrypt.MinCost)
+	h := Auth(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), AuthOptions{
+		Users:  map[string]string{"alice": "plain-secret", "bob": string(hash)},
+		Token:  "shared-token",
+		Exempt: []string{"/healthz", "/admin/"},
+	})
+
+	for _, tc := range []struct {
+		path, user, password, bearer string
+		want                         int
+	}{
+		{"/", "", "", "", http.StatusUnauthorized},
+		{"/", "alice", "plain-secret", "", http.StatusOK},
+		{"/", "alice", "wrong", "", http.StatusUnauthorized},
+		{"/", "bob", "hashed-secret", "", http.StatusOK},
+		{"/", "mallory", "plain-secret", "", http.StatusUnauthorized},
+		{"/about", "", "", "shared-token", http.StatusOK},
+		{"/about", "", "", "shared-tokenX", http.StatusUnauthorized},
+		{"/healthz", "", "", "", http.StatusOK},
+		{"/healthz/deep", "", "", "", http.StatusUnauthorized},
+		{"/admin/info", "", "", "admin-token", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.user != "" {
+			req.SetBasicAuth(tc.user, tc.password)
+		}
+		if tc.bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.bearer)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s as %q/%q: %d, want %d", tc.path, tc.user, tc.bearer, rec.Code, tc.want)
+		}
+		if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: no WWW-Authenticate challenge", tc.path)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultCompressTypes are the content types compressed when none are configured
+var DefaultCompressTypes = []string{
+	"text/html", "text/css", "text/plain", "text/xml", "text/calendar", "text/javascript",
+	"application/javascript", "application/json", "application/xml",
+	"application/rss+xml", "application/atom+xml", "image/svg+xml",
+}
+
+// CompressOptions configure Compress
+type CompressOptions struct {
+	// Level is the gzip level, 1 (fastest) to 9 (smallest); 0 uses the default
+	Level int
+	// MinSize skips responses with a known length below this many bytes
+	MinSize int
+	// Types are the compressed content types (DefaultCompressTypes when empty).
+	// An entry ending in "/" matches a whole family, e.g. "text/".
+	Types []string
+}
+
+// Compress gzips responses of the configured content types for clients that
+// accept it. Streamed pages stay streamed: every flush of the handler flushes
+// the compressed data written so far, so the browser renders as the model
+// writes. Responses that are already encoded (such as precompressed static
+// files, see ServeFile) and range requests are passed through.
+func Compress(next http.Handler, opts CompressOptions) http.Handler {
+	if opts.Level == 0 {
+		opts.Level = gzip.DefaultCompression
+	}
+	if len(opts.Types) == 0 {
+		opts.Types = DefaultCompressTypes
+	}
+	pool := &sync.Pool{New: func() interface{} {
+		gz, _ := gzip.NewWriterLevel(nil, opts.Level)
+		return gz
+	}}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, r: r, opts: &opts, pool: pool}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter decides whether to compress when the headers are written
+type compressWriter struct {
+	http.ResponseWriter
+	r    *http.Request
+	opts *CompressOptions
+	pool *sync.Pool
+
+	wroteHeader bool
+	gz          *gzip.Writer // Set when the response is compressed
+}
+
+// WriteHeader implements http.ResponseWriter
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	h := cw.Header()
+	addVary(h)
+	// The Accept-Encoding check happens now rather than up front, so route
+	// rules seen along the way can still opt the response out
+	if cw.compressible(status) {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		cw.gz = cw.pool.Get().(*gzip.Writer)
+		cw.gz.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+// compressible reports whether the response being started is compressed
+func (cw *compressWriter) compressible(status int) bool {
+	h := cw.Header()
+	if status < 200 || status == http.StatusNoContent || status == http.StatusNotModified || status == http.StatusPartialContent {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" || !AcceptsEncoding(cw.r, "gzip") {
+		return false
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < cw.opts.MinSize {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	if mediaType == "" {
+		return false
+	}
+	for _, t := range cw.opts.Types {
+		if mediaType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Write implements http.ResponseWriter
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.gz != nil {
+		return cw.gz.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher: compressed data written so far is sent
+// right away, so streamed pages keep streaming
+func (cw *compressWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.gz != nil {
+		cw.gz.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Close finishes the compressed stream
+func (cw *compressWriter) Close() {
+	if cw.gz == nil {
+		return
+	}
+	cw.gz.Close()
+	cw.gz.Reset(nil)
+	cw.pool.Put(cw.gz)
+	cw.gz = nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying connection
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// AcceptsEncoding reports whether r accepts the content coding, honoring
+// q=0 refusals and the "*" wildcard
+func AcceptsEncoding(r *http.Request, coding string) bool {
+	wildcard := false
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		refused := false
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			v, err := strconv.ParseFloat(q, 64)
+			refused = err == nil && v == 0
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case coding:
+			return !refused
+		case "*":
+			wildcard = !refused
+		}
+	}
+	return wildcard
+}
+
+// addVary marks the response as depending on Accept-Encoding, once
+func addVary(h http.Header) {
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(name), "Accept-Encoding") {
+				return
+			}
+		}
+	}
+	h.Add("Vary", "Accept-Encoding")
+}
+
+// precompressed are the sibling files ServeFile looks for, best first
+var precompressed = []struct{ coding, ext string }{{"br", ".br"}, {"gzip", ".gz"}}
+
+// ServeFile serves the file at path like http.ServeFile, preferring a
+// precompressed sibling (style.css.br or style.css.gz) when the client
+// accepts its encoding. Brotli is only served this way: static files can be
+// compressed with it ahead of time, at the highest levels.
+func ServeFile(w http.ResponseWriter, r *http.Request, path string) {
+	for _, pc := range precompressed {
+		if !AcceptsEncoding(r, pc.coding) {
+			continue
+		}
+		f, err := os.Open(path + pc.ext)
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil || info.IsDir() {
+			continue
+		}
+		ctype := mime.TypeByExtension(filepath.Ext(path))
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+		h := w.Header()
+		h.Set("Content-Type", ctype)
+		h.Set("Content-Encoding", pc.coding)
+		addVary(h)
+		http.ServeContent(w, r, filepath.Base(path), info.ModTime(), f)
+		return
+	}
+	http.ServeFile(w, r, path)
+}
@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompressStreams(t *testing.T) {
+	chunks := make(chan string)
+	h := Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		for chunk := range chunks {
+			io.WriteString(w, chunk)
+			w.(http.Flusher).Flush()
+		}
+	}), CompressOptions{})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	done := make(chan *http.Response)
+	go func() {
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- resp
+	}()
+	chunks <- "<h1>First</h1>\n"
+	resp := <-done
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q", resp.Header.Get("Content-Encoding"))
+	}
+
+	// The first chunk arrives before the handler finishes
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(zr).ReadString('\n')
+	if err != nil || line != "<h1>First</h1>\n" {
+		t.Fatalf("first chunk = %q, %v", line, err)
+	}
+	close(chunks)
+}
+
+func TestCompressNegotiates(t *testing.T) {
+	h := Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+		io.WriteString(w, strings.Repeat("MuseWeb ", 200))
+	}), CompressOptions{Types: []string{"text/"}})
+
+	for _, tc := range []struct {
+		accept, ctype string
+		want          bool
+	}{
+		{"gzip, br", "text/css", true},
+		{"br;q=1.0, *", "text/plain", true},
+		{"gzip;q=0, *", "text/plain", false},
+		{"", "text/html", false},
+		{"gzip", "image/png", false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/?type="+tc.ctype, nil)
+		req.Header.Set("Accept-Encoding", tc.accept)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if got := rec.Header().Get("Content-Encoding") == "gzip"; got != tc.want {
+			t.Errorf("%q %s: compressed %v, want %v", tc.accept, tc.ctype, got, tc.want)
+		}
+		if rec.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("%q %s: Vary = %q", tc.accept, tc.ctype, rec.Header().Get("Vary"))
+		}
+	}
+}
+
+func TestServeFilePrecompressed(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "style.css")
+	os.WriteFile(path, []byte("body{}"), 0o644)
+	os.WriteFile(path+".br", []byte("brotli bytes"), 0o644)
+
+	serve := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/style.css", nil)
+		req.Header.Set("Accept-Encoding", accept)
+		rec := httptest.NewRecorder()
+		Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { ServeFile(w, r, path) }), CompressOptions{}).ServeHTTP(rec, req)
+		return rec
+	}
+	br := serve("gzip, br")
+	if br.Header().Get("Content-Encoding") != "br" || br.Body.String() != "brotli bytes" ||
+		!strings.HasPrefix(br.Header().Get("Content-Type"), "text/css") {
+		t.Errorf("br: %v %q", br.Header(), br.Body.String())
+	}
+	if plain := serve(""); plain.Header().Get("Content-Encoding") != "" || plain.Body.String() != "body{}" {
+		t.Errorf("plain: %v %q", plain.Header(), plain.Body.String())
+	}
+}
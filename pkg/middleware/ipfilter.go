@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// IPFilterOptions configure IPFilter
+type IPFilterOptions struct {
+	// Allow, when set, admits only clients in these networks
+	Allow Networks
+	// Deny turns clients in these networks away, even allowed ones
+	Deny Networks
+	// Country, when set, returns the ISO 3166 country code of a client, e.g.
+	// from a GeoIP database or with CountryHeader; "" when unknown
+	Country func(r *http.Request, ip net.IP) string
+	// AllowCountries, when set, admits only clients from these countries
+	// (unknown countries included); DenyCountries turns clients away
+	AllowCountries []string
+	DenyCountries  []string
+	// Reject answers turned-away requests (a plain 403 when nil)
+	Reject func(w http.ResponseWriter, r *http.Request)
+}
+
+// IPFilter turns away the clients opts does not admit before next sees them
+func IPFilter(next http.Handler, opts IPFilterOptions) http.Handler {
+	reject := opts.Reject
+	if reject == nil {
+		reject = func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !opts.admits(r) {
+			reject(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// admits reports whether r's client may use the site; clients whose address
+// is unknown (such as Unix socket peers) are only checked by country
+func (o IPFilterOptions) admits(r *http.Request) bool {
+	ip := net.ParseIP(peerIP(r))
+	if ip != nil {
+		if o.Deny.Contains(ip) || len(o.Allow) > 0 && !o.Allow.Contains(ip) {
+			return false
+		}
+	}
+	if o.Country == nil || len(o.AllowCountries) == 0 && len(o.DenyCountries) == 0 {
+		return true
+	}
+	country := o.Country(r, ip)
+	if containsFold(o.DenyCountries, country) {
+		return false
+	}
+	return len(o.AllowCountries) == 0 || containsFold(o.AllowCountries, country)
+}
+
+// CountryHeader returns a Country function reading a country code set by a
+// CDN or proxy, such as Cloudflare's CF-IPCountry. The header is only
+// believed on requests from the trusted proxies (directly, or as found by
+// RealIP), as clients could send it themselves otherwise.
+func CountryHeader(name string, proxies TrustedProxies) func(*http.Request, net.IP) string {
+	return func(r *http.Request, _ net.IP) string {
+		peer, _, err := net.SplitHostPort(r.RemoteAddr)
+		if !Proxied(r) && (err != nil || !proxies.trusts(peer)) {
+			return ""
+		}
+		return strings.TrimSpace(r.Header.Get(name))
+	}
+}
+
+// containsFold reports whether list holds s, ignoring case
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if s != "" && strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
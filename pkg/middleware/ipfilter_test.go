@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPFilter(t *testing.T) {
+	mustParse := func(list ...string) Networks {
+		n, err := ParseNetworks(list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	proxies, _ := ParseTrustedProxies([]string{"10.0.0.1"})
+	h := RealIP(IPFilter(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), IPFilterOptions{
+		Allow:         mustParse("192.0.2.0/24", "2001:db8::/32", "10.0.0.1"),
+		Deny:          mustParse("192.0.2.66"),
+		Country:       CountryHeader("CF-IPCountry", proxies),
+		DenyCountries: []string{"xx"},
+	}), proxies)
+
+	for _, tc := range []struct {
+		remote, forwarded, country string
+		want                       int
+	}{
+		{"192.0.2.1:1234", "", "", http.StatusOK},
+		{"[2001:db8::1]:1234", "", "", http.StatusOK},
+		{"198.51.100.1:1234", "", "", http.StatusForbidden},
+		{"192.0.2.66:1234", "", "", http.StatusForbidden},
+		{"10.0.0.1:1234", "192.0.2.7", "", http.StatusOK},
+		{"10.0.0.1:1234", "198.51.100.1", "", http.StatusForbidden},
+		{"10.0.0.1:1234", "192.0.2.7", "XX", http.StatusForbidden},
+		{"192.0.2.1:1234", "", "XX", http.StatusOK}, // Not from the proxy, so not believed
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.remote
+		if tc.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tc.forwarded)
+		}
+		if tc.country != "" {
+			req.Header.Set("CF-IPCountry", tc.country)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s (forwarded for %q, country %q): %d, want %d", tc.remote, tc.forwarded, tc.country, rec.Code, tc.want)
+		}
+	}
+
+	if _, err := ParseNetworks([]string{"not-an-ip"}); err == nil {
+		t.Error("parsed an invalid address")
+	}
+}
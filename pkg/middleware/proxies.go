@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Networks are IP addresses and CIDR ranges
+type Networks []*net.IPNet
+
+// ParseNetworks parses IP addresses and CIDR ranges
+func ParseNetworks(list []string) (Networks, error) {
+	var networks Networks
+	for _, entry := range list {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			entry = fmt.Sprintf("%s/%d", entry, bits)
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %w", entry, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Contains reports whether ip belongs to one of the networks
+func (n Networks) Contains(ip net.IP) bool {
+	for _, network := range n {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// TrustedProxies are the networks of reverse proxies whose forwarding
+// headers name the real client
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses IP addresses and CIDR ranges
+func ParseTrustedProxies(list []string) (TrustedProxies, error) {
+	networks, err := ParseNetworks(list)
+	return TrustedProxies(networks), err
+}
+
+// trusts reports whether ip belongs to a trusted proxy
+func (t TrustedProxies) trusts(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && Networks(t).Contains(parsed)
+}
+
+// ClientIP returns the IP address r comes from. When the connection comes
+// from a trusted proxy, X-Forwarded-For is followed back from the right past
+// the trusted proxies in it, or X-Real-IP is used when there is none.
+func (t TrustedProxies) ClientIP(r *http.Request) string {
+	ip := peerIP(r)
+	if !t.trusts(ip) {
+		return ip
+	}
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break // Garbage; trust nothing further left
+			}
+			ip = hop
+			if !t.trusts(hop) {
+				break
+			}
+		}
+		return ip
+	}
+	if real := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(real) != nil {
+		return real
+	}
+	return ip
+}
+
+// peerIP returns the address of r's peer: the client itself, or the one
+// RealIP found behind a trusted proxy
+func peerIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// proxiedKey marks requests RealIP took from a trusted proxy
+type proxiedKey struct{}
+
+// RealIP makes r.RemoteAddr the client's address when the request comes
+// from one of the trusted proxies, so the logs, limits, and filters further
+// in see the visitor rather than the proxy
+func RealIP(next http.Handler, proxies TrustedProxies) http.Handler {
+	if len(proxies) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer, port, err := net.SplitHostPort(r.RemoteAddr)
+		if err == nil && proxies.trusts(peer) {
+			client := proxies.ClientIP(r)
+			r = r.WithContext(context.WithValue(r.Context(), proxiedKey{}, peer))
+			r.RemoteAddr = net.JoinHostPort(client, port)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Proxied reports whether RealIP found that r came from a trusted proxy
+func Proxied(r *http.Request) bool {
+	return r.Context().Value(proxiedKey{}) != nil
+}
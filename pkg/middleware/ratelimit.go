@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit caps what one client may ask for; zero fields are unlimited
+type RateLimit struct {
+	// PerMinute is the sustained number of requests per minute
+	PerMinute int
+	// Burst is how many requests may come at once after a quiet spell
+	// (PerMinute when 0)
+	Burst int
+	// Streams caps the requests of one client in progress at once
+	Streams int
+}
+
+// RateLimitOptions configure a RateLimiter
+type RateLimitOptions struct {
+	// Default applies to every client not identified by a key in Keys
+	Default RateLimit
+	// Keys are API keys with limits of their own, sent as a Bearer token or
+	// in an X-API-Key header. Requests with other keys count against the
+	// client's IP address, so made-up keys do not escape the limits.
+	Keys map[string]RateLimit
+	// Reject answers requests over the limit (with Retry-After already set);
+	// a plain 429 when nil
+	Reject func(w http.ResponseWriter, r *http.Request)
+}
+
+// RateLimiter limits requests per client with token buckets, and the
+// requests each client has in progress
+type RateLimiter struct {
+	opts RateLimitOptions
+
+	mu      sync.Mutex
+	clients map[string]*client
+	swept   time.Time
+	now     func() time.Time
+}
+
+// client is the state of one IP address or API key
+type client struct {
+	tokens  float64
+	updated time.Time
+	streams int
+}
+
+// NewRateLimiter creates a limiter for opts
+func NewRateLimiter(opts RateLimitOptions) *RateLimiter {
+	return &RateLimiter{opts: opts, clients: make(map[string]*client), now: time.Now}
+}
+
+// Middleware limits the requests reaching next
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, limit := l.identify(r)
+		wait, ok := l.acquire(id, limit)
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			if l.opts.Reject != nil {
+				l.opts.Reject(w, r)
+			} else {
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			}
+			return
+		}
+		defer l.release(id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// identify returns who r comes from and the limits that apply to them
+func (l *RateLimiter) identify(r *http.Request) (string, RateLimit) {
+	key := r.Header.Get("X-API-Key")
+	if auth := r.Header.Get("Authorization"); key == "" && strings.HasPrefix(auth, "Bearer ") {
+		key = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if limit, ok := l.opts.Keys[key]; ok && key != "" {
+		return "key:" + key, limit
+	}
+	return "ip:" + peerIP(r), l.opts.Default
+}
+
+// acquire takes a token and a stream slot for id. When the client is over
+// its limits it returns false and how long to wait before trying again.
+func (l *RateLimiter) acquire(id string, limit RateLimit) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+
+	c := l.clients[id]
+	burst := float64(limit.Burst)
+	if burst == 0 {
+		burst = float64(limit.PerMinute)
+	}
+	if c == nil {
+		c = &client{tokens: burst, updated: now}
+		l.clients[id] = c
+	}
+	if limit.Streams > 0 && c.streams >= limit.Streams {
+		return time.Second, false
+	}
+	if limit.PerMinute > 0 {
+		rate := float64(limit.PerMinute) / 60 // Tokens per second
+		c.tokens = math.Min(burst, c.tokens+now.Sub(c.updated).Seconds()*rate)
+		c.updated = now
+		if c.tokens < 1 {
+			return time.Duration((1 - c.tokens) / rate * float64(time.Second)), false
+		}
+		c.tokens--
+	}
+	c.streams++
+	return 0, true
+}
+
+// release frees the stream slot of a finished request
+func (l *RateLimiter) release(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c := l.clients[id]; c != nil {
+		c.streams--
+	}
+}
+
+// sweep forgets, at most once a minute, the clients that have no request in
+// progress and have been quiet for long enough to have a full bucket again.
+// Callers hold l.mu.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	l.swept = now
+	for id, c := range l.clients {
+		if c.streams == 0 && now.Sub(c.updated) > 10*time.Minute {
+			delete(l.clients, id)
+		}
+	}
+}
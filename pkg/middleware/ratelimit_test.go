@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterBuckets(t *testing.T) {
+	l := NewRateLimiter(RateLimitOptions{
+		Default: RateLimit{PerMinute: 60, Burst: 2},
+		Keys:    map[string]RateLimit{"partner": {PerMinute: 600, Burst: 5}},
+	})
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	h := l.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	get := func(remote, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remote + ":1234"
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := range 2 {
+		if rec := get("192.0.2.1", ""); rec.Code != http.StatusOK {
+			t.Fatalf("request %d within the burst: %d", i, rec.Code)
+		}
+	}
+	rec := get("192.0.2.1", "made-up")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("request over the burst: %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := get("192.0.2.2", ""); rec.Code != http.StatusOK {
+		t.Errorf("other client limited: %d", rec.Code)
+	}
+	for i := range 5 {
+		if rec := get("192.0.2.1", "partner"); rec.Code != http.StatusOK {
+			t.Fatalf("keyed request %d: %d", i, rec.Code)
+		}
+	}
+
+	// A token comes back every second
+	now = now.Add(time.Second)
+	if rec := get("192.0.2.1", ""); rec.Code != http.StatusOK {
+		t.Errorf("request after refill: %d", rec.Code)
+	}
+}
+
+func TestRateLimiterStreams(t *testing.T) {
+	entered, release := make(chan struct{}, 2), make(chan struct{})
+	h := NewRateLimiter(RateLimitOptions{Default: RateLimit{Streams: 1}}).Middleware(
+		http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			entered <- struct{}{}
+			<-release
+		}))
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		close(done)
+	}()
+	<-entered
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("second stream: %d", rec.Code)
+	}
+	close(release)
+	<-done
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("stream after the first ended: %d", rec.Code)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		remote, forwarded, real, want string
+	}{
+		{"203.0.113.9:1", "198.51.100.1", "", "203.0.113.9"},                   // Untrusted peer
+		{"10.1.2.3:1", "198.51.100.1", "", "198.51.100.1"},                     // One proxy
+		{"10.1.2.3:1", "6.6.6.6, 198.51.100.1, 192.0.2.7", "", "198.51.100.1"}, // Spoofed left part
+		{"10.1.2.3:1", "", "198.51.100.2", "198.51.100.2"},                     // X-Real-IP
+		{"10.1.2.3:1", "garbage", "", "10.1.2.3"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.remote
+		if tc.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tc.forwarded)
+		}
+		if tc.real != "" {
+			req.Header.Set("X-Real-IP", tc.real)
+		}
+		if got := proxies.ClientIP(req); got != tc.want {
+			t.Errorf("ClientIP(%s, XFF %q, X-Real-IP %q) = %s, want %s", tc.remote, tc.forwarded, tc.real, got, tc.want)
+		}
+	}
+	if _, err := ParseTrustedProxies([]string{"not-an-ip"}); err == nil {
+		t.Error("invalid proxy accepted")
+	}
+}
+
+func TestRealIP(t *testing.T) {
+	proxies, _ := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	var remote, country string
+	var proxied bool
+	h := RealIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote, proxied, country = r.RemoteAddr, Proxied(r), CountryHeader("CF-IPCountry", nil)(r, nil)
+	}), proxies)
+
+	for _, tc := range []struct {
+		peer, want, country string
+		proxied             bool
+	}{
+		{"10.1.2.3:4000", "198.51.100.1:4000", "NO", true},
+		{"203.0.113.9:4000", "203.0.113.9:4000", "", false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.peer
+		req.Header.Set("X-Forwarded-For", "198.51.100.1")
+		req.Header.Set("CF-IPCountry", "NO")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if remote != tc.want || proxied != tc.proxied || country != tc.country {
+			t.Errorf("from %s: RemoteAddr %s, proxied %v, country %q", tc.peer, remote, proxied, country)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+// Package middleware holds the HTTP middleware MuseWeb wraps its handlers in.
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/kekePower/museweb/pkg/errors"
+)
+
+// WrapHandler recovers from panics in h, logs them with a stack trace and
+// answers with a 500 error page, so one failing request never takes the
+// server down
+func WrapHandler(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+			log.Printf("💥 Panic serving %s: %v\n%s", r.URL.Path, rec, debug.Stack())
+			errors.InternalServerError(w, r, "Something went wrong while generating this page.")
+		}()
+		h(w, r)
+	}
+}
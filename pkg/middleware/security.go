@@ -0,0 +1,53 @@
+package middleware
+
+import "net/http"
+
+// SecurityOptions are the values of the security headers; an empty value
+// leaves its header out
+type SecurityOptions struct {
+	// HSTS is the Strict-Transport-Security header, sent on HTTPS requests only
+	HSTS string
+	// FrameOptions is the X-Frame-Options header, e.g. "DENY" or "SAMEORIGIN"
+	FrameOptions string
+	// ReferrerPolicy is the Referrer-Policy header
+	ReferrerPolicy string
+	// ContentSecurityPolicy is the Content-Security-Policy header
+	ContentSecurityPolicy string
+}
+
+// DefaultSecurity protects pages without breaking what models usually write:
+// the default policy allows scripts, styles, and images from anywhere (pages
+// often load them from CDNs) but no plugins, no foreign <base>, and no
+// framing by other sites
+var DefaultSecurity = SecurityOptions{
+	HSTS:                  "max-age=31536000",
+	FrameOptions:          "SAMEORIGIN",
+	ReferrerPolicy:        "strict-origin-when-cross-origin",
+	ContentSecurityPolicy: "base-uri 'self'; object-src 'none'; frame-ancestors 'self'",
+}
+
+// SecurityHeaders sets the security headers on every response of next. They
+// are set before next runs, so handlers and route rules can still replace them.
+func SecurityHeaders(next http.Handler, opts SecurityOptions) http.Handler {
+	headers := map[string]string{
+		"X-Frame-Options":         opts.FrameOptions,
+		"Referrer-Policy":         opts.ReferrerPolicy,
+		"Content-Security-Policy": opts.ContentSecurityPolicy,
+	}
+	for name, value := range headers {
+		if value == "" {
+			delete(headers, name)
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		for name, value := range headers {
+			h.Set(name, value)
+		}
+		// Browsers ignore HSTS over plain HTTP, where it could be forged
+		if opts.HSTS != "" && r.TLS != nil {
+			h.Set("Strict-Transport-Security", opts.HSTS)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
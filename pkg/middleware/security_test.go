@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	opts := DefaultSecurity
+	opts.ReferrerPolicy = "" // Left out
+	h := SecurityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/embed" {
+			w.Header().Set("X-Frame-Options", "ALLOWALL")
+		}
+	}), opts)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Header().Get("Content-Security-Policy"); got != DefaultSecurity.ContentSecurityPolicy {
+		t.Errorf("Content-Security-Policy = %q", got)
+	}
+	if rec.Header().Get("X-Frame-Options") != "SAMEORIGIN" || rec.Header().Get("Referrer-Policy") != "" {
+		t.Errorf("headers = %v", rec.Header())
+	}
+	if rec.Header().Get("Strict-Transport-Security") != "" {
+		t.Error("HSTS sent over plain HTTP")
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/embed", nil)
+	req.TLS = &tls.ConnectionState{}
+	h.ServeHTTP(rec, req)
+	if rec.Header().Get("Strict-Transport-Security") != DefaultSecurity.HSTS {
+		t.Errorf("HSTS over HTTPS = %q", rec.Header().Get("Strict-Transport-Security"))
+	}
+	if rec.Header().Get("X-Frame-Options") != "ALLOWALL" {
+		t.Error("handler could not replace a security header")
+	}
+}
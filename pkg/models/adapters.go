@@ -0,0 +1,318 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ResponseAdapter extracts page text from the streamed responses of one
+// family of OpenAI-compatible providers. Adapters are stateless and shared
+// between requests.
+type ResponseAdapter interface {
+	// Name identifies the adapter in config (model.response_adapter)
+	Name() string
+	// Parse returns the text carried by one SSE data payload, or "" when the
+	// event carries none (role announcements, finish reasons, citations, ...)
+	Parse(data string, debug bool) string
+}
+
+// ReasoningAdapter is implemented by response adapters whose provider streams
+// the model's reasoning in a field of its own, apart from the page text
+type ReasoningAdapter interface {
+	ResponseAdapter
+	// Reasoning returns the reasoning carried by one SSE data payload, or ""
+	Reasoning(data string) string
+}
+
+// AutoAdapter is the adapter name that tries every known format in turn
+const AutoAdapter = "auto"
+
+var (
+	adaptersMu sync.RWMutex
+	adapters   = map[string]ResponseAdapter{}
+)
+
+// RegisterAdapter makes a response adapter available by name, replacing any
+// adapter registered under the same name
+func RegisterAdapter(a ResponseAdapter) {
+	adaptersMu.Lock()
+	defer adaptersMu.Unlock()
+	adapters[a.Name()] = a
+}
+
+// LookupAdapter returns the adapter registered under name
+func LookupAdapter(name string) (ResponseAdapter, error) {
+	adaptersMu.RLock()
+	defer adaptersMu.RUnlock()
+	a, ok := adapters[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown response adapter %q (available: %s)", name, strings.Join(adapterNames(), ", "))
+	}
+	return a, nil
+}
+
+// adapterNames lists the registered adapters; callers hold adaptersMu
+func adapterNames() []string {
+	names := make([]string, 0, len(adapters))
+	for name := range adapters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// detectAdapter picks the adapter for apiBase when none is configured
+func detectAdapter(apiBase string) ResponseAdapter {
+	name := AutoAdapter
+	if strings.Contains(strings.ToLower(apiBase), "perplexity.ai") {
+		name = "perplexity"
+	} else if isMistral(apiBase) {
+		name = "mistral"
+	}
+	a, _ := LookupAdapter(name)
+	return a
+}
+
+// adapterFor returns the configured adapter, or the detected one when name is empty
+func adapterFor(name, apiBase string) ResponseAdapter {
+	if name != "" {
+		if a, err := LookupAdapter(name); err == nil {
+			return a
+		}
+		log.Printf("⚠️  Unknown response adapter %q, detecting one instead", name)
+	}
+	return detectAdapter(apiBase)
+}
+
+func init() {
+	RegisterAdapter(openAIAdapter{})
+	RegisterAdapter(geminiAdapter{})
+	RegisterAdapter(perplexityAdapter{})
+	RegisterAdapter(rawAdapter{})
+	RegisterAdapter(autoAdapter{})
+}
+
+// openAIMessage is a delta or complete message. DeepSeek, Fireworks,
+// SiliconFlow, and vLLM stream reasoning in reasoning_content; OpenRouter
+// calls it reasoning.
+type openAIMessage struct {
+	Content          string `json:"content"`
+	ReasoningContent string `json:"reasoning_content"`
+	Reasoning        string `json:"reasoning"`
+	// ToolCalls are the tools the model calls, streamed in pieces
+	ToolCalls []toolCallDelta `json:"tool_calls"`
+}
+
+// openAIChunk is the streaming chat completion format
+type openAIChunk struct {
+	Choices []struct {
+		Delta        *openAIMessage `json:"delta"`
+		Message      *openAIMessage `json:"message"`
+		FinishReason string         `json:"finish_reason"`
+	} `json:"choices"`
+}
+
+// finishReason returns why an OpenAI-compatible stream ended, or "" for
+// events before the last: "stop", "length", and vLLM's "abort"
+func finishReason(data string) string {
+	var chunk openAIChunk
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil || len(chunk.Choices) == 0 {
+		return ""
+	}
+	return chunk.Choices[0].FinishReason
+}
+
+// openAIAdapter reads standard chat.completion.chunk events
+type openAIAdapter struct{}
+
+// Name implements ResponseAdapter
+func (openAIAdapter) Name() string { return "openai" }
+
+// Parse implements ResponseAdapter. Providers that answer with a single
+// non-streamed message are supported too.
+func (openAIAdapter) Parse(data string, debug bool) string {
+	var chunk openAIChunk
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		if debug {
+			log.Printf("[DEBUG] Not a valid standard response: %v", err)
+		}
+		return ""
+	}
+	if len(chunk.Choices) == 0 {
+		return ""
+	}
+	choice := chunk.Choices[0]
+	if choice.Delta != nil {
+		return choice.Delta.Content
+	}
+	if choice.Message != nil {
+		return choice.Message.Content
+	}
+	return ""
+}
+
+// Reasoning implements ReasoningAdapter
+func (openAIAdapter) Reasoning(data string) string {
+	var chunk openAIChunk
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil || len(chunk.Choices) == 0 {
+		return ""
+	}
+	msg := chunk.Choices[0].Delta
+	if msg == nil {
+		msg = chunk.Choices[0].Message
+	}
+	if msg == nil {
+		return ""
+	}
+	if msg.ReasoningContent != "" {
+		return msg.ReasoningContent
+	}
+	return msg.Reasoning
+}
+
+// geminiAdapter reads Gemini's native streamGenerateContent events
+type geminiAdapter struct{}
+
+// Name implements ResponseAdapter
+func (geminiAdapter) Name() string { return "gemini" }
+
+// Parse implements ResponseAdapter
+func (geminiAdapter) Parse(data string, debug bool) string {
+	var resp struct {
+		Candidates []struct {
+			Content struct {
+				Parts []struct {
+					Text    string `json:"text"`
+					Thought bool   `json:"thought"`
+				} `json:"parts"`
+			} `json:"content"`
+		} `json:"candidates"`
+	}
+	if err := json.Unmarshal([]byte(data), &resp); err != nil {
+		if debug {
+			log.Printf("[DEBUG] Not a valid Gemini response: %v", err)
+		}
+		return ""
+	}
+	if len(resp.Candidates) == 0 {
+		return ""
+	}
+	var text strings.Builder
+	for _, part := range resp.Candidates[0].Content.Parts {
+		// Thought summaries are reasoning, not page content
+		if !part.Thought {
+			text.WriteString(part.Text)
+		}
+	}
+	return text.String()
+}
+
+// perplexityAdapter reads Perplexity Sonar events, which repeat the whole
+// message so far next to each delta and carry the citations
+type perplexityAdapter struct{}
+
+// Name implements ResponseAdapter
+func (perplexityAdapter) Name() string { return "perplexity" }
+
+// Parse implements ResponseAdapter. Only the delta is used; the cumulative
+// message would duplicate the page.
+func (perplexityAdapter) Parse(data string, debug bool) string {
+	var chunk openAIChunk
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		if debug {
+			log.Printf("[DEBUG] Not a valid Perplexity response: %v", err)
+		}
+		return ""
+	}
+	if len(chunk.Choices) == 0 || chunk.Choices[0].Delta == nil {
+		return ""
+	}
+	return chunk.Choices[0].Delta.Content
+}
+
+// Citations implements CitingAdapter. search_results carries titles; the
+// older citations field only has URLs.
+func (perplexityAdapter) Citations(data string) []Citation {
+	var chunk struct {
+		Citations     []string `json:"citations"`
+		SearchResults []struct {
+			Title string `json:"title"`
+			URL   string `json:"url"`
+		} `json:"search_results"`
+	}
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return nil
+	}
+	var citations []Citation
+	if len(chunk.SearchResults) > 0 {
+		for _, r := range chunk.SearchResults {
+			citations = append(citations, Citation{URL: r.URL, Title: r.Title})
+		}
+		return citations
+	}
+	for _, u := range chunk.Citations {
+		citations = append(citations, Citation{URL: u})
+	}
+	return citations
+}
+
+// rawAdapter passes data lines through as text, for endpoints that stream
+// plain text over SSE
+type rawAdapter struct{}
+
+// Name implements ResponseAdapter
+func (rawAdapter) Name() string { return "raw" }
+
+// Parse implements ResponseAdapter
+func (rawAdapter) Parse(data string, debug bool) string {
+	return data
+}
+
+// autoAdapter tries Gemini, then OpenAI, then a deep search of unknown JSON,
+// and finally treats non-JSON data as text
+type autoAdapter struct{}
+
+// Name implements ResponseAdapter
+func (autoAdapter) Name() string { return AutoAdapter }
+
+// Parse implements ResponseAdapter
+func (autoAdapter) Parse(data string, debug bool) string {
+	if content := (geminiAdapter{}).Parse(data, debug); content != "" {
+		return content
+	}
+	if content := (openAIAdapter{}).Parse(data, debug); content != "" {
+		return content
+	}
+
+	var anyJSON map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &anyJSON); err != nil {
+		if strings.HasPrefix(strings.TrimSpace(data), "{") {
+			return ""
+		}
+		if debug {
+			log.Printf("[DEBUG] Using raw data as content: %d bytes", len(data))
+		}
+		return data
+	}
+	// Known formats without text (finish reasons, role announcements) stop here
+	if _, ok := anyJSON["choices"]; ok {
+		return ""
+	}
+	if _, ok := anyJSON["candidates"]; ok {
+		return ""
+	}
+	content := extractTextFromMap(anyJSON, debug)
+	if content != "" && debug {
+		log.Printf("[DEBUG] Found text content via deep search: %q", content)
+	}
+	return content
+}
+
+// Reasoning implements ReasoningAdapter
+func (autoAdapter) Reasoning(data string) string {
+	return openAIAdapter{}.Reasoning(data)
+}
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResponseAdapters(t *testing.T) {
+	tests := []struct {
+		adapter string
+		data    string
+		want    string
+	}{
+		{"openai", `{"choices":[{"delta":{"content":"<h1>Hi</h1>"}}]}`, "<h1>Hi</h1>"},
+		{"openai", `{"choices":[{"delta":{"role":"assistant"}}]}`, ""},
+		{"openai", `{"choices":[{"delta":{},"finish_reason":"stop"}]}`, ""},
+		{"openai", `{"choices":[{"message":{"content":"whole page"}}]}`, "whole page"},
+		{"openai", `not json`, ""},
+		{"openai", `{"choices":[{"delta":{"reasoning_content":"Let me plan the page","content":null}}]}`, ""},
+
+		{"gemini", `{"candidates":[{"content":{"parts":[{"text":"<p>a"},{"text":"b</p>"}]}}]}`, "<p>ab</p>"},
+		{"gemini", `{"candidates":[{"content":{"parts":[{"text":"planning...","thought":true},{"text":"<p>"}]}}]}`, "<p>"},
+		{"gemini", `{"candidates":[]}`, ""},
+
+		{"perplexity", `{"choices":[{"delta":{"content":"lo"},"message":{"content":"Hello"}}],"citations":["https://example.com"]}`, "lo"},
+		{"perplexity", `{"choices":[{"message":{"content":"Hello"}}]}`, ""},
+
+		{"mistral", `{"choices":[{"delta":{"content":"<p>m</p>"}}]}`, "<p>m</p>"},
+		{"mistral", `{"choices":[{"delta":{"content":[{"type":"thinking","thinking":[{"type":"text","text":"hmm"}]},{"type":"text","text":"<p>"}]}}]}`, "<p>"},
+		{"mistral", `{"choices":[{"delta":{"role":"assistant","content":""}}]}`, ""},
+
+		{"raw", `<div>plain</div>`, "<div>plain</div>"},
+
+		{"auto", `{"choices":[{"delta":{"content":"x"}}]}`, "x"},
+		{"auto", `{"candidates":[{"content":{"parts":[{"text":"y"}]}}]}`, "y"},
+		{"auto", `{"output":{"text":"z"}}`, "z"},
+		{"auto", `{"choices":[{"delta":{},"finish_reason":"stop"}]}`, ""},
+		{"auto", `<html>`, "<html>"},
+		{"auto", `{broken`, ""},
+	}
+	for _, tt := range tests {
+		a, err := LookupAdapter(tt.adapter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := a.Parse(tt.data, false); got != tt.want {
+			t.Errorf("%s.Parse(%s) = %q, want %q", tt.adapter, tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestAdapterSelection(t *testing.T) {
+	tests := []struct {
+		name, apiBase, want string
+	}{
+		{"", "https://api.perplexity.ai", "perplexity"},
+		{"", "https://api.openai.com/v1", AutoAdapter},
+		{"", DefaultMistralAPIBase, "mistral"},
+		{"gemini", "https://api.openai.com/v1", "gemini"},
+		{"nonsense", "https://api.perplexity.ai", "perplexity"},
+	}
+	for _, tt := range tests {
+		if got := adapterFor(tt.name, tt.apiBase).Name(); got != tt.want {
+			t.Errorf("adapterFor(%q, %q) = %s, want %s", tt.name, tt.apiBase, got, tt.want)
+		}
+	}
+	if _, err := LookupAdapter("nonsense"); err == nil {
+		t.Error("LookupAdapter accepted an unknown name")
+	}
+}
+
+func TestPerplexityCitations(t *testing.T) {
+	a := perplexityAdapter{}
+	got := a.Citations(`{"choices":[],"citations":["https://a.example","https://b.example"]}`)
+	if len(got) != 2 || got[1].URL != "https://b.example" {
+		t.Fatalf("Citations = %+v", got)
+	}
+	got = a.Citations(`{"citations":["https://a.example"],"search_results":[{"title":"A & B","url":"https://a.example"}]}`)
+	if len(got) != 1 || got[0].Title != "A & B" {
+		t.Fatalf("Citations with search_results = %+v", got)
+	}
+
+	// The sources go inside the body even when </body> is split across chunks
+	var s sourcesInjector
+	out := s.Push("<html><body><p>x [1]</p></bo") + s.Push("dy></html>") + s.Close(got)
+	want := `<li id="source-1"><a href="https://a.example" target="_blank" rel="noopener noreferrer">A &amp; B</a></li>`
+	if !strings.Contains(out, want) || !strings.HasSuffix(out, "</section>\n</body></html>") {
+		t.Errorf("page with sources = %q", out)
+	}
+	if out := renderSources([]Citation{{URL: "javascript:alert(1)"}}); strings.Contains(out, "href") {
+		t.Errorf("non-http citation was linked: %q", out)
+	}
+}
+
+func TestReasoningAdapters(t *testing.T) {
+	tests := []struct {
+		adapter string
+		data    string
+		want    string
+	}{
+		{"openai", `{"choices":[{"delta":{"reasoning_content":"plan","content":""}}]}`, "plan"},
+		{"openai", `{"choices":[{"delta":{"reasoning":"route"}}]}`, "route"},
+		{"openai", `{"choices":[{"message":{"reasoning_content":"whole","content":"<p>"}}]}`, "whole"},
+		{"openai", `{"choices":[{"delta":{"content":"<p>"}}]}`, ""},
+		{"auto", `{"choices":[{"delta":{"reasoning_content":"plan"}}]}`, "plan"},
+		{"mistral", `{"choices":[{"delta":{"content":[{"type":"thinking","thinking":[{"type":"text","text":"hmm"}]},{"type":"text","text":"<p>"}]}}]}`, "hmm"},
+		{"mistral", `{"choices":[{"delta":{"content":"<p>m</p>"}}]}`, ""},
+	}
+	for _, tt := range tests {
+		a, err := LookupAdapter(tt.adapter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, ok := a.(ReasoningAdapter)
+		if !ok {
+			t.Fatalf("%s does not implement ReasoningAdapter", tt.adapter)
+		}
+		if got := r.Reasoning(tt.data); got != tt.want {
+			t.Errorf("%s.Reasoning(%s) = %q, want %q", tt.adapter, tt.data, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Anthropic Messages API defaults
+const (
+	DefaultAnthropicAPIBase = "https://api.anthropic.com/v1"
+	anthropicVersion        = "2023-06-01"
+	anthropicMaxTokens      = 16384 // Output tokens for the page itself, on top of any thinking budget
+)
+
+// anthropicEffortBudgets maps reasoning efforts to thinking budgets, since the
+// Messages API only takes a token budget (1024 is its minimum)
+var anthropicEffortBudgets = map[string]int{
+	"minimal": 1024,
+	"low":     2048,
+	"medium":  8192,
+	"high":    16384,
+}
+
+// AnthropicHandler implements the ModelHandler interface for Anthropic's native Messages API
+type AnthropicHandler struct {
+	ModelName string
+	APIKey    string
+	APIBase   string
+	Debug     bool
+	Reasoning ReasoningOptions
+	RawOutput bool
+	OnUsage   func(Usage)
+	Timeouts  Timeouts
+}
+
+// anthropicEvent is one server-sent event of a streaming Messages response
+type anthropicEvent struct {
+	Type    string `json:"type"`
+	Message struct {
+		Usage struct {
+			InputTokens int `json:"input_tokens"`
+		} `json:"usage"`
+	} `json:"message"`
+	ContentBlock struct {
+		Type string `json:"type"`
+	} `json:"content_block"`
+	Delta struct {
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		Thinking string `json:"thinking"`
+	} `json:"delta"`
+	Usage struct {
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// anthropicThinkingBudget returns the thinking budget to request, or 0 to leave thinking off
+func anthropicThinkingBudget(r ReasoningOptions) int {
+	if r.Effort == "none" {
+		return 0
+	}
+	if r.BudgetTokens > 0 {
+		return max(r.BudgetTokens, 1024)
+	}
+	return anthropicEffortBudgets[r.Effort]
+}
+
+// anthropicLimits returns the thinking budget and max_tokens to request.
+// Models known not to think get no budget, and max_tokens stays within what
+// the model can write, leaving at least half of it for the page.
+func anthropicLimits(modelName string, r ReasoningOptions) (budget, maxTokens int) {
+	budget = anthropicThinkingBudget(r)
+	caps, known := LookupCapabilities(modelName)
+	if known && caps.Reasoning != "" && caps.Reasoning != ReasoningBudget {
+		budget = 0
+	}
+	maxTokens = anthropicMaxTokens + budget
+	if known && caps.MaxOutput > 0 && maxTokens > caps.MaxOutput {
+		maxTokens = caps.MaxOutput
+		if budget > maxTokens/2 {
+			budget = maxTokens / 2
+		}
+		if budget < 1024 {
+			budget = 0
+		}
+	}
+	return budget, maxTokens
+}
+
+// StreamResponse streams the response from the Anthropic model. The system
+// prompt goes into the top-level system field, the page prompt is the single
+// user message, and thinking blocks are dropped just like <think> sections
+// from other reasoning models.
+func (h *AnthropicHandler) StreamResponse(ctx context.Context, w io.Writer, flusher http.Flusher, systemPrompt, userPrompt string) error {
+	apiBase := strings.TrimSuffix(h.APIBase, "/")
+	if apiBase == "" {
+		apiBase = DefaultAnthropicAPIBase
+	}
+
+	budget, maxTokens := anthropicLimits(h.ModelName, h.Reasoning)
+	payload := map[string]interface{}{
+		"model":      h.ModelName,
+		"max_tokens": maxTokens,
+		"messages": []map[string]string{
+			{"role": "user", "content": userPrompt},
+		},
+		"stream": true,
+	}
+	if systemPrompt != "" {
+		payload["system"] = systemPrompt
+	}
+	if budget > 0 {
+		payload["thinking"] = map[string]interface{}{"type": "enabled", "budget_tokens": budget}
+	}
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error creating JSON payload: %w", err)
+	}
+	if h.Debug {
+		log.Printf("🔍 Outgoing JSON payload for %s:\n%s", h.ModelName, string(jsonData))
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, apiBase+"/messages", bytes.NewReader(jsonData))
+	if err != nil {
+		return fmt.Errorf("error creating HTTP request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+	httpReq.Header.Set("x-api-key", h.APIKey)
+	httpReq.Header.Set("anthropic-version", anthropicVersion)
+
+	httpClient := h.Timeouts.client(5*time.Minute, h.Debug)
+
+	httpResp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(httpResp.Body)
+		return newAPIError(httpResp, body)
+	}
+
+	var fullResponse strings.Builder
+	processor := NewStreamProcessor()
+	var raw fenceStripper
+
+	// Tokens are spent even when the client goes away mid-stream, so always report
+	var usage Usage
+	defer func() {
+		reportUsage(h.OnUsage, usage, systemPrompt, userPrompt, fullResponse.String())
+	}()
+
+	scanner := bufio.NewScanner(httpResp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue // "event:" lines repeat the type that is also in the data
+		}
+
+		var event anthropicEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			if h.Debug {
+				log.Printf("[DEBUG] Skipping unparsable event: %v", err)
+			}
+			continue
+		}
+
+		switch event.Type {
+		case "message_start":
+			usage.PromptTokens = event.Message.Usage.InputTokens
+		case "message_delta":
+			usage.CompletionTokens = event.Usage.OutputTokens
+		case "error":
+			return fmt.Errorf("error from API: %s - %s", event.Error.Type, event.Error.Message)
+		case "content_block_start":
+			if h.Debug && event.ContentBlock.Type != "text" {
+				log.Printf("[DEBUG] Skipping %s block", event.ContentBlock.Type)
+			}
+		case "content_block_delta":
+			// Thinking and signature deltas never reach the page
+			if event.Delta.Type != "text_delta" || event.Delta.Text == "" {
+				continue
+			}
+			content := event.Delta.Text
+			fullResponse.WriteString(content)
+
+			var processedContent string
+			if h.RawOutput {
+				processedContent = raw.Push(content)
+			} else {
+				processedContent = processor.Process(content)
+			}
+			if processedContent != "" {
+				if _, err := io.WriteString(w, processedContent); err != nil {
+					log.Printf("[ERROR] Client disconnected during streaming: %v", err)
+					return fmt.Errorf("client disconnected: %w", err)
+				}
+				flusher.Flush()
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading response: %w", err)
+	}
+
+	if h.Debug {
+		log.Printf("[PROVIDER RAW RESPONSE] (Anthropic)\n%s", fullResponse.String())
+	}
+
+	// Flush whatever is left once the model stops
+	rest := processor.Finish()
+	if h.RawOutput {
+		rest = raw.Close()
+	}
+	if rest != "" {
+		if _, err := io.WriteString(w, rest); err != nil {
+			return fmt.Errorf("client disconnected: %w", err)
+		}
+		flusher.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ollama/ollama/api"
+)
+
+// APIError is a backend's HTTP error response, returned before any output
+type APIError struct {
+	StatusCode int
+	Status     string // e.g. "503 Service Unavailable"
+	Body       string
+}
+
+// Error implements error
+func (e *APIError) Error() string {
+	return fmt.Sprintf("error from API: %s - %s", e.Status, e.Body)
+}
+
+// newAPIError reads the error response into an APIError
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	return &APIError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(body)}
+}
+
+// IsTransient reports whether err is a backend error worth retrying: rate
+// limits (429), server errors (500, 502, 503, 504), and Anthropic's overload (529)
+func IsTransient(err error) bool {
+	code := 0
+	var apiErr *APIError
+	var ollamaErr api.StatusError
+	switch {
+	case errors.As(err, &apiErr):
+		code = apiErr.StatusCode
+	case errors.As(err, &ollamaErr):
+		code = ollamaErr.StatusCode
+	}
+	switch code {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout, 529:
+		return true
+	}
+	return false
+}
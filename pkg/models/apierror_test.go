@@ -0,0 +1,31 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&APIError{StatusCode: 429, Status: "429 Too Many Requests"}, true},
+		{fmt.Errorf("openai: %w", &APIError{StatusCode: 503}), true},
+		{&APIError{StatusCode: 529}, true},
+		{&APIError{StatusCode: 400}, false},
+		{&APIError{StatusCode: 401}, false},
+		{fmt.Errorf("failed to start Ollama chat: %w", api.StatusError{StatusCode: 502}), true},
+		{ErrRateLimited, false},
+		{errors.New("connection refused"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := IsTransient(tt.err); got != tt.want {
+			t.Errorf("IsTransient(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
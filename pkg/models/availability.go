@@ -0,0 +1,157 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+)
+
+// ErrModelNotFound is returned by CheckModel when the backend does not list the model
+var ErrModelNotFound = errors.New("model not found")
+
+// CheckModel asks the backend whether it has modelName: Ollama through
+// /api/tags, OpenAI, Mistral, Groq, and vLLM through /models. Other backends are not
+// checked. An error other than ErrModelNotFound means the list could not be
+// fetched, which says nothing about the model.
+func CheckModel(ctx context.Context, backend, modelName, apiKey, apiBase string) error {
+	switch backend {
+	case "ollama":
+		return checkOllamaModel(ctx, modelName, apiKey, apiBase)
+	case "openai":
+		return checkOpenAIModel(ctx, modelName, apiKey, apiBase)
+	case "mistral":
+		if apiBase == "" {
+			apiBase = DefaultMistralAPIBase
+		}
+		return checkOpenAIModel(ctx, modelName, apiKey, apiBase)
+	case "groq":
+		if apiBase == "" {
+			apiBase = DefaultGroqAPIBase
+		}
+		return checkOpenAIModel(ctx, modelName, apiKey, apiBase)
+	case "vllm":
+		if apiBase == "" {
+			apiBase = DefaultVLLMAPIBase
+		}
+		return checkOpenAIModel(ctx, modelName, apiKey, apiBase)
+	}
+	return nil
+}
+
+// CanCheckModel reports whether CheckModel can ask backend about its models
+func CanCheckModel(backend string) bool {
+	switch backend {
+	case "ollama", "openai", "mistral", "groq", "vllm":
+		return true
+	}
+	return false
+}
+
+// checkOllamaModel looks for modelName among the models Ollama has pulled
+func checkOllamaModel(ctx context.Context, modelName, apiKey, apiBase string) error {
+	client, err := ollamaClient(apiKey, apiBase)
+	if err != nil {
+		return err
+	}
+	list, err := client.List(ctx)
+	if err != nil {
+		return fmt.Errorf("listing Ollama models: %w", err)
+	}
+	for _, m := range list.Models {
+		if sameOllamaModel(m.Name, modelName) || sameOllamaModel(m.Model, modelName) {
+			return nil
+		}
+	}
+	return ErrModelNotFound
+}
+
+// sameOllamaModel compares model names, where no tag means ":latest"
+func sameOllamaModel(a, b string) bool {
+	withTag := func(name string) string {
+		if !strings.Contains(name, ":") {
+			return name + ":latest"
+		}
+		return name
+	}
+	return withTag(a) == withTag(b)
+}
+
+// checkOpenAIModel looks for modelName in the /models list of an
+// OpenAI-compatible API
+func checkOpenAIModel(ctx context.Context, modelName, apiKey, apiBase string) error {
+	if apiBase == "" {
+		apiBase = "https://api.openai.com/v1"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(apiBase, "/")+"/models", nil)
+	if err != nil {
+		return err
+	}
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("listing models: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("listing models: %s", resp.Status)
+	}
+
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return fmt.Errorf("listing models: %w", err)
+	}
+	for _, m := range list.Data {
+		if m.ID == modelName {
+			return nil
+		}
+	}
+	return ErrModelNotFound
+}
+
+// PullOllamaModel downloads modelName to the Ollama server at apiBase,
+// passing every progress update to progress
+func PullOllamaModel(ctx context.Context, modelName, apiKey, apiBase string, progress func(api.ProgressResponse)) error {
+	client, err := ollamaClient(apiKey, apiBase)
+	if err != nil {
+		return err
+	}
+	err = client.Pull(ctx, &api.PullRequest{Model: modelName}, func(p api.ProgressResponse) error {
+		if progress != nil {
+			progress(p)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("pulling %s: %w", modelName, err)
+	}
+	return nil
+}
+
+// ollamaClient creates an Ollama API client for apiBase, authenticating with
+// apiKey when one is set
+func ollamaClient(apiKey, apiBase string) (*api.Client, error) {
+	if apiBase == "" {
+		apiBase = "http://localhost:11434"
+	}
+	baseURL, err := url.Parse(apiBase)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Ollama API base %q: %w", apiBase, err)
+	}
+	httpClient := http.DefaultClient
+	if apiKey != "" {
+		httpClient = &http.Client{Transport: &authTransport{base: http.DefaultTransport, apiKey: apiKey}}
+	}
+	return api.NewClient(baseURL, httpClient), nil
+}
@@ -0,0 +1,141 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAWSAuthorization signs requests from the AWS Signature Version 4 test
+// suite and the IAM example of the AWS documentation
+func TestAWSAuthorization(t *testing.T) {
+	creds := AWSCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name, method, url, service string
+		headers                    map[string]string
+		want                       string
+	}{
+		{
+			name: "get-vanilla", method: "GET", url: "https://example.amazonaws.com/", service: "service",
+			want: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name: "get-vanilla-query-order-key-case", method: "GET", url: "https://example.amazonaws.com/?Param2=value2&Param1=value1", service: "service",
+			want: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			name: "post-vanilla", method: "POST", url: "https://example.amazonaws.com/", service: "service",
+			want: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name: "IAM ListUsers", method: "GET", url: "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", service: "iam",
+			headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded; charset=utf-8"},
+			want:    "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		},
+	} {
+		req, err := http.NewRequest(tc.method, tc.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Amz-Date", "20150830T123600Z")
+		for name, value := range tc.headers {
+			req.Header.Set(name, value)
+		}
+		if got := awsAuthorization(req, sha256Hex(nil), creds, "us-east-1", tc.service, now); got != tc.want {
+			t.Errorf("%s:\n got %s\nwant %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSignAWSRequest(t *testing.T) {
+	creds := AWSCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret", SessionToken: "session"}
+	body := []byte(`{"messages":[]}`)
+	req, _ := http.NewRequest("POST", "https://bedrock-runtime.us-east-1.amazonaws.com/model/anthropic.claude-3-haiku-20240307-v1%3A0/converse-stream", bytes.NewReader(body))
+	signAWSRequest(req, body, creds, "us-east-1", "bedrock", time.Date(2026, 10, 16, 9, 30, 0, 0, time.FixedZone("CEST", 2*60*60)))
+
+	for name, want := range map[string]string{
+		"X-Amz-Date":           "20261016T073000Z",
+		"X-Amz-Content-Sha256": sha256Hex(body),
+		"X-Amz-Security-Token": "session",
+	} {
+		if got := req.Header.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20261016/us-east-1/bedrock/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date;x-amz-security-token, Signature=") {
+		t.Errorf("Authorization = %q", auth)
+	}
+}
+
+// eventStreamFrame encodes a message with string headers
+func eventStreamFrame(headers map[string]string, payload string) []byte {
+	var h bytes.Buffer
+	for name, value := range headers {
+		h.WriteByte(byte(len(name)))
+		h.WriteString(name)
+		h.WriteByte(7)
+		binary.Write(&h, binary.BigEndian, uint16(len(value)))
+		h.WriteString(value)
+	}
+	total := 12 + h.Len() + len(payload) + 4
+	frame := binary.BigEndian.AppendUint32(nil, uint32(total))
+	frame = binary.BigEndian.AppendUint32(frame, uint32(h.Len()))
+	frame = binary.BigEndian.AppendUint32(frame, crc32.ChecksumIEEE(frame))
+	frame = append(frame, h.Bytes()...)
+	frame = append(frame, payload...)
+	return binary.BigEndian.AppendUint32(frame, crc32.ChecksumIEEE(frame))
+}
+
+func TestEventStreamReader(t *testing.T) {
+	first := eventStreamFrame(map[string]string{":event-type": "contentBlockDelta", ":message-type": "event"}, `{"delta":{"text":"<html>"}}`)
+	second := eventStreamFrame(map[string]string{":event-type": "messageStop"}, `{}`)
+
+	r := newEventStreamReader(bytes.NewReader(append(append([]byte{}, first...), second...)))
+	for _, want := range []eventStreamMessage{
+		{Headers: map[string]string{":event-type": "contentBlockDelta", ":message-type": "event"}, Payload: []byte(`{"delta":{"text":"<html>"}}`)},
+		{Headers: map[string]string{":event-type": "messageStop"}, Payload: []byte(`{}`)},
+	} {
+		msg, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*msg, want) {
+			t.Errorf("message = %+v, want %+v", *msg, want)
+		}
+	}
+	if _, err := r.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("after the last message: %v", err)
+	}
+
+	corrupt := func(i int) []byte {
+		frame := append([]byte{}, first...)
+		frame[i] ^= 0xff
+		return frame
+	}
+	for _, tc := range []struct {
+		name  string
+		input []byte
+		want  string
+	}{
+		{"payload changed", corrupt(len(first) - 6), "message checksum mismatch"},
+		{"message checksum changed", corrupt(len(first) - 1), "message checksum mismatch"},
+		{"length changed", corrupt(3), "prelude checksum mismatch"},
+		{"truncated prelude", first[:7], "truncated event stream prelude"},
+		{"truncated message", first[:len(first)-5], "truncated event stream message"},
+		{"truncated after the prelude", first[:12], "truncated event stream message"},
+	} {
+		_, err := newEventStreamReader(bytes.NewReader(tc.input)).Next()
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: %v, want an error containing %q", tc.name, err, tc.want)
+		}
+	}
+}
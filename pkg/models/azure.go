@@ -0,0 +1,38 @@
+package models
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultAzureAPIVersion is the Azure OpenAI api-version used when none is configured
+const DefaultAzureAPIVersion = "2024-10-21"
+
+// chatCompletionsURL returns the chat completions endpoint. Azure OpenAI
+// addresses a deployment (the configured model name) instead of a model and
+// needs an api-version query parameter.
+func (h *OpenAIHandler) chatCompletionsURL() string {
+	base := strings.TrimSuffix(h.APIBase, "/")
+	if !h.Azure {
+		return base + "/chat/completions"
+	}
+	apiVersion := h.APIVersion
+	if apiVersion == "" {
+		apiVersion = DefaultAzureAPIVersion
+	}
+	return base + "/openai/deployments/" + url.PathEscape(h.ModelName) +
+		"/chat/completions?api-version=" + url.QueryEscape(apiVersion)
+}
+
+// setAuth adds the API key to req: an api-key header for Azure, a Bearer token otherwise
+func (h *OpenAIHandler) setAuth(req *http.Request) {
+	if h.APIKey == "" {
+		return
+	}
+	if h.Azure {
+		req.Header.Set("api-key", h.APIKey)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+h.APIKey)
+}
@@ -0,0 +1,178 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultNodeCooldown is how long a failed node is skipped
+const DefaultNodeCooldown = 30 * time.Second
+
+// Node is one server of a balanced backend
+type Node struct {
+	APIBase string
+	Weight  int // Relative share of requests; 1 when zero
+}
+
+// NodeStatus reports the health of one node
+type NodeStatus struct {
+	APIBase      string    `json:"api_base"`
+	Weight       int       `json:"weight"`
+	Healthy      bool      `json:"healthy"`
+	Requests     int64     `json:"requests"`
+	Failures     int64     `json:"failures"`
+	LastError    string    `json:"last_error,omitempty"`
+	DownUntil    time.Time `json:"down_until,omitzero"`
+	consecutives int       // Failures since the last success
+}
+
+// Balancer spreads generations for one model over several identical servers
+// (e.g. Ollama nodes) using smooth weighted round-robin. A node that fails is
+// skipped for a cooldown, doubling while it keeps failing.
+type Balancer struct {
+	Cooldown time.Duration
+
+	mu      sync.Mutex
+	nodes   []Node
+	current []int // Smooth weighted round-robin state
+	status  []NodeStatus
+}
+
+// NewBalancer creates a balancer over nodes
+func NewBalancer(nodes []Node) (*Balancer, error) {
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("at least one node is required")
+	}
+	b := &Balancer{Cooldown: DefaultNodeCooldown}
+	for _, n := range nodes {
+		if n.APIBase == "" {
+			return nil, fmt.Errorf("node without api_base")
+		}
+		if n.Weight < 0 {
+			return nil, fmt.Errorf("node %s has a negative weight", n.APIBase)
+		}
+		if n.Weight == 0 {
+			n.Weight = 1
+		}
+		b.nodes = append(b.nodes, n)
+		b.status = append(b.status, NodeStatus{APIBase: n.APIBase, Weight: n.Weight, Healthy: true})
+	}
+	b.current = make([]int, len(b.nodes))
+	return b, nil
+}
+
+// pick returns the index of the next node, skipping nodes in their cooldown
+// and those in tried. When every node is down, the one that recovers first is used.
+func (b *Balancer) pick(tried map[int]bool) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+
+	best, total := -1, 0
+	for i, n := range b.nodes {
+		if tried[i] || now.Before(b.status[i].DownUntil) {
+			continue
+		}
+		b.current[i] += n.Weight
+		total += n.Weight
+		if best == -1 || b.current[i] > b.current[best] {
+			best = i
+		}
+	}
+	if best != -1 {
+		b.current[best] -= total
+		b.status[best].Requests++
+		return best
+	}
+
+	// Nothing healthy is left; try the node closest to the end of its cooldown
+	for i := range b.nodes {
+		if !tried[i] && (best == -1 || b.status[i].DownUntil.Before(b.status[best].DownUntil)) {
+			best = i
+		}
+	}
+	if best != -1 {
+		b.status[best].Requests++
+	}
+	return best
+}
+
+// report records the outcome of a generation on node i
+func (b *Balancer) report(i int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := &b.status[i]
+	if err == nil {
+		if !st.Healthy {
+			log.Printf("💚 Node %s is healthy again", st.APIBase)
+		}
+		st.Healthy, st.consecutives, st.DownUntil = true, 0, time.Time{}
+		return
+	}
+	st.Failures++
+	st.consecutives++
+	st.LastError = err.Error()
+	cooldown := b.Cooldown << min(st.consecutives-1, 5)
+	st.Healthy, st.DownUntil = false, time.Now().Add(cooldown)
+	log.Printf("💔 Node %s failed (%v); skipping it for %v", st.APIBase, err, cooldown)
+}
+
+// Status returns the health of every node
+func (b *Balancer) Status() []NodeStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := make([]NodeStatus, len(b.status))
+	copy(status, b.status)
+	return status
+}
+
+// balancedHandler runs a generation on the next node, moving on to another
+// node when one fails before producing any output
+type balancedHandler struct {
+	balancer *Balancer
+	newNode  func(apiBase string) ModelHandler
+}
+
+// StreamResponse implements ModelHandler
+func (h *balancedHandler) StreamResponse(ctx context.Context, w io.Writer, flusher http.Flusher, systemPrompt, userPrompt string) error {
+	tried := map[int]bool{}
+	var err error
+	for len(tried) < len(h.balancer.nodes) {
+		i := h.balancer.pick(tried)
+		tried[i] = true
+		cw := &countingWriter{w: w}
+		err = h.newNode(h.balancer.nodes[i].APIBase).StreamResponse(ctx, cw, flusher, systemPrompt, userPrompt)
+		if ctx.Err() != nil {
+			// The client went away; that says nothing about the node
+			return err
+		}
+		if err == nil {
+			h.balancer.report(i, nil)
+			return nil
+		}
+		if cw.n > 0 {
+			// Output has been sent; retrying would repeat it
+			return err
+		}
+		h.balancer.report(i, err)
+	}
+	return err
+}
+
+// countingWriter counts the bytes passed on to w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write implements io.Writer
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
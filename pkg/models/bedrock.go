@@ -0,0 +1,247 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Bedrock runtime defaults
+const (
+	DefaultBedrockRegion    = "us-east-1"
+	bedrockAnthropicVersion = "bedrock-2023-05-31"
+	bedrockLlamaMaxGenLen   = 2048 // The largest max_gen_len Bedrock accepts for Llama
+)
+
+// BedrockHandler implements the ModelHandler interface for models hosted on
+// Amazon Bedrock, using the native invoke-with-response-stream API. Anthropic
+// Claude and Meta Llama model families are supported.
+type BedrockHandler struct {
+	ModelName   string // Model ID or inference profile, e.g. anthropic.claude-3-5-sonnet-20240620-v1:0
+	APIKey      string // Bedrock API key; SigV4 signing with Credentials is used when empty
+	APIBase     string // Runtime endpoint; derived from Region when empty
+	Region      string
+	Credentials AWSCredentials
+	Debug       bool
+	Reasoning   ReasoningOptions
+	RawOutput   bool
+	OnUsage     func(Usage)
+	Timeouts    Timeouts
+}
+
+// bedrockChunk is the decoded payload of one chunk event. Claude chunks are
+// Messages API events; Llama chunks carry the generation directly. Both end
+// with Bedrock's invocation metrics.
+type bedrockChunk struct {
+	anthropicEvent
+	Generation string `json:"generation"`
+	Metrics    *struct {
+		InputTokenCount  int `json:"inputTokenCount"`
+		OutputTokenCount int `json:"outputTokenCount"`
+	} `json:"amazon-bedrock-invocationMetrics"`
+}
+
+// bedrockFamily returns the model family of a model ID, ignoring any
+// cross-region inference profile prefix ("us.", "eu.", ...)
+func bedrockFamily(modelID string) string {
+	switch {
+	case strings.Contains(modelID, "anthropic."):
+		return "anthropic"
+	case strings.Contains(modelID, "meta.llama"):
+		return "llama"
+	default:
+		return ""
+	}
+}
+
+// endpoint returns the runtime endpoint for the configured region
+func (h *BedrockHandler) endpoint() string {
+	if h.APIBase != "" {
+		return strings.TrimSuffix(h.APIBase, "/")
+	}
+	region := h.Region
+	if region == "" {
+		region = DefaultBedrockRegion
+	}
+	return "https://bedrock-runtime." + region + ".amazonaws.com"
+}
+
+// requestBody builds the model-specific invocation body
+func (h *BedrockHandler) requestBody(systemPrompt, userPrompt string) (map[string]interface{}, error) {
+	switch bedrockFamily(h.ModelName) {
+	case "anthropic":
+		budget, maxTokens := anthropicLimits(h.ModelName, h.Reasoning)
+		payload := map[string]interface{}{
+			"anthropic_version": bedrockAnthropicVersion,
+			"max_tokens":        maxTokens,
+			"messages": []map[string]string{
+				{"role": "user", "content": userPrompt},
+			},
+		}
+		if systemPrompt != "" {
+			payload["system"] = systemPrompt
+		}
+		if budget > 0 {
+			payload["thinking"] = map[string]interface{}{"type": "enabled", "budget_tokens": budget}
+		}
+		return payload, nil
+	case "llama":
+		// Bedrock takes a raw prompt for Llama, so apply the Llama 3 chat template here
+		prompt := "<|begin_of_text|>"
+		if systemPrompt != "" {
+			prompt += "<|start_header_id|>system<|end_header_id|>\n\n" + systemPrompt + "<|eot_id|>"
+		}
+		prompt += "<|start_header_id|>user<|end_header_id|>\n\n" + userPrompt + "<|eot_id|>" +
+			"<|start_header_id|>assistant<|end_header_id|>\n\n"
+		return map[string]interface{}{
+			"prompt":      prompt,
+			"max_gen_len": bedrockLlamaMaxGenLen,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported Bedrock model %q: only anthropic.* and meta.llama* models are supported", h.ModelName)
+	}
+}
+
+// StreamResponse streams the response from the Bedrock model
+func (h *BedrockHandler) StreamResponse(ctx context.Context, w io.Writer, flusher http.Flusher, systemPrompt, userPrompt string) error {
+	payload, err := h.requestBody(systemPrompt, userPrompt)
+	if err != nil {
+		return err
+	}
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error creating JSON payload: %w", err)
+	}
+	if h.Debug {
+		log.Printf("🔍 Outgoing JSON payload for %s:\n%s", h.ModelName, string(jsonData))
+	}
+
+	// Model IDs contain ':', which has to reach the signer escaped
+	escapedPath := "/model/" + awsURIEncode(h.ModelName, false) + "/invoke-with-response-stream"
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, h.endpoint()+escapedPath, bytes.NewReader(jsonData))
+	if err != nil {
+		return fmt.Errorf("error creating HTTP request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "application/vnd.amazon.eventstream")
+	if h.APIKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+h.APIKey)
+	} else {
+		region := h.Region
+		if region == "" {
+			region = DefaultBedrockRegion
+		}
+		signAWSRequest(httpReq, jsonData, h.Credentials, region, "bedrock", time.Now())
+	}
+
+	httpClient := h.Timeouts.client(5*time.Minute, h.Debug)
+
+	httpResp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(httpResp.Body)
+		return newAPIError(httpResp, body)
+	}
+
+	var fullResponse strings.Builder
+	processor := NewStreamProcessor()
+	var raw fenceStripper
+
+	// Tokens are spent even when the client goes away mid-stream, so always report
+	var usage Usage
+	defer func() {
+		reportUsage(h.OnUsage, usage, systemPrompt, userPrompt, fullResponse.String())
+	}()
+
+	events := newEventStreamReader(httpResp.Body)
+	for {
+		msg, err := events.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading response: %w", err)
+		}
+
+		switch msg.Headers[":message-type"] {
+		case "exception":
+			var exception struct {
+				Message string `json:"message"`
+			}
+			_ = json.Unmarshal(msg.Payload, &exception)
+			return fmt.Errorf("error from API: %s - %s", msg.Headers[":exception-type"], exception.Message)
+		case "error":
+			return fmt.Errorf("error from API: %s - %s", msg.Headers[":error-code"], msg.Headers[":error-message"])
+		}
+		if msg.Headers[":event-type"] != "chunk" {
+			continue
+		}
+
+		// The model's own JSON event arrives base64-encoded in the bytes field
+		var envelope struct {
+			Bytes []byte `json:"bytes"`
+		}
+		var chunk bedrockChunk
+		if err := json.Unmarshal(msg.Payload, &envelope); err != nil || json.Unmarshal(envelope.Bytes, &chunk) != nil {
+			if h.Debug {
+				log.Printf("[DEBUG] Skipping unparsable chunk: %s", string(msg.Payload))
+			}
+			continue
+		}
+		if chunk.Metrics != nil {
+			usage = Usage{PromptTokens: chunk.Metrics.InputTokenCount, CompletionTokens: chunk.Metrics.OutputTokenCount}
+		}
+
+		// Thinking and signature deltas never reach the page
+		content := chunk.Generation
+		if chunk.Type == "content_block_delta" && chunk.Delta.Type == "text_delta" {
+			content = chunk.Delta.Text
+		}
+		if content == "" {
+			continue
+		}
+		fullResponse.WriteString(content)
+
+		var processedContent string
+		if h.RawOutput {
+			processedContent = raw.Push(content)
+		} else {
+			processedContent = processor.Process(content)
+		}
+		if processedContent != "" {
+			if _, err := io.WriteString(w, processedContent); err != nil {
+				log.Printf("[ERROR] Client disconnected during streaming: %v", err)
+				return fmt.Errorf("client disconnected: %w", err)
+			}
+			flusher.Flush()
+		}
+	}
+
+	if h.Debug {
+		log.Printf("[PROVIDER RAW RESPONSE] (Bedrock)\n%s", fullResponse.String())
+	}
+
+	// Flush whatever is left once the model stops
+	rest := processor.Finish()
+	if h.RawOutput {
+		rest = raw.Close()
+	}
+	if rest != "" {
+		if _, err := io.WriteString(w, rest); err != nil {
+			return fmt.Errorf("client disconnected: %w", err)
+		}
+		flusher.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ollama/ollama/api"
+)
+
+// Reasoning styles of a model
+const (
+	ReasoningNone      = "none"       // Answers right away
+	ReasoningThinkTags = "think-tags" // Reasons in <think> blocks in the content
+	ReasoningEffort    = "effort"     // Takes a reasoning effort
+	ReasoningBudget    = "budget"     // Takes a thinking token budget
+)
+
+// Capabilities describe what a model can do. Zero values mean unknown.
+type Capabilities struct {
+	ContextWindow int    `json:"context_window,omitempty"` // Tokens of prompt and output together
+	MaxOutput     int    `json:"max_output,omitempty"`     // Most tokens the model writes in one response
+	Vision        bool   `json:"vision,omitempty"`         // Accepts images
+	Tools         bool   `json:"tools,omitempty"`          // Supports tool calls
+	Reasoning     string `json:"reasoning,omitempty"`      // One of the Reasoning* styles
+	PriceTier     string `json:"price_tier,omitempty"`     // "free", "low", "medium", or "high"
+}
+
+// Validate reports unsupported values
+func (c Capabilities) Validate() error {
+	switch c.Reasoning {
+	case "", ReasoningNone, ReasoningThinkTags, ReasoningEffort, ReasoningBudget:
+	default:
+		return fmt.Errorf("unknown reasoning style %q (use none, think-tags, effort, or budget)", c.Reasoning)
+	}
+	switch c.PriceTier {
+	case "", "free", "low", "medium", "high":
+	default:
+		return fmt.Errorf("unknown price tier %q (use free, low, medium, or high)", c.PriceTier)
+	}
+	if c.ContextWindow < 0 || c.MaxOutput < 0 {
+		return fmt.Errorf("context_window and max_output must not be negative")
+	}
+	return nil
+}
+
+// Overflows estimates the prompt's tokens and reports whether they, plus
+// room for the output, exceed the context window. Unknown windows never
+// overflow.
+func (c Capabilities) Overflows(systemPrompt, userPrompt string) (int, bool) {
+	tokens := EstimateTokens(systemPrompt) + EstimateTokens(userPrompt)
+	if c.ContextWindow == 0 {
+		return tokens, false
+	}
+	output := c.MaxOutput
+	if output == 0 || output > c.ContextWindow/4 {
+		output = c.ContextWindow / 4
+	}
+	return tokens, tokens+output > c.ContextWindow
+}
+
+// capabilityEntry describes the models matching a name pattern
+type capabilityEntry struct {
+	pattern string
+	caps    Capabilities
+}
+
+// capabilities holds what is known about models, most recently registered
+// last. The built-in entries go from general to specific.
+var capabilities = struct {
+	sync.RWMutex
+	entries []capabilityEntry
+}{entries: []capabilityEntry{
+	{"*gpt-4o*", Capabilities{ContextWindow: 128000, MaxOutput: 16384, Vision: true, Tools: true, Reasoning: ReasoningNone, PriceTier: "medium"}},
+	{"*gpt-4o-mini*", Capabilities{ContextWindow: 128000, MaxOutput: 16384, Vision: true, Tools: true, Reasoning: ReasoningNone, PriceTier: "low"}},
+	{"*gpt-4.1*", Capabilities{ContextWindow: 1047576, MaxOutput: 32768, Vision: true, Tools: true, Reasoning: ReasoningNone, PriceTier: "medium"}},
+	{"*gpt-4.1-mini*", Capabilities{ContextWindow: 1047576, MaxOutput: 32768, Vision: true, Tools: true, Reasoning: ReasoningNone, PriceTier: "low"}},
+	{"*gpt-4.1-nano*", Capabilities{ContextWindow: 1047576, MaxOutput: 32768, Vision: true, Tools: true, Reasoning: ReasoningNone, PriceTier: "low"}},
+	{"o3*", Capabilities{ContextWindow: 200000, MaxOutput: 100000, Vision: true, Tools: true, Reasoning: ReasoningEffort, PriceTier: "high"}},
+	{"o4-mini*", Capabilities{ContextWindow: 200000, MaxOutput: 100000, Vision: true, Tools: true, Reasoning: ReasoningEffort, PriceTier: "medium"}},
+	{"*gpt-5*", Capabilities{ContextWindow: 400000, MaxOutput: 128000, Vision: true, Tools: true, Reasoning: ReasoningEffort, PriceTier: "medium"}},
+	{"*claude-3-haiku*", Capabilities{ContextWindow: 200000, MaxOutput: 4096, Vision: true, Tools: true, Reasoning: ReasoningNone, PriceTier: "low"}},
+	{"*claude-3-opus*", Capabilities{ContextWindow: 200000, MaxOutput: 4096, Vision: true, Tools: true, Reasoning: ReasoningNone, PriceTier: "high"}},
+	{"*claude-3-5-*", Capabilities{ContextWindow: 200000, MaxOutput: 8192, Vision: true, Tools: true, Reasoning: ReasoningNone, PriceTier: "medium"}},
+	{"*claude-3-7-sonnet*", Capabilities{ContextWindow: 200000, MaxOutput: 64000, Vision: true, Tools: true, Reasoning: ReasoningBudget, PriceTier: "medium"}},
+	{"*claude-sonnet-4*", Capabilities{ContextWindow: 200000, MaxOutput: 64000, Vision: true, Tools: true, Reasoning: ReasoningBudget, PriceTier: "medium"}},
+	{"*claude-opus-4*", Capabilities{ContextWindow: 200000, MaxOutput: 32000, Vision: true, Tools: true, Reasoning: ReasoningBudget, PriceTier: "high"}},
+	{"*gemini-2.5-*", Capabilities{ContextWindow: 1048576, MaxOutput: 65536, Vision: true, Tools: true, Reasoning: ReasoningBudget, PriceTier: "medium"}},
+	{"*gemini-2.5-flash*", Capabilities{ContextWindow: 1048576, MaxOutput: 65536, Vision: true, Tools: true, Reasoning: ReasoningBudget, PriceTier: "low"}},
+	{"*deepseek-r1*", Capabilities{ContextWindow: 128000, Reasoning: ReasoningThinkTags}},
+	{"*qwen3*", Capabilities{ContextWindow: 32768, Tools: true, Reasoning: ReasoningThinkTags}},
+	{"*llama3.1*", Capabilities{ContextWindow: 131072, Tools: true, Reasoning: ReasoningNone}},
+	{"*llama3.2*", Capabilities{ContextWindow: 131072, Tools: true, Reasoning: ReasoningNone}},
+	{"*llama3.2-vision*", Capabilities{ContextWindow: 131072, Vision: true, Reasoning: ReasoningNone}},
+}}
+
+// SetCapabilities records the capabilities of the models matching pattern, a
+// path.Match glob over the model name ("*claude-3-5-*", "my-finetune:*").
+// It replaces what was known for that pattern and takes precedence over
+// every entry registered before it.
+func SetCapabilities(pattern string, c Capabilities) error {
+	if pattern == "" {
+		return fmt.Errorf("a model name or pattern is required")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid model pattern %q: %w", pattern, err)
+	}
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("%s: %w", pattern, err)
+	}
+	capabilities.Lock()
+	defer capabilities.Unlock()
+	for i, e := range capabilities.entries {
+		if e.pattern == pattern {
+			capabilities.entries = append(capabilities.entries[:i], capabilities.entries[i+1:]...)
+			break
+		}
+	}
+	capabilities.entries = append(capabilities.entries, capabilityEntry{pattern: pattern, caps: c})
+	return nil
+}
+
+// LookupCapabilities returns what is known about modelName. Provider prefixes
+// ("openai/gpt-4o" on OpenRouter) are ignored; case is too.
+func LookupCapabilities(modelName string) (Capabilities, bool) {
+	name := strings.ToLower(modelName)
+	base := name[strings.LastIndex(name, "/")+1:]
+	capabilities.RLock()
+	defer capabilities.RUnlock()
+	for i := len(capabilities.entries) - 1; i >= 0; i-- {
+		e := capabilities.entries[i]
+		pattern := strings.ToLower(e.pattern)
+		if ok, _ := path.Match(pattern, name); ok {
+			return e.caps, true
+		}
+		if ok, _ := path.Match(pattern, base); ok {
+			return e.caps, true
+		}
+	}
+	return Capabilities{}, false
+}
+
+// ErrNoCapabilities is returned by FetchCapabilities for providers that do
+// not describe their models
+var ErrNoCapabilities = errors.New("the provider does not describe its models")
+
+// FetchCapabilities asks the provider what modelName can do: Ollama through
+// /api/show, OpenRouter through its model list
+func FetchCapabilities(ctx context.Context, backend, modelName, apiKey, apiBase string) (Capabilities, error) {
+	switch {
+	case backend == "ollama":
+		return fetchOllamaCapabilities(ctx, modelName, apiKey, apiBase)
+	case backend == "openai" && isOpenRouter(apiBase):
+		return fetchOpenRouterCapabilities(ctx, modelName, apiKey, apiBase)
+	}
+	return Capabilities{}, ErrNoCapabilities
+}
+
+// fetchOllamaCapabilities reads the context length and the capabilities
+// Ollama reports for a pulled model. Local models cost nothing per token.
+func fetchOllamaCapabilities(ctx context.Context, modelName, apiKey, apiBase string) (Capabilities, error) {
+	client, err := ollamaClient(apiKey, apiBase)
+	if err != nil {
+		return Capabilities{}, err
+	}
+	show, err := client.Show(ctx, &api.ShowRequest{Model: modelName})
+	if err != nil {
+		return Capabilities{}, fmt.Errorf("describing %s: %w", modelName, err)
+	}
+
+	c := Capabilities{Reasoning: ReasoningNone, PriceTier: "free"}
+	// The key is prefixed with the architecture: "llama.context_length", "qwen3.context_length", ...
+	for key, v := range show.ModelInfo {
+		if n, ok := v.(float64); ok && strings.HasSuffix(key, ".context_length") {
+			c.ContextWindow = int(n)
+		}
+	}
+	for _, capability := range show.Capabilities {
+		switch capability {
+		case "vision":
+			c.Vision = true
+		case "tools":
+			c.Tools = true
+		case "thinking":
+			c.Reasoning = ReasoningThinkTags
+		}
+	}
+	return c, nil
+}
+
+// fetchOpenRouterCapabilities looks modelName up in OpenRouter's model list
+func fetchOpenRouterCapabilities(ctx context.Context, modelName, apiKey, apiBase string) (Capabilities, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(apiBase, "/")+"/models", nil)
+	if err != nil {
+		return Capabilities{}, err
+	}
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Capabilities{}, fmt.Errorf("listing models: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Capabilities{}, fmt.Errorf("listing models: %s", resp.Status)
+	}
+
+	var list struct {
+		Data []struct {
+			ID            string `json:"id"`
+			ContextLength int    `json:"context_length"`
+			Architecture  struct {
+				InputModalities []string `json:"input_modalities"`
+			} `json:"architecture"`
+			TopProvider struct {
+				MaxCompletionTokens int `json:"max_completion_tokens"`
+			} `json:"top_provider"`
+			Pricing struct {
+				Prompt string `json:"prompt"` // US dollars per token
+			} `json:"pricing"`
+			SupportedParameters []string `json:"supported_parameters"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return Capabilities{}, fmt.Errorf("listing models: %w", err)
+	}
+	for _, m := range list.Data {
+		if m.ID != modelName {
+			continue
+		}
+		c := Capabilities{ContextWindow: m.ContextLength, MaxOutput: m.TopProvider.MaxCompletionTokens, Reasoning: ReasoningNone}
+		for _, modality := range m.Architecture.InputModalities {
+			if modality == "image" {
+				c.Vision = true
+			}
+		}
+		for _, p := range m.SupportedParameters {
+			switch p {
+			case "tools":
+				c.Tools = true
+			case "reasoning":
+				c.Reasoning = ReasoningEffort
+			}
+		}
+		if price, err := strconv.ParseFloat(m.Pricing.Prompt, 64); err == nil {
+			c.PriceTier = priceTier(price * 1e6)
+		}
+		return c, nil
+	}
+	return Capabilities{}, ErrModelNotFound
+}
+
+// priceTier classifies a price in US dollars per million input tokens
+func priceTier(perMillion float64) string {
+	switch {
+	case perMillion <= 0:
+		return "free"
+	case perMillion < 1:
+		return "low"
+	case perMillion < 5:
+		return "medium"
+	}
+	return "high"
+}
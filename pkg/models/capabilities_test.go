@@ -0,0 +1,112 @@
+package models
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLookupCapabilities(t *testing.T) {
+	tests := []struct {
+		model      string
+		window     int
+		maxOutput  int
+		reasoning  string
+		wantLookup bool
+	}{
+		{"gpt-4o", 128000, 16384, ReasoningNone, true},
+		{"openai/gpt-4o-mini", 128000, 16384, ReasoningNone, true},
+		{"anthropic.claude-3-haiku-20240307-v1:0", 200000, 4096, ReasoningNone, true},
+		{"claude-sonnet-4-20250514", 200000, 64000, ReasoningBudget, true},
+		{"Qwen3:30B", 32768, 0, ReasoningThinkTags, true},
+		{"some-unknown-model", 0, 0, "", false},
+	}
+	for _, tt := range tests {
+		c, ok := LookupCapabilities(tt.model)
+		if ok != tt.wantLookup || c.ContextWindow != tt.window || c.MaxOutput != tt.maxOutput || c.Reasoning != tt.reasoning {
+			t.Errorf("LookupCapabilities(%q) = %+v, %v", tt.model, c, ok)
+		}
+	}
+}
+
+func TestSetCapabilitiesOverrides(t *testing.T) {
+	if err := SetCapabilities("test-override-*", Capabilities{ContextWindow: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetCapabilities("test-override-big", Capabilities{ContextWindow: 9000}); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := LookupCapabilities("test-override-big"); c.ContextWindow != 9000 {
+		t.Errorf("later entry did not win: %+v", c)
+	}
+	if c, _ := LookupCapabilities("test-override-small"); c.ContextWindow != 1000 {
+		t.Errorf("pattern entry not used: %+v", c)
+	}
+
+	if err := SetCapabilities("x", Capabilities{Reasoning: "loud"}); err == nil {
+		t.Error("unknown reasoning style accepted")
+	}
+	if err := SetCapabilities("[", Capabilities{}); err == nil {
+		t.Error("invalid pattern accepted")
+	}
+}
+
+func TestCapabilitiesOverflows(t *testing.T) {
+	c := Capabilities{ContextWindow: 1000, MaxOutput: 200}
+	if _, over := c.Overflows("", strings.Repeat("word ", 100)); over {
+		t.Error("small prompt overflows")
+	}
+	if tokens, over := c.Overflows("", strings.Repeat("word ", 800)); !over {
+		t.Errorf("%d-token prompt fits a 1000-token window with 200 tokens of output", tokens)
+	}
+	if _, over := (Capabilities{}).Overflows("", strings.Repeat("word ", 100000)); over {
+		t.Error("unknown window overflows")
+	}
+}
+
+func TestAnthropicLimits(t *testing.T) {
+	tests := []struct {
+		model      string
+		reasoning  ReasoningOptions
+		wantBudget int
+		wantMax    int
+	}{
+		{"claude-unknown", ReasoningOptions{BudgetTokens: 4000}, 4000, anthropicMaxTokens + 4000},
+		{"claude-3-haiku-20240307", ReasoningOptions{}, 0, 4096},
+		{"claude-3-5-sonnet-latest", ReasoningOptions{BudgetTokens: 4000}, 0, 8192},
+		{"claude-sonnet-4-0", ReasoningOptions{BudgetTokens: 4000}, 4000, anthropicMaxTokens + 4000},
+		{"claude-opus-4-1", ReasoningOptions{BudgetTokens: 30000}, 16000, 32000},
+	}
+	for _, tt := range tests {
+		budget, maxTokens := anthropicLimits(tt.model, tt.reasoning)
+		if budget != tt.wantBudget || maxTokens != tt.wantMax {
+			t.Errorf("anthropicLimits(%q) = %d, %d; want %d, %d", tt.model, budget, maxTokens, tt.wantBudget, tt.wantMax)
+		}
+	}
+}
+
+func TestFetchOllamaCapabilities(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/show" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"model_info":{"general.architecture":"gemma3","gemma3.context_length":131072},"capabilities":["completion","vision"]}`))
+	}))
+	defer srv.Close()
+
+	c, err := FetchCapabilities(context.Background(), "ollama", "gemma3:4b", "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Capabilities{ContextWindow: 131072, Vision: true, Reasoning: ReasoningNone, PriceTier: "free"}
+	if c != want {
+		t.Errorf("got %+v, want %+v", c, want)
+	}
+
+	if _, err := FetchCapabilities(context.Background(), "anthropic", "claude-sonnet-4-0", "", ""); err != ErrNoCapabilities {
+		t.Errorf("anthropic: got %v, want ErrNoCapabilities", err)
+	}
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+)
+
+// Citation is one source a model consulted, numbered by its position in the
+// list so that [1]-style markers in the text refer to it
+type Citation struct {
+	URL   string
+	Title string
+}
+
+// CitingAdapter is implemented by response adapters whose provider returns
+// the sources used for the answer next to the text
+type CitingAdapter interface {
+	ResponseAdapter
+	// Citations returns the sources carried by one SSE data payload, or nil
+	Citations(data string) []Citation
+}
+
+// sourcesInjector holds back the end of the page (from </body> on) so that a
+// sources section can be added inside the body once the stream is complete
+type sourcesInjector struct {
+	held    string // Output not sent yet: the page end, or what may be its start
+	atClose bool   // </body> has been seen; everything else is held
+}
+
+const bodyClose = "</body"
+
+// Push accepts the next piece of processed output and returns what can be sent now
+func (s *sourcesInjector) Push(chunk string) string {
+	buf := s.held + chunk
+	if s.atClose {
+		s.held = buf
+		return ""
+	}
+	lower := strings.ToLower(buf)
+	if idx := strings.Index(lower, bodyClose); idx != -1 {
+		s.atClose = true
+		s.held = buf[idx:]
+		return buf[:idx]
+	}
+	// Hold a trailing "</bo" in case the tag is split across chunks
+	for k := min(len(buf), len(bodyClose)-1); k > 0; k-- {
+		if strings.HasPrefix(bodyClose, lower[len(buf)-k:]) {
+			s.held = buf[len(buf)-k:]
+			return buf[:len(buf)-k]
+		}
+	}
+	s.held = ""
+	return buf
+}
+
+// Close returns the sources section followed by the held page end
+func (s *sourcesInjector) Close(citations []Citation) string {
+	return renderSources(citations) + s.held
+}
+
+// renderSources formats citations as a numbered list of footnote links.
+// Only http(s) links are rendered; others keep their number but no link.
+func renderSources(citations []Citation) string {
+	if len(citations) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\n<section class=\"museweb-sources\">\n<h2>Sources</h2>\n<ol>\n")
+	for i, c := range citations {
+		title := c.Title
+		if title == "" {
+			title = c.URL
+		}
+		u, err := url.Parse(c.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			fmt.Fprintf(&b, "<li id=\"source-%d\">%s</li>\n", i+1, html.EscapeString(title))
+			continue
+		}
+		fmt.Fprintf(&b, "<li id=\"source-%d\"><a href=\"%s\" target=\"_blank\" rel=\"noopener noreferrer\">%s</a></li>\n",
+			i+1, html.EscapeString(c.URL), html.EscapeString(title))
+	}
+	b.WriteString("</ol>\n</section>\n")
+	return b.String()
+}
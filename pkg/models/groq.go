@@ -0,0 +1,145 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultGroqAPIBase is GroqCloud's OpenAI-compatible API, used by backend
+// "groq" when no api_base is set
+const DefaultGroqAPIBase = "https://api.groq.com/openai/v1"
+
+// groqMaxWait is the longest a request waits for a Groq rate limit to reset;
+// longer waits fail with ErrRateLimited instead of holding the page open
+const groqMaxWait = 30 * time.Second
+
+// groqMaxRetries is how often a rate-limited request is sent again
+const groqMaxRetries = 3
+
+// ErrRateLimited is returned when the provider's rate limit does not reset in time
+var ErrRateLimited = errors.New("rate limited by the provider")
+
+// isGroq reports whether apiBase is Groq's API
+func isGroq(apiBase string) bool {
+	return strings.Contains(strings.ToLower(apiBase), "groq.com")
+}
+
+// applyGroq adapts a chat payload to Groq, which rejects the thinking switch.
+// Its reasoning models are asked to stream their reasoning in a field of its
+// own instead of <think> blocks in the content.
+func applyGroq(payload map[string]interface{}, apiBase string) {
+	if !isGroq(apiBase) {
+		return
+	}
+	if _, ok := payload["thinking"]; ok {
+		delete(payload, "thinking")
+		payload["reasoning_format"] = "parsed"
+	}
+}
+
+// groqLimits remembers, per API key, until when Groq's rate limits hold
+var groqLimits = rateLimits{until: map[string]time.Time{}}
+
+// rateLimits delays requests until a rate limit the provider reported resets
+type rateLimits struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+// wait blocks until key may send again, or fails when that is too far off
+func (l *rateLimits) wait(req *http.Request, key string) error {
+	l.mu.Lock()
+	delay := time.Until(l.until[key])
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	if delay > groqMaxWait {
+		return fmt.Errorf("%w: the limit resets in %s", ErrRateLimited, delay.Round(time.Second))
+	}
+	select {
+	case <-req.Context().Done():
+		return req.Context().Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// observe reads the rate limit headers of a response. A used-up request or
+// token allowance holds back further requests until it resets.
+func (l *rateLimits) observe(key string, h http.Header) time.Duration {
+	var delay time.Duration
+	if secs, err := strconv.Atoi(h.Get("Retry-After")); err == nil {
+		delay = time.Duration(secs) * time.Second
+	}
+	for _, kind := range []string{"requests", "tokens"} {
+		if h.Get("X-Ratelimit-Remaining-"+kind) != "0" {
+			continue
+		}
+		// Resets are Go-style durations such as "2m59.56s" or "7.66s"
+		if reset, err := time.ParseDuration(h.Get("X-Ratelimit-Reset-" + kind)); err == nil && reset > delay {
+			delay = reset
+		}
+	}
+	l.hold(key, delay)
+	return delay
+}
+
+// hold keeps key from sending for delay
+func (l *rateLimits) hold(key string, delay time.Duration) {
+	if delay <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := time.Now().Add(delay); until.After(l.until[key]) {
+		l.until[key] = until
+	}
+}
+
+// doGroq sends req, first waiting out a rate limit Groq reported earlier, and
+// sends it again after a 429 as long as the limit resets soon enough. The
+// 429 body never reaches the page.
+func doGroq(client *http.Client, req *http.Request, apiKey string, debug bool) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := groqLimits.wait(req, apiKey); err != nil {
+			return nil, err
+		}
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		delay := groqLimits.observe(apiKey, resp.Header)
+		if resp.StatusCode != http.StatusTooManyRequests {
+			return resp, nil
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if attempt == groqMaxRetries || delay > groqMaxWait {
+			return nil, fmt.Errorf("%w: Groq asked to retry in %s", ErrRateLimited, delay.Round(time.Second))
+		}
+		if delay == 0 {
+			// A 429 without a reset time; back off briefly before trying again
+			delay = time.Duration(attempt+1) * time.Second
+			groqLimits.hold(apiKey, delay)
+		}
+		log.Printf("⏳ Groq rate limit reached, retrying in %s", delay.Round(100*time.Millisecond))
+		if debug {
+			log.Printf("[DEBUG] Groq rate limit headers: %v", resp.Header)
+		}
+	}
+}
@@ -1,25 +1,114 @@
 package models
 
 import (
+	"context"
 	"io"
 	"net/http"
 )
 
-// ModelHandler is an interface for different AI model backends
+// ModelHandler is an interface for different AI model backends. Cancelling ctx
+// (e.g. when the client disconnects) aborts the upstream request.
 type ModelHandler interface {
-	StreamResponse(w io.Writer, flusher http.Flusher, systemPrompt, userPrompt string) error
+	StreamResponse(ctx context.Context, w io.Writer, flusher http.Flusher, systemPrompt, userPrompt string) error
 }
 
 // newModelHandler creates a new model handler based on the backend type
 // This is an internal implementation function called by the public NewModelHandler in models.go
-func newModelHandler(backend, modelName, apiKey, apiBase string, debug bool) ModelHandler {
+func newModelHandler(backend, modelName, apiKey, apiBase string, debug bool, opts Options) ModelHandler {
+	if opts.Mock.Record && backend != "mock" {
+		dir := opts.Mock.Dir
+		opts.Mock.Record = false
+		return &recordingHandler{next: newModelHandler(backend, modelName, apiKey, apiBase, debug, opts), dir: dir, debug: debug}
+	}
+	if b := opts.Balancer; b != nil {
+		opts.Balancer = nil
+		return &balancedHandler{balancer: b, newNode: func(nodeBase string) ModelHandler {
+			return newModelHandler(backend, modelName, apiKey, nodeBase, debug, opts)
+		}}
+	}
 	switch backend {
-	case "openai":
+	case "openai", "azure-openai", "mistral", "groq", "vllm":
+		if backend == "mistral" && apiBase == "" {
+			apiBase = DefaultMistralAPIBase
+		} else if backend == "groq" && apiBase == "" {
+			apiBase = DefaultGroqAPIBase
+		} else if backend == "vllm" && apiBase == "" {
+			apiBase = DefaultVLLMAPIBase
+		}
+		// vLLM always streams standard chunks; no need to guess the format
+		if backend == "vllm" && opts.ResponseAdapter == "" {
+			opts.ResponseAdapter = "openai"
+		}
 		return &OpenAIHandler{
+			ModelName:  modelName,
+			APIKey:     apiKey,
+			APIBase:    apiBase,
+			Debug:      debug,
+			Reasoning:  opts.Reasoning,
+			RawOutput:  opts.RawOutput,
+			OnUsage:    opts.OnUsage,
+			Azure:      backend == "azure-openai",
+			APIVersion: opts.APIVersion,
+			Adapter:    opts.ResponseAdapter,
+			OpenRouter: opts.OpenRouter,
+			Sampling:   opts.Sampling,
+			VLLM:       backend == "vllm",
+			Timeouts:   opts.Timeouts,
+			Tools:      opts.Tools,
+			Images:     opts.Images,
+		}
+	case "anthropic":
+		return &AnthropicHandler{
+			ModelName: modelName,
+			APIKey:    apiKey,
+			APIBase:   apiBase,
+			Debug:     debug,
+			Reasoning: opts.Reasoning,
+			RawOutput: opts.RawOutput,
+			OnUsage:   opts.OnUsage,
+			Timeouts:  opts.Timeouts,
+		}
+	case "bedrock":
+		return &BedrockHandler{
+			ModelName:   modelName,
+			APIKey:      apiKey,
+			APIBase:     apiBase,
+			Region:      opts.Region,
+			Credentials: opts.AWS,
+			Debug:       debug,
+			Reasoning:   opts.Reasoning,
+			RawOutput:   opts.RawOutput,
+			OnUsage:     opts.OnUsage,
+			Timeouts:    opts.Timeouts,
+		}
+	case "mock":
+		return &MockHandler{
+			Dir:     opts.Mock.Dir,
+			Delay:   opts.Mock.Delay,
+			Debug:   debug,
+			OnUsage: opts.OnUsage,
+		}
+	case "llamacpp":
+		return &LlamaCppHandler{
+			ModelName: modelName,
+			APIKey:    apiKey,
+			APIBase:   apiBase,
+			Debug:     debug,
+			RawOutput: opts.RawOutput,
+			OnUsage:   opts.OnUsage,
+			Options:   opts.LlamaCpp,
+			Timeouts:  opts.Timeouts,
+		}
+	case "tgi":
+		return &TGIHandler{
 			ModelName: modelName,
 			APIKey:    apiKey,
 			APIBase:   apiBase,
 			Debug:     debug,
+			RawOutput: opts.RawOutput,
+			OnUsage:   opts.OnUsage,
+			Options:   opts.TGI,
+			Timeouts:  opts.Timeouts,
 		}
 	default:
 		return &OllamaHandler{
@@ -28,6 +117,13 @@ func newModelHandler(backend, modelName, apiKey, apiBase string, debug bool) Mod
 			APIBase:         apiBase,
 			DisableThinking: false, // Keep for Ollama handler
 			Debug:           debug,
+			Reasoning:       opts.Reasoning,
+			RawOutput:       opts.RawOutput,
+			OnUsage:         opts.OnUsage,
+			Generate:        opts.Generate,
+			Options:         opts.Ollama,
+			Timeouts:        opts.Timeouts,
+			Images:          opts.Images,
 		}
 	}
 }
@@ -0,0 +1,227 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultLlamaCppAPIBase is where llama-server listens by default
+const DefaultLlamaCppAPIBase = "http://localhost:8080"
+
+// LlamaCppOptions are the llama.cpp sampling and caching settings
+type LlamaCppOptions struct {
+	// NPredict caps the generated tokens (0 uses the server default)
+	NPredict int
+	// CachePrompt reuses the KV cache of the previous request's common prefix
+	// (the system prompt and layout); nil uses the server default
+	CachePrompt *bool
+	// Grammar is a GBNF grammar constraining the output
+	Grammar string
+	// Template is a Go template over .System and .Prompt producing the raw
+	// prompt. Empty applies the model's own chat template through the server.
+	Template string
+}
+
+// LlamaCppHandler implements the ModelHandler interface for llama.cpp's
+// llama-server, using its native /completion endpoint rather than the
+// OpenAI-compatible shim
+type LlamaCppHandler struct {
+	ModelName string // Informational; llama-server serves the model it was started with
+	APIKey    string // Only needed when llama-server runs with --api-key
+	APIBase   string
+	Debug     bool
+	RawOutput bool
+	OnUsage   func(Usage)
+	Timeouts  Timeouts
+	Options   LlamaCppOptions
+}
+
+// llamaCppChunk is one streamed /completion result
+type llamaCppChunk struct {
+	Content         string `json:"content"`
+	Stop            bool   `json:"stop"`
+	TokensEvaluated int    `json:"tokens_evaluated"`
+	TokensPredicted int    `json:"tokens_predicted"`
+	Error           *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// endpoint returns the server URL without a trailing slash
+func (h *LlamaCppHandler) endpoint() string {
+	if h.APIBase == "" {
+		return DefaultLlamaCppAPIBase
+	}
+	return strings.TrimSuffix(h.APIBase, "/")
+}
+
+// post sends a JSON request to the server
+func (h *LlamaCppHandler) post(ctx context.Context, client *http.Client, path string, payload interface{}) (*http.Response, error) {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("error creating JSON payload: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.endpoint()+path, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("error creating HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+h.APIKey)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, newAPIError(resp, body)
+	}
+	return resp, nil
+}
+
+// prompt builds the raw prompt, either from the configured template or by
+// asking the server to apply the model's chat template. Servers without
+// /apply-template get the prompts joined.
+func (h *LlamaCppHandler) prompt(ctx context.Context, client *http.Client, systemPrompt, userPrompt string) (string, error) {
+	if h.Options.Template != "" {
+		mode := GenerateMode{Template: h.Options.Template, Raw: true}
+		return mode.render(systemPrompt, userPrompt)
+	}
+
+	var messages []map[string]string
+	if systemPrompt != "" {
+		messages = append(messages, map[string]string{"role": "system", "content": systemPrompt})
+	}
+	messages = append(messages, map[string]string{"role": "user", "content": userPrompt})
+	resp, err := h.post(ctx, client, "/apply-template", map[string]interface{}{"messages": messages})
+	if err == nil {
+		defer resp.Body.Close()
+		var applied struct {
+			Prompt string `json:"prompt"`
+		}
+		if err = json.NewDecoder(resp.Body).Decode(&applied); err == nil && applied.Prompt != "" {
+			return applied.Prompt, nil
+		}
+	}
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if h.Debug {
+		log.Printf("[DEBUG] llama.cpp could not apply the chat template (%v), joining the prompts", err)
+	}
+	return strings.TrimSpace(systemPrompt + "\n\n" + userPrompt), nil
+}
+
+// StreamResponse streams the response from llama-server
+func (h *LlamaCppHandler) StreamResponse(ctx context.Context, w io.Writer, flusher http.Flusher, systemPrompt, userPrompt string) error {
+	httpClient := h.Timeouts.client(10*time.Minute, h.Debug)
+
+	prompt, err := h.prompt(ctx, httpClient, systemPrompt, userPrompt)
+	if err != nil {
+		return err
+	}
+	payload := map[string]interface{}{
+		"prompt": prompt,
+		"stream": true,
+	}
+	if h.Options.NPredict != 0 {
+		payload["n_predict"] = h.Options.NPredict
+	}
+	if h.Options.CachePrompt != nil {
+		payload["cache_prompt"] = *h.Options.CachePrompt
+	}
+	if h.Options.Grammar != "" {
+		payload["grammar"] = h.Options.Grammar
+	}
+	if h.Debug {
+		log.Printf("🔍 Outgoing llama.cpp request: %d-byte prompt, n_predict %d, grammar %v", len(prompt), h.Options.NPredict, h.Options.Grammar != "")
+	}
+
+	httpResp, err := h.post(ctx, httpClient, "/completion", payload)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	var fullResponse strings.Builder
+	processor := NewStreamProcessor()
+	var raw fenceStripper
+
+	// Tokens are spent even when the client goes away mid-stream, so always report
+	var usage Usage
+	defer func() {
+		reportUsage(h.OnUsage, usage, systemPrompt, userPrompt, fullResponse.String())
+	}()
+
+	// Each result is a JSON object on its own line, with or without an SSE "data: " prefix
+	scanner := bufio.NewScanner(httpResp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		line = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if line == "" || line == "[DONE]" {
+			continue
+		}
+		var chunk llamaCppChunk
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			if h.Debug {
+				log.Printf("[DEBUG] Skipping unparsable chunk: %s", line)
+			}
+			continue
+		}
+		if chunk.Error != nil {
+			return fmt.Errorf("error from API: %s", chunk.Error.Message)
+		}
+		if chunk.Stop {
+			usage = Usage{PromptTokens: chunk.TokensEvaluated, CompletionTokens: chunk.TokensPredicted}
+		}
+		if chunk.Content == "" {
+			continue
+		}
+		fullResponse.WriteString(chunk.Content)
+
+		var processedContent string
+		if h.RawOutput {
+			processedContent = raw.Push(chunk.Content)
+		} else {
+			processedContent = processor.Process(chunk.Content)
+		}
+		if processedContent != "" {
+			if _, err := io.WriteString(w, processedContent); err != nil {
+				log.Printf("[ERROR] Client disconnected during streaming: %v", err)
+				return fmt.Errorf("client disconnected: %w", err)
+			}
+			flusher.Flush()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading response: %w", err)
+	}
+
+	if h.Debug {
+		log.Printf("[PROVIDER RAW RESPONSE] (llama.cpp)\n%s", fullResponse.String())
+	}
+
+	// Flush whatever is left once the model stops
+	rest := processor.Finish()
+	if h.RawOutput {
+		rest = raw.Close()
+	}
+	if rest != "" {
+		if _, err := io.WriteString(w, rest); err != nil {
+			return fmt.Errorf("client disconnected: %w", err)
+		}
+		flusher.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+)
+
+// DefaultMistralAPIBase is La Plateforme's API, used by backend "mistral"
+// when no api_base is set
+const DefaultMistralAPIBase = "https://api.mistral.ai/v1"
+
+// isMistral reports whether apiBase is Mistral's API
+func isMistral(apiBase string) bool {
+	return strings.Contains(strings.ToLower(apiBase), "mistral.ai")
+}
+
+// applyMistral adapts a chat payload to Mistral, which rejects fields it does
+// not know: the seed is called random_seed, usage is always sent with the
+// last chunk, and reasoning is not switched with thinking or reasoning_effort
+func applyMistral(payload map[string]interface{}, apiBase string, debug bool) {
+	if !isMistral(apiBase) {
+		return
+	}
+	if seed, ok := payload["seed"]; ok {
+		payload["random_seed"] = seed
+		delete(payload, "seed")
+	}
+	if n, ok := payload["max_completion_tokens"]; ok {
+		payload["max_tokens"] = n
+		delete(payload, "max_completion_tokens")
+	}
+	for _, key := range []string{"stream_options", "thinking", "reasoning_effort", "reasoning", "extra_body"} {
+		if _, ok := payload[key]; ok {
+			delete(payload, key)
+			if debug && key != "stream_options" && key != "thinking" {
+				log.Printf("[DEBUG] Mistral does not accept %s, leaving it out", key)
+			}
+		}
+	}
+}
+
+func init() {
+	RegisterAdapter(mistralAdapter{})
+}
+
+// mistralAdapter reads Mistral's chunks, whose delta content is either a
+// string or, for Magistral reasoning models, an array of typed parts
+type mistralAdapter struct{}
+
+// Name implements ResponseAdapter
+func (mistralAdapter) Name() string { return "mistral" }
+
+// Parse implements ResponseAdapter. Thinking parts are reasoning, not page content.
+func (mistralAdapter) Parse(data string, debug bool) string {
+	var chunk struct {
+		Choices []struct {
+			Delta struct {
+				Content json.RawMessage `json:"content"`
+			} `json:"delta"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		if debug {
+			log.Printf("[DEBUG] Not a valid Mistral response: %v", err)
+		}
+		return ""
+	}
+	if len(chunk.Choices) == 0 || len(chunk.Choices[0].Delta.Content) == 0 {
+		return ""
+	}
+	content := chunk.Choices[0].Delta.Content
+
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		return text
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(content, &parts); err != nil {
+		if debug {
+			log.Printf("[DEBUG] Unknown Mistral content: %s", content)
+		}
+		return ""
+	}
+	var b strings.Builder
+	for _, part := range parts {
+		if part.Type == "text" {
+			b.WriteString(part.Text)
+		}
+	}
+	return b.String()
+}
+
+// Reasoning implements ReasoningAdapter. Magistral's thinking parts hold
+// text parts of their own.
+func (mistralAdapter) Reasoning(data string) string {
+	var chunk struct {
+		Choices []struct {
+			Delta struct {
+				Content json.RawMessage `json:"content"`
+			} `json:"delta"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil || len(chunk.Choices) == 0 {
+		return ""
+	}
+	var parts []struct {
+		Type     string `json:"type"`
+		Thinking []struct {
+			Text string `json:"text"`
+		} `json:"thinking"`
+	}
+	if err := json.Unmarshal(chunk.Choices[0].Delta.Content, &parts); err != nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, part := range parts {
+		if part.Type == "thinking" {
+			for _, t := range part.Thinking {
+				b.WriteString(t.Text)
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// mockChunkSize is how much of a recording is written per replayed chunk
+const mockChunkSize = 512
+
+// MockOptions configures recording live responses and replaying them with
+// the "mock" backend
+type MockOptions struct {
+	// Dir holds the recordings, one file per prompt hash
+	Dir string
+	// Record saves every successful response of a real backend to Dir
+	Record bool
+	// Delay pauses between replayed chunks to mimic a streaming model
+	Delay time.Duration
+}
+
+// RecordingKey identifies the recording for a pair of prompts
+func RecordingKey(systemPrompt, userPrompt string) string {
+	sum := sha256.Sum256([]byte(systemPrompt + "\x00" + userPrompt))
+	return hex.EncodeToString(sum[:])
+}
+
+// recordingPath returns the file holding the recording for a pair of prompts
+func recordingPath(dir, systemPrompt, userPrompt string) string {
+	return filepath.Join(dir, RecordingKey(systemPrompt, userPrompt)+".txt")
+}
+
+// MockHandler implements the ModelHandler interface by replaying recorded
+// responses, so MuseWeb runs without an API key or GPU. Recordings hold the
+// processed output, which is written out as-is.
+type MockHandler struct {
+	Dir     string
+	Delay   time.Duration
+	Debug   bool
+	OnUsage func(Usage)
+}
+
+// StreamResponse replays the recording for the prompts
+func (h *MockHandler) StreamResponse(ctx context.Context, w io.Writer, flusher http.Flusher, systemPrompt, userPrompt string) error {
+	path := recordingPath(h.Dir, systemPrompt, userPrompt)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("no recording for this prompt at %s; record one by running with mock.record against a real backend", path)
+	} else if err != nil {
+		return err
+	}
+	if h.Debug {
+		log.Printf("🎭 Replaying %s (%d bytes)", path, len(data))
+	}
+
+	for rest := data; len(rest) > 0; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n := min(mockChunkSize, len(rest))
+		if _, err := w.Write(rest[:n]); err != nil {
+			return err
+		}
+		flusher.Flush()
+		rest = rest[n:]
+		if h.Delay > 0 && len(rest) > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(h.Delay):
+			}
+		}
+	}
+	reportUsage(h.OnUsage, Usage{}, systemPrompt, userPrompt, string(data))
+	return nil
+}
+
+// recordingHandler saves the responses of the handler it wraps for replay
+type recordingHandler struct {
+	next  ModelHandler
+	dir   string
+	debug bool
+}
+
+// StreamResponse streams the response of the wrapped handler and records it
+// once it has finished without error
+func (h *recordingHandler) StreamResponse(ctx context.Context, w io.Writer, flusher http.Flusher, systemPrompt, userPrompt string) error {
+	var buf bytes.Buffer
+	if err := h.next.StreamResponse(ctx, io.MultiWriter(w, &buf), flusher, systemPrompt, userPrompt); err != nil {
+		return err
+	}
+	if ctx.Err() != nil || buf.Len() == 0 {
+		return nil
+	}
+
+	// Write to a temporary file first so a replay never sees half a recording
+	path := recordingPath(h.dir, systemPrompt, userPrompt)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		log.Printf("⚠️  Could not record response: %v", err)
+		return nil
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Printf("⚠️  Could not record response: %v", err)
+		return nil
+	}
+	if h.debug {
+		log.Printf("🎙️ Recorded %s (%d bytes)", path, buf.Len())
+	}
+	return nil
+}
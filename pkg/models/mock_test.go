@@ -0,0 +1,49 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// fixedHandler streams a fixed response
+type fixedHandler string
+
+func (f fixedHandler) StreamResponse(ctx context.Context, w io.Writer, flusher http.Flusher, systemPrompt, userPrompt string) error {
+	_, err := io.WriteString(w, string(f))
+	return err
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	html := "<!DOCTYPE html><html><body>" + strings.Repeat("<p>recorded</p>", 100) + "</body></html>"
+
+	recorder := &recordingHandler{next: fixedHandler(html), dir: dir}
+	var live bytes.Buffer
+	if err := recorder.StreamResponse(context.Background(), &live, nopFlusher{}, "system", "user"); err != nil {
+		t.Fatal(err)
+	}
+	if live.String() != html {
+		t.Fatalf("recording changed the live response")
+	}
+
+	var usage Usage
+	mock := &MockHandler{Dir: dir, OnUsage: func(u Usage) { usage = u }}
+	var replay bytes.Buffer
+	if err := mock.StreamResponse(context.Background(), &replay, nopFlusher{}, "system", "user"); err != nil {
+		t.Fatal(err)
+	}
+	if replay.String() != html {
+		t.Errorf("replay = %q, want the recorded response", replay.String())
+	}
+	if !usage.Estimated || usage.CompletionTokens == 0 {
+		t.Errorf("usage = %+v, want estimated tokens", usage)
+	}
+
+	if err := mock.StreamResponse(context.Background(), io.Discard, nopFlusher{}, "system", "other user prompt"); err == nil {
+		t.Error("expected an error for a prompt without a recording")
+	}
+}
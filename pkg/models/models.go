@@ -6,15 +6,90 @@ package models
 //
 // The implementation details are split into separate files:
 // - interface.go: Contains the ModelHandler interface definition
+// - azure.go: Contains the Azure OpenAI endpoint and auth variants
+// - adapters.go: Contains the response adapters for OpenAI-compatible streams
+// - apierror.go: Contains the backend HTTP error type and transient error detection
+// - anthropic.go: Contains the native Anthropic Messages API implementation
+// - availability.go: Contains startup model checks and Ollama model pulls
+// - aws.go: Contains SigV4 request signing and the AWS event stream decoder
+// - balancer.go: Contains weighted load balancing across identical servers
+// - bedrock.go: Contains the Amazon Bedrock implementation
+// - capabilities.go: Contains the model capability registry and provider lookups
+// - citations.go: Contains the sources section added for citing providers
+// - groq.go: Contains the Groq API preset and its rate limit handling
+// - llamacpp.go: Contains the native llama.cpp server implementation
+// - mistral.go: Contains the Mistral API preset and its response adapter
+// - tgi.go: Contains the Hugging Face text-generation-inference implementation
+// - vllm.go: Contains the vLLM preset
+// - mock.go: Contains the replay backend and response recording
 // - ollama.go: Contains the Ollama implementation
+// - ollama_generate.go: Contains the Ollama generate-API mode
+// - ollama_options.go: Contains Ollama generation options and keep_alive
 // - openai.go: Contains the OpenAI implementation
 // - openai_custom.go: Contains custom request handling for OpenAI
+// - openrouter.go: Contains OpenRouter provider routing fields
+// - reasoning.go: Contains reasoning effort / thinking budget controls
+// - sampling.go: Contains OpenAI sampling parameters
+// - raw.go: Contains code fence stripping for non-HTML output
+// - stream.go: Contains per-request HTML stream processing
+// - timeouts.go: Contains connect and total request timeouts
+// - tools.go: Contains tool calling for OpenAI-compatible backends
 // - transport.go: Contains HTTP transport utilities
+// - usage.go: Contains token usage reporting
 // - utils.go: Contains common utility functions
+// - vision.go: Contains image input for multimodal models
 
 // NewModelHandler creates a new model handler based on the backend type
 // This is the main factory function that external code should use to create model handlers
 func NewModelHandler(backend, modelName, apiKey, apiBase string, debug bool) ModelHandler {
 	// Implementation is in interface.go
-	return newModelHandler(backend, modelName, apiKey, apiBase, debug)
+	return newModelHandler(backend, modelName, apiKey, apiBase, debug, Options{})
+}
+
+// Options holds optional per-generation settings for a model handler
+type Options struct {
+	// Reasoning forwards reasoning controls (effort, thinking budget) to the backend
+	Reasoning ReasoningOptions
+	// RawOutput streams non-HTML output (JSON, SVG, iCalendar, ...) as-is,
+	// stripping only a wrapping markdown code fence
+	RawOutput bool
+	// OnUsage, when set, receives the token usage once the generation ends
+	OnUsage func(Usage)
+	// Ollama holds Ollama generation options (num_ctx, temperature, keep_alive, ...)
+	Ollama OllamaOptions
+	// Generate switches the Ollama backend to /api/generate for this model
+	Generate *GenerateMode
+	// APIVersion is the Azure OpenAI api-version (DefaultAzureAPIVersion when empty)
+	APIVersion string
+	// ResponseAdapter names the parser for OpenAI-compatible streams ("" detects it)
+	ResponseAdapter string
+	// Sampling sets the sampling parameters of OpenAI-compatible requests
+	Sampling SamplingOptions
+	// OpenRouter adds provider routing fields for openrouter.ai API bases
+	OpenRouter *OpenRouterOptions
+	// Balancer spreads the generation over several servers, replacing apiBase
+	Balancer *Balancer
+	// Region and AWS sign Bedrock requests when no Bedrock API key is set
+	Region string
+	AWS    AWSCredentials
+	// LlamaCpp holds the llama.cpp sampling, caching, and template settings
+	LlamaCpp LlamaCppOptions
+	// TGI holds the text-generation-inference token limit and template
+	TGI TGIOptions
+	// Mock sets where the mock backend replays from and whether live
+	// responses are recorded
+	Mock MockOptions
+	// Timeouts bound connecting to the backend and the whole request
+	Timeouts Timeouts
+	// Tools the model may call before writing the page (OpenAI-compatible
+	// backends only)
+	Tools []Tool
+	// Images are sent with the user prompt to multimodal models (see
+	// AcceptsImages)
+	Images []Image
+}
+
+// NewModelHandlerWithOptions creates a model handler with per-generation options
+func NewModelHandlerWithOptions(backend, modelName, apiKey, apiBase string, debug bool, opts Options) ModelHandler {
+	return newModelHandler(backend, modelName, apiKey, apiBase, debug, opts)
 }
@@ -21,84 +21,17 @@ type OllamaHandler struct {
 	APIBase         string
 	DisableThinking bool
 	Debug           bool
-}
-
-// Streaming state tracking
-var (
-	ollamaStreamingStarted bool  // Have we started streaming to client?
-	ollamaLastSentLength   int   // How much have we sent so far?
-)
-
-// processStreamingContent implements smart streaming:
-// 1. Buffer until we find HTML start (<!DOCTYPE, <html>)
-// 2. Stream content in real-time to client
-// 3. Stop streaming after </html>, discard everything after
-func processOllamaStreamingContent(newContent string, pendingBuffer *strings.Builder) string {
-	// Add new content to pending buffer
-	pendingBuffer.WriteString(newContent)
-	bufferContent := pendingBuffer.String()
-	
-	// Phase 1: Look for HTML start if we haven't started streaming yet
-	if !ollamaStreamingStarted {
-		// Look for HTML document start patterns
-		htmlStartPos := -1
-		if strings.Contains(bufferContent, "<!DOCTYPE") {
-			htmlStartPos = strings.Index(bufferContent, "<!DOCTYPE")
-		} else if strings.Contains(bufferContent, "<html") {
-			htmlStartPos = strings.Index(bufferContent, "<html")
-		}
-		
-		if htmlStartPos != -1 {
-			// Found HTML start! Begin streaming from this point
-			ollamaStreamingStarted = true
-			ollamaLastSentLength = htmlStartPos
-			
-			// Send everything from HTML start to current buffer end
-			contentToSend := bufferContent[htmlStartPos:]
-			ollamaLastSentLength = len(bufferContent)
-			return contentToSend
-		}
-		
-		// No HTML start found yet, keep buffering
-		return ""
-	}
-	
-	// Phase 2: We're streaming - check if we've reached HTML end
-	htmlEndPos := strings.Index(strings.ToLower(bufferContent), "</html>")
-	
-	if htmlEndPos == -1 {
-		// No </html> yet - continue streaming new content
-		if len(bufferContent) > ollamaLastSentLength {
-			newPortion := bufferContent[ollamaLastSentLength:]
-			ollamaLastSentLength = len(bufferContent)
-			return newPortion
-		}
-		return ""
-		
-	} else {
-		// Found </html>! Send final portion and stop streaming
-		htmlEndFull := htmlEndPos + len("</html>")
-		
-		// Send any remaining content up to and including </html>
-		var finalContent string
-		if htmlEndFull > ollamaLastSentLength {
-			finalContent = bufferContent[ollamaLastSentLength:htmlEndFull]
-		}
-		
-		// Reset state for next request
-		pendingBuffer.Reset()
-		ollamaStreamingStarted = false
-		ollamaLastSentLength = 0
-		
-		// Everything after </html> goes to /dev/null (discarded)
-		return finalContent
-	}
+	Reasoning       ReasoningOptions
+	RawOutput       bool
+	OnUsage         func(Usage)
+	Generate        *GenerateMode // Use /api/generate instead of chat when set
+	Options         OllamaOptions // num_ctx, temperature, keep_alive, ...
+	Timeouts        Timeouts      // Connect and total request timeouts
+	Images          []Image       // Pictures sent with the user prompt
 }
 
 // StreamResponse streams the response from the Ollama model
-func (h *OllamaHandler) StreamResponse(w io.Writer, flusher http.Flusher, systemPrompt, userPrompt string) error {
-	ctx := context.Background()
-
+func (h *OllamaHandler) StreamResponse(ctx context.Context, w io.Writer, flusher http.Flusher, systemPrompt, userPrompt string) error {
 	// Determine base URL (config api_base or fallback)
 	endpoint := h.APIBase
 	if endpoint == "" {
@@ -108,36 +41,39 @@ func (h *OllamaHandler) StreamResponse(w io.Writer, flusher http.Flusher, system
 
 	// Prepare HTTP client, adding Authorization header if API key supplied and debug transport if debug enabled
 	httpClient := http.DefaultClient
+	if h.Timeouts != (Timeouts{}) {
+		httpClient = &http.Client{Transport: h.Timeouts.transport(), Timeout: h.Timeouts.Total}
+	}
 	if h.APIKey != "" {
 		if h.Debug {
 			// Use debug transport when debug mode is enabled
 			httpClient = &http.Client{
 				Transport: &utils.DebugTransport{
 					Transport: &authTransport{
-						base:   http.DefaultTransport,
+						base:   h.Timeouts.transport(),
 						apiKey: h.APIKey,
 					},
 				},
-				Timeout: 5 * time.Minute,
+				Timeout: h.Timeouts.total(5 * time.Minute),
 			}
 			log.Printf("[DEBUG] HTTP debugging enabled for Ollama client")
 		} else {
 			// Use standard transport without debug logging
 			httpClient = &http.Client{
 				Transport: &authTransport{
-					base:   http.DefaultTransport,
+					base:   h.Timeouts.transport(),
 					apiKey: h.APIKey,
 				},
-				Timeout: 5 * time.Minute,
+				Timeout: h.Timeouts.total(5 * time.Minute),
 			}
 		}
 	} else if h.Debug {
 		// No API key but debug is enabled
 		httpClient = &http.Client{
 			Transport: &utils.DebugTransport{
-				Transport: http.DefaultTransport,
+				Transport: h.Timeouts.transport(),
 			},
-			Timeout: 5 * time.Minute,
+			Timeout: h.Timeouts.total(5 * time.Minute),
 		}
 		log.Printf("[DEBUG] HTTP debugging enabled for Ollama client")
 	}
@@ -148,22 +84,67 @@ func (h *OllamaHandler) StreamResponse(w io.Writer, flusher http.Flusher, system
 		Model: h.ModelName,
 		Messages: []api.Message{
 			{Role: "system", Content: systemPrompt},
-			{Role: "user", Content: userPrompt},
+			{Role: "user", Content: userPrompt, Images: ollamaImages(h.Images)},
 		},
 		Stream: &streamOption,
 	}
 
+	// Generation options go in the request's options, keep_alive next to them
+	options, keepAlive, err := h.Options.split()
+	if err != nil {
+		return err
+	}
+	req.Options = options
+	req.KeepAlive = keepAlive
+
+	// Ollama only switches thinking on or off; the reasoning ends up in
+	// Message.Thinking, never in the page content. Reasoning models without
+	// explicit controls get think off too: left unset, Ollama passes their
+	// <think> blocks through in the content.
+	if h.Reasoning.Effort == "none" || h.DisableThinking {
+		think := false
+		req.Think = &think
+	} else if !h.Reasoning.IsZero() {
+		think := true
+		req.Think = &think
+		if h.Reasoning.BudgetTokens > 0 && h.Debug {
+			log.Printf("[DEBUG] Ollama has no thinking budget, ignoring %d tokens", h.Reasoning.BudgetTokens)
+		}
+	} else if utils.IsReasoningModel(h.ModelName, utils.ReasoningModelPatterns()) {
+		think := false
+		req.Think = &think
+	}
+
 	var fullResponse strings.Builder
-	var pendingBuffer strings.Builder
+	processor := NewDocumentStreamProcessor()
+	var raw fenceStripper
+
+	// Tokens are spent even when the client goes away mid-stream, so always report
+	var usage Usage
+	defer func() {
+		reportUsage(h.OnUsage, usage, systemPrompt, userPrompt, fullResponse.String())
+	}()
 
 	// Define a callback function to handle streaming responses
 	callbackFn := func(response api.ChatResponse) error {
+		if response.Done {
+			usage = Usage{PromptTokens: response.PromptEvalCount, CompletionTokens: response.EvalCount}
+		}
+		// Native thinking arrives separately and never reaches the page
+		if response.Message.Thinking != "" && h.Debug {
+			log.Printf("[DEBUG] Skipped %d bytes of model thinking", len(response.Message.Thinking))
+		}
 		if response.Message.Content != "" {
 			content := response.Message.Content
 			fullResponse.WriteString(content)
 			
 			// Process content for real-time streaming using the same logic as OpenAI custom
-			processedContent := processOllamaStreamingContent(content, &pendingBuffer)
+			var processedContent string
+			if h.RawOutput {
+				processedContent = raw.Push(content)
+			} else {
+				processedContent = processor.Process(content)
+			}
 			
 
 			
@@ -184,9 +165,12 @@ func (h *OllamaHandler) StreamResponse(w io.Writer, flusher http.Flusher, system
 		return nil
 	}
 
-	// Call the Chat method with the callback function
-	err := client.Chat(ctx, &req, callbackFn)
-	if err != nil {
+	// Models configured for generate mode bypass Ollama's chat template
+	if h.Generate != nil {
+		if err := h.streamGenerate(ctx, client, &req, callbackFn); err != nil {
+			return err
+		}
+	} else if err := client.Chat(ctx, &req, callbackFn); err != nil {
 		return fmt.Errorf("failed to start Ollama chat: %w", err)
 	}
 
@@ -195,27 +179,25 @@ func (h *OllamaHandler) StreamResponse(w io.Writer, flusher http.Flusher, system
 		log.Printf("[PROVIDER RAW RESPONSE] (Ollama)\n%s", fullResponse.String())
 	}
 
-	// Flush any remaining content in the pending buffer at the end of stream
-	if pendingBuffer.Len() > 0 {
-		// Apply final cleanup to any remaining pending content
-		finalPending := utils.CleanupCodeFences(pendingBuffer.String())
-		
-		// Additional end-of-stream cleanup for any remaining backticks
-		finalPending = strings.TrimSpace(finalPending)
-		if strings.HasSuffix(finalPending, "```") {
-			finalPending = strings.TrimSuffix(finalPending, "```")
-			finalPending = strings.TrimSpace(finalPending)
-		}
-		
-		if finalPending != "" {
-			_, err := io.WriteString(w, finalPending)
-			if err != nil {
-				log.Printf("[ERROR] Failed to send final pending content: %v", err)
-			} else {
-				flusher.Flush()
+	// Non-HTML output only has a possible closing fence left to drop
+	if h.RawOutput {
+		if rest := raw.Close(); rest != "" {
+			if _, err := io.WriteString(w, rest); err != nil {
+				return fmt.Errorf("client disconnected: %w", err)
 			}
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	// Flush whatever is left once the model stops
+	if finalPending := processor.Finish(); finalPending != "" {
+		_, err := io.WriteString(w, finalPending)
+		if err != nil {
+			log.Printf("[ERROR] Failed to send final pending content: %v", err)
+		} else {
+			flusher.Flush()
 		}
-		
 		if h.Debug {
 			log.Printf("[DEBUG] Flushed final pending content: %d bytes", len(finalPending))
 		}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/ollama/ollama/api"
+)
+
+// GenerateMode makes the Ollama handler use /api/generate instead of /api/chat,
+// for models whose chat templates mangle large HTML-producing prompts
+type GenerateMode struct {
+	// Template is a Go template over .System and .Prompt. Without Raw it is
+	// sent to Ollama in place of the model's own template; with Raw, MuseWeb
+	// renders it and Ollama applies no template at all. Empty uses the
+	// model's template (or, with Raw, the system and user prompts joined).
+	Template string
+	Raw      bool
+}
+
+// Validate checks that the template parses
+func (g GenerateMode) Validate() error {
+	if !g.Raw || g.Template == "" {
+		return nil
+	}
+	_, err := template.New("generate").Parse(g.Template)
+	return err
+}
+
+// GenerateModes maps model name patterns (path.Match globs) to generate modes
+type GenerateModes map[string]GenerateMode
+
+// Lookup returns the generate mode for modelName, if one is configured. An
+// exact name wins over glob patterns.
+func (m GenerateModes) Lookup(modelName string) (*GenerateMode, bool) {
+	if mode, ok := m[modelName]; ok {
+		return &mode, true
+	}
+	for pattern, mode := range m {
+		if ok, _ := path.Match(pattern, modelName); ok {
+			return &mode, true
+		}
+	}
+	return nil, false
+}
+
+// streamGenerate sends the chat request through /api/generate, passing every
+// chunk to handle as if it were a chat response
+func (h *OllamaHandler) streamGenerate(ctx context.Context, client *api.Client, chat *api.ChatRequest, handle api.ChatResponseFunc) error {
+	var systemPrompt, userPrompt string
+	var images []api.ImageData
+	for _, msg := range chat.Messages {
+		switch msg.Role {
+		case "system":
+			systemPrompt = msg.Content
+		case "user":
+			userPrompt = msg.Content
+			images = msg.Images
+		}
+	}
+
+	req := api.GenerateRequest{
+		Model:     chat.Model,
+		Stream:    chat.Stream,
+		Think:     chat.Think,
+		Raw:       h.Generate.Raw,
+		Options:   chat.Options,
+		KeepAlive: chat.KeepAlive,
+		Images:    images,
+	}
+	if h.Generate.Raw {
+		prompt, err := h.Generate.render(systemPrompt, userPrompt)
+		if err != nil {
+			return err
+		}
+		req.Prompt = prompt
+	} else {
+		req.System = systemPrompt
+		req.Prompt = userPrompt
+		req.Template = h.Generate.Template
+	}
+
+	err := client.Generate(ctx, &req, func(response api.GenerateResponse) error {
+		return handle(api.ChatResponse{
+			Model:   response.Model,
+			Message: api.Message{Role: "assistant", Content: response.Response},
+			Done:    response.Done,
+			Metrics: response.Metrics,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start Ollama generate: %w", err)
+	}
+	return nil
+}
+
+// render fills the raw template with the prompts
+func (g *GenerateMode) render(systemPrompt, userPrompt string) (string, error) {
+	if g.Template == "" {
+		return strings.TrimSpace(systemPrompt + "\n\n" + userPrompt), nil
+	}
+	tmpl, err := template.New("generate").Parse(g.Template)
+	if err != nil {
+		return "", fmt.Errorf("invalid generate template: %w", err)
+	}
+	var prompt strings.Builder
+	if err := tmpl.Execute(&prompt, struct{ System, Prompt string }{systemPrompt, userPrompt}); err != nil {
+		return "", fmt.Errorf("rendering generate template: %w", err)
+	}
+	return prompt.String(), nil
+}
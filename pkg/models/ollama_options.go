@@ -0,0 +1,74 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ollama/ollama/api"
+)
+
+// OllamaOptions are Ollama generation options such as num_ctx, temperature,
+// top_p, or repeat_penalty, forwarded in the request's options. The
+// keep_alive key (a duration like "10m", seconds, or negative to keep the
+// model loaded) is sent as the request's keep_alive instead.
+type OllamaOptions map[string]interface{}
+
+// Validate checks option names and value types against Ollama's API
+func (o OllamaOptions) Validate() error {
+	_, _, err := o.split()
+	return err
+}
+
+// Merge returns o with the entries of override on top
+func (o OllamaOptions) Merge(override OllamaOptions) OllamaOptions {
+	if len(override) == 0 {
+		return o
+	}
+	merged := make(OllamaOptions, len(o)+len(override))
+	for k, v := range o {
+		merged[k] = v
+	}
+	for k, v := range override {
+		merged[k] = v
+	}
+	return merged
+}
+
+// split separates keep_alive from the model options and checks both
+func (o OllamaOptions) split() (map[string]interface{}, *api.Duration, error) {
+	if len(o) == 0 {
+		return nil, nil, nil
+	}
+	options := make(map[string]interface{}, len(o))
+	var keepAlive *api.Duration
+	for k, v := range o {
+		if k != "keep_alive" {
+			options[k] = v
+			continue
+		}
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid keep_alive: %w", err)
+		}
+		keepAlive = &api.Duration{}
+		if err := keepAlive.UnmarshalJSON(raw); err != nil {
+			return nil, nil, fmt.Errorf("invalid keep_alive %v: %w", v, err)
+		}
+	}
+
+	// Round-trip through api.Options to catch misspelled names and wrong types
+	raw, err := json.Marshal(options)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid options: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&api.Options{}); err != nil {
+		return nil, nil, fmt.Errorf("invalid options: %w", err)
+	}
+	if len(options) == 0 {
+		options = nil
+	}
+	return options, keepAlive, nil
+}
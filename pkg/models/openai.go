@@ -13,12 +13,36 @@ type OpenAIHandler struct {
 	APIKey    string
 	APIBase   string
 	Debug     bool
+	Reasoning ReasoningOptions
+	RawOutput bool
+	OnUsage   func(Usage)
+	Adapter   string // Response adapter name; detected from APIBase when empty
+
+	// OpenRouter routing fields, only sent to openrouter.ai
+	OpenRouter *OpenRouterOptions
+
+	// Sampling sets temperature, top_p, max_tokens, and the other sampling parameters
+	Sampling SamplingOptions
+
+	// Azure switches to Azure OpenAI: deployment URLs, api-version, and api-key auth
+	Azure      bool
+	APIVersion string
+
+	// VLLM switches thinking off through the chat template, as vLLM expects
+	VLLM bool
+
+	// Timeouts bound connecting and the whole request
+	Timeouts Timeouts
+
+	// Tools are local functions the model may call before writing the page
+	Tools []Tool
+
+	// Images are sent as image_url parts of the user message
+	Images []Image
 }
 
 // StreamResponse streams the response from the OpenAI model
-func (h *OpenAIHandler) StreamResponse(w io.Writer, flusher http.Flusher, systemPrompt, userPrompt string) error {
-	ctx := context.Background()
-
+func (h *OpenAIHandler) StreamResponse(ctx context.Context, w io.Writer, flusher http.Flusher, systemPrompt, userPrompt string) error {
 	if h.Debug {
 		log.Printf("[DEBUG] Creating OpenAI stream with model: %s, API base: %s", h.ModelName, h.APIBase)
 	}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"log"
+	"strings"
+)
+
+// OpenRouterOptions are OpenRouter's routing fields, sent as-is with every
+// request to an openrouter.ai API base
+type OpenRouterOptions struct {
+	// Provider holds provider preferences such as order, allow_fallbacks,
+	// only, ignore, sort, or data_collection
+	Provider map[string]interface{}
+	// Route is "fallback" to try Models in order when the model is unavailable
+	Route string
+	// Models are the fallback models for Route
+	Models []string
+	// Transforms lists prompt transforms such as "middle-out"
+	Transforms []string
+}
+
+// isOpenRouter reports whether apiBase is the OpenRouter API
+func isOpenRouter(apiBase string) bool {
+	return strings.Contains(strings.ToLower(apiBase), "openrouter.ai")
+}
+
+// applyOpenRouterRouting adds the configured routing fields to a chat payload
+// bound for OpenRouter; other providers would reject them
+func applyOpenRouterRouting(payload map[string]interface{}, apiBase string, opts *OpenRouterOptions, debug bool) {
+	if opts == nil {
+		return
+	}
+	if !isOpenRouter(apiBase) {
+		if debug {
+			log.Printf("[DEBUG] OpenRouter routing is configured but %s is not OpenRouter, ignoring it", apiBase)
+		}
+		return
+	}
+	if len(opts.Provider) > 0 {
+		payload["provider"] = opts.Provider
+	}
+	if opts.Route != "" {
+		payload["route"] = opts.Route
+	}
+	if len(opts.Models) > 0 {
+		payload["models"] = opts.Models
+	}
+	if len(opts.Transforms) > 0 {
+		payload["transforms"] = opts.Transforms
+	}
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"strings"
+
+	"github.com/kekePower/museweb/pkg/utils"
+)
+
+// fenceStripper streams non-HTML model output unchanged except for a markdown
+// code fence wrapped around the whole response (```json ... ```), which models
+// add even when told not to. Only the opening fence line and trailing
+// backticks/whitespace are held back while streaming.
+type fenceStripper struct {
+	started bool
+	head    strings.Builder // Output received before the first real content
+	tail    string          // Trailing whitespace/backticks that may be a closing fence
+	harmony utils.HarmonyFilter
+}
+
+// Push accepts the next chunk and returns what can be sent to the client
+func (f *fenceStripper) Push(chunk string) string {
+	return f.push(f.harmony.Push(chunk))
+}
+
+// push handles model output that has been through the Harmony filter
+func (f *fenceStripper) push(chunk string) string {
+	if chunk == "" {
+		return ""
+	}
+	if !f.started {
+		f.head.WriteString(chunk)
+		trimmed := strings.TrimLeft(f.head.String(), " \t\r\n")
+		switch {
+		case trimmed == "":
+			return ""
+		case strings.HasPrefix(trimmed, "```"):
+			// Wait for the whole fence line (```json) before dropping it
+			nl := strings.IndexByte(trimmed, '\n')
+			if nl < 0 {
+				return ""
+			}
+			chunk = trimmed[nl+1:]
+		case strings.HasPrefix("```", trimmed):
+			// Could still become a fence
+			return ""
+		default:
+			chunk = trimmed
+		}
+		f.started = true
+	}
+
+	buf := f.tail + chunk
+	cut := len(strings.TrimRight(buf, " \t\r\n`"))
+	f.tail = buf[cut:]
+	return buf[:cut]
+}
+
+// Close returns the remaining output with any closing fence removed
+func (f *fenceStripper) Close() string {
+	out := f.push(f.harmony.Flush())
+	return out + f.close()
+}
+
+// close ends the output once the Harmony filter has been flushed
+func (f *fenceStripper) close() string {
+	if !f.started {
+		trimmed := strings.TrimSpace(f.head.String())
+		if strings.HasPrefix(trimmed, "```") {
+			return ""
+		}
+		return trimmed
+	}
+	rest := strings.TrimRight(f.tail, " \t\r\n")
+	if strings.HasSuffix(rest, "```") {
+		return strings.TrimRight(strings.TrimSuffix(rest, "```"), " \t\r\n")
+	}
+	return f.tail
+}
@@ -0,0 +1,133 @@
+package models
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// ReasoningOptions controls how much a reasoning model thinks before answering.
+// The zero value keeps the provider's defaults.
+type ReasoningOptions struct {
+	// Effort is "none", "minimal", "low", "medium", or "high"
+	Effort string
+	// BudgetTokens caps the tokens spent on thinking (0 = provider default)
+	BudgetTokens int
+}
+
+// reasoningEfforts are the accepted Effort values
+var reasoningEfforts = map[string]bool{"": true, "none": true, "minimal": true, "low": true, "medium": true, "high": true}
+
+// Validate reports unsupported values
+func (o ReasoningOptions) Validate() error {
+	if !reasoningEfforts[o.Effort] {
+		return fmt.Errorf("unknown reasoning effort %q (use none, minimal, low, medium, or high)", o.Effort)
+	}
+	if o.BudgetTokens < 0 {
+		return fmt.Errorf("thinking budget must not be negative, got %d", o.BudgetTokens)
+	}
+	return nil
+}
+
+// IsZero reports whether no reasoning controls are set
+func (o ReasoningOptions) IsZero() bool {
+	return o.Effort == "" && o.BudgetTokens == 0
+}
+
+// Merge returns o with any fields set in override replacing its own
+func (o ReasoningOptions) Merge(override ReasoningOptions) ReasoningOptions {
+	if override.Effort != "" {
+		o.Effort = override.Effort
+	}
+	if override.BudgetTokens != 0 {
+		o.BudgetTokens = override.BudgetTokens
+	}
+	return o
+}
+
+// openAIReasoningModel matches OpenAI's reasoning models (o1, o3-mini, o4-mini,
+// gpt-5, ...), the only ones its API accepts reasoning_effort for
+var openAIReasoningModel = regexp.MustCompile(`^(o[1-9]|gpt-5)`)
+
+// isOpenAIReasoningModel reports whether modelName is one of OpenAI's own
+// reasoning models served by api.openai.com. Azure deployment names say
+// nothing about the model behind them, so Azure is left alone.
+func isOpenAIReasoningModel(apiBase, modelName string) bool {
+	return strings.Contains(strings.ToLower(apiBase), "api.openai.com") &&
+		openAIReasoningModel.MatchString(strings.ToLower(modelName))
+}
+
+// applyOpenAIReasoning adds the reasoning controls to an OpenAI-compatible chat
+// payload. Providers disagree on the parameter names, so the API base decides
+// which dialect is sent.
+func applyOpenAIReasoning(payload map[string]interface{}, apiBase, modelName string, opts ReasoningOptions, debug bool) {
+	if opts.IsZero() {
+		return
+	}
+	base := strings.ToLower(apiBase)
+
+	switch {
+	case strings.Contains(base, "openrouter.ai"):
+		// OpenRouter accepts either an effort or a token budget
+		reasoning := map[string]interface{}{}
+		switch {
+		case opts.Effort == "none":
+			reasoning["enabled"] = false
+		case opts.BudgetTokens > 0:
+			reasoning["max_tokens"] = opts.BudgetTokens
+		case opts.Effort != "":
+			reasoning["effort"] = opts.Effort
+		}
+		payload["reasoning"] = reasoning
+		delete(payload, "thinking")
+
+	case strings.Contains(base, "anthropic.com"):
+		// Anthropic only understands an explicit thinking budget
+		if opts.BudgetTokens > 0 && opts.Effort != "none" {
+			payload["thinking"] = map[string]interface{}{"type": "enabled", "budget_tokens": opts.BudgetTokens}
+		} else {
+			payload["thinking"] = map[string]interface{}{"type": "disabled"}
+		}
+
+	case strings.Contains(base, "generativelanguage.googleapis.com"):
+		if opts.BudgetTokens > 0 {
+			payload["extra_body"] = map[string]interface{}{
+				"google": map[string]interface{}{
+					"thinking_config": map[string]interface{}{"thinking_budget": opts.BudgetTokens},
+				},
+			}
+		} else if opts.Effort != "" {
+			payload["reasoning_effort"] = opts.Effort
+		}
+		delete(payload, "thinking")
+
+	case strings.Contains(base, "dashscope"):
+		// Qwen on DashScope toggles thinking and takes a budget
+		payload["enable_thinking"] = opts.Effort != "none"
+		if opts.BudgetTokens > 0 {
+			payload["thinking_budget"] = opts.BudgetTokens
+		}
+		delete(payload, "thinking")
+
+	default:
+		// OpenAI and most compatible providers (Groq, xAI, Together, vLLM, ...).
+		// OpenAI rejects reasoning_effort for gpt-4o and friends, so a global
+		// effort must not break pages served by its non-reasoning models, nor
+		// by any model the capability registry knows does not reason.
+		if opts.Effort != "" {
+			caps, _ := LookupCapabilities(modelName)
+			if (strings.Contains(base, "api.openai.com") && !isOpenAIReasoningModel(apiBase, modelName)) || caps.Reasoning == ReasoningNone {
+				if debug {
+					log.Printf("[DEBUG] %s is not a reasoning model, ignoring reasoning effort %q", modelName, opts.Effort)
+				}
+				break
+			}
+			payload["reasoning_effort"] = opts.Effort
+			delete(payload, "thinking")
+		}
+		if opts.BudgetTokens > 0 && debug {
+			log.Printf("[DEBUG] Thinking budget is not supported by %s, ignoring it", apiBase)
+		}
+	}
+}
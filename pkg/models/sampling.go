@@ -0,0 +1,122 @@
+package models
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// SamplingOptions are the sampling parameters of an OpenAI chat completion.
+// Unset fields are not sent, leaving the provider's defaults.
+type SamplingOptions struct {
+	Temperature      *float64
+	TopP             *float64
+	MaxTokens        int // 0 = no limit
+	PresencePenalty  *float64
+	FrequencyPenalty *float64
+	Seed             *int // Makes sampling (mostly) deterministic where supported
+	Stop             []string
+}
+
+// Validate reports values outside the ranges the OpenAI API accepts
+func (o SamplingOptions) Validate() error {
+	inRange := func(name string, v *float64, lo, hi float64) error {
+		if v != nil && (*v < lo || *v > hi) {
+			return fmt.Errorf("%s must be between %g and %g, got %g", name, lo, hi, *v)
+		}
+		return nil
+	}
+	for _, err := range []error{
+		inRange("temperature", o.Temperature, 0, 2),
+		inRange("top_p", o.TopP, 0, 1),
+		inRange("presence_penalty", o.PresencePenalty, -2, 2),
+		inRange("frequency_penalty", o.FrequencyPenalty, -2, 2),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	if o.MaxTokens < 0 {
+		return fmt.Errorf("max_tokens must not be negative, got %d", o.MaxTokens)
+	}
+	if len(o.Stop) > 4 {
+		return fmt.Errorf("at most 4 stop sequences are allowed, got %d", len(o.Stop))
+	}
+	return nil
+}
+
+// Merge returns o with the values set in override replacing its own
+func (o SamplingOptions) Merge(override SamplingOptions) SamplingOptions {
+	if override.Temperature != nil {
+		o.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		o.TopP = override.TopP
+	}
+	if override.MaxTokens > 0 {
+		o.MaxTokens = override.MaxTokens
+	}
+	if override.PresencePenalty != nil {
+		o.PresencePenalty = override.PresencePenalty
+	}
+	if override.FrequencyPenalty != nil {
+		o.FrequencyPenalty = override.FrequencyPenalty
+	}
+	if override.Seed != nil {
+		o.Seed = override.Seed
+	}
+	if len(override.Stop) > 0 {
+		o.Stop = override.Stop
+	}
+	return o
+}
+
+// applySampling adds the sampling parameters to an OpenAI-compatible chat
+// payload. OpenAI itself (and Azure) replaced max_tokens with
+// max_completion_tokens, which reasoning models require; other providers
+// still expect max_tokens. OpenAI's reasoning models reject the sampling
+// parameters, so those are left out for them.
+func applySampling(payload map[string]interface{}, apiBase, modelName string, azure bool, opts SamplingOptions, debug bool) {
+	reasoningModel := isOpenAIReasoningModel(apiBase, modelName)
+	set := func(key string, v *float64) {
+		if v == nil {
+			return
+		}
+		if reasoningModel {
+			if debug {
+				log.Printf("[DEBUG] %s does not accept %s, ignoring it", modelName, key)
+			}
+			return
+		}
+		payload[key] = *v
+	}
+	set("temperature", opts.Temperature)
+	set("top_p", opts.TopP)
+	set("presence_penalty", opts.PresencePenalty)
+	set("frequency_penalty", opts.FrequencyPenalty)
+	if opts.Seed != nil {
+		payload["seed"] = *opts.Seed
+	}
+	if len(opts.Stop) > 0 {
+		if reasoningModel {
+			if debug {
+				log.Printf("[DEBUG] %s does not accept stop sequences, ignoring them", modelName)
+			}
+		} else {
+			payload["stop"] = opts.Stop
+		}
+	}
+	if caps, ok := LookupCapabilities(modelName); ok && caps.MaxOutput > 0 && opts.MaxTokens > caps.MaxOutput {
+		if debug {
+			log.Printf("[DEBUG] %s writes at most %d tokens, lowering max_tokens from %d", modelName, caps.MaxOutput, opts.MaxTokens)
+		}
+		opts.MaxTokens = caps.MaxOutput
+	}
+	if opts.MaxTokens > 0 {
+		if azure || strings.Contains(strings.ToLower(apiBase), "api.openai.com") {
+			payload["max_completion_tokens"] = opts.MaxTokens
+		} else {
+			payload["max_tokens"] = opts.MaxTokens
+		}
+	}
+}
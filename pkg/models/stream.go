@@ -0,0 +1,133 @@
+package models
+
+import (
+	"log"
+	"strings"
+
+	"github.com/kekePower/museweb/pkg/utils"
+)
+
+// StreamProcessor turns the raw chunks of one model response into the HTML
+// sent to the client. Each handler invocation owns its own processor, so
+// concurrent requests never share streaming state.
+type StreamProcessor struct {
+	buffer      strings.Builder     // Everything received so far
+	sent        int                 // How much of the output we have sent
+	started     bool                // The HTML document start has been seen
+	done        bool                // </html> was sent; the rest is discarded
+	passthrough bool                // Stream the document unchanged instead of cleaning it
+	harmony     utils.HarmonyFilter // Drops gpt-oss reasoning channels
+}
+
+// NewStreamProcessor creates a processor that cleans code fences from the whole
+// buffer on every chunk (handles fences split across chunks) and sends only the
+// part of the cleaned output that is new
+func NewStreamProcessor() *StreamProcessor {
+	return &StreamProcessor{}
+}
+
+// NewDocumentStreamProcessor creates a processor that streams the document
+// through unchanged once it starts
+func NewDocumentStreamProcessor() *StreamProcessor {
+	return &StreamProcessor{passthrough: true}
+}
+
+// Process adds a chunk of model output and returns what should be sent now.
+// Output is buffered until the HTML document starts (<!DOCTYPE or <html), and
+// everything after </html> is discarded to drop LLM chatter on either side.
+func (p *StreamProcessor) Process(chunk string) string {
+	if p.done {
+		return ""
+	}
+	return p.process(p.harmony.Push(chunk))
+}
+
+// process handles model output that has been through the Harmony filter
+func (p *StreamProcessor) process(chunk string) string {
+	if chunk == "" {
+		return ""
+	}
+	p.buffer.WriteString(chunk)
+	content := p.buffer.String()
+
+	if !p.started {
+		start := documentStart(content)
+		if start == -1 {
+			// No HTML start found yet, keep buffering
+			return ""
+		}
+		p.started = true
+		if p.passthrough {
+			p.sent = start
+		}
+	}
+	content = p.cutAtDocumentEnd(content)
+
+	if p.passthrough {
+		// Hold back trailing backticks in case they are a closing fence
+		return p.advance(strings.TrimRight(content, " \t\r\n`"))
+	}
+	return p.advance(utils.CleanupCodeFences(content))
+}
+
+// Finish returns whatever is left to send once the model stops, with any
+// trailing code fence removed
+func (p *StreamProcessor) Finish() string {
+	if p.done {
+		return ""
+	}
+	out := p.process(p.harmony.Flush())
+	if p.done {
+		return out
+	}
+	content := p.buffer.String()
+	if p.passthrough && p.started {
+		return out + p.advance(trimTrailingFence(content))
+	}
+	return out + p.advance(trimTrailingFence(utils.CleanupCodeFences(content)))
+}
+
+// documentStart returns the index where the HTML document begins, or -1
+func documentStart(content string) int {
+	if start := strings.Index(content, "<!DOCTYPE"); start != -1 {
+		return start
+	}
+	return strings.Index(content, "<html")
+}
+
+// cutAtDocumentEnd truncates content after </html> and marks the stream done
+func (p *StreamProcessor) cutAtDocumentEnd(content string) string {
+	end := strings.Index(strings.ToLower(content), "</html>")
+	if end == -1 {
+		return content
+	}
+	p.done = true
+	return content[:end+len("</html>")]
+}
+
+// advance returns the part of output that has not been sent yet
+func (p *StreamProcessor) advance(output string) string {
+	if len(output) <= p.sent {
+		return ""
+	}
+	next := output[p.sent:]
+	p.sent = len(output)
+	return next
+}
+
+// trimTrailingFence removes trailing whitespace and a closing ``` left at the end of a response
+func trimTrailingFence(s string) string {
+	s = strings.TrimRight(s, " \t\r\n")
+	if strings.HasSuffix(s, "```") {
+		s = strings.TrimRight(strings.TrimSuffix(s, "```"), " \t\r\n")
+	}
+	return s
+}
+
+// logFinish warns when a generation stopped at its token limit, which
+// leaves the page cut off
+func logFinish(modelName, reason string) {
+	if reason == "length" {
+		log.Printf("✂️  %s stopped at its token limit; the page is probably cut off", modelName)
+	}
+}
@@ -0,0 +1,166 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type nopFlusher struct{}
+
+func (nopFlusher) Flush() {}
+
+// page returns a distinct small HTML document for request i
+func page(i int) string {
+	return fmt.Sprintf("<!DOCTYPE html><html><body><h1>Page %d</h1><p>%s</p></body></html>", i, strings.Repeat("content ", 20))
+}
+
+// chunks splits s into pieces of size n, like a model streaming tokens
+func chunks(s string, n int) []string {
+	var out []string
+	for len(s) > n {
+		out = append(out, s[:n])
+		s = s[n:]
+	}
+	return append(out, s)
+}
+
+func run(p *StreamProcessor, parts []string) string {
+	var out strings.Builder
+	for _, c := range parts {
+		out.WriteString(p.Process(c))
+	}
+	out.WriteString(p.Finish())
+	return out.String()
+}
+
+func TestStreamProcessor(t *testing.T) {
+	doc := page(1)
+	tests := []struct {
+		name      string
+		processor func() *StreamProcessor
+		input     string
+		want      string
+	}{
+		{"clean document", NewStreamProcessor, doc, doc},
+		{"code fence and chatter", NewStreamProcessor, "```html\n" + doc + "\n```\nHope this helps!", doc},
+		{"document mode skips preamble", NewDocumentStreamProcessor, "Sure! Here it is:\n" + doc + "\nEnjoy.", doc},
+		{"document mode without end tag", NewDocumentStreamProcessor, "<html><body>unfinished\n```", "<html><body>unfinished"},
+		{"harmony channels", NewDocumentStreamProcessor,
+			"<|channel|>analysis<|message|>Draft: <!DOCTYPE html><html></html><|end|><|start|>assistant<|channel|>final<|message|>" + doc + "<|return|>", doc},
+		{"harmony without special tokens", NewStreamProcessor, "analysisThe user wants a page.assistantfinal" + doc, doc},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, size := range []int{1, 3, 7, 64, len(tt.input)} {
+				if got := run(tt.processor(), chunks(tt.input, size)); got != tt.want {
+					t.Errorf("chunk size %d:\n got %q\nwant %q", size, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamProcessorConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for _, newProcessor := range []func() *StreamProcessor{NewStreamProcessor, NewDocumentStreamProcessor} {
+				if got := run(newProcessor(), chunks(page(i), 5)); got != page(i) {
+					t.Errorf("request %d: got %q", i, got)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// promptIndex recovers the page number a fake backend should answer with
+func promptIndex(t *testing.T, userPrompt string) int {
+	var i int
+	if _, err := fmt.Sscanf(userPrompt, "page %d", &i); err != nil {
+		t.Errorf("unexpected prompt %q", userPrompt)
+	}
+	return i
+}
+
+func TestOpenAIHandlerConcurrentRequests(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []struct{ Content string } `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		i := promptIndex(t, req.Messages[len(req.Messages)-1].Content)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, c := range chunks(page(i), 4) {
+			data, _ := json.Marshal(map[string]interface{}{
+				"choices": []map[string]interface{}{{"delta": map[string]string{"content": c}}},
+			})
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			w.(http.Flusher).Flush()
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer backend.Close()
+
+	testConcurrentHandlers(t, func() ModelHandler {
+		return NewModelHandler("openai", "test-model", "key", backend.URL, false)
+	})
+}
+
+func TestOllamaHandlerConcurrentRequests(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []struct{ Content string } `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		i := promptIndex(t, req.Messages[len(req.Messages)-1].Content)
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		for _, c := range chunks(page(i), 4) {
+			enc.Encode(map[string]interface{}{
+				"model":   "test-model",
+				"message": map[string]string{"role": "assistant", "content": c},
+				"done":    false,
+			})
+			w.(http.Flusher).Flush()
+		}
+		enc.Encode(map[string]interface{}{"model": "test-model", "done": true})
+	}))
+	defer backend.Close()
+
+	testConcurrentHandlers(t, func() ModelHandler {
+		return NewModelHandler("ollama", "test-model", "", backend.URL, false)
+	})
+}
+
+// testConcurrentHandlers streams many pages at once and checks that no
+// response contains another request's output
+func testConcurrentHandlers(t *testing.T, newHandler func() ModelHandler) {
+	var wg sync.WaitGroup
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var out bytes.Buffer
+			if err := newHandler().StreamResponse(context.Background(), &out, nopFlusher{}, "system", fmt.Sprintf("page %d", i)); err != nil {
+				t.Errorf("request %d: %v", i, err)
+				return
+			}
+			if got := out.String(); got != page(i) {
+				t.Errorf("request %d:\n got %q\nwant %q", i, got, page(i))
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,223 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultTGIAPIBase is where text-generation-inference listens in its Docker image
+const DefaultTGIAPIBase = "http://localhost:8080"
+
+// TGIOptions are the text-generation-inference generation settings
+type TGIOptions struct {
+	// MaxNewTokens caps the generated tokens (0 uses the server default,
+	// which can be too short for a whole page)
+	MaxNewTokens int
+	// Template is a Go template over .System and .Prompt producing the raw
+	// prompt. Empty applies the model's own chat template through the server.
+	Template string
+}
+
+// TGIHandler implements the ModelHandler interface for Hugging Face
+// text-generation-inference, using its native /generate_stream endpoint
+type TGIHandler struct {
+	ModelName string // Informational; TGI serves the model it was started with
+	APIKey    string // Only needed behind an authenticating proxy or Inference Endpoints
+	APIBase   string
+	Debug     bool
+	RawOutput bool
+	OnUsage   func(Usage)
+	Timeouts  Timeouts
+	Options   TGIOptions
+}
+
+// tgiChunk is one streamed /generate_stream event. Each carries a token; the
+// last also carries the whole text and the details.
+type tgiChunk struct {
+	Token *struct {
+		Text    string `json:"text"`
+		Special bool   `json:"special"` // End-of-sequence and other control tokens
+	} `json:"token"`
+	Details *struct {
+		FinishReason    string `json:"finish_reason"` // "length", "eos_token", or "stop_sequence"
+		GeneratedTokens int    `json:"generated_tokens"`
+	} `json:"details"`
+	Error     string `json:"error"`
+	ErrorType string `json:"error_type"`
+}
+
+// endpoint returns the server URL without a trailing slash
+func (h *TGIHandler) endpoint() string {
+	if h.APIBase == "" {
+		return DefaultTGIAPIBase
+	}
+	return strings.TrimSuffix(h.APIBase, "/")
+}
+
+// post sends a JSON request to the server
+func (h *TGIHandler) post(ctx context.Context, client *http.Client, path string, payload interface{}) (*http.Response, error) {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("error creating JSON payload: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.endpoint()+path, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("error creating HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+h.APIKey)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, newAPIError(resp, body)
+	}
+	return resp, nil
+}
+
+// prompt builds the raw prompt, either from the configured template or by
+// asking the server to apply the model's chat template with /chat_tokenize.
+// Servers without it get the prompts joined.
+func (h *TGIHandler) prompt(ctx context.Context, client *http.Client, systemPrompt, userPrompt string) (string, error) {
+	if h.Options.Template != "" {
+		mode := GenerateMode{Template: h.Options.Template, Raw: true}
+		return mode.render(systemPrompt, userPrompt)
+	}
+
+	var messages []map[string]string
+	if systemPrompt != "" {
+		messages = append(messages, map[string]string{"role": "system", "content": systemPrompt})
+	}
+	messages = append(messages, map[string]string{"role": "user", "content": userPrompt})
+	resp, err := h.post(ctx, client, "/chat_tokenize", map[string]interface{}{"model": "tgi", "messages": messages})
+	if err == nil {
+		defer resp.Body.Close()
+		var applied struct {
+			TemplatedText string `json:"templated_text"`
+		}
+		if err = json.NewDecoder(resp.Body).Decode(&applied); err == nil && applied.TemplatedText != "" {
+			return applied.TemplatedText, nil
+		}
+	}
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if h.Debug {
+		log.Printf("[DEBUG] TGI could not apply the chat template (%v), joining the prompts", err)
+	}
+	return strings.TrimSpace(systemPrompt + "\n\n" + userPrompt), nil
+}
+
+// StreamResponse streams the response from text-generation-inference. TGI
+// reports only the generated tokens, so the prompt tokens are estimated.
+func (h *TGIHandler) StreamResponse(ctx context.Context, w io.Writer, flusher http.Flusher, systemPrompt, userPrompt string) error {
+	httpClient := h.Timeouts.client(10*time.Minute, h.Debug)
+
+	prompt, err := h.prompt(ctx, httpClient, systemPrompt, userPrompt)
+	if err != nil {
+		return err
+	}
+	parameters := map[string]interface{}{"details": true}
+	if h.Options.MaxNewTokens != 0 {
+		parameters["max_new_tokens"] = h.Options.MaxNewTokens
+	}
+	payload := map[string]interface{}{
+		"inputs":     prompt,
+		"parameters": parameters,
+	}
+	if h.Debug {
+		log.Printf("🔍 Outgoing TGI request: %d-byte prompt, max_new_tokens %d", len(prompt), h.Options.MaxNewTokens)
+	}
+
+	httpResp, err := h.post(ctx, httpClient, "/generate_stream", payload)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	var fullResponse strings.Builder
+	processor := NewStreamProcessor()
+	var raw fenceStripper
+
+	// Tokens are spent even when the client goes away mid-stream, so always report
+	var usage Usage
+	defer func() {
+		reportUsage(h.OnUsage, usage, systemPrompt, userPrompt, fullResponse.String())
+	}()
+
+	// Events are SSE "data:" lines without a space after the colon
+	scanner := bufio.NewScanner(httpResp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "data:")
+		line = strings.TrimSpace(line)
+		if !ok || line == "" {
+			continue
+		}
+		var chunk tgiChunk
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			if h.Debug {
+				log.Printf("[DEBUG] Skipping unparsable chunk: %s", line)
+			}
+			continue
+		}
+		if chunk.Error != "" {
+			return fmt.Errorf("error from API (%s): %s", chunk.ErrorType, chunk.Error)
+		}
+		if chunk.Details != nil {
+			usage = Usage{PromptTokens: EstimateTokens(prompt), CompletionTokens: chunk.Details.GeneratedTokens, Estimated: true}
+			logFinish(h.ModelName, chunk.Details.FinishReason)
+		}
+		if chunk.Token == nil || chunk.Token.Special || chunk.Token.Text == "" {
+			continue
+		}
+		fullResponse.WriteString(chunk.Token.Text)
+
+		var processedContent string
+		if h.RawOutput {
+			processedContent = raw.Push(chunk.Token.Text)
+		} else {
+			processedContent = processor.Process(chunk.Token.Text)
+		}
+		if processedContent != "" {
+			if _, err := io.WriteString(w, processedContent); err != nil {
+				log.Printf("[ERROR] Client disconnected during streaming: %v", err)
+				return fmt.Errorf("client disconnected: %w", err)
+			}
+			flusher.Flush()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading response: %w", err)
+	}
+
+	if h.Debug {
+		log.Printf("[PROVIDER RAW RESPONSE] (TGI)\n%s", fullResponse.String())
+	}
+
+	// Flush whatever is left once the model stops
+	rest := processor.Finish()
+	if h.RawOutput {
+		rest = raw.Close()
+	}
+	if rest != "" {
+		if _, err := io.WriteString(w, rest); err != nil {
+			return fmt.Errorf("client disconnected: %w", err)
+		}
+		flusher.Flush()
+	}
+	return nil
+}
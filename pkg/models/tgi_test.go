@@ -0,0 +1,57 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTGIStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/chat_tokenize":
+			fmt.Fprint(w, `{"templated_text":"<s>[INST] hi [/INST]"}`)
+		case "/generate_stream":
+			w.Header().Set("Content-Type", "text/event-stream")
+			for _, text := range []string{"<html>", "<p>hi</p>", "</html>"} {
+				fmt.Fprintf(w, "data:{\"token\":{\"id\":1,\"text\":%q,\"special\":false},\"generated_text\":null,\"details\":null}\n\n", text)
+			}
+			fmt.Fprint(w, "data:{\"token\":{\"id\":2,\"text\":\"</s>\",\"special\":true},\"generated_text\":\"<html><p>hi</p></html>\",\"details\":{\"finish_reason\":\"eos_token\",\"generated_tokens\":4}}\n\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	var usage Usage
+	h := &TGIHandler{APIBase: srv.URL, OnUsage: func(u Usage) { usage = u }}
+	var buf bytes.Buffer
+	if err := h.StreamResponse(context.Background(), &buf, nopFlusher{}, "sys", "hi"); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "<html><p>hi</p></html>" {
+		t.Errorf("page = %q", got)
+	}
+	if usage.CompletionTokens != 4 {
+		t.Errorf("usage = %+v, want 4 completion tokens", usage)
+	}
+}
+
+func TestTGIError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/chat_tokenize" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "data:{\"error\":\"Input validation error\",\"error_type\":\"validation\"}\n\n")
+	}))
+	defer srv.Close()
+
+	h := &TGIHandler{APIBase: srv.URL}
+	if err := h.StreamResponse(context.Background(), &bytes.Buffer{}, nopFlusher{}, "sys", "hi"); err == nil {
+		t.Fatal("expected the streamed error to be returned")
+	}
+}
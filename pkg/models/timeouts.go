@@ -0,0 +1,64 @@
+package models
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kekePower/museweb/pkg/tracing"
+	"github.com/kekePower/museweb/pkg/utils"
+)
+
+// Timeouts bound the HTTP requests to a backend. The time to the first token
+// is enforced by the server, which can fail over when it runs out.
+type Timeouts struct {
+	// Connect bounds dialing and the TLS handshake (0 keeps Go's defaults)
+	Connect time.Duration
+	// Total bounds the whole request, the streamed response included
+	// (0 keeps the backend's default, 5 or 10 minutes)
+	Total time.Duration
+}
+
+// transports holds one transport per connect timeout, so requests keep
+// sharing connections
+var transports sync.Map // time.Duration -> *http.Transport
+
+// transport returns the base transport for requests with these timeouts.
+// Requests made within a traced generation get a span of their own.
+func (t Timeouts) transport() http.RoundTripper {
+	return tracing.Transport(t.baseTransport())
+}
+
+// baseTransport returns the shared transport for the connect timeout
+func (t Timeouts) baseTransport() *http.Transport {
+	if t.Connect <= 0 {
+		return http.DefaultTransport.(*http.Transport)
+	}
+	if tr, ok := transports.Load(t.Connect); ok {
+		return tr.(*http.Transport)
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = (&net.Dialer{Timeout: t.Connect, KeepAlive: 30 * time.Second}).DialContext
+	tr.TLSHandshakeTimeout = t.Connect
+	actual, _ := transports.LoadOrStore(t.Connect, tr)
+	return actual.(*http.Transport)
+}
+
+// total returns the total timeout, or def when none is set
+func (t Timeouts) total(def time.Duration) time.Duration {
+	if t.Total > 0 {
+		return t.Total
+	}
+	return def
+}
+
+// client returns an HTTP client with these timeouts, logging every request
+// and response when debug is set. def is the backend's total timeout.
+func (t Timeouts) client(def time.Duration, debug bool) *http.Client {
+	transport := t.transport()
+	if debug {
+		transport = &utils.DebugTransport{Transport: transport}
+	}
+	return &http.Client{Transport: transport, Timeout: t.total(def)}
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeoutsClient(t *testing.T) {
+	if c := (Timeouts{}).client(5*time.Minute, false); c.Timeout != 5*time.Minute || (Timeouts{}).baseTransport() != http.DefaultTransport {
+		t.Errorf("default client: timeout %v, transport %T", c.Timeout, c.Transport)
+	}
+
+	timeouts := Timeouts{Connect: 3 * time.Second, Total: time.Minute}
+	c := timeouts.client(5*time.Minute, false)
+	if c.Timeout != time.Minute {
+		t.Errorf("Timeout = %v, want 1m", c.Timeout)
+	}
+	tr := timeouts.baseTransport()
+	if tr == http.DefaultTransport || tr.TLSHandshakeTimeout != 3*time.Second {
+		t.Fatal("transport not set up for a 3s connect timeout")
+	}
+	// Connections are pooled across requests
+	if timeouts.baseTransport() != tr {
+		t.Error("transport not reused for the same connect timeout")
+	}
+}
+
+func TestTimeoutsTotal(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data: {}\n"))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	h := &TGIHandler{APIBase: srv.URL, Options: TGIOptions{Template: "{{.Prompt}}"}, Timeouts: Timeouts{Total: 100 * time.Millisecond}}
+	start := time.Now()
+	var out strings.Builder
+	err := h.StreamResponse(t.Context(), &out, nopFlusher{}, "", "hi")
+	if err == nil {
+		t.Fatal("stream outliving the total timeout did not fail")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("total timeout took %v to apply", elapsed)
+	}
+}
@@ -0,0 +1,167 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// maxToolRounds caps how often the model may call tools before it must write
+// the page
+const maxToolRounds = 5
+
+// maxToolResult caps the tool output handed back to the model, in bytes
+const maxToolResult = 64 * 1024
+
+// Tool is a local function the model may call while writing a page
+type Tool struct {
+	Name        string
+	Description string
+	// Parameters is the JSON schema of the arguments object
+	Parameters map[string]interface{}
+	// Run executes the call; the result (or error) is what the model sees
+	Run func(ctx context.Context, args json.RawMessage) (string, error)
+}
+
+// openAITools declares tools in the chat completions format
+func openAITools(tools []Tool) []map[string]interface{} {
+	declared := make([]map[string]interface{}, len(tools))
+	for i, t := range tools {
+		params := t.Parameters
+		if params == nil {
+			params = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+		}
+		declared[i] = map[string]interface{}{
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":        t.Name,
+				"description": t.Description,
+				"parameters":  params,
+			},
+		}
+	}
+	return declared
+}
+
+// toolCallDelta is a streamed piece of a tool call. The first piece of a
+// call carries its ID and name; the arguments arrive in fragments.
+type toolCallDelta struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// toolCall is one complete call the model asked for
+type toolCall struct {
+	ID        string
+	Name      string
+	Arguments strings.Builder
+}
+
+// toolCalls assembles the tool calls of one response from its deltas
+type toolCalls map[int]*toolCall
+
+// add collects the tool call deltas of a stream event
+func (tc toolCalls) add(data string) {
+	var chunk openAIChunk
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return
+	}
+	for _, choice := range chunk.Choices {
+		msg := choice.Delta
+		if msg == nil {
+			msg = choice.Message
+		}
+		if msg == nil {
+			continue
+		}
+		for _, d := range msg.ToolCalls {
+			call := tc[d.Index]
+			if call == nil {
+				call = &toolCall{}
+				tc[d.Index] = call
+			}
+			if d.ID != "" {
+				call.ID = d.ID
+			}
+			if d.Function.Name != "" {
+				call.Name = d.Function.Name
+			}
+			call.Arguments.WriteString(d.Function.Arguments)
+		}
+	}
+}
+
+// ordered returns the calls in the order the model made them
+func (tc toolCalls) ordered() []*toolCall {
+	indexes := make([]int, 0, len(tc))
+	for i := range tc {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	calls := make([]*toolCall, len(indexes))
+	for i, index := range indexes {
+		calls[i] = tc[index]
+	}
+	return calls
+}
+
+// runTools executes the calls and returns the assistant message that made
+// them followed by one tool message per result, ready to be sent back
+func runTools(ctx context.Context, tools []Tool, calls []*toolCall, debug bool) []interface{} {
+	requested := make([]map[string]interface{}, len(calls))
+	for i, call := range calls {
+		if call.ID == "" {
+			call.ID = fmt.Sprintf("call_%d", i)
+		}
+		requested[i] = map[string]interface{}{
+			"id":   call.ID,
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":      call.Name,
+				"arguments": call.Arguments.String(),
+			},
+		}
+	}
+	messages := []interface{}{map[string]interface{}{"role": "assistant", "content": nil, "tool_calls": requested}}
+
+	for _, call := range calls {
+		result := runTool(ctx, tools, call)
+		if debug {
+			log.Printf("[DEBUG] Tool %s(%s) returned %d bytes", call.Name, call.Arguments.String(), len(result))
+		}
+		messages = append(messages, map[string]interface{}{"role": "tool", "tool_call_id": call.ID, "content": result})
+	}
+	return messages
+}
+
+// runTool executes one call. Failures are reported to the model as the
+// result, so it can carry on without the data.
+func runTool(ctx context.Context, tools []Tool, call *toolCall) string {
+	args := json.RawMessage(call.Arguments.String())
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+	for _, t := range tools {
+		if t.Name != call.Name {
+			continue
+		}
+		log.Printf("🛠️  Running tool %s", call.Name)
+		result, err := t.Run(ctx, args)
+		if err != nil {
+			log.Printf("⚠️  Tool %s failed: %v", call.Name, err)
+			return "Error: " + err.Error()
+		}
+		if len(result) > maxToolResult {
+			result = result[:maxToolResult] + "\n[truncated]"
+		}
+		return result
+	}
+	return fmt.Sprintf("Error: there is no tool named %q", call.Name)
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOpenAIToolCalls(t *testing.T) {
+	var rounds [][]map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Messages []map[string]interface{} `json:"messages"`
+			Tools    []interface{}            `json:"tools"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		if len(payload.Tools) != 1 {
+			t.Errorf("round %d declared %d tools, want 1", len(rounds)+1, len(payload.Tools))
+		}
+		rounds = append(rounds, payload.Messages)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		if len(rounds) == 1 {
+			// The arguments arrive in fragments after the call's ID and name
+			fmt.Fprint(w, `data: {"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"read_file","arguments":""}}]}}]}`+"\n\n")
+			fmt.Fprint(w, `data: {"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"path\":"}}]}}]}`+"\n\n")
+			fmt.Fprint(w, `data: {"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"team.json\"}"}}]},"finish_reason":"tool_calls"}]}`+"\n\n")
+		} else {
+			fmt.Fprint(w, `data: {"choices":[{"delta":{"content":"<html><body>Ada</body></html>"}}]}`+"\n\n")
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	var gotArgs string
+	h := &OpenAIHandler{ModelName: "gpt-4o", APIBase: srv.URL, Adapter: "openai", Tools: []Tool{{
+		Name: "read_file",
+		Run: func(ctx context.Context, args json.RawMessage) (string, error) {
+			gotArgs = string(args)
+			return `[{"name":"Ada"}]`, nil
+		},
+	}}}
+	rec := httptest.NewRecorder()
+	if err := h.StreamResponse(context.Background(), rec, rec, "system", "user"); err != nil {
+		t.Fatal(err)
+	}
+
+	if gotArgs != `{"path":"team.json"}` {
+		t.Errorf("tool got arguments %s", gotArgs)
+	}
+	if len(rounds) != 2 {
+		t.Fatalf("%d requests, want 2", len(rounds))
+	}
+	// The second request carries the call and its result
+	second := rounds[1]
+	if len(second) != 4 || second[2]["role"] != "assistant" || second[3]["role"] != "tool" ||
+		second[3]["tool_call_id"] != "call_1" || second[3]["content"] != `[{"name":"Ada"}]` {
+		t.Errorf("second round messages: %v", second)
+	}
+	if !strings.Contains(rec.Body.String(), "Ada") {
+		t.Errorf("page = %q", rec.Body.String())
+	}
+}
+
+func TestRunToolReportsErrors(t *testing.T) {
+	tools := []Tool{{Name: "fail", Run: func(context.Context, json.RawMessage) (string, error) {
+		return "", fmt.Errorf("no such file")
+	}}}
+	call := &toolCall{Name: "fail"}
+	if got := runTool(context.Background(), tools, call); got != "Error: no such file" {
+		t.Errorf("failed tool = %q", got)
+	}
+	call.Name = "missing"
+	if got := runTool(context.Background(), tools, call); !strings.HasPrefix(got, "Error:") {
+		t.Errorf("unknown tool = %q", got)
+	}
+}
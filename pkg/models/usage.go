@@ -0,0 +1,40 @@
+package models
+
+// Usage is the token count of one generation
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+	// Estimated is set when the backend did not report usage and the counts
+	// were approximated from the text length
+	Estimated bool
+}
+
+// Total returns the prompt and completion tokens combined
+func (u Usage) Total() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// EstimateTokens approximates the token count of s (about four characters per token)
+func EstimateTokens(s string) int {
+	return (len(s) + 3) / 4
+}
+
+// reportUsage hands the usage of a generation to fn. When the backend reported
+// nothing the counts are estimated from the text; a generation that produced
+// no output and no usage report is not counted at all.
+func reportUsage(fn func(Usage), reported Usage, systemPrompt, userPrompt, output string) {
+	if fn == nil {
+		return
+	}
+	if reported.Total() == 0 {
+		if output == "" {
+			return
+		}
+		reported = Usage{
+			PromptTokens:     EstimateTokens(systemPrompt) + EstimateTokens(userPrompt),
+			CompletionTokens: EstimateTokens(output),
+			Estimated:        true,
+		}
+	}
+	fn(reported)
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"github.com/ollama/ollama/api"
+)
+
+// Image is a picture passed to multimodal models along with the user prompt
+type Image struct {
+	MIMEType string // image/jpeg, image/png, image/gif, or image/webp
+	Data     []byte
+}
+
+// imageTypes are the formats multimodal APIs commonly accept
+var imageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// DetectImage sniffs the format of data and returns it as an Image, or an
+// error when it is not a JPEG, PNG, GIF, or WebP picture
+func DetectImage(data []byte) (Image, error) {
+	mimeType := http.DetectContentType(data)
+	if !imageTypes[mimeType] {
+		return Image{}, fmt.Errorf("unsupported image type %s (use JPEG, PNG, GIF, or WebP)", mimeType)
+	}
+	return Image{MIMEType: mimeType, Data: data}, nil
+}
+
+// AcceptsImages reports whether the handler for backend sends images to the
+// model. Others generate from the text prompts alone.
+func AcceptsImages(backend string) bool {
+	switch backend {
+	case "openai", "azure-openai", "mistral", "groq", "vllm", "ollama":
+		return true
+	}
+	return false
+}
+
+// dataURL encodes the image for an OpenAI image_url part
+func (img Image) dataURL() string {
+	return "data:" + img.MIMEType + ";base64," + base64.StdEncoding.EncodeToString(img.Data)
+}
+
+// openAIUserContent is the content of the user message: the prompt alone, or
+// the prompt followed by one image part per image
+func openAIUserContent(userPrompt string, images []Image) interface{} {
+	if len(images) == 0 {
+		return userPrompt
+	}
+	parts := []interface{}{map[string]string{"type": "text", "text": userPrompt}}
+	for _, img := range images {
+		parts = append(parts, map[string]interface{}{
+			"type":      "image_url",
+			"image_url": map[string]string{"url": img.dataURL()},
+		})
+	}
+	return parts
+}
+
+// ollamaImages converts the images for an Ollama message
+func ollamaImages(images []Image) []api.ImageData {
+	if len(images) == 0 {
+		return nil
+	}
+	data := make([]api.ImageData, len(images))
+	for i, img := range images {
+		data[i] = img.Data
+	}
+	return data
+}
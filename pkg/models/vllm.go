@@ -0,0 +1,13 @@
+package models
+
+// DefaultVLLMAPIBase is where vLLM's OpenAI-compatible server listens by default
+const DefaultVLLMAPIBase = "http://localhost:8000/v1"
+
+// applyVLLM switches thinking off the way vLLM understands it, through the
+// chat template, for reasoning models such as Qwen3
+func applyVLLM(payload map[string]interface{}) {
+	if _, ok := payload["thinking"]; ok {
+		delete(payload, "thinking")
+		payload["chat_template_kwargs"] = map[string]interface{}{"enable_thinking": false}
+	}
+}
@@ -0,0 +1,67 @@
+package museweb
+
+import (
+	"os"
+	"strings"
+
+	"github.com/kekePower/museweb/pkg/config"
+	"github.com/kekePower/museweb/pkg/server"
+)
+
+// apiKeyEnv names the environment variable holding each backend's API key
+var apiKeyEnv = map[string]string{
+	"openai":       "OPENAI_API_KEY",
+	"azure-openai": "AZURE_OPENAI_API_KEY",
+	"anthropic":    "ANTHROPIC_API_KEY",
+	"mistral":      "MISTRAL_API_KEY",
+	"groq":         "GROQ_API_KEY",
+	"bedrock":      "AWS_BEARER_TOKEN_BEDROCK",
+	"llamacpp":     "LLAMA_API_KEY",
+	"vllm":         "VLLM_API_KEY",
+	"tgi":          "HF_TOKEN",
+	"ollama":       "OLLAMA_API_KEY",
+}
+
+// credentials returns the configured API key and base of every backend type,
+// falling back to the environment for keys (and the Azure endpoint)
+func credentials(cfg *config.Config) map[string]server.Credentials {
+	configured := map[string]server.Credentials{
+		"openai":       {APIKey: cfg.OpenAI.APIKey, APIBase: cfg.OpenAI.APIBase},
+		"ollama":       {APIKey: cfg.Ollama.APIKey, APIBase: cfg.Ollama.APIBase},
+		"anthropic":    {APIKey: cfg.Anthropic.APIKey, APIBase: cfg.Anthropic.APIBase},
+		"mistral":      {APIKey: cfg.Mistral.APIKey, APIBase: cfg.Mistral.APIBase},
+		"groq":         {APIKey: cfg.Groq.APIKey, APIBase: cfg.Groq.APIBase},
+		"azure-openai": {APIKey: cfg.AzureOpenAI.APIKey, APIBase: firstNonEmpty(cfg.AzureOpenAI.APIBase, os.Getenv("AZURE_OPENAI_ENDPOINT"))},
+		"bedrock":      {APIKey: cfg.Bedrock.APIKey, APIBase: cfg.Bedrock.APIBase},
+		"llamacpp":     {APIKey: cfg.LlamaCpp.APIKey, APIBase: cfg.LlamaCpp.APIBase},
+		"vllm":         {APIKey: cfg.VLLM.APIKey, APIBase: cfg.VLLM.APIBase},
+		"tgi":          {APIKey: cfg.TGI.APIKey, APIBase: cfg.TGI.APIBase},
+	}
+	for backend, c := range configured {
+		c.APIKey = firstNonEmpty(c.APIKey, os.Getenv(apiKeyEnv[backend]))
+		configured[backend] = c
+	}
+	return configured
+}
+
+// backendCredentials returns the API key and base for backend: the explicit
+// ones when set, else those configured for the backend type. Unknown types
+// use Ollama's, as the model handlers do.
+func backendCredentials(cfg *config.Config, backend, apiKey, apiBase string) (string, string) {
+	all := credentials(cfg)
+	c, ok := all[strings.ToLower(backend)]
+	if !ok {
+		c = all["ollama"]
+	}
+	return firstNonEmpty(apiKey, c.APIKey), firstNonEmpty(apiBase, c.APIBase)
+}
+
+// firstNonEmpty returns the first value that is not empty
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,26 @@
+package museweb
+
+import "net/http"
+
+// registerHealth adds the health checks that load balancers and orchestrators
+// probe, open to them through auth.exempt: /healthz and /livez answer while
+// the process runs, /readyz until Shutdown begins
+func (s *Server) registerHealth() {
+	s.mux.HandleFunc("GET /healthz", healthy)
+	s.mux.HandleFunc("GET /livez", healthy)
+	s.mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		if s.stopping.Load() {
+			w.Header().Set("Cache-Control", "no-store")
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		healthy(w, r)
+	})
+}
+
+// healthy answers a health check
+func healthy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte("ok\n"))
+}
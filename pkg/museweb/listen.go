@@ -0,0 +1,85 @@
+package museweb
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor systemd passes sockets in
+const listenFDsStart = 3
+
+// listen returns the site's listener: the socket systemd passed in (socket
+// activation), a Unix domain socket for "unix:/path" addresses, or TCP on
+// server.address and server.port
+func (s *Server) listen() (net.Listener, error) {
+	if ln, err := systemdListener(); ln != nil || err != nil {
+		return ln, err
+	}
+
+	addr := s.cfg.Server.Address
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return listenUnix(path)
+	}
+	if addr == "0.0.0.0" {
+		addr = ""
+	}
+	return net.Listen("tcp", net.JoinHostPort(addr, s.cfg.Server.Port))
+}
+
+// listenUnix listens on a Unix domain socket at path, replacing the socket
+// a previous run left behind. Who may connect is up to the permissions of
+// the socket's directory. The socket is removed when the listener closes.
+func listenUnix(path string) (net.Listener, error) {
+	if path == "" {
+		return nil, fmt.Errorf("server.address \"unix:\" needs a socket path")
+	}
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o666); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// systemdListener returns the first socket systemd passed to this process
+// (LISTEN_FDS and LISTEN_PID, see sd_listen_fds), or nil when it passed
+// none. The variables are cleared so child processes do not take them for
+// their own.
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if n > 1 {
+		log.Printf("⚠️  systemd passed %d sockets; serving on the first only", n)
+	}
+
+	f := os.NewFile(uintptr(listenFDsStart), "LISTEN_FD_3")
+	defer f.Close() // The listener has its own copy
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("socket from systemd: %w", err)
+	}
+	log.Printf("🔌 Serving on the socket passed by systemd (%s)", ln.Addr())
+	return ln, nil
+}
@@ -0,0 +1,57 @@
+package museweb
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kekePower/museweb/pkg/models"
+	"github.com/ollama/ollama/api"
+)
+
+// ensureModel checks at startup that the backend at apiBase has modelName,
+// pulling it into Ollama when autoPull is set. A missing Ollama model is an
+// error; anything that only prevents the check is logged.
+func ensureModel(backend, modelName, apiKey, apiBase string, autoPull bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	err := models.CheckModel(ctx, backend, modelName, apiKey, apiBase)
+	cancel()
+	switch {
+	case err == nil:
+		return nil
+	case err != models.ErrModelNotFound:
+		log.Printf("⚠️  Could not check that %s has model '%s': %v", apiBase, modelName, err)
+		return nil
+	case backend != "ollama":
+		// Some OpenAI-compatible providers do not list every model they serve
+		log.Printf("⚠️  Model '%s' is not listed by %s; requests may fail", modelName, apiBase)
+		return nil
+	case !autoPull:
+		return fmt.Errorf("Ollama at %s does not have model '%s'. Run 'ollama pull %s' or set model.auto_pull: true", apiBase, modelName, modelName)
+	}
+
+	log.Printf("📥 Pulling model '%s' to %s...", modelName, apiBase)
+	var status string
+	var lastPercent int64 = -1
+	err = models.PullOllamaModel(context.Background(), modelName, apiKey, apiBase, func(p api.ProgressResponse) {
+		if p.Status != status {
+			status, lastPercent = p.Status, -1
+			if p.Total == 0 {
+				log.Printf("📥 %s", p.Status)
+			}
+		}
+		// Log downloads in steps of 10%
+		if p.Total > 0 {
+			if percent := p.Completed * 100 / p.Total / 10 * 10; percent > lastPercent {
+				lastPercent = percent
+				log.Printf("📥 %s: %d%% of %d MB", p.Status, percent, p.Total>>20)
+			}
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("could not pull model '%s': %w", modelName, err)
+	}
+	log.Printf("✅ Model '%s' is ready", modelName)
+	return nil
+}
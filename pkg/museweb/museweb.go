@@ -0,0 +1,315 @@
+// Package museweb assembles the complete MuseWeb server (configuration,
+// handlers, middleware, background tasks) so Go programs can embed it as a
+// component instead of running the museweb binary:
+//
+//	cfg, _ := config.Load("config.yaml")
+//	srv, err := museweb.New(cfg, museweb.Options{})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	if err := srv.Start(); err != nil {
+//		log.Fatal(err)
+//	}
+//	defer srv.Shutdown(context.Background())
+//
+// A Server is also an http.Handler, for mounting MuseWeb in another server's
+// mux without letting it listen on its own.
+package museweb
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kekePower/museweb/pkg/config"
+	"github.com/kekePower/museweb/pkg/redis"
+	"github.com/kekePower/museweb/pkg/server"
+	"github.com/kekePower/museweb/pkg/store"
+	"github.com/kekePower/museweb/pkg/tracing"
+	"github.com/kekePower/museweb/pkg/utils"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Options are settings that are not part of config.yaml
+type Options struct {
+	// APIKey and APIBase override the configured credentials of the
+	// model.backend (the -api-key and -api-base flags)
+	APIKey  string
+	APIBase string
+	// Build describes the running program for /admin/info
+	Build server.BuildInfo
+	// Country, when set, looks up the country code of a client (e.g. in a
+	// GeoIP database) for ip_filter's country lists, instead of
+	// ip_filter.country_header
+	Country func(r *http.Request, ip net.IP) string
+	// LoadConfig, when set, reads the configuration again for Reload, which
+	// POST /admin/reload calls when the admin endpoints are enabled
+	LoadConfig func() (*config.Config, error)
+}
+
+// Server is a configured MuseWeb site
+type Server struct {
+	// Pages generates the pages; register tools and composers or swap
+	// backends through it
+	Pages *server.Server
+
+	cfg     *config.Config
+	mux     *http.ServeMux
+	db      *store.DB
+	redis   *redis.Cache    // Shared page cache, if configured
+	tracer  *tracing.Tracer // Trace exporter, if configured
+	access  *server.AccessLog
+	handler http.Handler // The mux, logged and traced when configured
+	assets  *server.Assets
+	version string
+	backend string // Backend and model of model.*, for the startup message
+	model   string
+
+	opts       Options
+	loadConfig func() (*config.Config, error)
+	reloadMu   sync.Mutex     // Serializes reloads
+	applied    *config.Config // The configuration last applied by New or Reload
+
+	// metricsMux serves the Prometheus metrics when they have a listener of
+	// their own (metrics.address)
+	metricsMux *http.ServeMux
+	// tls, when set, makes the site serve HTTPS (server.tls), with
+	// certificates from acme when autocert is configured
+	tls          *tls.Config
+	acme         *autocert.Manager
+	redirectHTTP string // Address redirecting plain HTTP to HTTPS, if any
+
+	stopping atomic.Bool // Set once Shutdown begins, failing /readyz
+
+	mu       sync.Mutex
+	http     *http.Server
+	listener net.Listener
+	metrics  *http.Server
+	redirect *http.Server       // Redirects plain HTTP to HTTPS, if configured
+	stop     context.CancelFunc // Stops the background tasks
+}
+
+// New builds the server described by cfg. It checks the configuration, opens
+// the database and stores it uses, makes sure the model is available, and
+// starts the warmer, prewarming, and live reload when they are configured;
+// call Shutdown to stop them. Model capabilities and reasoning model patterns
+// are registered process-wide.
+func New(cfg *config.Config, opts Options) (*Server, error) {
+	s := &Server{cfg: cfg, mux: http.NewServeMux(), version: opts.Build.Version, opts: opts, loadConfig: opts.LoadConfig, applied: cfg}
+	if err := s.setup(opts); err != nil {
+		if s.db != nil {
+			s.db.Close()
+		}
+		if s.redis != nil {
+			s.redis.Close()
+		}
+		if s.tracer != nil {
+			s.tracer.Shutdown(context.Background())
+		}
+		s.access.Close()
+		return nil, err
+	}
+	s.startBackground()
+	return s, nil
+}
+
+// ServeHTTP serves the site
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Start listens on server.address and server.port (or the Unix socket of a
+// "unix:/path" address, or the socket systemd passed in) and serves the site
+// in the background, over HTTPS when server.tls has a certificate. It
+// returns once the site accepts connections.
+func (s *Server) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.http != nil {
+		return errors.New("server already started")
+	}
+
+	ln, err := s.listen()
+	if err != nil {
+		return fmt.Errorf("failed to start server: %w", err)
+	}
+
+	// Longer timeouts than usual leave room for AI responses
+	s.http = &http.Server{
+		Handler:      s.handler,
+		ReadTimeout:  60 * time.Second,  // Time to read request
+		WriteTimeout: 300 * time.Second, // Time to write response (5 minutes for large AI responses)
+		IdleTimeout:  120 * time.Second, // Time to keep connections alive
+		TLSConfig:    s.tls,
+		Protocols:    s.protocols(),
+	}
+	s.listener = ln
+
+	// The metrics may be kept off the public port, e.g. on a loopback address
+	if s.metricsMux != nil {
+		mln, err := net.Listen("tcp", s.cfg.Metrics.Address)
+		if err != nil {
+			ln.Close()
+			s.http, s.listener = nil, nil
+			return fmt.Errorf("failed to start metrics server: %w", err)
+		}
+		s.metrics = &http.Server{Handler: s.metricsMux, ReadTimeout: 10 * time.Second, WriteTimeout: 30 * time.Second}
+		go func() {
+			if err := s.metrics.Serve(mln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("❌ Metrics server stopped: %v", err)
+			}
+		}()
+		log.Printf("📈 Prometheus metrics available at http://%s%s", mln.Addr(), s.cfg.Metrics.Path)
+	}
+
+	// Plain HTTP clients are sent to the HTTPS port
+	if s.redirectHTTP != "" {
+		rln, err := net.Listen("tcp", s.redirectHTTP)
+		if err != nil {
+			ln.Close()
+			if s.metrics != nil {
+				s.metrics.Close()
+				s.metrics = nil
+			}
+			s.http, s.listener = nil, nil
+			return fmt.Errorf("failed to start HTTP redirect server: %w", err)
+		}
+		_, port, _ := net.SplitHostPort(ln.Addr().String())
+		var redirect http.Handler = redirectToHTTPS(port)
+		if s.acme != nil {
+			redirect = s.acme.HTTPHandler(redirect) // Answers HTTP-01 challenges too
+		}
+		s.redirect = &http.Server{Handler: redirect, ReadTimeout: 10 * time.Second, WriteTimeout: 10 * time.Second}
+		go func() {
+			if err := s.redirect.Serve(rln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("❌ HTTP redirect server stopped: %v", err)
+			}
+		}()
+		log.Printf("↪️  Redirecting plain HTTP on %s to HTTPS", rln.Addr())
+	}
+
+	go func() {
+		serve := s.http.Serve
+		if s.tls != nil {
+			serve = func(ln net.Listener) error { return s.http.ServeTLS(ln, "", "") }
+		}
+		if err := serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("❌ Server stopped: %v", err)
+		}
+	}()
+
+	name := "MuseWeb"
+	if s.version != "" {
+		name += " v" + s.version
+	}
+	scheme := "http"
+	if s.tls != nil {
+		scheme = "https"
+	}
+	if ln.Addr().Network() == "unix" {
+		log.Printf("✨ %s is live at unix:%s (%s)", name, ln.Addr(), scheme)
+	} else {
+		displayHost := s.cfg.Server.Address
+		if displayHost == "0.0.0.0" || strings.HasPrefix(displayHost, "unix:") {
+			displayHost = "localhost"
+		}
+		_, port, _ := net.SplitHostPort(ln.Addr().String())
+		log.Printf("✨ %s is live at %s://%s:%s", name, scheme, displayHost, port)
+	}
+	log.Printf("   (Using backend '%s', model '%s', and prompts from '%s')", s.backend, s.model, s.Pages.ActivePromptsDir())
+	if utils.IsThinkingEnabledModel(s.model) {
+		log.Printf("   🧠 Thinking tag enabled for %s model", s.model)
+	}
+	return nil
+}
+
+// protocols returns the HTTP versions the site is served with: HTTP/1.1,
+// HTTP/2 over TLS, and with server.h2c HTTP/2 over cleartext too
+func (s *Server) protocols() *http.Protocols {
+	p := new(http.Protocols)
+	p.SetHTTP1(true)
+	p.SetHTTP2(true)
+	p.SetUnencryptedHTTP2(s.cfg.Server.H2C)
+	return p
+}
+
+// Addr returns the address the server listens on, or nil before Start.
+// With server.port "0" it tells which port was picked.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Shutdown stops accepting requests, waits for running generations until
+// ctx ends, then stops the background tasks, closes the database, shared
+// cache, and access log, and exports the remaining trace spans
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stopping.Store(true)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	if s.http != nil {
+		err = s.http.Shutdown(ctx)
+	}
+	if s.metrics != nil {
+		s.metrics.Close()
+		s.metrics = nil
+	}
+	if s.redirect != nil {
+		s.redirect.Close()
+		s.redirect = nil
+	}
+	if s.stop != nil {
+		s.stop()
+		s.stop = nil
+	}
+	if s.db != nil {
+		if cerr := s.db.Close(); err == nil {
+			err = cerr
+		}
+		s.db = nil
+	}
+	if s.redis != nil {
+		s.redis.Close()
+		s.redis = nil
+	}
+	// Spans of the last requests are still on their way to the collector
+	if s.tracer != nil {
+		if terr := s.tracer.Shutdown(ctx); err == nil {
+			err = terr
+		}
+		s.tracer = nil
+	}
+	if s.access != nil {
+		s.access.Close()
+		s.access = nil
+	}
+	return err
+}
+
+// startBackground starts the tasks that run while the site is up
+func (s *Server) startBackground() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stop = cancel
+	if s.Pages.Warm != nil {
+		go s.Pages.RunWarmer(ctx)
+	}
+	if len(s.Pages.Prewarm) > 0 {
+		go s.Pages.RunPrewarm(ctx)
+	}
+	if s.Pages.LiveReload != nil {
+		go s.Pages.LiveReload.Watch(ctx.Done())
+	}
+}
@@ -0,0 +1,708 @@
+package museweb_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kekePower/museweb/pkg/config"
+	"github.com/kekePower/museweb/pkg/museweb"
+	"github.com/kekePower/museweb/pkg/server"
+	"github.com/kekePower/museweb/pkg/testsupport"
+)
+
+func TestStartAndShutdown(t *testing.T) {
+	backend := testsupport.NewBackend(t, testsupport.Reply("<html><body><h1>Embedded</h1></body></html>"))
+	cfg, _ := config.Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	cfg.Server.Port = "0"
+	cfg.Server.PromptsDir = testsupport.Prompts(t, map[string]string{"home.txt": "Create a home page"})
+	cfg.Model.Backend = "openai"
+	cfg.Model.Name = "test-model"
+	cfg.Model.ResponseAdapter = "openai"
+
+	srv, err := museweb.New(cfg, museweb.Options{APIKey: "test-key", APIBase: backend.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err == nil {
+		t.Error("second Start succeeded")
+	}
+	base := "http://" + srv.Addr().String()
+
+	resp, err := http.Get(base + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "<h1>Embedded</h1>") {
+		t.Errorf("status %d: %q", resp.StatusCode, body)
+	}
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := http.Get(base + "/"); err == nil {
+		t.Error("server still accepts requests after Shutdown")
+	}
+}
+
+func TestServeHTTPS(t *testing.T) {
+	backend := testsupport.NewBackend(t, testsupport.Reply("<html><body><h1>Secure</h1></body></html>"))
+	cfg, _ := config.Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	cfg.Server.Port = "0"
+	cfg.Server.PromptsDir = testsupport.Prompts(t, map[string]string{"home.txt": "Create a home page"})
+	cfg.Model.Backend = "openai"
+	cfg.Model.Name = "test-model"
+	cfg.Model.ResponseAdapter = "openai"
+	pool := x509.NewCertPool()
+	cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile = selfSignedCert(t, pool)
+	free, _ := net.Listen("tcp", "127.0.0.1:0")
+	cfg.Server.TLS.RedirectHTTP = free.Addr().String()
+	free.Close()
+
+	srv, err := museweb.New(cfg, museweb.Options{APIKey: "test-key", APIBase: backend.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown(context.Background())
+	_, port, _ := net.SplitHostPort(srv.Addr().String())
+
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}, ForceAttemptHTTP2: true},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get("https://localhost:" + port + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "<h1>Secure</h1>") {
+		t.Errorf("status %d: %q", resp.StatusCode, body)
+	}
+	if resp.ProtoMajor != 2 || resp.TLS.Version < tls.VersionTLS12 {
+		t.Errorf("served over %s with TLS version %x", resp.Proto, resp.TLS.Version)
+	}
+
+	resp, err = client.Get("http://" + cfg.Server.TLS.RedirectHTTP + "/about?x=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if want := "https://127.0.0.1:" + port + "/about?x=1"; resp.StatusCode != http.StatusPermanentRedirect || resp.Header.Get("Location") != want {
+		t.Errorf("redirect: %d to %q, want %q", resp.StatusCode, resp.Header.Get("Location"), want)
+	}
+}
+
+func TestServeOnUnixSocket(t *testing.T) {
+	backend := testsupport.NewBackend(t, testsupport.Reply("<html><body><h1>Socket</h1></body></html>"))
+	cfg, _ := config.Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	socket := filepath.Join(t.TempDir(), "museweb.sock")
+	os.WriteFile(socket, nil, 0o600) // Not a socket: refused
+	cfg.Server.Address = "unix:" + socket
+	cfg.Server.PromptsDir = testsupport.Prompts(t, map[string]string{"home.txt": "Create a home page"})
+	cfg.Model.Backend = "openai"
+	cfg.Model.Name = "test-model"
+	cfg.Model.ResponseAdapter = "openai"
+
+	srv, err := museweb.New(cfg, museweb.Options{APIKey: "test-key", APIBase: backend.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err == nil || !strings.Contains(err.Error(), "not a socket") {
+		t.Fatalf("Start over a regular file: %v", err)
+	}
+	os.Remove(socket)
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return new(net.Dialer).DialContext(ctx, "unix", socket)
+		},
+	}}
+	resp, err := client.Get("http://museweb/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "<h1>Socket</h1>") {
+		t.Errorf("status %d: %q", resp.StatusCode, body)
+	}
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("socket left behind after Shutdown: %v", err)
+	}
+}
+
+func TestH2CStreams(t *testing.T) {
+	// The backend sends the start of the page, then waits
+	release := make(chan struct{})
+	var once sync.Once
+	resume := func() { once.Do(func() { close(release) }) }
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/chat/completions") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i, chunk := range []string{"<html><body><h1>First</h1>", "<p>Rest</p></body></html>"} {
+			if i == 1 {
+				<-release
+			}
+			event, _ := json.Marshal(map[string]interface{}{
+				"choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]string{"content": chunk}}},
+			})
+			fmt.Fprintf(w, "data: %s\n\n", event)
+			w.(http.Flusher).Flush()
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+	defer resume()
+
+	cfg, _ := config.Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	cfg.Server.Port = "0"
+	cfg.Server.PromptsDir = testsupport.Prompts(t, map[string]string{"home.txt": "Create a home page"})
+	cfg.Model.Backend = "openai"
+	cfg.Model.Name = "test-model"
+	cfg.Model.ResponseAdapter = "openai"
+	cfg.Server.H2C = true
+	srv, err := museweb.New(cfg, museweb.Options{APIKey: "test-key", APIBase: upstream.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown(context.Background())
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: protocols}, Timeout: 10 * time.Second}
+	resp, err := client.Get("http://" + srv.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("served over %s, want HTTP/2", resp.Proto)
+	}
+
+	// The start of the page arrives while the backend is still generating
+	var got []byte
+	buf := make([]byte, 512)
+	for !strings.Contains(string(got), "<h1>First</h1>") {
+		n, err := resp.Body.Read(buf)
+		if err != nil {
+			t.Fatalf("page so far %q: %v", got, err)
+		}
+		got = append(got, buf[:n]...)
+	}
+	resume()
+	rest, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(rest), "<p>Rest</p>") {
+		t.Errorf("rest of the page = %q", rest)
+	}
+}
+
+func TestAutocertConfig(t *testing.T) {
+	cfg, _ := config.Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	cfg.Server.PromptsDir = testsupport.Prompts(t, map[string]string{"home.txt": "Create a home page"})
+	cfg.Model.Backend = "mock"
+	cfg.Server.TLS.Autocert.Domains = []string{"example.com"}
+	cfg.Server.TLS.Autocert.CacheDir = t.TempDir()
+	srv, err := museweb.New(cfg, museweb.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Shutdown(context.Background())
+
+	cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile = selfSignedCert(t, x509.NewCertPool())
+	if _, err := museweb.New(cfg, museweb.Options{}); err == nil || !strings.Contains(err.Error(), "autocert") {
+		t.Errorf("err = %v, want a conflict between the certificate files and autocert", err)
+	}
+}
+
+// selfSignedCert writes a certificate for localhost and 127.0.0.1 and its
+// key, adds the certificate to pool, and returns the files
+func selfSignedCert(t *testing.T, pool *x509.CertPool) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool.AddCert(cert)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+func TestNewRejectsInvalidConfig(t *testing.T) {
+	cfg, _ := config.Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	cfg.Model.Backend = "mock"
+	cfg.Server.RenderMode = "sideways"
+	if _, err := museweb.New(cfg, museweb.Options{}); err == nil || !strings.Contains(err.Error(), "render_mode") {
+		t.Errorf("err = %v, want an invalid render_mode error", err)
+	}
+}
+
+func TestFingerprintedAssets(t *testing.T) {
+	backend := testsupport.NewBackend(t, testsupport.Reply(`<!DOCTYPE html><html><head><link rel="stylesheet" href="/site.css"></head><body></body></html>`))
+	cfg, _ := config.Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	cfg.Server.PromptsDir = testsupport.Prompts(t, map[string]string{"home.txt": "Create a home page"})
+	testsupport.WriteFile(t, cfg.Server.PromptsDir, "public/site.css", "body { color: teal }")
+	cfg.Model.Backend = "openai"
+	cfg.Model.Name = "test-model"
+	cfg.Model.ResponseAdapter = "openai"
+	cfg.Static.Fingerprint = true
+
+	srv, err := museweb.New(cfg, museweb.Options{APIKey: "test-key", APIBase: backend.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown(context.Background())
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	plain := get("/site.css")
+	if cc := plain.Header().Get("Cache-Control"); cc != "public, max-age=3600" {
+		t.Errorf("plain asset Cache-Control = %q", cc)
+	}
+
+	page := get("/").Body.String()
+	m := regexp.MustCompile(`href="(/site\.[0-9a-f]{8}\.css)"`).FindStringSubmatch(page)
+	if m == nil {
+		t.Fatalf("stylesheet not fingerprinted: %q", page)
+	}
+	asset := get(m[1])
+	if asset.Body.String() != "body { color: teal }" || !strings.Contains(asset.Header().Get("Cache-Control"), "immutable") {
+		t.Errorf("fingerprinted asset: %v %q", asset.Header(), asset.Body.String())
+	}
+	if stale := get("/site.00000000.css"); strings.Contains(stale.Header().Get("Cache-Control"), "immutable") {
+		t.Error("outdated fingerprint served as immutable")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	backend := testsupport.NewBackend(t, testsupport.Reply("<html><body><h1>Measured</h1></body></html>"))
+	cfg, _ := config.Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	cfg.Server.PromptsDir = testsupport.Prompts(t, map[string]string{"home.txt": "Create a home page"})
+	cfg.Model.Backend = "openai"
+	cfg.Model.Name = "test-model"
+	cfg.Model.ResponseAdapter = "openai"
+	cfg.Metrics.Enabled = true
+	cfg.Cache.Enabled = true
+
+	srv, err := museweb.New(cfg, museweb.Options{APIKey: "test-key", APIBase: backend.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown(context.Background())
+	for _, path := range []string{"/", "/", "/missing"} {
+		srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	got := rec.Body.String()
+	for _, want := range []string{
+		`museweb_http_requests_total{code="200"} 2`,
+		`museweb_http_requests_total{code="404"} 1`,
+		`museweb_page_duration_seconds_count{route="home"} 2`,
+		`museweb_time_to_first_token_seconds_count{backend="openai",model="test-model"} 1`,
+		`museweb_stream_duration_seconds_count{backend="openai",model="test-model"} 1`,
+		`museweb_upstream_requests_total{backend="openai",model="test-model",outcome="ok"} 1`,
+		`museweb_cache_requests_total{result="hit"} 1`,
+		`museweb_cache_requests_total{result="miss"} 1`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	backend := testsupport.NewBackend(t, testsupport.Reply("<html><body><h1>Logged</h1></body></html>"))
+	cfg, _ := config.Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	cfg.Server.PromptsDir = testsupport.Prompts(t, map[string]string{"home.txt": "Create a home page"})
+	testsupport.WriteFile(t, cfg.Server.PromptsDir, "public/site.css", "body { color: teal }")
+	cfg.Server.AccessLog = filepath.Join(t.TempDir(), "access.log")
+	cfg.Model.Backend = "openai"
+	cfg.Model.Name = "test-model"
+	cfg.Model.ResponseAdapter = "openai"
+	cfg.Cache.Enabled = true
+
+	srv, err := museweb.New(cfg, museweb.Options{APIKey: "test-key", APIBase: backend.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/?lang=en", "/?lang=en", "/site.css", "/missing?Token=abc&x=1&api%5Fkey=def&key&key=ghi"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("User-Agent", `curl "quoted"`)
+		srv.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(cfg.Server.AccessLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(cfg.Server.AccessLog); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm()&0o007 != 0 {
+		t.Errorf("the access log is readable by everyone: %v", info.Mode())
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	want := []*regexp.Regexp{
+		regexp.MustCompile(`^192\.0\.2\.1 - - \[[^]]+\] "GET /\?lang=en HTTP/1\.1" 200 \d+ "-" "curl \\"quoted\\"" \d+ "openai/test-model" miss$`),
+		regexp.MustCompile(`"GET /\?lang=en HTTP/1\.1" 200 \d+ .* "-" cache$`),
+		regexp.MustCompile(`"GET /site\.css HTTP/1\.1" 200 20 .* "-" -$`),
+		regexp.MustCompile(`"GET /missing\?Token=REDACTED&x=1&api%5Fkey=REDACTED&key&key=REDACTED HTTP/1\.1" 404 \d+ .* "-" -$`),
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines:\n%s", len(lines), data)
+	}
+	for i, re := range want {
+		if !re.MatchString(lines[i]) {
+			t.Errorf("line %d = %q, want a match for %s", i+1, lines[i], re)
+		}
+	}
+}
+
+func TestDebugEndpoints(t *testing.T) {
+	backend := testsupport.NewBackend(t, testsupport.Reply("<html><body><h1>Profiled</h1></body></html>"))
+	cfg, _ := config.Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	cfg.Server.PromptsDir = testsupport.Prompts(t, map[string]string{"home.txt": "Create a home page"})
+	cfg.Model.Backend = "openai"
+	cfg.Model.Name = "test-model"
+	cfg.Model.ResponseAdapter = "openai"
+	cfg.Server.EnablePprof = true
+	cfg.Admin.Token = "secret"
+
+	srv, err := museweb.New(cfg, museweb.Options{APIKey: "test-key", APIBase: backend.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown(context.Background())
+	srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := get("/debug/stats")
+	var stats server.RuntimeStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("stats: %d %q (%v)", rec.Code, rec.Body, err)
+	}
+	if stats.Goroutines == 0 || stats.HeapAlloc == 0 || stats.Requests != 1 || stats.Generations != 1 || stats.Streams != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if rec := get("/debug/pprof/goroutine?debug=1"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "goroutine profile") {
+		t.Errorf("goroutine profile: %d %.100q", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/stats", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("stats without the admin token: %d", rec.Code)
+	}
+}
+
+func TestReload(t *testing.T) {
+	backend := testsupport.NewBackend(t, func(req testsupport.BackendRequest) string {
+		return "<html><body><p>" + req.Model + ": " + req.User + "</p></body></html>"
+	})
+	cfg, _ := config.Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	cfg.Server.PromptsDir = testsupport.Prompts(t, map[string]string{"home.txt": "Create a home page"})
+	cfg.Model.Backend = "openai"
+	cfg.Model.Name = "test-model"
+	cfg.Model.ResponseAdapter = "openai"
+	cfg.Cache.Enabled = true
+	cfg.Admin.Token = "secret"
+
+	next := *cfg
+	srv, err := museweb.New(cfg, museweb.Options{APIKey: "test-key", APIBase: backend.URL,
+		LoadConfig: func() (*config.Config, error) {
+			reloaded := next
+			return &reloaded, nil
+		}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown(context.Background())
+	home := func() string {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Body.String()
+	}
+	if page := home(); !strings.Contains(page, "test-model: Create a home page") {
+		t.Fatalf("before reloading: %q", page)
+	}
+
+	next.Model.Name = "other-model"
+	next.Server.PromptsDir = testsupport.Prompts(t, map[string]string{"home.txt": "Create the new home page"})
+	next.Cache.Size = 1
+	req := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reload: %d %q", rec.Code, rec.Body)
+	}
+	if page := home(); !strings.Contains(page, "other-model: Create the new home page") {
+		t.Errorf("after reloading: %q", page)
+	}
+
+	next.Model.Name = "third-model"
+	next.Server.PromptsDir = filepath.Join(t.TempDir(), "missing")
+	if err := srv.Reload(); err == nil {
+		t.Error("reloaded a configuration with a missing prompts directory")
+	}
+	if active := srv.Pages.Active(); active.Model != "other-model" {
+		t.Errorf("an invalid configuration switched the model to %q", active.Model)
+	}
+}
+
+func TestSiteAuth(t *testing.T) {
+	backend := testsupport.NewBackend(t, testsupport.Reply("<html><body><h1>Private</h1></body></html>"))
+	for _, adminToken := range []string{"", "admin-secret"} {
+		cfg, _ := config.Load(filepath.Join(t.TempDir(), "missing.yaml"))
+		cfg.Server.PromptsDir = testsupport.Prompts(t, map[string]string{
+			"home.txt":        "Create a home page",
+			"admin/notes.txt": "Create the editors' notes",
+		})
+		cfg.Model.Backend = "openai"
+		cfg.Model.Name = "test-model"
+		cfg.Model.ResponseAdapter = "openai"
+		cfg.Auth.Enabled = true
+		cfg.Auth.Token = "site-secret"
+		cfg.Admin.Token = adminToken
+
+		srv, err := museweb.New(cfg, museweb.Options{APIKey: "test-key", APIBase: backend.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		get := func(method, path, token string) int {
+			req := httptest.NewRequest(method, path, nil)
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		for _, tc := range []struct {
+			method, path, token string
+			want                int
+		}{
+			{"GET", "/", "", http.StatusUnauthorized},
+			{"GET", "/", "site-secret", http.StatusOK},
+			{"GET", "/healthz", "", http.StatusOK},
+			{"GET", "/livez", "", http.StatusOK},
+			{"GET", "/readyz", "", http.StatusOK},
+		} {
+			if got := get(tc.method, tc.path, tc.token); got != tc.want {
+				t.Errorf("admin token %q: %s %s with %q = %d, want %d", adminToken, tc.method, tc.path, tc.token, got, tc.want)
+			}
+		}
+
+		// Site auth leaves /admin/ to the admin endpoints only when they exist,
+		// and then every path under it checks the admin token
+		adminPaths := []string{
+			"GET /admin/notes", "GET /admin/preview", "GET /admin/info",
+			"GET /admin/api/model", "POST /admin/api/model", "GET /admin/model", "POST /admin/model",
+		}
+		for _, route := range adminPaths {
+			method, path, _ := strings.Cut(route, " ")
+			if adminToken == "" {
+				if got := get(method, path, ""); got != http.StatusUnauthorized {
+					t.Errorf("without admin endpoints: %s = %d, want 401", route, got)
+				}
+				continue
+			}
+			for _, token := range []string{"", "site-secret"} {
+				if got := get(method, path, token); got != http.StatusUnauthorized {
+					t.Errorf("%s with %q = %d, want 401", route, token, got)
+				}
+			}
+		}
+		if adminToken != "" {
+			if got := get("GET", "/admin/notes", adminToken); got != http.StatusNotFound {
+				t.Errorf("unknown admin path with the admin token = %d, want 404", got)
+			}
+			if got := get("GET", "/admin/info", adminToken); got != http.StatusOK {
+				t.Errorf("/admin/info with the admin token = %d, want 200", got)
+			}
+		}
+
+		if err := srv.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if got := get("GET", "/readyz", ""); got != http.StatusServiceUnavailable {
+			t.Errorf("/readyz after Shutdown = %d, want 503", got)
+		}
+	}
+	// Only the home page was generated, once per server; never admin/notes
+	if n := len(backend.Requests()); n != 2 {
+		t.Errorf("backend called %d times, want 2", n)
+	}
+}
+
+func TestAdminLogin(t *testing.T) {
+	cfg, _ := config.Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	cfg.Server.PromptsDir = testsupport.Prompts(t, map[string]string{"home.txt": "Create a home page"})
+	cfg.Admin.Token = "admin-secret"
+	srv, err := museweb.New(cfg, museweb.Options{APIKey: "test-key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown(context.Background())
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+	login := func(token, next string) *httptest.ResponseRecorder {
+		form := url.Values{"token": {token}, "next": {next}}
+		req := httptest.NewRequest(http.MethodPost, "/admin/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return serve(req)
+	}
+
+	// The token is not taken from the URL
+	if rec := serve(httptest.NewRequest(http.MethodGet, "/admin/info?token=admin-secret", nil)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("?token= = %d, want 401", rec.Code)
+	}
+	if rec := serve(httptest.NewRequest(http.MethodGet, "/admin/login", nil)); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `type="password" name="token"`) {
+		t.Errorf("sign-in form: %d %q", rec.Code, rec.Body)
+	}
+	if rec := login("wrong", ""); rec.Code != http.StatusUnauthorized || len(rec.Result().Cookies()) != 0 {
+		t.Errorf("wrong token: %d, cookies %v", rec.Code, rec.Result().Cookies())
+	}
+
+	for _, tc := range []struct{ next, want string }{
+		{"/admin/snapshots", "/admin/snapshots"},
+		{"", "/admin/info"},
+		{"https://example.com/admin/", "/admin/info"},
+		{"//example.com/admin/", "/admin/info"},
+	} {
+		rec := login("admin-secret", tc.next)
+		if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != tc.want {
+			t.Errorf("next %q: %d to %q, want %q", tc.next, rec.Code, rec.Header().Get("Location"), tc.want)
+		}
+		cookies := rec.Result().Cookies()
+		if len(cookies) != 1 {
+			t.Fatalf("next %q: cookies %v", tc.next, cookies)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/admin/info", nil)
+		req.AddCookie(cookies[0])
+		if rec := serve(req); rec.Code != http.StatusOK {
+			t.Errorf("/admin/info after signing in = %d", rec.Code)
+		}
+	}
+}
+
+func TestRateLimitBehindTrustedProxy(t *testing.T) {
+	backend := testsupport.NewBackend(t, testsupport.Reply("<html><body><h1>Limited</h1></body></html>"))
+	cfg, _ := config.Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	cfg.Server.PromptsDir = testsupport.Prompts(t, map[string]string{"home.txt": "Create a home page"})
+	cfg.Server.TrustedProxies = []string{"10.0.0.0/8"}
+	cfg.Model.Backend = "openai"
+	cfg.Model.Name = "test-model"
+	cfg.Model.ResponseAdapter = "openai"
+	cfg.RateLimit.Enabled = true
+	cfg.RateLimit.PerMinute = 1
+	cfg.RateLimit.Burst = 1
+
+	srv, err := museweb.New(cfg, museweb.Options{APIKey: "test-key", APIBase: backend.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown(context.Background())
+	get := func(visitor string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "10.1.2.3:4000"
+		req.Header.Set("X-Forwarded-For", visitor)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	// Visitors behind the same proxy have limits of their own
+	for _, step := range []struct {
+		visitor string
+		want    int
+	}{
+		{"198.51.100.1", http.StatusOK},
+		{"198.51.100.2", http.StatusOK},
+		{"198.51.100.1", http.StatusTooManyRequests},
+	} {
+		if got := get(step.visitor); got != step.want {
+			t.Errorf("visitor %s got %d, want %d", step.visitor, got, step.want)
+		}
+	}
+}
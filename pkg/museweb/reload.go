@@ -0,0 +1,111 @@
+package museweb
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kekePower/museweb/pkg/config"
+	"github.com/kekePower/museweb/pkg/server"
+	"github.com/kekePower/museweb/pkg/utils"
+)
+
+// Reload reads the configuration again with Options.LoadConfig and applies
+// what can change while serving: the model backend and name, the prompts
+// directory, the reasoning model patterns, and the size and lifetime of the
+// in-memory page cache. Pages already generating finish with the settings
+// they started with. Nothing is applied when the new configuration is
+// invalid; other changes are logged and take effect on restart.
+func (s *Server) Reload() error {
+	if s.loadConfig == nil {
+		return errors.New("no configuration to reload")
+	}
+	next, err := s.loadConfig()
+	if err != nil {
+		return fmt.Errorf("could not reload the configuration: %w", err)
+	}
+
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	applied, pages := s.applied, s.Pages
+
+	// Check everything before applying anything
+	backend, model := next.Model.Backend, next.Model.Name
+	apiKey, apiBase := backendCredentials(next, backend, s.opts.APIKey, s.opts.APIBase)
+	swap := backend != applied.Model.Backend || model != applied.Model.Name
+	if swap {
+		if err := checkCredentials(backend, apiKey, apiBase, pages.AWSCredentials); err != nil {
+			return err
+		}
+	}
+	promptsDir := next.Server.PromptsDir
+	if promptsDir != applied.Server.PromptsDir {
+		if info, err := os.Stat(promptsDir); err != nil || !info.IsDir() {
+			return fmt.Errorf("invalid server.prompts_dir: %s is not a directory", promptsDir)
+		}
+	}
+	cache, resizable := pages.Cache.(*server.ResponseCache)
+	resizable = resizable && next.Cache.Enabled
+	if resizable && (next.Cache.Size < 1 || next.Cache.TTL < 1) {
+		return fmt.Errorf("invalid cache: size and ttl must be positive")
+	}
+
+	if swap {
+		active, err := pages.SwapBackend(server.BackendSettings{Backend: backend, Model: model, APIKey: apiKey, APIBase: apiBase})
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.backend, s.model = active.Backend, active.Model
+		s.mu.Unlock()
+	}
+	if promptsDir != applied.Server.PromptsDir {
+		pages.SetPromptsDir(promptsDir)
+		if s.assets != nil {
+			s.assets.SetDirs([]string{filepath.Join(promptsDir, "public"), "public"})
+		}
+	}
+	if !slices.Equal(next.Model.ReasoningModels, applied.Model.ReasoningModels) {
+		utils.SetReasoningModelPatterns(next.Model.ReasoningModels)
+		log.Printf("🧠 Loaded %d reasoning model patterns from config", len(next.Model.ReasoningModels))
+	}
+	if resizable && (next.Cache.Size != applied.Cache.Size || next.Cache.TTL != applied.Cache.TTL) {
+		cache.Resize(next.Cache.Size, time.Duration(next.Cache.TTL)*time.Second)
+		log.Printf("🗃️  Caching up to %d generated pages for %ds", next.Cache.Size, next.Cache.TTL)
+	}
+
+	if sections := restartSections(applied, next, resizable); len(sections) > 0 {
+		log.Printf("⚠️  Changes to %s take effect on restart", strings.Join(sections, ", "))
+	}
+	s.applied = next
+	log.Printf("🔄 Configuration reloaded")
+	return nil
+}
+
+// restartSections returns the top-level sections of the configuration whose
+// changes from applied to next Reload cannot apply
+func restartSections(applied, next *config.Config, resized bool) []string {
+	a, b := *applied, *next
+	b.Model.Backend, b.Model.Name = a.Model.Backend, a.Model.Name
+	b.Model.ReasoningModels = a.Model.ReasoningModels
+	b.Server.PromptsDir = a.Server.PromptsDir
+	if resized {
+		b.Cache.Size, b.Cache.TTL = a.Cache.Size, a.Cache.TTL
+	}
+
+	var changed []string
+	av, bv := reflect.ValueOf(a), reflect.ValueOf(b)
+	for i := 0; i < av.NumField(); i++ {
+		if !reflect.DeepEqual(av.Field(i).Interface(), bv.Field(i).Interface()) {
+			name, _, _ := strings.Cut(av.Type().Field(i).Tag.Get("yaml"), ",")
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,1091 @@
+package museweb
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kekePower/museweb/pkg/auth"
+	"github.com/kekePower/museweb/pkg/budget"
+	"github.com/kekePower/museweb/pkg/config"
+	"github.com/kekePower/museweb/pkg/errors"
+	"github.com/kekePower/museweb/pkg/middleware"
+	"github.com/kekePower/museweb/pkg/models"
+	"github.com/kekePower/museweb/pkg/postprocess"
+	"github.com/kekePower/museweb/pkg/rag"
+	"github.com/kekePower/museweb/pkg/redis"
+	"github.com/kekePower/museweb/pkg/server"
+	"github.com/kekePower/museweb/pkg/snapshot"
+	"github.com/kekePower/museweb/pkg/store"
+	"github.com/kekePower/museweb/pkg/tracing"
+	"github.com/kekePower/museweb/pkg/translate"
+	"github.com/kekePower/museweb/pkg/utils"
+)
+
+// setup builds the page server and the routes from the configuration
+func (s *Server) setup(opts Options) error {
+	cfg := s.cfg
+
+	// Set reasoning model patterns from configuration
+	if len(cfg.Model.ReasoningModels) > 0 {
+		utils.SetReasoningModelPatterns(cfg.Model.ReasoningModels)
+		log.Printf("🧠 Loaded %d reasoning model patterns from config", len(cfg.Model.ReasoningModels))
+	}
+
+	backend, model := cfg.Model.Backend, cfg.Model.Name
+	apiKey, apiBase := backendCredentials(cfg, backend, opts.APIKey, opts.APIBase)
+	awsCredentials := models.AWSCredentials{
+		AccessKeyID:     firstNonEmpty(cfg.Bedrock.AccessKeyID, os.Getenv("AWS_ACCESS_KEY_ID")),
+		SecretAccessKey: firstNonEmpty(cfg.Bedrock.SecretAccessKey, os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		SessionToken:    firstNonEmpty(cfg.Bedrock.SessionToken, os.Getenv("AWS_SESSION_TOKEN")),
+	}
+	if err := checkCredentials(backend, apiKey, apiBase, awsCredentials); err != nil {
+		return err
+	}
+	s.backend, s.model = backend, model
+
+	pages := server.New(backend, model, cfg.Server.PromptsDir, apiKey, apiBase, cfg.Server.Debug)
+	s.Pages = pages
+	// Configured credentials for each backend, used by runtime model swaps
+	pages.Credentials = credentials(cfg)
+	pages.AzureAPIVersion = cfg.AzureOpenAI.APIVersion
+	pages.BedrockRegion = firstNonEmpty(cfg.Bedrock.Region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"))
+	pages.AWSCredentials = awsCredentials
+
+	for _, step := range []func() error{
+		s.setupBackends,
+		s.setupGeneration,
+		func() error { return s.setupModel(apiKey, apiBase) },
+		s.setupRendering,
+		s.setupPostProcessing,
+		s.setupHistory,
+		s.setupMetrics,
+		s.setupTracing,
+		s.setupAccessLog,
+		s.setupTLS,
+		s.setupRoutes,
+		s.setupStorage,
+		s.setupLogin,
+		s.setupRAG,
+	} {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+
+	pages.AdminToken = firstNonEmpty(cfg.Admin.Token, os.Getenv("MUSEWEB_ADMIN_TOKEN"))
+	if pages.AdminToken != "" {
+		pages.Build = opts.Build
+		var err error
+		if pages.ConfigSummary, err = cfg.Redacted(); err != nil {
+			log.Printf("⚠️  Could not summarize the configuration for /admin/info: %v", err)
+		}
+		if s.loadConfig != nil {
+			pages.Reload = s.Reload
+		}
+		pages.RegisterAdmin(s.mux)
+		log.Printf("🔐 Admin endpoints enabled under /admin")
+	}
+
+	// The profiler and runtime stats, for debugging in place; registered after
+	// the admin token is known, which protects them
+	if cfg.Server.Debug || cfg.Server.EnablePprof {
+		pages.RegisterDebug(s.mux)
+		if pages.AdminToken == "" {
+			log.Printf("⚠️  Profiler available at /debug/pprof/ and runtime stats at /debug/stats without authentication; set admin.token to protect them")
+		} else {
+			log.Printf("🩺 Profiler available at /debug/pprof/ and runtime stats at /debug/stats (admin token required)")
+		}
+	}
+	site, err := s.authenticate(s.mux)
+	if err != nil {
+		return err
+	}
+	if site, err = s.filterClients(site, opts.Country); err != nil {
+		return err
+	}
+	// Behind reverse proxies, everything sees the visitor's address
+	proxies, err := middleware.ParseTrustedProxies(cfg.Server.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("invalid server.trusted_proxies: %w", err)
+	}
+	s.handler = middleware.RealIP(s.access.Middleware(s.tracer.Middleware(site)), proxies)
+	return nil
+}
+
+// checkCredentials reports a missing API key or endpoint of backends that
+// cannot work without one
+func checkCredentials(backend, apiKey, apiBase string, aws models.AWSCredentials) error {
+	switch {
+	case backend == "openai" && apiKey == "":
+		return fmt.Errorf("for the 'openai' backend, the API key must be provided via the -api-key flag, the config.yaml file, or the OPENAI_API_KEY environment variable")
+	case backend == "azure-openai" && (apiKey == "" || apiBase == ""):
+		return fmt.Errorf("for the 'azure-openai' backend, the API key and resource endpoint must be provided via flags, the azure_openai section of config.yaml, or the AZURE_OPENAI_API_KEY and AZURE_OPENAI_ENDPOINT environment variables")
+	case backend == "mistral" && apiKey == "":
+		return fmt.Errorf("for the 'mistral' backend, the API key must be provided via the -api-key flag, the mistral section of config.yaml, or the MISTRAL_API_KEY environment variable")
+	case backend == "groq" && apiKey == "":
+		return fmt.Errorf("for the 'groq' backend, the API key must be provided via the -api-key flag, the groq section of config.yaml, or the GROQ_API_KEY environment variable")
+	case backend == "anthropic" && apiKey == "":
+		return fmt.Errorf("for the 'anthropic' backend, the API key must be provided via the -api-key flag, the config.yaml file, or the ANTHROPIC_API_KEY environment variable")
+	// Bedrock takes either an API key or AWS access keys to sign requests with
+	case backend == "bedrock" && apiKey == "" && (aws.AccessKeyID == "" || aws.SecretAccessKey == ""):
+		return fmt.Errorf("for the 'bedrock' backend, provide a Bedrock API key (-api-key or AWS_BEARER_TOKEN_BEDROCK) or AWS access keys via the bedrock section of config.yaml or the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables")
+	}
+	return nil
+}
+
+// setupBackends configures named backends, failover, routing, retries,
+// model overrides, and the shadow backend
+func (s *Server) setupBackends() error {
+	cfg, pages := s.cfg, s.Pages
+
+	// Named backends that prompts can select with "backend: name"
+	for _, b := range cfg.Backends {
+		settings := server.BackendSettings{
+			Backend:          b.Type,
+			Model:            b.Model,
+			APIKey:           b.APIKey,
+			APIBase:          b.APIBase,
+			FirstByteTimeout: time.Duration(b.FirstByteTimeout) * time.Second,
+		}
+		if err := pages.AddBackend(b.Name, settings); err != nil {
+			return fmt.Errorf("invalid backends entry %q: %w", b.Name, err)
+		}
+		log.Printf("🧭 Backend '%s' available to prompts (%s/%s)", b.Name, b.Type, b.Model)
+	}
+	if cfg.Timeouts.Connect < 0 || cfg.Timeouts.FirstToken < 0 || cfg.Timeouts.Total < 0 {
+		return fmt.Errorf("invalid timeouts: they must not be negative")
+	}
+	if cfg.Timeouts.Total > 0 && cfg.Timeouts.FirstToken >= cfg.Timeouts.Total {
+		return fmt.Errorf("invalid timeouts: first_token must be shorter than total")
+	}
+	pages.Timeouts = models.Timeouts{
+		Connect: time.Duration(cfg.Timeouts.Connect) * time.Second,
+		Total:   time.Duration(cfg.Timeouts.Total) * time.Second,
+	}
+	firstToken := cfg.Timeouts.FirstToken
+	if firstToken == 0 {
+		firstToken = cfg.Failover.FirstByteTimeout
+	}
+	if len(cfg.Failover.Backends) > 0 || firstToken > 0 {
+		if err := pages.SetFailover(cfg.Failover.Backends, time.Duration(firstToken)*time.Second); err != nil {
+			return fmt.Errorf("invalid failover: %w", err)
+		}
+		if len(cfg.Failover.Backends) > 0 {
+			log.Printf("🛟 Failing over to %s when a backend errors before responding", strings.Join(cfg.Failover.Backends, " → "))
+		}
+	}
+	if len(cfg.Routing.Rules) > 0 {
+		rules := make([]server.RoutingRule, len(cfg.Routing.Rules))
+		for i, r := range cfg.Routing.Rules {
+			rules[i] = server.RoutingRule{
+				Backend:         r.Backend,
+				Routes:          r.Routes,
+				Complexity:      r.Complexity,
+				MinPromptTokens: r.MinPromptTokens,
+				MaxPromptTokens: r.MaxPromptTokens,
+			}
+		}
+		if err := pages.SetRouting(rules, cfg.Routing.EscalateAfter); err != nil {
+			return fmt.Errorf("invalid routing: %w", err)
+		}
+		log.Printf("🧭 %d routing rule(s) loaded", len(rules))
+	}
+	if cfg.Retry.Attempts < 0 || cfg.Retry.BaseDelayMs < 0 || cfg.Retry.MaxDelayMs < 0 {
+		return fmt.Errorf("invalid retry: attempts and delays must not be negative")
+	}
+	pages.Retry = server.RetryPolicy{
+		Attempts:  cfg.Retry.Attempts,
+		BaseDelay: time.Duration(cfg.Retry.BaseDelayMs) * time.Millisecond,
+		MaxDelay:  time.Duration(cfg.Retry.MaxDelayMs) * time.Millisecond,
+	}
+	if len(cfg.Model.Overrides) > 0 {
+		if err := pages.SetModelOverrides(cfg.Model.Overrides); err != nil {
+			return fmt.Errorf("invalid model overrides: %w", err)
+		}
+		log.Printf("🎛️  Requests may pick %s with ?model=", strings.Join(cfg.Model.Overrides, ", "))
+	}
+	if cfg.Shadow.Backend != "" {
+		if err := pages.SetShadow(cfg.Shadow.Backend, cfg.Shadow.Sample, cfg.Shadow.Dir); err != nil {
+			return fmt.Errorf("invalid shadow: %w", err)
+		}
+		log.Printf("🪞 Mirroring %g%% of generations to shadow backend '%s'", cfg.Shadow.Sample*100, cfg.Shadow.Backend)
+	}
+	return nil
+}
+
+// setupGeneration configures how the backends generate: reasoning, sampling,
+// and the per-backend options
+func (s *Server) setupGeneration() error {
+	cfg, pages := s.cfg, s.Pages
+
+	if cfg.Scrub.Emails || cfg.Scrub.Phones || len(cfg.Scrub.Terms) > 0 {
+		pages.Scrubber = utils.NewScrubber(cfg.Scrub.Emails, cfg.Scrub.Phones, cfg.Scrub.Terms, cfg.Scrub.Allow, cfg.Scrub.Mask)
+		log.Printf("🧽 Masking personal data and %d terms in generated pages", len(cfg.Scrub.Terms))
+	}
+	c := cfg.Input
+	if c.MaxLength > 0 || c.StripControl || len(c.Deny) > 0 || c.Delimit {
+		filter, err := utils.NewInputFilter(c.MaxLength, c.StripControl, c.Deny, c.Delimit)
+		if err != nil {
+			return fmt.Errorf("input: %w", err)
+		}
+		pages.InputFilter = filter
+	}
+	pages.Templates, pages.TemplateVars = cfg.Templates.Enabled, cfg.Templates.Vars
+	if cfg.Templates.Enabled {
+		log.Printf("🧩 Page prompts are rendered as templates with %d configured variables", len(cfg.Templates.Vars))
+	}
+
+	pages.Reasoning = models.ReasoningOptions{
+		Effort:       cfg.Model.Reasoning.Effort,
+		BudgetTokens: cfg.Model.Reasoning.BudgetTokens,
+	}
+	if err := pages.Reasoning.Validate(); err != nil {
+		return fmt.Errorf("invalid model.reasoning: %w", err)
+	}
+
+	if or := cfg.OpenRouter; len(or.Provider) > 0 || or.Route != "" || len(or.Models) > 0 || len(or.Transforms) > 0 {
+		pages.OpenRouter = &models.OpenRouterOptions{
+			Provider:   or.Provider,
+			Route:      or.Route,
+			Models:     or.Models,
+			Transforms: or.Transforms,
+		}
+	}
+
+	sampling := cfg.OpenAI.Sampling
+	pages.Sampling = models.SamplingOptions{
+		Temperature:      sampling.Temperature,
+		TopP:             sampling.TopP,
+		MaxTokens:        sampling.MaxTokens,
+		PresencePenalty:  sampling.PresencePenalty,
+		FrequencyPenalty: sampling.FrequencyPenalty,
+		Seed:             sampling.Seed,
+		Stop:             sampling.Stop,
+	}
+	if err := pages.Sampling.Validate(); err != nil {
+		return fmt.Errorf("invalid openai.sampling: %w", err)
+	}
+
+	if cfg.Model.ResponseAdapter != "" {
+		if _, err := models.LookupAdapter(cfg.Model.ResponseAdapter); err != nil {
+			return fmt.Errorf("invalid model.response_adapter: %w", err)
+		}
+		pages.ResponseAdapter = cfg.Model.ResponseAdapter
+	}
+
+	pages.LlamaCpp = models.LlamaCppOptions{
+		NPredict:    cfg.LlamaCpp.NPredict,
+		CachePrompt: cfg.LlamaCpp.CachePrompt,
+		Grammar:     cfg.LlamaCpp.Grammar,
+		Template:    cfg.LlamaCpp.Template,
+	}
+	if cfg.LlamaCpp.GrammarFile != "" {
+		grammar, err := os.ReadFile(cfg.LlamaCpp.GrammarFile)
+		if err != nil {
+			return fmt.Errorf("could not read llamacpp.grammar_file: %w", err)
+		}
+		pages.LlamaCpp.Grammar = string(grammar)
+	}
+	if err := (models.GenerateMode{Template: cfg.LlamaCpp.Template, Raw: true}).Validate(); err != nil {
+		return fmt.Errorf("invalid llamacpp.template: %w", err)
+	}
+	pages.TGI = models.TGIOptions{MaxNewTokens: cfg.TGI.MaxNewTokens, Template: cfg.TGI.Template}
+	if err := (models.GenerateMode{Template: cfg.TGI.Template, Raw: true}).Validate(); err != nil {
+		return fmt.Errorf("invalid tgi.template: %w", err)
+	}
+
+	// Replay recorded responses instead of calling a model, or record live ones
+	pages.Mock = models.MockOptions{
+		Dir:    cfg.Mock.Dir,
+		Record: cfg.Mock.Record,
+		Delay:  time.Duration(cfg.Mock.DelayMS) * time.Millisecond,
+	}
+	if s.backend == "mock" {
+		log.Printf("🎭 Replaying recorded responses from '%s'", cfg.Mock.Dir)
+	}
+	if cfg.Mock.Record && s.backend != "mock" {
+		if err := os.MkdirAll(cfg.Mock.Dir, 0o755); err != nil {
+			return fmt.Errorf("could not create mock.dir: %w", err)
+		}
+		log.Printf("🎙️ Recording responses to '%s' for the mock backend", cfg.Mock.Dir)
+	}
+
+	if len(cfg.Ollama.Options) > 0 {
+		pages.OllamaOptions = models.OllamaOptions(cfg.Ollama.Options)
+		if err := pages.OllamaOptions.Validate(); err != nil {
+			return fmt.Errorf("invalid ollama.options: %w", err)
+		}
+	}
+
+	for pattern, g := range cfg.Ollama.GenerateModels {
+		mode := models.GenerateMode{Template: g.Template, Raw: g.Raw}
+		if err := mode.Validate(); err != nil {
+			return fmt.Errorf("invalid ollama.generate_models[%q].template: %w", pattern, err)
+		}
+		if pages.OllamaGenerate == nil {
+			pages.OllamaGenerate = models.GenerateModes{}
+		}
+		pages.OllamaGenerate[pattern] = mode
+		log.Printf("🦙 Ollama models matching '%s' use the generate API (raw: %v)", pattern, g.Raw)
+	}
+
+	if len(cfg.Ollama.Nodes) > 0 {
+		nodes := make([]models.Node, len(cfg.Ollama.Nodes))
+		for i, n := range cfg.Ollama.Nodes {
+			nodes[i] = models.Node{APIBase: n.APIBase, Weight: n.Weight}
+		}
+		balancer, err := models.NewBalancer(nodes)
+		if err != nil {
+			return fmt.Errorf("invalid ollama.nodes: %w", err)
+		}
+		if cfg.Ollama.NodeCooldown > 0 {
+			balancer.Cooldown = time.Duration(cfg.Ollama.NodeCooldown) * time.Second
+		}
+		pages.OllamaNodes = balancer
+		log.Printf("⚖️  Balancing Ollama generations over %d nodes", len(nodes))
+	}
+
+	// Tools prompts can let the model call
+	pages.Tools = server.ToolOptions{DataDir: cfg.Tools.DataDir, AllowedHosts: cfg.Tools.AllowedHosts}
+
+	// Images uploaded for multimodal models
+	if cfg.Vision.MaxImageMB <= 0 {
+		return fmt.Errorf("vision.max_image_mb must be positive")
+	}
+	pages.MaxImageBytes = int64(cfg.Vision.MaxImageMB) << 20
+	return nil
+}
+
+// setupModel checks that the model exists and loads what it can do
+func (s *Server) setupModel(apiKey, apiBase string) error {
+	cfg := s.cfg
+
+	// Find out now, not on the first visit, whether the model exists
+	if s.backend == "ollama" && len(cfg.Ollama.Nodes) > 0 {
+		for _, n := range cfg.Ollama.Nodes {
+			if err := ensureModel(s.backend, s.model, apiKey, n.APIBase, cfg.Model.AutoPull); err != nil {
+				return err
+			}
+		}
+	} else if err := ensureModel(s.backend, s.model, apiKey, apiBase, cfg.Model.AutoPull); err != nil {
+		return err
+	}
+
+	// What the models can do decides parameters and prompt size warnings;
+	// configured descriptions win over what the providers report
+	if cfg.Capabilities.Fetch {
+		if n := s.Pages.LoadCapabilities(context.Background()); n > 0 {
+			log.Printf("📐 Loaded the capabilities of %d model(s) from their providers", n)
+		}
+	}
+	for _, m := range cfg.Capabilities.Models {
+		caps := models.Capabilities{
+			ContextWindow: m.ContextWindow,
+			MaxOutput:     m.MaxOutput,
+			Vision:        m.Vision,
+			Tools:         m.Tools,
+			Reasoning:     m.Reasoning,
+			PriceTier:     m.PriceTier,
+		}
+		if err := models.SetCapabilities(m.Model, caps); err != nil {
+			return fmt.Errorf("invalid capabilities: %w", err)
+		}
+	}
+	if len(cfg.Capabilities.Models) > 0 {
+		log.Printf("📐 %d model description(s) loaded", len(cfg.Capabilities.Models))
+	}
+	return nil
+}
+
+// setupRendering configures how pages reach the browser
+func (s *Server) setupRendering() error {
+	cfg, pages := s.cfg, s.Pages
+
+	switch cfg.Server.RenderMode {
+	case server.RenderStream, "":
+	case server.RenderMorph:
+		pages.RenderMode = server.RenderMorph
+		log.Printf("🧬 DOM-morphing render mode enabled")
+	default:
+		return fmt.Errorf("invalid server.render_mode %q (use stream or morph)", cfg.Server.RenderMode)
+	}
+
+	switch cfg.Server.Metadata {
+	case "":
+	case server.MetadataComment, server.MetadataTrailers, server.MetadataBoth:
+		pages.Metadata = cfg.Server.Metadata
+		log.Printf("🏷️ Attaching generation metadata to pages (%s)", cfg.Server.Metadata)
+	default:
+		return fmt.Errorf("invalid server.metadata %q (use comment, trailers, or both)", cfg.Server.Metadata)
+	}
+
+	pages.ClientBuffer = cfg.Server.ClientBufferKB * 1024
+	if cfg.Server.StallTimeout > 0 {
+		pages.StallTimeout = time.Duration(cfg.Server.StallTimeout) * time.Second
+	}
+
+	// Keep the backend from being swamped by bursts of visitors
+	if cfg.Server.MaxGenerations > 0 {
+		if cfg.Server.QueueSize < 0 || cfg.Server.QueueWait < 0 {
+			return fmt.Errorf("invalid server.queue_size or queue_wait: must not be negative")
+		}
+		pages.Limit = server.NewGenerationLimit(cfg.Server.MaxGenerations, cfg.Server.QueueSize, time.Duration(cfg.Server.QueueWait)*time.Second)
+		log.Printf("🚦 At most %d generations at once, %d more queued for up to %ds", cfg.Server.MaxGenerations, cfg.Server.QueueSize, cfg.Server.QueueWait)
+	} else if cfg.Server.MaxGenerations < 0 {
+		return fmt.Errorf("invalid server.max_generations %d: must not be negative", cfg.Server.MaxGenerations)
+	}
+
+	// How page prompts become the model's system and user prompts
+	if cfg.Composer.Strategy != "" && cfg.Composer.Strategy != server.DefaultComposer {
+		err := pages.SetComposer(cfg.Composer.Strategy, server.ComposerOptions{ExamplesDir: cfg.Composer.ExamplesDir, MaxExamples: cfg.Composer.MaxExamples})
+		if err != nil {
+			return fmt.Errorf("invalid composer: %w", err)
+		}
+		log.Printf("🧩 Composing prompts with the %s strategy", cfg.Composer.Strategy)
+	}
+
+	if cfg.Navigation.Enabled || cfg.Navigation.Render {
+		pages.Navigation = true
+		log.Printf("🧭 Site navigation from prompt front matter enabled")
+	}
+	if cfg.Translation.Provider != "" {
+		apiKey := cfg.Translation.APIKey
+		if apiKey == "" && cfg.Translation.Provider == "deepl" {
+			apiKey = os.Getenv("DEEPL_API_KEY")
+		}
+		translator, err := translate.New(cfg.Translation.Provider, apiKey, cfg.Translation.APIBase)
+		if err != nil {
+			return fmt.Errorf("invalid translation: %w", err)
+		}
+		pages.Translator = translator
+		if cfg.Translation.CacheTTL > 0 {
+			pages.TranslationCache = translate.NewCache(time.Duration(cfg.Translation.CacheTTL) * time.Second)
+		}
+		log.Printf("🌐 Pages requested with ?lang= are translated by %s", translator.Name())
+	}
+	return nil
+}
+
+// setupPostProcessing configures the passes over each complete page
+func (s *Server) setupPostProcessing() error {
+	cfg, pages := s.cfg, s.Pages
+
+	// The public site URL keeps generated absolute URLs on the right host
+	var baseURL *url.URL
+	if cfg.Server.BaseURL != "" {
+		u, err := postprocess.ParseBaseURL(cfg.Server.BaseURL)
+		if err != nil {
+			return fmt.Errorf("invalid server.base_url: %w", err)
+		}
+		baseURL = u
+		pages.BaseURL = u.String()
+		log.Printf("🏠 Public site URL: %s", pages.BaseURL)
+	}
+
+	if cfg.PostProcess.Accessibility {
+		pages.PostProcessors = append(pages.PostProcessors, postprocess.NewAccessibility())
+		log.Printf("♿ Accessibility post-processing enabled")
+	}
+	if cfg.PostProcess.LinkValidation != "" {
+		validator, err := postprocess.NewLinkValidator(cfg.PostProcess.LinkValidation, func() ([]string, error) {
+			return server.ListRoutes(pages.ActivePromptsDir())
+		})
+		if err != nil {
+			return fmt.Errorf("invalid postprocess.link_validation: %w", err)
+		}
+		if baseURL != nil {
+			validator.SetBaseURL(baseURL)
+		}
+		pages.PostProcessors = append(pages.PostProcessors, validator)
+		log.Printf("🔗 Internal link validation enabled (mode: %s)", cfg.PostProcess.LinkValidation)
+	}
+	if spelling := cfg.PostProcess.Spelling; spelling.Enabled {
+		var complete postprocess.CompleteFunc
+		if spelling.Model != "" {
+			complete = pages.Completer(spelling.Model)
+		}
+		checker, err := postprocess.NewSpelling(spelling.Languages, spelling.Dictionary, complete)
+		if err != nil {
+			return fmt.Errorf("invalid postprocess.spelling: %w", err)
+		}
+		pages.PostProcessors = append(pages.PostProcessors, checker)
+		log.Printf("🔤 Spelling correction enabled for %v", spelling.Languages)
+	}
+	if cfg.Navigation.Render {
+		pages.PostProcessors = append(pages.PostProcessors, postprocess.NewNavigation(func() ([]postprocess.NavItem, error) {
+			return server.BuildNavigation(pages.ActivePromptsDir())
+		}))
+		log.Printf("🧭 Navigation menus are rendered server-side")
+	}
+	if cfg.SEO.Enabled {
+		canonical := cfg.SEO.CanonicalBaseURL
+		if canonical == "" {
+			canonical = pages.BaseURL
+		}
+		pages.PostProcessors = append(pages.PostProcessors, postprocess.NewSEO(postprocess.SEOOptions{
+			SiteName:           cfg.SEO.SiteName,
+			DefaultDescription: cfg.SEO.DefaultDescription,
+			CanonicalBaseURL:   canonical,
+			NoIndex:            cfg.SEO.NoIndex,
+		}))
+		log.Printf("🔎 SEO metadata enforcement enabled")
+	}
+	if cfg.Static.Fingerprint {
+		pages.PostProcessors = append(pages.PostProcessors, postprocess.NewFingerprint(s.staticAssets().Fingerprint))
+		log.Printf("🔖 Asset URLs on pages are fingerprinted for long-lived caching")
+	}
+	if c := cfg.PostProcess.Sanitize; c.Enabled {
+		pages.Sanitizer = postprocess.NewSanitizer(postprocess.SanitizeOptions{
+			ScriptSources: c.ScriptSources,
+			FrameSources:  c.FrameSources,
+			URLSchemes:    c.URLSchemes,
+		})
+		log.Printf("🧼 Generated pages are sanitized: scripts, event handlers, and unsafe URLs are removed")
+	}
+	// Runs last so links added by other passes are covered too. The pass buffers
+	// pages, so on its own it only runs when links need the base path.
+	if baseURL != nil && (len(pages.PostProcessors) > 0 || baseURL.Path != "") {
+		pages.PostProcessors = append(pages.PostProcessors, postprocess.NewBaseURL(baseURL))
+	}
+	return nil
+}
+
+// setupHistory configures snapshots, caching, warming, and live reload
+func (s *Server) setupHistory() error {
+	cfg, pages := s.cfg, s.Pages
+
+	// Keep a history of generations that can be pinned or rolled back
+	if cfg.Snapshots.Enabled {
+		store, err := snapshot.Open(cfg.Snapshots.Dir, cfg.Snapshots.Keep)
+		if err != nil {
+			return fmt.Errorf("could not open snapshot store: %w", err)
+		}
+		pages.Snapshots = store
+		log.Printf("📸 Keeping the last %d generations per route in '%s'", cfg.Snapshots.Keep, cfg.Snapshots.Dir)
+	}
+
+	// Serve pages generated before from memory
+	if cfg.Cache.Enabled {
+		if cfg.Cache.Size < 1 || cfg.Cache.TTL < 1 {
+			return fmt.Errorf("invalid cache: size and ttl must be positive")
+		}
+		ttl := time.Duration(cfg.Cache.TTL) * time.Second
+		switch cfg.Cache.Driver {
+		case "", "memory":
+			pages.Cache = server.NewResponseCache(cfg.Cache.Size, ttl)
+			log.Printf("🗃️  Caching up to %d generated pages for %ds", cfg.Cache.Size, cfg.Cache.TTL)
+		case "redis":
+			rc := cfg.Cache.Redis
+			shared, err := redis.Open(redis.Options{Address: rc.Address, Password: rc.Password, DB: rc.DB, Prefix: rc.Prefix, TTL: ttl, Stale: time.Duration(rc.Stale) * time.Second})
+			if err != nil {
+				return fmt.Errorf("could not open the shared cache: %w", err)
+			}
+			s.redis, pages.Cache = shared, shared
+			log.Printf("🗃️  Caching generated pages for %ds in Redis at %s, shared with other instances", cfg.Cache.TTL, rc.Address)
+		default:
+			return fmt.Errorf("invalid cache.driver %q: use memory or redis", cfg.Cache.Driver)
+		}
+	}
+
+	// Generate listed pages at startup (and on a schedule) so they are always cached
+	if len(cfg.Cache.Prewarm) > 0 {
+		if !cfg.Cache.Enabled {
+			return fmt.Errorf("cache.prewarm needs cache.enabled")
+		}
+		pages.Prewarm = cfg.Cache.Prewarm
+		if cfg.Cache.RegenerateEvery != "" {
+			every, err := time.ParseDuration(cfg.Cache.RegenerateEvery)
+			if err != nil || every <= 0 {
+				return fmt.Errorf("invalid cache.regenerate_every %q: use a positive duration such as \"6h\"", cfg.Cache.RegenerateEvery)
+			}
+			if every > time.Duration(cfg.Cache.TTL)*time.Second {
+				log.Printf("⚠️  cache.regenerate_every (%v) is longer than cache.ttl (%ds); prewarmed pages expire before they are regenerated", every, cfg.Cache.TTL)
+			}
+			pages.PrewarmEvery = every
+		}
+		log.Printf("🔥 Prewarming %d pages: %s", len(pages.Prewarm), strings.Join(pages.Prewarm, ", "))
+	}
+
+	// Regenerate popular pages in the background so visitors never wait for them
+	if cfg.Warm.Enabled {
+		if cfg.Warm.Top < 1 || cfg.Warm.Interval < 1 {
+			return fmt.Errorf("invalid warm: top and interval must be positive")
+		}
+		pages.Warm = server.NewWarmer(cfg.Warm.Top, time.Duration(cfg.Warm.Interval)*time.Second)
+		log.Printf("🔥 Keeping the %d most requested pages warm, regenerated every %ds", cfg.Warm.Top, cfg.Warm.Interval)
+	}
+
+	// In dev mode, watch the prompts directory and live-reload connected browsers
+	if cfg.Server.DevMode {
+		pages.LiveReload = server.NewLiveReload(pages.ActivePromptsDir)
+		s.mux.Handle(server.LiveReloadPath, pages.LiveReload)
+		log.Printf("🔄 Dev mode: live-reload enabled for '%s'", cfg.Server.PromptsDir)
+	}
+	return nil
+}
+
+// setupMetrics exposes the Prometheus metrics, on the site's port or on a
+// listener of their own (started by Start)
+func (s *Server) setupMetrics() error {
+	cfg, pages := s.cfg, s.Pages
+	if !cfg.Metrics.Enabled {
+		return nil
+	}
+	if !strings.HasPrefix(cfg.Metrics.Path, "/") {
+		return fmt.Errorf("invalid metrics.path %q: must start with /", cfg.Metrics.Path)
+	}
+	pages.Metrics = server.NewMetrics(pages)
+	if cfg.Metrics.Address == "" {
+		s.mux.Handle(cfg.Metrics.Path, pages.Metrics)
+		log.Printf("📈 Prometheus metrics available at %s", cfg.Metrics.Path)
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(cfg.Metrics.Address); err != nil {
+		return fmt.Errorf("invalid metrics.address %q: %w", cfg.Metrics.Address, err)
+	}
+	s.metricsMux = http.NewServeMux()
+	s.metricsMux.Handle(cfg.Metrics.Path, pages.Metrics)
+	return nil
+}
+
+// setupTracing exports traces of every request to an OTLP collector
+func (s *Server) setupTracing() error {
+	cfg := s.cfg
+	if !cfg.Tracing.Enabled {
+		return nil
+	}
+	tracer, err := tracing.New(tracing.Options{
+		Endpoint:    cfg.Tracing.Endpoint,
+		Headers:     cfg.Tracing.Headers,
+		ServiceName: cfg.Tracing.ServiceName,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		return fmt.Errorf("invalid tracing: %w", err)
+	}
+	s.tracer = tracer
+	log.Printf("🔭 Exporting traces of %.0f%% of requests to %s", cfg.Tracing.SampleRatio*100, cfg.Tracing.Endpoint)
+	return nil
+}
+
+// setupAccessLog opens the access log
+func (s *Server) setupAccessLog() error {
+	path := s.cfg.Server.AccessLog
+	if path == "" {
+		return nil
+	}
+	access, err := server.OpenAccessLog(path)
+	if err != nil {
+		return err
+	}
+	s.access = access
+	log.Printf("📒 Logging requests to %s", path)
+	return nil
+}
+
+// authenticate makes h private when site-wide authentication is configured
+func (s *Server) authenticate(h http.Handler) (http.Handler, error) {
+	c := s.cfg.Auth
+	if !c.Enabled {
+		return h, nil
+	}
+	if len(c.Users) == 0 && c.Token == "" {
+		return nil, fmt.Errorf("auth is enabled but has neither users nor a token")
+	}
+	log.Printf("🔏 Site requires authentication (%d user(s), token: %v)", len(c.Users), c.Token != "")
+	exempt := slices.Clone(c.Exempt)
+	// Every path under /admin/ is answered by a handler checking the admin
+	// token (see RegisterAdmin), so admins need not pass the site's auth too.
+	// Without the admin endpoints, /admin/ paths are pages like any other.
+	if s.Pages.AdminToken != "" {
+		exempt = append(exempt, "/admin/")
+	}
+	return middleware.Auth(h, middleware.AuthOptions{
+		Users:  c.Users,
+		Token:  c.Token,
+		Realm:  c.Realm,
+		Exempt: exempt,
+	}), nil
+}
+
+// filterClients turns away the clients ip_filter does not admit before h
+// sees them; country lists use country, or the configured header
+func (s *Server) filterClients(h http.Handler, country func(*http.Request, net.IP) string) (http.Handler, error) {
+	c := s.cfg.IPFilter
+	if !c.Enabled {
+		return h, nil
+	}
+	allow, err := middleware.ParseNetworks(c.Allow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ip_filter.allow: %w", err)
+	}
+	deny, err := middleware.ParseNetworks(c.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ip_filter.deny: %w", err)
+	}
+	if country == nil && c.CountryHeader != "" {
+		country = middleware.CountryHeader(c.CountryHeader, nil) // Believed from server.trusted_proxies
+	}
+	if country == nil && len(c.AllowCountries)+len(c.DenyCountries) > 0 {
+		return nil, fmt.Errorf("ip_filter country lists need a country_header")
+	}
+	log.Printf("🧱 Filtering clients: %d allowed and %d denied network(s), %d allowed and %d denied countries",
+		len(allow), len(deny), len(c.AllowCountries), len(c.DenyCountries))
+	return middleware.IPFilter(h, middleware.IPFilterOptions{
+		Allow:          allow,
+		Deny:           deny,
+		Country:        country,
+		AllowCountries: c.AllowCountries,
+		DenyCountries:  c.DenyCountries,
+		Reject: func(w http.ResponseWriter, r *http.Request) {
+			errors.RenderErrorPage(w, r, http.StatusForbidden, "Access to this site is restricted.")
+		},
+	}), nil
+}
+
+// setupLogin signs visitors in with the configured OpenID Connect provider
+func (s *Server) setupLogin() error {
+	c := s.cfg.OIDC
+	if !c.Enabled {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	login, err := auth.NewOIDC(ctx, auth.Options{
+		Issuer:        c.Issuer,
+		ClientID:      c.ClientID,
+		ClientSecret:  c.ClientSecret,
+		RedirectURL:   c.RedirectURL,
+		Scopes:        c.Scopes,
+		Claims:        c.Claims,
+		SessionSecret: c.SessionSecret,
+		SessionTTL:    time.Duration(c.SessionHours) * time.Hour,
+	})
+	if err != nil {
+		return fmt.Errorf("oidc: %w", err)
+	}
+	login.Register(s.mux)
+	s.Pages.Users = login
+	log.Printf("🔑 Visitors can sign in with %s at /auth/login", c.Issuer)
+	return nil
+}
+
+// staticAssets returns the static files of the prompt set's and the global
+// public directories
+func (s *Server) staticAssets() *server.Assets {
+	if s.assets == nil {
+		s.assets = &server.Assets{Dirs: []string{filepath.Join(s.cfg.Server.PromptsDir, "public"), "public"}}
+		if !s.cfg.Server.DevMode {
+			s.assets.MaxAge = time.Duration(s.cfg.Static.MaxAge) * time.Second
+		}
+	}
+	return s.assets
+}
+
+// compress wraps h in the configured response compression, if any
+func (s *Server) compress(h http.Handler) http.Handler {
+	c := s.cfg.Compression
+	if !c.Enabled {
+		return h
+	}
+	return middleware.Compress(h, middleware.CompressOptions{Level: c.Level, MinSize: c.MinSize, Types: c.Types})
+}
+
+// secure sets the configured security headers on the responses of h
+func (s *Server) secure(h http.Handler) http.Handler {
+	c := s.cfg.SecurityHeaders
+	if !c.Enabled {
+		return h
+	}
+	opts := middleware.DefaultSecurity
+	if c.HSTS != nil {
+		opts.HSTS = *c.HSTS
+	}
+	if c.FrameOptions != nil {
+		opts.FrameOptions = *c.FrameOptions
+	}
+	if c.ReferrerPolicy != nil {
+		opts.ReferrerPolicy = *c.ReferrerPolicy
+	}
+	if c.ContentSecurityPolicy != nil {
+		opts.ContentSecurityPolicy = *c.ContentSecurityPolicy
+	}
+	return middleware.SecurityHeaders(h, opts)
+}
+
+// rateLimit returns the middleware limiting requests per client, which
+// passes requests on untouched when rate limiting is disabled
+func (s *Server) rateLimit() (func(http.Handler) http.Handler, error) {
+	c := s.cfg.RateLimit
+	if !c.Enabled {
+		return func(h http.Handler) http.Handler { return h }, nil
+	}
+	// Clients are told apart by the address RealIP found with
+	// server.trusted_proxies, like everywhere else
+	opts := middleware.RateLimitOptions{
+		Default: middleware.RateLimit(c.RateLimit),
+		Keys:    make(map[string]middleware.RateLimit, len(c.Keys)),
+		Reject: func(w http.ResponseWriter, r *http.Request) {
+			errors.RenderErrorPage(w, r, http.StatusTooManyRequests, "Too many requests. Please slow down and try again in a moment.")
+		},
+	}
+	for key, limit := range c.Keys {
+		opts.Keys[key] = middleware.RateLimit(limit)
+	}
+	log.Printf("🚦 Rate limiting clients to %d requests per minute and %d streams at once (0 = unlimited)", c.PerMinute, c.Streams)
+	return middleware.NewRateLimiter(opts).Middleware, nil
+}
+
+// setupRoutes registers the site's routes: pages and static files,
+// redirects, API proxies, and GraphQL
+func (s *Server) setupRoutes() error {
+	cfg, pages := s.cfg, s.Pages
+
+	// With compression, static files may come precompressed
+	serveFile := http.ServeFile
+	if cfg.Compression.Enabled {
+		if cfg.Compression.Level < 0 || cfg.Compression.Level > 9 {
+			return fmt.Errorf("invalid compression.level %d: use 1 to 9, or 0 for the default", cfg.Compression.Level)
+		}
+		serveFile = middleware.ServeFile
+		log.Printf("🗜️  Compressing responses with gzip")
+	}
+	assets := s.staticAssets()
+	assets.ServeFile = serveFile
+
+	// Per-client rate limits on generating pages and on the GraphQL API
+	limit, err := s.rateLimit()
+	if err != nil {
+		return err
+	}
+	generate := limit(pages)
+
+	// Main route handler with recovery middleware
+	mainHandler := middleware.WrapHandler(func(w http.ResponseWriter, r *http.Request) {
+		// Serve static files if the path contains a dot (file extension)
+		if strings.Contains(r.URL.Path, ".") {
+			// Try the prompt-scoped public directory first, then the global one
+			staticReqPath := strings.TrimPrefix(r.URL.Path, "/") // e.g. "logo.png" or "static/logo.png"
+			if assets.Serve(w, r, staticReqPath) {
+				return
+			}
+			// Prompts can generate non-HTML endpoints such as /events.ics (prompts/events.ics.txt),
+			// and old URLs like /index.html may be redirected
+			if pages.HasRoute(staticReqPath) || pages.Redirected(staticReqPath) {
+				generate.ServeHTTP(w, r)
+				return
+			}
+			// Not found in either location
+			errors.RenderErrorPage(w, r, http.StatusNotFound, fmt.Sprintf("Static file '%s' not found in prompt-scoped or global public directories", r.URL.Path))
+			return
+		}
+		// Otherwise, handle as a prompt request
+		generate.ServeHTTP(w, r)
+	})
+
+	// Redirects from old routes
+	if len(cfg.Redirects) > 0 {
+		redirects := make([]server.Redirect, len(cfg.Redirects))
+		for i, rd := range cfg.Redirects {
+			redirects[i] = server.Redirect{From: rd.From, To: rd.To, Status: rd.Status}
+		}
+		if err := pages.SetRedirects(redirects); err != nil {
+			return fmt.Errorf("invalid redirects: %w", err)
+		}
+		log.Printf("↪️  %d redirect(s) loaded", len(redirects))
+	}
+
+	// Per-route header, compression, and caching overrides
+	var routeRules server.RouteRules
+	for _, rule := range cfg.Routes {
+		routeRules = append(routeRules, server.RouteRule{
+			Pattern:       rule.Pattern,
+			Headers:       rule.Headers,
+			NoCompression: rule.NoCompression,
+			NoCache:       rule.NoCache,
+		})
+	}
+	if err := routeRules.Validate(); err != nil {
+		return fmt.Errorf("invalid routes: %w", err)
+	}
+	if len(routeRules) > 0 {
+		log.Printf("🛣️  %d route rule(s) loaded", len(routeRules))
+	}
+	s.registerHealth()
+	s.mux.Handle("/", pages.Metrics.Instrument(s.secure(routeRules.Middleware(s.compress(http.HandlerFunc(mainHandler))))))
+
+	// Pass-through routes to external APIs, so pages never carry their keys
+	if len(cfg.Proxies) > 0 {
+		proxies := make([]server.APIProxy, len(cfg.Proxies))
+		for i, p := range cfg.Proxies {
+			proxies[i] = server.APIProxy{
+				Path:     p.Path,
+				Upstream: p.Upstream,
+				Headers:  p.Headers,
+				Query:    p.Query,
+				Methods:  p.Methods,
+				CacheTTL: time.Duration(p.CacheTTL) * time.Second,
+				Timeout:  time.Duration(p.Timeout) * time.Second,
+			}
+		}
+		if err := server.RegisterProxies(s.mux, proxies); err != nil {
+			return fmt.Errorf("invalid proxies: %w", err)
+		}
+		log.Printf("🔁 %d API proxy route(s) loaded", len(proxies))
+	}
+
+	if cfg.Server.EnableGraphQL {
+		s.mux.Handle("/graphql", pages.Metrics.Instrument(s.compress(limit(middleware.WrapHandler(pages.HandleGraphQL)))))
+		log.Printf("🔌 GraphQL API available at /graphql")
+	}
+
+	// Add a test route for error handling (can be removed in production)
+	if cfg.Server.Debug {
+		s.mux.HandleFunc("/error-test", middleware.WrapHandler(func(w http.ResponseWriter, r *http.Request) {
+			// Test different error types based on query parameter
+			errorType := r.URL.Query().Get("type")
+			switch errorType {
+			case "panic":
+				panic("Test panic for error handling")
+			case "404":
+				errors.NotFound(w, r)
+			case "500":
+				errors.InternalServerError(w, r, "Test internal server error")
+			case "405":
+				errors.MethodNotAllowed(w, r)
+			default:
+				errors.BadRequest(w, r, "Invalid error type. Use: panic, 404, 500, or 405")
+			}
+		}))
+		log.Printf("📝 Debug mode: Error testing available at /error-test?type=[panic|404|500|405]")
+	}
+	return nil
+}
+
+// setupStorage opens the database and the features keeping state in it
+func (s *Server) setupStorage() error {
+	cfg, pages := s.cfg, s.Pages
+
+	// Optional embedded database for durable state
+	if cfg.Storage.SQLitePath != "" {
+		db, err := store.Open(cfg.Storage.SQLitePath)
+		if err != nil {
+			return fmt.Errorf("could not open database: %w", err)
+		}
+		s.db = db
+		if pages.Audit, err = store.NewAuditLog(db); err != nil {
+			return fmt.Errorf("could not prepare audit log: %w", err)
+		}
+		log.Printf("🗄️  Using SQLite database at '%s'", db.Path())
+	}
+
+	// Optional key-value store for prompt placeholders and form counters
+	if cfg.KV.Enabled {
+		if s.db == nil {
+			return fmt.Errorf("kv.enabled needs storage.sqlite_path")
+		}
+		var err error
+		if pages.KV, err = store.NewKV(s.db); err != nil {
+			return fmt.Errorf("could not prepare the key-value store: %w", err)
+		}
+		for _, pattern := range cfg.KV.Writable {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid kv.writable pattern %q: %w", pattern, err)
+			}
+		}
+		pages.KVWritable = cfg.KV.Writable
+		if len(cfg.KV.Writable) > 0 {
+			s.mux.HandleFunc("/kv/", middleware.WrapHandler(pages.HandleKV))
+		}
+		log.Printf("🔢 Key-value store enabled (%d writable pattern(s))", len(cfg.KV.Writable))
+	}
+
+	// Prices per model, shared by budgets and usage accounting; other models
+	// use the budget's prices
+	pricing := budget.Pricing{InputPer1K: cfg.Budget.InputCostPer1K, OutputPer1K: cfg.Budget.OutputCostPer1K}
+	prices := make(map[string]budget.Pricing, len(cfg.Usage.Prices))
+	for model, p := range cfg.Usage.Prices {
+		prices[model] = budget.Pricing{InputPer1K: p.InputPer1K, OutputPer1K: p.OutputPer1K}
+	}
+
+	// Optional token budgets; usage is kept in the database when there is one
+	if cfg.Budget.Enabled {
+		sites := make(map[string]budget.Limits, len(cfg.Budget.Sites))
+		for site, limits := range cfg.Budget.Sites {
+			sites[site] = budgetLimits(limits)
+		}
+		tracker, err := budget.New(budgetLimits(cfg.Budget.BudgetLimits), sites, pricing, s.db)
+		if err != nil {
+			return fmt.Errorf("could not set up budgets: %w", err)
+		}
+		tracker.SetModelPrices(prices)
+		pages.Budget = tracker
+		if cfg.Budget.Fallback != "" {
+			if pages.BudgetFallback, err = os.ReadFile(cfg.Budget.Fallback); err != nil {
+				return fmt.Errorf("could not read the budget fallback page: %w", err)
+			}
+		}
+		if s.db == nil {
+			log.Printf("💰 Token budgets enabled (usage resets on restart; set storage.sqlite_path to keep it)")
+		} else {
+			log.Printf("💰 Token budgets enabled")
+		}
+	}
+
+	// Token and cost accounting per day, route, and model
+	if cfg.Usage.Enabled {
+		ledger, err := budget.NewLedger(pricing, prices, cfg.Usage.KeepDays, s.db)
+		if err != nil {
+			return fmt.Errorf("could not set up usage accounting: %w", err)
+		}
+		pages.Usage, pages.UsageHeader = ledger, cfg.Usage.Header
+		log.Printf("🧮 Accounting token usage per route and model for %d days", cfg.Usage.KeepDays)
+	}
+	return nil
+}
+
+// setupRAG indexes the site's documents for grounding pages in them
+func (s *Server) setupRAG() error {
+	c := s.cfg.RAG
+	if !c.Enabled {
+		return nil
+	}
+	if c.TopK < 1 || c.ChunkSize < 100 {
+		return fmt.Errorf("invalid rag: top_k must be positive and chunk_size at least 100")
+	}
+	apiKey, apiBase := backendCredentials(s.cfg, c.Backend, c.APIKey, c.APIBase)
+	embedder, err := rag.NewEmbedder(c.Backend, c.Model, apiKey, apiBase)
+	if err != nil {
+		return fmt.Errorf("invalid rag: %w", err)
+	}
+
+	index := rag.NewIndex(embedder, c.DocsDir, c.ChunkSize)
+	started := time.Now()
+	n, err := index.Build(context.Background())
+	if err != nil {
+		return fmt.Errorf("could not index rag.docs_dir: %w", err)
+	}
+	s.Pages.RAG, s.Pages.RAGTopK = index, c.TopK
+	log.Printf("📚 Indexed %d chunks from '%s' with %s in %s", n, c.DocsDir, c.Model, time.Since(started).Round(time.Millisecond))
+	return nil
+}
+
+// budgetLimits converts configured limits to budget.Limits
+func budgetLimits(l config.BudgetLimits) budget.Limits {
+	return budget.Limits{
+		DailyTokens:   l.DailyTokens,
+		MonthlyTokens: l.MonthlyTokens,
+		DailyCost:     l.DailyCost,
+		MonthlyCost:   l.MonthlyCost,
+	}
+}
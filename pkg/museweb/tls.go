@@ -0,0 +1,128 @@
+package museweb
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// setupTLS loads the certificate for serving HTTPS, so a missing or
+// mismatched file is reported before the server starts, or prepares
+// obtaining certificates from Let's Encrypt
+func (s *Server) setupTLS() error {
+	c := s.cfg.Server.TLS
+	if len(c.Autocert.Domains) > 0 {
+		return s.setupAutocert()
+	}
+	if c.CertFile == "" && c.KeyFile == "" {
+		if c.RedirectHTTP != "" {
+			return fmt.Errorf("server.tls.redirect_http needs server.tls.cert_file and key_file, or autocert")
+		}
+		return nil
+	}
+	if c.CertFile == "" || c.KeyFile == "" {
+		return fmt.Errorf("server.tls needs both cert_file and key_file")
+	}
+	if err := checkRedirectAddress(c.RedirectHTTP); err != nil {
+		return err
+	}
+	s.redirectHTTP = c.RedirectHTTP
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return fmt.Errorf("could not load the TLS certificate: %w", err)
+	}
+	s.tls = tlsConfig()
+	s.tls.Certificates = []tls.Certificate{cert}
+	log.Printf("🔒 Serving HTTPS with the certificate in '%s'", c.CertFile)
+	return nil
+}
+
+// setupAutocert gets the certificates of the configured domains from Let's
+// Encrypt when they are first requested, and renews them before they expire
+func (s *Server) setupAutocert() error {
+	c := s.cfg.Server.TLS
+	if c.CertFile != "" || c.KeyFile != "" {
+		return fmt.Errorf("server.tls: use either cert_file and key_file or autocert, not both")
+	}
+	if c.Autocert.CacheDir == "" {
+		return fmt.Errorf("server.tls.autocert needs a cache_dir")
+	}
+	s.redirectHTTP = c.RedirectHTTP
+	if s.redirectHTTP == "" {
+		s.redirectHTTP = ":80"
+	}
+	if err := checkRedirectAddress(s.redirectHTTP); err != nil {
+		return err
+	}
+	s.acme = &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(c.Autocert.Domains...),
+		Cache:      autocert.DirCache(c.Autocert.CacheDir),
+		Email:      c.Autocert.Email,
+	}
+	s.tls = tlsConfig()
+	s.tls.GetCertificate = s.acme.GetCertificate
+	s.tls.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto} // ALPN for TLS-ALPN-01 challenges
+	if s.cfg.Server.Port != "443" {
+		log.Printf("⚠️  Let's Encrypt validates domains on port 443, but the server listens on port %s; forward 443 to it", s.cfg.Server.Port)
+	}
+	log.Printf("🔒 Serving HTTPS with Let's Encrypt certificates for %s (kept in '%s')", strings.Join(c.Autocert.Domains, ", "), c.Autocert.CacheDir)
+	return nil
+}
+
+// checkRedirectAddress validates server.tls.redirect_http, when it is set
+func checkRedirectAddress(addr string) error {
+	if addr == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return fmt.Errorf("invalid server.tls.redirect_http %q: %w", addr, err)
+	}
+	return nil
+}
+
+// tlsConfig accepts TLS 1.2 and 1.3 only and, for TLS 1.2, only forward
+// secret AEAD cipher suites (TLS 1.3 suites are not configurable)
+func tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		},
+	}
+}
+
+// redirectToHTTPS permanently redirects every request to the same URL over
+// HTTPS on port (left out of the URL when it is 443 or unknown, as for Unix
+// sockets)
+func redirectToHTTPS(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		if host == "" {
+			http.Error(w, "Missing Host header", http.StatusBadRequest)
+			return
+		}
+		if port != "443" && port != "" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]" // IPv6
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
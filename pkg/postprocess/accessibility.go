@@ -0,0 +1,289 @@
+package postprocess
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// langCodeRE matches values that are plausible BCP 47 language tags (en, es_ES, pt-BR)
+var langCodeRE = regexp.MustCompile(`^[a-zA-Z]{2,3}([_-][a-zA-Z0-9]{2,8})*$`)
+
+// Accessibility fixes common a11y problems in generated pages: a missing lang
+// attribute, images without alt text, skipped heading levels, and form
+// controls that are not associated with a label.
+type Accessibility struct{}
+
+// NewAccessibility creates the accessibility audit-and-fix pass
+func NewAccessibility() *Accessibility {
+	return &Accessibility{}
+}
+
+// Name implements Processor
+func (a *Accessibility) Name() string {
+	return "accessibility"
+}
+
+// Process implements Processor
+func (a *Accessibility) Process(page *Page) []string {
+	doc := parseDocument(page.HTML)
+	if doc == nil {
+		return nil
+	}
+
+	var notes []string
+	notes = append(notes, fixLang(doc, page.Lang)...)
+	notes = append(notes, fixImageAlt(doc)...)
+	notes = append(notes, fixHeadingOrder(doc)...)
+	notes = append(notes, fixLabels(doc)...)
+
+	if len(notes) == 0 {
+		return nil
+	}
+	rendered, err := renderDocument(doc)
+	if err != nil {
+		return nil
+	}
+	page.HTML = rendered
+	return notes
+}
+
+// fixLang adds a lang attribute to <html>: the requested language, or "en"
+// when none was requested. A requested value that is not a language code
+// says nothing about the page's language, so <html> is left alone then.
+func fixLang(doc *html.Node, requested string) []string {
+	requested = strings.TrimSpace(requested)
+	if requested != "" && !langCodeRE.MatchString(requested) {
+		return nil
+	}
+	var notes []string
+	walk(doc, func(n *html.Node) {
+		if n.DataAtom != atom.Html {
+			return
+		}
+		if lang, ok := getAttr(n, "lang"); ok && strings.TrimSpace(lang) != "" {
+			return
+		}
+		lang := "en"
+		if requested != "" {
+			lang = strings.ReplaceAll(requested, "_", "-")
+		}
+		setAttr(n, "lang", lang)
+		notes = append(notes, fmt.Sprintf("added lang=%q to <html>", lang))
+	})
+	return notes
+}
+
+// fixImageAlt gives every <img> without an alt attribute a placeholder derived from its file name
+func fixImageAlt(doc *html.Node) []string {
+	var notes []string
+	walk(doc, func(n *html.Node) {
+		if n.DataAtom != atom.Img {
+			return
+		}
+		if _, ok := getAttr(n, "alt"); ok {
+			return
+		}
+		src, _ := getAttr(n, "src")
+		alt := altFromSource(src)
+		setAttr(n, "alt", alt)
+		notes = append(notes, fmt.Sprintf("added placeholder alt=%q to <img src=%q>", alt, src))
+	})
+	return notes
+}
+
+// altFromSource turns "/images/team-photo_2.jpg" into "team photo 2"
+func altFromSource(src string) string {
+	if strings.HasPrefix(src, "data:") {
+		return "Image"
+	}
+	if i := strings.IndexAny(src, "?#"); i != -1 {
+		src = src[:i]
+	}
+	name := strings.TrimSuffix(path.Base(src), path.Ext(src))
+	name = strings.NewReplacer("-", " ", "_", " ", ".", " ").Replace(name)
+	name = strings.Join(strings.Fields(name), " ")
+	if name == "" || name == "/" {
+		return "Image"
+	}
+	return name
+}
+
+// headingLevels maps heading atoms to their level
+var headingLevels = map[atom.Atom]int{
+	atom.H1: 1, atom.H2: 2, atom.H3: 3, atom.H4: 4, atom.H5: 5, atom.H6: 6,
+}
+
+// headingAtoms maps levels back to heading atoms
+var headingAtoms = [...]atom.Atom{0, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6}
+
+// fixHeadingOrder renumbers headings that skip levels (h1 followed by h3 becomes h1, h2)
+func fixHeadingOrder(doc *html.Node) []string {
+	var notes []string
+	previous := 0
+	walk(doc, func(n *html.Node) {
+		level, ok := headingLevels[n.DataAtom]
+		if !ok {
+			return
+		}
+		if previous > 0 && level > previous+1 {
+			fixed := previous + 1
+			notes = append(notes, fmt.Sprintf("changed <h%d> %q to <h%d> to avoid skipping a heading level", level, truncate(textContent(n), 40), fixed))
+			n.DataAtom = headingAtoms[fixed]
+			n.Data = headingAtoms[fixed].String()
+			level = fixed
+		}
+		previous = level
+	})
+	return notes
+}
+
+// labelableControls are the form controls that need an accessible name
+var labelableControls = map[atom.Atom]bool{
+	atom.Input: true, atom.Select: true, atom.Textarea: true,
+}
+
+// fixLabels associates labels with their controls and names unlabeled controls
+func fixLabels(doc *html.Node) []string {
+	var notes []string
+	ids := make(map[string]bool)
+	labelled := make(map[string]bool)
+	walk(doc, func(n *html.Node) {
+		if id, ok := getAttr(n, "id"); ok {
+			ids[id] = true
+		}
+		if n.DataAtom == atom.Label {
+			if target, ok := getAttr(n, "for"); ok {
+				labelled[target] = true
+			}
+		}
+	})
+
+	generated := 0
+	newID := func() string {
+		for {
+			generated++
+			id := fmt.Sprintf("museweb-field-%d", generated)
+			if !ids[id] {
+				ids[id] = true
+				return id
+			}
+		}
+	}
+
+	// Labels without a for attribute: nested controls are already associated,
+	// otherwise bind the label to the control that immediately follows it.
+	walk(doc, func(n *html.Node) {
+		if n.DataAtom != atom.Label {
+			return
+		}
+		if _, ok := getAttr(n, "for"); ok || findControl(n) != nil {
+			return
+		}
+		control := nextControl(n)
+		if control == nil {
+			return
+		}
+		id, ok := getAttr(control, "id")
+		if !ok || id == "" {
+			id = newID()
+			setAttr(control, "id", id)
+		}
+		setAttr(n, "for", id)
+		labelled[id] = true
+		notes = append(notes, fmt.Sprintf("associated <label> %q with <%s id=%q>", truncate(textContent(n), 40), control.Data, id))
+	})
+
+	// Controls that still have no accessible name get an aria-label
+	walk(doc, func(n *html.Node) {
+		if !labelableControls[n.DataAtom] || !needsLabel(n) {
+			return
+		}
+		if id, ok := getAttr(n, "id"); ok && labelled[id] {
+			return
+		}
+		if insideLabel(n) {
+			return
+		}
+		for _, key := range []string{"aria-label", "aria-labelledby", "title"} {
+			if _, ok := getAttr(n, key); ok {
+				return
+			}
+		}
+		name, _ := getAttr(n, "placeholder")
+		if name == "" {
+			name, _ = getAttr(n, "name")
+		}
+		if name == "" {
+			return
+		}
+		setAttr(n, "aria-label", name)
+		notes = append(notes, fmt.Sprintf("added aria-label=%q to unlabeled <%s>", name, n.Data))
+	})
+	return notes
+}
+
+// needsLabel reports whether a control is user-visible and requires a label
+func needsLabel(n *html.Node) bool {
+	if n.DataAtom != atom.Input {
+		return true
+	}
+	typ, _ := getAttr(n, "type")
+	switch strings.ToLower(typ) {
+	case "hidden", "submit", "reset", "button", "image":
+		return false
+	}
+	return true
+}
+
+// findControl returns the first form control nested inside n
+func findControl(n *html.Node) *html.Node {
+	var found *html.Node
+	walk(n, func(c *html.Node) {
+		if found == nil && labelableControls[c.DataAtom] {
+			found = c
+		}
+	})
+	return found
+}
+
+// nextControl returns the form control immediately following a label
+func nextControl(label *html.Node) *html.Node {
+	for s := label.NextSibling; s != nil; s = s.NextSibling {
+		if s.Type != html.ElementNode {
+			continue
+		}
+		if labelableControls[s.DataAtom] {
+			return s
+		}
+		// Allow a single wrapper such as <div><input></div>, but stop at the next label
+		if s.DataAtom == atom.Label {
+			return nil
+		}
+		return findControl(s)
+	}
+	return nil
+}
+
+// insideLabel reports whether n is nested in a <label> element
+func insideLabel(n *html.Node) bool {
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p.DataAtom == atom.Label {
+			return true
+		}
+	}
+	return false
+}
+
+// truncate shortens s to at most n runes for log output
+func truncate(s string, n int) string {
+	runes := []rune(strings.Join(strings.Fields(s), " "))
+	if len(runes) <= n {
+		return string(runes)
+	}
+	return string(runes[:n]) + "…"
+}
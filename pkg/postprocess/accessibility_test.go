@@ -0,0 +1,74 @@
+package postprocess
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAccessibility(t *testing.T) {
+	a := NewAccessibility()
+
+	for _, tc := range []struct {
+		name  string
+		lang  string // Requested language
+		in    string
+		keep  []string // Must be in the output
+		gone  []string // Must not be
+		notes int
+	}{
+		{"default lang", "", `<html><body><p>Hi</p></body></html>`,
+			[]string{`<html lang="en">`}, nil, 1},
+		{"requested lang", "pt_BR", `<html><body></body></html>`,
+			[]string{`<html lang="pt-BR">`}, nil, 1},
+		{"requested lang with spaces", " de ", `<html><body></body></html>`,
+			[]string{`<html lang="de">`}, nil, 1},
+		{"blank lang", "fr", `<html lang=" "><body></body></html>`,
+			[]string{`<html lang="fr">`}, nil, 1},
+		{"existing lang", "fr", `<html lang="nb"><body></body></html>`,
+			[]string{`<html lang="nb">`}, []string{"fr"}, 0},
+		{"invalid requested lang", "not a language", `<html><body></body></html>`,
+			[]string{"<html>"}, []string{"lang="}, 0},
+		{"invalid requested lang keeps an empty one", `"><script>`, `<html lang=""><body></body></html>`,
+			[]string{`<html lang="">`}, []string{"script", "en"}, 0},
+		{"overlong requested lang", "en-abcdefghi", `<html><body></body></html>`,
+			nil, []string{"lang="}, 0},
+		{"image alt", "en", `<html lang="en"><body><img src="/images/team-photo_2.jpg?v=3"><img src="data:image/png;base64,AAAA"><img src="x.png" alt=""></body></html>`,
+			[]string{`alt="team photo 2"`, `alt="Image"`, `<img src="x.png" alt=""/>`}, nil, 2},
+		{"heading order", "en", `<html lang="en"><body><h1>A</h1><h3>B</h3><h4>C</h4><h2>D</h2></body></html>`,
+			[]string{"<h1>A</h1><h2>B</h2><h3>C</h3><h2>D</h2>"}, []string{"<h4>"}, 2},
+		{"label before its control", "en", `<html lang="en"><body><label>Name</label><input name="name"><label>Mail</label><div><input id="mail"></div></body></html>`,
+			[]string{`<label for="museweb-field-1">Name</label><input name="name" id="museweb-field-1"/>`, `<label for="mail">Mail</label>`}, []string{"aria-label"}, 2},
+		{"generated id already taken", "en", `<html lang="en"><body><p id="museweb-field-1"></p><label>Name</label><input></body></html>`,
+			[]string{`<label for="museweb-field-2">`, `<input id="museweb-field-2"/>`}, nil, 1},
+		{"unlabeled controls", "en", `<html lang="en"><body><input placeholder="Search"><select name="size"></select><input type="hidden" name="t"><input title="Age"><label>Nested <input name="n"></label></body></html>`,
+			[]string{`<input placeholder="Search" aria-label="Search"/>`, `<select name="size" aria-label="size">`, `<input type="hidden" name="t"/>`, `<input title="Age"/>`, `<input name="n"/>`}, nil, 2},
+		{"malformed markup", "", `<html><body><h1>A<h3>B</p><img src="a.png"`,
+			[]string{`<html lang="en">`, "<h2>B"}, nil, 2},
+		{"fragment", "de", `<p>Hi</p><img src="a.png">`,
+			[]string{`<p>Hi</p><img src="a.png">`}, []string{"alt", "lang"}, 0},
+	} {
+		page := &Page{HTML: tc.in, Lang: tc.lang}
+		notes := a.Process(page)
+		if len(notes) != tc.notes {
+			t.Errorf("%s: %d notes %q, want %d", tc.name, len(notes), notes, tc.notes)
+		}
+		for _, want := range tc.keep {
+			if !strings.Contains(page.HTML, want) {
+				t.Errorf("%s: %q lacks %q", tc.name, page.HTML, want)
+			}
+		}
+		for _, bad := range tc.gone {
+			if strings.Contains(page.HTML, bad) {
+				t.Errorf("%s: %q has %q", tc.name, page.HTML, bad)
+			}
+		}
+	}
+}
+
+func TestAccessibilityUnchanged(t *testing.T) {
+	const doc = `<!DOCTYPE html><html lang="en"><body><h1>A</h1><h2>B</h2><label for="q">Q</label><input id="q"/></body></html>`
+	page := &Page{HTML: doc, Lang: "de"}
+	if notes := NewAccessibility().Process(page); len(notes) != 0 || page.HTML != doc {
+		t.Errorf("accessible page changed (%v): %q", notes, page.HTML)
+	}
+}
@@ -0,0 +1,128 @@
+package postprocess
+
+// set makes a lookup table of names
+func set(names ...string) map[string]bool {
+	m := make(map[string]bool, len(names))
+	for _, name := range names {
+		m[name] = true
+	}
+	return m
+}
+
+// prefixed returns names with prefix in front of each
+func prefixed(prefix string, names ...string) []string {
+	out := make([]string, len(names))
+	for i, name := range names {
+		out[i] = prefix + name
+	}
+	return out
+}
+
+// allowedElements are the elements the sanitizer keeps. SVG and MathML
+// elements carry their namespace ("svg:path", "math:mi"); everything is
+// lowercase. Elements in neither list are replaced by their content.
+var allowedElements = set(append(append([]string{
+	"html", "head", "body", "title", "meta", "link", "style",
+	"main", "header", "footer", "nav", "section", "article", "aside", "search",
+	"h1", "h2", "h3", "h4", "h5", "h6", "hgroup", "p", "div", "span", "br", "hr", "wbr",
+	"pre", "blockquote", "ol", "ul", "li", "dl", "dt", "dd", "menu", "figure", "figcaption", "address",
+	"a", "em", "strong", "small", "s", "cite", "q", "dfn", "abbr", "ruby", "rt", "rp", "data", "time",
+	"code", "var", "samp", "kbd", "sub", "sup", "i", "b", "u", "mark", "bdi", "bdo", "ins", "del",
+	"center", "font", "big", "strike", "tt", "nobr",
+	"img", "picture", "source", "track", "video", "audio", "map", "area",
+	"table", "caption", "colgroup", "col", "thead", "tbody", "tfoot", "tr", "td", "th",
+	"form", "fieldset", "legend", "label", "input", "button", "select", "datalist", "optgroup",
+	"option", "textarea", "output", "progress", "meter", "details", "summary", "dialog",
+}, prefixed("svg:",
+	"svg", "g", "defs", "symbol", "use", "image", "switch", "desc", "title", "metadata", "style", "a", "view",
+	"path", "rect", "circle", "ellipse", "line", "polyline", "polygon", "text", "tspan", "textpath",
+	"clippath", "mask", "pattern", "marker", "lineargradient", "radialgradient", "stop",
+	"filter", "feblend", "fecolormatrix", "fecomponenttransfer", "fecomposite", "feconvolvematrix",
+	"fediffuselighting", "fedisplacementmap", "fedistantlight", "fedropshadow", "feflood",
+	"fefunca", "fefuncb", "fefuncg", "fefuncr", "fegaussianblur", "feimage", "femerge", "femergenode",
+	"femorphology", "feoffset", "fepointlight", "fespecularlighting", "fespotlight", "fetile", "feturbulence",
+)...), prefixed("math:",
+	"math", "mi", "mn", "mo", "ms", "mtext", "mspace", "mrow", "mfrac", "msqrt", "mroot", "mstyle",
+	"merror", "mpadded", "mphantom", "mfenced", "menclose", "msub", "msup", "msubsup", "munder",
+	"mover", "munderover", "mmultiscripts", "mprescripts", "none", "mtable", "mtr", "mtd",
+	"mlabeledtr", "semantics", "annotation",
+)...)...)
+
+// droppedElements are removed with everything in them: they run code, embed
+// other documents, change how the page resolves URLs, or hide markup from
+// the sanitizer. Scripts and iframes from allowed sources are kept (see
+// SanitizeOptions).
+var droppedElements = set(append(append([]string{
+	"script", "iframe", "frame", "frameset", "object", "embed", "applet", "param", "base",
+	"noscript", "noembed", "noframes", "template", "portal", "xmp", "plaintext", "listing",
+}, prefixed("svg:",
+	"script", "animate", "animatemotion", "animatetransform", "animatecolor", "set", "mpath",
+	"discard", "foreignobject", "handler", "listener",
+)...), prefixed("math:", "annotation-xml", "maction")...)...)
+
+// allowedAttributes may appear on any kept element. Event handlers never
+// may; data-* and aria-* attributes always may.
+var allowedAttributes = set(
+	// Global
+	"id", "class", "title", "lang", "dir", "hidden", "tabindex", "role", "style", "translate",
+	"accesskey", "draggable", "spellcheck", "inputmode", "enterkeyhint", "autocapitalize",
+	"itemscope", "itemprop", "itemtype", "itemid", "itemref", "property", "typeof", "vocab",
+	"prefix", "resource", "about", "xmlns", "xmlns:xlink", "xml:lang", "xml:space",
+	// HTML
+	"abbr", "accept", "accept-charset", "action", "align", "allow", "allowfullscreen", "alt",
+	"async", "autocomplete", "autoplay", "bgcolor", "border", "cellpadding", "cellspacing",
+	"charset", "checked", "cite", "color", "cols", "colspan", "content", "controls", "coords",
+	"crossorigin", "datetime", "decoding", "default", "defer", "dirname", "disabled", "download",
+	"enctype", "face", "fetchpriority", "for", "form", "formaction", "formenctype", "formmethod",
+	"formnovalidate", "formtarget", "frameborder", "headers", "height", "high", "href",
+	"hreflang", "http-equiv", "integrity", "ismap", "kind", "label", "list", "loading", "loop",
+	"low", "max", "maxlength", "media", "method", "min", "minlength", "multiple", "muted", "name",
+	"nomodule", "novalidate", "open", "optimum", "pattern", "ping", "placeholder", "playsinline",
+	"poster", "preload", "readonly", "referrerpolicy", "rel", "required", "reversed", "rows",
+	"rowspan", "sandbox", "scope", "selected", "shape", "size", "sizes", "span", "src", "srclang",
+	"srcset", "start", "step", "summary", "target", "type", "usemap", "valign", "value", "width", "wrap",
+	// SVG
+	"viewbox", "preserveaspectratio", "version", "x", "y", "x1", "x2", "y1", "y2", "cx", "cy", "r",
+	"rx", "ry", "d", "points", "transform", "pathlength", "fill", "fill-opacity", "fill-rule",
+	"stroke", "stroke-width", "stroke-opacity", "stroke-linecap", "stroke-linejoin",
+	"stroke-dasharray", "stroke-dashoffset", "stroke-miterlimit", "opacity", "clip-path",
+	"clip-rule", "clippathunits", "mask", "maskunits", "maskcontentunits", "marker-start",
+	"marker-mid", "marker-end", "markerwidth", "markerheight", "markerunits", "refx", "refy",
+	"orient", "patternunits", "patterncontentunits", "patterntransform", "gradientunits",
+	"gradienttransform", "spreadmethod", "offset", "stop-color", "stop-opacity", "fx", "fy", "fr",
+	"filter", "filterunits", "primitiveunits", "in", "in2", "result", "stddeviation", "dx", "dy",
+	"operator", "k1", "k2", "k3", "k4", "mode", "flood-color", "flood-opacity", "lighting-color",
+	"kernelmatrix", "order", "divisor", "bias", "targetx", "targety", "edgemode",
+	"kernelunitlength", "preservealpha", "surfacescale", "diffuseconstant", "specularconstant",
+	"specularexponent", "azimuth", "elevation", "pointsatx", "pointsaty", "pointsatz",
+	"limitingconeangle", "z", "basefrequency", "numoctaves", "seed", "stitchtiles", "scale",
+	"xchannelselector", "ychannelselector", "radius", "tablevalues", "slope", "intercept",
+	"amplitude", "exponent", "font-family", "font-size", "font-weight", "font-style",
+	"text-anchor", "dominant-baseline", "alignment-baseline", "baseline-shift", "letter-spacing",
+	"word-spacing", "text-decoration", "textlength", "lengthadjust", "startoffset", "spacing",
+	"rotate", "writing-mode", "display", "visibility", "overflow", "vector-effect",
+	"shape-rendering", "text-rendering", "image-rendering", "color-interpolation",
+	"color-interpolation-filters", "paint-order", "mix-blend-mode", "isolation",
+	"xlink:href", "xlink:title",
+	// MathML
+	"mathvariant", "mathsize", "mathcolor", "mathbackground", "displaystyle", "scriptlevel",
+	"linethickness", "numalign", "denomalign", "bevelled", "accent", "accentunder",
+	"columnalign", "rowalign", "columnspan", "columnlines", "rowlines", "frame", "framespacing",
+	"equalrows", "equalcolumns", "fence", "separator", "separators", "stretchy", "symmetric",
+	"largeop", "movablelimits", "lspace", "rspace", "minsize", "maxsize", "notation", "close",
+	"depth", "voffset", "encoding", "lquote", "rquote",
+)
+
+// elementAttributes may appear only on the element named. They are harmless
+// there but not on others, such as values on an SVG animation.
+var elementAttributes = map[string]map[string]bool{
+	"svg:fecolormatrix": set("values"),
+}
+
+// urlAttributes are the attributes holding a URL, on any element
+var urlAttributes = set(
+	"href", "src", "action", "formaction", "poster", "cite", "background", "data", "ping", "xlink:href",
+)
+
+// imageElements may load data:image/ URLs
+var imageElements = set("img", "source", "svg:image", "svg:feimage")
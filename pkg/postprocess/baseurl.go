@@ -0,0 +1,164 @@
+package postprocess
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ParseBaseURL validates a public site URL such as "https://example.com" or
+// "https://example.com/docs" and strips the trailing slash
+func ParseBaseURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%q is not an absolute http(s) URL", raw)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("%q must not have a query or fragment", raw)
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	return u, nil
+}
+
+// urlAttrs are the attributes holding same-site links that need the base
+// path when the site is served below one
+var urlAttrs = map[atom.Atom]string{
+	atom.A:      "href",
+	atom.Link:   "href",
+	atom.Img:    "src",
+	atom.Script: "src",
+	atom.Source: "src",
+	atom.Form:   "action",
+}
+
+// absoluteMeta are the meta properties that must hold absolute URLs
+var absoluteMeta = map[string]bool{
+	"og:url":        true,
+	"og:image":      true,
+	"twitter:image": true,
+}
+
+// BaseURL makes the URLs on generated pages agree with the configured public
+// address: metadata URLs (canonical, og:url, og:image) are made absolute on
+// the base host, and root-relative links get the base path when the site is
+// served below one, e.g. behind a proxy at https://example.com/site.
+type BaseURL struct {
+	base *url.URL
+}
+
+// NewBaseURL creates the pass for a URL accepted by ParseBaseURL
+func NewBaseURL(base *url.URL) *BaseURL {
+	return &BaseURL{base: base}
+}
+
+// Name implements Processor
+func (b *BaseURL) Name() string {
+	return "base-url"
+}
+
+// Process implements Processor
+func (b *BaseURL) Process(page *Page) []string {
+	doc := parseDocument(page.HTML)
+	if doc == nil {
+		return nil
+	}
+
+	var notes []string
+	fix := func(n *html.Node, key, value string) {
+		setAttr(n, key, value)
+		notes = append(notes, fmt.Sprintf("set <%s %s> to %q", n.Data, key, value))
+	}
+	walk(doc, func(n *html.Node) {
+		switch {
+		case n.DataAtom == atom.Meta:
+			property, _ := getAttr(n, "property")
+			if property == "" {
+				property, _ = getAttr(n, "name")
+			}
+			property = strings.ToLower(property)
+			content, ok := getAttr(n, "content")
+			if !absoluteMeta[property] || !ok {
+				return
+			}
+			if abs := b.absolute(content, page.Route, property == "og:url"); abs != content {
+				fix(n, "content", abs)
+			}
+		case n.DataAtom == atom.Link && (rel(n) == "canonical" || rel(n) == "alternate"):
+			if href, ok := getAttr(n, "href"); ok {
+				if abs := b.absolute(href, page.Route, rel(n) == "canonical"); abs != href {
+					fix(n, "href", abs)
+				}
+			}
+		default:
+			key, ok := urlAttrs[n.DataAtom]
+			if !ok {
+				return
+			}
+			if value, ok := getAttr(n, key); ok {
+				if prefixed := b.prefixed(value); prefixed != value {
+					fix(n, key, prefixed)
+				}
+			}
+		}
+	})
+
+	if len(notes) > 0 {
+		if rendered, err := renderDocument(doc); err == nil {
+			page.HTML = rendered
+		}
+	}
+	return notes
+}
+
+// rel returns the lower-cased rel attribute of a <link>
+func rel(n *html.Node) string {
+	v, _ := getAttr(n, "rel")
+	return strings.ToLower(strings.TrimSpace(v))
+}
+
+// absolute resolves ref against the page's public URL. With self set, ref
+// describes this site, so an absolute URL on some other (invented) host is
+// moved to the base host.
+func (b *BaseURL) absolute(ref, route string, self bool) string {
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil || u.Opaque != "" || (u.Scheme != "" && u.Host == "") {
+		return ref
+	}
+	if u.Host != "" {
+		if !self || strings.EqualFold(u.Host, b.base.Host) {
+			return ref
+		}
+		u.Scheme, u.Host, u.User = "", "", nil
+	}
+	if strings.HasPrefix(u.Path, "/") {
+		u.Path = b.prefixed(u.Path)
+	}
+	page := *b.base
+	page.Path += "/"
+	if route != "home" {
+		page.Path += route
+	}
+	return page.ResolveReference(u).String()
+}
+
+// prefixed adds the base path to a root-relative URL that lacks it
+func (b *BaseURL) prefixed(ref string) string {
+	if b.base.Path == "" || !strings.HasPrefix(ref, "/") || strings.HasPrefix(ref, "//") {
+		return ref
+	}
+	if ref == b.base.Path || strings.HasPrefix(ref, b.base.Path+"/") ||
+		strings.HasPrefix(ref, b.base.Path+"?") || strings.HasPrefix(ref, b.base.Path+"#") {
+		return ref
+	}
+	if ref == "/" {
+		return b.base.Path + "/"
+	}
+	return b.base.Path + ref
+}
@@ -0,0 +1,95 @@
+package postprocess
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// assetAttrs are the attributes that load static assets
+var assetAttrs = map[atom.Atom]string{
+	atom.Link:   "href",
+	atom.Script: "src",
+	atom.Img:    "src",
+	atom.Source: "src",
+	atom.Video:  "poster",
+}
+
+// Fingerprint points the asset URLs of a page (stylesheets, scripts, images,
+// icons) at fingerprinted names that change with the file's content, so the
+// assets can be cached forever. URLs of files that do not exist are left
+// alone.
+type Fingerprint struct {
+	resolve func(urlPath string) (string, bool)
+}
+
+// NewFingerprint creates the pass. resolve maps a root-relative asset path
+// such as "/css/site.css" to its fingerprinted URL, reporting false when
+// there is no such asset.
+func NewFingerprint(resolve func(urlPath string) (string, bool)) *Fingerprint {
+	return &Fingerprint{resolve: resolve}
+}
+
+// Name implements Processor
+func (f *Fingerprint) Name() string {
+	return "fingerprint"
+}
+
+// Process implements Processor
+func (f *Fingerprint) Process(page *Page) []string {
+	doc := parseDocument(page.HTML)
+	if doc == nil {
+		return nil
+	}
+
+	var notes []string
+	walk(doc, func(n *html.Node) {
+		key, ok := assetAttrs[n.DataAtom]
+		if !ok {
+			return
+		}
+		// Canonical and alternate links name pages, not assets
+		if n.DataAtom == atom.Link && (rel(n) == "canonical" || rel(n) == "alternate") {
+			return
+		}
+		value, ok := getAttr(n, key)
+		if !ok {
+			return
+		}
+		if fingerprinted, ok := f.rewrite(value, page.Route); ok {
+			setAttr(n, key, fingerprinted)
+			notes = append(notes, fmt.Sprintf("fingerprinted %s as %s", value, fingerprinted))
+		}
+	})
+
+	if len(notes) > 0 {
+		if rendered, err := renderDocument(doc); err == nil {
+			page.HTML = rendered
+		}
+	}
+	return notes
+}
+
+// rewrite returns the fingerprinted form of a same-site asset reference,
+// resolved against the page's route, keeping its query and fragment
+func (f *Fingerprint) rewrite(ref, route string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Opaque != "" || u.Path == "" || path.Ext(u.Path) == "" {
+		return "", false
+	}
+	p := u.Path
+	if !strings.HasPrefix(p, "/") {
+		p = path.Join("/", path.Dir(route), p)
+	}
+	fingerprinted, ok := f.resolve(p)
+	if !ok {
+		return "", false
+	}
+	u.Path = fingerprinted
+	u.RawPath = ""
+	return u.String(), true
+}
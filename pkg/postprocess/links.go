@@ -0,0 +1,220 @@
+package postprocess
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Link validation modes
+const (
+	LinkModeRewrite = "rewrite" // Point broken links at the closest existing route, drop them if none is close
+	LinkModeDrop    = "drop"    // Replace broken links with their text
+	LinkModeFlag    = "flag"    // Leave links untouched and only report them
+)
+
+// LinkValidator checks internal links against the routes that actually exist.
+// Models frequently invent navigation entries; this pass keeps them honest.
+type LinkValidator struct {
+	mode   string
+	routes func() ([]string, error)
+	base   *url.URL // Public site URL; links to it count as internal
+}
+
+// NewLinkValidator creates a link validation pass. routes returns the known
+// route names (prompt files without extension) and is called once per page so
+// newly added prompts are picked up without a restart.
+func NewLinkValidator(mode string, routes func() ([]string, error)) (*LinkValidator, error) {
+	switch mode {
+	case LinkModeRewrite, LinkModeDrop, LinkModeFlag:
+	default:
+		return nil, fmt.Errorf("unknown link validation mode %q (use rewrite, drop, or flag)", mode)
+	}
+	return &LinkValidator{mode: mode, routes: routes}, nil
+}
+
+// SetBaseURL makes absolute links to the public site URL (see ParseBaseURL)
+// count as internal, and accepts links that already carry its path
+func (v *LinkValidator) SetBaseURL(base *url.URL) {
+	v.base = base
+}
+
+// Name implements Processor
+func (v *LinkValidator) Name() string {
+	return "link-validation"
+}
+
+// Process implements Processor
+func (v *LinkValidator) Process(page *Page) []string {
+	doc := parseDocument(page.HTML)
+	if doc == nil {
+		return nil
+	}
+	routes, err := v.routes()
+	if err != nil || len(routes) == 0 {
+		return nil
+	}
+	known := make(map[string]bool, len(routes))
+	for _, r := range routes {
+		known[r] = true
+	}
+
+	var notes []string
+	var dropped []*html.Node
+	changed := false
+	walk(doc, func(n *html.Node) {
+		if n.DataAtom != atom.A {
+			return
+		}
+		href, ok := getAttr(n, "href")
+		if !ok {
+			return
+		}
+		route, u, internal := internalRoute(href, page.Route, v.base)
+		if !internal || known[route] {
+			return
+		}
+
+		switch v.mode {
+		case LinkModeFlag:
+			notes = append(notes, fmt.Sprintf("broken link %q (no prompt for route %q)", href, route))
+		case LinkModeRewrite:
+			if match := closestRoute(route, routes); match != "" {
+				prefix := ""
+				if v.base != nil && hasPathPrefix(u.Path, v.base.Path) {
+					prefix = v.base.Path
+				}
+				u.Path = prefix + "/" + match
+				if match == "home" {
+					u.Path = prefix + "/"
+				}
+				setAttr(n, "href", u.String())
+				notes = append(notes, fmt.Sprintf("rewrote broken link %q to %q", href, u.String()))
+				changed = true
+				return
+			}
+			fallthrough
+		case LinkModeDrop:
+			dropped = append(dropped, n)
+			notes = append(notes, fmt.Sprintf("dropped broken link %q (%q)", href, truncate(textContent(n), 40)))
+			changed = true
+		}
+	})
+
+	// Unwrap dropped links after walking so the traversal is not disturbed
+	for _, n := range dropped {
+		unwrap(n)
+	}
+
+	if changed {
+		if rendered, err := renderDocument(doc); err == nil {
+			page.HTML = rendered
+		}
+	}
+	return notes
+}
+
+// internalRoute resolves href to a route name if it points at a generated page
+// on this site. base, when set, is the site's public URL.
+func internalRoute(href, currentRoute string, base *url.URL) (string, *url.URL, bool) {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.HasPrefix(href, "#") {
+		return "", nil, false
+	}
+	u, err := url.Parse(href)
+	if err != nil || u.Opaque != "" {
+		return "", nil, false
+	}
+	if u.Scheme != "" || u.Host != "" {
+		onSite := base != nil && (u.Scheme == "" || u.Scheme == base.Scheme) &&
+			strings.EqualFold(u.Host, base.Host) && hasPathPrefix(u.Path, base.Path)
+		if !onSite {
+			return "", nil, false
+		}
+	}
+	p := u.Path
+	if base != nil && base.Path != "" && hasPathPrefix(p, base.Path) {
+		p = "/" + strings.TrimPrefix(p[len(base.Path):], "/")
+	}
+	if p == "" {
+		return "", nil, false
+	}
+	if !strings.HasPrefix(p, "/") {
+		p = path.Join("/", path.Dir("/"+currentRoute), p)
+	}
+	// Paths with a file extension are static assets, not prompts
+	if strings.Contains(path.Base(p), ".") {
+		return "", nil, false
+	}
+	route := strings.Trim(path.Clean(p), "/")
+	if route == "" {
+		route = "home"
+	}
+	return route, u, true
+}
+
+// hasPathPrefix reports whether p is prefix or lies below it
+func hasPathPrefix(p, prefix string) bool {
+	return prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// closestRoute returns the known route most similar to route, or "" if none is close enough
+func closestRoute(route string, routes []string) string {
+	best := ""
+	bestDist := -1
+	for _, candidate := range routes {
+		d := levenshtein(strings.ToLower(route), strings.ToLower(candidate))
+		if bestDist == -1 || d < bestDist {
+			best, bestDist = candidate, d
+		}
+	}
+	limit := len(route) / 3
+	if limit < 2 {
+		limit = 2
+	}
+	if bestDist > limit {
+		return ""
+	}
+	return best
+}
+
+// levenshtein computes the edit distance between a and b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// unwrap replaces n with its children
+func unwrap(n *html.Node) {
+	parent := n.Parent
+	if parent == nil {
+		return
+	}
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		n.RemoveChild(c)
+		parent.InsertBefore(c, n)
+		c = next
+	}
+	parent.RemoveChild(n)
+}
@@ -0,0 +1,108 @@
+package postprocess
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// document wraps body the way html.Render writes a parsed page
+func document(body string) string {
+	return "<html><head></head><body>" + body + "</body></html>"
+}
+
+func TestLinkValidator(t *testing.T) {
+	routes := func() ([]string, error) {
+		return []string{"home", "about", "contact", "blog/first-post"}, nil
+	}
+	base, err := ParseBaseURL("https://example.com/site/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		mode  string
+		base  bool // Serve the site below https://example.com/site
+		in    string
+		want  string
+		notes int
+	}{
+		{"known links", LinkModeRewrite, false,
+			`<a href="/about">A</a><a href="/">H</a><a href="/contact/">C</a><a href="first-post">F</a>`,
+			`<a href="/about">A</a><a href="/">H</a><a href="/contact/">C</a><a href="first-post">F</a>`, 0},
+		{"not pages", LinkModeRewrite, false,
+			`<a href="/css/site.css">S</a><a href="https://other.example/abut">O</a><a href="mailto:me@example.com">M</a><a href="#top">T</a><a href="javascript:void(0)">J</a><a>N</a>`,
+			`<a href="/css/site.css">S</a><a href="https://other.example/abut">O</a><a href="mailto:me@example.com">M</a><a href="#top">T</a><a href="javascript:void(0)">J</a><a>N</a>`, 0},
+		{"rewritten", LinkModeRewrite, false,
+			`<a href="/abut?ref=nav#team">A</a><a href="/hom">H</a><a href="firstpost" class="x">F</a>`,
+			`<a href="/about?ref=nav#team">A</a><a href="/">H</a><a href="/blog/first-post" class="x">F</a>`, 3},
+		{"nothing close enough", LinkModeRewrite, false,
+			`<p>See <a href="/pricing-and-plans"><b>our</b> prices</a>.</p>`,
+			`<p>See <b>our</b> prices.</p>`, 1},
+		{"dropped", LinkModeDrop, false,
+			`<nav><a href="/abut">About</a> | <a href="/about">About</a></nav>`,
+			`<nav>About | <a href="/about">About</a></nav>`, 1},
+		{"flagged", LinkModeFlag, false,
+			`<a href="/abut">About</a><a href="/zzzzzzzzzz">Z</a>`,
+			`<a href="/abut">About</a><a href="/zzzzzzzzzz">Z</a>`, 2},
+		{"below the base path", LinkModeRewrite, true,
+			`<a href="/site/abut">A</a><a href="https://example.com/site/contct">C</a><a href="/hom">H</a><a href="https://example.com/other/abut">O</a>`,
+			`<a href="/site/about">A</a><a href="https://example.com/site/contact">C</a><a href="/">H</a><a href="https://example.com/other/abut">O</a>`, 3},
+		{"site root", LinkModeRewrite, true,
+			`<a href="https://example.com/site">R</a><a href="/site/">R</a><a href="http://example.com/site/abut">A</a>`,
+			`<a href="https://example.com/site">R</a><a href="/site/">R</a><a href="http://example.com/site/abut">A</a>`, 0},
+		{"unclosed link", LinkModeDrop, false,
+			`<p><a href="/abut">About<p>Next`,
+			`<p>About</p><p>Next</p>`, 2}, // The parser reopens the link in the next paragraph
+		{"nested links", LinkModeDrop, false,
+			`<a href="/zzzzzz">Z<a href="/about">A</a></a>`,
+			`Z<a href="/about">A</a>`, 1},
+		{"unterminated attribute", LinkModeRewrite, false,
+			`<a href="/abut>About</a><p>Text</p>`,
+			`<a href="/abut>About</a><p>Text</p>`, 0},
+	} {
+		v, err := NewLinkValidator(tc.mode, routes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.base {
+			v.SetBaseURL(base)
+		}
+		page := &Page{Route: "blog/first-post", HTML: document(tc.in)}
+		notes := v.Process(page)
+		if page.HTML != document(tc.want) {
+			t.Errorf("%s:\n got %q\nwant %q", tc.name, page.HTML, document(tc.want))
+		}
+		if len(notes) != tc.notes {
+			t.Errorf("%s: %d notes %q, want %d", tc.name, len(notes), notes, tc.notes)
+		}
+	}
+}
+
+func TestLinkValidatorSkips(t *testing.T) {
+	v, err := NewLinkValidator(LinkModeDrop, func() ([]string, error) { return []string{"home"}, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	const fragment = `<p><a href="/missing">M</a></p>`
+	page := &Page{Route: "home", HTML: fragment}
+	if notes := v.Process(page); len(notes) != 0 || page.HTML != fragment {
+		t.Errorf("fragment changed (%v): %q", notes, page.HTML)
+	}
+
+	for _, routes := range []func() ([]string, error){
+		func() ([]string, error) { return nil, errors.New("no prompts directory") },
+		func() ([]string, error) { return nil, nil },
+	} {
+		v, _ := NewLinkValidator(LinkModeDrop, routes)
+		page := &Page{Route: "home", HTML: document(`<a href="/missing">M</a>`)}
+		if notes := v.Process(page); len(notes) != 0 || !strings.Contains(page.HTML, `href="/missing"`) {
+			t.Errorf("links dropped without routes (%v): %q", notes, page.HTML)
+		}
+	}
+
+	if _, err := NewLinkValidator("fix", nil); err == nil || !strings.Contains(err.Error(), `unknown link validation mode "fix"`) {
+		t.Errorf("unknown mode: %v", err)
+	}
+}
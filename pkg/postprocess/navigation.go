@@ -0,0 +1,105 @@
+package postprocess
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// NavItem is one entry of the site navigation
+type NavItem struct {
+	Title    string    `json:"title"`
+	Path     string    `json:"path"`
+	Children []NavItem `json:"children,omitempty"`
+}
+
+// Navigation replaces the model's menu with one rendered from the site's
+// navigation model, so every page shows the same links
+type Navigation struct {
+	items func() ([]NavItem, error)
+}
+
+// NewNavigation creates the pass; items is called for every page so prompt
+// changes show up without a restart
+func NewNavigation(items func() ([]NavItem, error)) *Navigation {
+	return &Navigation{items: items}
+}
+
+// Name implements Processor
+func (n *Navigation) Name() string {
+	return "Navigation"
+}
+
+// Process implements Processor. The first <nav> element is replaced; pages
+// without one get the menu at the start of <body>.
+func (n *Navigation) Process(page *Page) []string {
+	doc := parseDocument(page.HTML)
+	if doc == nil {
+		return nil
+	}
+	items, err := n.items()
+	if err != nil || len(items) == 0 {
+		return nil
+	}
+	body := findElement(doc, atom.Body)
+	if body == nil {
+		return nil
+	}
+	nodes, err := html.ParseFragment(strings.NewReader(renderNavigation(items, "/"+page.Route, page.Lang)), body)
+	if err != nil || len(nodes) == 0 {
+		return nil
+	}
+	menu := nodes[0]
+
+	var note string
+	if existing := findElement(body, atom.Nav); existing != nil {
+		existing.Parent.InsertBefore(menu, existing)
+		existing.Parent.RemoveChild(existing)
+		note = "replaced the generated <nav> with the site navigation"
+	} else {
+		body.InsertBefore(menu, body.FirstChild)
+		note = "added the site navigation"
+	}
+	if out, err := renderDocument(doc); err == nil {
+		page.HTML = out
+		return []string{note}
+	}
+	return nil
+}
+
+// renderNavigation renders items as nested lists, marking the current page.
+// Links keep the page's ?lang= so visitors stay in their language.
+func renderNavigation(items []NavItem, current, lang string) string {
+	if current == "/home" {
+		current = "/"
+	}
+	var b strings.Builder
+	b.WriteString(`<nav class="museweb-nav" aria-label="Main">`)
+	writeNavList(&b, items, current, lang)
+	b.WriteString(`</nav>`)
+	return b.String()
+}
+
+func writeNavList(b *strings.Builder, items []NavItem, current, lang string) {
+	b.WriteString("<ul>")
+	for _, item := range items {
+		b.WriteString("<li>")
+		attr := ""
+		if item.Path == current {
+			attr = ` aria-current="page"`
+		}
+		href := item.Path
+		if lang != "" {
+			href += "?lang=" + url.QueryEscape(lang)
+		}
+		fmt.Fprintf(b, `<a href="%s"%s>%s</a>`, html.EscapeString(href), attr, html.EscapeString(item.Title))
+		if len(item.Children) > 0 {
+			writeNavList(b, item.Children, current, lang)
+		}
+		b.WriteString("</li>")
+	}
+	b.WriteString("</ul>")
+}
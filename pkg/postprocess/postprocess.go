@@ -0,0 +1,141 @@
+// Package postprocess provides optional passes that run over a complete
+// generated page before it is sent to the browser. Because the passes need the
+// whole document, enabling any of them buffers the model output instead of
+// streaming it token-by-token.
+package postprocess
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Page is a fully generated document passing through the pipeline
+type Page struct {
+	Route string // Route the page was generated for, e.g. "about"
+	Lang  string // Requested language, if any
+	HTML  string // Document markup; processors rewrite it in place
+
+	// ContentType is the media type of non-HTML markup, such as
+	// image/svg+xml; only the sanitizer handles it (see IsXML)
+	ContentType string
+
+	// NoIndex is set when the prompt's front matter marks the page noindex or draft
+	NoIndex bool
+
+	// Context is the request context; passes that call a model stop when it is
+	// cancelled. Nil means context.Background().
+	Context context.Context
+}
+
+// context returns the page's request context
+func (p *Page) context() context.Context {
+	if p.Context == nil {
+		return context.Background()
+	}
+	return p.Context
+}
+
+// Processor is a single post-processing pass
+type Processor interface {
+	// Name identifies the pass in logs
+	Name() string
+	// Process rewrites page.HTML and returns a note for every correction made
+	Process(page *Page) []string
+}
+
+// Pipeline runs processors in order
+type Pipeline []Processor
+
+// Run applies every processor to page and logs what each one corrected
+func (p Pipeline) Run(page *Page, debug bool) {
+	for _, proc := range p {
+		notes := proc.Process(page)
+		if len(notes) == 0 {
+			continue
+		}
+		log.Printf("🛠️  %s corrected %d issue(s) on /%s", proc.Name(), len(notes), page.Route)
+		if debug {
+			for _, note := range notes {
+				log.Printf("🛠️    - %s", note)
+			}
+		}
+	}
+}
+
+// isDocument reports whether s looks like a full HTML document worth parsing
+func isDocument(s string) bool {
+	lower := strings.ToLower(s)
+	return strings.Contains(lower, "<html") || strings.Contains(lower, "<!doctype")
+}
+
+// parseDocument parses a generated page, returning nil for non-HTML output
+func parseDocument(s string) *html.Node {
+	if !isDocument(s) {
+		return nil
+	}
+	doc, err := html.Parse(strings.NewReader(s))
+	if err != nil {
+		return nil
+	}
+	return doc
+}
+
+// renderDocument serializes a parsed document back to markup
+func renderDocument(doc *html.Node) (string, error) {
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// walk calls fn for every element node below n in document order
+func walk(n *html.Node, fn func(*html.Node)) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode {
+			fn(c)
+		}
+		walk(c, fn)
+	}
+}
+
+// getAttr returns the value of an attribute and whether it is present
+func getAttr(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && strings.EqualFold(a.Key, key) {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+// setAttr sets or replaces an attribute value
+func setAttr(n *html.Node, key, value string) {
+	for i, a := range n.Attr {
+		if a.Namespace == "" && strings.EqualFold(a.Key, key) {
+			n.Attr[i].Val = value
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: value})
+}
+
+// textContent returns the concatenated text below n
+func textContent(n *html.Node) string {
+	var sb strings.Builder
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
+		}
+	}
+	collect(n)
+	return strings.TrimSpace(sb.String())
+}
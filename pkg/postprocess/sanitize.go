@@ -0,0 +1,269 @@
+package postprocess
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// DefaultURLSchemes are the URL schemes links and sources may use when none
+// are configured; relative URLs are always allowed
+var DefaultURLSchemes = []string{"http", "https", "mailto", "tel"}
+
+// SanitizeOptions configure the sanitizer
+type SanitizeOptions struct {
+	// ScriptSources are URL prefixes of external scripts to keep, such as
+	// "/js/" or "https://cdn.example.com/"; every other script is removed
+	ScriptSources []string
+	// FrameSources are URL prefixes of iframes to keep, such as
+	// "https://www.youtube-nocookie.com/embed/"; every other frame is removed
+	FrameSources []string
+	// URLSchemes may be used by links, sources, and form actions
+	// (DefaultURLSchemes when empty); images may also use data:image/ URLs
+	URLSchemes []string
+}
+
+// Sanitizer removes what could run code in a visitor's browser from
+// generated pages, since model output is untrusted. Only known HTML, SVG, and
+// MathML elements and attributes are kept: scripts, frames, plugins, <base>,
+// SVG animations, meta refreshes, inline event handlers, and URLs outside
+// URLSchemes are removed, and unknown elements are replaced by their content.
+// JSON data blocks (such as JSON-LD) are kept. SVG, XHTML, and other XML
+// responses are sanitized too (see Page.ContentType).
+type Sanitizer struct {
+	opts    SanitizeOptions
+	schemes map[string]bool
+}
+
+// NewSanitizer creates the sanitizer
+func NewSanitizer(opts SanitizeOptions) *Sanitizer {
+	if len(opts.URLSchemes) == 0 {
+		opts.URLSchemes = DefaultURLSchemes
+	}
+	s := &Sanitizer{opts: opts, schemes: map[string]bool{}}
+	for _, scheme := range opts.URLSchemes {
+		s.schemes[strings.ToLower(strings.TrimSuffix(scheme, ":"))] = true
+	}
+	return s
+}
+
+// Name implements Processor
+func (s *Sanitizer) Name() string {
+	return "sanitize"
+}
+
+// Process implements Processor. Fragments are sanitized as the body of a
+// page; XML content types are sanitized as XML.
+func (s *Sanitizer) Process(page *Page) []string {
+	if IsXML(page.ContentType) {
+		return s.processXML(page)
+	}
+	doc := parseDocument(page.HTML)
+	root := doc
+	if doc == nil {
+		root = &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+		nodes, err := html.ParseFragment(strings.NewReader(page.HTML), root)
+		if err != nil {
+			return nil
+		}
+		for _, n := range nodes {
+			root.AppendChild(n)
+		}
+	}
+
+	notes := s.clean(root)
+	if len(notes) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	for c := root.FirstChild; c != nil; c = c.NextSibling {
+		if err := html.Render(&buf, c); err != nil {
+			return nil
+		}
+	}
+	page.HTML = buf.String()
+	return notes
+}
+
+// What happens to an element
+type verdict int
+
+const (
+	keepElement   verdict = iota
+	dropElement           // Removed with everything in it
+	unwrapElement         // Replaced by its content
+)
+
+// clean removes the unsafe elements and attributes below root
+func (s *Sanitizer) clean(root *html.Node) []string {
+	var notes []string
+	var doomed, unwrapped []*html.Node
+	walk(root, func(n *html.Node) {
+		switch v, reason := s.element(n); v {
+		case dropElement:
+			doomed = append(doomed, n)
+			notes = append(notes, "removed "+reason)
+			return
+		case unwrapElement:
+			unwrapped = append(unwrapped, n)
+			notes = append(notes, "unwrapped "+reason)
+			return
+		}
+		kept := n.Attr[:0]
+		for _, a := range n.Attr {
+			key := strings.ToLower(a.Key)
+			if a.Namespace != "" {
+				key = a.Namespace + ":" + key
+			}
+			if reason := s.unsafeAttr(elementName(n), key, a.Val); reason != "" {
+				notes = append(notes, fmt.Sprintf("removed %s from <%s>", reason, n.Data))
+				continue
+			}
+			kept = append(kept, a)
+		}
+		n.Attr = kept
+	})
+	for _, n := range doomed {
+		if n.Parent != nil {
+			n.Parent.RemoveChild(n)
+		}
+	}
+	for _, n := range unwrapped {
+		unwrap(n)
+	}
+	return notes
+}
+
+// elementName is the lowercase name of n, prefixed by its namespace for SVG
+// and MathML ("svg:path")
+func elementName(n *html.Node) string {
+	if n.Namespace != "" {
+		return n.Namespace + ":" + strings.ToLower(n.Data)
+	}
+	return strings.ToLower(n.Data)
+}
+
+// element decides what happens to n, and describes it
+func (s *Sanitizer) element(n *html.Node) (verdict, string) {
+	name := elementName(n)
+	tag := "<" + n.Data + ">"
+	switch name {
+	case "script":
+		if t, _ := getAttr(n, "type"); isDataScript(t) {
+			return keepElement, ""
+		}
+		if src, ok := getAttr(n, "src"); ok && hasPrefix(src, s.opts.ScriptSources) && s.safeURL(src, false) {
+			return keepElement, ""
+		}
+	case "iframe":
+		if src, ok := getAttr(n, "src"); ok && hasPrefix(src, s.opts.FrameSources) && s.safeURL(src, false) {
+			return keepElement, ""
+		}
+	case "meta":
+		if equiv, _ := getAttr(n, "http-equiv"); strings.EqualFold(strings.TrimSpace(equiv), "refresh") {
+			return dropElement, "<meta http-equiv=refresh>"
+		}
+	case "style", "svg:style":
+		if dangerousCSS(textContent(n)) {
+			return dropElement, "<style> with script URLs"
+		}
+	}
+	switch {
+	case allowedElements[name]:
+		return keepElement, ""
+	case droppedElements[name]:
+		return dropElement, tag
+	}
+	return unwrapElement, tag
+}
+
+// unsafeAttr describes why attribute key=val of the element must go, or
+// returns ""
+func (s *Sanitizer) unsafeAttr(element, key, val string) string {
+	switch {
+	case strings.HasPrefix(key, "on"):
+		return key + " handler"
+	case !allowedAttr(element, key):
+		return key
+	case key == "style" && dangerousCSS(val):
+		return "style with script URLs"
+	case key == "srcset":
+		for _, candidate := range strings.Split(val, ",") {
+			if fields := strings.Fields(candidate); len(fields) > 0 && !s.safeURL(fields[0], true) {
+				return "srcset with an unsafe URL"
+			}
+		}
+	case urlAttributes[key]:
+		image := imageElements[element] && key != "href" || element == "video" && key == "poster"
+		if !s.safeURL(val, image) {
+			return fmt.Sprintf("unsafe %s URL", key)
+		}
+	}
+	return ""
+}
+
+// allowedAttr reports whether key may appear on the element
+func allowedAttr(element, key string) bool {
+	if allowedAttributes[key] || elementAttributes[element][key] {
+		return true
+	}
+	for _, prefix := range []string{"data-", "aria-"} {
+		if strings.HasPrefix(key, prefix) && len(key) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// safeURL reports whether u is relative or uses an allowed scheme; images
+// may use data:image/ URLs
+func (s *Sanitizer) safeURL(u string, image bool) bool {
+	// Browsers ignore whitespace and control characters inside the scheme
+	compact := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, u)
+	colon := strings.IndexByte(compact, ':')
+	if colon < 0 || strings.ContainsAny(compact[:colon], "/?#") {
+		return true // Relative
+	}
+	scheme := strings.ToLower(compact[:colon])
+	if scheme == "data" {
+		return image && strings.HasPrefix(strings.ToLower(compact), "data:image/")
+	}
+	return s.schemes[scheme]
+}
+
+// isDataScript reports whether a script type is data rather than code, such
+// as JSON-LD
+func isDataScript(t string) bool {
+	t = strings.ToLower(strings.TrimSpace(t))
+	return t == "application/ld+json" || t == "application/json"
+}
+
+// dangerousCSS reports whether CSS can run script through URLs or IE's
+// expression()
+func dangerousCSS(css string) bool {
+	lower := strings.ToLower(strings.Join(strings.Fields(css), ""))
+	return strings.Contains(lower, "javascript:") || strings.Contains(lower, "vbscript:") || strings.Contains(lower, "expression(")
+}
+
+// hasPrefix reports whether u starts with one of the prefixes
+func hasPrefix(u string, prefixes []string) bool {
+	u = strings.TrimSpace(u)
+	for _, p := range prefixes {
+		// "/js/" is on this site; "//host/js/" is not
+		if p == "" || strings.HasPrefix(u, "//") && !strings.HasPrefix(p, "//") {
+			continue
+		}
+		if strings.HasPrefix(u, p) {
+			return true
+		}
+	}
+	return false
+}
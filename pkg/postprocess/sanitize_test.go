@@ -0,0 +1,137 @@
+package postprocess
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizer(t *testing.T) {
+	s := NewSanitizer(SanitizeOptions{ScriptSources: []string{"/js/"}, FrameSources: []string{"https://www.youtube-nocookie.com/embed/"}})
+
+	for _, tc := range []struct {
+		name string
+		in   string
+		keep []string // Must be in the output
+		gone []string // Must not be
+	}{
+		{"script", `<p>Hi</p><script>alert(1)</script>`, []string{"<p>Hi</p>"}, []string{"script", "alert"}},
+		{"allowed script", `<script src="/js/app.js"></script>`, []string{`<script src="/js/app.js">`}, nil},
+		{"script from elsewhere", `<script src="https://evil.example/x.js"></script>`, nil, []string{"script"}},
+		{"protocol-relative script", `<script src="//evil.example/js/x.js"></script>`, nil, []string{"script"}},
+		{"json-ld", `<script type="application/ld+json">{"@type":"Thing"}</script>`, []string{"ld+json", "Thing"}, nil},
+		{"event handler", `<img src="a.png" onerror="alert(1)">`, []string{`<img src="a.png"/>`}, []string{"onerror", "alert"}},
+		{"javascript link", `<a href="javascript:alert(1)">x</a>`, []string{"<a>x</a>"}, []string{"javascript"}},
+		{"obfuscated scheme", "<a href=\" java\tscript:alert(1)\">x</a>", nil, []string{"script:"}},
+		{"entity scheme", `<a href="&#106;avascript:alert(1)">x</a>`, nil, []string{"avascript"}},
+		{"vbscript", `<a href="vbscript:msgbox(1)">x</a>`, nil, []string{"vbscript"}},
+		{"data link", `<a href="data:text/html,<script>alert(1)</script>">x</a>`, nil, []string{"data:"}},
+		{"data image", `<img src="data:image/png;base64,AAAA">`, []string{"data:image/png"}, nil},
+		{"data svg in use", `<svg><use href="data:image/svg+xml,&lt;svg onload=alert(1)&gt;"></use></svg>`, nil, []string{"data:"}},
+		{"relative and allowed links", `<a href="/about">a</a><a href="mailto:me@example.com">m</a>`, []string{`href="/about"`, `href="mailto:me@example.com"`}, nil},
+		{"form action", `<form action="javascript:alert(1)"><button formaction="javascript:alert(2)">Go</button></form>`, []string{"<button>Go</button>"}, []string{"javascript"}},
+		{"srcset", `<img srcset="javascript:alert(1) 1x">`, nil, []string{"javascript"}},
+		{"style attribute", `<p style="background:url(javascript:alert(1))">x</p>`, []string{"<p>x</p>"}, []string{"javascript"}},
+		{"expression", `<p style="width: expression(alert(1))">x</p>`, nil, []string{"expression"}},
+		{"style element", `<style>body{background:url("javascript:alert(1)")}</style><p>x</p>`, []string{"<p>x</p>"}, []string{"javascript"}},
+		{"plain style", `<style>p{color:red}</style>`, []string{"p{color:red}"}, nil},
+		{"iframe", `<iframe src="https://evil.example/"></iframe>`, nil, []string{"iframe"}},
+		{"allowed iframe", `<iframe src="https://www.youtube-nocookie.com/embed/xyz" allowfullscreen></iframe>`, []string{"youtube-nocookie", "allowfullscreen"}, nil},
+		{"srcdoc", `<iframe src="https://www.youtube-nocookie.com/embed/xyz" srcdoc="<script>alert(1)</script>"></iframe>`, nil, []string{"srcdoc", "alert"}},
+		{"plugins", `<object data="x.swf"></object><embed src="x.swf"><applet code="X"></applet>`, nil, []string{"object", "embed", "applet"}},
+		{"base", `<base href="https://evil.example/"><a href="/x">x</a>`, []string{`href="/x"`}, []string{"base", "evil"}},
+		{"meta refresh", `<meta http-equiv="refresh" content="0;url=javascript:alert(1)">`, nil, []string{"refresh", "javascript"}},
+		{"svg onload", `<svg onload="alert(1)"><circle r="4" fill="red"></circle></svg>`, []string{`<circle r="4" fill="red">`}, []string{"onload", "alert"}},
+		{"svg animate href", `<svg><a><animate attributeName="href" values="javascript:alert(1)"></animate><text x="20" y="20">click</text></a></svg>`, []string{"click"}, []string{"animate", "javascript"}},
+		{"svg set", `<svg><a><set attributeName="href" to="javascript:alert(1)"></set></a></svg>`, nil, []string{"<set", "javascript"}},
+		{"svg animation attributes", `<svg><a from="javascript:alert(1)" to="javascript:alert(2)" values="javascript:alert(3)">x</a></svg>`, []string{">x</a>"}, []string{"javascript"}},
+		{"svg xlink", `<svg><a xlink:href="javascript:alert(1)">x</a></svg>`, nil, []string{"javascript"}},
+		{"svg script", `<svg><script>alert(1)</script></svg>`, nil, []string{"script", "alert"}},
+		{"foreign object", `<svg><foreignObject><img src="x" onerror="alert(1)"></foreignObject></svg>`, nil, []string{"foreignObject", "onerror", "alert"}},
+		{"svg filter", `<svg><filter id="f"><feColorMatrix type="saturate" values="0.5"></feColorMatrix></filter></svg>`, []string{`values="0.5"`}, nil},
+		{"mathml href", `<math><mi href="javascript:alert(1)">x</mi></math>`, []string{"<mi>x</mi>"}, []string{"javascript"}},
+		{"mathml xlink", `<math><maction actiontype="statusline" xlink:href="javascript:alert(1)"><mi>x</mi></maction></math>`, nil, []string{"maction", "javascript"}},
+		{"unknown element", `<blink class="x">Hi</blink>`, []string{"Hi"}, []string{"blink"}},
+		{"unknown attribute", `<p formula="x" data-id="7" aria-label="Hi">x</p>`, []string{`data-id="7"`, `aria-label="Hi"`}, []string{"formula"}},
+		{"template", `<template><img src="x" onerror="alert(1)"></template>`, nil, []string{"template", "onerror"}},
+		{"noscript", `<noscript><p title="</noscript><img src=x onerror=alert(1)>"></p></noscript>`, nil, []string{"onerror"}},
+	} {
+		page := &Page{HTML: tc.in}
+		s.Process(page)
+		for _, want := range tc.keep {
+			if !strings.Contains(page.HTML, want) {
+				t.Errorf("%s: %q lost %q", tc.name, page.HTML, want)
+			}
+		}
+		for _, bad := range tc.gone {
+			if strings.Contains(page.HTML, bad) {
+				t.Errorf("%s: %q still has %q", tc.name, page.HTML, bad)
+			}
+		}
+	}
+}
+
+func TestSanitizerDocument(t *testing.T) {
+	const doc = `<!DOCTYPE html><html lang="en"><head><title>Hi</title><meta name="description" content="About us"></head>` +
+		`<body><h1 class="big">Hello</h1><p>Text</p></body></html>`
+	page := &Page{HTML: doc}
+	if notes := NewSanitizer(SanitizeOptions{}).Process(page); len(notes) != 0 || page.HTML != doc {
+		t.Errorf("safe page changed (%v): %q", notes, page.HTML)
+	}
+}
+
+func TestSanitizerXML(t *testing.T) {
+	s := NewSanitizer(SanitizeOptions{})
+
+	for _, tc := range []struct {
+		name, contentType, in string
+		want                  string // The whole output
+	}{
+		{"safe svg", "image/svg+xml",
+			`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><circle r="4"/></svg>`,
+			`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><circle r="4"/></svg>`},
+		{"svg script", "image/svg+xml",
+			`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script><rect width="1" height="1" onclick="alert(2)"/></svg>`,
+			`<svg xmlns="http://www.w3.org/2000/svg"><rect width="1" height="1"/></svg>`},
+		{"svg animate", "image/svg+xml",
+			`<svg xmlns="http://www.w3.org/2000/svg"><a><animate attributeName="href" values="javascript:alert(1)"/><text>x</text></a></svg>`,
+			`<svg xmlns="http://www.w3.org/2000/svg"><a><text>x</text></a></svg>`},
+		{"svg xlink", "image/svg+xml",
+			`<svg xmlns="http://www.w3.org/2000/svg" xmlns:l="http://www.w3.org/1999/xlink"><a l:href="javascript:alert(1)">x</a></svg>`,
+			`<svg xmlns="http://www.w3.org/2000/svg" xmlns:l="http://www.w3.org/1999/xlink"><a>x</a></svg>`},
+		{"unprefixed svg", "image/svg+xml",
+			`<svg><foreignObject><p>x</p></foreignObject><g/></svg>`,
+			`<svg><g/></svg>`},
+		{"xhtml", "application/xhtml+xml",
+			`<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Hi</p><script>alert(1)</script><a href="javascript:x">a</a></body></html>`,
+			`<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Hi</p><a>a</a></body></html>`},
+		{"xhtml in xml", "text/xml",
+			`<feed><x:script xmlns:x="http://www.w3.org/1999/xhtml">alert(1)</x:script><title>News</title></feed>`,
+			`<feed><title>News</title></feed>`},
+		{"feed", "application/atom+xml",
+			`<feed xmlns="http://www.w3.org/2005/Atom"><link href="https://example.com/"/><entry onclick="x"><title>A &amp; B</title></entry></feed>`,
+			`<feed xmlns="http://www.w3.org/2005/Atom"><link href="https://example.com/"/><entry><title>A &amp; B</title></entry></feed>`},
+		{"data link", "application/xml",
+			`<urlset><link href="javascript:alert(1)"/></urlset>`,
+			`<urlset><link/></urlset>`},
+		{"xslt", "application/xml",
+			`<?xml-stylesheet type="text/xsl" href="/evil.xsl"?><urlset/>`,
+			`<urlset/>`},
+		{"dtd", "image/svg+xml",
+			`<!DOCTYPE svg [<!ENTITY w "World">]><svg xmlns="http://www.w3.org/2000/svg"><text>Hello</text></svg>`,
+			`<svg xmlns="http://www.w3.org/2000/svg"><text>Hello</text></svg>`},
+		{"dtd entities", "image/svg+xml",
+			`<!DOCTYPE svg [<!ENTITY x "&#60;script&#62;alert(1)&#60;/script&#62;">]><svg xmlns="http://www.w3.org/2000/svg">&x;</svg>`,
+			``},
+		{"style", "image/svg+xml",
+			`<svg xmlns="http://www.w3.org/2000/svg"><style>rect{fill:url(javascript:alert(1))}</style><style>rect{fill:red}</style></svg>`,
+			`<svg xmlns="http://www.w3.org/2000/svg"><style>rect{fill:red}</style></svg>`},
+		{"mismatched", "image/svg+xml", `<svg><script>alert(1)</svg>`, ``},
+		{"unclosed", "image/svg+xml", `<svg><g>`, ``},
+	} {
+		page := &Page{HTML: tc.in, ContentType: tc.contentType + "; charset=utf-8"}
+		s.Process(page)
+		if page.HTML != tc.want {
+			t.Errorf("%s:\n got %q\nwant %q", tc.name, page.HTML, tc.want)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package postprocess
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+)
+
+// Namespaces of the markup browsers run; elements in other namespaces are
+// data (RSS, sitemaps, ...) and kept as they are
+var activeNamespaces = map[string]string{
+	"http://www.w3.org/1999/xhtml":         "",
+	"http://www.w3.org/2000/svg":           "svg:",
+	"http://www.w3.org/1998/Math/MathML":   "math:",
+	"http://www.w3.org/1999/xlink":         "xlink:",
+	"http://www.w3.org/XML/1998/namespace": "xml:",
+}
+
+// IsXML reports whether contentType is XML markup a browser may render as a
+// document, such as image/svg+xml, application/xhtml+xml, or text/xml
+func IsXML(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "text/xml" || mediaType == "application/xml" || strings.HasSuffix(mediaType, "+xml")
+}
+
+// processXML sanitizes XML markup like HTML: XHTML, SVG, and MathML elements
+// and attributes go through the same allowlists, event handlers and unsafe
+// URLs are removed everywhere, and so are DTDs (whose entities could spell
+// elements) and XSLT stylesheets. Markup that isn't well-formed is dropped.
+func (s *Sanitizer) processXML(page *Page) []string {
+	out, notes, err := s.cleanXML(page.HTML, xmlDefaultNamespace(page.ContentType))
+	if err != nil {
+		page.HTML = ""
+		return []string{fmt.Sprintf("removed malformed XML (%v)", err)}
+	}
+	if len(notes) > 0 {
+		page.HTML = out
+	}
+	return notes
+}
+
+// xmlDefaultNamespace is the namespace browsers assume for unqualified
+// elements of contentType
+func xmlDefaultNamespace(contentType string) string {
+	switch mediaType, _, _ := mime.ParseMediaType(contentType); mediaType {
+	case "image/svg+xml":
+		return "http://www.w3.org/2000/svg"
+	case "application/xhtml+xml":
+		return "http://www.w3.org/1999/xhtml"
+	}
+	return ""
+}
+
+// xmlScope is an open element: its namespace declarations and what happens
+// to it
+type xmlScope struct {
+	name     xml.Name
+	prefixes map[string]string // Prefix ("" for the default) -> namespace
+	verdict  verdict
+	style    int // Where a kept <style> starts in the output, or -1
+}
+
+// cleanXML rewrites markup, copying what is kept byte for byte
+func (s *Sanitizer) cleanXML(markup, defaultNS string) (string, []string, error) {
+	d := xml.NewDecoder(strings.NewReader(markup))
+	d.Entity = xml.HTMLEntity
+	var out strings.Builder
+	var notes []string
+	stack := []xmlScope{{prefixes: map[string]string{"": defaultNS, "xml": "http://www.w3.org/XML/1998/namespace"}, style: -1}}
+	dropping := 0 // Depth inside a dropped element
+	styleText := ""
+	prev := int64(0)
+	for {
+		tok, err := d.RawToken()
+		if errors.Is(err, io.EOF) && len(stack) == 1 {
+			break
+		} else if errors.Is(err, io.EOF) {
+			return "", nil, fmt.Errorf("unclosed <%s>", qualified(stack[len(stack)-1].name))
+		} else if err != nil {
+			return "", nil, err
+		}
+		raw := markup[prev:d.InputOffset()]
+		prev = d.InputOffset()
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			scope := xmlScope{name: t.Name, prefixes: map[string]string{}, style: -1}
+			for _, a := range t.Attr {
+				if a.Name.Space == "xmlns" {
+					scope.prefixes[a.Name.Local] = a.Value
+				} else if a.Name.Space == "" && a.Name.Local == "xmlns" {
+					scope.prefixes[""] = a.Value
+				}
+			}
+			stack = append(stack, scope)
+			top := &stack[len(stack)-1]
+			if dropping > 0 {
+				dropping++
+				top.verdict = dropElement
+				continue
+			}
+			name, active := xmlName(stack, t.Name, true)
+			if !active {
+				// Data elements keep their content, but no handlers or links
+				// a browser could follow
+				rebuilt, removed := s.xmlAttrs(stack, "", t, raw, false)
+				notes = append(notes, removed...)
+				out.WriteString(rebuilt)
+				continue
+			}
+			v, reason := s.xmlElement(name, t)
+			top.verdict = v
+			switch v {
+			case dropElement:
+				dropping = 1
+				notes = append(notes, "removed "+reason)
+				continue
+			case unwrapElement:
+				notes = append(notes, "unwrapped "+reason)
+				continue
+			}
+			if name == "style" || name == "svg:style" {
+				top.style, styleText = out.Len(), ""
+			}
+			rebuilt, removed := s.xmlAttrs(stack, name, t, raw, true)
+			notes = append(notes, removed...)
+			out.WriteString(rebuilt)
+
+		case xml.EndElement:
+			top := stack[len(stack)-1]
+			if len(stack) == 1 || top.name != t.Name {
+				return "", nil, fmt.Errorf("unexpected </%s>", qualified(t.Name))
+			}
+			stack = stack[:len(stack)-1]
+			if dropping > 0 {
+				dropping--
+				continue
+			}
+			if top.verdict == unwrapElement {
+				continue
+			}
+			out.WriteString(raw)
+			if top.style >= 0 && dangerousCSS(styleText) {
+				kept := out.String()[:top.style]
+				out.Reset()
+				out.WriteString(kept)
+				notes = append(notes, "removed <style> with script URLs")
+			}
+
+		case xml.CharData:
+			if dropping > 0 {
+				continue
+			}
+			if stack[len(stack)-1].style >= 0 {
+				styleText += string(t)
+			}
+			out.WriteString(raw)
+
+		case xml.ProcInst:
+			if dropping > 0 {
+				continue
+			}
+			if t.Target == "xml-stylesheet" && !strings.Contains(strings.ToLower(string(t.Inst)), "text/css") {
+				notes = append(notes, "removed an XSLT stylesheet")
+				continue
+			}
+			out.WriteString(raw)
+
+		case xml.Directive:
+			if dropping > 0 {
+				continue
+			}
+			if strings.Contains(string(t), "[") {
+				notes = append(notes, "removed a DTD")
+				continue
+			}
+			out.WriteString(raw)
+
+		default: // Comments
+			if dropping == 0 {
+				out.WriteString(raw)
+			}
+		}
+	}
+	return out.String(), notes, nil
+}
+
+// xmlName resolves name against the namespaces in scope. For names in a
+// namespace browsers run, it returns the name the allowlists use ("svg:path",
+// "xlink:href") and true. Unprefixed attributes are in no namespace.
+func xmlName(stack []xmlScope, name xml.Name, element bool) (string, bool) {
+	space := ""
+	if name.Space != "" || element {
+		space = "\x00" // Undeclared prefix
+		for i := len(stack) - 1; i >= 0; i-- {
+			if ns, ok := stack[i].prefixes[name.Space]; ok {
+				space = ns
+				break
+			}
+		}
+	}
+	local := strings.ToLower(name.Local)
+	if !element && space == "" {
+		return local, true
+	}
+	prefix, ok := activeNamespaces[space]
+	return prefix + local, ok
+}
+
+// xmlElement decides what happens to the active element name
+func (s *Sanitizer) xmlElement(name string, t xml.StartElement) (verdict, string) {
+	tag := "<" + name + ">"
+	attr := func(key string) (string, bool) {
+		for _, a := range t.Attr {
+			if a.Name.Space == "" && strings.EqualFold(a.Name.Local, key) {
+				return a.Value, true
+			}
+		}
+		return "", false
+	}
+	switch name {
+	case "script":
+		if typ, _ := attr("type"); isDataScript(typ) {
+			return keepElement, ""
+		}
+		if src, ok := attr("src"); ok && hasPrefix(src, s.opts.ScriptSources) && s.safeURL(src, false) {
+			return keepElement, ""
+		}
+	case "iframe":
+		if src, ok := attr("src"); ok && hasPrefix(src, s.opts.FrameSources) && s.safeURL(src, false) {
+			return keepElement, ""
+		}
+	case "meta":
+		if equiv, _ := attr("http-equiv"); strings.EqualFold(strings.TrimSpace(equiv), "refresh") {
+			return dropElement, "<meta http-equiv=refresh>"
+		}
+	}
+	switch {
+	case allowedElements[name]:
+		return keepElement, ""
+	case droppedElements[name]:
+		return dropElement, tag
+	}
+	return unwrapElement, tag
+}
+
+// xmlAttrs returns the start tag of t without its unsafe attributes: raw
+// when all are kept, rebuilt otherwise. Attributes of data elements
+// (active false) only lose event handlers and unsafe links.
+func (s *Sanitizer) xmlAttrs(stack []xmlScope, element string, t xml.StartElement, raw string, active bool) (string, []string) {
+	var notes []string
+	kept := t.Attr[:0:0]
+	for _, a := range t.Attr {
+		if a.Name.Space == "xmlns" || a.Name.Space == "" && a.Name.Local == "xmlns" {
+			kept = append(kept, a)
+			continue
+		}
+		key, known := xmlName(stack, a.Name, false)
+		reason := ""
+		switch {
+		case active:
+			if !known {
+				reason = a.Name.Space + ":" + a.Name.Local
+			} else {
+				reason = s.unsafeAttr(element, key, a.Value)
+			}
+		case strings.HasPrefix(strings.ToLower(a.Name.Local), "on"):
+			reason = a.Name.Local + " handler"
+		case known && (urlAttributes[key] || key == "xml:base") && !s.safeURL(a.Value, false):
+			reason = fmt.Sprintf("unsafe %s URL", key)
+		}
+		if reason != "" {
+			notes = append(notes, fmt.Sprintf("removed %s from <%s>", reason, t.Name.Local))
+			continue
+		}
+		kept = append(kept, a)
+	}
+	if len(notes) == 0 {
+		return raw, nil
+	}
+
+	var b strings.Builder
+	b.WriteString("<" + qualified(t.Name))
+	for _, a := range kept {
+		b.WriteString(" " + qualified(a.Name) + `="`)
+		xml.EscapeText(&b, []byte(a.Value))
+		b.WriteString(`"`)
+	}
+	if strings.HasSuffix(strings.TrimSpace(raw), "/>") {
+		b.WriteString("/>")
+	} else {
+		b.WriteString(">")
+	}
+	return b.String(), notes
+}
+
+// qualified writes name as it appeared: prefix:local
+func qualified(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
@@ -0,0 +1,273 @@
+package postprocess
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// SEOOptions configures the SEO metadata pass
+type SEOOptions struct {
+	SiteName           string   // Appended to titles and used for og:site_name
+	DefaultDescription string   // Used when a page has no usable description
+	CanonicalBaseURL   string   // e.g. "https://example.com"; canonical links are omitted when empty
+	NoIndex            []string // Route glob patterns that must not be indexed
+}
+
+// SEO enforces consistent titles, descriptions, canonical links, and robots
+// meta on generated pages regardless of what the model produced.
+type SEO struct {
+	opts SEOOptions
+}
+
+// NewSEO creates the SEO metadata pass
+func NewSEO(opts SEOOptions) *SEO {
+	opts.CanonicalBaseURL = strings.TrimRight(opts.CanonicalBaseURL, "/")
+	return &SEO{opts: opts}
+}
+
+// Name implements Processor
+func (s *SEO) Name() string {
+	return "seo"
+}
+
+// NoIndex reports whether route matches one of the configured noindex patterns
+func (s *SEO) NoIndex(route string) bool {
+	return matchRoute(route, s.opts.NoIndex)
+}
+
+// Process implements Processor
+func (s *SEO) Process(page *Page) []string {
+	doc := parseDocument(page.HTML)
+	if doc == nil {
+		return nil
+	}
+	head := findElement(doc, atom.Head)
+	if head == nil {
+		return nil
+	}
+
+	var notes []string
+	notes = append(notes, s.enforceTitle(doc, head, page)...)
+	notes = append(notes, s.enforceDescription(doc, head)...)
+	notes = append(notes, s.enforceCanonical(head, page)...)
+	notes = append(notes, s.enforceRobots(head, page)...)
+	notes = append(notes, s.enforceOpenGraph(head)...)
+
+	if len(notes) > 0 {
+		if rendered, err := renderDocument(doc); err == nil {
+			page.HTML = rendered
+		}
+	}
+	return notes
+}
+
+func (s *SEO) enforceTitle(doc, head *html.Node, page *Page) []string {
+	title := findElement(head, atom.Title)
+	current := ""
+	if title != nil {
+		current = textContent(title)
+	}
+
+	want := current
+	if want == "" {
+		if h1 := findElement(doc, atom.H1); h1 != nil {
+			want = textContent(h1)
+		}
+	}
+	if want == "" {
+		want = humanizeRoute(page.Route)
+	}
+	if s.opts.SiteName != "" && !strings.Contains(want, s.opts.SiteName) {
+		want = want + " | " + s.opts.SiteName
+	}
+	if want == current {
+		return nil
+	}
+
+	if title == nil {
+		title = &html.Node{Type: html.ElementNode, DataAtom: atom.Title, Data: "title"}
+		head.InsertBefore(title, head.FirstChild)
+	}
+	setText(title, want)
+	return []string{fmt.Sprintf("set <title> to %q", want)}
+}
+
+func (s *SEO) enforceDescription(doc, head *html.Node) []string {
+	meta := findMeta(head, "name", "description")
+	if meta != nil {
+		if content, _ := getAttr(meta, "content"); strings.TrimSpace(content) != "" {
+			return nil
+		}
+	}
+
+	description := s.opts.DefaultDescription
+	if description == "" {
+		if p := findElement(doc, atom.P); p != nil {
+			description = truncate(textContent(p), 155)
+		}
+	}
+	if description == "" {
+		return nil
+	}
+
+	if meta == nil {
+		meta = appendElement(head, atom.Meta, "name", "description")
+	}
+	setAttr(meta, "content", description)
+	return []string{fmt.Sprintf("set meta description to %q", truncate(description, 60))}
+}
+
+func (s *SEO) enforceCanonical(head *html.Node, page *Page) []string {
+	if s.opts.CanonicalBaseURL == "" {
+		return nil
+	}
+	canonical := s.opts.CanonicalBaseURL + "/"
+	if page.Route != "home" {
+		canonical += page.Route
+	}
+	if page.Lang != "" {
+		canonical += "?lang=" + url.QueryEscape(page.Lang)
+	}
+
+	link := findLink(head, "canonical")
+	if link != nil {
+		if href, _ := getAttr(link, "href"); href == canonical {
+			return nil
+		}
+	} else {
+		link = appendElement(head, atom.Link, "rel", "canonical")
+	}
+	setAttr(link, "href", canonical)
+	return []string{fmt.Sprintf("set canonical link to %q", canonical)}
+}
+
+func (s *SEO) enforceRobots(head *html.Node, page *Page) []string {
+	meta := findMeta(head, "name", "robots")
+	if !page.NoIndex && !s.NoIndex(page.Route) {
+		// Models sometimes emit noindex on their own; only configured routes may opt out
+		if meta != nil {
+			if content, _ := getAttr(meta, "content"); strings.Contains(strings.ToLower(content), "noindex") {
+				meta.Parent.RemoveChild(meta)
+				return []string{"removed model-generated robots noindex"}
+			}
+		}
+		return nil
+	}
+
+	if meta != nil {
+		if content, _ := getAttr(meta, "content"); content == "noindex, nofollow" {
+			return nil
+		}
+	} else {
+		meta = appendElement(head, atom.Meta, "name", "robots")
+	}
+	setAttr(meta, "content", "noindex, nofollow")
+	return []string{"set robots meta to noindex"}
+}
+
+func (s *SEO) enforceOpenGraph(head *html.Node) []string {
+	var notes []string
+	set := func(property, value string) {
+		if value == "" || findMeta(head, "property", property) != nil {
+			return
+		}
+		meta := appendElement(head, atom.Meta, "property", property)
+		setAttr(meta, "content", value)
+		notes = append(notes, fmt.Sprintf("added %s", property))
+	}
+
+	if title := findElement(head, atom.Title); title != nil {
+		set("og:title", textContent(title))
+	}
+	set("og:site_name", s.opts.SiteName)
+	if link := findLink(head, "canonical"); link != nil {
+		href, _ := getAttr(link, "href")
+		set("og:url", href)
+	}
+	return notes
+}
+
+// matchRoute reports whether route matches any of the glob patterns
+func matchRoute(route string, patterns []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.Trim(pattern, "/")
+		if ok, _ := path.Match(pattern, route); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// humanizeRoute turns "tech_and_projects" into "Tech And Projects"
+func humanizeRoute(route string) string {
+	words := strings.FieldsFunc(path.Base(route), func(r rune) bool {
+		return r == '-' || r == '_'
+	})
+	for i, w := range words {
+		r := []rune(w)
+		words[i] = strings.ToUpper(string(r[0])) + string(r[1:])
+	}
+	return strings.Join(words, " ")
+}
+
+// findElement returns the first element with the given atom below n
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	var found *html.Node
+	walk(n, func(c *html.Node) {
+		if found == nil && c.DataAtom == a {
+			found = c
+		}
+	})
+	return found
+}
+
+// findMeta returns the first <meta> whose attribute key equals value (case-insensitive)
+func findMeta(head *html.Node, key, value string) *html.Node {
+	var found *html.Node
+	walk(head, func(c *html.Node) {
+		if found != nil || c.DataAtom != atom.Meta {
+			return
+		}
+		if v, ok := getAttr(c, key); ok && strings.EqualFold(v, value) {
+			found = c
+		}
+	})
+	return found
+}
+
+// findLink returns the first <link> with the given rel
+func findLink(head *html.Node, rel string) *html.Node {
+	var found *html.Node
+	walk(head, func(c *html.Node) {
+		if found != nil || c.DataAtom != atom.Link {
+			return
+		}
+		if v, ok := getAttr(c, "rel"); ok && strings.EqualFold(v, rel) {
+			found = c
+		}
+	})
+	return found
+}
+
+// appendElement adds a new element with one attribute as the last child of parent
+func appendElement(parent *html.Node, a atom.Atom, key, value string) *html.Node {
+	n := &html.Node{Type: html.ElementNode, DataAtom: a, Data: a.String()}
+	setAttr(n, key, value)
+	parent.AppendChild(n)
+	return n
+}
+
+// setText replaces the children of n with a single text node
+func setText(n *html.Node, text string) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		n.RemoveChild(c)
+		c = next
+	}
+	n.AppendChild(&html.Node{Type: html.TextNode, Data: text})
+}
@@ -0,0 +1,133 @@
+package postprocess
+
+import "testing"
+
+func TestSEO(t *testing.T) {
+	s := NewSEO(SEOOptions{SiteName: "Acme", CanonicalBaseURL: "https://example.com/", NoIndex: []string{"/drafts/*"}})
+	const og = `<meta property="og:title" content="About | Acme"/><meta property="og:site_name" content="Acme"/><meta property="og:url" content="https://example.com/about"/>`
+
+	for _, tc := range []struct {
+		name    string
+		route   string
+		lang    string
+		noIndex bool
+		in      string
+		want    string
+		notes   int
+	}{
+		{
+			name:  "complete",
+			route: "about",
+			in: `<html><head><title>About | Acme</title><meta name="description" content="Who we are"><link rel="canonical" href="https://example.com/about">` +
+				`<meta property="og:title" content="About | Acme"><meta property="og:site_name" content="Acme"><meta property="og:url" content="https://example.com/about"></head><body></body></html>`,
+			want: `<html><head><title>About | Acme</title><meta name="description" content="Who we are"><link rel="canonical" href="https://example.com/about">` +
+				`<meta property="og:title" content="About | Acme"><meta property="og:site_name" content="Acme"><meta property="og:url" content="https://example.com/about"></head><body></body></html>`,
+		},
+		{
+			name:  "empty head",
+			route: "tech_and-projects",
+			in:    `<html><head></head><body><p>  First   paragraph. </p><p>Second</p></body></html>`,
+			want: `<html><head><title>Tech And Projects | Acme</title><meta name="description" content="First paragraph."/>` +
+				`<link rel="canonical" href="https://example.com/tech_and-projects"/><meta property="og:title" content="Tech And Projects | Acme"/>` +
+				`<meta property="og:site_name" content="Acme"/><meta property="og:url" content="https://example.com/tech_and-projects"/></head>` +
+				`<body><p>  First   paragraph. </p><p>Second</p></body></html>`,
+			notes: 6,
+		},
+		{
+			name:  "title from the heading, canonical with the language",
+			route: "home",
+			lang:  "pt-BR",
+			in:    `<html><head><title>  </title></head><body><h1>Welcome <em>home</em></h1></body></html>`,
+			want: `<html><head><title>Welcome home | Acme</title><link rel="canonical" href="https://example.com/?lang=pt-BR"/>` +
+				`<meta property="og:title" content="Welcome home | Acme"/><meta property="og:site_name" content="Acme"/>` +
+				`<meta property="og:url" content="https://example.com/?lang=pt-BR"/></head><body><h1>Welcome <em>home</em></h1></body></html>`,
+			notes: 5,
+		},
+		{
+			name:  "wrong canonical and a model-generated noindex",
+			route: "about",
+			in:    `<html><head><title>About</title><link rel="Canonical" href="https://evil.example/"><meta name="robots" content="NOINDEX"></head><body></body></html>`,
+			want:  `<html><head><title>About | Acme</title><link rel="Canonical" href="https://example.com/about"/>` + og + `</head><body></body></html>`,
+			notes: 6,
+		},
+		{
+			name:  "configured noindex",
+			route: "drafts/x",
+			in:    `<html><head><title>Draft | Acme</title><meta name="robots" content="noindex"></head><body></body></html>`,
+			want: `<html><head><title>Draft | Acme</title><meta name="robots" content="noindex, nofollow"/><link rel="canonical" href="https://example.com/drafts/x"/>` +
+				`<meta property="og:title" content="Draft | Acme"/><meta property="og:site_name" content="Acme"/><meta property="og:url" content="https://example.com/drafts/x"/></head><body></body></html>`,
+			notes: 5,
+		},
+		{
+			name:    "noindex page",
+			route:   "about",
+			noIndex: true,
+			in:      `<html><head><title>About | Acme</title></head><body></body></html>`,
+			want: `<html><head><title>About | Acme</title><link rel="canonical" href="https://example.com/about"/><meta name="robots" content="noindex, nofollow"/>` +
+				og + `</head><body></body></html>`,
+			notes: 5,
+		},
+		{
+			name:  "unclosed title",
+			route: "about",
+			in:    `<!DOCTYPE html><html><head><title>About<body><p>Text`,
+			want: `<!DOCTYPE html><html><head><title>About&lt;body&gt;&lt;p&gt;Text | Acme</title><link rel="canonical" href="https://example.com/about"/>` +
+				`<meta property="og:title" content="About&lt;body&gt;&lt;p&gt;Text | Acme"/><meta property="og:site_name" content="Acme"/>` +
+				`<meta property="og:url" content="https://example.com/about"/></head><body></body></html>`,
+			notes: 5,
+		},
+		{
+			name:  "metadata in the body",
+			route: "about",
+			in:    `<html><body><meta name="description" content="x"><title>Late</title><p>Text</p>`,
+			want: `<html><head><title>About | Acme</title><meta name="description" content="Text"/><link rel="canonical" href="https://example.com/about"/>` +
+				og + `</head><body><meta name="description" content="x"/><title>Late</title><p>Text</p></body></html>`,
+			notes: 6,
+		},
+		{
+			name:  "fragment",
+			route: "about",
+			in:    `<p>Fragment</p>`,
+			want:  `<p>Fragment</p>`,
+		},
+	} {
+		page := &Page{Route: tc.route, Lang: tc.lang, NoIndex: tc.noIndex, HTML: tc.in}
+		notes := s.Process(page)
+		if page.HTML != tc.want {
+			t.Errorf("%s:\n got %q\nwant %q", tc.name, page.HTML, tc.want)
+		}
+		if len(notes) != tc.notes {
+			t.Errorf("%s: %d notes %q, want %d", tc.name, len(notes), notes, tc.notes)
+		}
+	}
+}
+
+func TestSEODefaults(t *testing.T) {
+	s := NewSEO(SEOOptions{DefaultDescription: "The Acme company"})
+	page := &Page{Route: "about", HTML: `<html><head><title>About</title></head><body><p>Text</p></body></html>`}
+	notes := s.Process(page)
+	const want = `<html><head><title>About</title><meta name="description" content="The Acme company"/><meta property="og:title" content="About"/></head><body><p>Text</p></body></html>`
+	if page.HTML != want || len(notes) != 2 {
+		t.Errorf("got %q (%q), want %q", page.HTML, notes, want)
+	}
+}
+
+func TestMatchRoute(t *testing.T) {
+	patterns := []string{"/drafts/*", "private", "tmp-*/"}
+	for _, tc := range []struct {
+		route string
+		want  bool
+	}{
+		{"drafts/one", true},
+		{"drafts/one/two", false},
+		{"drafts", false},
+		{"private", true},
+		{"private/x", false},
+		{"tmp-1", true},
+		{"about", false},
+	} {
+		if got := matchRoute(tc.route, patterns); got != tc.want {
+			t.Errorf("matchRoute(%q) = %v, want %v", tc.route, got, tc.want)
+		}
+	}
+}
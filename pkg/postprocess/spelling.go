@@ -0,0 +1,255 @@
+package postprocess
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// CompleteFunc sends a single prompt to a model and returns its full output
+type CompleteFunc func(ctx context.Context, systemPrompt, userPrompt string) (string, error)
+
+// defaultEnglishCorrections covers typos small local models make most often
+var defaultEnglishCorrections = map[string]string{
+	"accomodate": "accommodate", "acheive": "achieve", "acommodate": "accommodate",
+	"adress": "address", "agressive": "aggressive", "apparantly": "apparently",
+	"begining": "beginning", "beleive": "believe", "buisness": "business",
+	"calender": "calendar", "collegue": "colleague", "comming": "coming",
+	"commited": "committed", "completly": "completely", "concious": "conscious",
+	"definately": "definitely", "dilema": "dilemma", "enviroment": "environment",
+	"existance": "existence", "experiance": "experience", "familar": "familiar",
+	"finaly": "finally", "foward": "forward", "freind": "friend",
+	"goverment": "government", "grammer": "grammar", "guarentee": "guarantee",
+	"happend": "happened", "immediatly": "immediately", "independant": "independent",
+	"knowlege": "knowledge", "liason": "liaison", "libary": "library",
+	"maintainance": "maintenance", "millenium": "millennium", "neccessary": "necessary",
+	"necesary": "necessary", "noticable": "noticeable", "occassion": "occasion",
+	"occured": "occurred", "occurence": "occurrence", "persistant": "persistent",
+	"posession": "possession", "prefered": "preferred", "publically": "publicly",
+	"recieve": "receive", "recomend": "recommend", "refered": "referred",
+	"relevent": "relevant", "seperate": "separate", "sucess": "success",
+	"succesful": "successful", "supercede": "supersede", "suprise": "surprise",
+	"teh": "the", "tommorow": "tomorrow", "truely": "truly",
+	"untill": "until", "wierd": "weird", "wich": "which",
+}
+
+// wordRE matches candidate words in text nodes
+var wordRE = regexp.MustCompile(`\p{L}+`)
+
+// Spelling corrects obvious spelling errors in the visible text of generated
+// pages. Corrections come from a local dictionary of known typos and,
+// optionally, a second cheap model call that proofreads the text.
+type Spelling struct {
+	languages   []string
+	corrections map[string]string
+	complete    CompleteFunc
+}
+
+// NewSpelling creates the spelling pass for the given languages. dictionary is
+// an optional file of "typo=correction" lines; when empty the built-in English
+// list is used. complete, if non-nil, runs a model proofreading pass as well.
+func NewSpelling(languages []string, dictionary string, complete CompleteFunc) (*Spelling, error) {
+	corrections := defaultEnglishCorrections
+	if dictionary != "" {
+		loaded, err := loadCorrections(dictionary)
+		if err != nil {
+			return nil, err
+		}
+		corrections = loaded
+	}
+	if len(languages) == 0 {
+		languages = []string{"en"}
+	}
+	return &Spelling{languages: languages, corrections: corrections, complete: complete}, nil
+}
+
+// loadCorrections reads "typo=correction" lines, ignoring blanks and # comments
+func loadCorrections(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening spelling dictionary: %w", err)
+	}
+	defer f.Close()
+
+	corrections := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		typo, fix, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		corrections[strings.ToLower(strings.TrimSpace(typo))] = strings.TrimSpace(fix)
+	}
+	return corrections, scanner.Err()
+}
+
+// Name implements Processor
+func (s *Spelling) Name() string {
+	return "spelling"
+}
+
+// Process implements Processor
+func (s *Spelling) Process(page *Page) []string {
+	doc := parseDocument(page.HTML)
+	if doc == nil || !s.appliesTo(pageLanguage(doc, page.Lang)) {
+		return nil
+	}
+
+	nodes := visibleTextNodes(doc)
+	var notes []string
+	for _, n := range nodes {
+		n.Data = wordRE.ReplaceAllStringFunc(n.Data, func(word string) string {
+			fix, ok := s.corrections[strings.ToLower(word)]
+			if !ok {
+				return word
+			}
+			fix = matchCase(word, fix)
+			notes = append(notes, fmt.Sprintf("%q -> %q", word, fix))
+			return fix
+		})
+	}
+
+	if s.complete != nil {
+		notes = append(notes, s.proofread(page.context(), nodes)...)
+	}
+
+	if len(notes) > 0 {
+		if rendered, err := renderDocument(doc); err == nil {
+			page.HTML = rendered
+		}
+	}
+	return notes
+}
+
+// appliesTo reports whether lang is one of the configured languages
+func (s *Spelling) appliesTo(lang string) bool {
+	lang = strings.ToLower(lang)
+	for _, l := range s.languages {
+		l = strings.ToLower(l)
+		if lang == l || strings.HasPrefix(lang, l+"-") || strings.HasPrefix(lang, l+"_") {
+			return true
+		}
+	}
+	return false
+}
+
+// pageLanguage returns the requested language, the document's lang attribute, or "en"
+func pageLanguage(doc *html.Node, requested string) string {
+	if requested != "" {
+		return requested
+	}
+	lang := ""
+	walk(doc, func(n *html.Node) {
+		if n.DataAtom == atom.Html && lang == "" {
+			lang, _ = getAttr(n, "lang")
+		}
+	})
+	if lang == "" {
+		return "en"
+	}
+	return lang
+}
+
+// skipTextParents are elements whose text is not prose
+var skipTextParents = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Code: true, atom.Pre: true,
+	atom.Kbd: true, atom.Samp: true, atom.Textarea: true,
+}
+
+// visibleTextNodes returns the non-empty prose text nodes of a document
+func visibleTextNodes(doc *html.Node) []*html.Node {
+	var nodes []*html.Node
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		if n.Type == html.ElementNode && skipTextParents[n.DataAtom] {
+			return
+		}
+		if n.Type == html.TextNode && strings.TrimSpace(n.Data) != "" {
+			nodes = append(nodes, n)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
+		}
+	}
+	collect(doc)
+	return nodes
+}
+
+// matchCase applies the capitalization of word to fix
+func matchCase(word, fix string) string {
+	runes := []rune(word)
+	switch {
+	case strings.ToUpper(word) == word && len(runes) > 1:
+		return strings.ToUpper(fix)
+	case unicode.IsUpper(runes[0]):
+		f := []rune(fix)
+		f[0] = unicode.ToUpper(f[0])
+		return string(f)
+	}
+	return fix
+}
+
+const proofreadSystemPrompt = `You are a careful proofreader. You receive numbered lines of web page text.
+Fix obvious spelling mistakes only. Do not rephrase, translate, or change meaning, punctuation, or names.
+Reply with exactly the same numbered lines in the same "N: text" format and nothing else.`
+
+// proofread asks the secondary model to fix spelling and applies only small, line-preserving edits
+func (s *Spelling) proofread(ctx context.Context, nodes []*html.Node) []string {
+	if len(nodes) == 0 {
+		return nil
+	}
+	var prompt strings.Builder
+	for i, n := range nodes {
+		fmt.Fprintf(&prompt, "%d: %s\n", i+1, strings.Join(strings.Fields(n.Data), " "))
+	}
+
+	output, err := s.complete(ctx, proofreadSystemPrompt, prompt.String())
+	if err != nil {
+		log.Printf("⚠️  Spelling: proofreading model call failed: %v", err)
+		return nil
+	}
+
+	var notes []string
+	for _, line := range strings.Split(output, "\n") {
+		num, text, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		i, err := strconv.Atoi(strings.TrimSpace(num))
+		if err != nil || i < 1 || i > len(nodes) {
+			continue
+		}
+		text = strings.TrimSpace(text)
+		original := strings.Join(strings.Fields(nodes[i-1].Data), " ")
+		if text == "" || text == original {
+			continue
+		}
+		// Reject rewrites: a spelling fix changes only a few characters
+		if levenshtein(original, text) > max(3, len(original)/10) {
+			continue
+		}
+		nodes[i-1].Data = preserveOuterSpace(nodes[i-1].Data, text)
+		notes = append(notes, fmt.Sprintf("model corrected %q -> %q", truncate(original, 60), truncate(text, 60)))
+	}
+	return notes
+}
+
+// preserveOuterSpace keeps the leading and trailing whitespace of original around text
+func preserveOuterSpace(original, text string) string {
+	leading := original[:len(original)-len(strings.TrimLeftFunc(original, unicode.IsSpace))]
+	trailing := original[len(strings.TrimRightFunc(original, unicode.IsSpace)):]
+	return leading + text + trailing
+}
@@ -0,0 +1,103 @@
+package rag
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Ollama embeds with an Ollama server's /api/embed
+type Ollama struct {
+	Model   string
+	APIKey  string // Only needed behind an authenticating proxy
+	APIBase string
+	Client  *http.Client
+}
+
+// Embed implements Embedder
+func (o *Ollama) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	base := strings.TrimSuffix(o.APIBase, "/")
+	if base == "" {
+		base = "http://localhost:11434"
+	}
+	var resp struct {
+		Embeddings [][]float32 `json:"embeddings"`
+	}
+	payload := map[string]interface{}{"model": o.Model, "input": texts}
+	if err := postJSON(ctx, o.Client, base+"/api/embed", o.APIKey, payload, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("Ollama returned %d embeddings for %d texts", len(resp.Embeddings), len(texts))
+	}
+	return resp.Embeddings, nil
+}
+
+// OpenAI embeds with an OpenAI-compatible /embeddings endpoint
+type OpenAI struct {
+	Model   string
+	APIKey  string
+	APIBase string
+	Client  *http.Client
+}
+
+// Embed implements Embedder
+func (o *OpenAI) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	base := strings.TrimSuffix(o.APIBase, "/")
+	if base == "" {
+		base = "https://api.openai.com/v1"
+	}
+	var resp struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	payload := map[string]interface{}{"model": o.Model, "input": texts}
+	if err := postJSON(ctx, o.Client, base+"/embeddings", o.APIKey, payload, &resp); err != nil {
+		return nil, err
+	}
+	vectors := make([][]float32, len(texts))
+	for _, d := range resp.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	for i, v := range vectors {
+		if v == nil {
+			return nil, fmt.Errorf("no embedding returned for text %d", i)
+		}
+	}
+	return vectors, nil
+}
+
+// postJSON sends payload and decodes the JSON answer into out
+func postJSON(ctx context.Context, client *http.Client, url, apiKey string, payload, out interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s: %s", url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
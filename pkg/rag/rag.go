@@ -0,0 +1,272 @@
+// Package rag grounds generated pages in real site content: it splits the
+// documents of a directory into chunks, embeds them with an embeddings model
+// (Ollama or OpenAI), and finds the chunks most relevant to a prompt.
+package rag
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// DefaultChunkSize is the largest chunk, in characters, when none is set
+const DefaultChunkSize = 1500
+
+// embedBatch is how many chunks are embedded per request
+const embedBatch = 32
+
+// Embedder turns texts into embedding vectors, one per text
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// NewEmbedder creates the embedder for backend ("ollama" or "openai"). An
+// empty apiBase uses the backend's default endpoint.
+func NewEmbedder(backend, model, apiKey, apiBase string) (Embedder, error) {
+	if model == "" {
+		return nil, fmt.Errorf("an embeddings model is required")
+	}
+	client := &http.Client{Timeout: 2 * time.Minute}
+	switch backend {
+	case "ollama":
+		return &Ollama{Model: model, APIKey: apiKey, APIBase: apiBase, Client: client}, nil
+	case "openai":
+		if apiKey == "" {
+			return nil, fmt.Errorf("openai embeddings require an API key")
+		}
+		return &OpenAI{Model: model, APIKey: apiKey, APIBase: apiBase, Client: client}, nil
+	}
+	return nil, fmt.Errorf("unknown embeddings backend %q (use ollama or openai)", backend)
+}
+
+// Chunk is a piece of a document
+type Chunk struct {
+	Source string // Path relative to the indexed directory
+	Text   string
+	Score  float64 // Similarity to the query, set by Search
+
+	vector []float32
+}
+
+// Index holds the embedded chunks of a directory's documents (.md, .txt,
+// and .html files)
+type Index struct {
+	dir       string
+	chunkSize int
+	embedder  Embedder
+
+	mu     sync.RWMutex
+	chunks []Chunk
+}
+
+// NewIndex creates an empty index of dir; call Build to fill it
+func NewIndex(embedder Embedder, dir string, chunkSize int) *Index {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	return &Index{dir: dir, chunkSize: chunkSize, embedder: embedder}
+}
+
+// Build reads and embeds the documents, replacing the previous contents.
+// Chunks that did not change since the last build keep their embeddings.
+// It returns the number of chunks indexed.
+func (ix *Index) Build(ctx context.Context) (int, error) {
+	var chunks []Chunk
+	err := filepath.WalkDir(ix.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != ix.dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if ext != ".md" && ext != ".txt" && ext != ".html" && ext != ".htm" {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		text := string(data)
+		if ext == ".html" || ext == ".htm" {
+			text = htmlText(text)
+		}
+		rel, _ := filepath.Rel(ix.dir, path)
+		for _, piece := range split(text, ix.chunkSize) {
+			chunks = append(chunks, Chunk{Source: filepath.ToSlash(rel), Text: piece})
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("reading %s: %w", ix.dir, err)
+	}
+
+	// Reuse what is already embedded
+	known := map[[32]byte][]float32{}
+	ix.mu.RLock()
+	for _, c := range ix.chunks {
+		known[sha256.Sum256([]byte(c.Text))] = c.vector
+	}
+	ix.mu.RUnlock()
+	var pending []int
+	for i := range chunks {
+		if v, ok := known[sha256.Sum256([]byte(chunks[i].Text))]; ok {
+			chunks[i].vector = v
+		} else {
+			pending = append(pending, i)
+		}
+	}
+
+	for start := 0; start < len(pending); start += embedBatch {
+		batch := pending[start:min(start+embedBatch, len(pending))]
+		texts := make([]string, len(batch))
+		for j, i := range batch {
+			texts[j] = chunks[i].Text
+		}
+		vectors, err := ix.embedder.Embed(ctx, texts)
+		if err != nil {
+			return 0, fmt.Errorf("embedding: %w", err)
+		}
+		for j, i := range batch {
+			chunks[i].vector = vectors[j]
+		}
+	}
+
+	ix.mu.Lock()
+	ix.chunks = chunks
+	ix.mu.Unlock()
+	return len(chunks), nil
+}
+
+// Len returns the number of indexed chunks
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.chunks)
+}
+
+// Search returns the k chunks most similar to query, best first
+func (ix *Index) Search(ctx context.Context, query string, k int) ([]Chunk, error) {
+	ix.mu.RLock()
+	chunks := ix.chunks
+	ix.mu.RUnlock()
+	if len(chunks) == 0 || k <= 0 || strings.TrimSpace(query) == "" {
+		return nil, nil
+	}
+
+	vectors, err := ix.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("embedding the query: %w", err)
+	}
+	q := vectors[0]
+	scored := make([]Chunk, len(chunks))
+	for i, c := range chunks {
+		scored[i] = c
+		scored[i].Score = cosine(q, c.vector)
+	}
+	sort.SliceStable(scored, func(i, j int) bool { return scored[i].Score > scored[j].Score })
+	return scored[:min(k, len(scored))], nil
+}
+
+// cosine returns the cosine similarity of a and b (0 for mismatched vectors)
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// split cuts text into chunks of at most size characters, at paragraph
+// breaks where possible and at spaces otherwise
+func split(text string, size int) []string {
+	var chunks []string
+	var current strings.Builder
+	flush := func() {
+		if s := strings.TrimSpace(current.String()); s != "" {
+			chunks = append(chunks, s)
+		}
+		current.Reset()
+	}
+	for _, para := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		para = strings.TrimSpace(para)
+		if para == "" {
+			continue
+		}
+		if current.Len() > 0 && current.Len()+len(para)+2 > size {
+			flush()
+		}
+		for len(para) > size {
+			cut := strings.LastIndexByte(para[:size], ' ')
+			if cut <= 0 {
+				cut = size
+			}
+			current.WriteString(para[:cut])
+			flush()
+			para = strings.TrimSpace(para[cut:])
+		}
+		if current.Len() > 0 {
+			current.WriteString("\n\n")
+		}
+		current.WriteString(para)
+	}
+	flush()
+	return chunks
+}
+
+// htmlText extracts the visible text of an HTML document, one block per
+// paragraph
+func htmlText(doc string) string {
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(doc))
+	skip := 0
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return b.String()
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "script", "style", "noscript":
+				skip++
+			case "p", "div", "section", "article", "li", "h1", "h2", "h3", "h4", "h5", "h6", "tr", "br":
+				b.WriteString("\n\n")
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "script", "style", "noscript":
+				if skip > 0 {
+					skip--
+				}
+			}
+		case html.TextToken:
+			if skip == 0 {
+				b.WriteString(strings.Join(strings.Fields(string(z.Text())), " "))
+				b.WriteByte(' ')
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package rag
+
+import (
+	"context"
+	"hash/fnv"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// wordsEmbedder embeds texts as bags of words and counts its calls
+type wordsEmbedder struct{ texts int }
+
+func (e *wordsEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	e.texts += len(texts)
+	vectors := make([][]float32, len(texts))
+	for i, t := range texts {
+		v := make([]float32, 64)
+		for _, w := range strings.Fields(strings.ToLower(t)) {
+			h := fnv.New32a()
+			h.Write([]byte(strings.Trim(w, ".,:")))
+			v[h.Sum32()%64]++
+		}
+		vectors[i] = v
+	}
+	return vectors, nil
+}
+
+func TestIndexSearch(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "team.md"), []byte("# Team\n\nAda leads engineering.\n\nGrace runs operations."), 0o644)
+	os.WriteFile(filepath.Join(dir, "pricing.html"), []byte("<html><script>var x</script><p>Plans start at 10 euros per month.</p></html>"), 0o644)
+	os.WriteFile(filepath.Join(dir, "logo.png"), []byte("not text"), 0o644)
+
+	e := &wordsEmbedder{}
+	ix := NewIndex(e, dir, 30)
+	n, err := ix.Build(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Fatalf("indexed %d chunks, want 4", n)
+	}
+
+	chunks, err := ix.Search(context.Background(), "how much per month are plans", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 1 || chunks[0].Source != "pricing.html" || strings.Contains(chunks[0].Text, "var x") {
+		t.Errorf("search = %+v", chunks)
+	}
+
+	// Unchanged chunks are not embedded again
+	before := e.texts
+	if _, err := ix.Build(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if e.texts != before {
+		t.Errorf("rebuild embedded %d texts, want 0", e.texts-before)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	chunks := split("one two three four five six seven eight nine ten", 20)
+	for _, c := range chunks {
+		if len(c) > 20 {
+			t.Errorf("chunk %q is over 20 characters", c)
+		}
+	}
+	if strings.Join(chunks, " ") != "one two three four five six seven eight nine ten" {
+		t.Errorf("chunks lost text: %q", chunks)
+	}
+	if got := split("a\n\nb", 100); len(got) != 1 || got[0] != "a\n\nb" {
+		t.Errorf("short paragraphs not merged: %q", got)
+	}
+}
+
+func TestOpenAIEmbed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/embeddings" || r.Header.Get("Authorization") != "Bearer key" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		// Out of order, as the API allows
+		w.Write([]byte(`{"data":[{"index":1,"embedding":[0,1]},{"index":0,"embedding":[1,0]}]}`))
+	}))
+	defer srv.Close()
+
+	e, err := NewEmbedder("openai", "text-embedding-3-small", "key", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vectors, err := e.Embed(context.Background(), []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vectors[0][0] != 1 || vectors[1][1] != 1 {
+		t.Errorf("vectors = %v", vectors)
+	}
+}
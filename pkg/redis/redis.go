@@ -0,0 +1,289 @@
+// Package redis is a shared page cache for several MuseWeb instances behind a
+// load balancer. Instances serve each other's generated pages, and a lock per
+// page makes sure only one of them generates it at a time. It speaks the
+// small subset of the Redis protocol it needs, so any Redis-compatible server
+// (Redis, Valkey, KeyDB, Dragonfly) works.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxIdle is how many idle connections are kept for reuse
+const maxIdle = 8
+
+// unlockScript deletes a lock only while it still holds our token, so an
+// expired lock taken over by another instance is left alone
+const unlockScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+
+// Options configure the connection to the Redis server
+type Options struct {
+	Address  string // host:port
+	Password string
+	DB       int
+	Prefix   string        // Prepended to every key, e.g. "museweb:"
+	TTL      time.Duration // How long pages are kept
+	Stale    time.Duration // How much longer expired pages are kept (see GetStale)
+}
+
+// Cache keeps generated pages in Redis
+type Cache struct {
+	opts  Options
+	token string // Identifies this instance's locks
+
+	mu   sync.Mutex
+	idle []*conn
+}
+
+// conn is one connection to the server
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// errNil is the reply to a GET of a missing key
+var errNil = errors.New("nil reply")
+
+// Open connects to the server described by opts and checks that it answers
+func Open(opts Options) (*Cache, error) {
+	if opts.Address == "" {
+		return nil, fmt.Errorf("a Redis address is required")
+	}
+	token := make([]byte, 16)
+	rand.Read(token)
+	c := &Cache{opts: opts, token: hex.EncodeToString(token)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := c.do(ctx, "PING"); err != nil {
+		return nil, fmt.Errorf("connecting to Redis at %s: %w", opts.Address, err)
+	}
+	return c, nil
+}
+
+// Get returns the cached page for key
+func (c *Cache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	reply, err := c.do(ctx, "GET", c.opts.Prefix+"page:"+key)
+	if errors.Is(err, errNil) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return reply, true, nil
+}
+
+// Set caches a page for the configured TTL
+func (c *Cache) Set(ctx context.Context, key string, html []byte) error {
+	return c.SetWithTTL(ctx, key, html, 0)
+}
+
+// SetWithTTL caches a page for ttl, or the configured TTL when it is 0
+func (c *Cache) SetWithTTL(ctx context.Context, key string, html []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = c.opts.TTL
+	}
+	if _, err := c.do(ctx, "SET", c.opts.Prefix+"page:"+key, string(html), "PX", millis(ttl)); err != nil {
+		return err
+	}
+	if c.opts.Stale <= 0 {
+		return nil
+	}
+	_, err := c.do(ctx, "SET", c.opts.Prefix+"stale:"+key, string(html), "PX", millis(ttl+c.opts.Stale))
+	return err
+}
+
+// GetStale returns the last page cached for key, for up to Options.Stale
+// after it expired
+func (c *Cache) GetStale(ctx context.Context, key string) ([]byte, bool, error) {
+	if c.opts.Stale <= 0 {
+		return nil, false, nil
+	}
+	reply, err := c.do(ctx, "GET", c.opts.Prefix+"stale:"+key)
+	if errors.Is(err, errNil) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return reply, true, nil
+}
+
+// Lock claims the generation of key for ttl. It reports false when another
+// instance holds the claim.
+func (c *Cache) Lock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	_, err := c.do(ctx, "SET", c.opts.Prefix+"lock:"+key, c.token, "NX", "PX", millis(ttl))
+	if errors.Is(err, errNil) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Unlock releases a claim taken with Lock
+func (c *Cache) Unlock(ctx context.Context, key string) error {
+	_, err := c.do(ctx, "EVAL", unlockScript, "1", c.opts.Prefix+"lock:"+key, c.token)
+	return err
+}
+
+// Close closes the idle connections
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cn := range c.idle {
+		cn.Close()
+	}
+	c.idle = nil
+	return nil
+}
+
+// do runs one command and returns its reply; integer and status replies are
+// returned as text
+func (c *Cache) do(ctx context.Context, args ...string) ([]byte, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		cn.SetDeadline(deadline)
+	} else {
+		cn.SetDeadline(time.Time{})
+	}
+	if err := writeCommand(cn, args); err != nil {
+		cn.Close()
+		return nil, err
+	}
+	reply, err := readReply(cn.r)
+	var serverErr replyError
+	if err != nil && !errors.Is(err, errNil) && !errors.As(err, &serverErr) {
+		// The connection is in an unknown state
+		cn.Close()
+		return nil, err
+	}
+	c.put(cn)
+	return reply, err
+}
+
+// get returns an idle connection or dials a new one
+func (c *Cache) get(ctx context.Context) (*conn, error) {
+	c.mu.Lock()
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", c.opts.Address)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc)}
+	if c.opts.Password != "" {
+		if err := cn.setup(ctx, "AUTH", c.opts.Password); err != nil {
+			return nil, err
+		}
+	}
+	if c.opts.DB != 0 {
+		if err := cn.setup(ctx, "SELECT", strconv.Itoa(c.opts.DB)); err != nil {
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+// setup runs a connection setup command, closing the connection on failure
+func (cn *conn) setup(ctx context.Context, args ...string) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		cn.SetDeadline(deadline)
+	}
+	err := writeCommand(cn, args)
+	if err == nil {
+		_, err = readReply(cn.r)
+	}
+	if err != nil {
+		cn.Close()
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	return nil
+}
+
+// put returns a connection to the idle pool
+func (c *Cache) put(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.idle) >= maxIdle {
+		cn.Close()
+		return
+	}
+	c.idle = append(c.idle, cn)
+}
+
+// replyError is an error reply from the server
+type replyError string
+
+func (e replyError) Error() string { return "redis: " + string(e) }
+
+// writeCommand sends args as an array of bulk strings
+func writeCommand(w io.Writer, args []string) error {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// readReply reads one reply. Arrays are not used by this package's commands
+// and are rejected.
+func readReply(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+', ':':
+		return []byte(body), nil
+	case '-':
+		return nil, replyError(body)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed bulk length %q", body)
+		}
+		if n < 0 {
+			return nil, errNil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply type %q", kind)
+}
+
+// millis formats d in milliseconds, at least 1
+func millis(d time.Duration) string {
+	return strconv.FormatInt(max(d.Milliseconds(), 1), 10)
+}
@@ -0,0 +1,161 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer answers the commands Cache sends from an in-memory map
+func fakeServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var mu sync.Mutex
+	data := map[string]string{}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				r := bufio.NewReader(c)
+				for {
+					args, err := readCommand(r)
+					if err != nil {
+						return
+					}
+					mu.Lock()
+					reply := "+OK\r\n"
+					switch strings.ToUpper(args[0]) {
+					case "PING":
+						reply = "+PONG\r\n"
+					case "GET":
+						if v, ok := data[args[1]]; ok {
+							reply = "$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n"
+						} else {
+							reply = "$-1\r\n"
+						}
+					case "SET":
+						if _, exists := data[args[1]]; exists && len(args) > 3 && args[3] == "NX" {
+							reply = "$-1\r\n"
+						} else {
+							data[args[1]] = args[2]
+						}
+					case "EVAL": // The unlock script
+						if data[args[3]] == args[4] {
+							delete(data, args[3])
+							reply = ":1\r\n"
+						} else {
+							reply = ":0\r\n"
+						}
+					default:
+						reply = "-ERR unknown command\r\n"
+					}
+					mu.Unlock()
+					c.Write([]byte(reply))
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// readCommand reads an array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		arg, err := readReply(r)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = string(arg)
+	}
+	return args, nil
+}
+
+func TestCache(t *testing.T) {
+	addr := fakeServer(t)
+	ctx := context.Background()
+	a, err := Open(Options{Address: addr, Prefix: "test:", TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := Open(Options{Address: addr, Prefix: "test:", TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if _, ok, err := a.Get(ctx, "home"); ok || err != nil {
+		t.Fatalf("missing page: ok %v, err %v", ok, err)
+	}
+	page := "<html>\r\n<h1>Hello</h1></html>"
+	if err := a.Set(ctx, "home", []byte(page)); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok, err := b.Get(ctx, "home"); !ok || err != nil || string(got) != page {
+		t.Errorf("page from the other instance = %q, %v, %v", got, ok, err)
+	}
+
+	// Only one instance holds the claim, and only it can release it
+	if ok, err := a.Lock(ctx, "home", time.Minute); !ok || err != nil {
+		t.Fatalf("first claim: %v, %v", ok, err)
+	}
+	if ok, _ := b.Lock(ctx, "home", time.Minute); ok {
+		t.Error("second instance claimed a held page")
+	}
+	b.Unlock(ctx, "home")
+	if ok, _ := b.Lock(ctx, "home", time.Minute); ok {
+		t.Error("claim released by an instance not holding it")
+	}
+	a.Unlock(ctx, "home")
+	if ok, _ := b.Lock(ctx, "home", time.Minute); !ok {
+		t.Error("released claim not available")
+	}
+}
+
+func TestCacheKeepsStalePages(t *testing.T) {
+	addr := fakeServer(t)
+	ctx := context.Background()
+	c, err := Open(Options{Address: addr, Prefix: "test:", TTL: time.Minute, Stale: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, ok, err := c.GetStale(ctx, "home"); ok || err != nil {
+		t.Fatalf("missing stale page: ok %v, err %v", ok, err)
+	}
+	c.Set(ctx, "home", []byte("<h1>Hello</h1>"))
+	if got, ok, err := c.GetStale(ctx, "home"); !ok || err != nil || string(got) != "<h1>Hello</h1>" {
+		t.Errorf("stale page = %q, %v, %v", got, ok, err)
+	}
+
+	fresh, err := Open(Options{Address: addr, Prefix: "other:", TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fresh.Close()
+	fresh.Set(ctx, "home", []byte("<h1>Hello</h1>"))
+	if _, ok, _ := fresh.GetStale(ctx, "home"); ok {
+		t.Error("stale page kept without Options.Stale")
+	}
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLog writes one line per request in the Combined Log Format, so
+// existing log tooling can read it, followed by three MuseWeb fields: the
+// duration in microseconds (as Apache's %D), the backend and model that
+// generated the page, and how it was served ("miss", "cache", "warm", ...):
+//
+//	127.0.0.1 - - [16/Oct/2026:10:02:03 +0200] "GET /about HTTP/1.1" 200 5120 "-" "curl/8.5.0" 1834211 "ollama/llama3" miss
+//
+// Requests that are not pages, like static files, have "-" for the last two.
+// The values of query parameters that carry credentials are logged as
+// REDACTED.
+type AccessLog struct {
+	mu sync.Mutex
+	w  io.Writer
+	c  io.Closer // The log file, when the log has its own
+}
+
+// NewAccessLog writes the access log to w
+func NewAccessLog(w io.Writer) *AccessLog {
+	return &AccessLog{w: w}
+}
+
+// OpenAccessLog writes the access log to stdout for "stdout" or "-", and
+// appends it to the file at path otherwise, which is created readable by its
+// owner and group only
+func OpenAccessLog(path string) (*AccessLog, error) {
+	if path == "stdout" || path == "-" {
+		return NewAccessLog(os.Stdout), nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open access log: %w", err)
+	}
+	return &AccessLog{w: f, c: f}, nil
+}
+
+// Close closes the log file, if the log has one
+func (l *AccessLog) Close() error {
+	if l == nil || l.c == nil {
+		return nil
+	}
+	return l.c.Close()
+}
+
+// accessKey carries the *accessEntry of a request, so ServeHTTP can report
+// how the page was generated
+type accessKey struct{}
+
+// accessEntry is what the access log learns from the page server
+type accessEntry struct {
+	info *genInfo
+}
+
+// Middleware logs every request next handles
+func (l *AccessLog) Middleware(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		entry := &accessEntry{}
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), accessKey{}, entry)))
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		l.write(r, sw, start, entry)
+	})
+}
+
+// write formats and writes the line for a finished request
+func (l *AccessLog) write(r *http.Request, sw *statusWriter, start time.Time, entry *accessEntry) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if host == "" || host == "@" {
+		host = "-" // Unix sockets have no client address
+	}
+	user := "-"
+	if name, _, ok := r.BasicAuth(); ok && name != "" {
+		user = name
+	}
+	size := "-"
+	if sw.bytes > 0 {
+		size = strconv.FormatInt(sw.bytes, 10)
+	}
+	model, cache := "-", "-"
+	if g := entry.info; g != nil {
+		g.mu.Lock()
+		if g.model != "" {
+			model = g.backend + "/" + g.model
+		}
+		cache = g.cache
+		g.mu.Unlock()
+	}
+
+	line := fmt.Sprintf("%s - %s [%s] %s %d %s %s %s %d %s %s\n",
+		host, user, start.Format("02/Jan/2006:15:04:05 -0700"),
+		quoteLog(r.Method+" "+logURI(r.URL)+" "+r.Proto), sw.status, size,
+		quoteLog(r.Referer()), quoteLog(r.UserAgent()),
+		time.Since(start).Microseconds(), quoteLog(model), cache)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.w, line)
+}
+
+// sensitiveParams are the query parameters whose values are never logged
+var sensitiveParams = map[string]bool{"token": true, "access_token": true, "api_key": true, "key": true}
+
+// logURI returns the path and query of u for the log, with the values of
+// sensitiveParams redacted. The query keeps its order and encoding otherwise.
+func logURI(u *url.URL) string {
+	uri := u.EscapedPath()
+	if u.RawQuery == "" {
+		return uri
+	}
+	params := strings.Split(u.RawQuery, "&")
+	for i, param := range params {
+		raw, _, found := strings.Cut(param, "=")
+		name, err := url.QueryUnescape(raw)
+		if err != nil {
+			name = raw
+		}
+		if found && sensitiveParams[strings.ToLower(name)] {
+			params[i] = raw + "=REDACTED"
+		}
+	}
+	return uri + "?" + strings.Join(params, "&")
+}
+
+// logEscaper keeps request data from breaking the log's quoting or lines
+var logEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+// quoteLog quotes a log field, "-" when it is empty
+func quoteLog(s string) string {
+	if s == "" {
+		s = "-"
+	}
+	return `"` + logEscaper.Replace(s) + `"`
+}
+
+// accessEntryFrom returns the access log entry of the request, if it is logged
+func accessEntryFrom(ctx context.Context) *accessEntry {
+	entry, _ := ctx.Value(accessKey{}).(*accessEntry)
+	return entry
+}
@@ -0,0 +1,483 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kekePower/museweb/pkg/models"
+	"github.com/kekePower/museweb/pkg/snapshot"
+)
+
+// adminCookie holds the admin token for the browser UI so forms need not carry it
+const adminCookie = "museweb_admin"
+
+// previewCookie marks a browser session that may view draft prompts
+const previewCookie = "museweb_preview"
+
+// RegisterAdmin adds the token-protected /admin endpoints to mux. Nothing is
+// registered when no admin token is configured. Every path under /admin/
+// then checks the token, unknown ones included, so site-wide auth can leave
+// /admin/ to them.
+func (s *Server) RegisterAdmin(mux *http.ServeMux) {
+	if s.AdminToken == "" {
+		return
+	}
+	mux.HandleFunc("/admin/", s.requireAdmin(http.NotFound))
+	mux.HandleFunc("GET /admin/login", s.handleAdminLogin)
+	mux.HandleFunc("POST /admin/login", s.handleAdminLogin)
+	mux.HandleFunc("GET /admin/preview", s.requireAdmin(s.handlePreview))
+	if s.Audit != nil {
+		mux.HandleFunc("GET /admin/api/audit", s.requireAdmin(s.handleAuditList))
+	}
+	if s.Budget != nil {
+		mux.HandleFunc("GET /admin/api/budget", s.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, s.Budget.Status())
+		}))
+	}
+	if s.Usage != nil {
+		mux.HandleFunc("GET /admin/api/usage", s.requireAdmin(s.handleUsage))
+	}
+	if s.OllamaNodes != nil {
+		mux.HandleFunc("GET /admin/api/nodes", s.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, s.OllamaNodes.Status())
+		}))
+	}
+	if s.ShadowStatus() != nil {
+		mux.HandleFunc("GET /admin/api/shadow", s.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, s.ShadowStatus())
+		}))
+	}
+	mux.HandleFunc("GET /admin/info", s.requireAdmin(s.handleInfo))
+	mux.HandleFunc("GET /admin/api/model", s.requireAdmin(s.handleModelGet))
+	mux.HandleFunc("POST /admin/api/model", s.requireAdmin(s.handleModelSwap))
+	mux.HandleFunc("GET /admin/model", s.requireAdmin(s.handleModelGet))
+	mux.HandleFunc("POST /admin/model", s.requireAdmin(s.handleModelSwap))
+	if s.Reload != nil {
+		mux.HandleFunc("POST /admin/reload", s.requireAdmin(s.handleReload))
+		mux.HandleFunc("POST /admin/api/reload", s.requireAdmin(s.handleReload))
+	}
+	if s.Snapshots != nil {
+		mux.HandleFunc("GET /admin/snapshots", s.requireAdmin(s.handleSnapshotsUI))
+		mux.HandleFunc("GET /admin/snapshots/view", s.requireAdmin(s.handleSnapshotView))
+		mux.HandleFunc("GET /admin/api/snapshots", s.requireAdmin(s.handleSnapshotList))
+		mux.HandleFunc("POST /admin/api/snapshots/pin", s.requireAdmin(s.handleSnapshotPin))
+		mux.HandleFunc("POST /admin/api/snapshots/unpin", s.requireAdmin(s.handleSnapshotUnpin))
+		mux.HandleFunc("POST /admin/api/snapshots/rollback", s.requireAdmin(s.handleSnapshotRollback))
+	}
+}
+
+// requireAdmin rejects requests that do not carry the admin token, as a Bearer
+// token or in the cookie /admin/login sets for the browser UI. The token is
+// never taken from the URL, where it would end up in logs and browser history.
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if token == "" {
+			if c, err := r.Cookie(adminCookie); err == nil {
+				token = c.Value
+			}
+		}
+		if !s.isAdminToken(token) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="museweb-admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// isAdminToken reports whether token is the admin token
+func (s *Server) isAdminToken(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) == 1
+}
+
+// handleAdminLogin signs a browser in to the admin UI. GET shows a form, and
+// the token it posts is kept in a cookie before the browser goes on to next
+// (an /admin/ page, /admin/info by default).
+func (s *Server) handleAdminLogin(w http.ResponseWriter, r *http.Request) {
+	next := r.FormValue("next")
+	if !strings.HasPrefix(next, "/admin/") {
+		next = "/admin/info"
+	}
+	form := struct {
+		Next   string
+		Failed bool
+	}{Next: next}
+	if r.Method == http.MethodPost {
+		if s.isAdminToken(r.PostFormValue("token")) {
+			http.SetCookie(w, &http.Cookie{
+				Name:     adminCookie,
+				Value:    s.AdminToken,
+				Path:     "/admin",
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteStrictMode,
+			})
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+		form.Failed = true
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if form.Failed {
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	if err := adminLoginPage.Execute(w, form); err != nil {
+		log.Printf("Error rendering admin sign-in page: %v", err)
+	}
+}
+
+var adminLoginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Sign in | MuseWeb admin</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2rem; color: #222; }
+p.error { color: #b00020; }
+</style>
+</head>
+<body>
+<h1>MuseWeb admin</h1>
+{{if .Failed}}<p class="error">That is not the admin token.</p>{{end}}
+<form method="post" action="/admin/login">
+<input type="hidden" name="next" value="{{.Next}}">
+<label>Admin token <input type="password" name="token" autocomplete="current-password" autofocus></label>
+<button>Sign in</button>
+</form>
+</body>
+</html>
+`))
+
+// bearerToken returns the token from an "Authorization: Bearer" header, or ""
+func bearerToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// previewValue derives the preview cookie value from the admin token, so the
+// token itself is never stored in a site-wide cookie
+func (s *Server) previewValue() string {
+	mac := hmac.New(sha256.New, []byte(s.AdminToken))
+	mac.Write([]byte("museweb-preview"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// isPreview reports whether r may see draft prompts: it carries the admin
+// token or a preview cookie issued by /admin/preview
+func (s *Server) isPreview(r *http.Request) bool {
+	if s.AdminToken == "" {
+		return false
+	}
+	if token := bearerToken(r); token != "" {
+		return s.isAdminToken(token)
+	}
+	c, err := r.Cookie(previewCookie)
+	return err == nil && subtle.ConstantTimeCompare([]byte(c.Value), []byte(s.previewValue())) == 1
+}
+
+// handlePreview starts a draft preview session and redirects to ?route=
+// (the home page by default); ?end=1 ends the session instead
+func (s *Server) handlePreview(w http.ResponseWriter, r *http.Request) {
+	cookie := &http.Cookie{
+		Name:     previewCookie,
+		Value:    s.previewValue(),
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if r.URL.Query().Get("end") != "" {
+		cookie.Value = ""
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+	http.Redirect(w, r, "/"+strings.TrimPrefix(r.URL.Query().Get("route"), "/"), http.StatusSeeOther)
+}
+
+// audit records an admin action when the audit log is enabled
+func (s *Server) audit(r *http.Request, action, target, details string) {
+	if s.Audit == nil {
+		return
+	}
+	if err := s.Audit.Record("admin@"+r.RemoteAddr, action, target, details); err != nil {
+		log.Printf("⚠️  %v", err)
+	}
+}
+
+// handleAuditList returns the most recent audit entries (?limit=, default 100)
+func (s *Server) handleAuditList(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 100
+	}
+	entries, err := s.Audit.Recent(limit)
+	if err != nil {
+		http.Error(w, "Error reading audit log: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// handleUsage reports the token usage and cost of the last ?days= days
+// (all that are kept by default), per day, route, and model
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	days, _ := strconv.Atoi(r.URL.Query().Get("days"))
+	writeJSON(w, http.StatusOK, s.Usage.Report(days))
+}
+
+// publicSettings strips the API key from settings returned to clients
+func publicSettings(b BackendSettings) BackendSettings {
+	b.APIKey = ""
+	return b
+}
+
+// handleModelGet reports the backend and model new requests use
+func (s *Server) handleModelGet(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, publicSettings(s.Active()))
+}
+
+// handleModelSwap switches the active backend and/or model. The body is a JSON
+// BackendSettings; omitted fields keep their current value. A model the
+// backend does not list is refused unless ?force=1 is set. Caches and warm
+// pages are kept, so the site stays fast while the new model takes over.
+func (s *Server) handleModelSwap(w http.ResponseWriter, r *http.Request) {
+	var next BackendSettings
+	if err := json.NewDecoder(r.Body).Decode(&next); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	previous := s.Active()
+	if r.URL.Query().Get("force") == "" {
+		if err := s.checkSwapModel(r.Context(), previous, next); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	active, err := s.SwapBackend(next)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.audit(r, "model.swap", active.Backend+"/"+active.Model, "from "+previous.Backend+"/"+previous.Model)
+	writeJSON(w, http.StatusOK, map[string]BackendSettings{
+		"previous": publicSettings(previous),
+		"active":   publicSettings(active),
+	})
+}
+
+// handleReload re-reads the configuration. Pages already generating finish
+// with the settings they started with.
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if err := s.Reload(); err != nil {
+		http.Error(w, "Error reloading the configuration: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.audit(r, "config.reload", "", "")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"reloaded": true,
+		"active":   publicSettings(s.Active()),
+	})
+}
+
+// checkSwapModel refuses a swap to a model the backend does not have. When the
+// backend cannot be asked, the swap goes ahead with a warning.
+func (s *Server) checkSwapModel(ctx context.Context, previous, next BackendSettings) error {
+	target, err := s.resolveSwap(previous, next)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	err = models.CheckModel(ctx, target.Backend, target.Model, target.APIKey, target.APIBase)
+	switch {
+	case errors.Is(err, models.ErrModelNotFound):
+		return fmt.Errorf("the %s backend does not have model %q (add ?force=1 to switch anyway)", target.Backend, target.Model)
+	case err != nil:
+		log.Printf("⚠️  Could not check that %s/%s is available: %v", target.Backend, target.Model, err)
+	}
+	return nil
+}
+
+// snapshotEntry is the JSON view of one key's history
+type snapshotEntry struct {
+	Key      string             `json:"key"`
+	Pinned   string             `json:"pinned,omitempty"`
+	Versions []snapshot.Version `json:"versions"`
+}
+
+// snapshotEntries collects the history of every key, or just one when key is set
+func (s *Server) snapshotEntries(key string) ([]snapshotEntry, error) {
+	keys := []string{key}
+	if key == "" {
+		var err error
+		if keys, err = s.Snapshots.Keys(); err != nil {
+			return nil, err
+		}
+	}
+	entries := make([]snapshotEntry, 0, len(keys))
+	for _, k := range keys {
+		versions, pinned, err := s.Snapshots.List(k)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, snapshotEntry{Key: k, Pinned: pinned, Versions: versions})
+	}
+	return entries, nil
+}
+
+// handleSnapshotList returns the snapshot history as JSON (?key= limits it to one key)
+func (s *Server) handleSnapshotList(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.snapshotEntries(r.URL.Query().Get("key"))
+	if err != nil {
+		http.Error(w, "Error listing snapshots: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// handleSnapshotView serves the markup of one stored version
+func (s *Server) handleSnapshotView(w http.ResponseWriter, r *http.Request) {
+	html, err := s.Snapshots.Load(r.URL.Query().Get("key"), r.URL.Query().Get("id"))
+	if errors.Is(err, snapshot.ErrNotFound) {
+		http.Error(w, "Snapshot not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Error loading snapshot: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	w.Write(html)
+}
+
+// handleSnapshotPin pins ?key= to version ?id=
+func (s *Server) handleSnapshotPin(w http.ResponseWriter, r *http.Request) {
+	key, id := r.FormValue("key"), r.FormValue("id")
+	if err := s.Snapshots.Pin(key, id); err != nil {
+		snapshotError(w, err)
+		return
+	}
+	log.Printf("📌 Pinned /%s to snapshot %s", key, id)
+	s.audit(r, "snapshot.pin", key, id)
+	s.snapshotActionDone(w, r, map[string]string{"key": key, "pinned": id})
+}
+
+// handleSnapshotUnpin resumes live generation for ?key=
+func (s *Server) handleSnapshotUnpin(w http.ResponseWriter, r *http.Request) {
+	key := r.FormValue("key")
+	if err := s.Snapshots.Unpin(key); err != nil {
+		snapshotError(w, err)
+		return
+	}
+	log.Printf("📌 Unpinned /%s, resuming live generation", key)
+	s.audit(r, "snapshot.unpin", key, "")
+	s.snapshotActionDone(w, r, map[string]string{"key": key, "pinned": ""})
+}
+
+// handleSnapshotRollback pins ?key= to the version before the one currently served
+func (s *Server) handleSnapshotRollback(w http.ResponseWriter, r *http.Request) {
+	key := r.FormValue("key")
+	version, err := s.Snapshots.Rollback(key)
+	if err != nil {
+		snapshotError(w, err)
+		return
+	}
+	log.Printf("⏪ Rolled /%s back to snapshot %s", key, version.ID)
+	s.audit(r, "snapshot.rollback", key, version.ID)
+	s.snapshotActionDone(w, r, map[string]string{"key": key, "pinned": version.ID})
+}
+
+// snapshotActionDone answers API clients with JSON and sends UI form posts back to the list
+func (s *Server) snapshotActionDone(w http.ResponseWriter, r *http.Request, result map[string]string) {
+	if r.FormValue("ui") != "" {
+		http.Redirect(w, r, "/admin/snapshots", http.StatusSeeOther)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func snapshotError(w http.ResponseWriter, err error) {
+	if errors.Is(err, snapshot.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// writeJSON encodes v as the response body
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing JSON response: %v", err)
+	}
+}
+
+var snapshotsPage = template.Must(template.New("snapshots").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Snapshots | MuseWeb admin</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2rem; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2rem; }
+th, td { padding: .3rem .8rem; border-bottom: 1px solid #ddd; text-align: left; }
+tr.pinned { background: #fff6d5; }
+form { display: inline; }
+</style>
+</head>
+<body>
+<h1>Generation snapshots</h1>
+{{range .}}
+<h2>/{{.Key}}{{if .Pinned}} <small>📌 pinned</small>{{end}}</h2>
+<form method="post" action="/admin/api/snapshots/rollback"><input type="hidden" name="ui" value="1"><input type="hidden" name="key" value="{{.Key}}"><button>Roll back</button></form>
+{{if .Pinned}}<form method="post" action="/admin/api/snapshots/unpin"><input type="hidden" name="ui" value="1"><input type="hidden" name="key" value="{{.Key}}"><button>Unpin (serve live)</button></form>{{end}}
+<table>
+<tr><th>Version</th><th>Model</th><th>Size</th><th></th></tr>
+{{$key := .Key}}{{$pinned := .Pinned}}
+{{range .Versions}}
+<tr{{if eq .ID $pinned}} class="pinned"{{end}}>
+<td><a href="/admin/snapshots/view?key={{$key}}&amp;id={{.ID}}" target="_blank">{{.Created.Format "2006-01-02 15:04:05"}}</a></td>
+<td>{{.Model}}</td>
+<td>{{.Size}} B</td>
+<td>{{if ne .ID $pinned}}<form method="post" action="/admin/api/snapshots/pin"><input type="hidden" name="ui" value="1"><input type="hidden" name="key" value="{{$key}}"><input type="hidden" name="id" value="{{.ID}}"><button>Pin</button></form>{{end}}</td>
+</tr>
+{{end}}
+</table>
+{{else}}
+<p>No generations recorded yet.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// handleSnapshotsUI renders the snapshot admin page
+func (s *Server) handleSnapshotsUI(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.snapshotEntries("")
+	if err != nil {
+		http.Error(w, "Error listing snapshots: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := snapshotsPage.Execute(w, entries); err != nil {
+		log.Printf("Error rendering snapshots page: %v", err)
+	}
+}
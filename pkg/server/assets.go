@@ -0,0 +1,148 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// immutableCacheControl is sent with fingerprinted assets: their URL changes
+// whenever their content does, so browsers may keep them for a year
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// fingerprinted matches the hash Assets adds to file names, as in
+// "site.3f2a9c1b.css"
+var fingerprinted = regexp.MustCompile(`^(.+)\.([0-9a-f]{8})(\.[^./]+)$`)
+
+// Assets serves the static files of the public directories with cache
+// headers. With fingerprinting, pages link to assets by URLs carrying a hash
+// of their content (/css/site.3f2a9c1b.css), which are served as immutable.
+type Assets struct {
+	// Dirs are searched in order, e.g. the prompt set's public directory
+	// before the global one; change them with SetDirs once serving
+	Dirs []string
+	// MaxAge is the Cache-Control max-age of plain asset URLs (0 sends none)
+	MaxAge time.Duration
+	// ServeFile writes a file found in Dirs (http.ServeFile when nil)
+	ServeFile func(w http.ResponseWriter, r *http.Request, name string)
+
+	mu     sync.Mutex
+	hashes map[string]assetHash // By file path
+}
+
+// SetDirs changes the directories searched for assets
+func (a *Assets) SetDirs(dirs []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.Dirs = dirs
+}
+
+// assetHash is the content hash of a file as of its size and modification time
+type assetHash struct {
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+// find returns the file for a URL path such as "css/site.css"
+func (a *Assets) find(name string) (string, os.FileInfo, bool) {
+	name = path.Clean("/" + name)
+	a.mu.Lock()
+	dirs := a.Dirs
+	a.mu.Unlock()
+	for _, dir := range dirs {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if info, err := os.Stat(file); err == nil && !info.IsDir() {
+			return file, info, true
+		}
+	}
+	return "", nil, false
+}
+
+// hash returns the short content hash of file, computing it only when the
+// file changed since last time
+func (a *Assets) hash(file string, info os.FileInfo) (string, error) {
+	a.mu.Lock()
+	h, ok := a.hashes[file]
+	a.mu.Unlock()
+	if ok && h.size == info.Size() && h.modTime.Equal(info.ModTime()) {
+		return h.hash, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sum := sha256.New()
+	if _, err := io.Copy(sum, f); err != nil {
+		return "", err
+	}
+	h = assetHash{size: info.Size(), modTime: info.ModTime(), hash: hex.EncodeToString(sum.Sum(nil))[:8]}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.hashes == nil {
+		a.hashes = map[string]assetHash{}
+	}
+	a.hashes[file] = h
+	return h.hash, nil
+}
+
+// Fingerprint returns the fingerprinted URL of the asset at a root-relative
+// URL path ("/css/site.css" becomes "/css/site.3f2a9c1b.css"); ok is false
+// when no such file exists
+func (a *Assets) Fingerprint(urlPath string) (string, bool) {
+	file, info, ok := a.find(urlPath)
+	if !ok {
+		return "", false
+	}
+	hash, err := a.hash(file, info)
+	if err != nil {
+		return "", false
+	}
+	ext := path.Ext(urlPath)
+	return strings.TrimSuffix(urlPath, ext) + "." + hash + ext, true
+}
+
+// Serve writes the asset for a URL path such as "css/site.css" or
+// "css/site.3f2a9c1b.css" and reports whether there is one. A fingerprinted
+// URL is served as immutable only while its hash matches the file; after the
+// file changed, it gets the current content with the plain cache headers.
+func (a *Assets) Serve(w http.ResponseWriter, r *http.Request, name string) bool {
+	file, info, ok := a.find(name)
+	immutable := false
+	if !ok {
+		m := fingerprinted.FindStringSubmatch(name)
+		if m == nil {
+			return false
+		}
+		if file, info, ok = a.find(m[1] + m[3]); !ok {
+			return false
+		}
+		hash, err := a.hash(file, info)
+		immutable = err == nil && hash == m[2]
+	}
+
+	switch {
+	case immutable:
+		w.Header().Set("Cache-Control", immutableCacheControl)
+	case a.MaxAge > 0:
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(a.MaxAge.Seconds())))
+	}
+	if a.ServeFile != nil {
+		a.ServeFile(w, r, file)
+	} else {
+		http.ServeFile(w, r, file)
+	}
+	return true
+}
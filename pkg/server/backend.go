@@ -0,0 +1,230 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kekePower/museweb/pkg/models"
+)
+
+// BackendSettings identifies the model provider and model a page is generated with
+type BackendSettings struct {
+	Backend string `json:"backend"`
+	Model   string `json:"model"`
+	APIKey  string `json:"api_key,omitempty"`
+	APIBase string `json:"api_base,omitempty"`
+	// FirstByteTimeout overrides the failover first-byte timeout for a named backend
+	FirstByteTimeout time.Duration `json:"-"`
+}
+
+// Credentials holds the configured API key and base URL for one backend. They
+// are used when a runtime swap switches backends without supplying its own.
+type Credentials struct {
+	APIKey  string
+	APIBase string
+}
+
+// Active returns the backend settings new requests are generated with
+func (s *Server) Active() BackendSettings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return BackendSettings{Backend: s.Backend, Model: s.ModelName, APIKey: s.APIKey, APIBase: s.APIBase}
+}
+
+// ActivePromptsDir returns the directory prompts are read from
+func (s *Server) ActivePromptsDir() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.PromptsDir
+}
+
+// SetPromptsDir switches the directory prompts are read from at runtime.
+// Requests already generating keep the prompts they read.
+func (s *Server) SetPromptsDir(dir string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if dir != s.PromptsDir {
+		log.Printf("📁 Reading prompts from '%s' instead of '%s'", dir, s.PromptsDir)
+	}
+	s.PromptsDir = dir
+}
+
+// SwapBackend switches the active backend and/or model at runtime. Requests
+// already generating keep the settings they started with. Empty fields keep
+// their current value; when the backend changes, a missing API key or base
+// URL is taken from Credentials.
+func (s *Server) SwapBackend(next BackendSettings) (BackendSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous := BackendSettings{Backend: s.Backend, Model: s.ModelName, APIKey: s.APIKey, APIBase: s.APIBase}
+	next, err := s.resolveSwap(previous, next)
+	if err != nil {
+		return previous, err
+	}
+
+	s.Backend, s.ModelName, s.APIKey, s.APIBase = next.Backend, next.Model, next.APIKey, next.APIBase
+	log.Printf("🔀 Switched from %s/%s to %s/%s for new requests", previous.Backend, previous.Model, next.Backend, next.Model)
+	return next, nil
+}
+
+// resolveSwap fills in the settings a swap from previous to next leaves out
+// and checks the result
+func (s *Server) resolveSwap(previous, next BackendSettings) (BackendSettings, error) {
+	if next.Backend == "" {
+		next.Backend = previous.Backend
+	}
+	if err := checkBackend(next.Backend); err != nil {
+		return next, err
+	}
+
+	if next.Backend == previous.Backend {
+		if next.Model == "" {
+			next.Model = previous.Model
+		}
+		if next.APIKey == "" {
+			next.APIKey = previous.APIKey
+		}
+		if next.APIBase == "" {
+			next.APIBase = previous.APIBase
+		}
+	} else {
+		if next.Model == "" {
+			return next, fmt.Errorf("a model is required when switching to the %s backend", next.Backend)
+		}
+		creds := s.Credentials[next.Backend]
+		if next.APIKey == "" {
+			next.APIKey = creds.APIKey
+		}
+		if next.APIBase == "" {
+			next.APIBase = creds.APIBase
+		}
+	}
+	return next, s.checkCredentials(next)
+}
+
+// checkBackend reports an error for backend types MuseWeb doesn't know
+func checkBackend(backend string) error {
+	switch backend {
+	case "ollama", "openai", "azure-openai", "mistral", "groq", "vllm", "tgi", "anthropic", "bedrock", "llamacpp", "mock":
+		return nil
+	}
+	return fmt.Errorf("unknown backend %q (use ollama, openai, azure-openai, mistral, groq, vllm, tgi, anthropic, bedrock, llamacpp, or mock)", backend)
+}
+
+// checkCredentials reports an error when settings lack the key their backend needs
+func (s *Server) checkCredentials(settings BackendSettings) error {
+	switch settings.Backend {
+	case "ollama", "llamacpp", "vllm", "tgi", "mock":
+		return nil
+	case "bedrock":
+		if settings.APIKey == "" && s.AWSCredentials.AccessKeyID == "" {
+			return fmt.Errorf("the bedrock backend requires an API key or AWS access keys")
+		}
+		return nil
+	}
+	if settings.APIKey == "" {
+		return fmt.Errorf("the %s backend requires an API key", settings.Backend)
+	}
+	return nil
+}
+
+// AddBackend registers a named backend that prompts can select with
+// "backend: name" in their front matter. A missing API key or base URL is
+// taken from Credentials.
+func (s *Server) AddBackend(name string, settings BackendSettings) error {
+	if name == "" {
+		return fmt.Errorf("a backend name is required")
+	}
+	if err := checkBackend(settings.Backend); err != nil {
+		return err
+	}
+	if settings.Model == "" {
+		return fmt.Errorf("a model is required")
+	}
+	creds := s.Credentials[settings.Backend]
+	if settings.APIKey == "" {
+		settings.APIKey = creds.APIKey
+	}
+	if settings.APIBase == "" {
+		settings.APIBase = creds.APIBase
+	}
+	if err := s.checkCredentials(settings); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.backends[name]; exists {
+		return fmt.Errorf("backend %q is defined twice", name)
+	}
+	if s.backends == nil {
+		s.backends = map[string]BackendSettings{}
+	}
+	s.backends[name] = settings
+	return nil
+}
+
+// backendFor returns the settings for a prompt's named backend, or the active
+// backend when name is empty
+func (s *Server) backendFor(name string) (BackendSettings, error) {
+	if name == "" {
+		return s.Active(), nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	settings, ok := s.backends[name]
+	if !ok {
+		return BackendSettings{}, fmt.Errorf("unknown backend %q", name)
+	}
+	return settings, nil
+}
+
+// pageBackend returns the settings a page is generated with: its named
+// backend (or the active one), with the model its front matter picks
+func (s *Server) pageBackend(meta FrontMatter) (BackendSettings, error) {
+	settings, err := s.backendFor(meta.Backend)
+	if err == nil && meta.Model != "" {
+		settings.Model = meta.Model
+	}
+	return settings, err
+}
+
+// NamedBackends returns the backends registered with AddBackend
+func (s *Server) NamedBackends() map[string]BackendSettings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	named := make(map[string]BackendSettings, len(s.backends))
+	for name, settings := range s.backends {
+		named[name] = settings
+	}
+	return named
+}
+
+// newHandler creates the model handler for one generation
+func (s *Server) newHandler(active BackendSettings, opts models.Options) models.ModelHandler {
+	opts.Mock = s.Mock
+	opts.Timeouts = s.Timeouts
+	switch active.Backend {
+	case "ollama":
+		opts.Generate, _ = s.OllamaGenerate.Lookup(active.Model)
+		opts.Balancer = s.OllamaNodes
+	case "openai", "mistral", "groq", "vllm":
+		opts.ResponseAdapter = s.ResponseAdapter
+		opts.OpenRouter = s.OpenRouter
+		opts.Sampling = s.Sampling.Merge(opts.Sampling)
+	case "azure-openai":
+		opts.APIVersion = s.AzureAPIVersion
+		opts.ResponseAdapter = s.ResponseAdapter
+		opts.Sampling = s.Sampling.Merge(opts.Sampling)
+	case "bedrock":
+		opts.Region = s.BedrockRegion
+		opts.AWS = s.AWSCredentials
+	case "llamacpp":
+		opts.LlamaCpp = s.LlamaCpp
+	case "tgi":
+		opts.TGI = s.TGI
+	}
+	return models.NewModelHandlerWithOptions(active.Backend, active.Model, active.APIKey, active.APIBase, s.Debug, opts)
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Defaults for decoupling generations from slow clients
+const (
+	DefaultClientBuffer = 1 << 20 // 1 MiB, larger than nearly every generated page
+	DefaultStallTimeout = 30 * time.Second
+)
+
+// errClientStalled is returned once a client has not accepted data for the stall timeout
+var errClientStalled = errors.New("client stalled")
+
+// clientWriter lets the model run ahead of the client. The model writes into a
+// bounded buffer that a separate goroutine drains to the connection, so a slow
+// client does not hold the backend (e.g. an Ollama slot) for the whole
+// generation. The model only waits when the buffer is full, and a client that
+// accepts nothing for the stall timeout is dropped.
+type clientWriter struct {
+	rw    http.ResponseWriter
+	rc    *http.ResponseController
+	limit int
+	stall time.Duration
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	buf     bytes.Buffer
+	flush   bool  // A flush was requested since the last drain
+	closed  bool  // The model is done writing
+	err     error // First error writing to the client
+	done    chan struct{}
+	sent    int64
+	started time.Time
+}
+
+// newClientWriter starts draining to rw; limit is the buffer size in bytes
+func newClientWriter(rw http.ResponseWriter, limit int, stall time.Duration) *clientWriter {
+	cw := &clientWriter{
+		rw:      rw,
+		rc:      http.NewResponseController(rw),
+		limit:   limit,
+		stall:   stall,
+		done:    make(chan struct{}),
+		started: time.Now(),
+	}
+	cw.cond = sync.NewCond(&cw.mu)
+	go cw.drain()
+	return cw
+}
+
+// Write buffers p, waiting only while the buffer is full
+func (cw *clientWriter) Write(p []byte) (int, error) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	for cw.buf.Len() >= cw.limit && cw.err == nil {
+		cw.cond.Wait()
+	}
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	cw.buf.Write(p)
+	cw.cond.Broadcast()
+	return len(p), nil
+}
+
+// Flush implements http.Flusher; the drain goroutine flushes after its next write
+func (cw *clientWriter) Flush() {
+	cw.mu.Lock()
+	cw.flush = true
+	cw.cond.Broadcast()
+	cw.mu.Unlock()
+}
+
+// Close waits until everything buffered has reached the client and returns the
+// first write error. It reports how long the client lagged behind the model.
+func (cw *clientWriter) Close(route string, debug bool) error {
+	cw.mu.Lock()
+	cw.closed = true
+	cw.cond.Broadcast()
+	cw.mu.Unlock()
+
+	generated := time.Now()
+	<-cw.done
+	lag := time.Since(generated)
+
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if lag > time.Second || (debug && cw.sent > 0) {
+		elapsed := time.Since(cw.started).Seconds()
+		log.Printf("🐢 Client for /%s received %d bytes at %.1f KB/s, %v after the model finished",
+			route, cw.sent, float64(cw.sent)/1024/elapsed, lag.Round(time.Millisecond))
+	}
+	return cw.err
+}
+
+// drain copies buffered output to the client until the writer is closed
+func (cw *clientWriter) drain() {
+	defer close(cw.done)
+	for {
+		cw.mu.Lock()
+		for cw.buf.Len() == 0 && !cw.flush && !cw.closed {
+			cw.cond.Wait()
+		}
+		data := bytes.Clone(cw.buf.Bytes())
+		cw.buf.Reset()
+		flush := cw.flush || cw.closed
+		cw.flush = false
+		closed := cw.closed
+		cw.cond.Broadcast() // Room for the model again
+		cw.mu.Unlock()
+
+		if err := cw.send(data, flush); err != nil {
+			cw.mu.Lock()
+			cw.err = err
+			cw.cond.Broadcast()
+			cw.mu.Unlock()
+			return
+		}
+		if closed {
+			return
+		}
+	}
+}
+
+// send writes data to the client, failing when the client stalls
+func (cw *clientWriter) send(data []byte, flush bool) error {
+	// A rolling deadline replaces the server's WriteTimeout while streaming.
+	// Some writers (e.g. in tests) don't support deadlines; they never stall.
+	cw.rc.SetWriteDeadline(time.Now().Add(cw.stall))
+
+	if len(data) > 0 {
+		n, err := cw.rw.Write(data)
+		cw.mu.Lock()
+		cw.sent += int64(n)
+		cw.mu.Unlock()
+		if err != nil {
+			return cw.stallError(err)
+		}
+	}
+	if flush {
+		if err := cw.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return cw.stallError(err)
+		}
+	}
+	return nil
+}
+
+// stallError tells a stalled client apart from one that disconnected
+func (cw *clientWriter) stallError(err error) error {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("%w for %v: %v", errClientStalled, cw.stall, err)
+	}
+	return fmt.Errorf("client disconnected: %w", err)
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/kekePower/museweb/pkg/models"
+	"github.com/kekePower/museweb/pkg/snapshot"
+)
+
+// recordUsage returns the usage callback that charges generations for site by
+// model to the budget, or nil when budgets are disabled
+func (s *Server) recordUsage(site, model string) func(models.Usage) {
+	if s.Budget == nil {
+		return nil
+	}
+	return func(u models.Usage) {
+		if err := s.Budget.RecordModel(site, model, u.PromptTokens, u.CompletionTokens); err != nil {
+			log.Printf("⚠️  %v", err)
+		}
+		if s.Debug {
+			log.Printf("💰 Charged %d tokens to the budget (estimated: %v)", u.Total(), u.Estimated)
+		}
+	}
+}
+
+// UsageTrailer carries the tokens and cost of a generated page, as in
+// "prompt=812 completion=2304 cost=0.004210"; " estimated" is appended when
+// the backend reported no usage and the counts were approximated
+const UsageTrailer = "X-MuseWeb-Usage"
+
+// sumUsage wraps a usage callback so the generation's tokens are summed into
+// spent, when they are accounted
+func (s *Server) sumUsage(spent *models.Usage, next func(models.Usage)) func(models.Usage) {
+	if s.Usage == nil {
+		return next
+	}
+	return func(u models.Usage) {
+		spent.PromptTokens += u.PromptTokens
+		spent.CompletionTokens += u.CompletionTokens
+		spent.Estimated = spent.Estimated || u.Estimated
+		if next != nil {
+			next(u)
+		}
+	}
+}
+
+// accountUsage logs the tokens a generation of req used, adds them to the
+// usage ledger, and reports them in the usage trailer
+func (s *Server) accountUsage(client io.Writer, req PageRequest, used BackendSettings, u models.Usage) {
+	if s.Usage == nil || u.Total() == 0 {
+		return
+	}
+	cost, err := s.Usage.Record(req.Route, used.Model, u.PromptTokens, u.CompletionTokens)
+	if err != nil {
+		log.Printf("⚠️  %v", err)
+	}
+	estimated := ""
+	if u.Estimated {
+		estimated = " estimated"
+	}
+	log.Printf("🧮 /%s used %d prompt + %d completion tokens%s on %s/%s (cost %.4f)", req.Route, u.PromptTokens, u.CompletionTokens, estimated, used.Backend, used.Model, cost)
+	if rw, ok := client.(http.ResponseWriter); ok && s.UsageHeader {
+		rw.Header().Set(UsageTrailer, fmt.Sprintf("prompt=%d completion=%d cost=%.6f%s", u.PromptTokens, u.CompletionTokens, cost, estimated))
+	}
+}
+
+// overBudget serves req without calling the backend once the budget is
+// exhausted: the latest snapshot of the page when there is one, otherwise the
+// configured fallback page or a short notice. It returns false when the budget
+// still allows a generation.
+func (s *Server) overBudget(w io.Writer, flusher http.Flusher, req PageRequest, p prompts) (bool, error) {
+	if s.Budget == nil {
+		return false, nil
+	}
+	err := s.Budget.Check(req.Site)
+	if err == nil {
+		return false, nil
+	}
+	log.Printf("💸 Not generating /%s: %v", req.Route, err)
+
+	rw, isHTTP := w.(http.ResponseWriter)
+	if isHTTP {
+		rw.Header().Set("X-MuseWeb-Budget", "exhausted")
+	}
+	if s.serveLatestSnapshot(rw, w, flusher, req, p) {
+		return true, nil
+	}
+
+	// Programmatic callers (GraphQL) get the error itself
+	if !isHTTP {
+		return true, err
+	}
+	rw.Header().Set("Retry-After", "3600")
+	rw.Header().Set("Cache-Control", "no-store")
+	if !p.HTML {
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(rw, "This page can't be generated right now: the site's generation budget is used up.")
+		return true, nil
+	}
+	rw.WriteHeader(http.StatusServiceUnavailable)
+	if s.BudgetFallback != nil {
+		rw.Write(s.BudgetFallback)
+	} else {
+		budgetPage.Execute(rw, nil)
+	}
+	flusher.Flush()
+	return true, nil
+}
+
+// serveLatestSnapshot writes the most recent generation of req's page, if the
+// history has one. rw, when not nil, gets the snapshot header.
+func (s *Server) serveLatestSnapshot(rw http.ResponseWriter, w io.Writer, flusher http.Flusher, req PageRequest, p prompts) bool {
+	if !s.recordsSnapshot(req, p) {
+		return false
+	}
+	html, version, err := s.Snapshots.Latest(snapshot.Key(req.Route, req.Lang))
+	if err != nil {
+		return false
+	}
+	if rw != nil {
+		rw.Header().Set("X-MuseWeb-Snapshot", version.ID)
+	}
+	w.Write(html)
+	flusher.Flush()
+	return true
+}
+
+// budgetPage is shown when a page can't be generated and has no snapshot,
+// unless the site has its own fallback page
+var budgetPage = template.Must(template.New("budget").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Taking a break</title>
+<style>body{font-family:system-ui,sans-serif;max-width:36rem;margin:15vh auto;padding:0 1rem;color:#333;line-height:1.5}</style>
+</head>
+<body>
+<h1>Taking a break</h1>
+<p>This site writes its pages with an AI model, and it has used up its generation budget for now. Please come back later.</p>
+<p><a href="/">Home</a></p>
+</body>
+</html>
+`))
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kekePower/museweb/pkg/tracing"
+)
+
+// PageCache stores generated pages by key. ResponseCache keeps them in this
+// process; a shared driver (see pkg/redis) lets several instances behind a
+// load balancer serve each other's pages.
+type PageCache interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, html []byte) error
+}
+
+// PageExpirer is implemented by caches that can keep a page for a time of
+// its own, for prompts with cache_ttl in their front matter; other caches
+// keep every page for their configured time
+type PageExpirer interface {
+	// SetWithTTL caches a page for ttl, or the configured time when it is 0
+	SetWithTTL(ctx context.Context, key string, html []byte, ttl time.Duration) error
+}
+
+// PageLocker is implemented by shared caches that coalesce generations across
+// instances: the instance holding a page's lock generates it while the others
+// wait for it to appear in the cache
+type PageLocker interface {
+	// Lock claims key for ttl; it reports false when another instance holds it
+	Lock(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	Unlock(ctx context.Context, key string) error
+}
+
+// StalePageCache is implemented by shared caches that keep pages a while
+// after they expire. While one instance regenerates an expired page, the
+// others serve its stale copy instead of waiting.
+type StalePageCache interface {
+	// GetStale returns the last page cached for key, expired or not
+	GetStale(ctx context.Context, key string) ([]byte, bool, error)
+}
+
+// Waiting on another instance's generation
+const (
+	sharedLockTTL = 5 * time.Minute // Longest a claim outlives a crashed instance
+	sharedPoll    = 500 * time.Millisecond
+)
+
+// ResponseCache keeps generated pages in memory, so a page is generated once
+// per prompt, model, language, and user input until it expires. Editing a
+// prompt file changes its hash, so stale pages are never served after a
+// change. The least recently used pages are dropped when the cache is full.
+type ResponseCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	order   *list.List // Most recently used first
+	entries map[string]*list.Element
+}
+
+// pageKey identifies a generated page
+type pageKey struct {
+	prompt [32]byte // Hash of the assembled system and user prompts
+	model  string   // Backend and model, e.g. "openai/gpt-4o"
+	lang   string
+	input  [32]byte // Hash of the user input
+}
+
+// String returns the key as a hash, the same in every instance
+func (k pageKey) String() string {
+	h := sha256.New()
+	h.Write(k.prompt[:])
+	io.WriteString(h, k.model)
+	h.Write([]byte{0})
+	io.WriteString(h, k.lang)
+	h.Write([]byte{0})
+	h.Write(k.input[:])
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+type cacheEntry struct {
+	key     string
+	html    []byte
+	expires time.Time
+}
+
+// NewResponseCache creates a cache holding up to size pages for ttl each
+func NewResponseCache(size int, ttl time.Duration) *ResponseCache {
+	return &ResponseCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+// Get returns the cached page for key, if it has not expired
+func (c *ResponseCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := el.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, false, nil
+	}
+	c.order.MoveToFront(el)
+	return entry.html, true, nil
+}
+
+// Set caches a page, dropping the least recently used one when full
+func (c *ResponseCache) Set(ctx context.Context, key string, html []byte) error {
+	return c.SetWithTTL(ctx, key, html, 0)
+}
+
+// SetWithTTL caches a page for ttl, or the cache's TTL when it is 0
+func (c *ResponseCache) SetWithTTL(ctx context.Context, key string, html []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ttl <= 0 {
+		ttl = c.ttl
+	}
+	expires := time.Now().Add(ttl)
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		entry.html, entry.expires = html, expires
+		c.order.MoveToFront(el)
+		return nil
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, html: html, expires: expires})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+	return nil
+}
+
+// Resize changes how many pages the cache holds and for how long. Cached
+// pages keep their expiry; the least recently used ones are dropped when the
+// cache shrinks.
+func (c *ResponseCache) Resize(size int, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size, c.ttl = size, ttl
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Len returns the number of cached pages, including expired ones not yet dropped
+func (c *ResponseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// pageKeyFor returns the key of req's page, shared by identical requests.
+// Pages that change between requests (stored values), show the signed-in
+// user, carry images, or come from a one-off model override have none: they
+// are never cached or shared.
+func (s *Server) pageKeyFor(req PageRequest, p prompts) (pageKey, bool) {
+	if req.Preview || req.Model != "" || p.Dynamic || p.Personal || len(req.Images) > 0 || len(p.Images) > 0 {
+		return pageKey{}, false
+	}
+	active, err := s.pageBackend(p.Meta)
+	if err != nil {
+		return pageKey{}, false
+	}
+	h := sha256.New()
+	io.WriteString(h, p.System)
+	h.Write([]byte{0})
+	io.WriteString(h, p.User)
+	// Front matter sampling changes the page as much as its prompt does
+	if t := p.Meta.Temperature; t != nil {
+		fmt.Fprintf(h, "\x00temperature=%g", *t)
+	}
+	if p.Meta.MaxTokens > 0 {
+		fmt.Fprintf(h, "\x00max_tokens=%d", p.Meta.MaxTokens)
+	}
+	key := pageKey{model: active.Backend + "/" + active.Model, lang: req.Lang, input: sha256.Sum256([]byte(req.Input))}
+	copy(key.prompt[:], h.Sum(nil))
+	return key, true
+}
+
+// serveCached writes the cached page for key, if there is one
+func (s *Server) serveCached(ctx context.Context, w io.Writer, flusher http.Flusher, req PageRequest, key pageKey) bool {
+	html, ok, err := s.Cache.Get(ctx, key.String())
+	if err != nil {
+		log.Printf("⚠️  Could not read the cached /%s: %v", req.Route, err)
+		return false
+	}
+	s.Metrics.observeCache(ok)
+	if !ok {
+		return false
+	}
+	if rw, ok := w.(http.ResponseWriter); ok {
+		rw.Header().Set("X-MuseWeb-Cache", "hit")
+	}
+	tracing.FromContext(ctx).AddEvent("cache hit")
+	if s.Debug {
+		log.Printf("🗃️  Serving cached /%s (lang %q)", req.Route, req.Lang)
+	}
+	w.Write(html)
+	flusher.Flush()
+	return true
+}
+
+// storeCached caches a completed generation, for ttl when the cache supports
+// it and ttl is set
+func (s *Server) storeCached(ctx context.Context, req PageRequest, key pageKey, html []byte, ttl time.Duration) {
+	if len(bytes.TrimSpace(html)) == 0 {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	var err error
+	if expirer, ok := s.Cache.(PageExpirer); ok && ttl > 0 {
+		err = expirer.SetWithTTL(ctx, key.String(), bytes.Clone(html), ttl)
+	} else {
+		err = s.Cache.Set(ctx, key.String(), bytes.Clone(html))
+	}
+	if err != nil {
+		log.Printf("⚠️  Could not cache /%s: %v", req.Route, err)
+	}
+}
+
+// awaitShared claims the generation of key when the cache is shared between
+// instances. While another instance holds the claim, it returns the stale
+// copy of the page if the cache kept one, else waits for that instance's page
+// and returns it instead. Without a shared cache, or when the cache cannot
+// be reached, the page is generated here unclaimed.
+func (s *Server) awaitShared(ctx context.Context, req PageRequest, key string) (html []byte, stale, claimed bool, err error) {
+	locker, ok := s.Cache.(PageLocker)
+	if !ok {
+		return nil, false, false, nil
+	}
+	waiting := false
+	for {
+		claimed, err := locker.Lock(ctx, key, sharedLockTTL)
+		if err != nil {
+			log.Printf("⚠️  Could not claim /%s in the shared cache, generating it anyway: %v", req.Route, err)
+			return nil, false, false, nil
+		}
+		if claimed && waiting {
+			// The other instance may have finished just now
+			if html, ok, err := s.Cache.Get(ctx, key); err == nil && ok {
+				s.releaseShared(ctx, req, key)
+				return html, false, false, nil
+			}
+		}
+		if claimed {
+			return nil, false, true, nil
+		}
+		if !waiting {
+			if cache, ok := s.Cache.(StalePageCache); ok {
+				html, ok, err := cache.GetStale(ctx, key)
+				if err != nil {
+					log.Printf("⚠️  Could not read the stale /%s: %v", req.Route, err)
+				} else if ok {
+					if s.Debug {
+						log.Printf("🧲 Serving stale /%s while another instance regenerates it", req.Route)
+					}
+					return html, true, false, nil
+				}
+			}
+			if s.Debug {
+				log.Printf("🧲 Waiting for another instance generating /%s", req.Route)
+			}
+		}
+		waiting = true
+
+		select {
+		case <-ctx.Done():
+			return nil, false, false, ctx.Err()
+		case <-time.After(sharedPoll):
+		}
+		if html, ok, err := s.Cache.Get(ctx, key); err == nil && ok {
+			return html, false, false, nil
+		}
+	}
+}
+
+// releaseShared gives up a claim taken by awaitShared
+func (s *Server) releaseShared(ctx context.Context, req PageRequest, key string) {
+	if err := s.Cache.(PageLocker).Unlock(context.WithoutCancel(ctx), key); err != nil {
+		log.Printf("⚠️  Could not release the claim on /%s: %v", req.Route, err)
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/kekePower/museweb/pkg/models"
+)
+
+// LoadCapabilities asks the providers of the active and named backends what
+// their models can do (context window, vision, tools, ...) and records the
+// answers in the capability registry. Providers that cannot say are skipped.
+// It returns how many models were described.
+func (s *Server) LoadCapabilities(ctx context.Context) int {
+	settings := []BackendSettings{s.Active()}
+	for _, b := range s.NamedBackends() {
+		settings = append(settings, b)
+	}
+
+	loaded := 0
+	seen := map[string]bool{}
+	for _, b := range settings {
+		key := b.Backend + "|" + b.APIBase + "|" + b.Model
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		fetchCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		caps, err := models.FetchCapabilities(fetchCtx, b.Backend, b.Model, b.APIKey, b.APIBase)
+		cancel()
+		if errors.Is(err, models.ErrNoCapabilities) {
+			continue
+		}
+		if err != nil {
+			log.Printf("⚠️  Could not ask %s about %s: %v", b.Backend, b.Model, err)
+			continue
+		}
+		if err := models.SetCapabilities(b.Model, caps); err != nil {
+			log.Printf("⚠️  %v", err)
+			continue
+		}
+		if s.Debug {
+			log.Printf("[DEBUG] %s/%s: context window %d, vision %v, tools %v, reasoning %q", b.Backend, b.Model, caps.ContextWindow, caps.Vision, caps.Tools, caps.Reasoning)
+		}
+		loaded++
+	}
+	return loaded
+}
+
+// warnOversized logs when a prompt likely does not fit the model's context
+// window, so a truncated or failed page is not a mystery
+func (s *Server) warnOversized(settings BackendSettings, systemPrompt, userPrompt string) {
+	caps, ok := models.LookupCapabilities(settings.Model)
+	if !ok {
+		return
+	}
+	if tokens, over := caps.Overflows(systemPrompt, userPrompt); over {
+		log.Printf("📏 The prompt for %s/%s is about %d tokens, too much for its %d-token context window", settings.Backend, settings.Model, tokens, caps.ContextWindow)
+	}
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultFlightTimeout bounds shared generations when Timeouts.Total is unset
+const defaultFlightTimeout = 10 * time.Minute
+
+// flights tracks the generations in progress, so identical requests arriving
+// while a page is being generated share that generation instead of starting
+// their own. The zero value is ready to use.
+type flights struct {
+	mu      sync.Mutex
+	pending map[pageKey]*flight
+}
+
+// flight is one generation shared by identical requests. Everything it
+// writes is kept, so requests joining late still get the whole page. The
+// generation outlives its leader's client as long as followers wait for it.
+type flight struct {
+	mu      sync.Mutex
+	buf     []byte
+	done    bool
+	err     error         // Why the generation failed, if it did
+	changed chan struct{} // Closed and replaced on every write
+
+	followers  int
+	leaderGone bool               // The leader's client went away
+	cancel     context.CancelFunc // Stops the generation (see detach)
+}
+
+// join returns the flight generating key and whether the caller leads it.
+// The leader generates the page, writes it to the flight, and lands it.
+func (fs *flights) join(key pageKey) (*flight, bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if f, ok := fs.pending[key]; ok {
+		return f, false
+	}
+	if fs.pending == nil {
+		fs.pending = map[pageKey]*flight{}
+	}
+	f := &flight{changed: make(chan struct{})}
+	fs.pending[key] = f
+	return f, true
+}
+
+// land ends the flight with the generation's error, if any; requests
+// arriving from now on start a new one
+func (fs *flights) land(key pageKey, f *flight, err error) {
+	fs.mu.Lock()
+	delete(fs.pending, key)
+	fs.mu.Unlock()
+
+	f.mu.Lock()
+	f.done, f.err = true, err
+	close(f.changed)
+	f.mu.Unlock()
+}
+
+// detach returns the context the leader generates with. It is not cancelled
+// with ctx while followers still wait for the page, only when nobody is left
+// to serve or after timeout. stop releases it.
+func (f *flight) detach(ctx context.Context, timeout time.Duration) (gen context.Context, stop func()) {
+	gen, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	f.mu.Lock()
+	f.cancel = cancel
+	f.mu.Unlock()
+	unwatch := context.AfterFunc(ctx, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.leaderGone = true
+		if f.followers == 0 {
+			cancel()
+		}
+	})
+	return gen, func() {
+		unwatch()
+		cancel()
+	}
+}
+
+// flightTimeout bounds a shared generation
+func (s *Server) flightTimeout() time.Duration {
+	if s.Timeouts.Total > 0 {
+		return s.Timeouts.Total
+	}
+	return defaultFlightTimeout
+}
+
+// Write passes generated output on to the followers
+func (f *flight) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.buf = append(f.buf, p...)
+	close(f.changed)
+	f.changed = make(chan struct{})
+	return len(p), nil
+}
+
+// follow copies the flight's output to w as it is generated, until the
+// flight lands or ctx is done. It returns the number of bytes written, and
+// an error when the generation failed after some of it was sent.
+func (f *flight) follow(ctx context.Context, w io.Writer, flusher http.Flusher) (int, error) {
+	f.mu.Lock()
+	f.followers++
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.followers--
+		if f.followers == 0 && f.leaderGone && f.cancel != nil {
+			f.cancel()
+		}
+	}()
+
+	sent := 0
+	for {
+		f.mu.Lock()
+		data, done, ferr, changed := f.buf[sent:], f.done, f.err, f.changed
+		f.mu.Unlock()
+
+		if len(data) > 0 {
+			n, err := w.Write(data)
+			sent += n
+			if err != nil {
+				return sent, err
+			}
+			flusher.Flush()
+			continue
+		}
+		if done {
+			if ferr != nil && sent > 0 {
+				return sent, fmt.Errorf("shared generation failed: %w", ferr)
+			}
+			return sent, nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return sent, ctx.Err()
+		}
+	}
+}
+
+// followFlight serves req from the generation of an identical request. When
+// that generation ends without output (it failed, and its client got a
+// fallback page instead), req is generated on its own; when it fails midway,
+// so does req.
+func (s *Server) followFlight(ctx context.Context, w io.Writer, flusher http.Flusher, req PageRequest, p prompts, f *flight) error {
+	if s.Debug {
+		log.Printf("🧲 Sharing the generation of /%s already in progress", req.Route)
+	}
+	rw, isHTTP := w.(http.ResponseWriter)
+	if isHTTP {
+		rw.Header().Set("X-MuseWeb-Cache", "coalesced")
+	}
+	sent, err := f.follow(ctx, w, flusher)
+	if err != nil {
+		return err
+	}
+	if sent == 0 {
+		if isHTTP {
+			rw.Header().Del("X-MuseWeb-Cache")
+		}
+		return s.stream(ctx, w, flusher, req, p)
+	}
+	req.info.served("coalesced")
+	return nil
+}
+
+// leaderClient writes to the client of a flight's leader until the client
+// goes away, then drops the writes, so the generation carries on for the
+// followers
+type leaderClient struct {
+	w    io.Writer
+	ctx  context.Context // The client's request
+	gone bool
+}
+
+func (c *leaderClient) Write(p []byte) (int, error) {
+	if !c.gone {
+		if n, err := c.w.Write(p); err != nil {
+			if c.ctx.Err() == nil {
+				return n, err
+			}
+			c.gone = true
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFlightsJoin(t *testing.T) {
+	var fs flights
+	a, b := pageKey{lang: "en"}, pageKey{lang: "de"}
+	f, lead := fs.join(a)
+	if !lead {
+		t.Fatal("the first request does not lead")
+	}
+	if g, lead := fs.join(a); lead || g != f {
+		t.Error("an identical request does not share the flight")
+	}
+	if g, lead := fs.join(b); !lead || g == f {
+		t.Error("another page shares the flight")
+	}
+	fs.land(a, f, nil)
+	if g, lead := fs.join(a); !lead || g == f {
+		t.Error("a landed flight is still shared")
+	}
+}
+
+func TestFlightFollow(t *testing.T) {
+	failure := errors.New("backend failed")
+	for _, tc := range []struct {
+		name   string
+		before []string // Written before the follower joins
+		after  []string // Written while it follows
+		err    error    // What the generation ends with
+		want   string
+		fails  bool
+	}{
+		{name: "late joiner gets everything", before: []string{"<html>", "<body>"}, after: []string{"</body></html>"}, want: "<html><body></body></html>"},
+		{name: "joined before any output", after: []string{"<p>a</p>", "<p>b</p>"}, want: "<p>a</p><p>b</p>"},
+		{name: "failed midway", before: []string{"<html>"}, after: []string{"<body>"}, err: failure, want: "<html><body>", fails: true},
+		{name: "failed before any output", err: failure, want: ""},
+	} {
+		var fs flights
+		key := pageKey{model: tc.name}
+		f, _ := fs.join(key)
+		for _, chunk := range tc.before {
+			f.Write([]byte(chunk))
+		}
+		type result struct {
+			n   int
+			err error
+		}
+		var out bytes.Buffer
+		done := make(chan result)
+		go func() {
+			n, err := f.follow(context.Background(), &out, discardFlusher{})
+			done <- result{n, err}
+		}()
+		waitFor(t, func() bool { f.mu.Lock(); defer f.mu.Unlock(); return f.followers == 1 })
+		for _, chunk := range tc.after {
+			f.Write([]byte(chunk))
+		}
+		fs.land(key, f, tc.err)
+		r := <-done
+		if out.String() != tc.want || r.n != len(tc.want) {
+			t.Errorf("%s: followed %q (%d bytes), want %q", tc.name, out.String(), r.n, tc.want)
+		}
+		if (r.err != nil) != tc.fails || tc.fails && !errors.Is(r.err, failure) {
+			t.Errorf("%s: err = %v", tc.name, r.err)
+		}
+	}
+
+	// A follower whose client leaves stops following
+	var fs flights
+	f, _ := fs.join(pageKey{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := f.follow(ctx, &bytes.Buffer{}, discardFlusher{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("follow with a cancelled client: %v", err)
+	}
+}
+
+func TestFlightDetach(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		followers int
+		timeout   time.Duration
+		leave     bool // The followers leave after the leader's client
+		alive     bool // Whether the generation still runs in the end
+	}{
+		{name: "alone", followers: 0, timeout: time.Minute, alive: false},
+		{name: "followers waiting", followers: 2, timeout: time.Minute, alive: true},
+		{name: "followers gone too", followers: 2, timeout: time.Minute, leave: true, alive: false},
+		{name: "timed out", followers: 1, timeout: time.Millisecond, alive: false},
+	} {
+		var fs flights
+		f, _ := fs.join(pageKey{})
+		client, leave := context.WithCancel(context.Background())
+		gen, stop := f.detach(client, tc.timeout)
+
+		followers, unfollow := context.WithCancel(context.Background())
+		for i := 0; i < tc.followers; i++ {
+			go f.follow(followers, &bytes.Buffer{}, discardFlusher{})
+		}
+		waitFor(t, func() bool { f.mu.Lock(); defer f.mu.Unlock(); return f.followers == tc.followers })
+		leave()
+		waitFor(t, func() bool { f.mu.Lock(); defer f.mu.Unlock(); return f.leaderGone })
+		if tc.leave {
+			unfollow()
+			waitFor(t, func() bool { f.mu.Lock(); defer f.mu.Unlock(); return f.followers == 0 })
+		}
+		if tc.timeout < time.Second {
+			time.Sleep(10 * tc.timeout)
+		}
+		if alive := gen.Err() == nil; alive != tc.alive {
+			t.Errorf("%s: generation running = %v, want %v", tc.name, alive, tc.alive)
+		}
+		stop()
+		unfollow()
+		if gen.Err() == nil {
+			t.Errorf("%s: generation still running after stop", tc.name)
+		}
+	}
+}
+
+func TestLeaderClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &failingWriter{}
+	c := &leaderClient{w: w, ctx: ctx}
+	if _, err := c.Write([]byte("<html>")); err != nil || w.String() != "<html>" {
+		t.Fatalf("write to a connected client: %v, %q", err, w.String())
+	}
+	w.fail = true
+	if _, err := c.Write([]byte("<body>")); err == nil {
+		t.Error("a write error of a connected client was swallowed")
+	}
+	cancel()
+	if n, err := c.Write([]byte("<body>")); err != nil || n != 6 {
+		t.Errorf("write after the client left: %d, %v", n, err)
+	}
+	w.fail = false
+	c.Write([]byte("</body>"))
+	if strings.Contains(w.String(), "</body>") {
+		t.Error("writes went on after the client left")
+	}
+}
+
+// failingWriter fails writes while fail is set
+type failingWriter struct {
+	bytes.Buffer
+	fail bool
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.fail {
+		return 0, errors.New("broken pipe")
+	}
+	return w.Buffer.Write(p)
+}
+
+// waitFor waits up to a second for cond to hold
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting")
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// PromptInput is what a page's prompts are composed from. The prompt file
+// has already been read, its front matter checked, and any scheduled variant
+// applied.
+type PromptInput struct {
+	Request     PageRequest
+	Meta        FrontMatter
+	Page        string // The page prompt, without front matter
+	ContentType string // Media type of the output
+	HTML        bool   // Whether the output is an HTML page
+}
+
+// PromptComposer turns a page prompt into the system and user prompts sent
+// to the model. Pages with {{kv}} or {{user}} placeholders are composed
+// again when generated, with the placeholders filled in.
+type PromptComposer interface {
+	Compose(in PromptInput) (system, user string, err error)
+}
+
+// ComposerOptions configure the built-in composers
+type ComposerOptions struct {
+	ExamplesDir string // Few-shot: example pages, relative to the prompts directory
+	MaxExamples int    // Few-shot: how many examples to include (0 = all)
+}
+
+// ComposerFactory builds a composer. base is the default composer, for
+// composers that only change part of the prompts.
+type ComposerFactory func(s *Server, base PromptComposer, opts ComposerOptions) (PromptComposer, error)
+
+// DefaultComposer is the composer used when none is configured
+const DefaultComposer = "default"
+
+var (
+	composersMu sync.RWMutex
+	composers   = map[string]ComposerFactory{
+		DefaultComposer: func(s *Server, base PromptComposer, opts ComposerOptions) (PromptComposer, error) {
+			return base, nil
+		},
+		"few-shot": newFewShotComposer,
+	}
+)
+
+// RegisterComposer makes a composer available by name, replacing any
+// composer registered under the same name
+func RegisterComposer(name string, factory ComposerFactory) {
+	composersMu.Lock()
+	defer composersMu.Unlock()
+	composers[name] = factory
+}
+
+// SetComposer switches prompt composition to the composer registered as name
+func (s *Server) SetComposer(name string, opts ComposerOptions) error {
+	composersMu.RLock()
+	factory, ok := composers[name]
+	names := make([]string, 0, len(composers))
+	for n := range composers {
+		names = append(names, n)
+	}
+	composersMu.RUnlock()
+	if !ok {
+		sort.Strings(names)
+		return fmt.Errorf("unknown prompt composer %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	composer, err := factory(s, defaultComposer{s}, opts)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.promptComposer = composer
+	return nil
+}
+
+// composer returns the configured composer, or the default one
+func (s *Server) composer() PromptComposer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.promptComposer == nil {
+		return defaultComposer{s}
+	}
+	return s.promptComposer
+}
+
+// defaultComposer builds the system prompt from system_prompt.txt and the
+// layout, and the user prompt from the page prompt, the user's input, and
+// the site's instructions (robots, base URL, navigation, language)
+type defaultComposer struct {
+	s *Server
+}
+
+// Compose implements PromptComposer
+func (c defaultComposer) Compose(in PromptInput) (string, string, error) {
+	s, req, meta := c.s, in.Request, in.Meta
+
+	// Load the system prompt from system_prompt.txt
+	systemPromptPath := filepath.Join(s.ActivePromptsDir(), "system_prompt.txt")
+	var systemPrompt string
+
+	// Check if system_prompt.txt exists
+	if _, err := os.Stat(systemPromptPath); !os.IsNotExist(err) {
+		// Read the system prompt file
+		systemPromptData, err := os.ReadFile(systemPromptPath)
+		if err != nil {
+			log.Printf("Warning: Error reading system_prompt.txt: %v", err)
+		} else {
+			systemPrompt = string(systemPromptData)
+		}
+	} else {
+		log.Printf("Warning: system_prompt.txt not found in %s", s.ActivePromptsDir())
+	}
+
+	// Check for layout files
+	layoutMinPath := filepath.Join(s.ActivePromptsDir(), "layout.min.txt")
+	layoutPath := filepath.Join(s.ActivePromptsDir(), "layout.txt")
+	var layoutContent string
+
+	// Use the page's own layout, else try layout.min.txt, then fall back to layout.txt
+	if meta.Layout != "" {
+		// "none" leaves the layout out
+		if meta.Layout != "none" {
+			layoutData, err := os.ReadFile(filepath.Join(s.ActivePromptsDir(), meta.Layout))
+			if err != nil {
+				return "", "", fmt.Errorf("layout: %w", err)
+			}
+			layoutContent = string(layoutData)
+		}
+	} else if _, err := os.Stat(layoutMinPath); !os.IsNotExist(err) {
+		layoutData, err := os.ReadFile(layoutMinPath)
+		if err == nil {
+			layoutContent = string(layoutData)
+		}
+	} else if _, err := os.Stat(layoutPath); !os.IsNotExist(err) {
+		layoutData, err := os.ReadFile(layoutPath)
+		if err == nil {
+			layoutContent = string(layoutData)
+		}
+	}
+
+	// If we have a layout, append it to the system prompt
+	if layoutContent != "" {
+		if systemPrompt != "" {
+			systemPrompt += "\n\n" + layoutContent
+		} else {
+			systemPrompt = layoutContent
+		}
+	}
+
+	userPrompt := in.Page
+
+	// Append user input (e.g. from POST data) if available
+	if req.Input != "" {
+		userPrompt += "\n\n" + s.InputFilter.Format(req.Input)
+	}
+
+	// Non-HTML output gets its own system prompt instead of the HTML rules and layout
+	if !in.HTML {
+		systemPrompt = rawSystemPrompt(in.ContentType)
+	}
+
+	// Ask for a robots meta tag; the SEO pass enforces it when enabled
+	if in.HTML && (meta.NoIndex || meta.Draft) {
+		userPrompt += "\n\nInclude <meta name=\"robots\" content=\"noindex, nofollow\"> in the <head>."
+	}
+
+	// Absolute URLs must use the public address, not one the model invents
+	if in.HTML && s.BaseURL != "" {
+		userPrompt += fmt.Sprintf("\n\nThe site's public URL is %s. Use it for every absolute URL (canonical link, og:url, og:image); internal links may stay relative.", s.BaseURL)
+	}
+
+	// Give the model the site navigation so menus match across pages
+	if in.HTML && s.Navigation {
+		userPrompt += s.navigationPrompt(req.Route)
+	}
+
+	// Add translation instruction if language parameter is provided
+	if req.Lang != "" {
+		// Validate and clean the language parameter (basic sanitization)
+		langParam := strings.TrimSpace(req.Lang)
+		if len(langParam) > 0 && len(langParam) <= 10 && s.Translator != nil && in.HTML {
+			// The external translator translates the finished page; only the links need the language
+			userPrompt += fmt.Sprintf("\n\n**VERY IMPORTANT:** Add ?lang=%s to all generated URLs to preserve the language context.", langParam)
+		} else if len(langParam) > 0 && len(langParam) <= 10 { // Reasonable length limit
+			translationInstruction := fmt.Sprintf("\n\nTranslate all the content to %s.\n**VERY IMPORTANT:** DO NOT TRANSLATE ANY OF THE URLS IN THE NAVBAR. Keep the links as they are.\n**VERY IMPORTANT:** Add ?lang=%s to all generated URLs to preserve the language context.", langParam, langParam)
+			userPrompt += translationInstruction
+			if s.Debug {
+				log.Printf("🌐 Added translation instruction: %s", translationInstruction)
+			}
+		} else if s.Debug {
+			log.Printf("⚠️  Invalid language parameter ignored: %s", langParam)
+		}
+	}
+
+	return systemPrompt, userPrompt, nil
+}
+
+// fewShotComposer adds finished example pages to the system prompt of HTML
+// pages, so the model follows the site's style and structure more closely
+type fewShotComposer struct {
+	base PromptComposer
+	dir  string
+	max  int
+}
+
+// newFewShotComposer reads examples from opts.ExamplesDir ("examples" when empty)
+func newFewShotComposer(s *Server, base PromptComposer, opts ComposerOptions) (PromptComposer, error) {
+	dir := opts.ExamplesDir
+	if dir == "" {
+		dir = "examples"
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(s.ActivePromptsDir(), dir)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("few-shot composer: %s is not a directory", dir)
+	}
+	return fewShotComposer{base: base, dir: dir, max: opts.MaxExamples}, nil
+}
+
+// Compose implements PromptComposer. Examples are read on every request, like
+// the prompts, and a page's own example (<route>.html) is left out.
+func (c fewShotComposer) Compose(in PromptInput) (string, string, error) {
+	system, user, err := c.base.Compose(in)
+	if err != nil || !in.HTML {
+		return system, user, err
+	}
+	paths, err := filepath.Glob(filepath.Join(c.dir, "*.html"))
+	if err != nil {
+		return "", "", err
+	}
+	sort.Strings(paths)
+
+	var b strings.Builder
+	n := 0
+	for _, path := range paths {
+		if c.max > 0 && n == c.max {
+			break
+		}
+		if strings.TrimSuffix(filepath.Base(path), ".html") == in.Request.Route {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("⚠️  Could not read example %s: %v", path, err)
+			continue
+		}
+		fmt.Fprintf(&b, "\n\n<example>\n%s\n</example>", strings.TrimSpace(string(data)))
+		n++
+	}
+	if n == 0 {
+		return system, user, nil
+	}
+	system += "\n\nFinished pages from this site follow. Match their style and structure, but never copy their content." + b.String()
+	return system, user, nil
+}
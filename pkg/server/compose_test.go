@@ -0,0 +1,172 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePrompts writes files into a new prompts directory
+func writePrompts(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestDefaultComposer(t *testing.T) {
+	dir := writePrompts(t, map[string]string{
+		"system_prompt.txt": "SYSTEM",
+		"layout.txt":        "LAYOUT",
+		"layout.min.txt":    "LAYOUT-MIN",
+		"layouts/blog.txt":  "LAYOUT-BLOG",
+	})
+	s := New("ollama", "test-model", dir, "", "", false)
+
+	for _, tc := range []struct {
+		name       string
+		in         PromptInput
+		configure  func(*Server)
+		system     string
+		user       []string // Parts the user prompt must contain
+		userAbsent []string
+	}{
+		{
+			name:   "minified layout first",
+			in:     PromptInput{Page: "PAGE", HTML: true},
+			system: "SYSTEM\n\nLAYOUT-MIN",
+			user:   []string{"PAGE"},
+		},
+		{
+			name:   "the page's own layout",
+			in:     PromptInput{Page: "PAGE", HTML: true, Meta: FrontMatter{Layout: "layouts/blog.txt"}},
+			system: "SYSTEM\n\nLAYOUT-BLOG",
+		},
+		{
+			name:   "no layout",
+			in:     PromptInput{Page: "PAGE", HTML: true, Meta: FrontMatter{Layout: "none"}},
+			system: "SYSTEM",
+		},
+		{
+			name:       "non-HTML output",
+			in:         PromptInput{Page: "PAGE", ContentType: "application/json; charset=utf-8", Meta: FrontMatter{NoIndex: true}},
+			system:     rawSystemPrompt("application/json"),
+			userAbsent: []string{"robots", "public URL"},
+		},
+		{
+			name:   "input, noindex, and base URL",
+			in:     PromptInput{Page: "PAGE", HTML: true, Meta: FrontMatter{Draft: true}, Request: PageRequest{Input: "hello"}},
+			system: "SYSTEM\n\nLAYOUT-MIN",
+			configure: func(s *Server) {
+				s.BaseURL = "https://example.com"
+			},
+			user: []string{"PAGE\n\nUser Input: hello", `content="noindex, nofollow"`, "The site's public URL is https://example.com."},
+		},
+		{
+			name:   "language",
+			in:     PromptInput{Page: "PAGE", HTML: true, Request: PageRequest{Lang: " de "}},
+			system: "SYSTEM\n\nLAYOUT-MIN",
+			user:   []string{"Translate all the content to de.", "Add ?lang=de to all generated URLs"},
+		},
+		{
+			name:       "overlong language",
+			in:         PromptInput{Page: "PAGE", HTML: true, Request: PageRequest{Lang: "not-a-language-code"}},
+			system:     "SYSTEM\n\nLAYOUT-MIN",
+			userAbsent: []string{"Translate", "?lang="},
+		},
+	} {
+		s.BaseURL = ""
+		if tc.configure != nil {
+			tc.configure(s)
+		}
+		system, user, err := defaultComposer{s}.Compose(tc.in)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if system != tc.system {
+			t.Errorf("%s: system prompt %q, want %q", tc.name, system, tc.system)
+		}
+		if !strings.HasPrefix(user, tc.in.Page) {
+			t.Errorf("%s: user prompt %q does not start with the page prompt", tc.name, user)
+		}
+		for _, want := range tc.user {
+			if !strings.Contains(user, want) {
+				t.Errorf("%s: user prompt %q lacks %q", tc.name, user, want)
+			}
+		}
+		for _, unwanted := range tc.userAbsent {
+			if strings.Contains(user, unwanted) {
+				t.Errorf("%s: user prompt %q has %q", tc.name, user, unwanted)
+			}
+		}
+	}
+
+	if _, _, err := (defaultComposer{s}).Compose(PromptInput{HTML: true, Meta: FrontMatter{Layout: "missing.txt"}}); err == nil {
+		t.Error("a missing layout was ignored")
+	}
+}
+
+func TestFewShotComposer(t *testing.T) {
+	dir := writePrompts(t, map[string]string{
+		"system_prompt.txt":   "SYSTEM",
+		"examples/about.html": "<p>about</p>\n",
+		"examples/blog.html":  "<p>blog</p>",
+		"examples/home.html":  "<p>home</p>",
+		"examples/notes.txt":  "not an example",
+	})
+	s := New("ollama", "test-model", dir, "", "", false)
+
+	for _, tc := range []struct {
+		name     string
+		max      int
+		in       PromptInput
+		want     []string
+		unwanted []string
+	}{
+		{"all examples", 0, PromptInput{HTML: true, Request: PageRequest{Route: "contact"}},
+			[]string{"<example>\n<p>about</p>\n</example>", "<p>blog</p>", "<p>home</p>"}, []string{"not an example"}},
+		{"not the page's own", 0, PromptInput{HTML: true, Request: PageRequest{Route: "home"}},
+			[]string{"<p>about</p>", "<p>blog</p>"}, []string{"<p>home</p>"}},
+		{"at most max, in order", 1, PromptInput{HTML: true, Request: PageRequest{Route: "contact"}},
+			[]string{"<p>about</p>"}, []string{"<p>blog</p>", "<p>home</p>"}},
+		{"not for other output", 0, PromptInput{ContentType: "text/plain", Request: PageRequest{Route: "contact"}},
+			nil, []string{"<example>", "Finished pages"}},
+	} {
+		c, err := newFewShotComposer(s, defaultComposer{s}, ComposerOptions{MaxExamples: tc.max})
+		if err != nil {
+			t.Fatal(err)
+		}
+		system, _, err := c.Compose(tc.in)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(system, want) {
+				t.Errorf("%s: system prompt %q lacks %q", tc.name, system, want)
+			}
+		}
+		for _, unwanted := range tc.unwanted {
+			if strings.Contains(system, unwanted) {
+				t.Errorf("%s: system prompt %q has %q", tc.name, system, unwanted)
+			}
+		}
+	}
+
+	if _, err := newFewShotComposer(s, defaultComposer{s}, ComposerOptions{ExamplesDir: "missing"}); err == nil {
+		t.Error("a missing examples directory was accepted")
+	}
+	if err := s.SetComposer("no-such-composer", ComposerOptions{}); err == nil || !strings.Contains(err.Error(), "available: default, few-shot") {
+		t.Errorf("unknown composer: %v", err)
+	}
+}
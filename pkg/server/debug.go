@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// RuntimeStats is what /debug/stats reports about the running server
+type RuntimeStats struct {
+	Uptime      string `json:"uptime"`
+	Goroutines  int    `json:"goroutines"`
+	HeapAlloc   uint64 `json:"heap_alloc_bytes"`
+	HeapInuse   uint64 `json:"heap_inuse_bytes"`
+	HeapObjects uint64 `json:"heap_objects"`
+	Sys         uint64 `json:"sys_bytes"`
+	NumGC       uint32 `json:"gc_cycles"`
+	LastGCPause string `json:"last_gc_pause"`
+	Streams     int64  `json:"active_streams"`      // Backend calls in progress
+	Running     int    `json:"generations_running"` // Under the generation limit
+	Queued      int    `json:"generations_queued"`  // Waiting for a generation slot
+	Requests    int64  `json:"requests"`
+	Generations int64  `json:"generations"`
+	Failures    int64  `json:"failures"`
+}
+
+// Stats returns the current runtime stats of the server
+func (s *Server) Stats() RuntimeStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats := RuntimeStats{
+		Uptime:      time.Since(s.started).Round(time.Second).String(),
+		Goroutines:  runtime.NumGoroutine(),
+		HeapAlloc:   mem.HeapAlloc,
+		HeapInuse:   mem.HeapInuse,
+		HeapObjects: mem.HeapObjects,
+		Sys:         mem.Sys,
+		NumGC:       mem.NumGC,
+		LastGCPause: time.Duration(mem.PauseNs[(mem.NumGC+255)%256]).String(),
+		Streams:     s.streams.Load(),
+		Requests:    s.requests.Load(),
+		Generations: s.generations.Load(),
+		Failures:    s.failures.Load(),
+	}
+	if s.Limit != nil {
+		stats.Running, stats.Queued = s.Limit.Running(), s.Limit.Queued()
+	}
+	return stats
+}
+
+// RegisterDebug adds the Go profiler under /debug/pprof/ and the runtime
+// stats at /debug/stats to mux. With an admin token configured, both need it.
+func (s *Server) RegisterDebug(mux *http.ServeMux) {
+	protect := func(h http.HandlerFunc) http.HandlerFunc {
+		if s.AdminToken == "" {
+			return h
+		}
+		return s.requireAdmin(h)
+	}
+	mux.HandleFunc("GET /debug/pprof/", protect(pprof.Index))
+	mux.HandleFunc("GET /debug/pprof/cmdline", protect(pprof.Cmdline))
+	mux.HandleFunc("GET /debug/pprof/profile", protect(pprof.Profile))
+	mux.HandleFunc("GET /debug/pprof/symbol", protect(pprof.Symbol))
+	mux.HandleFunc("POST /debug/pprof/symbol", protect(pprof.Symbol))
+	mux.HandleFunc("GET /debug/pprof/trace", protect(pprof.Trace))
+	mux.HandleFunc("GET /debug/stats", protect(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Stats())
+	}))
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/kekePower/museweb/pkg/models"
+)
+
+// errEmptyGeneration is returned when the model produced no content, even after a retry
+var errEmptyGeneration = errors.New("the model returned no content")
+
+// generate streams the page's generation to w, retrying once when the model
+// finishes without any content. Leading whitespace is held back, so an empty
+// generation never commits a response and a fallback can still be served.
+func (s *Server) generate(ctx context.Context, w io.Writer, flusher http.Flusher, req PageRequest, p prompts, active BackendSettings, opts models.Options) (BackendSettings, error) {
+	for attempt := 1; ; attempt++ {
+		cw := &contentWriter{w: w, flusher: flusher}
+		used, err := s.generateWithFailover(ctx, cw, cw, p.Meta.Backend, active, opts, p.System, p.User)
+		if err != nil || cw.wrote || ctx.Err() != nil {
+			return used, err
+		}
+		if attempt == 2 {
+			return used, errEmptyGeneration
+		}
+		log.Printf("🫙 %s/%s returned no content for /%s, retrying once", used.Backend, used.Model, req.Route)
+	}
+}
+
+// serveEmpty answers a request whose generation stayed empty: with the latest
+// snapshot of the page when there is one, otherwise a short notice instead of
+// an empty 200. client is the writer stream was called with; w and flusher
+// may wrap it.
+func (s *Server) serveEmpty(client, w io.Writer, flusher http.Flusher, req PageRequest, p prompts) error {
+	log.Printf("🫙 Generation for /%s returned no content twice, serving a fallback", req.Route)
+	return s.serveFailure(client, w, flusher, req, p, http.StatusServiceUnavailable, errEmptyGeneration,
+		"This site writes its pages with an AI model, and this time it came back empty-handed.")
+}
+
+// serveFailure answers a request whose generation failed before sending
+// anything: with the latest snapshot of the page when there is one, otherwise
+// a notice with status explaining why. Programmatic callers (GraphQL) get err.
+func (s *Server) serveFailure(client, w io.Writer, flusher http.Flusher, req PageRequest, p prompts, status int, err error, reason string) error {
+	rw, isHTTP := client.(http.ResponseWriter)
+	if s.serveLatestSnapshot(rw, w, flusher, req, p) {
+		return nil
+	}
+	if !isHTTP {
+		return err
+	}
+	rw.Header().Set("Retry-After", "30")
+	rw.Header().Set("Cache-Control", "no-store")
+	if !p.HTML {
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		rw.WriteHeader(status)
+		fmt.Fprintln(w, "This page could not be generated. Please try again in a moment.")
+		flusher.Flush()
+		return nil
+	}
+	rw.WriteHeader(status)
+	failedPage.Execute(w, reason)
+	flusher.Flush()
+	return nil
+}
+
+// contentWriter holds back output, and flushes, until something other than
+// whitespace arrives
+type contentWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+	pending []byte // Leading whitespace
+	wrote   bool
+}
+
+// Write implements io.Writer
+func (c *contentWriter) Write(p []byte) (int, error) {
+	if !c.wrote {
+		if len(bytes.TrimSpace(p)) == 0 {
+			c.pending = append(c.pending, p...)
+			return len(p), nil
+		}
+		c.wrote = true
+		if len(c.pending) > 0 {
+			if _, err := c.w.Write(c.pending); err != nil {
+				return 0, err
+			}
+			c.pending = nil
+		}
+	}
+	return c.w.Write(p)
+}
+
+// Flush implements http.Flusher
+func (c *contentWriter) Flush() {
+	if c.wrote {
+		c.flusher.Flush()
+	}
+}
+
+// failedPage is shown when a generation fails and the page has no snapshot
+var failedPage = template.Must(template.New("failed").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Page not written</title>
+<style>body{font-family:system-ui,sans-serif;max-width:36rem;margin:15vh auto;padding:0 1rem;color:#333;line-height:1.5}</style>
+</head>
+<body>
+<h1>Page not written</h1>
+<p>{{.}} Please try again in a moment.</p>
+<p><a href="">Try again</a> · <a href="/">Home</a></p>
+</body>
+</html>
+`))
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/kekePower/museweb/pkg/models"
+	"github.com/kekePower/museweb/pkg/tracing"
+)
+
+// errFirstByteTimeout cancels an attempt whose backend sent nothing in time
+var errFirstByteTimeout = errors.New("no response before the first-byte timeout")
+
+// serveTimeout answers a request whose backends all missed the first-byte
+// timeout, instead of leaving the client with an empty page
+func (s *Server) serveTimeout(client, w io.Writer, flusher http.Flusher, req PageRequest, p prompts, err error) error {
+	log.Printf("⏱️  No backend answered in time for /%s (%v), serving a fallback", req.Route, err)
+	return s.serveFailure(client, w, flusher, req, p, http.StatusGatewayTimeout, err,
+		"This site writes its pages with an AI model, and the model took too long to start writing this one.")
+}
+
+// SetFailover configures the named backends tried, in order, when a page's
+// own backend fails before sending anything. timeout is the default time to
+// first byte for every attempt (0 waits indefinitely); named backends can set
+// their own.
+func (s *Server) SetFailover(names []string, timeout time.Duration) error {
+	for _, name := range names {
+		if _, err := s.backendFor(name); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failover = names
+	s.firstByteTimeout = timeout
+	return nil
+}
+
+// RetryPolicy retries a backend that fails with a transient error (a rate
+// limit or server error) before sending anything, before failing over
+type RetryPolicy struct {
+	Attempts  int           // Retries after the first try (0 = none)
+	BaseDelay time.Duration // Delay before the first retry, doubled for each one after
+	MaxDelay  time.Duration // Cap on a single delay (0 = no cap)
+}
+
+// delay returns the wait before retry n (from 0): exponential, with jitter
+// so that instances that failed together do not retry together
+func (p RetryPolicy) delay(n int) time.Duration {
+	d := p.BaseDelay << n
+	if p.MaxDelay > 0 && (d > p.MaxDelay || d <= 0) {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// attempt is one backend in a failover chain
+type attempt struct {
+	name     string // "" for the active backend
+	settings BackendSettings
+}
+
+// failoverChain returns the backends to try for a page using the named backend
+func (s *Server) failoverChain(name string, primary BackendSettings) []attempt {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	chain := []attempt{{name: name, settings: primary}}
+	for _, next := range s.failover {
+		if next != name {
+			chain = append(chain, attempt{name: next, settings: s.backends[next]})
+		}
+	}
+	return chain
+}
+
+// generateWithFailover streams a generation from the first backend in the
+// chain that answers. A backend that fails or misses its first-byte timeout
+// before writing anything is skipped, after s.Retry retries for transient
+// errors; once output has reached w, errors are returned as-is. It returns the settings of the backend that was used.
+func (s *Server) generateWithFailover(ctx context.Context, w io.Writer, flusher http.Flusher, backend string, primary BackendSettings, opts models.Options, systemPrompt, userPrompt string) (BackendSettings, error) {
+	chain := s.failoverChain(backend, primary)
+	s.mu.RLock()
+	defaultTimeout := s.firstByteTimeout
+	s.mu.RUnlock()
+
+	var err error
+	for i, a := range chain {
+		timeout := a.settings.FirstByteTimeout
+		if timeout == 0 {
+			timeout = defaultTimeout
+		}
+
+		var wrote bool
+		for retry := 0; ; retry++ {
+			wrote, err = s.tryBackend(ctx, w, flusher, a.settings, timeout, opts, systemPrompt, userPrompt)
+			if err == nil || wrote || ctx.Err() != nil || retry == s.Retry.Attempts || !models.IsTransient(err) {
+				break
+			}
+			delay := s.Retry.delay(retry)
+			if s.Debug {
+				log.Printf("🔁 %s/%s failed before responding (%v), retry %d of %d in %s", a.settings.Backend, a.settings.Model, err, retry+1, s.Retry.Attempts, delay.Round(time.Millisecond))
+			}
+			select {
+			case <-ctx.Done():
+				return a.settings, ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+
+		if err == nil || wrote || ctx.Err() != nil || i == len(chain)-1 {
+			return a.settings, err
+		}
+		next := chain[i+1].settings
+		log.Printf("⚠️  %s/%s failed before responding (%v), failing over to %s/%s", a.settings.Backend, a.settings.Model, err, next.Backend, next.Model)
+	}
+	return chain[len(chain)-1].settings, err
+}
+
+// tryBackend runs one generation on settings, cancelling it when nothing
+// arrives within timeout. It reports whether any output was written.
+func (s *Server) tryBackend(ctx context.Context, w io.Writer, flusher http.Flusher, settings BackendSettings, timeout time.Duration, opts models.Options, systemPrompt, userPrompt string) (bool, error) {
+	s.warnOversized(settings, systemPrompt, userPrompt)
+	spanCtx, span := tracing.Start(ctx, "backend.call", tracing.String("museweb.backend", settings.Backend), tracing.String("museweb.model", settings.Model))
+	defer span.End()
+	attemptCtx, cancel := context.WithCancelCause(spanCtx)
+	defer cancel(nil)
+	start := time.Now()
+	fw := &firstByteWriter{w: w}
+	var timer *time.Timer
+	if timeout > 0 {
+		timer = time.AfterFunc(timeout, func() { cancel(errFirstByteTimeout) })
+	}
+	fw.onFirst = func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		s.Metrics.observeFirstToken(spanCtx, settings, start)
+		span.AddEvent("first token")
+	}
+	opts.OnUsage = s.Metrics.countTokens(settings, traceUsage(span, opts.OnUsage))
+	s.streams.Add(1)
+	defer s.streams.Add(-1)
+	err := s.newHandler(settings, opts).StreamResponse(attemptCtx, fw, flusher, systemPrompt, userPrompt)
+	if !fw.wrote && errors.Is(context.Cause(attemptCtx), errFirstByteTimeout) {
+		err = fmt.Errorf("%w (%v)", errFirstByteTimeout, timeout)
+	}
+	s.Metrics.observeStream(ctx, settings, start, err)
+	span.RecordError(err)
+	return fw.wrote, err
+}
+
+// traceUsage wraps a usage callback so the generation's tokens are recorded
+// on its span
+func traceUsage(span *tracing.Span, next func(models.Usage)) func(models.Usage) {
+	if span == nil {
+		return next
+	}
+	return func(u models.Usage) {
+		span.SetAttributes(tracing.Int("gen_ai.usage.input_tokens", u.PromptTokens), tracing.Int("gen_ai.usage.output_tokens", u.CompletionTokens))
+		if next != nil {
+			next(u)
+		}
+	}
+}
+
+// firstByteWriter records whether anything was written and reports the first
+// write. Handlers write from the goroutine that called them, so no locking.
+type firstByteWriter struct {
+	w       io.Writer
+	onFirst func()
+	wrote   bool
+}
+
+// Write implements io.Writer
+func (fw *firstByteWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 && !fw.wrote {
+		fw.wrote = true
+		if fw.onFirst != nil {
+			fw.onFirst()
+		}
+	}
+	return fw.w.Write(p)
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kekePower/museweb/pkg/models"
+)
+
+// fakeBackend is an OpenAI-compatible backend for failover tests
+type fakeBackend struct {
+	*httptest.Server
+	calls atomic.Int32
+}
+
+// newFakeBackend serves chat completions: it waits delay, streams chunks,
+// and then either ends the stream or, with breakAfter, drops the connection
+// after that many chunks. A status other than 200 fails the request instead.
+func newFakeBackend(t *testing.T, status int, delay time.Duration, breakAfter int, chunks ...string) *fakeBackend {
+	b := &fakeBackend{}
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.calls.Add(1)
+		io.Copy(io.Discard, r.Body) // So the server notices the client leaving
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		if status != http.StatusOK {
+			http.Error(w, `{"error":{"message":"backend failed"}}`, status)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i, chunk := range chunks {
+			if breakAfter > 0 && i == breakAfter {
+				conn, _, _ := http.NewResponseController(w).Hijack()
+				conn.Close()
+				return
+			}
+			event, _ := json.Marshal(map[string]interface{}{
+				"choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]string{"content": chunk}}},
+			})
+			fmt.Fprintf(w, "data: %s\n\n", event)
+			w.(http.Flusher).Flush()
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(b.Close)
+	return b
+}
+
+func TestGenerateWithFailover(t *testing.T) {
+	page := []string{"<html>", "<p>backup</p>", "</html>"}
+	for _, tc := range []struct {
+		name           string
+		primary        func(t *testing.T) *fakeBackend
+		timeout        time.Duration // First-byte timeout of every attempt
+		backupTimeout  time.Duration // The backup's own, when set
+		want           string
+		wantBackend    string
+		fails          bool
+		wantBackupCall bool
+	}{
+		{
+			name:           "failed before the first byte",
+			primary:        func(t *testing.T) *fakeBackend { return newFakeBackend(t, http.StatusInternalServerError, 0, 0) },
+			want:           "<html><p>backup</p></html>",
+			wantBackend:    "backup-model",
+			wantBackupCall: true,
+		},
+		{
+			name:           "refused the request",
+			primary:        func(t *testing.T) *fakeBackend { return newFakeBackend(t, http.StatusBadRequest, 0, 0) },
+			want:           "<html><p>backup</p></html>",
+			wantBackend:    "backup-model",
+			wantBackupCall: true,
+		},
+		{
+			name: "failed after output started",
+			primary: func(t *testing.T) *fakeBackend {
+				return newFakeBackend(t, http.StatusOK, 0, 2, "<html>", "<p>primary", "</p></html>")
+			},
+			want:        "<html><p>primary",
+			wantBackend: "primary-model",
+			fails:       true,
+		},
+		{
+			name: "missed the first-byte timeout",
+			primary: func(t *testing.T) *fakeBackend {
+				return newFakeBackend(t, http.StatusOK, 5*time.Second, 0, "<p>late</p>")
+			},
+			timeout:        50 * time.Millisecond,
+			want:           "<html><p>backup</p></html>",
+			wantBackend:    "backup-model",
+			wantBackupCall: true,
+		},
+		{
+			name:        "answered within the timeout",
+			primary:     func(t *testing.T) *fakeBackend { return newFakeBackend(t, http.StatusOK, 0, 0, "<p>primary</p>") },
+			timeout:     time.Second,
+			want:        "<p>primary</p>",
+			wantBackend: "primary-model",
+		},
+		{
+			name: "every backend missed its timeout",
+			primary: func(t *testing.T) *fakeBackend {
+				return newFakeBackend(t, http.StatusOK, 5*time.Second, 0, "<p>late</p>")
+			},
+			timeout:        50 * time.Millisecond,
+			backupTimeout:  time.Millisecond,
+			wantBackend:    "backup-model",
+			fails:          true,
+			wantBackupCall: true,
+		},
+	} {
+		primary := tc.primary(t)
+		backupDelay := time.Duration(0)
+		if tc.backupTimeout > 0 {
+			backupDelay = 5 * time.Second
+		}
+		backup := newFakeBackend(t, http.StatusOK, backupDelay, 0, page...)
+
+		s := New("openai", "primary-model", t.TempDir(), "test-key", primary.URL, false)
+		s.ResponseAdapter = "openai"
+		if err := s.AddBackend("backup", BackendSettings{Backend: "openai", Model: "backup-model", APIKey: "test-key", APIBase: backup.URL, FirstByteTimeout: tc.backupTimeout}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SetFailover([]string{"backup"}, tc.timeout); err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+		var out bytes.Buffer
+		used, err := s.generateWithFailover(context.Background(), &out, discardFlusher{}, "", s.Active(), models.Options{}, "system", "user")
+		if (err != nil) != tc.fails {
+			t.Errorf("%s: err = %v", tc.name, err)
+		}
+		if tc.fails && tc.timeout > 0 && !strings.Contains(fmt.Sprint(err), errFirstByteTimeout.Error()) {
+			t.Errorf("%s: err = %v, want the first-byte timeout", tc.name, err)
+		}
+		if got := out.String(); got != tc.want {
+			t.Errorf("%s: output %q, want %q", tc.name, got, tc.want)
+		}
+		if used.Model != tc.wantBackend {
+			t.Errorf("%s: generated with %s, want %s", tc.name, used.Model, tc.wantBackend)
+		}
+		if called := backup.calls.Load() > 0; called != tc.wantBackupCall {
+			t.Errorf("%s: backup called = %v, want %v", tc.name, called, tc.wantBackupCall)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("%s: took %v", tc.name, elapsed)
+		}
+	}
+}
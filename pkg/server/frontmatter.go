@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/kekePower/museweb/pkg/models"
+	"gopkg.in/yaml.v3"
+)
+
+// FrontMatter holds per-route settings from an optional YAML block at the top
+// of a prompt file:
+//
+//	---
+//	draft: true
+//	noindex: true
+//	---
+//	Create a page about...
+type FrontMatter struct {
+	// Draft prompts are only served to admins and preview sessions
+	Draft bool `yaml:"draft"`
+	// NoIndex keeps search engines away via X-Robots-Tag and a robots meta tag
+	NoIndex bool `yaml:"noindex"`
+	// Login pages are for signed-in visitors only (see Server.Users)
+	Login bool `yaml:"login"`
+	// ReasoningEffort and ThinkingBudget override the configured reasoning controls
+	ReasoningEffort string `yaml:"reasoning_effort"`
+	ThinkingBudget  int    `yaml:"thinking_budget"`
+	// OllamaOptions override ollama.options (num_ctx, temperature, keep_alive, ...) for this page
+	OllamaOptions models.OllamaOptions `yaml:"ollama_options"`
+	// ContentType declares non-HTML output such as application/json or text/calendar
+	ContentType string `yaml:"content_type"`
+	// Backend names one of the configured backends to generate the page with
+	Backend string `yaml:"backend"`
+	// Model replaces the model of the page's backend, e.g. a larger one for
+	// long pages
+	Model string `yaml:"model"`
+	// Temperature and MaxTokens override the configured sampling for this
+	// page on OpenAI-compatible backends and Ollama (as num_predict)
+	Temperature *float64 `yaml:"temperature"`
+	MaxTokens   int      `yaml:"max_tokens"`
+	// CacheTTL keeps the page in the response cache for this many seconds
+	// instead of cache.ttl
+	CacheTTL int `yaml:"cache_ttl"`
+	// Layout names the layout file in the prompts directory the page uses
+	// instead of layout.min.txt or layout.txt; "none" uses no layout
+	Layout string `yaml:"layout"`
+	// Complexity is a hint for routing rules, e.g. "simple" or "complex"
+	Complexity string `yaml:"complexity"`
+	// Tools the model may call while writing the page, e.g. read_file or fetch_url
+	Tools []string `yaml:"tools"`
+	// Images are pictures from the prompts' public directory shown to multimodal models
+	Images []string `yaml:"images"`
+	// RAG set to false leaves site content out of this page's prompt
+	RAG *bool `yaml:"rag"`
+	// Template set to true or false runs the prompt as a text/template with
+	// the request's data (see TemplateData) or not, whatever Server.Templates says
+	Template *bool `yaml:"template"`
+	// Sections split the page into parts generated concurrently and joined in order
+	Sections []Section `yaml:"sections"`
+	// Guardrails are the size and structure the generated page must have
+	Guardrails Guardrails `yaml:"guardrails"`
+	// Schedule swaps in prompt variants by date, time of day, or weekday
+	Schedule []ScheduleRule `yaml:"schedule"`
+	// Aliases are old routes that redirect (301) to this page
+	Aliases []string `yaml:"aliases"`
+	// Title, NavOrder, and Parent place the page in the site navigation;
+	// NavExclude leaves it out
+	Title      string `yaml:"title"`
+	NavOrder   int    `yaml:"nav_order"`
+	Parent     string `yaml:"parent"`
+	NavExclude bool   `yaml:"nav_exclude"`
+}
+
+// reasoning returns the reasoning overrides as model options
+func (fm FrontMatter) reasoning() models.ReasoningOptions {
+	return models.ReasoningOptions{Effort: fm.ReasoningEffort, BudgetTokens: fm.ThinkingBudget}
+}
+
+// sampling returns the sampling overrides for OpenAI-compatible backends
+func (fm FrontMatter) sampling() models.SamplingOptions {
+	return models.SamplingOptions{Temperature: fm.Temperature, MaxTokens: fm.MaxTokens}
+}
+
+// ollamaOptions returns the Ollama option overrides: temperature and
+// max_tokens, then ollama_options, which win
+func (fm FrontMatter) ollamaOptions() models.OllamaOptions {
+	opts := models.OllamaOptions{}
+	if fm.Temperature != nil {
+		opts["temperature"] = *fm.Temperature
+	}
+	if fm.MaxTokens > 0 {
+		opts["num_predict"] = fm.MaxTokens
+	}
+	return opts.Merge(fm.OllamaOptions)
+}
+
+// validateModelSettings checks the sampling, cache, and layout settings
+func (fm FrontMatter) validateModelSettings() error {
+	if err := fm.sampling().Validate(); err != nil {
+		return err
+	}
+	if fm.CacheTTL < 0 {
+		return fmt.Errorf("cache_ttl must not be negative, got %d", fm.CacheTTL)
+	}
+	if fm.Layout != "" && fm.Layout != "none" && !filepath.IsLocal(fm.Layout) {
+		return fmt.Errorf("invalid layout %q: name a file in the prompts directory", fm.Layout)
+	}
+	return nil
+}
+
+// parseFrontMatter splits data into its front matter and the remaining prompt.
+// Files without a leading "---" line have no front matter.
+func parseFrontMatter(data []byte) (FrontMatter, []byte, error) {
+	var fm FrontMatter
+	rest, ok := cutDelimiter(data)
+	if !ok {
+		return fm, data, nil
+	}
+
+	// Find the closing delimiter line
+	for offset := 0; offset < len(rest); {
+		end := bytes.IndexByte(rest[offset:], '\n')
+		line := rest[offset:]
+		next := len(rest)
+		if end >= 0 {
+			line = rest[offset : offset+end]
+			next = offset + end + 1
+		}
+		if string(bytes.TrimRight(line, "\r")) == "---" {
+			if err := yaml.Unmarshal(rest[:offset], &fm); err != nil {
+				return fm, data, fmt.Errorf("invalid front matter: %w", err)
+			}
+			return fm, rest[next:], nil
+		}
+		offset = next
+	}
+	return fm, data, fmt.Errorf("front matter is missing its closing ---")
+}
+
+// cutDelimiter strips an opening "---" line
+func cutDelimiter(data []byte) ([]byte, bool) {
+	for _, prefix := range []string{"---\n", "---\r\n"} {
+		if rest, ok := bytes.CutPrefix(data, []byte(prefix)); ok {
+			return rest, true
+		}
+	}
+	return data, false
+}
+
+// htmlContentType is what pages are served as unless a prompt declares otherwise
+const htmlContentType = "text/html; charset=utf-8"
+
+// contentType resolves the response Content-Type for a route: the declared
+// type, else the type implied by a file extension in the route (events.ics),
+// else HTML. It reports whether the result is HTML.
+func contentType(route, declared string) (string, bool, error) {
+	if declared == "" {
+		declared = mime.TypeByExtension(path.Ext(route))
+	}
+	if declared == "" {
+		return htmlContentType, true, nil
+	}
+	mediaType, params, err := mime.ParseMediaType(declared)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid content_type %q: %w", declared, err)
+	}
+	if mediaType == "text/html" {
+		return htmlContentType, true, nil
+	}
+	if _, ok := params["charset"]; !ok && (strings.HasPrefix(mediaType, "text/") || mediaType == "application/json" || strings.HasSuffix(mediaType, "+xml")) {
+		params["charset"] = "utf-8"
+	}
+	return mime.FormatMediaType(mediaType, params), false, nil
+}
+
+// rawSystemPrompt replaces the HTML-oriented system prompt and layout for
+// prompts that produce other content types
+func rawSystemPrompt(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return fmt.Sprintf("You generate the body of an HTTP response with Content-Type %s.\n"+
+		"Output only valid %s content, exactly as it should be served.\n"+
+		"Do not wrap it in markdown code fences and do not add any explanation before or after it.", mediaType, mediaType)
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/kekePower/museweb/pkg/graphql"
+)
+
+// graphQLSchema documents the API served by HandleGraphQL. It is returned as-is
+// for GET requests without a query so frontends can generate typed clients.
+const graphQLSchema = `type Query {
+  "Generate the page for a route. Runs a full (non-streaming) model call."
+  render(route: String!, lang: String, input: String): Page!
+  "Routes available in the prompts directory"
+  routes: [Route!]!
+  "Models this server can generate with"
+  models: [Model!]!
+  "Request counters since startup"
+  stats: Stats!
+}
+
+type Page {
+  route: String!
+  lang: String
+  html: String!
+  model: String!
+  backend: String!
+  durationMs: Int!
+}
+
+type Route {
+  name: String!
+  path: String!
+}
+
+type Model {
+  name: String!
+  backend: String!
+  active: Boolean!
+}
+
+type Stats {
+  requests: Int!
+  generations: Int!
+  failures: Int!
+  guardrailViolations: Int!
+  uptimeSeconds: Int!
+}
+`
+
+// HandleGraphQL serves the /graphql endpoint
+func (s *Server) HandleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req graphql.Request
+
+	switch r.Method {
+	case http.MethodGet:
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if req.Query == "" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			fmt.Fprint(w, graphQLSchema)
+			return
+		}
+		if vars := r.URL.Query().Get("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				http.Error(w, "Invalid variables: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid GraphQL request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.Debug {
+		log.Printf("🔍 GraphQL query: %s", req.Query)
+	}
+
+	resp := graphql.Execute(s.graphQLRoot(r.Context()), req)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("Error writing GraphQL response: %v", err)
+	}
+}
+
+// graphQLRoot returns the resolver for the Query type; renders stop when ctx is cancelled
+func (s *Server) graphQLRoot(ctx context.Context) graphql.Object {
+	return func(field string, args map[string]interface{}) (interface{}, error) {
+		switch field {
+		case "__typename":
+			return "Query", nil
+		case "render":
+			return s.resolveRender(ctx, args)
+		case "routes":
+			routes, err := ListRoutes(s.ActivePromptsDir())
+			if err != nil {
+				return nil, fmt.Errorf("listing routes: %w", err)
+			}
+			objects := make([]graphql.Object, len(routes))
+			for i, route := range routes {
+				objects[i] = valueObject("Route", map[string]interface{}{
+					"name": route,
+					"path": "/" + route,
+				})
+			}
+			return objects, nil
+		case "models":
+			active := s.Active()
+			objects := []graphql.Object{valueObject("Model", map[string]interface{}{
+				"name":    active.Model,
+				"backend": active.Backend,
+				"active":  true,
+			})}
+			// Named backends are available to prompts that select them
+			named := s.NamedBackends()
+			names := make([]string, 0, len(named))
+			for name := range named {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				objects = append(objects, valueObject("Model", map[string]interface{}{
+					"name":    named[name].Model,
+					"backend": named[name].Backend,
+					"active":  false,
+				}))
+			}
+			return objects, nil
+		case "stats":
+			return valueObject("Stats", map[string]interface{}{
+				"requests":            s.requests.Load(),
+				"generations":         s.generations.Load(),
+				"failures":            s.failures.Load(),
+				"guardrailViolations": s.guardrailViolations.Load(),
+				"uptimeSeconds":       int64(time.Since(s.started).Seconds()),
+			}), nil
+		}
+		return nil, fmt.Errorf("cannot query field %q on type \"Query\"", field)
+	}
+}
+
+// resolveRender generates a page into memory for the render query
+func (s *Server) resolveRender(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	route, err := graphql.StringArg(args, "route", "")
+	if err != nil {
+		return nil, err
+	}
+	if route == "" {
+		return nil, fmt.Errorf("argument \"route\" is required")
+	}
+	lang, err := graphql.StringArg(args, "lang", "")
+	if err != nil {
+		return nil, err
+	}
+	input, err := graphql.StringArg(args, "input", "")
+	if err != nil {
+		return nil, err
+	}
+	if input, err = s.InputFilter.Clean(input); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	active := s.Active()
+	var buf bytes.Buffer
+	if err := s.Generate(ctx, &buf, discardFlusher{}, PageRequest{Route: route, Lang: lang, Input: input}); err != nil {
+		return nil, fmt.Errorf("rendering %q: %w", route, err)
+	}
+
+	var langValue interface{}
+	if lang != "" {
+		langValue = lang
+	}
+	return valueObject("Page", map[string]interface{}{
+		"route":      route,
+		"lang":       langValue,
+		"html":       buf.String(),
+		"model":      active.Model,
+		"backend":    active.Backend,
+		"durationMs": time.Since(start).Milliseconds(),
+	}), nil
+}
+
+// valueObject exposes a fixed set of scalar fields as a GraphQL object
+func valueObject(typeName string, fields map[string]interface{}) graphql.Object {
+	return func(field string, args map[string]interface{}) (interface{}, error) {
+		if field == "__typename" {
+			return typeName, nil
+		}
+		value, ok := fields[field]
+		if !ok {
+			return nil, fmt.Errorf("cannot query field %q on type %q", field, typeName)
+		}
+		return value, nil
+	}
+}
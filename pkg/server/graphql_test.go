@@ -0,0 +1,54 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderRefusesPromptsOutsideTheRoutes(t *testing.T) {
+	dir := t.TempDir()
+	promptsDir := filepath.Join(dir, "prompts")
+	if err := os.Mkdir(promptsDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"secret.txt":                "SECRET",
+		"prompts/system_prompt.txt": "SYSTEM",
+		"prompts/layout.txt":        "LAYOUT",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := New("ollama", "test-model", promptsDir, "", "", false)
+
+	for _, route := range []string{"../secret", "../secret.txt", "layout", "layout.min", "system_prompt", "/" + filepath.Join(dir, "secret")} {
+		body, _ := json.Marshal(map[string]interface{}{
+			"query":     `query ($route: String!) { render(route: $route) { html } }`,
+			"variables": map[string]string{"route": route},
+		})
+		w := httptest.NewRecorder()
+		s.HandleGraphQL(w, httptest.NewRequest("POST", "/graphql", strings.NewReader(string(body))))
+		if got := w.Body.String(); !strings.Contains(got, errPromptNotFound.Error()) {
+			t.Errorf("render(route: %q) = %s", route, got)
+		}
+	}
+}
+
+func TestRenderRefusesMembersOnlyPages(t *testing.T) {
+	dir := writePrompts(t, map[string]string{
+		"system_prompt.txt": "SYSTEM",
+		"members.txt":       "---\nlogin: true\n---\nMEMBERS",
+	})
+	s := New("ollama", "test-model", dir, "", "", false)
+
+	w := httptest.NewRecorder()
+	s.HandleGraphQL(w, httptest.NewRequest("GET", `/graphql?query={render(route:"members"){html}}`, nil))
+	if got := w.Body.String(); !strings.Contains(got, errLoginRequired.Error()) {
+		t.Errorf("render(route: \"members\") = %s", got)
+	}
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/kekePower/museweb/pkg/models"
+)
+
+// Guardrails are the size and structure a generated page must have, declared
+// in front matter:
+//
+//	---
+//	guardrails:
+//	  max_kb: 200
+//	  require_tags: [nav, footer]
+//	  require_text: ["© 2025 Example Ltd"]
+//	---
+//
+// A page that breaks them is generated again with the problems spelled out;
+// when the retries are used up, the latest snapshot of the page is served
+// instead, if there is one.
+type Guardrails struct {
+	MaxKB       int      `yaml:"max_kb"`       // 0 = no limit
+	RequireTags []string `yaml:"require_tags"` // Elements the page must contain
+	RequireText []string `yaml:"require_text"` // Text the page must contain verbatim
+	Retries     *int     `yaml:"retries"`      // Corrective retries, default 1
+}
+
+// maxGuardrailRetries caps retries; every one is a full generation
+const maxGuardrailRetries = 3
+
+// tagName limits required tags to plain element names
+var tagName = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// active reports whether the page has any guardrails
+func (g Guardrails) active() bool {
+	return g.MaxKB > 0 || len(g.RequireTags) > 0 || len(g.RequireText) > 0
+}
+
+// retries returns how often a failing page is generated again
+func (g Guardrails) retries() int {
+	if g.Retries == nil {
+		return 1
+	}
+	return *g.Retries
+}
+
+// validate checks the declared guardrails of a prompt
+func (g Guardrails) validate(isHTML bool) error {
+	if !g.active() {
+		return nil
+	}
+	if !isHTML {
+		return fmt.Errorf("guardrails are only supported for HTML pages")
+	}
+	if g.MaxKB < 0 {
+		return fmt.Errorf("guardrails: max_kb must not be negative")
+	}
+	for _, tag := range g.RequireTags {
+		if !tagName.MatchString(tag) {
+			return fmt.Errorf("guardrails: invalid tag name %q", tag)
+		}
+	}
+	for _, text := range g.RequireText {
+		if strings.TrimSpace(text) == "" {
+			return fmt.Errorf("guardrails: require_text must not be empty")
+		}
+	}
+	if r := g.retries(); r < 0 || r > maxGuardrailRetries {
+		return fmt.Errorf("guardrails: retries must be between 0 and %d", maxGuardrailRetries)
+	}
+	return nil
+}
+
+// check returns the ways html breaks the guardrails; tooLarge means the
+// generation was stopped at the size limit
+func (g Guardrails) check(html string, tooLarge bool) []string {
+	if tooLarge {
+		return []string{fmt.Sprintf("the page is larger than %d KB", g.MaxKB)}
+	}
+	var violations []string
+	lower := strings.ToLower(html)
+	for _, tag := range g.RequireTags {
+		if !hasElement(lower, tag) {
+			violations = append(violations, fmt.Sprintf("the page has no <%s> element", tag))
+		}
+	}
+	for _, text := range g.RequireText {
+		if !strings.Contains(html, text) {
+			violations = append(violations, fmt.Sprintf("the page does not contain the text %q", text))
+		}
+	}
+	return violations
+}
+
+// hasElement reports whether the lowercased html has an opening tag for name
+func hasElement(html, name string) bool {
+	open := "<" + name
+	for i := strings.Index(html, open); i != -1; {
+		rest := html[i+len(open):]
+		if rest == "" || strings.ContainsRune(" \t\r\n/>", rune(rest[0])) {
+			return true
+		}
+		next := strings.Index(rest, open)
+		if next == -1 {
+			break
+		}
+		i += len(open) + next
+	}
+	return false
+}
+
+// correction is appended to the user prompt when a page is generated again
+func correction(violations []string) string {
+	var b strings.Builder
+	b.WriteString("\n\nA previous version of this page was rejected because ")
+	b.WriteString(strings.Join(violations, "; "))
+	b.WriteString(". Fix these problems and output the complete page again.")
+	return b.String()
+}
+
+// generateGuarded generates the page into buf and checks it against the
+// prompt's guardrails, retrying with corrective instructions. It returns the
+// violations of the last attempt, which are nil when the page passed.
+func (s *Server) generateGuarded(ctx context.Context, buf *bytes.Buffer, req PageRequest, p prompts, active BackendSettings, opts models.Options) (BackendSettings, []string, error) {
+	g := p.Meta.Guardrails
+	if !g.active() {
+		used, err := s.generatePage(ctx, buf, discardFlusher{}, req, p, active, opts)
+		return used, nil, err
+	}
+
+	attempt := p
+	for try := 0; ; try++ {
+		buf.Reset()
+		genCtx, cancel := context.WithCancel(ctx)
+		lw := &limitWriter{w: buf, limit: g.MaxKB * 1024, cancel: cancel}
+		used, err := s.generatePage(genCtx, lw, discardFlusher{}, req, attempt, active, opts)
+		cancel()
+		if err != nil && (!lw.exceeded || ctx.Err() != nil) {
+			return used, nil, err
+		}
+
+		violations := g.check(buf.String(), lw.exceeded)
+		if len(violations) == 0 {
+			return used, nil, nil
+		}
+		s.guardrailViolations.Add(1)
+		log.Printf("🚧 /%s broke its guardrails (attempt %d): %s", req.Route, try+1, strings.Join(violations, "; "))
+		if try >= g.retries() {
+			return used, violations, nil
+		}
+		attempt.User = p.User + correction(violations)
+	}
+}
+
+// limitWriter stops a generation once it has written more than limit bytes
+// (0 = no limit) by cancelling its context
+type limitWriter struct {
+	w        io.Writer
+	limit    int
+	written  int
+	exceeded bool
+	cancel   context.CancelFunc
+}
+
+// Write implements io.Writer
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if l.exceeded {
+		return len(p), nil
+	}
+	l.written += len(p)
+	if l.limit > 0 && l.written > l.limit {
+		l.exceeded = true
+		l.cancel()
+		return len(p), nil
+	}
+	return l.w.Write(p)
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/kekePower/museweb/pkg/models"
+)
+
+func TestGuardrailsValidate(t *testing.T) {
+	zero, three, four, negative := 0, 3, 4, -1
+	for _, tc := range []struct {
+		name   string
+		g      Guardrails
+		isHTML bool
+		want   string // Part of the error, or "" when valid
+	}{
+		{"none", Guardrails{}, false, ""},
+		{"retries alone are no guardrails", Guardrails{Retries: &four}, true, ""},
+		{"valid", Guardrails{MaxKB: 200, RequireTags: []string{"nav", "my-footer"}, RequireText: []string{"© 2025"}}, true, ""},
+		{"no retries", Guardrails{MaxKB: 1, Retries: &zero}, true, ""},
+		{"most retries", Guardrails{MaxKB: 1, Retries: &three}, true, ""},
+		{"not HTML", Guardrails{MaxKB: 1}, false, "only supported for HTML"},
+		{"negative size", Guardrails{MaxKB: -1, RequireTags: []string{"nav"}}, true, "max_kb must not be negative"},
+		{"uppercase tag", Guardrails{RequireTags: []string{"NAV"}}, true, `invalid tag name "NAV"`},
+		{"tag with brackets", Guardrails{RequireTags: []string{"<nav>"}}, true, "invalid tag name"},
+		{"tag with attributes", Guardrails{RequireTags: []string{"nav class"}}, true, "invalid tag name"},
+		{"blank text", Guardrails{RequireText: []string{"  "}}, true, "require_text must not be empty"},
+		{"too many retries", Guardrails{MaxKB: 1, Retries: &four}, true, "retries must be between 0 and 3"},
+		{"negative retries", Guardrails{MaxKB: 1, Retries: &negative}, true, "retries must be between 0 and 3"},
+	} {
+		err := tc.g.validate(tc.isHTML)
+		if tc.want == "" && err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+		if tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+			t.Errorf("%s: err = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestGuardrailsCheck(t *testing.T) {
+	g := Guardrails{MaxKB: 2, RequireTags: []string{"nav", "footer"}, RequireText: []string{"© 2025 Example Ltd"}}
+	for _, tc := range []struct {
+		name     string
+		html     string
+		tooLarge bool
+		want     []string
+	}{
+		{"allowed", `<NAV class="top"></NAV><main></main><footer>© 2025 Example Ltd</footer>`, false, nil},
+		{"missing element", `<nav></nav><div class="footer">© 2025 Example Ltd</div>`, false,
+			[]string{"the page has no <footer> element"}},
+		{"element only in a longer name", `<navbar></navbar><footer-links></footer-links>© 2025 Example Ltd`, false,
+			[]string{"the page has no <nav> element", "the page has no <footer> element"}},
+		{"text with different case", `<nav></nav><footer>© 2025 example ltd</footer>`, false,
+			[]string{`the page does not contain the text "© 2025 Example Ltd"`}},
+		{"too large", `<nav></nav><footer>© 2025 Example Ltd</footer>`, true,
+			[]string{"the page is larger than 2 KB"}},
+	} {
+		got := g.check(tc.html, tc.tooLarge)
+		if strings.Join(got, "\n") != strings.Join(tc.want, "\n") {
+			t.Errorf("%s: violations %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestHasElement(t *testing.T) {
+	for _, tc := range []struct {
+		html string
+		want bool
+	}{
+		{"<nav>", true},
+		{`<nav class="top">`, true},
+		{"<nav\n  id=top>", true},
+		{"<nav/>", true},
+		{"<nav", true},
+		{"<navbar><nav>", true},
+		{"<navbar>", false},
+		{"</nav>", false},
+		{"nav", false},
+		{"", false},
+	} {
+		if got := hasElement(tc.html, "nav"); got != tc.want {
+			t.Errorf("hasElement(%q, nav) = %v, want %v", tc.html, got, tc.want)
+		}
+	}
+}
+
+func TestLimitWriter(t *testing.T) {
+	var buf bytes.Buffer
+	cancelled := false
+	lw := &limitWriter{w: &buf, limit: 5, cancel: func() { cancelled = true }}
+	for _, chunk := range []string{"abc", "de", "f", "gh"} {
+		if n, err := lw.Write([]byte(chunk)); n != len(chunk) || err != nil {
+			t.Errorf("Write(%q) = %d, %v", chunk, n, err)
+		}
+	}
+	if buf.String() != "abcde" || !lw.exceeded || !cancelled {
+		t.Errorf("wrote %q, exceeded %v, cancelled %v", buf.String(), lw.exceeded, cancelled)
+	}
+
+	buf.Reset()
+	unlimited := &limitWriter{w: &buf, cancel: func() { t.Error("cancelled without a limit") }}
+	unlimited.Write(bytes.Repeat([]byte("x"), 1<<16))
+	if buf.Len() != 1<<16 || unlimited.exceeded {
+		t.Errorf("wrote %d bytes, exceeded %v", buf.Len(), unlimited.exceeded)
+	}
+}
+
+// newGuardrailBackend serves chat completions that answer with reply, or
+// with corrected once the prompt says a previous version was rejected
+func newGuardrailBackend(t *testing.T, calls *atomic.Int32, reply, corrected string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		content := reply
+		if bytes.Contains(body, []byte("A previous version of this page was rejected")) {
+			content = corrected
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		event, _ := json.Marshal(map[string]interface{}{
+			"choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]string{"content": content}}},
+		})
+		fmt.Fprintf(w, "data: %s\n\ndata: [DONE]\n\n", event)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGenerateGuarded(t *testing.T) {
+	zero := 0
+	for _, tc := range []struct {
+		name           string
+		g              Guardrails
+		reply          string
+		corrected      string
+		want           string
+		wantViolations []string
+		wantCalls      int32
+	}{
+		{
+			name:      "passes the first time",
+			g:         Guardrails{RequireTags: []string{"footer"}},
+			reply:     "<main></main><footer></footer>",
+			want:      "<main></main><footer></footer>",
+			wantCalls: 1,
+		},
+		{
+			name:      "corrected on the retry",
+			g:         Guardrails{RequireTags: []string{"footer"}},
+			reply:     "<main></main>",
+			corrected: "<main></main><footer></footer>",
+			want:      "<main></main><footer></footer>",
+			wantCalls: 2,
+		},
+		{
+			name:           "still broken after the retries",
+			g:              Guardrails{RequireTags: []string{"footer"}},
+			reply:          "<main></main>",
+			corrected:      "<main>again</main>",
+			want:           "<main>again</main>",
+			wantViolations: []string{"the page has no <footer> element"},
+			wantCalls:      2,
+		},
+		{
+			name:           "no retries",
+			g:              Guardrails{RequireTags: []string{"footer"}, Retries: &zero},
+			reply:          "<main></main>",
+			want:           "<main></main>",
+			wantViolations: []string{"the page has no <footer> element"},
+			wantCalls:      1,
+		},
+		{
+			name:           "stopped at the size limit",
+			g:              Guardrails{MaxKB: 1, Retries: &zero},
+			reply:          "<p>" + strings.Repeat("x", 2048) + "</p>",
+			want:           "", // The write that crosses the limit is dropped
+			wantViolations: []string{"the page is larger than 1 KB"},
+			wantCalls:      1,
+		},
+	} {
+		var calls atomic.Int32
+		backend := newGuardrailBackend(t, &calls, tc.reply, tc.corrected)
+		s := New("openai", "test-model", t.TempDir(), "test-key", backend.URL, false)
+		s.ResponseAdapter = "openai"
+		p := prompts{System: "system", User: "PAGE", Meta: FrontMatter{Guardrails: tc.g}}
+
+		var buf bytes.Buffer
+		_, violations, err := s.generateGuarded(context.Background(), &buf, PageRequest{Route: "home"}, p, s.Active(), models.Options{})
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+		if got := buf.String(); got != tc.want {
+			t.Errorf("%s: page %q, want %q", tc.name, got, tc.want)
+		}
+		if strings.Join(violations, "\n") != strings.Join(tc.wantViolations, "\n") {
+			t.Errorf("%s: violations %q, want %q", tc.name, violations, tc.wantViolations)
+		}
+		if got := calls.Load(); got != tc.wantCalls {
+			t.Errorf("%s: %d generations, want %d", tc.name, got, tc.wantCalls)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"time"
+
+	"github.com/kekePower/museweb/pkg/models"
+)
+
+// BuildInfo identifies the running binary
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// NewBuildInfo fills in what the linker flags left empty from the build
+// information Go embeds in the binary
+func NewBuildInfo(version, commit, buildDate string) BuildInfo {
+	info := BuildInfo{Version: version, Commit: commit, BuildDate: buildDate, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range bi.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = setting.Value
+			case setting.Key == "vcs.modified" && setting.Value == "true" && info.Commit != "":
+				info.Commit += "-dirty"
+			}
+		}
+	}
+	return info
+}
+
+// backendStatus is the reachability of one backend, as reported by /admin/info
+type backendStatus struct {
+	Name string `json:"name,omitempty"`
+	BackendSettings
+	Status       string               `json:"status"`
+	Capabilities *models.Capabilities `json:"capabilities,omitempty"`
+}
+
+// handleInfo reports the build, uptime, configuration (secrets redacted),
+// prompt count, and whether the active and named backends have their models
+func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	routes, err := ListRoutes(s.ActivePromptsDir())
+	prompts := len(routes)
+	if err != nil {
+		prompts = -1
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	named := s.NamedBackends()
+	names := make([]string, 0, len(named))
+	for name := range named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	backends := make([]backendStatus, len(names))
+	for i, name := range names {
+		backends[i] = checkStatus(ctx, name, named[name])
+	}
+
+	info := map[string]interface{}{
+		"build":          s.Build,
+		"uptime_seconds": int64(time.Since(s.started).Seconds()),
+		"prompts":        prompts,
+		"backend":        checkStatus(ctx, "", s.Active()),
+		"backends":       backends,
+		"config":         s.ConfigSummary,
+	}
+	if s.OllamaNodes != nil {
+		info["ollama_nodes"] = s.OllamaNodes.Status()
+	}
+	if s.Limit != nil {
+		info["generations"] = map[string]int{"running": s.Limit.Running(), "queued": s.Limit.Queued()}
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// checkStatus asks a backend whether it has its model: "ok", "model not
+// found", "unchecked" for backends that cannot be asked, or the error
+func checkStatus(ctx context.Context, name string, settings BackendSettings) backendStatus {
+	status := "ok"
+	switch err := models.CheckModel(ctx, settings.Backend, settings.Model, settings.APIKey, settings.APIBase); {
+	case errors.Is(err, models.ErrModelNotFound):
+		status = "model not found"
+	case err != nil:
+		status = err.Error()
+	case !models.CanCheckModel(settings.Backend):
+		status = "unchecked"
+	}
+	st := backendStatus{Name: name, BackendSettings: publicSettings(settings), Status: status}
+	if caps, ok := models.LookupCapabilities(settings.Model); ok {
+		st.Capabilities = &caps
+	}
+	return st
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// kvPattern matches the key-value placeholders in page prompts:
+// {{kv "name"}} is replaced by the stored value, {{incr "name"}} adds one to
+// the stored number first (a visit counter)
+var kvPattern = regexp.MustCompile(`\{\{\s*(kv|incr)\s+"([^"]*)"\s*\}\}`)
+
+// validKVKey reports whether key is usable: short, and letters, digits, and
+// "-_.:" only, so keys read well in prompts and URLs
+func validKVKey(key string) bool {
+	return key != "" && len(key) <= 64 && strings.Trim(key, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.:") == ""
+}
+
+// usesKV reports whether a page prompt has key-value placeholders. Such pages
+// change between requests, so they are never served from the warm cache.
+func (s *Server) usesKV(page string) bool {
+	return s.KV != nil && kvPattern.MatchString(page)
+}
+
+// expandKV fills in the key-value placeholders of a prompt
+func (s *Server) expandKV(prompt string) string {
+	return kvPattern.ReplaceAllStringFunc(prompt, func(m string) string {
+		parts := kvPattern.FindStringSubmatch(m)
+		op, key := parts[1], parts[2]
+		if !validKVKey(key) {
+			log.Printf("⚠️  Invalid key %q in prompt placeholder %s", key, m)
+			return ""
+		}
+		if op == "incr" {
+			n, err := s.KV.Incr(key, 1)
+			if err != nil {
+				log.Printf("⚠️  %v", err)
+				return ""
+			}
+			return strconv.FormatInt(n, 10)
+		}
+		value, err := s.KV.Get(key)
+		if err != nil {
+			log.Printf("⚠️  %v", err)
+		}
+		return value
+	})
+}
+
+// kvWritable reports whether forms may change key
+func (s *Server) kvWritable(key string) bool {
+	for _, pattern := range s.KVWritable {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleKV serves POST /kv/<key>, which adds one to a counter so plain HTML
+// forms can keep tallies (RSVPs, votes, ...). Only keys matching KVWritable
+// can be changed. Browsers are sent back to the form's "redirect" field or
+// the page they came from; clients asking for JSON get the new value.
+func (s *Server) HandleKV(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/kv/")
+	if !validKVKey(key) {
+		http.Error(w, "Invalid key", http.StatusBadRequest)
+		return
+	}
+	if s.KV == nil || !s.kvWritable(key) {
+		http.Error(w, fmt.Sprintf("Key %q is not writable", key), http.StatusForbidden)
+		return
+	}
+	n, err := s.KV.Incr(key, 1)
+	if err != nil {
+		log.Printf("Error updating key-value store: %v", err)
+		http.Error(w, "Could not update the value", http.StatusInternalServerError)
+		return
+	}
+	if s.Debug {
+		log.Printf("[DEBUG] %s incremented to %d", key, n)
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "value": n})
+		return
+	}
+	http.Redirect(w, r, kvRedirect(r), http.StatusSeeOther)
+}
+
+// kvRedirect picks where a form post goes next: the "redirect" field or the
+// referring page, as long as it stays on this site
+func kvRedirect(r *http.Request) string {
+	if target := r.FormValue("redirect"); localPath(target) {
+		return target
+	}
+	if ref, err := url.Parse(r.Referer()); err == nil && ref.Host == r.Host && localPath(ref.RequestURI()) {
+		return ref.RequestURI()
+	}
+	return "/"
+}
+
+// localPath reports whether target is a path on this site, not another host
+func localPath(target string) bool {
+	return strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "//") && !strings.HasPrefix(target, "/\\")
+}
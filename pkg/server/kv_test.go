@@ -0,0 +1,114 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kekePower/museweb/pkg/store"
+)
+
+func TestValidKVKey(t *testing.T) {
+	for _, tc := range []struct {
+		key  string
+		want bool
+	}{
+		{"visits", true},
+		{"rsvp:party-2024_v1.yes", true},
+		{strings.Repeat("k", 64), true},
+		{"", false},
+		{strings.Repeat("k", 65), false},
+		{"two words", false},
+		{"a/b", false},
+		{`quote"d`, false},
+		{"æøå", false},
+	} {
+		if got := validKVKey(tc.key); got != tc.want {
+			t.Errorf("validKVKey(%q) = %v", tc.key, got)
+		}
+	}
+}
+
+func TestExpandKV(t *testing.T) {
+	db, err := store.Open(filepath.Join(t.TempDir(), "museweb.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	s := New("ollama", "test-model", t.TempDir(), "", "", false)
+	if s.usesKV(`{{kv "name"}}`) {
+		t.Error("placeholders used without a store")
+	}
+	if s.KV, err = store.NewKV(db); err != nil {
+		t.Fatal(err)
+	}
+	s.KV.Set("name", "Museum")
+
+	for _, tc := range []struct {
+		prompt, want string
+		uses         bool
+	}{
+		{`Welcome to {{kv "name"}}`, "Welcome to Museum", true},
+		{`{{ kv   "name" }}!`, "Museum!", true},
+		{`Visitor {{incr "visits"}}, then {{incr "visits"}}; {{kv "visits"}} so far`, "Visitor 1, then 2; 2 so far", true},
+		{`[{{kv "unset"}}]`, "[]", true},
+		{`[{{kv "bad key"}}]`, "[]", true},
+		{`{{kv name}} {{kv 'name'}} {kv "name"}`, `{{kv name}} {{kv 'name'}} {kv "name"}`, false},
+	} {
+		if got := s.usesKV(tc.prompt); got != tc.uses {
+			t.Errorf("usesKV(%q) = %v", tc.prompt, got)
+		}
+		if got := s.expandKV(tc.prompt); got != tc.want {
+			t.Errorf("expandKV(%q) = %q, want %q", tc.prompt, got, tc.want)
+		}
+	}
+}
+
+func TestHandleKV(t *testing.T) {
+	db, err := store.Open(filepath.Join(t.TempDir(), "museweb.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	s := New("ollama", "test-model", t.TempDir(), "", "", false)
+	if s.KV, err = store.NewKV(db); err != nil {
+		t.Fatal(err)
+	}
+	s.KVWritable = []string{"rsvp:*"}
+
+	for _, tc := range []struct {
+		method, path, accept, referer string
+		form                          url.Values
+		status                        int
+		location, body                string
+	}{
+		{method: "POST", path: "/kv/rsvp:yes", accept: "application/json", status: http.StatusOK, body: `"value":1`},
+		{method: "POST", path: "/kv/rsvp:yes", form: url.Values{"redirect": {"/thanks"}}, status: http.StatusSeeOther, location: "/thanks"},
+		{method: "POST", path: "/kv/rsvp:yes", form: url.Values{"redirect": {"//evil.example"}}, referer: "http://example.com/party?x=1", status: http.StatusSeeOther, location: "/party?x=1"},
+		{method: "POST", path: "/kv/rsvp:yes", referer: "http://evil.example/", status: http.StatusSeeOther, location: "/"},
+		{method: "POST", path: "/kv/rsvp:yes", form: url.Values{"redirect": {`/\evil.example`}}, status: http.StatusSeeOther, location: "/"},
+		{method: "POST", path: "/kv/visits", status: http.StatusForbidden},
+		{method: "POST", path: "/kv/bad key", status: http.StatusBadRequest},
+		{method: "GET", path: "/kv/rsvp:yes", status: http.StatusMethodNotAllowed},
+	} {
+		req := httptest.NewRequest(tc.method, "http://example.com"+strings.ReplaceAll(tc.path, " ", "%20"), strings.NewReader(tc.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		if tc.referer != "" {
+			req.Header.Set("Referer", tc.referer)
+		}
+		rec := httptest.NewRecorder()
+		s.HandleKV(rec, req)
+		if rec.Code != tc.status || rec.Header().Get("Location") != tc.location || !strings.Contains(rec.Body.String(), tc.body) {
+			t.Errorf("%s %s %v: %d, Location %q, body %q", tc.method, tc.path, tc.form, rec.Code, rec.Header().Get("Location"), rec.Body)
+		}
+	}
+	if n, _ := s.KV.Get("rsvp:yes"); n != "5" {
+		t.Errorf("rsvp:yes = %q after five posts", n)
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// errBusy is returned when a generation cannot start: every slot is taken
+// and the queue is full, or the request waited too long for a slot
+var errBusy = errors.New("too many generations in progress")
+
+// GenerationLimit caps how many pages are generated at once, so a small
+// backend (one Ollama box) is not swamped. Requests beyond the limit wait in
+// a queue for a free slot; when the queue is full, or a request waited too
+// long, the visitor gets a "busy" page instead.
+type GenerationLimit struct {
+	slots chan struct{} // One token per running generation
+	queue chan struct{} // One token per waiting request
+	wait  time.Duration
+}
+
+// NewGenerationLimit allows max generations at once, with up to queue more
+// requests waiting at most wait each (0 waits as long as the client does)
+func NewGenerationLimit(max, queue int, wait time.Duration) *GenerationLimit {
+	return &GenerationLimit{
+		slots: make(chan struct{}, max),
+		queue: make(chan struct{}, queue),
+		wait:  wait,
+	}
+}
+
+// acquire takes a generation slot, waiting in the queue when none is free
+func (l *GenerationLimit) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	select {
+	case l.queue <- struct{}{}:
+		defer func() { <-l.queue }()
+	default:
+		return errBusy
+	}
+	var timeout <-chan time.Time
+	if l.wait > 0 {
+		timer := time.NewTimer(l.wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-timeout:
+		return errBusy
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees a slot taken with acquire
+func (l *GenerationLimit) release() {
+	<-l.slots
+}
+
+// Running returns the number of generations in progress
+func (l *GenerationLimit) Running() int {
+	return len(l.slots)
+}
+
+// Queued returns the number of requests waiting for a slot
+func (l *GenerationLimit) Queued() int {
+	return len(l.queue)
+}
+
+// serveBusy answers a request turned away by the generation limit
+func (s *Server) serveBusy(client, w io.Writer, flusher http.Flusher, req PageRequest, p prompts) error {
+	log.Printf("🚦 Too many generations in progress, turning away /%s", req.Route)
+	return s.serveFailure(client, w, flusher, req, p, http.StatusServiceUnavailable, errBusy,
+		"This site writes its pages with an AI model, and it is busy writing pages for other visitors right now.")
+}
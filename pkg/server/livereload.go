@@ -0,0 +1,155 @@
+package server
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LiveReloadPath is the Server-Sent Events endpoint browsers subscribe to in dev mode
+const LiveReloadPath = "/__livereload"
+
+// liveReloadScript is appended to generated pages in dev mode. It reloads the
+// current route as soon as the server reports a prompt change; pages rendered
+// in morph mode regenerate in place instead of reloading.
+const liveReloadScript = `
+<script>(function(){var es=new EventSource("` + LiveReloadPath + `");es.addEventListener("reload",function(){if(window.museweb){museweb.regenerate();return;}es.close();location.reload();});})();</script>
+`
+
+// LiveReload watches the prompts directory and notifies connected browsers when
+// any prompt file changes. It polls modification times so it works on every
+// platform without extra dependencies.
+type LiveReload struct {
+	dir      func() string // Read on every poll so a switched directory is followed
+	interval time.Duration
+
+	mu          sync.Mutex
+	subscribers map[chan string]struct{}
+}
+
+// NewLiveReload creates a watcher for the directory dir returns, such as
+// Server.ActivePromptsDir; call Watch to start polling
+func NewLiveReload(dir func() string) *LiveReload {
+	return &LiveReload{
+		dir:         dir,
+		interval:    500 * time.Millisecond,
+		subscribers: make(map[chan string]struct{}),
+	}
+}
+
+// Watch polls the prompts directory until stop is closed (a nil stop watches forever)
+func (lr *LiveReload) Watch(stop <-chan struct{}) {
+	previous := lr.snapshot()
+	ticker := time.NewTicker(lr.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			current := lr.snapshot()
+			if changed := diffSnapshots(previous, current); changed != "" {
+				log.Printf("🔄 Prompt change detected (%s), reloading browsers", changed)
+				lr.broadcast(changed)
+			}
+			previous = current
+		}
+	}
+}
+
+// snapshot records the modification time of every file below the prompts directory
+func (lr *LiveReload) snapshot() map[string]time.Time {
+	files := make(map[string]time.Time)
+	filepath.WalkDir(lr.dir(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			files[path] = info.ModTime()
+		}
+		return nil
+	})
+	return files
+}
+
+// diffSnapshots returns the first changed, added, or removed path, or "" if nothing changed
+func diffSnapshots(previous, current map[string]time.Time) string {
+	for path, modTime := range current {
+		if prev, ok := previous[path]; !ok || !prev.Equal(modTime) {
+			return path
+		}
+	}
+	for path := range previous {
+		if _, ok := current[path]; !ok {
+			return path
+		}
+	}
+	return ""
+}
+
+func (lr *LiveReload) broadcast(changed string) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	for ch := range lr.subscribers {
+		select {
+		case ch <- changed:
+		default:
+			// Subscriber already has a pending reload
+		}
+	}
+}
+
+func (lr *LiveReload) subscribe() chan string {
+	ch := make(chan string, 1)
+	lr.mu.Lock()
+	lr.subscribers[ch] = struct{}{}
+	lr.mu.Unlock()
+	return ch
+}
+
+func (lr *LiveReload) unsubscribe(ch chan string) {
+	lr.mu.Lock()
+	delete(lr.subscribers, ch)
+	lr.mu.Unlock()
+}
+
+// ServeHTTP streams reload events to a browser using Server-Sent Events
+func (lr *LiveReload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.ProtoMajor == 1 {
+		w.Header().Set("Connection", "keep-alive") // Not allowed in HTTP/2
+	}
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	ch := lr.subscribe()
+	defer lr.unsubscribe(ch)
+
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case changed := <-ch:
+			fmt.Fprintf(w, "event: reload\ndata: %s\n\n", filepath.Base(changed))
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLiveReloadFollowsThePromptsDir(t *testing.T) {
+	first := writePrompts(t, map[string]string{"home.txt": "HOME"})
+	second := writePrompts(t, map[string]string{"home.txt": "HOME", "about.txt": "ABOUT"})
+	s := New("ollama", "test-model", first, "", "", false)
+
+	lr := NewLiveReload(s.ActivePromptsDir)
+	lr.interval = 10 * time.Millisecond
+	ch := lr.subscribe()
+	stop := make(chan struct{})
+	defer close(stop)
+	go lr.Watch(stop)
+
+	// expectReload waits for a reload of a file in dir
+	expectReload := func(what, dir string) {
+		t.Helper()
+		select {
+		case changed := <-ch:
+			if filepath.Dir(changed) != dir {
+				t.Errorf("%s: reload for %s, want one in %s", what, changed, dir)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s: no reload", what)
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond) // Let Watch take its first snapshot
+	s.SetPromptsDir(second)
+	expectReload("switched directory", second)
+
+	if err := os.WriteFile(filepath.Join(second, "contact.txt"), []byte("CONTACT"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expectReload("new prompt", second)
+
+	if err := os.WriteFile(filepath.Join(first, "contact.txt"), []byte("CONTACT"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case changed := <-ch:
+		t.Errorf("reload for %s in the directory no longer used", changed)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kekePower/museweb/pkg/models"
+)
+
+// Metadata modes: how generation metadata is attached to each response
+const (
+	MetadataComment  = "comment"  // An HTML comment after the page, visible in view-source
+	MetadataTrailers = "trailers" // HTTP trailers, for any content type
+	MetadataBoth     = "both"
+)
+
+// RequestIDHeader carries the request ID; a sane incoming value is reused
+const RequestIDHeader = "X-Request-Id"
+
+// genInfo collects what one request's generation reports in its metadata
+type genInfo struct {
+	requestID string
+	start     time.Time
+
+	mu      sync.Mutex
+	cache   string // How the page was served: "miss", "warm", "pinned", ...
+	backend string
+	model   string
+	usage   models.Usage
+}
+
+// newGenInfo starts collecting metadata for r
+func newGenInfo(r *http.Request) *genInfo {
+	return &genInfo{requestID: requestID(r), start: time.Now(), cache: "miss"}
+}
+
+// requestID reuses the client's or proxy's request ID when it is short and
+// plain, and makes one up otherwise
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(RequestIDHeader); id != "" && len(id) <= 64 && strings.Trim(id, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.") == "" {
+		return id
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// served records how the page was served. It does nothing on a nil genInfo,
+// so generations without metadata need no checks.
+func (g *genInfo) served(cache string) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.cache = cache
+}
+
+// generatedBy records the backend and model that produced the page
+func (g *genInfo) generatedBy(settings BackendSettings) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.backend, g.model = settings.Backend, settings.Model
+}
+
+// countUsage wraps a usage callback so the generation's tokens are summed
+func (g *genInfo) countUsage(next func(models.Usage)) func(models.Usage) {
+	if g == nil {
+		return next
+	}
+	return func(u models.Usage) {
+		g.mu.Lock()
+		g.usage.PromptTokens += u.PromptTokens
+		g.usage.CompletionTokens += u.CompletionTokens
+		g.usage.Estimated = g.usage.Estimated || u.Estimated
+		g.mu.Unlock()
+		if next != nil {
+			next(u)
+		}
+	}
+}
+
+// fields returns the metadata as ordered name/value pairs
+func (g *genInfo) fields() [][2]string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	fields := [][2]string{{"request-id", g.requestID}, {"cache", g.cache}}
+	if g.model != "" {
+		fields = append(fields, [2]string{"model", g.backend + "/" + g.model})
+	}
+	fields = append(fields, [2]string{"duration-ms", strconv.FormatInt(time.Since(g.start).Milliseconds(), 10)})
+	if g.usage.PromptTokens > 0 || g.usage.CompletionTokens > 0 {
+		tokens := fmt.Sprintf("%d+%d", g.usage.PromptTokens, g.usage.CompletionTokens)
+		if g.usage.Estimated {
+			tokens += " (estimated)"
+		}
+		fields = append(fields, [2]string{"tokens", tokens})
+	}
+	return fields
+}
+
+// announceTrailers declares the metadata and usage trailers before the body
+// is written
+func (s *Server) announceTrailers(w http.ResponseWriter) {
+	var trailers []string
+	if s.Metadata == MetadataTrailers || s.Metadata == MetadataBoth {
+		trailers = append(trailers, "X-MuseWeb-Request-Id, X-MuseWeb-Cache-Status, X-MuseWeb-Model-Used, X-MuseWeb-Duration-Ms, X-MuseWeb-Tokens")
+	}
+	if s.Usage != nil && s.UsageHeader {
+		trailers = append(trailers, UsageTrailer)
+	}
+	if len(trailers) > 0 {
+		w.Header().Set("Trailer", strings.Join(trailers, ", "))
+	}
+}
+
+// writeMetadata attaches the collected metadata to a finished response: as a
+// comment after HTML pages and as trailers, depending on s.Metadata
+func (s *Server) writeMetadata(w http.ResponseWriter, g *genInfo, html bool) {
+	if g == nil {
+		return
+	}
+	fields := g.fields()
+	if html && (s.Metadata == MetadataComment || s.Metadata == MetadataBoth) {
+		var b strings.Builder
+		b.WriteString("\n<!-- museweb")
+		for _, f := range fields {
+			// "--" would end the comment early
+			fmt.Fprintf(&b, " %s=%s", f[0], strings.ReplaceAll(f[1], "--", "-"))
+		}
+		b.WriteString(" -->\n")
+		io.WriteString(w, b.String())
+	}
+	if s.Metadata == MetadataTrailers || s.Metadata == MetadataBoth {
+		names := map[string]string{
+			"request-id":  "X-MuseWeb-Request-Id",
+			"cache":       "X-MuseWeb-Cache-Status",
+			"model":       "X-MuseWeb-Model-Used",
+			"duration-ms": "X-MuseWeb-Duration-Ms",
+			"tokens":      "X-MuseWeb-Tokens",
+		}
+		for _, f := range fields {
+			w.Header().Set(names[f[0]], f[1])
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kekePower/museweb/pkg/metrics"
+	"github.com/kekePower/museweb/pkg/models"
+	"github.com/kekePower/museweb/pkg/tracing"
+)
+
+// Metrics are the Prometheus metrics of page requests and generations. Every
+// method does nothing on a nil *Metrics, so call sites need no checks. With
+// tracing on, latencies carry the trace ID of the request as an exemplar.
+type Metrics struct {
+	registry   *metrics.Registry
+	requests   *metrics.Counter   // By status code
+	pages      *metrics.Histogram // Page latency by route
+	firstToken *metrics.Histogram // Time to first token by backend and model
+	streams    *metrics.Histogram // Stream duration by backend and model
+	upstream   *metrics.Counter   // Backend calls by backend, model, and outcome
+	cache      *metrics.Counter   // Response cache lookups by result
+	tokens     *metrics.Counter   // Tokens by backend, model, and kind
+}
+
+// NewMetrics creates the metrics of s; serve them with ServeHTTP and count
+// requests with Instrument
+func NewMetrics(s *Server) *Metrics {
+	r := metrics.NewRegistry()
+	m := &Metrics{
+		registry: r,
+		requests: r.Counter("museweb_http_requests_total",
+			"HTTP requests by status code.", "code"),
+		pages: r.Histogram("museweb_page_duration_seconds",
+			"Time to serve a page, from the request to its last byte, by route.", nil, "route"),
+		firstToken: r.Histogram("museweb_time_to_first_token_seconds",
+			"Time from calling a backend to its first output.", nil, "backend", "model"),
+		streams: r.Histogram("museweb_stream_duration_seconds",
+			"Duration of backend calls, from the request to the end of the stream.", nil, "backend", "model"),
+		upstream: r.Counter("museweb_upstream_requests_total",
+			"Backend calls by outcome: ok, error, or timeout (no first token in time).", "backend", "model", "outcome"),
+		cache: r.Counter("museweb_cache_requests_total",
+			"Response cache lookups by result: hit or miss.", "result"),
+		tokens: r.Counter("museweb_tokens_total",
+			"Tokens used by backend, model, and kind (prompt or completion).", "backend", "model", "kind"),
+	}
+	r.GaugeFunc("museweb_uptime_seconds", "Seconds since the server started.", func() float64 {
+		return time.Since(s.started).Seconds()
+	})
+	r.GaugeFunc("museweb_generations_running", "Generations in progress under the generation limit.", func() float64 {
+		if s.Limit == nil {
+			return 0
+		}
+		return float64(s.Limit.Running())
+	})
+	r.GaugeFunc("museweb_generations_queued", "Requests waiting for a generation slot.", func() float64 {
+		if s.Limit == nil {
+			return 0
+		}
+		return float64(s.Limit.Queued())
+	})
+	return m
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	m.registry.ServeHTTP(w, r)
+}
+
+// Instrument counts the requests next answers by status code
+func (m *Metrics) Instrument(next http.Handler) http.Handler {
+	if m == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		m.requests.Inc(strconv.Itoa(sw.status))
+	})
+}
+
+// observePage records the latency of a page served for route since start
+func (m *Metrics) observePage(ctx context.Context, route string, start time.Time) {
+	if m != nil {
+		m.pages.ObserveWithTrace(time.Since(start).Seconds(), traceID(ctx), route)
+	}
+}
+
+// traceID returns the ID of the trace ctx is part of, or "" untraced
+func traceID(ctx context.Context) string {
+	return tracing.FromContext(ctx).TraceID()
+}
+
+// observeCache counts a response cache lookup
+func (m *Metrics) observeCache(hit bool) {
+	if m == nil {
+		return
+	}
+	if hit {
+		m.cache.Inc("hit")
+	} else {
+		m.cache.Inc("miss")
+	}
+}
+
+// observeFirstToken records the time to first token of a backend call
+func (m *Metrics) observeFirstToken(ctx context.Context, settings BackendSettings, start time.Time) {
+	if m != nil {
+		m.firstToken.ObserveWithTrace(time.Since(start).Seconds(), traceID(ctx), settings.Backend, settings.Model)
+	}
+}
+
+// observeStream records a finished backend call. Calls the client cancelled
+// say nothing about the backend and are not counted.
+func (m *Metrics) observeStream(ctx context.Context, settings BackendSettings, start time.Time, err error) {
+	if m == nil || ctx.Err() != nil {
+		return
+	}
+	outcome := "ok"
+	switch {
+	case errors.Is(err, errFirstByteTimeout):
+		outcome = "timeout"
+	case err != nil:
+		outcome = "error"
+	}
+	m.streams.ObserveWithTrace(time.Since(start).Seconds(), traceID(ctx), settings.Backend, settings.Model)
+	m.upstream.Inc(settings.Backend, settings.Model, outcome)
+}
+
+// countTokens wraps a usage callback so tokens are counted per backend
+func (m *Metrics) countTokens(settings BackendSettings, next func(models.Usage)) func(models.Usage) {
+	if m == nil {
+		return next
+	}
+	return func(u models.Usage) {
+		m.tokens.Add(float64(u.PromptTokens), settings.Backend, settings.Model, "prompt")
+		m.tokens.Add(float64(u.CompletionTokens), settings.Backend, settings.Model, "completion")
+		if next != nil {
+			next(u)
+		}
+	}
+}
+
+// statusWriter remembers the status code and counts the bytes written
+// through it
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// WriteHeader implements http.ResponseWriter
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements http.ResponseWriter
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+)
+
+// Render modes
+const (
+	RenderStream = "stream" // Stream raw HTML and let the browser parse it progressively
+	RenderMorph  = "morph"  // Serve a small shell that streams fragments and morphs the DOM
+)
+
+// FragmentHeader marks requests from the morph client for the raw page stream
+const FragmentHeader = "X-MuseWeb-Fragment"
+
+// wantsMorphShell reports whether r should get the morph shell instead of the
+// generated page. The shell's own fetch carries FragmentHeader, and
+// ?render=stream opts out (used as the no-JavaScript fallback).
+func (s *Server) wantsMorphShell(r *http.Request) bool {
+	return s.RenderMode == RenderMorph &&
+		r.Method == http.MethodGet &&
+		r.Header.Get(FragmentHeader) == "" &&
+		r.URL.Query().Get("render") != RenderStream
+}
+
+// serveMorphShell writes the shell page that renders the route with morphScript
+func (s *Server) serveMorphShell(w http.ResponseWriter, r *http.Request) {
+	fallback := *r.URL
+	q := fallback.Query()
+	q.Set("render", RenderStream)
+	fallback.RawQuery = q.Encode()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Vary", FragmentHeader)
+	err := morphShell.Execute(w, map[string]interface{}{
+		"Fallback":   fallback.RequestURI(),
+		"Script":     template.JS(morphScript),
+		"LiveReload": template.HTML(s.liveReloadSnippet()),
+	})
+	if err != nil {
+		log.Printf("Error writing morph shell: %v", err)
+	}
+}
+
+// liveReloadSnippet returns the live-reload script in dev mode, or ""
+func (s *Server) liveReloadSnippet() string {
+	if s.LiveReload == nil {
+		return ""
+	}
+	return liveReloadScript
+}
+
+var morphShell = template.Must(template.New("shell").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<noscript><meta http-equiv="refresh" content="0;url={{.Fallback}}"></noscript>
+<script>{{.Script}}</script>{{.LiveReload}}
+</head>
+<body></body>
+</html>
+`))
+
+// morphScript fetches the page stream and, on every animation frame that has
+// new data, parses everything received so far and morphs the live document to
+// match. Unchanged nodes are left alone, so sections appear without flicker and
+// a regeneration only touches the parts of the page that differ.
+//
+// window.museweb.regenerate(selector) renders the page again; with a selector
+// only the matching element is replaced.
+const morphScript = `(function(){
+var FRAGMENT=` + "\"" + FragmentHeader + "\"" + `;
+function same(a,b){return a.nodeType===b.nodeType&&a.nodeName===b.nodeName&&(a.nodeType!==1||a.id===b.id);}
+function morphAttrs(from,to){
+  for(var i=0;i<to.attributes.length;i++){var a=to.attributes[i];if(from.getAttribute(a.name)!==a.value)from.setAttribute(a.name,a.value);}
+  for(var j=from.attributes.length-1;j>=0;j--){var n=from.attributes[j].name;if(!to.hasAttribute(n))from.removeAttribute(n);}
+}
+function morphNode(from,to){
+  if(!same(from,to)){from.parentNode.replaceChild(document.importNode(to,true),from);return;}
+  if(from.nodeType!==1){if(from.nodeValue!==to.nodeValue)from.nodeValue=to.nodeValue;return;}
+  morphAttrs(from,to);
+  morphChildren(from,to);
+}
+function morphChildren(from,to){
+  var f=from.firstChild,t=to.firstChild;
+  while(t){
+    var tNext=t.nextSibling;
+    if(!f){from.appendChild(document.importNode(t,true));}
+    else{var fNext=f.nextSibling;morphNode(f,t);f=fNext;}
+    t=tNext;
+  }
+  while(f){var next=f.nextSibling;from.removeChild(f);f=next;}
+}
+function syncHead(doc){
+  if(doc.title&&document.title!==doc.title)document.title=doc.title;
+  var lang=doc.documentElement.getAttribute("lang");
+  if(lang)document.documentElement.setAttribute("lang",lang);
+  var have={};
+  Array.prototype.forEach.call(document.head.children,function(el){have[el.outerHTML]=true;});
+  Array.prototype.forEach.call(doc.head.querySelectorAll("style,link,meta"),function(el){
+    if(!have[el.outerHTML])document.head.appendChild(document.importNode(el,true));
+  });
+}
+function runScripts(root){
+  Array.prototype.forEach.call(root.querySelectorAll("script"),function(old){
+    var s=document.createElement("script");
+    for(var i=0;i<old.attributes.length;i++)s.setAttribute(old.attributes[i].name,old.attributes[i].value);
+    s.text=old.text;
+    old.parentNode.replaceChild(s,old);
+  });
+}
+var running=null;
+function render(selector){
+  if(running)running.abort();
+  var ctrl=running=new AbortController();
+  var html="",scheduled=false,decoder=new TextDecoder();
+  function apply(final){
+    scheduled=false;
+    var doc=new DOMParser().parseFromString(html,"text/html");
+    syncHead(doc);
+    if(selector){
+      var from=document.querySelector(selector),to=doc.querySelector(selector);
+      if(from&&to)morphNode(from,to);
+      if(final&&from)runScripts(document.querySelector(selector));
+      return;
+    }
+    morphAttrs(document.body,doc.body);
+    morphChildren(document.body,doc.body);
+    if(final)runScripts(document.body);
+  }
+  return fetch(location.href,{headers:{[FRAGMENT]:"1"},signal:ctrl.signal}).then(function(res){
+    var reader=res.body.getReader();
+    function pump(){
+      return reader.read().then(function(r){
+        if(r.done){html+=decoder.decode();apply(true);running=null;return;}
+        html+=decoder.decode(r.value,{stream:true});
+        if(!scheduled){scheduled=true;requestAnimationFrame(function(){apply(false);});}
+        return pump();
+      });
+    }
+    return pump();
+  }).catch(function(err){if(err.name!=="AbortError")console.error("museweb:",err);});
+}
+window.museweb={regenerate:render};
+if(document.readyState==="loading")document.addEventListener("DOMContentLoaded",function(){render();});else render();
+})();`
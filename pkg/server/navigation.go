@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kekePower/museweb/pkg/postprocess"
+)
+
+// navEntry is a route's place in the navigation, read from its front matter
+type navEntry struct {
+	route string
+	meta  FrontMatter
+}
+
+// BuildNavigation assembles the site navigation from the front matter of the
+// prompts in promptsDir (title, nav_order, parent). Drafts, non-HTML routes,
+// and prompts with nav_exclude are left out. Entries are sorted by nav_order,
+// then title; entries without a nav_order come last.
+func BuildNavigation(promptsDir string) ([]postprocess.NavItem, error) {
+	routes, err := ListRoutes(promptsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]navEntry{}
+	for _, route := range routes {
+		data, err := os.ReadFile(filepath.Join(promptsDir, promptFileName(route)))
+		if err != nil {
+			continue
+		}
+		meta, _, err := parseFrontMatter(data)
+		if err != nil || meta.NavExclude {
+			continue
+		}
+		if _, isHTML, err := contentType(route, meta.ContentType); err != nil || !isHTML {
+			continue
+		}
+		entries[route] = navEntry{route: route, meta: meta}
+	}
+
+	// Group by parent; unknown (or self-referencing) parents make top-level entries
+	children := map[string][]navEntry{}
+	for route, e := range entries {
+		parent := e.meta.Parent
+		if _, ok := entries[parent]; !ok || parent == route {
+			parent = ""
+		}
+		children[parent] = append(children[parent], e)
+	}
+	return navItems(children, "", map[string]bool{}), nil
+}
+
+// navItems builds the sorted items below parent; seen guards against parent cycles
+func navItems(children map[string][]navEntry, parent string, seen map[string]bool) []postprocess.NavItem {
+	list := children[parent]
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if (a.meta.NavOrder == 0) != (b.meta.NavOrder == 0) {
+			return a.meta.NavOrder != 0
+		}
+		if a.meta.NavOrder != b.meta.NavOrder {
+			return a.meta.NavOrder < b.meta.NavOrder
+		}
+		return navTitle(a) < navTitle(b)
+	})
+
+	var items []postprocess.NavItem
+	for _, e := range list {
+		if seen[e.route] {
+			continue
+		}
+		seen[e.route] = true
+		path := "/" + e.route
+		if e.route == "home" {
+			path = "/"
+		}
+		items = append(items, postprocess.NavItem{
+			Title:    navTitle(e),
+			Path:     path,
+			Children: navItems(children, e.route, seen),
+		})
+	}
+	return items
+}
+
+// navTitle is the front matter title, or one derived from the route name
+func navTitle(e navEntry) string {
+	if e.meta.Title != "" {
+		return e.meta.Title
+	}
+	words := strings.FieldsFunc(filepath.Base(e.route), func(r rune) bool {
+		return r == '-' || r == '_'
+	})
+	for i, w := range words {
+		words[i] = strings.ToUpper(w[:1]) + w[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+// navigationPrompt describes the site navigation for the user prompt, so the
+// model builds the same menu on every page
+func (s *Server) navigationPrompt(route string) string {
+	items, err := BuildNavigation(s.ActivePromptsDir())
+	if err != nil || len(items) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(items)
+	if err != nil {
+		return ""
+	}
+	current := "/" + route
+	if route == "home" {
+		current = "/"
+	}
+	return "\n\nSite navigation (JSON, nested by parent). Build the menu from exactly these links, in this order, and mark " +
+		current + " as the current page:\n" + string(data)
+}
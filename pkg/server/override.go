@@ -0,0 +1,64 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ModelHeader selects an allowed model for a single request, like ?model=
+const ModelHeader = "X-MuseWeb-Model"
+
+// SetModelOverrides lets requests pick one of names with ?model= or the
+// X-MuseWeb-Model header, to compare models on the same prompt. A name is a
+// named backend or, failing that, a model on the active backend.
+func (s *Server) SetModelOverrides(names []string) error {
+	allowed := make(map[string]bool, len(names))
+	for _, name := range names {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("model overrides must not be empty")
+		}
+		allowed[name] = true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.overrides = allowed
+	return nil
+}
+
+// requestedModel returns the model override a request asks for, if any
+func requestedModel(r *http.Request) string {
+	if name := r.URL.Query().Get("model"); name != "" {
+		return name
+	}
+	return r.Header.Get(ModelHeader)
+}
+
+// allowedOverride reports an error unless name is on the override allowlist
+func (s *Server) allowedOverride(name string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.overrides[name] {
+		return nil
+	}
+	if len(s.overrides) == 0 {
+		return fmt.Errorf("model overrides are not enabled")
+	}
+	allowed := make([]string, 0, len(s.overrides))
+	for n := range s.overrides {
+		allowed = append(allowed, n)
+	}
+	sort.Strings(allowed)
+	return fmt.Errorf("model %q is not allowed (use %s)", name, strings.Join(allowed, ", "))
+}
+
+// overrideFor returns the settings an allowed override generates with
+func (s *Server) overrideFor(name string) BackendSettings {
+	if settings, err := s.backendFor(name); err == nil {
+		return settings
+	}
+	settings := s.Active()
+	settings.Model = name
+	return settings
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxProxyBody caps request and response bodies passed through a proxy route
+const maxProxyBody = 10 << 20
+
+// maxProxyCache caps the cached responses per proxy route
+const maxProxyCache = 1000
+
+// proxyRequestHeaders are the client headers passed upstream. Cookies and
+// credentials for the site itself never leave MuseWeb.
+var proxyRequestHeaders = []string{"Accept", "Accept-Language", "Content-Type"}
+
+// APIProxy passes requests under Path on to Upstream, adding headers and query
+// parameters (such as API keys) that client-side scripts in generated pages
+// must not see. Values may reference environment variables as ${NAME}.
+type APIProxy struct {
+	Path     string            // e.g. "/api/proxy/weather"; subpaths are appended to Upstream
+	Upstream string            // e.g. "https://api.example.com/v1/weather"
+	Headers  map[string]string // Added to every upstream request
+	Query    map[string]string // Added to every upstream URL; clients cannot override them
+	Methods  []string          // Allowed methods, GET when empty
+	CacheTTL time.Duration     // Successful GET responses are cached this long (0 = never)
+	Timeout  time.Duration     // Upstream timeout, 30 seconds when 0
+}
+
+// proxyRoute is a configured proxy with its response cache
+type proxyRoute struct {
+	APIProxy
+	upstream *url.URL
+	client   *http.Client
+
+	mu    sync.Mutex
+	cache map[string]proxyEntry
+}
+
+type proxyEntry struct {
+	status      int
+	contentType string
+	body        []byte
+	expires     time.Time
+}
+
+// RegisterProxies adds the proxy routes to mux
+func RegisterProxies(mux *http.ServeMux, proxies []APIProxy) error {
+	seen := map[string]bool{}
+	for _, p := range proxies {
+		route, err := newProxyRoute(p)
+		if err != nil {
+			return err
+		}
+		if seen[route.Path] {
+			return fmt.Errorf("proxy %s is defined twice", route.Path)
+		}
+		seen[route.Path] = true
+		mux.Handle(route.Path, route)
+		mux.Handle(route.Path+"/", route)
+	}
+	return nil
+}
+
+// newProxyRoute checks p and resolves its environment references
+func newProxyRoute(p APIProxy) (*proxyRoute, error) {
+	p.Path = "/" + strings.Trim(p.Path, "/")
+	if p.Path == "/" {
+		return nil, fmt.Errorf("proxies need a path")
+	}
+	upstream, err := url.Parse(os.ExpandEnv(p.Upstream))
+	if err != nil || (upstream.Scheme != "http" && upstream.Scheme != "https") || upstream.Host == "" {
+		return nil, fmt.Errorf("proxy %s: upstream must be an http(s) URL", p.Path)
+	}
+	if len(p.Methods) == 0 {
+		p.Methods = []string{http.MethodGet}
+	}
+	for i, m := range p.Methods {
+		p.Methods[i] = strings.ToUpper(m)
+	}
+	if p.Timeout == 0 {
+		p.Timeout = 30 * time.Second
+	}
+	headers := make(map[string]string, len(p.Headers))
+	for k, v := range p.Headers {
+		headers[k] = os.ExpandEnv(v)
+	}
+	query := make(map[string]string, len(p.Query))
+	for k, v := range p.Query {
+		query[k] = os.ExpandEnv(v)
+	}
+	p.Headers, p.Query = headers, query
+	return &proxyRoute{
+		APIProxy: p,
+		upstream: upstream,
+		client:   &http.Client{Timeout: p.Timeout},
+		cache:    map[string]proxyEntry{},
+	}, nil
+}
+
+// allows reports whether the route accepts method
+func (pr *proxyRoute) allows(method string) bool {
+	for _, m := range pr.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// target builds the upstream URL for a request. The subpath is cleaned
+// first, so ".." segments cannot climb out of the upstream path.
+func (pr *proxyRoute) target(r *http.Request) string {
+	u := *pr.upstream
+	if rest := strings.TrimPrefix(r.URL.Path, pr.Path); rest != "" {
+		cleaned := path.Clean("/" + rest)
+		if strings.HasSuffix(rest, "/") && cleaned != "/" {
+			cleaned += "/"
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/") + cleaned
+		u.RawPath = ""
+	}
+	q := u.Query()
+	for k, vs := range r.URL.Query() {
+		if _, fixed := pr.Query[k]; !fixed {
+			q[k] = vs
+		}
+	}
+	for k, v := range pr.Query {
+		q.Set(k, v)
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// ServeHTTP passes the request upstream, or answers it from the cache
+func (pr *proxyRoute) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !pr.allows(r.Method) {
+		w.Header().Set("Allow", strings.Join(pr.Methods, ", "))
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	target := pr.target(r)
+	cacheable := pr.CacheTTL > 0 && r.Method == http.MethodGet
+	if cacheable {
+		if entry, ok := pr.cached(target); ok {
+			w.Header().Set("X-MuseWeb-Cache", "hit")
+			pr.write(w, entry)
+			return
+		}
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, target, http.MaxBytesReader(w, r.Body, maxProxyBody))
+	if err != nil {
+		http.Error(w, "Bad proxy request", http.StatusBadRequest)
+		return
+	}
+	for _, h := range proxyRequestHeaders {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	for k, v := range pr.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := pr.client.Do(req)
+	if err != nil {
+		log.Printf("⚠️  Proxy %s: %v", pr.Path, err)
+		http.Error(w, "Upstream unavailable", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxProxyBody+1))
+	if err != nil || len(body) > maxProxyBody {
+		log.Printf("⚠️  Proxy %s: unreadable or oversized upstream response", pr.Path)
+		http.Error(w, "Upstream response too large", http.StatusBadGateway)
+		return
+	}
+
+	entry := proxyEntry{status: resp.StatusCode, contentType: resp.Header.Get("Content-Type"), body: body}
+	if cacheable && resp.StatusCode == http.StatusOK {
+		pr.store(target, entry)
+	}
+	pr.write(w, entry)
+}
+
+// write sends a proxied response
+func (pr *proxyRoute) write(w http.ResponseWriter, entry proxyEntry) {
+	if entry.contentType != "" {
+		w.Header().Set("Content-Type", entry.contentType)
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(entry.status)
+	io.Copy(w, bytes.NewReader(entry.body))
+}
+
+// cached returns a fresh cached response for target
+func (pr *proxyRoute) cached(target string) (proxyEntry, bool) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	entry, ok := pr.cache[target]
+	if !ok || time.Now().After(entry.expires) {
+		return proxyEntry{}, false
+	}
+	return entry, true
+}
+
+// store caches a response, dropping expired ones when the cache is full
+func (pr *proxyRoute) store(target string, entry proxyEntry) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if len(pr.cache) >= maxProxyCache {
+		now := time.Now()
+		for k, e := range pr.cache {
+			if now.After(e.expires) {
+				delete(pr.cache, k)
+			}
+		}
+		if len(pr.cache) >= maxProxyCache {
+			return
+		}
+	}
+	entry.expires = time.Now().Add(pr.CacheTTL)
+	pr.cache[target] = entry
+}
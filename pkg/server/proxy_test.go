@@ -0,0 +1,167 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxy(t *testing.T) {
+	var got *http.Request
+	calls := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, calls = r.Clone(r.Context()), calls+1
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "upstream=1")
+		w.Header().Set("Connection", "X-Upstream-Hop")
+		w.Header().Set("X-Upstream-Hop", "1")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		io.WriteString(w, `{"temp":12}`)
+	}))
+	defer upstream.Close()
+	t.Setenv("WEATHER_KEY", "secret-key")
+
+	mux := http.NewServeMux()
+	err := RegisterProxies(mux, []APIProxy{
+		{
+			Path:     "/api/proxy/weather/",
+			Upstream: upstream.URL + "/v1/weather",
+			Headers:  map[string]string{"X-Api-Key": "${WEATHER_KEY}", "Accept": "application/json"},
+			Query:    map[string]string{"appid": "${WEATHER_KEY}"},
+			Methods:  []string{"get", "post"},
+		},
+		{Path: "/api/proxy/cached", Upstream: upstream.URL + "/v2/", CacheTTL: time.Minute},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(method, target string, header http.Header) *httptest.ResponseRecorder {
+		got = nil
+		req := httptest.NewRequest(method, target, strings.NewReader("city=Oslo"))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Subpaths are joined onto the upstream path and stay below it
+	for _, tc := range []struct {
+		path, want string
+	}{
+		{"/api/proxy/weather", "/v1/weather"},
+		{"/api/proxy/weather/", "/v1/weather/"},
+		{"/api/proxy/weather/today/oslo", "/v1/weather/today/oslo"},
+		{"/api/proxy/weather/today/", "/v1/weather/today/"},
+		{"/api/proxy/weather/%2e%2e/%2e%2e/admin", "/v1/weather/admin"},
+		{"/api/proxy/weather/..%2f..%2fadmin", "/v1/weather/admin"},
+		{"/api/proxy/weather/a%2F..%2F..%2F..%2Fadmin", "/v1/weather/admin"},
+		{"/api/proxy/weather/%3Fappid=mine", "/v1/weather/?appid=mine"},
+		{"/api/proxy/cached/forecast", "/v2/forecast"},
+	} {
+		rec := serve("GET", tc.path, nil)
+		if rec.Code != http.StatusOK || got == nil {
+			t.Errorf("%s: %d %q", tc.path, rec.Code, rec.Body)
+			continue
+		}
+		if got.URL.Path != tc.want {
+			t.Errorf("%s: upstream path %q, want %q", tc.path, got.URL.Path, tc.want)
+		}
+	}
+	// Unclean paths the mux sees are redirected before reaching the proxy
+	if rec := serve("GET", "/api/proxy/weather/../../admin", nil); got != nil || rec.Code/100 != 3 {
+		t.Errorf("unclean path: %d, upstream called %v", rec.Code, got != nil)
+	}
+
+	// Configured headers and query parameters win over the client's
+	rec := serve("POST", "/api/proxy/weather/today?appid=mine&units=metric&APPID=other", http.Header{
+		"X-Api-Key":           {"client-key"},
+		"Accept":              {"text/html"},
+		"Accept-Language":     {"nb"},
+		"Content-Type":        {"application/x-www-form-urlencoded"},
+		"Cookie":              {"museweb_admin=token"},
+		"Authorization":       {"Bearer site-token"},
+		"Connection":          {"Keep-Alive, X-Hop"},
+		"Keep-Alive":          {"timeout=5"},
+		"Te":                  {"trailers"},
+		"Upgrade":             {"websocket"},
+		"Proxy-Authorization": {"Basic abc"},
+		"X-Hop":               {"1"},
+		"X-Forwarded-For":     {"198.51.100.1"},
+	})
+	if rec.Code != http.StatusOK || got == nil {
+		t.Fatalf("POST: %d %q", rec.Code, rec.Body)
+	}
+	if want := (url.Values{"appid": {"secret-key"}, "units": {"metric"}, "APPID": {"other"}}); got.URL.Query().Encode() != want.Encode() {
+		t.Errorf("upstream query %q, want %q", got.URL.RawQuery, want.Encode())
+	}
+	for name, want := range map[string]string{
+		"X-Api-Key":       "secret-key",
+		"Accept":          "application/json",
+		"Accept-Language": "nb",
+		"Content-Type":    "application/x-www-form-urlencoded",
+	} {
+		if got.Header.Get(name) != want {
+			t.Errorf("upstream %s = %q, want %q", name, got.Header.Get(name), want)
+		}
+	}
+	// Credentials, hop-by-hop headers, and anything else stay behind
+	for _, name := range []string{"Cookie", "Authorization", "Keep-Alive", "Te", "Upgrade", "Proxy-Authorization", "X-Hop", "X-Forwarded-For"} {
+		if v := got.Header.Get(name); v != "" {
+			t.Errorf("upstream got %s: %q", name, v)
+		}
+	}
+	if c := got.Header.Get("Connection"); strings.Contains(c, "X-Hop") {
+		t.Errorf("upstream Connection = %q", c)
+	}
+	if body, _ := io.ReadAll(got.Body); string(body) != "" && string(body) != "city=Oslo" {
+		t.Errorf("upstream body %q", body)
+	}
+
+	// Only the content type comes back, with headers of MuseWeb's own
+	if rec.Body.String() != `{"temp":12}` || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("response %q (%s)", rec.Body, rec.Header().Get("Content-Type"))
+	}
+	for _, name := range []string{"Set-Cookie", "Connection", "X-Upstream-Hop", "Access-Control-Allow-Origin"} {
+		if v := rec.Header().Get(name); v != "" {
+			t.Errorf("client got %s: %q", name, v)
+		}
+	}
+	if rec.Header().Get("Cache-Control") != "no-store" || rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("response headers %v", rec.Header())
+	}
+
+	if rec := serve("DELETE", "/api/proxy/weather", nil); rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, POST" {
+		t.Errorf("DELETE: %d, Allow %q", rec.Code, rec.Header().Get("Allow"))
+	}
+
+	// Cached routes answer repeated GETs themselves
+	calls = 0
+	for i := 0; i < 2; i++ {
+		serve("GET", "/api/proxy/cached/forecast?day=1", nil)
+	}
+	if rec := serve("GET", "/api/proxy/cached/forecast?day=1", nil); calls != 1 || rec.Header().Get("X-MuseWeb-Cache") != "hit" {
+		t.Errorf("upstream called %d times, cache %q", calls, rec.Header().Get("X-MuseWeb-Cache"))
+	}
+}
+
+func TestRegisterProxiesErrors(t *testing.T) {
+	for _, tc := range []struct {
+		proxies []APIProxy
+		want    string
+	}{
+		{[]APIProxy{{Path: "/", Upstream: "https://api.example.com"}}, "need a path"},
+		{[]APIProxy{{Path: "/api/x", Upstream: "ftp://api.example.com"}}, "must be an http(s) URL"},
+		{[]APIProxy{{Path: "/api/x", Upstream: "/relative"}}, "must be an http(s) URL"},
+		{[]APIProxy{{Path: "/api/x", Upstream: "https://a.example.com"}, {Path: "api/x/", Upstream: "https://b.example.com"}}, "defined twice"},
+	} {
+		if err := RegisterProxies(http.NewServeMux(), tc.proxies); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%+v: %v, want %q", tc.proxies, err, tc.want)
+		}
+	}
+}
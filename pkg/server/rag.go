@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kekePower/museweb/pkg/rag"
+)
+
+// DefaultRAGTopK is how many chunks are added to a prompt when RAGTopK is 0
+const DefaultRAGTopK = 4
+
+// ragTimeout bounds looking up the context of one page
+const ragTimeout = 15 * time.Second
+
+// withSiteContext appends the site content most relevant to the page to its
+// user prompt. Lookup failures are logged and the page is generated without.
+func (s *Server) withSiteContext(ctx context.Context, route string, p prompts) string {
+	if s.RAG == nil || (p.Meta.RAG != nil && !*p.Meta.RAG) {
+		return p.User
+	}
+	k := s.RAGTopK
+	if k <= 0 {
+		k = DefaultRAGTopK
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ragTimeout)
+	defer cancel()
+	chunks, err := s.RAG.Search(ctx, p.User, k)
+	if err != nil {
+		log.Printf("⚠️  Could not look up site content for /%s: %v", route, err)
+		return p.User
+	}
+	if len(chunks) == 0 {
+		return p.User
+	}
+	if s.Debug {
+		sources := make([]string, len(chunks))
+		for i, c := range chunks {
+			sources[i] = c.Source
+		}
+		log.Printf("[DEBUG] Adding %d chunks of site content to /%s from %s", len(chunks), route, strings.Join(sources, ", "))
+	}
+	return p.User + formatSiteContext(chunks)
+}
+
+// formatSiteContext presents the chunks to the model
+func formatSiteContext(chunks []rag.Chunk) string {
+	var b strings.Builder
+	b.WriteString("\n\nRelevant content from this site. Base facts on it rather than inventing them:\n")
+	for _, c := range chunks {
+		b.WriteString("\n--- ")
+		b.WriteString(c.Source)
+		b.WriteString(" ---\n")
+		b.WriteString(c.Text)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Redirect sends requests for an old route to its new location
+type Redirect struct {
+	From   string // Old route, e.g. "about-us" or "index.html"
+	To     string // Route ("about", "home") or absolute URL
+	Status int    // 301 (default), 302, 303, 307, or 308
+}
+
+// redirectStatuses are the accepted Redirect.Status values
+var redirectStatuses = map[int]bool{
+	http.StatusMovedPermanently:  true,
+	http.StatusFound:             true,
+	http.StatusSeeOther:          true,
+	http.StatusTemporaryRedirect: true,
+	http.StatusPermanentRedirect: true,
+}
+
+// normalizeRoute turns a path like "/about-us/" into the route "about-us"
+func normalizeRoute(p string) string {
+	route := strings.Trim(p, "/")
+	if route == "" {
+		return "home"
+	}
+	return route
+}
+
+// isAbsoluteURL reports whether a redirect target leaves the site
+func isAbsoluteURL(to string) bool {
+	return strings.HasPrefix(to, "http://") || strings.HasPrefix(to, "https://")
+}
+
+// SetRedirects configures redirects from old routes. They are checked before
+// prompts, so a redirect can retire a page whose prompt still exists.
+func (s *Server) SetRedirects(redirects []Redirect) error {
+	byRoute := make(map[string]Redirect, len(redirects))
+	for _, rd := range redirects {
+		if rd.From == "" || rd.To == "" {
+			return fmt.Errorf("redirects need both from and to")
+		}
+		rd.From = normalizeRoute(rd.From)
+		if !isAbsoluteURL(rd.To) {
+			rd.To = normalizeRoute(rd.To)
+		}
+		if rd.Status == 0 {
+			rd.Status = http.StatusMovedPermanently
+		}
+		if !redirectStatuses[rd.Status] {
+			return fmt.Errorf("redirect from %q: unsupported status %d (use 301, 302, 303, 307, or 308)", rd.From, rd.Status)
+		}
+		if _, exists := byRoute[rd.From]; exists {
+			return fmt.Errorf("redirect from %q is defined twice", rd.From)
+		}
+		byRoute[rd.From] = rd
+	}
+
+	// Refuse loops; a chain of redirects is fine as long as it ends
+	for from := range byRoute {
+		seen := map[string]bool{}
+		for route := from; ; {
+			rd, ok := byRoute[route]
+			if !ok {
+				break
+			}
+			if seen[route] {
+				return fmt.Errorf("redirects from %q form a loop", from)
+			}
+			seen[route] = true
+			route = rd.To
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.redirects = byRoute
+	return nil
+}
+
+// redirectFor returns the redirect for route: a configured one, or the prompt
+// listing route in its "aliases" front matter when route has no prompt of its own
+func (s *Server) redirectFor(route string) (Redirect, bool) {
+	s.mu.RLock()
+	rd, ok := s.redirects[route]
+	s.mu.RUnlock()
+	if ok {
+		return rd, true
+	}
+	if s.HasRoute(route) {
+		return Redirect{}, false
+	}
+	if target := s.aliasTarget(route); target != "" {
+		return Redirect{From: route, To: target, Status: http.StatusMovedPermanently}, true
+	}
+	return Redirect{}, false
+}
+
+// Redirected reports whether requests for route are redirected
+func (s *Server) Redirected(route string) bool {
+	_, ok := s.redirectFor(normalizeRoute(route))
+	return ok
+}
+
+// aliasTarget finds the prompt whose front matter lists route as an alias.
+// Prompts are read on every lookup, like everything else, so new aliases work
+// without a restart; lookups only happen for routes without a prompt.
+func (s *Server) aliasTarget(route string) string {
+	routes, err := ListRoutes(s.ActivePromptsDir())
+	if err != nil {
+		return ""
+	}
+	for _, target := range routes {
+		data, err := os.ReadFile(filepath.Join(s.ActivePromptsDir(), promptFileName(target)))
+		if err != nil {
+			continue
+		}
+		meta, _, err := parseFrontMatter(data)
+		if err != nil {
+			continue
+		}
+		for _, alias := range meta.Aliases {
+			if normalizeRoute(alias) == route && target != route {
+				return target
+			}
+		}
+	}
+	return ""
+}
+
+// serveRedirect answers r with rd, keeping the query string (e.g. ?lang=)
+func (s *Server) serveRedirect(w http.ResponseWriter, r *http.Request, rd Redirect) {
+	location := rd.To
+	if !isAbsoluteURL(location) {
+		location = "/" + location
+		if rd.To == "home" {
+			location = "/"
+		}
+	}
+	if r.URL.RawQuery != "" && !strings.Contains(location, "?") {
+		location += "?" + r.URL.RawQuery
+	}
+	if s.Debug {
+		log.Printf("↪️  Redirecting /%s to %s (%d)", rd.From, location, rd.Status)
+	}
+	http.Redirect(w, r, location, rd.Status)
+}
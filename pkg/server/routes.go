@@ -0,0 +1,57 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// reservedPromptFiles are prompt-directory files that never map to a route
+var reservedPromptFiles = map[string]bool{
+	"system_prompt.txt": true,
+	"layout.txt":        true,
+	"layout.min.txt":    true,
+}
+
+// HasRoute reports whether promptsDir has a prompt file for route. main uses it
+// to let prompts such as events.ics.txt answer paths that look like static files.
+func (s *Server) HasRoute(route string) bool {
+	if !validRoute(route) {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(s.ActivePromptsDir(), promptFileName(route)))
+	return err == nil && !info.IsDir()
+}
+
+// validRoute reports whether route may name a page prompt: it stays inside
+// the prompts directory and is not one of the reserved files
+func validRoute(route string) bool {
+	return route != "" && !strings.Contains(route, "..") && !strings.HasPrefix(route, "/") &&
+		!reservedPromptFiles[promptFileName(route)]
+}
+
+// ListRoutes returns the public routes served from promptsDir, sorted by name.
+// Draft prompts are left out.
+func ListRoutes(promptsDir string) ([]string, error) {
+	entries, err := os.ReadDir(promptsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var routes []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != ".txt" || reservedPromptFiles[name] {
+			continue
+		}
+		if data, err := os.ReadFile(filepath.Join(promptsDir, name)); err == nil {
+			if meta, _, err := parseFrontMatter(data); err == nil && meta.Draft {
+				continue
+			}
+		}
+		routes = append(routes, strings.TrimSuffix(name, ".txt"))
+	}
+	sort.Strings(routes)
+	return routes, nil
+}
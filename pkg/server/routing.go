@@ -0,0 +1,135 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kekePower/museweb/pkg/models"
+)
+
+// routingEscalation is how long a route stays off a backend that kept
+// producing flawed pages for it
+const routingEscalation = time.Hour
+
+// RoutingRule sends the pages it matches to a named backend, so small and
+// simple pages can use a cheap, fast model and complex ones a stronger one.
+// Every condition that is set must hold.
+type RoutingRule struct {
+	Backend         string   // Named backend the matching pages are generated with
+	Routes          []string // Route globs such as "blog/*" (empty = every route)
+	Complexity      []string // Values of the "complexity" front matter hint (empty = any)
+	MinPromptTokens int      // Smallest assembled prompt, estimated
+	MaxPromptTokens int      // Largest assembled prompt, estimated (0 = no limit)
+}
+
+// matches reports whether the rule applies to a page
+func (r RoutingRule) matches(route, complexity string, tokens int) bool {
+	if len(r.Routes) > 0 && !slices.ContainsFunc(r.Routes, func(pattern string) bool {
+		ok, _ := path.Match(strings.Trim(pattern, "/"), route)
+		return ok
+	}) {
+		return false
+	}
+	if len(r.Complexity) > 0 && !slices.Contains(r.Complexity, complexity) {
+		return false
+	}
+	return tokens >= r.MinPromptTokens && (r.MaxPromptTokens == 0 || tokens <= r.MaxPromptTokens)
+}
+
+// routingState is the configured routing and what it learned about routes
+type routingState struct {
+	rules         []RoutingRule
+	escalateAfter int // Flawed pages in a row before a route skips a rule's backend (0 = never)
+
+	mu      sync.Mutex
+	strikes map[string]int       // route|backend -> flawed generations in a row
+	skip    map[string]time.Time // route|backend -> skipped until
+}
+
+// SetRouting routes pages without a "backend" in their front matter by the
+// first rule they match; pages matching none use the active backend. A route
+// whose routed generations fail or break their guardrails escalateAfter
+// times in a row skips that rule for a while, moving on to the next one.
+func (s *Server) SetRouting(rules []RoutingRule, escalateAfter int) error {
+	for i, rule := range rules {
+		if _, err := s.backendFor(rule.Backend); rule.Backend == "" || err != nil {
+			return fmt.Errorf("rule %d: unknown backend %q", i+1, rule.Backend)
+		}
+		for _, pattern := range rule.Routes {
+			if _, err := path.Match(strings.Trim(pattern, "/"), ""); err != nil {
+				return fmt.Errorf("rule %d: invalid route pattern %q: %w", i+1, pattern, err)
+			}
+		}
+		if rule.MinPromptTokens < 0 || rule.MaxPromptTokens < 0 || (rule.MaxPromptTokens > 0 && rule.MinPromptTokens > rule.MaxPromptTokens) {
+			return fmt.Errorf("rule %d: invalid prompt token range %d-%d", i+1, rule.MinPromptTokens, rule.MaxPromptTokens)
+		}
+	}
+	if escalateAfter < 0 {
+		return fmt.Errorf("escalate_after must not be negative")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.routing = &routingState{rules: rules, escalateAfter: escalateAfter, strikes: map[string]int{}, skip: map[string]time.Time{}}
+	return nil
+}
+
+// routeFor picks the named backend for a page, or "" to leave it on its own
+// backend. Pages that name a backend or model and per-request model picks
+// are never routed.
+func (s *Server) routeFor(req PageRequest, p prompts) string {
+	s.mu.RLock()
+	rs := s.routing
+	s.mu.RUnlock()
+	if rs == nil || p.Meta.Backend != "" || p.Meta.Model != "" || req.Model != "" {
+		return ""
+	}
+
+	tokens := models.EstimateTokens(p.System) + models.EstimateTokens(p.User)
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for _, rule := range rs.rules {
+		if !rule.matches(req.Route, p.Meta.Complexity, tokens) {
+			continue
+		}
+		if until, ok := rs.skip[req.Route+"|"+rule.Backend]; ok {
+			if time.Now().Before(until) {
+				continue
+			}
+			delete(rs.skip, req.Route+"|"+rule.Backend)
+		}
+		if s.Debug {
+			log.Printf("[DEBUG] Routing /%s (about %d prompt tokens, complexity %q) to backend '%s'", req.Route, tokens, p.Meta.Complexity, rule.Backend)
+		}
+		return rule.Backend
+	}
+	return ""
+}
+
+// observeRouted records how a routed generation went. Flawed pages in a row
+// take the route off the backend for routingEscalation.
+func (s *Server) observeRouted(route, backend string, ok bool) {
+	s.mu.RLock()
+	rs := s.routing
+	s.mu.RUnlock()
+	if rs == nil || rs.escalateAfter == 0 {
+		return
+	}
+	key := route + "|" + backend
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if ok {
+		delete(rs.strikes, key)
+		return
+	}
+	rs.strikes[key]++
+	if rs.strikes[key] >= rs.escalateAfter {
+		delete(rs.strikes, key)
+		rs.skip[key] = time.Now().Add(routingEscalation)
+		log.Printf("🧭 /%s failed on backend '%s' %d times in a row, routing it elsewhere for %s", route, backend, rs.escalateAfter, routingEscalation)
+	}
+}
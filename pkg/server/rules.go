@@ -0,0 +1,129 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// RouteRule adjusts the responses for routes matching a glob pattern
+type RouteRule struct {
+	// Pattern is matched against the route ("home", "blog/post", "events.ics")
+	Pattern string
+	// Headers are set on every response, replacing values set by MuseWeb
+	Headers map[string]string
+	// NoCompression hides Accept-Encoding from the handlers, so no compression
+	// layer encodes the response
+	NoCompression bool
+	// NoCache forbids storing the response in browsers and shared caches
+	NoCache bool
+}
+
+// RouteRules are applied in order; later rules override the headers of earlier ones
+type RouteRules []RouteRule
+
+// Validate checks that every pattern is a valid glob
+func (rules RouteRules) Validate() error {
+	for _, rule := range rules {
+		if _, err := path.Match(strings.Trim(rule.Pattern, "/"), ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", rule.Pattern, err)
+		}
+	}
+	return nil
+}
+
+// match returns the rules that apply to route, merged into one
+func (rules RouteRules) match(route string) (RouteRule, bool) {
+	merged := RouteRule{Headers: map[string]string{}}
+	found := false
+	for _, rule := range rules {
+		if ok, _ := path.Match(strings.Trim(rule.Pattern, "/"), route); !ok {
+			continue
+		}
+		found = true
+		for name, value := range rule.Headers {
+			merged.Headers[name] = value
+		}
+		merged.NoCompression = merged.NoCompression || rule.NoCompression
+		merged.NoCache = merged.NoCache || rule.NoCache
+	}
+	return merged, found
+}
+
+// Middleware applies the matching rules to requests before passing them to next
+func (rules RouteRules) Middleware(next http.Handler) http.Handler {
+	if len(rules) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := strings.Trim(r.URL.Path, "/")
+		if route == "" {
+			route = "home"
+		}
+		rule, ok := rules.match(route)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if rule.NoCompression {
+			r.Header.Del("Accept-Encoding")
+		}
+		if rule.NoCache {
+			// Always send the full response instead of 304 Not Modified
+			r.Header.Del("If-Modified-Since")
+			r.Header.Del("If-None-Match")
+		}
+		next.ServeHTTP(&ruleWriter{ResponseWriter: w, rule: rule}, r)
+	})
+}
+
+// ruleWriter applies a route rule to the headers just before they are sent,
+// so the configured values win over those set while handling the request
+type ruleWriter struct {
+	http.ResponseWriter
+	rule        RouteRule
+	wroteHeader bool
+}
+
+// WriteHeader implements http.ResponseWriter
+func (rw *ruleWriter) WriteHeader(status int) {
+	if !rw.wroteHeader {
+		rw.wroteHeader = true
+		h := rw.Header()
+		if rw.rule.NoCache {
+			h.Set("Cache-Control", "no-store")
+			h.Set("Pragma", "no-cache")
+			h.Del("ETag")
+			h.Del("Last-Modified")
+		}
+		for name, value := range rw.rule.Headers {
+			h.Set(name, value)
+		}
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter
+func (rw *ruleWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher so pages keep streaming
+func (rw *ruleWriter) Flush() {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying connection
+func (rw *ruleWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScheduleRule swaps in a variant of a prompt while it is in effect, declared
+// in front matter. The first rule in effect wins; outside every rule the
+// prompt is used as written:
+//
+//	---
+//	schedule:
+//	  - name: holidays
+//	    dates: "12-20..12-26"
+//	    prompt: A festive homepage with a winter holiday theme...
+//	  - name: night
+//	    hours: "22:00..06:00"
+//	    append: It is night time; use a dark color scheme.
+//	---
+//	A homepage for...
+type ScheduleRule struct {
+	Name     string   `yaml:"name"`
+	Dates    string   `yaml:"dates"`    // "12-20..12-26" every year, or "2025-11-28..2025-12-01"
+	Hours    string   `yaml:"hours"`    // "09:00..17:00"; may wrap past midnight
+	Weekdays []string `yaml:"weekdays"` // "mon" ... "sun"
+	Timezone string   `yaml:"timezone"` // IANA name; the server's local time by default
+	Prompt   string   `yaml:"prompt"`   // Replaces the prompt body
+	Append   string   `yaml:"append"`   // Is added to the prompt body
+}
+
+// weekdays maps the accepted weekday names to time.Weekday
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// validateSchedule checks the declared schedule of a prompt
+func validateSchedule(rules []ScheduleRule) error {
+	for i, rule := range rules {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		if _, err := rule.matches(time.Now()); err != nil {
+			return fmt.Errorf("schedule %s: %w", name, err)
+		}
+		if rule.Dates == "" && rule.Hours == "" && len(rule.Weekdays) == 0 {
+			return fmt.Errorf("schedule %s: needs dates, hours, or weekdays", name)
+		}
+		if (rule.Prompt == "") == (rule.Append == "") {
+			return fmt.Errorf("schedule %s: needs either prompt or append", name)
+		}
+	}
+	return nil
+}
+
+// activeSchedule returns the first rule in effect at now, or nil. Rules are
+// validated when the prompt is loaded, so errors here only skip the rule.
+func activeSchedule(rules []ScheduleRule, now time.Time) *ScheduleRule {
+	for i := range rules {
+		if ok, err := rules[i].matches(now); err == nil && ok {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// apply returns the prompt body with the rule's variant in place
+func (r ScheduleRule) apply(body string) string {
+	if r.Prompt != "" {
+		return r.Prompt
+	}
+	return strings.TrimRight(body, "\n") + "\n\n" + r.Append
+}
+
+// matches reports whether every condition of the rule holds at now
+func (r ScheduleRule) matches(now time.Time) (bool, error) {
+	if r.Timezone != "" {
+		loc, err := time.LoadLocation(r.Timezone)
+		if err != nil {
+			return false, fmt.Errorf("unknown timezone %q", r.Timezone)
+		}
+		now = now.In(loc)
+	}
+
+	ok := true
+	if r.Dates != "" {
+		in, err := inDateRange(r.Dates, now)
+		if err != nil {
+			return false, err
+		}
+		ok = ok && in
+	}
+	if r.Hours != "" {
+		in, err := inHours(r.Hours, now)
+		if err != nil {
+			return false, err
+		}
+		ok = ok && in
+	}
+	if len(r.Weekdays) > 0 {
+		in := false
+		for _, name := range r.Weekdays {
+			day, known := weekdays[strings.ToLower(strings.TrimSpace(name))]
+			if !known {
+				return false, fmt.Errorf("unknown weekday %q (use mon, tue, ... sun)", name)
+			}
+			in = in || day == now.Weekday()
+		}
+		ok = ok && in
+	}
+	return ok, nil
+}
+
+// splitRange splits "a..b" into its ends; a single value is a range of one
+func splitRange(s string) (string, string) {
+	from, to, found := strings.Cut(s, "..")
+	if !found {
+		to = from
+	}
+	return strings.TrimSpace(from), strings.TrimSpace(to)
+}
+
+// inDateRange reports whether now falls in a range of dates, inclusive. Month
+// and day ranges repeat every year and may wrap past New Year.
+func inDateRange(dates string, now time.Time) (bool, error) {
+	from, to := splitRange(dates)
+	yearly := len(from) == len("01-02")
+	if len(to) != len(from) {
+		return false, fmt.Errorf("dates %q mixes yearly and full dates", dates)
+	}
+
+	layout := "2006-01-02"
+	if yearly {
+		layout = "01-02"
+	}
+	start, err := time.Parse(layout, from)
+	if err != nil {
+		return false, fmt.Errorf("invalid dates %q (use MM-DD..MM-DD or YYYY-MM-DD..YYYY-MM-DD)", dates)
+	}
+	end, err := time.Parse(layout, to)
+	if err != nil {
+		return false, fmt.Errorf("invalid dates %q (use MM-DD..MM-DD or YYYY-MM-DD..YYYY-MM-DD)", dates)
+	}
+
+	if yearly {
+		day := int(now.Month())*100 + now.Day()
+		lo := int(start.Month())*100 + start.Day()
+		hi := int(end.Month())*100 + end.Day()
+		if lo <= hi {
+			return lo <= day && day <= hi, nil
+		}
+		return day >= lo || day <= hi, nil
+	}
+	if end.Before(start) {
+		return false, fmt.Errorf("dates %q end before they start", dates)
+	}
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return !day.Before(start) && !day.After(end), nil
+}
+
+// inHours reports whether now falls in a daily window; the end is exclusive
+func inHours(hours string, now time.Time) (bool, error) {
+	from, to := splitRange(hours)
+	lo, err := minuteOfDay(from)
+	if err != nil {
+		return false, fmt.Errorf("invalid hours %q (use HH:MM..HH:MM)", hours)
+	}
+	hi, err := minuteOfDay(to)
+	if err != nil || lo == hi {
+		return false, fmt.Errorf("invalid hours %q (use HH:MM..HH:MM)", hours)
+	}
+	minute := now.Hour()*60 + now.Minute()
+	if lo < hi {
+		return lo <= minute && minute < hi, nil
+	}
+	return minute >= lo || minute < hi, nil
+}
+
+// minuteOfDay parses "HH:MM" ("24:00" is the end of the day)
+func minuteOfDay(s string) (int, error) {
+	hh, mm, found := strings.Cut(s, ":")
+	if !found {
+		return 0, fmt.Errorf("missing colon")
+	}
+	h, err := strconv.Atoi(hh)
+	if err != nil {
+		return 0, err
+	}
+	m, err := strconv.Atoi(mm)
+	if err != nil {
+		return 0, err
+	}
+	if h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("out of range")
+	}
+	return h*60 + m, nil
+}
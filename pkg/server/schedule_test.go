@@ -0,0 +1,117 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduleRuleMatches(t *testing.T) {
+	at := func(value string) time.Time {
+		now, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return now
+	}
+	for _, tc := range []struct {
+		name string
+		rule ScheduleRule
+		now  string
+		want bool
+	}{
+		// Yearly dates, inclusive, across New Year
+		{"holidays, first day", ScheduleRule{Dates: "12-20..12-26"}, "2026-12-20T00:00:00Z", true},
+		{"holidays, last day", ScheduleRule{Dates: "12-20..12-26"}, "2026-12-26T23:59:00Z", true},
+		{"holidays, after", ScheduleRule{Dates: "12-20..12-26"}, "2026-12-27T00:00:00Z", false},
+		{"new year, december", ScheduleRule{Dates: "12-30..01-02"}, "2026-12-31T12:00:00Z", true},
+		{"new year, january", ScheduleRule{Dates: "12-30..01-02"}, "2027-01-02T12:00:00Z", true},
+		{"new year, after", ScheduleRule{Dates: "12-30..01-02"}, "2027-01-03T00:00:00Z", false},
+		{"new year, before", ScheduleRule{Dates: "12-30..01-02"}, "2026-12-29T23:59:00Z", false},
+		{"single day", ScheduleRule{Dates: "02-29"}, "2028-02-29T08:00:00Z", true},
+		// Full dates only match their year
+		{"black friday", ScheduleRule{Dates: "2025-11-28..2025-12-01"}, "2025-12-01T22:00:00Z", true},
+		{"black friday, a year on", ScheduleRule{Dates: "2025-11-28..2025-12-01"}, "2026-11-29T12:00:00Z", false},
+		{"across the year end", ScheduleRule{Dates: "2026-12-31..2027-01-01"}, "2027-01-01T12:00:00Z", true},
+		// Hours, the end exclusive, across midnight
+		{"office hours", ScheduleRule{Hours: "09:00..17:00"}, "2026-10-16T09:00:00Z", true},
+		{"office hours, end", ScheduleRule{Hours: "09:00..17:00"}, "2026-10-16T17:00:00Z", false},
+		{"night, evening", ScheduleRule{Hours: "22:00..06:00"}, "2026-10-16T23:30:00Z", true},
+		{"night, midnight", ScheduleRule{Hours: "22:00..06:00"}, "2026-10-16T00:00:00Z", true},
+		{"night, morning", ScheduleRule{Hours: "22:00..06:00"}, "2026-10-16T05:59:00Z", true},
+		{"night, day", ScheduleRule{Hours: "22:00..06:00"}, "2026-10-16T06:00:00Z", false},
+		{"until the end of the day", ScheduleRule{Hours: "18:00..24:00"}, "2026-10-16T23:59:00Z", true},
+		{"new year's eve night", ScheduleRule{Dates: "12-31", Hours: "20:00..24:00"}, "2026-12-31T21:00:00Z", true},
+		// Weekdays, combined with the other conditions
+		{"weekend", ScheduleRule{Weekdays: []string{"sat", " Sun "}}, "2026-10-18T12:00:00Z", true},
+		{"weekend, friday", ScheduleRule{Weekdays: []string{"sat", "sun"}}, "2026-10-16T12:00:00Z", false},
+		{"friday night", ScheduleRule{Weekdays: []string{"fri"}, Hours: "22:00..06:00"}, "2026-10-16T23:00:00Z", true},
+		{"friday night, saturday morning", ScheduleRule{Weekdays: []string{"fri"}, Hours: "22:00..06:00"}, "2026-10-17T01:00:00Z", false},
+		// The rule's timezone decides the day and hour
+		{"utc evening, morning in auckland", ScheduleRule{Hours: "06:00..09:00", Timezone: "Pacific/Auckland"}, "2026-10-15T19:00:00Z", true},
+		{"utc evening, not in utc", ScheduleRule{Hours: "06:00..09:00"}, "2026-10-15T19:00:00Z", false},
+		{"new year in auckland first", ScheduleRule{Dates: "01-01", Timezone: "Pacific/Auckland"}, "2026-12-31T12:00:00Z", true},
+		{"still the old year in oslo", ScheduleRule{Dates: "01-01", Timezone: "Europe/Oslo"}, "2026-12-31T12:00:00Z", false},
+		{"monday in auckland, sunday in utc", ScheduleRule{Weekdays: []string{"mon"}, Timezone: "Pacific/Auckland"}, "2026-10-18T20:00:00Z", true},
+		{"other offsets are converted", ScheduleRule{Hours: "09:00..17:00", Timezone: "UTC"}, "2026-10-16T18:00:00+02:00", true},
+	} {
+		got, err := tc.rule.matches(at(tc.now))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: matches %s = %v, want %v", tc.name, tc.now, got, tc.want)
+		}
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	for _, tc := range []struct {
+		rule ScheduleRule
+		want string
+	}{
+		{ScheduleRule{Dates: "12-20..12-26", Append: "x"}, ""},
+		{ScheduleRule{Append: "x"}, "needs dates, hours, or weekdays"},
+		{ScheduleRule{Hours: "09:00..17:00"}, "needs either prompt or append"},
+		{ScheduleRule{Hours: "09:00..17:00", Prompt: "x", Append: "y"}, "needs either prompt or append"},
+		{ScheduleRule{Dates: "12-20..2026-12-26", Append: "x"}, "mixes yearly and full dates"},
+		{ScheduleRule{Dates: "13-01", Append: "x"}, "invalid dates"},
+		{ScheduleRule{Dates: "2026-12-26..2026-12-20", Append: "x"}, "end before they start"},
+		{ScheduleRule{Hours: "09:00..09:00", Append: "x"}, "invalid hours"},
+		{ScheduleRule{Hours: "9..17", Append: "x"}, "invalid hours"},
+		{ScheduleRule{Hours: "24:30..01:00", Append: "x"}, "invalid hours"},
+		{ScheduleRule{Weekdays: []string{"monday"}, Append: "x"}, `unknown weekday "monday"`},
+		{ScheduleRule{Name: "night", Hours: "22:00..06:00", Timezone: "Mars/Olympus", Append: "x"}, `schedule night: unknown timezone "Mars/Olympus"`},
+	} {
+		err := validateSchedule([]ScheduleRule{tc.rule})
+		if tc.want == "" && err != nil || tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+			t.Errorf("%+v: %v, want %q", tc.rule, err, tc.want)
+		}
+	}
+}
+
+func TestActiveSchedule(t *testing.T) {
+	rules := []ScheduleRule{
+		{Name: "broken", Hours: "nope", Append: "never"},
+		{Name: "holidays", Dates: "12-20..12-26", Prompt: "A festive homepage"},
+		{Name: "night", Hours: "22:00..06:00", Append: "Use a dark color scheme."},
+	}
+	for _, tc := range []struct {
+		now, rule, body string
+	}{
+		{"2026-12-24T23:00:00Z", "holidays", "A festive homepage"}, // The first rule in effect wins
+		{"2026-10-16T23:00:00Z", "night", "A homepage\n\nUse a dark color scheme."},
+		{"2026-10-16T12:00:00Z", "", "A homepage\n"},
+	} {
+		now, _ := time.Parse(time.RFC3339, tc.now)
+		rule := activeSchedule(rules, now)
+		name, body := "", "A homepage\n"
+		if rule != nil {
+			name, body = rule.Name, rule.apply(body)
+		}
+		if name != tc.rule || body != tc.body {
+			t.Errorf("%s: rule %q with %q, want %q with %q", tc.now, name, body, tc.rule, tc.body)
+		}
+	}
+}
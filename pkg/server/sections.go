@@ -0,0 +1,156 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/kekePower/museweb/pkg/models"
+)
+
+// Section is one part of a page generated by its own model call, declared in
+// front matter:
+//
+//	---
+//	sections:
+//	  - name: hero
+//	    prompt: A bold hero with the tagline...
+//	  - name: features
+//	    prompt: Three feature cards...
+//	---
+//	A landing page for...
+type Section struct {
+	Name   string `yaml:"name"`
+	Prompt string `yaml:"prompt"`
+}
+
+// sectionName limits names to what works as an HTML id
+var sectionName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+
+// validateSections checks the declared sections of a prompt
+func validateSections(sections []Section, isHTML bool) error {
+	if len(sections) == 0 {
+		return nil
+	}
+	if !isHTML {
+		return fmt.Errorf("sections are only supported for HTML pages")
+	}
+	seen := map[string]bool{}
+	for _, sec := range sections {
+		if !sectionName.MatchString(sec.Name) {
+			return fmt.Errorf("invalid section name %q", sec.Name)
+		}
+		if seen[sec.Name] {
+			return fmt.Errorf("section %q is declared twice", sec.Name)
+		}
+		seen[sec.Name] = true
+		if strings.TrimSpace(sec.Prompt) == "" {
+			return fmt.Errorf("section %q has no prompt", sec.Name)
+		}
+	}
+	return nil
+}
+
+// sectionPrompt is the user prompt for section i: the page's shared prompt,
+// the section's own instructions, and where it sits in the document
+func sectionPrompt(userPrompt string, sections []Section, i int) string {
+	sec := sections[i]
+	var b strings.Builder
+	b.WriteString(userPrompt)
+	fmt.Fprintf(&b, "\n\nThis page is written in %d parts at the same time, which are joined in order. Write ONLY part %d, the %q section: %s\n",
+		len(sections), i+1, sec.Name, strings.TrimSpace(sec.Prompt))
+	switch {
+	case len(sections) == 1:
+		b.WriteString("Output the complete HTML document for the page.")
+	case i == 0:
+		fmt.Fprintf(&b, "Start the document: <!DOCTYPE html>, the complete <head> with the styles for the whole page, and the opening <body>, "+
+			"then the section in <section id=%q>. Do not close <body> or <html>; the other parts follow.", sec.Name)
+	case i == len(sections)-1:
+		fmt.Fprintf(&b, "Output only the section in <section id=%q>, then close the page with </body></html>. "+
+			"Do not repeat <!DOCTYPE>, <head>, or the opening <body>.", sec.Name)
+	default:
+		fmt.Fprintf(&b, "Output only the section in <section id=%q>, without <!DOCTYPE>, <html>, <head>, or <body> tags.", sec.Name)
+	}
+	return b.String()
+}
+
+// generatePage generates the page in one call, or section by section when its
+// prompt declares sections
+func (s *Server) generatePage(ctx context.Context, w io.Writer, flusher http.Flusher, req PageRequest, p prompts, active BackendSettings, opts models.Options) (BackendSettings, error) {
+	if len(p.Meta.Sections) > 0 {
+		return s.generateSections(ctx, w, flusher, req, p, active, opts)
+	}
+	return s.generate(ctx, w, flusher, req, p, active, opts)
+}
+
+// sectionResult is the finished output of one concurrently generated section
+type sectionResult struct {
+	html []byte
+	err  error
+}
+
+// generateSections generates every section concurrently and writes them to w
+// in declared order. The first section streams as it is generated; the
+// others are written as soon as everything before them is done. A later
+// section that fails is left out rather than failing the whole page.
+func (s *Server) generateSections(ctx context.Context, w io.Writer, flusher http.Flusher, req PageRequest, p prompts, active BackendSettings, opts models.Options) (BackendSettings, error) {
+	sections := p.Meta.Sections
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]chan sectionResult, len(sections))
+	for i := 1; i < len(sections); i++ {
+		results[i] = make(chan sectionResult, 1)
+		part := p
+		part.User = sectionPrompt(p.User, sections, i)
+		go func(done chan<- sectionResult) {
+			var buf bytes.Buffer
+			_, err := s.generate(ctx, &buf, discardFlusher{}, req, part, active, opts)
+			done <- sectionResult{html: buf.Bytes(), err: err}
+		}(results[i])
+	}
+
+	first := p
+	first.User = sectionPrompt(p.User, sections, 0)
+	used, err := s.generate(ctx, w, flusher, req, first, active, opts)
+	if err != nil {
+		return used, err
+	}
+	if s.Debug {
+		log.Printf("🧩 Section %q of /%s done", sections[0].Name, req.Route)
+	}
+
+	for i := 1; i < len(sections); i++ {
+		res := <-results[i]
+		if res.err != nil {
+			if ctx.Err() != nil {
+				return used, ctx.Err()
+			}
+			log.Printf("⚠️  Leaving section %q out of /%s: %v", sections[i].Name, req.Route, res.err)
+			continue
+		}
+		if _, err := w.Write(append([]byte("\n"), trimFragment(res.html)...)); err != nil {
+			return used, err
+		}
+		flusher.Flush()
+		if s.Debug {
+			log.Printf("🧩 Section %q of /%s done", sections[i].Name, req.Route)
+		}
+	}
+	return used, nil
+}
+
+// trimFragment drops model chatter before the first tag and after the last one
+func trimFragment(html []byte) []byte {
+	start := bytes.IndexByte(html, '<')
+	end := bytes.LastIndexByte(html, '>')
+	if start == -1 || end < start {
+		return nil
+	}
+	return html[start : end+1]
+}
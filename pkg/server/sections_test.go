@@ -0,0 +1,175 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kekePower/museweb/pkg/models"
+)
+
+func TestValidateSections(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		sections []Section
+		isHTML   bool
+		want     string // Part of the error, or "" when valid
+	}{
+		{"none", nil, false, ""},
+		{"valid", []Section{{"hero", "A hero"}, {"feature_list-2", "Cards"}}, true, ""},
+		{"not HTML", []Section{{"hero", "A hero"}}, false, "only supported for HTML"},
+		{"name with a space", []Section{{"the hero", "A hero"}}, true, `invalid section name "the hero"`},
+		{"name starting with a digit", []Section{{"2col", "Columns"}}, true, "invalid section name"},
+		{"name that breaks out of the id", []Section{{`hero"><script>`, "A hero"}}, true, "invalid section name"},
+		{"empty name", []Section{{"", "A hero"}}, true, "invalid section name"},
+		{"duplicate", []Section{{"hero", "A hero"}, {"hero", "Another"}}, true, `section "hero" is declared twice`},
+		{"blank prompt", []Section{{"hero", " \n"}}, true, `section "hero" has no prompt`},
+	} {
+		err := validateSections(tc.sections, tc.isHTML)
+		if tc.want == "" && err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+		if tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+			t.Errorf("%s: err = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestSectionPrompt(t *testing.T) {
+	sections := []Section{{"hero", " A hero \n"}, {"features", "Cards"}, {"footer", "Links"}}
+	for _, tc := range []struct {
+		name     string
+		sections []Section
+		i        int
+		want     []string
+		unwanted []string
+	}{
+		{"only section", sections[:1], 0,
+			[]string{"written in 1 parts", `part 1, the "hero" section: A hero`, "complete HTML document"},
+			[]string{"<section id="}},
+		{"first", sections, 0,
+			[]string{"written in 3 parts", `part 1, the "hero" section: A hero` + "\n", "<!DOCTYPE html>", "opening <body>", `<section id="hero">`, "Do not close <body>"},
+			[]string{"</body></html>", "complete HTML document"}},
+		{"middle", sections, 1,
+			[]string{`part 2, the "features" section: Cards`, `<section id="features">`, "without <!DOCTYPE>, <html>, <head>, or <body> tags"},
+			[]string{"</body></html>", "Start the document"}},
+		{"last", sections, 2,
+			[]string{`part 3, the "footer" section: Links`, `<section id="footer">`, "close the page with </body></html>", "Do not repeat <!DOCTYPE>"},
+			[]string{"Start the document"}},
+	} {
+		got := sectionPrompt("PAGE", tc.sections, tc.i)
+		if !strings.HasPrefix(got, "PAGE\n\n") {
+			t.Errorf("%s: %q does not start with the page prompt", tc.name, got)
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: %q lacks %q", tc.name, got, want)
+			}
+		}
+		for _, unwanted := range tc.unwanted {
+			if strings.Contains(got, unwanted) {
+				t.Errorf("%s: %q has %q", tc.name, got, unwanted)
+			}
+		}
+	}
+}
+
+func TestTrimFragment(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{`<section id="a">A</section>`, `<section id="a">A</section>`},
+		{"Here is the section:\n<section>A</section>\nHope this helps!", "<section>A</section>"},
+		{"```html\n<p>A</p>\n```", "<p>A</p>"},
+		{"a > b, so c < d", ""},
+		{"no markup at all", ""},
+		{"", ""},
+	} {
+		if got := string(trimFragment([]byte(tc.in))); got != tc.want {
+			t.Errorf("trimFragment(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+// newSectionBackend serves chat completions that answer each section prompt
+// with replies[part], or fail for parts missing from replies
+func newSectionBackend(t *testing.T, replies map[int]string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		for part, reply := range replies {
+			if !bytes.Contains(body, []byte(fmt.Sprintf("Write ONLY part %d,", part))) {
+				continue
+			}
+			w.Header().Set("Content-Type", "text/event-stream")
+			event, _ := json.Marshal(map[string]interface{}{
+				"choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]string{"content": reply}}},
+			})
+			fmt.Fprintf(w, "data: %s\n\ndata: [DONE]\n\n", event)
+			return
+		}
+		http.Error(w, `{"error":{"message":"backend failed"}}`, http.StatusBadRequest)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGenerateSections(t *testing.T) {
+	sections := []Section{{"hero", "A hero"}, {"features", "Cards"}, {"quote", "A quote"}, {"footer", "Links"}}
+	for _, tc := range []struct {
+		name    string
+		replies map[int]string
+		want    string
+		fails   bool
+	}{
+		{
+			name: "joined in order",
+			replies: map[int]string{
+				1: `<!DOCTYPE html><html><body><section id="hero">H</section>`,
+				2: "Here you go:\n<section id=\"features\">F</section>\nEnjoy!",
+				3: `<section id="quote">Q</section>`,
+				4: "```html\n<section id=\"footer\">L</section></body></html>\n```",
+			},
+			want: "<!DOCTYPE html><html><body><section id=\"hero\">H</section>\n" +
+				"<section id=\"features\">F</section>\n<section id=\"quote\">Q</section>\n" +
+				"<section id=\"footer\">L</section></body></html>",
+		},
+		{
+			name: "a failed later section is left out",
+			replies: map[int]string{
+				1: `<body><section id="hero">H</section>`,
+				2: `<section id="features">F</section>`,
+				4: `<section id="footer">L</section></body>`,
+			},
+			want: "<body><section id=\"hero\">H</section>\n<section id=\"features\">F</section>\n<section id=\"footer\">L</section></body>",
+		},
+		{
+			name: "a failed first section fails the page",
+			replies: map[int]string{
+				2: `<section id="features">F</section>`,
+				3: `<section id="quote">Q</section>`,
+				4: `<section id="footer">L</section></body>`,
+			},
+			fails: true,
+		},
+	} {
+		backend := newSectionBackend(t, tc.replies)
+		s := New("openai", "test-model", t.TempDir(), "test-key", backend.URL, false)
+		s.ResponseAdapter = "openai"
+		p := prompts{System: "system", User: "PAGE", Meta: FrontMatter{Sections: sections}}
+
+		var out bytes.Buffer
+		_, err := s.generatePage(context.Background(), &out, discardFlusher{}, PageRequest{Route: "landing"}, p, s.Active(), models.Options{})
+		if (err != nil) != tc.fails {
+			t.Errorf("%s: err = %v", tc.name, err)
+		}
+		if got := out.String(); got != tc.want {
+			t.Errorf("%s: page %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
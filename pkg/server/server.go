@@ -1,15 +1,32 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/kekePower/museweb/pkg/auth"
+	"github.com/kekePower/museweb/pkg/budget"
 	"github.com/kekePower/museweb/pkg/models"
+	"github.com/kekePower/museweb/pkg/postprocess"
+	"github.com/kekePower/museweb/pkg/rag"
+	"github.com/kekePower/museweb/pkg/snapshot"
+	"github.com/kekePower/museweb/pkg/store"
+	"github.com/kekePower/museweb/pkg/tracing"
+	"github.com/kekePower/museweb/pkg/translate"
+	"github.com/kekePower/museweb/pkg/utils"
 )
 
 // DebugMessage represents a message in the debug output
@@ -52,168 +69,997 @@ func PrintRequestDebugInfo(backend, modelName, systemPrompt, userPrompt string,
 	log.Printf("🔍 User Prompt: %s\n", debugReq.Messages[0].Content)
 }
 
+// Server renders prompt files into pages. The same instance backs the main page
+// handler and auxiliary endpoints (such as /graphql) that render prompts
+// programmatically, so they all share one set of backend settings.
+//
+// Backend, ModelName, APIKey, and APIBase are the initial settings; once the
+// server is running, read them with Active and change them with SwapBackend.
+// Likewise PromptsDir is the initial prompt directory; read it with
+// ActivePromptsDir and change it with SetPromptsDir.
+type Server struct {
+	Backend    string
+	ModelName  string
+	PromptsDir string
+	APIKey     string
+	APIBase    string
+	Debug      bool
+
+	// Credentials per backend, used when SwapBackend switches backends
+	Credentials map[string]Credentials
+
+	// Reasoning sets the default reasoning effort and thinking budget; prompts
+	// can override it in their front matter
+	Reasoning models.ReasoningOptions
+
+	// RenderMode is RenderStream (default) or RenderMorph
+	RenderMode string
+
+	// Metadata attaches the request ID, model, duration, tokens, and cache
+	// status to each page: MetadataComment, MetadataTrailers, MetadataBoth, or
+	// "" for none
+	Metadata string
+
+	// ResponseAdapter names the stream parser for OpenAI-compatible backends ("" detects it)
+	ResponseAdapter string
+
+	// OpenRouter holds provider routing fields for OpenRouter API bases
+	OpenRouter *models.OpenRouterOptions
+
+	// Sampling sets temperature, top_p, max_tokens, ... for the OpenAI and
+	// Azure OpenAI backends
+	Sampling models.SamplingOptions
+
+	// AzureAPIVersion is the api-version sent to Azure OpenAI (a default when empty)
+	AzureAPIVersion string
+
+	// BedrockRegion and AWSCredentials sign Bedrock requests when the
+	// backend has no Bedrock API key
+	BedrockRegion  string
+	AWSCredentials models.AWSCredentials
+
+	// Translator, when set, translates pages requested with ?lang= instead of
+	// the model; TranslationCache keeps its results per route and language
+	Translator       translate.Translator
+	TranslationCache *translate.Cache
+
+	// BaseURL is the public site URL (e.g. "https://example.com"), given to
+	// the model for absolute URLs
+	BaseURL string
+
+	// Navigation adds the site navigation built from prompt front matter to
+	// every HTML page prompt
+	Navigation bool
+
+	// LlamaCpp holds the llama.cpp sampling, caching, and template settings
+	LlamaCpp models.LlamaCppOptions
+
+	// TGI holds the text-generation-inference token limit and template
+	TGI models.TGIOptions
+
+	// Mock is where the mock backend replays recorded responses from, and
+	// whether live responses are recorded there
+	Mock models.MockOptions
+
+	// OllamaNodes, when set, balances Ollama generations over several servers
+	OllamaNodes *models.Balancer
+
+	// OllamaOptions are the default Ollama generation options; prompts can
+	// override them in their front matter
+	OllamaOptions models.OllamaOptions
+
+	// OllamaGenerate lists the Ollama models that use /api/generate instead of chat
+	OllamaGenerate models.GenerateModes
+
+	// ClientBuffer is how many bytes of output the model may run ahead of a
+	// slow client (0 writes to the client directly); StallTimeout drops
+	// clients that accept nothing for that long
+	ClientBuffer int
+	StallTimeout time.Duration
+
+	// Retry retries a backend that fails with a rate limit or server error
+	// before streaming starts, before any failover backend is tried
+	Retry RetryPolicy
+
+	// LiveReload, when set, injects a reload script into generated pages (dev mode)
+	LiveReload *LiveReload
+
+	// PostProcessors run over each complete page before it is written; when any
+	// are configured the page is buffered instead of streamed
+	PostProcessors postprocess.Pipeline
+
+	// Sanitizer, when set, strips scripts, event handlers, and unsafe URLs
+	// from each page after every other pass (translation included); pages
+	// are buffered
+	Sanitizer *postprocess.Sanitizer
+
+	// Snapshots, when set, records each generation and serves pinned versions
+	Snapshots *snapshot.Store
+
+	// AdminToken protects the /admin endpoints; they are not registered when empty
+	AdminToken string
+
+	// Reload, when set, re-reads the configuration and applies what can change
+	// at runtime (POST /admin/reload)
+	Reload func() error
+
+	// Build and ConfigSummary (with secrets redacted) are reported by /admin/info
+	Build         BuildInfo
+	ConfigSummary map[string]interface{}
+
+	// Audit, when set, records administrative actions in the SQLite store
+	Audit *store.AuditLog
+
+	// Budget, when set, caps token spending; over budget, pages are served
+	// from snapshots or replaced by a notice instead of calling the backend
+	Budget *budget.Tracker
+	// BudgetFallback, when set, is the HTML page served over budget in place
+	// of the built-in notice
+	BudgetFallback []byte
+
+	// Usage, when set, accounts the tokens and cost of every generation per
+	// day, route, and model (see /admin/api/usage); with UsageHeader, each
+	// page's usage is also sent in the X-MuseWeb-Usage trailer
+	Usage       *budget.Ledger
+	UsageHeader bool
+
+	// Cache, when set, keeps generated pages per prompt, model, language,
+	// and user input: in memory (ResponseCache) or shared between instances
+	Cache PageCache
+
+	// Prewarm lists routes generated into the Cache at startup (see
+	// RunPrewarm), and again every PrewarmEvery when that is set
+	Prewarm      []string
+	PrewarmEvery time.Duration
+
+	// Limit, when set, caps the generations running at once and queues the rest
+	Limit *GenerationLimit
+
+	// Metrics, when set, records request, generation, and cache metrics for
+	// Prometheus (see NewMetrics)
+	Metrics *Metrics
+
+	// Warm, when set, keeps the most requested pages generated in the
+	// background (see RunWarmer) and answers requests for them from memory
+	Warm *Warmer
+
+	// Timeouts bound connecting to backends and each whole generation; the
+	// time to first token is set with SetFailover
+	Timeouts models.Timeouts
+
+	// KV, when set, fills in {{kv "key"}} and {{incr "key"}} in page prompts;
+	// HandleKV lets forms increment the keys matching KVWritable
+	KV         *store.KV
+	KVWritable []string
+
+	// MaxImageBytes caps images uploaded with multipart forms
+	// (DefaultMaxImageBytes when 0)
+	MaxImageBytes int64
+
+	// RAG, when set, adds the RAGTopK chunks of site content most relevant
+	// to each page to its prompt (DefaultRAGTopK when 0)
+	RAG     *rag.Index
+	RAGTopK int
+
+	// Tools configure the built-in tools prompts can declare in their front
+	// matter (see RegisterTool for others)
+	Tools ToolOptions
+
+	// Scrubber, when set, masks email addresses, phone numbers, and
+	// configured terms in generated pages as they stream out
+	Scrubber *utils.Scrubber
+
+	// InputFilter, when set, limits and cleans what visitors POST before it
+	// reaches a prompt, and may delimit it as data
+	InputFilter *utils.InputFilter
+
+	// Templates runs every page prompt as a text/template with the request's
+	// query parameters, path, headers, the time, and TemplateVars (see
+	// TemplateData); prompts can opt in or out with "template" front matter
+	Templates    bool
+	TemplateVars map[string]string
+
+	// Users, when set, tells who is signed in: prompts can greet them with
+	// {{user "name"}}, and pages with "login: true" require signing in
+	Users Users
+
+	mu               sync.RWMutex               // Guards the backend settings and PromptsDir
+	backends         map[string]BackendSettings // Named backends prompts can select
+	failover         []string                   // Named backends tried when a generation fails
+	redirects        map[string]Redirect        // Configured redirects by old route
+	overrides        map[string]bool            // Models requests may pick with ?model=
+	shadow           *shadowState               // Mirroring to a shadow backend, if configured
+	routing          *routingState              // Routing rules by page size and complexity, if configured
+	flights          flights                    // Generations in progress, shared by identical requests
+	promptComposer   PromptComposer             // Assembles the prompts; nil uses the default
+	firstByteTimeout time.Duration              // Default time to first byte per attempt
+	started          time.Time
+	requests         atomic.Int64
+	generations      atomic.Int64
+	failures         atomic.Int64
+	streams          atomic.Int64 // Backend calls in progress
+	// guardrailViolations counts generations that broke their page's guardrails
+	guardrailViolations atomic.Int64
+}
+
+// PageRequest describes a single page generation
+type PageRequest struct {
+	Route string // Prompt name without the .txt extension, e.g. "about"
+	Lang  string // Optional target language for translation
+	Input string // Optional user input, e.g. a POST body
+	Site  string // Request host, for per-site budgets
+	Model string // Allowed model override for this request only (see SetModelOverrides)
+
+	// Images are pictures uploaded with the request for multimodal models
+	Images []models.Image
+
+	// User is the signed-in visitor, if any (see Server.Users)
+	User *auth.User
+
+	// ClientIP is the visitor's address, for prompt composers; behind
+	// trusted proxies it is the address they forwarded
+	ClientIP string
+
+	// Query and Header are the request's query parameters (cleaned like
+	// Input) and headers, for templated prompts
+	Query  url.Values
+	Header http.Header
+
+	// info collects the generation metadata, when it is attached to pages
+	info *genInfo
+
+	// Preview allows draft prompts to be rendered (admin or preview session)
+	Preview bool
+
+	// NoCache skips the response cache lookup (?nocache=1); the page is
+	// regenerated and cached again
+	NoCache bool
+}
+
+// prompts is a fully assembled generation request for one route
+type prompts struct {
+	System      string
+	User        string
+	Meta        FrontMatter
+	ContentType string         // Response Content-Type
+	HTML        bool           // Whether the output is an HTML page
+	Dynamic     bool           // Whether the page reads the key-value store (see expandKV)
+	Personal    bool           // Whether the page shows the signed-in user (see expandUser)
+	Templated   bool           // Whether the prompt was rendered with request data (see renderTemplate)
+	Images      []models.Image // Pictures referenced in the front matter
+
+	composed PromptInput // What System and User were composed from
+}
+
+// errPromptNotFound is returned when a route has no matching prompt file
+var errPromptNotFound = errors.New("prompt file not found")
+
+// errLoginRequired is returned when a members-only page is generated for
+// nobody in particular
+var errLoginRequired = errors.New("the page is for signed-in visitors only")
+
+// New creates a Server for the given backend settings
+func New(backend, modelName, promptsDir, apiKey, apiBase string, debug bool) *Server {
+	return &Server{
+		Backend:    backend,
+		ModelName:  modelName,
+		PromptsDir: promptsDir,
+		APIKey:     apiKey,
+		APIBase:    apiBase,
+		Debug:      debug,
+		started:    time.Now(),
+
+		ClientBuffer: DefaultClientBuffer,
+		StallTimeout: DefaultStallTimeout,
+	}
+}
+
 // HandleRequest returns a handler function that processes incoming requests
 func HandleRequest(backend, modelName, promptsDir, apiKey, apiBase string, debug bool) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Set CORS headers for all responses
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-		// Handle preflight OPTIONS request
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
+	return New(backend, modelName, promptsDir, apiKey, apiBase, debug).ServeHTTP
+}
+
+// ServeHTTP streams the page generated from the prompt matching the request path
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requests.Add(1)
+
+	// Set CORS headers for all responses
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	// Handle preflight OPTIONS request
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Only accept GET and POST requests
+	if r.Method != "GET" && r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse the URL path to get the prompt file name
+	originalPath := r.URL.Path
+	route := strings.TrimPrefix(originalPath, "/")
+	// Remove trailing slash if present (AI sometimes generates URLs like /path/?lang=xx)
+	route = strings.TrimSuffix(route, "/")
+	if route == "" {
+		route = "home"
+	}
+
+	// Debug logging for URL path cleaning
+	if s.Debug && strings.HasSuffix(originalPath, "/") && originalPath != "/" {
+		log.Printf("🔧 Cleaned URL path: '%s' -> '%s'", originalPath, route)
+	}
+
+	// Old URLs move to their new routes instead of generating duplicate pages
+	if rd, ok := s.redirectFor(route); ok {
+		s.serveRedirect(w, r, rd)
+		return
+	}
+
+	// Extract language parameter from URL query string
+	langParam := r.URL.Query().Get("lang")
+	if s.Debug && langParam != "" {
+		log.Printf("🌐 Language parameter detected: %s", langParam)
+	}
+
+	req := PageRequest{Route: route, Lang: langParam, Site: r.Host, Preview: s.isPreview(r), NoCache: r.URL.Query().Get("nocache") == "1"}
+	if s.Users != nil {
+		req.User = s.Users.User(r)
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		req.ClientIP = host
+	}
+	if s.Metadata != "" {
+		req.info = newGenInfo(r)
+		w.Header().Set(RequestIDHeader, req.info.requestID)
+	}
+	// The access log reports the model and cache status of pages
+	entry := accessEntryFrom(r.Context())
+	if entry != nil && req.info == nil {
+		req.info = newGenInfo(r)
+	}
+
+	// A model picked for comparison applies to this request only and is never cached
+	if name := requestedModel(r); name != "" {
+		if err := s.allowedOverride(name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		req.Model = name
+		w.Header().Set(ModelHeader, name)
+		w.Header().Set("Cache-Control", "private, no-store")
+	}
 
-		// Only accept GET and POST requests
-		if r.Method != "GET" && r.Method != "POST" {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	// Get user input from POST data if available; multipart forms may also
+	// upload images for the model to look at
+	if r.Method == "POST" && isMultipart(r) {
+		input, images, err := s.readMultipart(r)
+		switch {
+		case errors.Is(err, errImageTooLarge):
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		case errors.Is(err, errNotImage):
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		case err != nil:
+			http.Error(w, "Error reading request body", http.StatusBadRequest)
 			return
 		}
-
-		// Parse the URL path to get the prompt file name
-		originalPath := r.URL.Path
-		promptFile := strings.TrimPrefix(originalPath, "/")
-		// Remove trailing slash if present (AI sometimes generates URLs like /path/?lang=xx)
-		promptFile = strings.TrimSuffix(promptFile, "/")
-		if promptFile == "" {
-			promptFile = "home"
+		req.Input, req.Images = input, images
+	} else if r.Method == "POST" {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Error reading request body", http.StatusBadRequest)
+			return
 		}
-		
-		// Debug logging for URL path cleaning
-		if debug && strings.HasSuffix(originalPath, "/") && originalPath != "/" {
-			log.Printf("🔧 Cleaned URL path: '%s' -> '%s'", originalPath, promptFile)
+		defer r.Body.Close()
+		req.Input = string(body)
+	}
+	// Input is checked and cleaned before any prompt sees it, and so are the
+	// query parameters templated prompts can read
+	input, err := s.InputFilter.Clean(req.Input)
+	req.Query, req.Header = r.URL.Query(), r.Header
+	for _, values := range req.Query {
+		for i := 0; i < len(values) && err == nil; i++ {
+			values[i], err = s.InputFilter.Clean(values[i])
 		}
-
-		// Extract language parameter from URL query string
-		langParam := r.URL.Query().Get("lang")
-		if debug && langParam != "" {
-			log.Printf("🌐 Language parameter detected: %s", langParam)
+	}
+	if err != nil {
+		log.Printf("🛡️  Refused input for /%s from %s: %v", route, req.ClientIP, err)
+		status := http.StatusBadRequest
+		if errors.Is(err, utils.ErrInputTooLong) {
+			status = http.StatusRequestEntityTooLarge
 		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	req.Input = input
 
-		// Add .txt extension if not present
-		if !strings.HasSuffix(promptFile, ".txt") {
-			promptFile += ".txt"
-		}
+	tracing.FromContext(r.Context()).SetAttributes(tracing.String("museweb.route", route))
+	_, span := tracing.Start(r.Context(), "prompt.load", tracing.String("museweb.route", route))
+	p, err := s.buildPrompts(req)
+	span.RecordError(err)
+	span.End()
+	if errors.Is(err, errPromptNotFound) {
+		http.Error(w, fmt.Sprintf("Prompt file not found: %s", promptFileName(route)), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading prompt file: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer s.Metrics.observePage(r.Context(), route, time.Now())
+	if entry != nil {
+		entry.info = req.info
+	}
 
-		// Construct the full path to the prompt file
-		promptPath := filepath.Join(promptsDir, promptFile)
+	// Drafts and noindex routes must never end up in search results or shared caches
+	if p.Meta.NoIndex || p.Meta.Draft {
+		w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+	}
+	if p.Meta.Draft {
+		w.Header().Set("Cache-Control", "private, no-store")
+	}
 
-		// Check if the file exists
-		if _, err := os.Stat(promptPath); os.IsNotExist(err) {
-			http.Error(w, fmt.Sprintf("Prompt file not found: %s", promptFile), http.StatusNotFound)
+	// Members-only pages send visitors to sign in first, and are never kept
+	// by shared caches; pages greeting the user are not kept at all
+	if p.Meta.Login && req.User == nil {
+		if s.Users == nil {
+			log.Printf("⚠️  /%s requires signing in, but no sign-in is configured", route)
+			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, s.Users.LoginURL(r.URL.RequestURI()), http.StatusFound)
+		return
+	}
+	if p.Personal {
+		w.Header().Set("Cache-Control", "private, no-store")
+	} else if p.Meta.Login && w.Header().Get("Cache-Control") == "" {
+		w.Header().Set("Cache-Control", "private")
+	}
 
-		// Read the prompt file
-		promptData, err := os.ReadFile(promptPath)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Error reading prompt file: %v", err), http.StatusInternalServerError)
+	// In morph mode, browsers first get a shell that streams and morphs the page
+	if p.HTML && s.wantsMorphShell(r) {
+		s.serveMorphShell(w, r)
+		return
+	}
+
+	// Set content type for streaming response
+	w.Header().Set("Content-Type", p.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	s.announceTrailers(w)
+
+	// Pages showing stored values are always fresh
+	if p.Dynamic && w.Header().Get("Cache-Control") == "" {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+
+	// Popular pages are kept warm; personal, members-only, draft, stateful,
+	// templated, and dev-mode pages never are
+	if s.Warm != nil && r.Method == http.MethodGet && !req.Preview && req.Model == "" && !p.Meta.Draft && !p.Meta.Login && !p.Dynamic && !p.Personal && !p.Templated && s.LiveReload == nil {
+		s.Warm.record(req)
+		if html, ok := s.Warm.page(req); ok {
+			w.Header().Set("X-MuseWeb-Cache", "warm")
+			w.Write(html)
+			req.info.served("warm")
+			s.writeMetadata(w, req.info, p.HTML)
 			return
 		}
+	}
 
-		// Load the system prompt from system_prompt.txt
-		systemPromptPath := filepath.Join(promptsDir, "system_prompt.txt")
-		var systemPrompt string
+	// Get flusher for streaming
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
 
-		// Check if system_prompt.txt exists
-		if _, err := os.Stat(systemPromptPath); !os.IsNotExist(err) {
-			// Read the system prompt file
-			systemPromptData, err := os.ReadFile(systemPromptPath)
-			if err != nil {
-				log.Printf("Warning: Error reading system_prompt.txt: %v", err)
-			} else {
-				systemPrompt = string(systemPromptData)
-			}
-		} else {
-			log.Printf("Warning: system_prompt.txt not found in %s", promptsDir)
-		}
+	// Stream the response; the generation stops when the client goes away
+	err = s.stream(r.Context(), w, flusher, req, p)
+	if r.Context().Err() != nil {
+		log.Printf("🔌 Client disconnected, cancelled generation for /%s", route)
+	} else if err != nil {
+		log.Printf("Error streaming response: %v", err)
+		// Don't send an error response here as we may have already started streaming
+	} else {
+		s.writeMetadata(w, req.info, p.HTML)
+	}
+
+	// In dev mode, let the browser reload itself when a prompt changes (the
+	// morph shell already carries the script, so fragments don't need it)
+	if s.LiveReload != nil && p.HTML && r.Header.Get(FragmentHeader) == "" {
+		io.WriteString(w, liveReloadScript)
+		flusher.Flush()
+	}
+}
+
+// Generate renders the page for req and writes it to w, stopping when ctx is cancelled
+func (s *Server) Generate(ctx context.Context, w io.Writer, flusher http.Flusher, req PageRequest) error {
+	p, err := s.buildPrompts(req)
+	if err != nil {
+		return err
+	}
+	if p.Meta.Login && req.User == nil {
+		return errLoginRequired
+	}
+	return s.stream(ctx, w, flusher, req, p)
+}
 
-		// Check for layout files
-		layoutMinPath := filepath.Join(promptsDir, "layout.min.txt")
-		layoutPath := filepath.Join(promptsDir, "layout.txt")
-		var layoutContent string
+// stream sends the composed prompts to the configured backend and streams the result to w
+func (s *Server) stream(ctx context.Context, w io.Writer, flusher http.Flusher, req PageRequest, p prompts) (err error) {
+	// A pinned snapshot replaces live generation until it is unpinned
+	if s.servePinned(w, flusher, req, p) {
+		req.info.served("pinned")
+		return nil
+	}
 
-		// First try layout.min.txt, then fall back to layout.txt
-		if _, err := os.Stat(layoutMinPath); !os.IsNotExist(err) {
-			layoutData, err := os.ReadFile(layoutMinPath)
-			if err == nil {
-				layoutContent = string(layoutData)
+	// Pages generated before are served from memory; ?nocache=1 regenerates them
+	key, shared := s.pageKeyFor(req, p)
+	cacheable := shared && s.Cache != nil
+	if cacheable && !req.NoCache && s.serveCached(ctx, w, flusher, req, key) {
+		req.info.served("cache")
+		return nil
+	}
+
+	// Pages already translated by the external translator need no generation
+	translating := s.translates(req, p)
+	if translating && s.serveCachedTranslation(w, flusher, req, p) {
+		req.info.served("translation")
+		return nil
+	}
+
+	// Over budget, the backend is not called at all
+	if served, err := s.overBudget(w, flusher, req, p); served {
+		req.info.served("budget")
+		return err
+	}
+
+	// Identical requests arriving while the page is generated share one
+	// generation, which goes on while any of them still wants the page
+	var leading *flight
+	clientCtx := ctx
+	if shared {
+		f, leader := s.flights.join(key)
+		if !leader {
+			return s.followFlight(ctx, w, flusher, req, p, f)
+		}
+		leading = f
+		defer func() { s.flights.land(key, f, err) }()
+		var stop func()
+		ctx, stop = f.detach(ctx, s.flightTimeout())
+		defer stop()
+	}
+
+	// With a shared cache, one instance generates the page while the others wait for it
+	if leading != nil && cacheable && !req.NoCache {
+		html, stale, claimed, err := s.awaitShared(ctx, req, key.String())
+		if err != nil {
+			return err
+		}
+		if claimed {
+			defer s.releaseShared(ctx, req, key.String())
+		}
+		if html != nil {
+			source := "shared"
+			if stale {
+				source = "stale"
 			}
-		} else if _, err := os.Stat(layoutPath); !os.IsNotExist(err) {
-			layoutData, err := os.ReadFile(layoutPath)
-			if err == nil {
-				layoutContent = string(layoutData)
+			if rw, ok := w.(http.ResponseWriter); ok {
+				rw.Header().Set("X-MuseWeb-Cache", source)
 			}
+			io.MultiWriter(w, leading).Write(html)
+			flusher.Flush()
+			req.info.served(source)
+			return nil
+		}
+	}
+
+	// Stored values are read, and counters counted, only for pages actually
+	// generated. Only the page prompt is expanded, never the visitor's input.
+	if p.Dynamic || p.Personal {
+		in := p.composed
+		if p.Dynamic {
+			in.Page = s.expandKV(in.Page)
 		}
+		if p.Personal {
+			in.Page = expandUser(in.Page, req.User)
+		}
+		if p.System, p.User, err = s.composer().Compose(in); err != nil {
+			return err
+		}
+	}
+	p.User = s.withSiteContext(ctx, req.Route, p)
 
-		// If we have a layout, append it to the system prompt
-		if layoutContent != "" {
-			if systemPrompt != "" {
-				systemPrompt += "\n\n" + layoutContent
-			} else {
-				systemPrompt = layoutContent
+	// Pages that pick no backend may be routed by their size and complexity;
+	// how routed pages turn out decides whether the route stays there
+	var flawed bool
+	if routed := s.routeFor(req, p); routed != "" {
+		p.Meta.Backend = routed
+		defer func() {
+			if ctx.Err() == nil {
+				s.observeRouted(req.Route, routed, err == nil && !flawed)
 			}
+		}()
+	}
+
+	// Beyond the generation limit, requests wait for a slot or are turned away
+	if s.Limit != nil {
+		_, span := tracing.Start(ctx, "generation.queue")
+		err := s.Limit.acquire(ctx)
+		span.RecordError(err)
+		span.End()
+		if errors.Is(err, errBusy) {
+			req.info.served("busy")
+			return s.serveBusy(w, w, flusher, req, p)
+		} else if err != nil {
+			return err
 		}
+		defer s.Limit.release()
+	}
 
-		// The prompt file content becomes the user prompt
-		userPrompt := string(promptData)
+	s.generations.Add(1)
+	client := w
 
-		// Get user input from POST data if available
-		if r.Method == "POST" {
-			body, err := io.ReadAll(r.Body)
-			if err != nil {
-				http.Error(w, "Error reading request body", http.StatusBadRequest)
-				return
+	// Let the model run ahead of slow clients instead of waiting on every write
+	if rw, ok := w.(http.ResponseWriter); ok && s.ClientBuffer > 0 {
+		cw := newClientWriter(rw, s.ClientBuffer, s.StallTimeout)
+		defer func() {
+			if cerr := cw.Close(req.Route, s.Debug); err == nil {
+				err = cerr
 			}
-			defer r.Body.Close()
+		}()
+		w, flusher = cw, cw
+	}
 
-			userInput := string(body)
-			if userInput != "" {
-				userPrompt += "\n\nUser Input: " + userInput
+	// Resolve the prompt's backend now; a swap mid-generation does not affect it
+	active, err := s.pageBackend(p.Meta)
+	if err != nil {
+		return err
+	}
+	if req.Model != "" {
+		active = s.overrideFor(req.Model)
+	}
+	var spent models.Usage // This generation's tokens, for the usage ledger
+	opts := models.Options{
+		Reasoning: s.Reasoning.Merge(p.Meta.reasoning()),
+		RawOutput: !p.HTML,
+		OnUsage:   req.info.countUsage(s.sumUsage(&spent, s.recordUsage(req.Site, active.Model))),
+		Ollama:    s.OllamaOptions.Merge(p.Meta.ollamaOptions()),
+		Sampling:  p.Meta.sampling(),
+		Images:    append(p.Images, req.Images...),
+	}
+	if len(opts.Images) > 0 {
+		s.warnVision(req.Route, active)
+	}
+	if opts.Tools, err = s.toolsFor(p.Meta.Tools); err != nil {
+		return err
+	}
+
+	// Keep a copy of what the client receives for the snapshot history and cache
+	var capture bytes.Buffer
+	out := w
+	if leading != nil {
+		out = &leaderClient{w: w, ctx: clientCtx}
+	}
+	if s.recordsSnapshot(req, p) || cacheable {
+		out = io.MultiWriter(w, &capture)
+	}
+	if leading != nil {
+		out = io.MultiWriter(out, leading)
+	}
+
+	// A sample of generations is mirrored to the shadow backend for comparison
+	if run := s.startShadow(req, p, opts); run != nil {
+		out = io.MultiWriter(out, &run.primary)
+		opts.OnUsage = run.countUsage(opts.OnUsage)
+		defer func() { s.finishShadow(run, active.Backend+"/"+active.Model, err) }()
+	}
+
+	// Personal data the model echoes back is masked before anything else sees it
+	var scrub *utils.ScrubWriter
+	if s.Scrubber != nil {
+		scrub = s.Scrubber.Writer(out)
+		out = scrub
+		defer func() {
+			scrub.Close()
+			if scrub.Masked > 0 {
+				log.Printf("🧽 Masked %d matches in /%s", scrub.Masked, req.Route)
 			}
+		}()
+	}
+
+	// Without post-processors (which only understand HTML), sanitizing,
+	// translation, or guardrails, stream straight through to the client.
+	// Other markup a browser renders (SVG, XHTML, XML) is sanitized too.
+	sanitizeXML := s.Sanitizer != nil && !p.HTML && postprocess.IsXML(p.ContentType)
+	if (len(s.PostProcessors) == 0 && s.Sanitizer == nil && !translating && !p.Meta.Guardrails.active()) || !p.HTML && !sanitizeXML {
+		used, err := s.generatePage(ctx, out, flusher, req, p, active, opts)
+		s.accountUsage(client, req, used, spent)
+		if errors.Is(err, errEmptyGeneration) {
+			s.countFailure(ctx)
+			req.info.served("fallback")
+			flawed = true
+			return s.serveEmpty(client, w, flusher, req, p)
+		} else if errors.Is(err, errFirstByteTimeout) {
+			s.countFailure(ctx)
+			req.info.served("fallback")
+			flawed = true
+			return s.serveTimeout(client, w, flusher, req, p, err)
+		} else if err != nil {
+			s.countFailure(ctx)
+			return err
+		}
+		if err := scrub.Close(); err != nil {
+			return err
 		}
+		flusher.Flush()
+		req.info.generatedBy(used)
+		s.saveSnapshot(req, p, used.Model, capture.Bytes())
+		if cacheable {
+			s.storeCached(ctx, req, key, capture.Bytes(), time.Duration(p.Meta.CacheTTL)*time.Second)
+		}
+		return nil
+	}
 
-		// Add translation instruction if language parameter is provided
-		if langParam != "" {
-			// Validate and clean the language parameter (basic sanitization)
-			langParam = strings.TrimSpace(langParam)
-			if len(langParam) > 0 && len(langParam) <= 10 { // Reasonable length limit
-				translationInstruction := fmt.Sprintf("\n\nTranslate all the content to %s.\n**VERY IMPORTANT:** DO NOT TRANSLATE ANY OF THE URLS IN THE NAVBAR. Keep the links as they are.\n**VERY IMPORTANT:** Add ?lang=%s to all generated URLs to preserve the language context.", langParam, langParam)
-				userPrompt += translationInstruction
-				if debug {
-					log.Printf("🌐 Added translation instruction: %s", translationInstruction)
-				}
-			} else if debug {
-				log.Printf("⚠️  Invalid language parameter ignored: %s", langParam)
-			}
+	// Post-processors, translators, and guardrails need the complete document, so buffer the generation first
+	var buf bytes.Buffer
+	used, violations, err := s.generateGuarded(ctx, &buf, req, p, active, opts)
+	s.accountUsage(client, req, used, spent)
+	if errors.Is(err, errEmptyGeneration) {
+		s.countFailure(ctx)
+		req.info.served("fallback")
+		flawed = true
+		return s.serveEmpty(client, w, flusher, req, p)
+	} else if errors.Is(err, errFirstByteTimeout) {
+		s.countFailure(ctx)
+		req.info.served("fallback")
+		flawed = true
+		return s.serveTimeout(client, w, flusher, req, p, err)
+	} else if err != nil {
+		s.countFailure(ctx)
+		return err
+	}
+	if len(violations) > 0 {
+		flawed = true
+		rw, _ := client.(http.ResponseWriter)
+		if s.serveLatestSnapshot(rw, w, flusher, req, p) {
+			log.Printf("🚧 Serving the latest snapshot of /%s instead", req.Route)
+			req.info.served("snapshot")
+			return nil
 		}
+		// Nothing to fall back to; a flawed page beats none, but it is not kept
+		log.Printf("🚧 No snapshot of /%s to fall back to, serving it despite its guardrails", req.Route)
+	}
+
+	page := &postprocess.Page{Route: req.Route, Lang: req.Lang, HTML: buf.String(), NoIndex: p.Meta.NoIndex || p.Meta.Draft, Context: ctx}
+	if p.HTML {
+		_, span := tracing.Start(ctx, "postprocess")
+		s.PostProcessors.Run(page, s.Debug)
+		span.End()
+	} else {
+		page.ContentType = p.ContentType
+	}
+	if translating {
+		_, span := tracing.Start(ctx, "translate", tracing.String("museweb.lang", req.Lang))
+		page.HTML = s.translatePage(ctx, req, p, page.HTML)
+		span.End()
+	}
+	// Model output is untrusted; nothing may run in the visitor's browser
+	if s.Sanitizer != nil {
+		postprocess.Pipeline{s.Sanitizer}.Run(page, s.Debug)
+	}
 
-		// Print debug information if enabled
-		if debug {
-			PrintRequestDebugInfo(backend, modelName, systemPrompt, userPrompt, false)
+	if _, err := io.WriteString(out, page.HTML); err != nil {
+		return err
+	}
+	if err := scrub.Close(); err != nil {
+		return err
+	}
+	flusher.Flush()
+	req.info.generatedBy(used)
+	if len(violations) == 0 {
+		s.saveSnapshot(req, p, used.Model, capture.Bytes())
+		if cacheable {
+			s.storeCached(ctx, req, key, capture.Bytes(), time.Duration(p.Meta.CacheTTL)*time.Second)
 		}
+	}
+	return nil
+}
 
-		// Set content type for streaming response
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		w.Header().Set("X-Content-Type-Options", "nosniff")
+// countFailure counts a failed generation, unless the client cancelled it
+func (s *Server) countFailure(ctx context.Context) {
+	if ctx.Err() == nil {
+		s.failures.Add(1)
+	}
+}
 
-		// Get flusher for streaming
-		flusher, ok := w.(http.Flusher)
-		if !ok {
-			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
-			return
+// recordsSnapshot reports whether generations for req are kept in the snapshot
+// history. Pages rendered from user input or for a signed-in user are
+// personal and never stored, pages from a model override are one-off
+// comparisons, and templated pages differ from one query to the next.
+func (s *Server) recordsSnapshot(req PageRequest, p prompts) bool {
+	return s.Snapshots != nil && req.Input == "" && req.Model == "" && len(req.Images) == 0 && req.User == nil && !p.Templated
+}
+
+// servePinned writes the pinned snapshot for req, if there is one
+func (s *Server) servePinned(w io.Writer, flusher http.Flusher, req PageRequest, p prompts) bool {
+	if !s.recordsSnapshot(req, p) {
+		return false
+	}
+	html, version, ok := s.Snapshots.Pinned(snapshot.Key(req.Route, req.Lang))
+	if !ok {
+		return false
+	}
+	if rw, ok := w.(http.ResponseWriter); ok {
+		rw.Header().Set("X-MuseWeb-Snapshot", version.ID)
+	}
+	if s.Debug {
+		log.Printf("📌 Serving pinned snapshot %s for /%s", version.ID, req.Route)
+	}
+	w.Write(html)
+	flusher.Flush()
+	return true
+}
+
+// saveSnapshot records a completed generation in the snapshot history
+func (s *Server) saveSnapshot(req PageRequest, p prompts, model string, html []byte) {
+	if !s.recordsSnapshot(req, p) || len(bytes.TrimSpace(html)) == 0 {
+		return
+	}
+	version, err := s.Snapshots.Save(snapshot.Key(req.Route, req.Lang), html, model)
+	if err != nil {
+		log.Printf("⚠️  Could not save snapshot for /%s: %v", req.Route, err)
+		return
+	}
+	if s.Debug {
+		log.Printf("📸 Saved snapshot %s for /%s (%d bytes)", version.ID, req.Route, version.Size)
+	}
+}
+
+// Completer returns a function that runs a single non-streaming completion with
+// modelName on the active backend. Post-processing passes use it for cheap
+// secondary model calls; an empty modelName uses the active model.
+func (s *Server) Completer(modelName string) postprocess.CompleteFunc {
+	return func(ctx context.Context, systemPrompt, userPrompt string) (string, error) {
+		active := s.Active()
+		if modelName != "" {
+			active.Model = modelName
 		}
+		if s.Budget != nil {
+			if err := s.Budget.Check(""); err != nil {
+				return "", err
+			}
+		}
+		var buf bytes.Buffer
+		handler := s.newHandler(active, models.Options{Reasoning: s.Reasoning, OnUsage: s.recordUsage("", active.Model), Ollama: s.OllamaOptions})
+		err := handler.StreamResponse(ctx, &buf, discardFlusher{}, systemPrompt, userPrompt)
+		return strings.TrimSpace(buf.String()), err
+	}
+}
 
-		// Create model handler based on backend
-		handler := models.NewModelHandler(backend, modelName, apiKey, apiBase, debug)
+// discardFlusher satisfies http.Flusher for generations that are buffered in memory
+type discardFlusher struct{}
 
-		// Stream the response
-		err = handler.StreamResponse(w, flusher, systemPrompt, userPrompt)
-		if err != nil {
-			log.Printf("Error streaming response: %v", err)
-			// Don't send an error response here as we may have already started streaming
+// Flush implements http.Flusher
+func (discardFlusher) Flush() {}
+
+// promptFileName maps a route to its prompt file name
+func promptFileName(route string) string {
+	// Add .txt extension if not present
+	if !strings.HasSuffix(route, ".txt") {
+		return route + ".txt"
+	}
+	return route
+}
+
+// buildPrompts loads the prompt files for req and assembles the system and user prompts
+func (s *Server) buildPrompts(req PageRequest) (prompts, error) {
+	// Routes also arrive from GraphQL, without the cleaning ServeHTTP gets
+	if !validRoute(req.Route) {
+		return prompts{}, errPromptNotFound
+	}
+	promptFile := promptFileName(req.Route)
+
+	// Construct the full path to the prompt file
+	promptPath := filepath.Join(s.ActivePromptsDir(), promptFile)
+
+	// Check if the file exists
+	if _, err := os.Stat(promptPath); os.IsNotExist(err) {
+		return prompts{}, errPromptNotFound
+	}
+
+	// Read the prompt file
+	promptData, err := os.ReadFile(promptPath)
+	if err != nil {
+		return prompts{}, err
+	}
+
+	// Split off the optional front matter
+	meta, promptData, err := parseFrontMatter(promptData)
+	if err != nil {
+		return prompts{}, fmt.Errorf("%s: %w", promptFile, err)
+	}
+
+	if err := meta.reasoning().Validate(); err != nil {
+		return prompts{}, fmt.Errorf("%s: %w", promptFile, err)
+	}
+	if err := meta.OllamaOptions.Validate(); err != nil {
+		return prompts{}, fmt.Errorf("%s: ollama_options: %w", promptFile, err)
+	}
+	if err := meta.validateModelSettings(); err != nil {
+		return prompts{}, fmt.Errorf("%s: %w", promptFile, err)
+	}
+
+	backend, err := s.pageBackend(meta)
+	if err != nil {
+		return prompts{}, fmt.Errorf("%s: %w", promptFile, err)
+	}
+
+	mediaType, isHTML, err := contentType(req.Route, meta.ContentType)
+	if err != nil {
+		return prompts{}, fmt.Errorf("%s: %w", promptFile, err)
+	}
+
+	if err := validateSections(meta.Sections, isHTML); err != nil {
+		return prompts{}, fmt.Errorf("%s: %w", promptFile, err)
+	}
+	if err := meta.Guardrails.validate(isHTML); err != nil {
+		return prompts{}, fmt.Errorf("%s: %w", promptFile, err)
+	}
+	if err := validateSchedule(meta.Schedule); err != nil {
+		return prompts{}, fmt.Errorf("%s: %w", promptFile, err)
+	}
+	if _, err := s.toolsFor(meta.Tools); err != nil {
+		return prompts{}, fmt.Errorf("%s: %w", promptFile, err)
+	}
+	images, err := s.pageImages(meta.Images)
+	if err != nil {
+		return prompts{}, fmt.Errorf("%s: %w", promptFile, err)
+	}
+
+	// Drafts don't exist for the public
+	if meta.Draft && !req.Preview {
+		return prompts{}, errPromptNotFound
+	}
+
+	// The prompt file content is the page prompt, or the variant scheduled for now
+	pagePrompt := string(promptData)
+	if rule := activeSchedule(meta.Schedule, time.Now()); rule != nil {
+		pagePrompt = rule.apply(pagePrompt)
+		if s.Debug {
+			log.Printf("🗓️  Using scheduled variant %q for /%s", rule.Name, req.Route)
+		}
+	}
+	// Templated prompts fill in the request's data; pages are cached per
+	// rendered prompt, so each query gets its own page. Placeholders are
+	// looked for in the prompt as written, not in what the request filled in.
+	dynamic, personal, templated := s.usesKV(pagePrompt), userPattern.MatchString(pagePrompt), s.templated(meta)
+	if templated {
+		if pagePrompt, err = s.renderTemplate(promptFile, pagePrompt, req); err != nil {
+			return prompts{}, fmt.Errorf("%s: %w", promptFile, err)
 		}
 	}
+
+	in := PromptInput{
+		Request:     req,
+		Meta:        meta,
+		Page:        pagePrompt,
+		ContentType: mediaType,
+		HTML:        isHTML,
+	}
+	systemPrompt, userPrompt, err := s.composer().Compose(in)
+	if err != nil {
+		return prompts{}, fmt.Errorf("%s: %w", promptFile, err)
+	}
+
+	// Print debug information if enabled
+	if s.Debug {
+		PrintRequestDebugInfo(backend.Backend, backend.Model, systemPrompt, userPrompt, false)
+	}
+
+	return prompts{System: systemPrompt, User: userPrompt, Meta: meta, ContentType: mediaType, HTML: isHTML, Dynamic: dynamic, Personal: personal, Templated: templated, Images: images, composed: in}, nil
 }
@@ -0,0 +1,326 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kekePower/museweb/pkg/models"
+)
+
+// maxShadowRuns caps concurrent shadow generations; samples beyond it are
+// skipped so a slow shadow model cannot pile up work
+const maxShadowRuns = 2
+
+// shadowTimeout bounds a shadow generation, which no client is waiting for
+const shadowTimeout = 5 * time.Minute
+
+// shadowState is the shadow configuration and what it has measured so far
+type shadowState struct {
+	name    string  // Named backend the sample is mirrored to
+	rate    float64 // Share of generations mirrored, 0-1
+	dir     string  // Where both outputs of every comparison are saved; "" keeps none
+	running atomic.Int32
+
+	mu      sync.Mutex
+	samples int
+	primary shadowTotals
+	shadow  shadowTotals
+}
+
+// shadowTotals sums up one side of the sampled generations
+type shadowTotals struct {
+	Errors  int   `json:"errors"`
+	Invalid int   `json:"invalid"`
+	Millis  int64 `json:"-"`
+	Bytes   int64 `json:"-"`
+	counted int
+}
+
+// SetShadow mirrors a sample of page generations (rate between 0 and 1) to
+// the named backend. Its output is never served: the latency, size, and
+// validity of both generations are logged so a model upgrade can be judged on
+// real traffic before switching. With a dir, both outputs and their stats are
+// saved there for offline review. An empty name turns mirroring off.
+func (s *Server) SetShadow(name string, rate float64, dir string) error {
+	if name == "" {
+		s.mu.Lock()
+		s.shadow = nil
+		s.mu.Unlock()
+		return nil
+	}
+	if _, err := s.backendFor(name); err != nil {
+		return err
+	}
+	if rate <= 0 || rate > 1 {
+		return fmt.Errorf("sample rate must be above 0 and at most 1, got %g", rate)
+	}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("shadow dir: %w", err)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shadow = &shadowState{name: name, rate: rate, dir: dir}
+	return nil
+}
+
+// shadowRun is one mirrored generation, measured next to the one served
+type shadowRun struct {
+	req     PageRequest
+	p       prompts
+	start   time.Time
+	primary bytes.Buffer // What the client received
+	done    chan struct{}
+
+	usageMu      sync.Mutex
+	primaryUsage models.Usage // Summed over every call the served page took
+
+	// Set by the shadow generation before done is closed
+	model    string
+	duration time.Duration
+	output   []byte
+	usage    models.Usage
+	err      error
+}
+
+// countUsage wraps the served generation's usage callback to add up its tokens
+func (run *shadowRun) countUsage(next func(models.Usage)) func(models.Usage) {
+	return func(u models.Usage) {
+		run.usageMu.Lock()
+		run.primaryUsage.PromptTokens += u.PromptTokens
+		run.primaryUsage.CompletionTokens += u.CompletionTokens
+		run.primaryUsage.Estimated = run.primaryUsage.Estimated || u.Estimated
+		run.usageMu.Unlock()
+		if next != nil {
+			next(u)
+		}
+	}
+}
+
+// startShadow mirrors the generation for req to the shadow backend when it is
+// sampled. The caller copies the served page into the run's primary buffer,
+// reports its usage through countUsage, and calls finishShadow once it is
+// done; nil means the request is not sampled.
+func (s *Server) startShadow(req PageRequest, p prompts, opts models.Options) *shadowRun {
+	s.mu.RLock()
+	state := s.shadow
+	s.mu.RUnlock()
+	if state == nil || rand.Float64() >= state.rate {
+		return nil
+	}
+	settings, err := s.backendFor(state.name)
+	if err != nil {
+		return nil
+	}
+	if state.running.Add(1) > maxShadowRuns {
+		state.running.Add(-1)
+		return nil
+	}
+
+	run := &shadowRun{req: req, p: p, start: time.Now(), done: make(chan struct{}), model: settings.Backend + "/" + settings.Model}
+	go func() {
+		defer state.running.Add(-1)
+		defer close(run.done)
+		ctx, cancel := context.WithTimeout(context.Background(), shadowTimeout)
+		defer cancel()
+
+		// One plain call: no failover, sections, or post-processing
+		var out bytes.Buffer
+		charge := s.recordUsage(req.Site, settings.Model)
+		opts.OnUsage = func(u models.Usage) {
+			run.usage = u
+			if charge != nil {
+				charge(u)
+			}
+		}
+		start := time.Now()
+		run.err = s.newHandler(settings, opts).StreamResponse(ctx, &out, discardFlusher{}, p.System, p.User)
+		run.duration = time.Since(start)
+		run.output = out.Bytes()
+	}()
+	return run
+}
+
+// finishShadow records the served generation and, once the shadow is done too,
+// logs and counts the comparison. It never waits for the shadow.
+func (s *Server) finishShadow(run *shadowRun, primaryModel string, primaryErr error) {
+	duration := time.Since(run.start)
+	s.mu.RLock()
+	state := s.shadow
+	s.mu.RUnlock()
+
+	go func() {
+		<-run.done
+		primaryProblem := pageProblem(run.p, run.primary.Bytes(), primaryErr)
+		shadowProblem := pageProblem(run.p, run.output, run.err)
+		log.Printf("🪞 /%s: %s %v %s %s · shadow %s %v %s %s", run.req.Route,
+			primaryModel, duration.Round(time.Millisecond), sizeKB(run.primary.Len()), primaryProblem,
+			run.model, run.duration.Round(time.Millisecond), sizeKB(len(run.output)), shadowProblem)
+		if state == nil {
+			return
+		}
+		state.mu.Lock()
+		defer state.mu.Unlock()
+		state.samples++
+		state.primary.add(duration, run.primary.Len(), primaryErr, primaryProblem)
+		state.shadow.add(run.duration, len(run.output), run.err, shadowProblem)
+		if state.dir != "" {
+			run.usageMu.Lock()
+			primary := newShadowSide(primaryModel, duration, run.primary.Len(), run.primaryUsage, primaryProblem)
+			run.usageMu.Unlock()
+			shadow := newShadowSide(run.model, run.duration, len(run.output), run.usage, shadowProblem)
+			if err := run.save(state.dir, primary, shadow); err != nil {
+				log.Printf("⚠️  Could not save shadow comparison for /%s: %v", run.req.Route, err)
+			}
+		}
+	}()
+}
+
+// shadowSide is one generation in a saved comparison
+type shadowSide struct {
+	Model            string `json:"model"`
+	Millis           int64  `json:"ms"`
+	Bytes            int    `json:"bytes"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	Estimated        bool   `json:"tokens_estimated"`
+	Result           string `json:"result"`
+}
+
+// newShadowSide collects the stats of one generation
+func newShadowSide(model string, d time.Duration, size int, u models.Usage, result string) shadowSide {
+	return shadowSide{
+		Model:            model,
+		Millis:           d.Milliseconds(),
+		Bytes:            size,
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		Estimated:        u.Estimated,
+		Result:           result,
+	}
+}
+
+// save writes both outputs and their stats to a new directory under dir,
+// named after the time and route: primary.html, shadow.html, and stats.json
+func (run *shadowRun) save(dir string, primary, shadow shadowSide) error {
+	name := run.start.Format("20060102-150405.000") + "-" + strings.ReplaceAll(run.req.Route, "/", "_")
+	if run.req.Lang != "" {
+		name += "-" + run.req.Lang
+	}
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return err
+	}
+	stats, err := json.MarshalIndent(map[string]interface{}{
+		"route":   run.req.Route,
+		"lang":    run.req.Lang,
+		"time":    run.start.UTC(),
+		"primary": primary,
+		"shadow":  shadow,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	files := map[string][]byte{"primary.html": run.primary.Bytes(), "shadow.html": run.output, "stats.json": stats}
+	for file, data := range files {
+		if err := os.WriteFile(filepath.Join(path, file), data, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add counts one generation
+func (t *shadowTotals) add(d time.Duration, size int, err error, problem string) {
+	switch {
+	case err != nil:
+		t.Errors++
+		return
+	case problem != "ok":
+		t.Invalid++
+	}
+	t.counted++
+	t.Millis += d.Milliseconds()
+	t.Bytes += int64(size)
+}
+
+// MarshalJSON adds the averages over the successful generations
+func (t shadowTotals) MarshalJSON() ([]byte, error) {
+	type totals shadowTotals
+	out := struct {
+		totals
+		AvgMillis int64 `json:"avg_ms"`
+		AvgBytes  int64 `json:"avg_bytes"`
+	}{totals: totals(t)}
+	if t.counted > 0 {
+		out.AvgMillis = t.Millis / int64(t.counted)
+		out.AvgBytes = t.Bytes / int64(t.counted)
+	}
+	return json.Marshal(out)
+}
+
+// ShadowStatus summarizes the shadow comparison for the admin API, or returns
+// nil when no shadow backend is configured
+func (s *Server) ShadowStatus() map[string]interface{} {
+	s.mu.RLock()
+	state := s.shadow
+	s.mu.RUnlock()
+	if state == nil {
+		return nil
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return map[string]interface{}{
+		"backend": state.name,
+		"rate":    state.rate,
+		"samples": state.samples,
+		"primary": state.primary,
+		"shadow":  state.shadow,
+	}
+}
+
+// pageProblem returns "ok" or what is wrong with a generated page: an error,
+// no content, an unfinished HTML document, invalid JSON, or broken guardrails
+func pageProblem(p prompts, body []byte, err error) string {
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	content := bytes.TrimSpace(body)
+	if len(content) == 0 {
+		return "empty"
+	}
+	if p.HTML {
+		lower := strings.ToLower(string(content))
+		if !strings.HasPrefix(lower, "<!doctype") && !strings.HasPrefix(lower, "<html") {
+			return "no document start"
+		}
+		if !strings.Contains(lower, "</html>") {
+			return "unfinished document"
+		}
+		if violations := p.Meta.Guardrails.check(string(content), false); p.Meta.Guardrails.active() && len(violations) > 0 {
+			return "guardrails: " + strings.Join(violations, "; ")
+		}
+		return "ok"
+	}
+	if mediaType, _, _ := mime.ParseMediaType(p.ContentType); mediaType == "application/json" && !json.Valid(content) {
+		return "invalid JSON"
+	}
+	return "ok"
+}
+
+// sizeKB formats a byte count for the comparison log
+func sizeKB(n int) string {
+	return fmt.Sprintf("%.1f KB", float64(n)/1024)
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// TemplateData is what templated prompts can read, so one prompt can render
+// differently per request:
+//
+//	Product {{.Query.id}} of {{.Vars.company}}, written in {{.Now.Year}}
+//	for a visitor speaking {{.Header "Accept-Language"}}
+type TemplateData struct {
+	Route string            // e.g. "blog/first-post"
+	Path  []string          // Route segments, e.g. ["blog", "first-post"]
+	Query map[string]string // First value of each query parameter
+	Lang  string            // Target language, if any
+	Now   time.Time
+	Vars  map[string]string // Configured variables (templates.vars)
+
+	header http.Header
+}
+
+// Header returns the value of a request header. Credentials (cookies and
+// authorization) are never handed to prompts.
+func (d TemplateData) Header(name string) string {
+	switch http.CanonicalHeaderKey(name) {
+	case "Cookie", "Authorization", "Proxy-Authorization":
+		return ""
+	}
+	return inert(d.header.Get(name))
+}
+
+// inertBraces turns braces into their full-width look-alikes, which read the
+// same to the model
+var inertBraces = strings.NewReplacer("{", "\uff5b", "}", "\uff5d")
+
+// inert makes a value from the request safe to render into a prompt: braces
+// are replaced, so no value, alone or next to another, can spell a
+// placeholder ({{kv ...}}, {{incr ...}}, {{user ...}}) expanded afterwards
+func inert(value string) string {
+	return inertBraces.Replace(value)
+}
+
+// templateFuncs keep the placeholders expanded when the page is generated
+// ({{user ...}}, {{kv ...}}, {{incr ...}}) as they are
+var templateFuncs = template.FuncMap{
+	"user": func(claim string) string { return "{{user " + strconv.Quote(claim) + "}}" },
+	"kv":   func(key string) string { return "{{kv " + strconv.Quote(key) + "}}" },
+	"incr": func(key string) string { return "{{incr " + strconv.Quote(key) + "}}" },
+}
+
+// templated reports whether a page's prompt is run as a template: as its
+// front matter says, else as Templates does
+func (s *Server) templated(meta FrontMatter) bool {
+	if meta.Template != nil {
+		return *meta.Template
+	}
+	return s.Templates
+}
+
+// renderTemplate runs a page prompt as a text/template with req's data.
+// Missing query parameters and variables are empty.
+func (s *Server) renderTemplate(name, page string, req PageRequest) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=zero").Funcs(templateFuncs).Parse(page)
+	if err != nil {
+		return "", err
+	}
+	data := TemplateData{
+		Route:  req.Route,
+		Path:   strings.Split(req.Route, "/"),
+		Query:  make(map[string]string, len(req.Query)),
+		Lang:   inert(req.Lang),
+		Now:    time.Now(),
+		Vars:   s.TemplateVars,
+		header: req.Header,
+	}
+	for key, values := range req.Query {
+		if len(values) > 0 {
+			data.Query[inert(key)] = inert(values[0])
+		}
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
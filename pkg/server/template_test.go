@@ -0,0 +1,75 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	s := New("ollama", "test-model", t.TempDir(), "", "", false)
+	s.TemplateVars = map[string]string{"company": "Acme"}
+	req := PageRequest{
+		Route: "blog/first-post",
+		Lang:  "de",
+		Query: url.Values{"id": {"42", "43"}, "q": {`{{kv "secret"}}`}, "{{kv": {"x"}},
+		Header: http.Header{
+			"Accept-Language": {"nb-NO"},
+			"Cookie":          {"session=abc"},
+			"Authorization":   {"Bearer abc"},
+			"X-Note":          {"}}{{incr \"hits\"}}"},
+		},
+	}
+	for _, tc := range []struct {
+		page, want string
+	}{
+		{`Product {{.Query.id}} of {{.Vars.company}}`, "Product 42 of Acme"},
+		{`{{.Route}} in {{index .Path 0}}, {{len .Path}} segments, lang {{.Lang}}`, "blog/first-post in blog, 2 segments, lang de"},
+		{`[{{.Query.missing}}{{.Vars.missing}}]`, "[]"},
+		{`{{.Header "accept-language"}}`, "nb-NO"},
+		{`[{{.Header "Cookie"}}{{.Header "Authorization"}}]`, "[]"},
+		{`{{if eq .Now.Year 0}}never{{else}}now{{end}}`, "now"},
+		// Placeholders expanded at generation time are kept as they are
+		{`{{kv "visits"}} {{incr "visits"}} {{user "name"}}`, `{{kv "visits"}} {{incr "visits"}} {{user "name"}}`},
+		// Request data never spells a placeholder, alone or next to another
+		{`{{.Query.q}}`, "｛｛kv \"secret\"｝｝"},
+		{`{{range $k, $v := .Query}}{{$k}}={{$v}};{{end}}`, "id=42;q=｛｛kv \"secret\"｝｝;｛｛kv=x;"},
+		{`{{"{{"}}{{.Header "X-Note"}}`, "{{｝｝｛｛incr \"hits\"｝｝"},
+	} {
+		got, err := s.renderTemplate("page", tc.page, req)
+		if err != nil {
+			t.Errorf("%s: %v", tc.page, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s = %q, want %q", tc.page, got, tc.want)
+		}
+	}
+
+	if _, err := s.renderTemplate("page", "{{.Query.id", req); err == nil {
+		t.Error("a broken template rendered")
+	}
+	if got, _ := s.renderTemplate("page", "{{.Now.Format \"2006\"}}", PageRequest{}); got != time.Now().Format("2006") {
+		t.Errorf("Now = %q", got)
+	}
+}
+
+func TestTemplated(t *testing.T) {
+	yes, no := true, false
+	for _, tc := range []struct {
+		site bool
+		page *bool
+		want bool
+	}{
+		{false, nil, false},
+		{true, nil, true},
+		{false, &yes, true},
+		{true, &no, false},
+	} {
+		s := &Server{Templates: tc.site}
+		if got := s.templated(FrontMatter{Template: tc.page}); got != tc.want {
+			t.Errorf("templates %v, front matter %v: %v", tc.site, tc.page, got)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kekePower/museweb/pkg/models"
+)
+
+// ToolOptions configure the built-in tools prompts can declare
+type ToolOptions struct {
+	// DataDir holds the files read_file may read, relative to the prompts
+	// directory ("data" when empty)
+	DataDir string
+	// AllowedHosts are the hosts fetch_url may fetch from; it is unavailable
+	// when empty
+	AllowedHosts []string
+}
+
+// toolFetchTimeout bounds a fetch_url call
+const toolFetchTimeout = 10 * time.Second
+
+var (
+	toolsMu sync.RWMutex
+	tools   = map[string]models.Tool{}
+)
+
+// RegisterTool makes a tool available to prompts that list its name under
+// "tools" in their front matter, replacing any tool of the same name. The
+// built-in read_file and fetch_url cannot be replaced.
+func RegisterTool(t models.Tool) {
+	toolsMu.Lock()
+	defer toolsMu.Unlock()
+	tools[t.Name] = t
+}
+
+// toolsFor returns the tools named in a page's front matter
+func (s *Server) toolsFor(names []string) ([]models.Tool, error) {
+	var resolved []models.Tool
+	for _, name := range names {
+		switch name {
+		case "read_file":
+			resolved = append(resolved, s.readFileTool())
+		case "fetch_url":
+			if len(s.Tools.AllowedHosts) == 0 {
+				return nil, fmt.Errorf("tool fetch_url needs tools.allowed_hosts")
+			}
+			resolved = append(resolved, s.fetchURLTool())
+		default:
+			toolsMu.RLock()
+			t, ok := tools[name]
+			toolsMu.RUnlock()
+			if !ok {
+				return nil, fmt.Errorf("unknown tool %q (available: %s)", name, strings.Join(toolNames(), ", "))
+			}
+			resolved = append(resolved, t)
+		}
+	}
+	return resolved, nil
+}
+
+// toolNames lists the tools prompts can declare
+func toolNames() []string {
+	toolsMu.RLock()
+	defer toolsMu.RUnlock()
+	names := []string{"fetch_url", "read_file"}
+	for name := range tools {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readFileTool reads a file from the data directory; paths cannot leave it
+func (s *Server) readFileTool() models.Tool {
+	dir := s.Tools.DataDir
+	if dir == "" {
+		dir = "data"
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(s.ActivePromptsDir(), dir)
+	}
+	return models.Tool{
+		Name:        "read_file",
+		Description: "Read a text data file (CSV, JSON, Markdown, ...) provided by the site, by its path relative to the data directory.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"path": map[string]interface{}{"type": "string", "description": "File path, e.g. \"team.json\""},
+			},
+			"required": []string{"path"},
+		},
+		Run: func(ctx context.Context, args json.RawMessage) (string, error) {
+			var in struct {
+				Path string `json:"path"`
+			}
+			if err := json.Unmarshal(args, &in); err != nil || in.Path == "" {
+				return "", fmt.Errorf("a path is required")
+			}
+			root, err := os.OpenRoot(dir)
+			if err != nil {
+				return "", fmt.Errorf("no data directory")
+			}
+			defer root.Close()
+			f, err := root.Open(filepath.Clean(in.Path))
+			if err != nil {
+				return "", fmt.Errorf("cannot read %s", in.Path)
+			}
+			defer f.Close()
+			data, err := io.ReadAll(io.LimitReader(f, 256*1024))
+			if err != nil {
+				return "", fmt.Errorf("cannot read %s", in.Path)
+			}
+			return string(data), nil
+		},
+	}
+}
+
+// fetchURLTool fetches a web page or API response from an allowed host
+func (s *Server) fetchURLTool() models.Tool {
+	allowed := s.Tools.AllowedHosts
+	return models.Tool{
+		Name:        "fetch_url",
+		Description: "Fetch a URL with GET and return the response body. Only these hosts are allowed: " + strings.Join(allowed, ", "),
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"url": map[string]interface{}{"type": "string", "description": "Absolute http or https URL"},
+			},
+			"required": []string{"url"},
+		},
+		Run: func(ctx context.Context, args json.RawMessage) (string, error) {
+			var in struct {
+				URL string `json:"url"`
+			}
+			if err := json.Unmarshal(args, &in); err != nil || in.URL == "" {
+				return "", fmt.Errorf("a url is required")
+			}
+			u, err := url.Parse(in.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				return "", fmt.Errorf("not an http or https URL: %s", in.URL)
+			}
+			if !slices.Contains(allowed, u.Hostname()) {
+				return "", fmt.Errorf("host %s is not allowed", u.Hostname())
+			}
+
+			ctx, cancel := context.WithTimeout(ctx, toolFetchTimeout)
+			defer cancel()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+			if err != nil {
+				return "", err
+			}
+			req.Header.Set("User-Agent", "MuseWeb")
+			// Redirects must stay on allowed hosts too
+			client := &http.Client{CheckRedirect: func(next *http.Request, via []*http.Request) error {
+				if !slices.Contains(allowed, next.URL.Hostname()) {
+					return fmt.Errorf("redirect to %s is not allowed", next.URL.Hostname())
+				}
+				return nil
+			}}
+			resp, err := client.Do(req)
+			if err != nil {
+				return "", err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return "", fmt.Errorf("%s answered %s", u.Hostname(), resp.Status)
+			}
+			data, err := io.ReadAll(io.LimitReader(resp.Body, 256*1024))
+			if err != nil {
+				return "", err
+			}
+			return string(data), nil
+		},
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/kekePower/museweb/pkg/translate"
+)
+
+// translates reports whether the page for req is translated by the external
+// translator instead of the model
+func (s *Server) translates(req PageRequest, p prompts) bool {
+	return s.Translator != nil && req.Lang != "" && p.HTML
+}
+
+// translationVersion fingerprints the prompts behind a translated page
+func translationVersion(p prompts) string {
+	return translate.Version(p.System, p.User)
+}
+
+// serveCachedTranslation writes a cached translation of the page, if there is one
+func (s *Server) serveCachedTranslation(w io.Writer, flusher http.Flusher, req PageRequest, p prompts) bool {
+	if s.TranslationCache == nil || req.Input != "" {
+		return false
+	}
+	html, ok := s.TranslationCache.Get(req.Route, req.Lang, translationVersion(p))
+	if !ok {
+		return false
+	}
+	if s.Debug {
+		log.Printf("🌐 Serving cached %s translation of /%s", req.Lang, req.Route)
+	}
+	io.WriteString(w, html)
+	flusher.Flush()
+	return true
+}
+
+// translatePage translates a generated page into req.Lang and caches the
+// result. On failure the untranslated page is returned.
+func (s *Server) translatePage(ctx context.Context, req PageRequest, p prompts, html string) string {
+	translated, err := s.Translator.Translate(ctx, html, req.Lang)
+	if err != nil {
+		log.Printf("⚠️  %s could not translate /%s to %s, serving it untranslated: %v", s.Translator.Name(), req.Route, req.Lang, err)
+		return html
+	}
+	if s.TranslationCache != nil && req.Input == "" {
+		s.TranslationCache.Put(req.Route, req.Lang, translationVersion(p), translated)
+	}
+	if s.Debug {
+		log.Printf("🌐 %s translated /%s to %s", s.Translator.Name(), req.Route, req.Lang)
+	}
+	return translated
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/kekePower/museweb/pkg/auth"
+)
+
+// Users tells who is signed in, such as an *auth.OIDC
+type Users interface {
+	// User returns the signed-in user of r, or nil
+	User(r *http.Request) *auth.User
+	// LoginURL returns where to sign in and come back to next
+	LoginURL(next string) string
+}
+
+// userPattern matches the user placeholders in page prompts: {{user "name"}}
+// is replaced by the signed-in user's name, {{user "email"}} by their email
+// address, and so on for the claims kept at sign-in ("" for visitors)
+var userPattern = regexp.MustCompile(`\{\{\s*user\s+"([^"]*)"\s*\}\}`)
+
+// expandUser fills in the user placeholders of a prompt
+func expandUser(prompt string, u *auth.User) string {
+	return userPattern.ReplaceAllStringFunc(prompt, func(m string) string {
+		if u == nil {
+			return ""
+		}
+		return u.Claim(userPattern.FindStringSubmatch(m)[1])
+	})
+}
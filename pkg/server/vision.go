@@ -0,0 +1,133 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/kekePower/museweb/pkg/models"
+)
+
+// DefaultMaxImageBytes is the largest image a form may upload
+const DefaultMaxImageBytes = 10 << 20
+
+// maxImages caps the images sent with one page request
+const maxImages = 4
+
+// maxFormField caps a text field of a multipart form
+const maxFormField = 64 << 10
+
+// errImageTooLarge and errNotImage reject uploads
+var (
+	errImageTooLarge = errors.New("image too large")
+	errNotImage      = errors.New("not an image")
+)
+
+// isMultipart reports whether r is a multipart/form-data POST
+func isMultipart(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "multipart/form-data"
+}
+
+// readMultipart reads a multipart form as the page input. Text fields are
+// passed on URL-encoded, as an ordinary form POST would send them; uploaded
+// images are returned for the model. Empty file fields are skipped.
+func (s *Server) readMultipart(r *http.Request) (string, []models.Image, error) {
+	limit := s.MaxImageBytes
+	if limit <= 0 {
+		limit = DefaultMaxImageBytes
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return "", nil, err
+	}
+
+	values := url.Values{}
+	var images []models.Image
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", nil, err
+		}
+
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, maxFormField))
+			if err != nil {
+				return "", nil, err
+			}
+			values.Add(part.FormName(), string(value))
+			continue
+		}
+
+		data, err := io.ReadAll(io.LimitReader(part, limit+1))
+		if err != nil {
+			return "", nil, err
+		}
+		if len(data) == 0 {
+			continue
+		}
+		if int64(len(data)) > limit {
+			return "", nil, fmt.Errorf("%w: %s is over %d MB", errImageTooLarge, part.FileName(), limit>>20)
+		}
+		if len(images) == maxImages {
+			return "", nil, fmt.Errorf("%w: at most %d images per request", errImageTooLarge, maxImages)
+		}
+		img, err := models.DetectImage(data)
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: %s: %v", errNotImage, part.FileName(), err)
+		}
+		images = append(images, img)
+	}
+	return values.Encode(), images, nil
+}
+
+// pageImages loads the images a page's front matter references from the
+// prompts' public directory
+func (s *Server) pageImages(names []string) ([]models.Image, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	root, err := os.OpenRoot(filepath.Join(s.ActivePromptsDir(), "public"))
+	if err != nil {
+		return nil, fmt.Errorf("images: %w", err)
+	}
+	defer root.Close()
+
+	images := make([]models.Image, 0, len(names))
+	for _, name := range names {
+		f, err := root.Open(filepath.Clean(name))
+		if err != nil {
+			return nil, fmt.Errorf("images: %w", err)
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("images: %w", err)
+		}
+		img, err := models.DetectImage(data)
+		if err != nil {
+			return nil, fmt.Errorf("images: %s: %w", name, err)
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+// warnVision logs when images go to a model that may not see them
+func (s *Server) warnVision(route string, active BackendSettings) {
+	if !models.AcceptsImages(active.Backend) {
+		log.Printf("⚠️  Backend '%s' does not take images, generating /%s from the text alone", active.Backend, route)
+		return
+	}
+	if caps, ok := models.LookupCapabilities(active.Model); ok && !caps.Vision {
+		log.Printf("🖼️  Sending images for /%s to %s, which is not known to accept them", route, active.Model)
+	}
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Warmer keeps the most requested pages generated ahead of time. Requests are
+// counted per route and language; every interval the top pages are generated
+// again in the background, and requests for them are answered from memory
+// instead of waiting for the model.
+type Warmer struct {
+	top      int
+	interval time.Duration
+
+	mu    sync.Mutex
+	hits  map[warmKey]float64  // Request counts, halved every interval so recent traffic counts most
+	sites map[warmKey]string   // Host of the latest request, for per-site budgets
+	pages map[warmKey]warmPage // Pages generated by the warmer
+}
+
+type warmKey struct{ route, lang string }
+
+type warmPage struct {
+	html      []byte
+	generated time.Time
+}
+
+// NewWarmer creates a warmer that keeps the top most requested pages
+// regenerated every interval
+func NewWarmer(top int, interval time.Duration) *Warmer {
+	return &Warmer{
+		top:      top,
+		interval: interval,
+		hits:     map[warmKey]float64{},
+		sites:    map[warmKey]string{},
+		pages:    map[warmKey]warmPage{},
+	}
+}
+
+// record counts a request for req's page
+func (wm *Warmer) record(req PageRequest) {
+	key := warmKey{req.Route, req.Lang}
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	wm.hits[key]++
+	wm.sites[key] = req.Site
+}
+
+// page returns the warm copy of req's page. Pages that dropped out of the top
+// are not regenerated, so copies older than two intervals are not served.
+func (wm *Warmer) page(req PageRequest) ([]byte, bool) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	p, ok := wm.pages[warmKey{req.Route, req.Lang}]
+	if !ok || time.Since(p.generated) > 2*wm.interval {
+		return nil, false
+	}
+	return p.html, true
+}
+
+// popular returns the most requested pages and decays the counts, forgetting
+// pages that are hardly requested any more. Warm copies of pages that are no
+// longer popular are dropped.
+func (wm *Warmer) popular() []PageRequest {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	keys := make([]warmKey, 0, len(wm.hits))
+	for key := range wm.hits {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if wm.hits[keys[i]] != wm.hits[keys[j]] {
+			return wm.hits[keys[i]] > wm.hits[keys[j]]
+		}
+		return keys[i].route+"?"+keys[i].lang < keys[j].route+"?"+keys[j].lang
+	})
+	if len(keys) > wm.top {
+		keys = keys[:wm.top]
+	}
+
+	reqs := make([]PageRequest, len(keys))
+	keep := make(map[warmKey]bool, len(keys))
+	for i, key := range keys {
+		reqs[i] = PageRequest{Route: key.route, Lang: key.lang, Site: wm.sites[key]}
+		keep[key] = true
+	}
+	for key := range wm.pages {
+		if !keep[key] {
+			delete(wm.pages, key)
+		}
+	}
+	for key, n := range wm.hits {
+		if n /= 2; n < 0.5 {
+			delete(wm.hits, key)
+			delete(wm.sites, key)
+		} else {
+			wm.hits[key] = n
+		}
+	}
+	return reqs
+}
+
+// store keeps a freshly generated page
+func (wm *Warmer) store(req PageRequest, html []byte) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	wm.pages[warmKey{req.Route, req.Lang}] = warmPage{html: html, generated: time.Now()}
+}
+
+// RunWarmer regenerates the most requested pages every interval until ctx is
+// done. Warm generations are ordinary generations: they count against budgets
+// and are recorded as snapshots.
+func (s *Server) RunWarmer(ctx context.Context) {
+	ticker := time.NewTicker(s.Warm.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.warm(ctx)
+		}
+	}
+}
+
+// warm regenerates the popular pages one after another, so warming never
+// loads the backend with more than one generation
+func (s *Server) warm(ctx context.Context) {
+	for _, req := range s.Warm.popular() {
+		start := time.Now()
+		var buf bytes.Buffer
+		if err := s.Generate(ctx, &buf, discardFlusher{}, req); err != nil {
+			log.Printf("⚠️  Could not warm /%s: %v", req.Route, err)
+			continue
+		}
+		if len(bytes.TrimSpace(buf.Bytes())) == 0 {
+			continue
+		}
+		s.Warm.store(req, buf.Bytes())
+		if s.Debug {
+			log.Printf("🔥 Warmed /%s (lang %q) in %v", req.Route, req.Lang, time.Since(start).Round(time.Millisecond))
+		}
+	}
+}
+
+// RunPrewarm generates the Prewarm pages into the response cache, and again
+// every PrewarmEvery (if set) until ctx is done, so visitors never wait for
+// them
+func (s *Server) RunPrewarm(ctx context.Context) {
+	s.prewarm(ctx)
+	if s.PrewarmEvery <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.PrewarmEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.prewarm(ctx)
+		}
+	}
+}
+
+// prewarm regenerates the Prewarm pages one after another
+func (s *Server) prewarm(ctx context.Context) {
+	start := time.Now()
+	done := 0
+	for _, route := range s.Prewarm {
+		if ctx.Err() != nil {
+			return
+		}
+		var buf bytes.Buffer
+		if err := s.Generate(ctx, &buf, discardFlusher{}, PageRequest{Route: route, NoCache: true}); err != nil {
+			log.Printf("⚠️  Could not prewarm /%s: %v", route, err)
+			continue
+		}
+		done++
+	}
+	log.Printf("🔥 Prewarmed %d of %d pages in %v", done, len(s.Prewarm), time.Since(start).Round(time.Millisecond))
+}
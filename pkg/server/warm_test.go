@@ -0,0 +1,89 @@
+package server
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestWarmerPopular(t *testing.T) {
+	wm := NewWarmer(2, time.Minute)
+	for _, hit := range []struct {
+		route, lang, site string
+		n                 int
+	}{
+		{"home", "", "a.example", 5},
+		{"home", "de", "a.example", 1},
+		{"about", "", "b.example", 3},
+		{"blog", "", "a.example", 3},
+	} {
+		for i := 0; i < hit.n; i++ {
+			wm.record(PageRequest{Route: hit.route, Lang: hit.lang, Site: hit.site})
+		}
+	}
+
+	for _, round := range []struct {
+		stored []string // Routes with a warm copy going into the round
+		want   []PageRequest
+		kept   []string // Warm copies left after it
+	}{
+		{
+			// Ties are broken by route, so the order is stable
+			stored: []string{"home", "blog"},
+			want:   []PageRequest{{Route: "home", Site: "a.example"}, {Route: "about", Site: "b.example"}},
+			kept:   []string{"home"},
+		},
+		{
+			// Counts are halved every round: home 2.5, about and blog 1.5
+			want: []PageRequest{{Route: "home", Site: "a.example"}, {Route: "about", Site: "b.example"}},
+			kept: []string{"home"},
+		},
+		{
+			// Home 1.25, about and blog 0.75; home in German is forgotten
+			want: []PageRequest{{Route: "home", Site: "a.example"}, {Route: "about", Site: "b.example"}},
+			kept: []string{"home"},
+		},
+		{want: []PageRequest{{Route: "home", Site: "a.example"}}, kept: []string{"home"}},
+		{want: []PageRequest{}},
+	} {
+		for _, route := range round.stored {
+			wm.store(PageRequest{Route: route}, []byte("<p>"+route+"</p>"))
+		}
+		if got := wm.popular(); !reflect.DeepEqual(got, round.want) {
+			t.Errorf("popular = %+v, want %+v", got, round.want)
+		}
+		for _, route := range []string{"home", "about", "blog"} {
+			_, ok := wm.page(PageRequest{Route: route})
+			if want := slices.Contains(round.kept, route); ok != want {
+				t.Errorf("warm copy of %s kept = %v, want %v", route, ok, want)
+			}
+		}
+	}
+}
+
+func TestWarmerPage(t *testing.T) {
+	wm := NewWarmer(10, time.Minute)
+	req := PageRequest{Route: "home", Lang: "de"}
+	if _, ok := wm.page(req); ok {
+		t.Error("a page never warmed was found")
+	}
+	wm.store(req, []byte("<p>Hallo</p>"))
+	for _, tc := range []struct {
+		req  PageRequest
+		age  time.Duration
+		want bool
+	}{
+		{req, 0, true},
+		{PageRequest{Route: "home"}, 0, false}, // Another language
+		{req, 119 * time.Second, true},
+		{req, 121 * time.Second, false}, // Older than two intervals
+	} {
+		key := warmKey{req.Route, req.Lang}
+		wm.pages[key] = warmPage{html: wm.pages[key].html, generated: time.Now().Add(-tc.age)}
+		html, ok := wm.page(tc.req)
+		if ok != tc.want || ok && string(html) != "<p>Hallo</p>" {
+			t.Errorf("page(%+v) %v old = %q, %v", tc.req, tc.age, html, ok)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+// Package snapshot keeps a bounded on-disk history of generated pages per
+// route so a bad generation can be rolled back by pinning an earlier version.
+package snapshot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when a key or version does not exist
+var ErrNotFound = errors.New("snapshot not found")
+
+// Version describes one stored generation
+type Version struct {
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+	Size    int       `json:"size"`
+	Model   string    `json:"model,omitempty"`
+}
+
+// index is the per-key metadata file
+type index struct {
+	Pinned   string    `json:"pinned,omitempty"`
+	Versions []Version `json:"versions"` // Newest first
+}
+
+// Store keeps the last N generations per key under a directory
+type Store struct {
+	dir  string
+	keep int
+	mu   sync.Mutex
+}
+
+// Key builds the snapshot key for a route and optional language
+func Key(route, lang string) string {
+	if lang == "" {
+		return route
+	}
+	return route + "@" + lang
+}
+
+// Open creates (if needed) and opens a snapshot store keeping keep versions per key
+func Open(dir string, keep int) (*Store, error) {
+	if keep < 1 {
+		keep = 1
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating snapshot directory: %w", err)
+	}
+	return &Store{dir: dir, keep: keep}, nil
+}
+
+// keyDir returns the directory of key. A leading dot is escaped as well, so
+// the keys "." and ".." stay inside the store like any other.
+func (s *Store) keyDir(key string) string {
+	name := url.PathEscape(key)
+	if strings.HasPrefix(name, ".") {
+		name = "%2E" + name[1:]
+	}
+	return filepath.Join(s.dir, name)
+}
+
+func (s *Store) readIndex(key string) (*index, error) {
+	data, err := os.ReadFile(filepath.Join(s.keyDir(key), "index.json"))
+	if os.IsNotExist(err) {
+		return &index{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var idx index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("reading snapshot index for %q: %w", key, err)
+	}
+	return &idx, nil
+}
+
+func (s *Store) writeIndex(key string, idx *index) error {
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.keyDir(key), "index.json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Save stores a new generation for key and prunes versions beyond the limit.
+// A pinned version is never pruned.
+func (s *Store) Save(key string, html []byte, model string) (Version, error) {
+	if key == "" {
+		return Version{}, errors.New("empty snapshot key")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.keyDir(key), 0o755); err != nil {
+		return Version{}, err
+	}
+	idx, err := s.readIndex(key)
+	if err != nil {
+		return Version{}, err
+	}
+
+	now := time.Now().UTC()
+	v := Version{
+		ID:      now.Format("20060102T150405.000000000Z"),
+		Created: now,
+		Size:    len(html),
+		Model:   model,
+	}
+	if err := os.WriteFile(filepath.Join(s.keyDir(key), v.ID+".html"), html, 0o644); err != nil {
+		return Version{}, err
+	}
+	idx.Versions = append([]Version{v}, idx.Versions...)
+
+	// Prune the oldest unpinned versions
+	var kept []Version
+	for i, old := range idx.Versions {
+		if i < s.keep || old.ID == idx.Pinned {
+			kept = append(kept, old)
+			continue
+		}
+		os.Remove(filepath.Join(s.keyDir(key), old.ID+".html"))
+	}
+	idx.Versions = kept
+
+	return v, s.writeIndex(key, idx)
+}
+
+// Keys returns every key with stored versions, sorted
+func (s *Store) Keys() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		key, err := url.PathUnescape(e.Name())
+		if err != nil {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// List returns the stored versions for key (newest first) and the pinned version ID
+func (s *Store) List(key string) ([]Version, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx, err := s.readIndex(key)
+	if err != nil {
+		return nil, "", err
+	}
+	return idx.Versions, idx.Pinned, nil
+}
+
+// Load returns the markup of a stored version
+func (s *Store) Load(key, id string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx, err := s.readIndex(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := findVersion(idx, id); !ok {
+		return nil, ErrNotFound
+	}
+	return os.ReadFile(filepath.Join(s.keyDir(key), id+".html"))
+}
+
+// Latest returns the newest stored version for key
+func (s *Store) Latest(key string) ([]byte, Version, error) {
+	versions, _, err := s.List(key)
+	if err != nil {
+		return nil, Version{}, err
+	}
+	if len(versions) == 0 {
+		return nil, Version{}, ErrNotFound
+	}
+	data, err := s.Load(key, versions[0].ID)
+	return data, versions[0], err
+}
+
+// Pinned returns the pinned version for key, if any
+func (s *Store) Pinned(key string) ([]byte, Version, bool) {
+	versions, pinned, err := s.List(key)
+	if err != nil || pinned == "" {
+		return nil, Version{}, false
+	}
+	for _, v := range versions {
+		if v.ID == pinned {
+			data, err := s.Load(key, v.ID)
+			if err != nil {
+				return nil, Version{}, false
+			}
+			return data, v, true
+		}
+	}
+	return nil, Version{}, false
+}
+
+// Pin makes key always serve version id until unpinned
+func (s *Store) Pin(key, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx, err := s.readIndex(key)
+	if err != nil {
+		return err
+	}
+	if _, ok := findVersion(idx, id); !ok {
+		return ErrNotFound
+	}
+	idx.Pinned = id
+	return s.writeIndex(key, idx)
+}
+
+// Unpin resumes live generation for key
+func (s *Store) Unpin(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx, err := s.readIndex(key)
+	if err != nil {
+		return err
+	}
+	if len(idx.Versions) == 0 {
+		return ErrNotFound
+	}
+	idx.Pinned = ""
+	return s.writeIndex(key, idx)
+}
+
+// Rollback pins the version preceding the one currently served (the pinned
+// version, or the newest if nothing is pinned)
+func (s *Store) Rollback(key string) (Version, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx, err := s.readIndex(key)
+	if err != nil {
+		return Version{}, err
+	}
+	current := 0
+	if idx.Pinned != "" {
+		if i, ok := findVersion(idx, idx.Pinned); ok {
+			current = i
+		}
+	}
+	if current+1 >= len(idx.Versions) {
+		return Version{}, fmt.Errorf("no earlier version of %q to roll back to: %w", key, ErrNotFound)
+	}
+	target := idx.Versions[current+1]
+	idx.Pinned = target.ID
+	return target, s.writeIndex(key, idx)
+}
+
+func findVersion(idx *index, id string) (int, bool) {
+	for i, v := range idx.Versions {
+		if v.ID == id {
+			return i, true
+		}
+	}
+	return -1, false
+}
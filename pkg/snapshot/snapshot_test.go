@@ -0,0 +1,157 @@
+package snapshot
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// save stores a generation of key for each page, oldest first
+func save(t *testing.T, s *Store, key string, pages ...string) []Version {
+	t.Helper()
+	var versions []Version
+	for _, page := range pages {
+		v, err := s.Save(key, []byte(page), "test-model")
+		if err != nil {
+			t.Fatal(err)
+		}
+		versions = append(versions, v)
+	}
+	return versions
+}
+
+// ids returns the IDs of versions
+func ids(versions []Version) []string {
+	var ids []string
+	for _, v := range versions {
+		ids = append(ids, v.ID)
+	}
+	return ids
+}
+
+func TestSave(t *testing.T) {
+	s, err := Open(t.TempDir(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := save(t, s, Key("about", "de"), "<p>1</p>", "<p>2</p>", "<p>3</p>")
+
+	// Only the newest two are kept, newest first
+	versions, pinned, err := s.List("about@de")
+	if err != nil || pinned != "" {
+		t.Fatalf("List: %v, pinned %q", err, pinned)
+	}
+	if want := []string{v[2].ID, v[1].ID}; !reflect.DeepEqual(ids(versions), want) {
+		t.Errorf("versions = %v, want %v", ids(versions), want)
+	}
+	if versions[0].Size != 8 || versions[0].Model != "test-model" {
+		t.Errorf("newest version = %+v", versions[0])
+	}
+	if _, err := s.Load("about@de", v[0].ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("pruned version: %v", err)
+	}
+	if html, latest, err := s.Latest("about@de"); err != nil || string(html) != "<p>3</p>" || latest.ID != v[2].ID {
+		t.Errorf("Latest = %q, %+v, %v", html, latest, err)
+	}
+	if _, _, err := s.Latest("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Latest of a missing key: %v", err)
+	}
+	if _, err := s.Save("", []byte("<p>"), "test-model"); err == nil {
+		t.Error("saved an empty key")
+	}
+}
+
+func TestPin(t *testing.T) {
+	s, err := Open(t.TempDir(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := save(t, s, "home", "<p>1</p>", "<p>2</p>")
+	if err := s.Pin("home", v[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if html, pinned, ok := s.Pinned("home"); !ok || string(html) != "<p>1</p>" || pinned.ID != v[0].ID {
+		t.Errorf("Pinned = %q, %+v, %v", html, pinned, ok)
+	}
+
+	// The pinned version outlives the limit
+	v = append(v, save(t, s, "home", "<p>3</p>", "<p>4</p>")...)
+	versions, _, _ := s.List("home")
+	if want := []string{v[3].ID, v[2].ID, v[0].ID}; !reflect.DeepEqual(ids(versions), want) {
+		t.Errorf("versions = %v, want %v", ids(versions), want)
+	}
+
+	for _, tc := range []struct{ key, id string }{{"home", v[1].ID}, {"home", "no-such-version"}, {"missing", v[0].ID}} {
+		if err := s.Pin(tc.key, tc.id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Pin(%q, %q): %v", tc.key, tc.id, err)
+		}
+	}
+	if err := s.Unpin("home"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := s.Pinned("home"); ok {
+		t.Error("still pinned after Unpin")
+	}
+	if err := s.Unpin("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unpin of a missing key: %v", err)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	s, err := Open(t.TempDir(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := save(t, s, "home", "<p>1</p>", "<p>2</p>", "<p>3</p>")
+
+	// Each rollback steps back from the version served
+	for _, want := range []Version{v[1], v[0]} {
+		got, err := s.Rollback("home")
+		if err != nil || got.ID != want.ID {
+			t.Fatalf("Rollback = %+v, %v, want %s", got, err, want.ID)
+		}
+		if _, pinned, _ := s.List("home"); pinned != want.ID {
+			t.Errorf("pinned %q after rolling back, want %q", pinned, want.ID)
+		}
+	}
+	if _, err := s.Rollback("home"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("rolling back past the oldest version: %v", err)
+	}
+	if _, err := s.Rollback("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("rolling back a missing key: %v", err)
+	}
+}
+
+func TestKeysStayInsideTheStore(t *testing.T) {
+	root := t.TempDir()
+	s, err := Open(filepath.Join(root, "snapshots"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{".", "..", "../escape", "blog/first-post", ".hidden", "a%2Fb"}
+	for _, key := range keys {
+		save(t, s, key, "<p>"+key+"</p>")
+	}
+
+	got, err := s.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{".", "..", "../escape", ".hidden", "a%2Fb", "blog/first-post"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Keys = %q, want %q", got, want)
+	}
+	for _, key := range keys {
+		if html, _, err := s.Latest(key); err != nil || string(html) != "<p>"+key+"</p>" {
+			t.Errorf("Latest(%q) = %q, %v", key, html, err)
+		}
+	}
+	if entries, _ := os.ReadDir(root); len(entries) != 1 {
+		t.Errorf("snapshots written next to the store: %v", entries)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(root, "snapshots")); len(entries) != len(keys) {
+		t.Errorf("store has %d entries, want a directory per key", len(entries))
+	}
+}
@@ -0,0 +1,74 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// auditMigrations creates the audit log tables
+var auditMigrations = []Migration{
+	{Version: 1, Name: "create audit_log", SQL: `
+		CREATE TABLE audit_log (
+			id      INTEGER PRIMARY KEY AUTOINCREMENT,
+			at      INTEGER NOT NULL,
+			actor   TEXT    NOT NULL,
+			action  TEXT    NOT NULL,
+			target  TEXT    NOT NULL DEFAULT '',
+			details TEXT    NOT NULL DEFAULT ''
+		);
+		CREATE INDEX audit_log_at ON audit_log (at);`},
+}
+
+// AuditEntry is one recorded administrative action
+type AuditEntry struct {
+	ID      int64     `json:"id"`
+	At      time.Time `json:"at"`
+	Actor   string    `json:"actor"`
+	Action  string    `json:"action"`
+	Target  string    `json:"target,omitempty"`
+	Details string    `json:"details,omitempty"`
+}
+
+// AuditLog records administrative actions such as pins, rollbacks, and model swaps
+type AuditLog struct {
+	db *DB
+}
+
+// NewAuditLog prepares the audit log tables in db
+func NewAuditLog(db *DB) (*AuditLog, error) {
+	if err := db.Migrate("audit", auditMigrations); err != nil {
+		return nil, err
+	}
+	return &AuditLog{db: db}, nil
+}
+
+// Record appends an entry to the audit log
+func (a *AuditLog) Record(actor, action, target, details string) error {
+	_, err := a.db.Exec(`INSERT INTO audit_log (at, actor, action, target, details) VALUES (?, ?, ?, ?, ?)`,
+		time.Now().UnixMilli(), actor, action, target, details)
+	if err != nil {
+		return fmt.Errorf("recording audit entry: %w", err)
+	}
+	return nil
+}
+
+// Recent returns up to limit entries, newest first
+func (a *AuditLog) Recent(limit int) ([]AuditEntry, error) {
+	rows, err := a.db.Query(`SELECT id, at, actor, action, target, details FROM audit_log ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		var at int64
+		if err := rows.Scan(&e.ID, &at, &e.Actor, &e.Action, &e.Target, &e.Details); err != nil {
+			return nil, err
+		}
+		e.At = time.UnixMilli(at).UTC()
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
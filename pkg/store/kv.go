@@ -0,0 +1,70 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// kvMigrations creates the key-value table
+var kvMigrations = []Migration{
+	{Version: 1, Name: "create kv", SQL: `
+		CREATE TABLE kv (
+			key     TEXT    PRIMARY KEY,
+			value   TEXT    NOT NULL,
+			updated INTEGER NOT NULL
+		);`},
+}
+
+// KV is a small persistent key-value store for prompt authors: visit
+// counters, RSVP tallies, and other state simple pages need
+type KV struct {
+	db *DB
+}
+
+// NewKV prepares the key-value table in db
+func NewKV(db *DB) (*KV, error) {
+	if err := db.Migrate("kv", kvMigrations); err != nil {
+		return nil, err
+	}
+	return &KV{db: db}, nil
+}
+
+// Get returns the value of key, or "" when it was never set
+func (kv *KV) Get(key string) (string, error) {
+	var value string
+	err := kv.db.QueryRow(`SELECT value FROM kv WHERE key = ?`, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", key, err)
+	}
+	return value, nil
+}
+
+// Set stores value under key
+func (kv *KV) Set(key, value string) error {
+	_, err := kv.db.Exec(`INSERT INTO kv (key, value, updated) VALUES (?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value, updated = excluded.updated`,
+		key, value, time.Now().UnixMilli())
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", key, err)
+	}
+	return nil
+}
+
+// Incr adds delta to the number stored under key and returns the new value.
+// Unset keys count from 0; a value that is not a number counts as 0.
+func (kv *KV) Incr(key string, delta int64) (int64, error) {
+	var value int64
+	err := kv.db.QueryRow(`INSERT INTO kv (key, value, updated) VALUES (?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = CAST(CAST(value AS INTEGER) + ? AS TEXT), updated = excluded.updated
+		RETURNING CAST(value AS INTEGER)`,
+		key, fmt.Sprint(delta), time.Now().UnixMilli(), delta).Scan(&value)
+	if err != nil {
+		return 0, fmt.Errorf("incrementing %s: %w", key, err)
+	}
+	return value, nil
+}
@@ -0,0 +1,101 @@
+// Package store provides MuseWeb's optional embedded SQLite database. It is a
+// single file shared by every subsystem that needs durable state (audit log,
+// sessions, feedback, comments, cache index); each subsystem owns its tables
+// and brings them up to date with Migrate.
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite" // Pure-Go SQLite driver, no cgo required
+)
+
+// DB is the shared SQLite database
+type DB struct {
+	*sql.DB
+	path string
+}
+
+// Migration is one schema change for a subsystem. Versions start at 1 and are
+// applied in order, each exactly once.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Open opens (creating if needed) the database at path and prepares the
+// migrations table
+func Open(path string) (*DB, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("creating database directory: %w", err)
+		}
+	}
+
+	dsn := "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(on)"
+	sqlDB, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("opening database %s: %w", path, err)
+	}
+	// SQLite allows a single writer; one connection avoids SQLITE_BUSY under load
+	sqlDB.SetMaxOpenConns(1)
+
+	db := &DB{DB: sqlDB, path: path}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		subsystem  TEXT    NOT NULL,
+		version    INTEGER NOT NULL,
+		name       TEXT    NOT NULL,
+		applied_at INTEGER NOT NULL,
+		PRIMARY KEY (subsystem, version)
+	)`)
+	if err != nil {
+		sqlDB.Close()
+		return nil, fmt.Errorf("initializing database %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// Path returns the database file path
+func (db *DB) Path() string {
+	return db.path
+}
+
+// Migrate applies the migrations of subsystem that have not run yet, each in
+// its own transaction
+func (db *DB) Migrate(subsystem string, migrations []Migration) error {
+	var current int
+	err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations WHERE subsystem = ?`, subsystem).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("reading %s schema version: %w", subsystem, err)
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(m.SQL); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("%s migration %d (%s): %w", subsystem, m.Version, m.Name, err)
+		}
+		_, err = tx.Exec(`INSERT INTO schema_migrations (subsystem, version, name, applied_at) VALUES (?, ?, ?, ?)`,
+			subsystem, m.Version, m.Name, time.Now().Unix())
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		current = m.Version
+	}
+	return nil
+}
@@ -0,0 +1,330 @@
+// Package testsupport runs MuseWeb in tests. It starts a scripted
+// OpenAI-compatible backend, writes prompt directories, and serves a
+// server.Server over HTTP, so routing, streaming, and sanitization can be
+// tested black-box by the project and by programs embedding MuseWeb:
+//
+//	site := testsupport.NewSite(t, map[string]string{
+//		"home.txt": "Create a home page",
+//	}, testsupport.Reply("```html\n<html><body>Hello</body></html>\n```"))
+//	page := site.Get("/")
+//	// page.Status == 200, page.Body == "<html><body>Hello</body></html>"
+package testsupport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kekePower/museweb/pkg/server"
+)
+
+// DefaultSystemPrompt is written to prompt directories that have no
+// system_prompt.txt
+const DefaultSystemPrompt = "You are a web designer. Answer with one complete HTML document."
+
+// DefaultChunkSize is how many bytes of a reply the backend streams per event
+const DefaultChunkSize = 16
+
+// Responder answers one generation request with the model's raw output
+type Responder func(req BackendRequest) string
+
+// Reply answers every request with the same output
+func Reply(output string) Responder {
+	return func(BackendRequest) string { return output }
+}
+
+// BackendRequest is a generation request the backend received
+type BackendRequest struct {
+	Model  string
+	System string
+	User   string
+	Images []string // Data URLs of the images sent with the user prompt
+
+	// Temperature and MaxTokens are the sampling parameters sent, if any
+	Temperature *float64
+	MaxTokens   int
+}
+
+// Backend is a scripted OpenAI-compatible chat completions server. It
+// streams the responder's output in small server-sent events, like a real
+// model would.
+type Backend struct {
+	// URL is the API base to point MuseWeb at
+	URL string
+	// ChunkSize is how many bytes each event carries (DefaultChunkSize when 0)
+	ChunkSize int
+
+	t       testing.TB
+	srv     *httptest.Server
+	respond Responder
+
+	mu       sync.Mutex
+	requests []BackendRequest
+	failures []int
+}
+
+// NewBackend starts a backend answering with respond. It is closed when the
+// test ends.
+func NewBackend(t testing.TB, respond Responder) *Backend {
+	t.Helper()
+	b := &Backend{t: t, respond: respond}
+	b.srv = httptest.NewServer(http.HandlerFunc(b.serve))
+	b.URL = b.srv.URL
+	t.Cleanup(b.srv.Close)
+	return b
+}
+
+// Requests returns the generation requests received so far
+func (b *Backend) Requests() []BackendRequest {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]BackendRequest(nil), b.requests...)
+}
+
+// FailNext makes the next requests fail with the given HTTP statuses, one
+// status per request, before the responder answers again
+func (b *Backend) FailNext(statuses ...int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = append(b.failures, statuses...)
+}
+
+// serve answers one chat completions request
+func (b *Backend) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/chat/completions") {
+		http.NotFound(w, r)
+		return
+	}
+	var payload struct {
+		Model               string   `json:"model"`
+		Temperature         *float64 `json:"temperature"`
+		MaxTokens           int      `json:"max_tokens"`
+		MaxCompletionTokens int      `json:"max_completion_tokens"`
+		Messages            []struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, `{"error":{"message":"invalid request"}}`, http.StatusBadRequest)
+		return
+	}
+	req := BackendRequest{Model: payload.Model, Temperature: payload.Temperature, MaxTokens: max(payload.MaxTokens, payload.MaxCompletionTokens)}
+	for _, m := range payload.Messages {
+		switch m.Role {
+		case "system":
+			json.Unmarshal(m.Content, &req.System)
+		case "user":
+			// Plain text, or text and image parts
+			if json.Unmarshal(m.Content, &req.User) == nil {
+				break
+			}
+			var parts []struct {
+				Type     string `json:"type"`
+				Text     string `json:"text"`
+				ImageURL struct {
+					URL string `json:"url"`
+				} `json:"image_url"`
+			}
+			json.Unmarshal(m.Content, &parts)
+			for _, part := range parts {
+				switch part.Type {
+				case "text":
+					req.User += part.Text
+				case "image_url":
+					req.Images = append(req.Images, part.ImageURL.URL)
+				}
+			}
+		}
+	}
+
+	b.mu.Lock()
+	b.requests = append(b.requests, req)
+	status := 0
+	if len(b.failures) > 0 {
+		status, b.failures = b.failures[0], b.failures[1:]
+	}
+	b.mu.Unlock()
+	if status != 0 {
+		http.Error(w, fmt.Sprintf(`{"error":{"message":"scripted failure %d"}}`, status), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+	size := b.ChunkSize
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+	output := b.respond(req)
+	for len(output) > 0 {
+		n := min(size, len(output))
+		event, _ := json.Marshal(map[string]interface{}{
+			"model":   payload.Model,
+			"choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]string{"content": output[:n]}}},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", event)
+		if flusher != nil {
+			flusher.Flush()
+		}
+		output = output[n:]
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+// Prompts writes files (names relative to the directory, such as "home.txt"
+// or "blog/post.txt") to a temporary prompts directory and returns its path.
+// A system_prompt.txt is added when files has none.
+func Prompts(t testing.TB, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if _, ok := files["system_prompt.txt"]; !ok {
+		WriteFile(t, dir, "system_prompt.txt", DefaultSystemPrompt)
+	}
+	for name, content := range files {
+		WriteFile(t, dir, name, content)
+	}
+	return dir
+}
+
+// WriteFile writes one file under dir, creating its directories, e.g. to
+// change a prompt during a test
+func WriteFile(t testing.TB, dir, name, content string) {
+	t.Helper()
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Site is MuseWeb serving a prompts directory from a scripted backend
+type Site struct {
+	// Server is the MuseWeb server; configure it before the first request
+	*server.Server
+	// Backend is the scripted backend the server generates pages with
+	Backend *Backend
+	// URL is the site's base URL
+	URL string
+	// Client keeps cookies and does not follow redirects, so tests can
+	// check them
+	Client *http.Client
+
+	t testing.TB
+}
+
+// NewSite starts MuseWeb on the prompts in files with a backend answering
+// with respond. configure runs before the site accepts requests; use it to
+// set server options. Everything is closed when the test ends.
+func NewSite(t testing.TB, files map[string]string, respond Responder, configure ...func(*server.Server)) *Site {
+	t.Helper()
+	backend := NewBackend(t, respond)
+	s := server.New("openai", "test-model", Prompts(t, files), "test-key", backend.URL, false)
+	s.ResponseAdapter = "openai"
+	for _, fn := range configure {
+		fn(s)
+	}
+
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return &Site{Server: s, Backend: backend, URL: srv.URL, Client: client, t: t}
+}
+
+// Page is a response of the site, read to the end
+type Page struct {
+	Status int
+	Header http.Header
+	Body   string
+}
+
+// Get requests path and reads the whole page
+func (s *Site) Get(path string) Page {
+	s.t.Helper()
+	return s.read(s.Open(http.MethodGet, path, nil))
+}
+
+// Post submits form to path and reads the whole page
+func (s *Site) Post(path string, form url.Values) Page {
+	s.t.Helper()
+	return s.read(s.Open(http.MethodPost, path, form))
+}
+
+// Upload posts form and files (field name to file content) as
+// multipart/form-data and reads the whole page
+func (s *Site) Upload(path string, form url.Values, files map[string][]byte) Page {
+	s.t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, values := range form {
+		for _, value := range values {
+			mw.WriteField(name, value)
+		}
+	}
+	for name, data := range files {
+		fw, err := mw.CreateFormFile(name, name)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		fw.Write(data)
+	}
+	mw.Close()
+
+	resp, err := s.Client.Post(s.URL+path, mw.FormDataContentType(), &body)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return s.read(resp)
+}
+
+// Open sends a request and returns the response unread, e.g. to follow a
+// page as it streams. The caller closes the body.
+func (s *Site) Open(method, path string, form url.Values) *http.Response {
+	s.t.Helper()
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequest(method, s.URL+path, body)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return resp
+}
+
+// read reads and closes a response
+func (s *Site) read(resp *http.Response) Page {
+	s.t.Helper()
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return Page{Status: resp.StatusCode, Header: resp.Header, Body: string(data)}
+}
@@ -0,0 +1,140 @@
+package tracing
+
+import (
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Middleware starts a server span for every request next handles, named
+// after the method and path, and records the response status
+func (t *Tracer) Middleware(next http.Handler) http.Handler {
+	if t == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := t.StartRequest(r, r.Method+" "+r.URL.Path,
+			String("http.request.method", r.Method),
+			String("url.path", r.URL.Path),
+			String("user_agent.original", r.UserAgent()),
+		)
+		if span == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		defer span.End()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		span.SetAttributes(Int("http.response.status_code", sw.status))
+		if sw.status >= 500 {
+			span.fail(http.StatusText(sw.status))
+		}
+	})
+}
+
+// statusWriter remembers the status code written through it
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader implements http.ResponseWriter
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements http.ResponseWriter
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush implements http.Flusher
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Transport wraps base so requests made within a span get a client span of
+// their own, lasting until the response body is closed (streamed responses
+// included), and carry a traceparent header. Requests without a span in
+// their context pass straight through.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return &transport{base: base}
+}
+
+type transport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	_, span := Start(req.Context(), req.Method+" "+req.URL.Host,
+		String("http.request.method", req.Method),
+		String("server.address", req.URL.Host),
+		// Never the query: some APIs take their key there
+		String("url.path", req.URL.Path),
+	)
+	if span == nil {
+		return t.base.RoundTrip(req)
+	}
+	span.kind = KindClient
+
+	// RoundTrippers must not modify the request
+	req = req.Clone(req.Context())
+	req.Header.Set("traceparent", span.TraceParent())
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		span.End()
+		return nil, err
+	}
+	span.SetAttributes(Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		span.fail(resp.Status)
+	}
+	resp.Body = &spanBody{ReadCloser: resp.Body, span: span}
+	return resp, nil
+}
+
+// spanBody ends its span when the response has been read or closed
+type spanBody struct {
+	io.ReadCloser
+	span *Span
+	once sync.Once
+}
+
+// Read implements io.Reader
+func (b *spanBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.once.Do(b.span.End)
+	} else if err != nil {
+		b.once.Do(func() {
+			b.span.RecordError(err)
+			b.span.End()
+		})
+	}
+	return n, err
+}
+
+// Close implements io.Closer
+func (b *spanBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.span.End)
+	return err
+}
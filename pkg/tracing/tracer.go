@@ -0,0 +1,322 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of Options
+const (
+	DefaultServiceName = "museweb"
+	DefaultBatchSize   = 512
+	DefaultInterval    = 5 * time.Second
+)
+
+// queueSize bounds the finished spans waiting for export; beyond it spans
+// are dropped rather than slowing down requests
+const queueSize = 4096
+
+// Options configure a Tracer
+type Options struct {
+	// Endpoint is the collector's OTLP/HTTP address, such as
+	// "http://localhost:4318"; spans are posted to its /v1/traces unless it
+	// names a path of its own
+	Endpoint string
+	// Headers are sent with every export, e.g. a vendor's API key
+	Headers map[string]string
+	// ServiceName identifies the traces' source (DefaultServiceName when empty)
+	ServiceName string
+	// SampleRatio is the share of new traces recorded, 0 to 1. Requests
+	// carrying a traceparent follow their caller's decision.
+	SampleRatio float64
+	// BatchSize and Interval bound how many spans are exported at once and
+	// how long finished spans wait (DefaultBatchSize, DefaultInterval when 0)
+	BatchSize int
+	Interval  time.Duration
+}
+
+// Tracer starts root spans and exports finished spans in the background
+type Tracer struct {
+	url     string
+	opts    Options
+	client  *http.Client
+	queue   chan *Span
+	flushed chan struct{} // Closed when the exporter stopped
+
+	mu      sync.Mutex
+	closed  bool
+	dropped int
+}
+
+// New creates a tracer exporting to opts.Endpoint; call Shutdown to flush
+// the remaining spans
+func New(opts Options) (*Tracer, error) {
+	u, err := url.Parse(opts.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q: use an http(s) URL such as http://localhost:4318", opts.Endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	if opts.ServiceName == "" {
+		opts.ServiceName = DefaultServiceName
+	}
+	if opts.SampleRatio < 0 || opts.SampleRatio > 1 {
+		return nil, fmt.Errorf("invalid sample ratio %v: must be between 0 and 1", opts.SampleRatio)
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+	t := &Tracer{
+		url:     u.String(),
+		opts:    opts,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		queue:   make(chan *Span, queueSize),
+		flushed: make(chan struct{}),
+	}
+	go t.run()
+	return t, nil
+}
+
+// StartRequest starts the server span of an incoming request, continuing the
+// trace of its traceparent header when there is one. It returns a nil span
+// when the trace is not sampled.
+func (t *Tracer) StartRequest(r *http.Request, name string, attrs ...Attr) (context.Context, *Span) {
+	ctx := r.Context()
+	s := t.newSpan(name, KindServer, attrs)
+	if p, sampled, ok := parseTraceParent(r.Header.Get("traceparent")); ok {
+		if !sampled {
+			return ctx, nil
+		}
+		s.traceID, s.parent = p.traceID, p.spanID
+	} else {
+		randomID(s.traceID[:])
+		if !sampledRatio(s.traceID, t.opts.SampleRatio) {
+			return ctx, nil
+		}
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// newSpan starts a span of this tracer; the caller sets its IDs
+func (t *Tracer) newSpan(name string, kind int, attrs []Attr) *Span {
+	s := &Span{tracer: t, name: name, kind: kind, start: time.Now(), attrs: attrs}
+	randomID(s.spanID[:])
+	return s
+}
+
+// export queues a finished span
+func (t *Tracer) export(s *Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	select {
+	case t.queue <- s:
+	default:
+		t.dropped++
+	}
+}
+
+// run sends the queued spans in batches until the queue is closed
+func (t *Tracer) run() {
+	defer close(t.flushed)
+	ticker := time.NewTicker(t.opts.Interval)
+	defer ticker.Stop()
+	var batch []*Span
+	for {
+		select {
+		case s, ok := <-t.queue:
+			if !ok {
+				t.send(batch)
+				return
+			}
+			if batch = append(batch, s); len(batch) >= t.opts.BatchSize {
+				t.send(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			t.send(batch)
+			batch = nil
+		}
+	}
+}
+
+// send posts a batch of spans to the collector
+func (t *Tracer) send(batch []*Span) {
+	t.mu.Lock()
+	dropped := t.dropped
+	t.dropped = 0
+	t.mu.Unlock()
+	if dropped > 0 {
+		log.Printf("⚠️  Dropped %d spans: the trace export queue was full", dropped)
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(t.encode(batch))
+	if err != nil {
+		log.Printf("⚠️  Could not encode %d spans: %v", len(batch), err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("⚠️  Could not export %d spans: %v", len(batch), err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.opts.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		log.Printf("⚠️  Could not export %d spans: %v", len(batch), err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		log.Printf("⚠️  Collector rejected %d spans: %s %s", len(batch), resp.Status, strings.TrimSpace(string(msg)))
+	}
+}
+
+// Shutdown exports the spans still queued, waiting until ctx ends
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mu.Unlock()
+	select {
+	case <-t.flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// The OTLP/HTTP JSON encoding: IDs are hex strings, 64-bit integers are
+// decimal strings, and field names are lowerCamelCase
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"` // 2 is an error
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// encode converts finished spans into an OTLP export request
+func (t *Tracer) encode(batch []*Span) otlpRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		s.mu.Lock()
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: unixNano(s.start),
+			EndTimeUnixNano:   unixNano(s.end),
+			Attributes:        keyValues(s.attrs),
+		}
+		if s.parent != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		for _, e := range s.events {
+			span.Events = append(span.Events, otlpEvent{TimeUnixNano: unixNano(e.time), Name: e.name, Attributes: keyValues(e.attrs)})
+		}
+		if s.err != "" {
+			span.Status = otlpStatus{Code: 2, Message: s.err}
+		}
+		s.mu.Unlock()
+		spans = append(spans, span)
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: keyValues([]Attr{String("service.name", t.opts.ServiceName)})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "github.com/kekePower/museweb"}, Spans: spans}},
+	}}}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func keyValues(attrs []Attr) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		var value map[string]interface{}
+		switch v := a.Value.(type) {
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		kvs = append(kvs, otlpKeyValue{Key: a.Key, Value: value})
+	}
+	return kvs
+}
@@ -0,0 +1,215 @@
+// Package tracing records OpenTelemetry-compatible traces of page requests
+// and exports them to a collector over OTLP/HTTP (JSON encoding). It covers
+// what MuseWeb needs, not the whole OpenTelemetry API: spans with
+// attributes, events, and errors, W3C trace context propagation, ratio
+// sampling, and batched export.
+//
+// Spans are started from a context. Without a sampled span in the context,
+// Start returns a nil *Span, whose methods do nothing, so code can be
+// instrumented unconditionally.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span kinds, as numbered by OTLP
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+)
+
+// Attr is a span or event attribute
+type Attr struct {
+	Key   string
+	Value interface{} // string, int64, float64, or bool
+}
+
+// String returns a string attribute
+func String(key, value string) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+// Int returns an integer attribute
+func Int(key string, value int) Attr {
+	return Attr{Key: key, Value: int64(value)}
+}
+
+// Bool returns a boolean attribute
+func Bool(key string, value bool) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+// Span is one timed operation of a trace
+type Span struct {
+	tracer  *Tracer
+	traceID [16]byte
+	spanID  [8]byte
+	parent  [8]byte // Zero for root spans
+	name    string
+	kind    int
+	start   time.Time
+
+	mu     sync.Mutex
+	end    time.Time
+	attrs  []Attr
+	events []event
+	err    string
+	ended  bool
+}
+
+// event is something that happened at a point in a span
+type event struct {
+	name  string
+	time  time.Time
+	attrs []Attr
+}
+
+type spanKey struct{}
+
+// remoteParent is a sampled span of another process, from a traceparent header
+type remoteParent struct {
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+// FromContext returns the span in ctx, or nil
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Start starts a child of the span in ctx. Without one it returns ctx and
+// a nil span.
+func Start(ctx context.Context, name string, attrs ...Attr) (context.Context, *Span) {
+	parent := FromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	s := parent.tracer.newSpan(name, KindInternal, attrs)
+	s.traceID, s.parent = parent.traceID, parent.spanID
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// SetAttributes adds attributes to the span
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// AddEvent records that something happened now
+func (s *Span) AddEvent(name string, attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event{name: name, time: time.Now(), attrs: attrs})
+}
+
+// RecordError marks the span as failed with err; nil errors are ignored
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err.Error()
+	s.events = append(s.events, event{name: "exception", time: time.Now(), attrs: []Attr{String("exception.message", err.Error())}})
+}
+
+// fail marks the span as failed without an error event, e.g. for an HTTP
+// error status
+func (s *Span) fail(msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = msg
+}
+
+// End finishes the span and queues it for export; later calls do nothing
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+	s.tracer.export(s)
+}
+
+// TraceParent returns the span's W3C traceparent header value
+func (s *Span) TraceParent() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(s.traceID[:]), hex.EncodeToString(s.spanID[:]))
+}
+
+// TraceID returns the span's trace ID in hex, for logs
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.traceID[:])
+}
+
+// parseTraceParent reads a W3C traceparent header value. ok is false for
+// malformed values; sampled reports the sampled flag.
+func parseTraceParent(h string) (p remoteParent, sampled, ok bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return p, false, false
+	}
+	if _, err := hex.Decode(p.traceID[:], []byte(parts[1])); err != nil || p.traceID == [16]byte{} {
+		return p, false, false
+	}
+	if _, err := hex.Decode(p.spanID[:], []byte(parts[2])); err != nil || p.spanID == [8]byte{} {
+		return p, false, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return p, false, false
+	}
+	return p, flags[0]&1 == 1, true
+}
+
+// randomID fills id with random bytes, never all zero
+func randomID(id []byte) {
+	for {
+		rand.Read(id)
+		for _, b := range id {
+			if b != 0 {
+				return
+			}
+		}
+	}
+}
+
+// sampledRatio decides ratio sampling from the trace ID, so every process
+// seeing the trace decides the same way
+func sampledRatio(traceID [16]byte, ratio float64) bool {
+	switch {
+	case ratio >= 1:
+		return true
+	case ratio <= 0:
+		return false
+	}
+	return float64(binary.BigEndian.Uint64(traceID[8:])>>11)/(1<<53) < ratio
+}
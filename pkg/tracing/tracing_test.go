@@ -0,0 +1,148 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// collector is a fake OTLP/HTTP endpoint keeping the spans it receives
+type collector struct {
+	mu     sync.Mutex
+	spans  []otlpSpan
+	header http.Header
+}
+
+func newCollector(t *testing.T) (*collector, string) {
+	c := &collector{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("export to %s as %q", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decoding export: %v", err)
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.header = r.Header
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				c.spans = append(c.spans, ss.Spans...)
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return c, srv.URL
+}
+
+func (c *collector) byName() map[string]otlpSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	spans := map[string]otlpSpan{}
+	for _, s := range c.spans {
+		spans[s.Name] = s
+	}
+	return spans
+}
+
+func TestRequestTrace(t *testing.T) {
+	c, endpoint := newCollector(t)
+	tracer, err := New(Options{Endpoint: endpoint, SampleRatio: 1, Headers: map[string]string{"X-Api-Key": "secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var upstreamParent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamParent = r.Header.Get("traceparent")
+		io.WriteString(w, "streamed")
+	}))
+	defer upstream.Close()
+	client := &http.Client{Transport: Transport(http.DefaultTransport)}
+
+	handler := tracer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := Start(r.Context(), "backend.call", String("museweb.backend", "test"))
+		defer span.End()
+		span.AddEvent("first token")
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, upstream.URL+"/v1/chat?key=hidden", nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		io.Copy(w, resp.Body)
+		resp.Body.Close()
+		span.RecordError(errors.New("model refused"))
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/about", nil)
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	spans := c.byName()
+	server, call, clientSpan := spans["GET /about"], spans["backend.call"], spans["POST "+strings.TrimPrefix(upstream.URL, "http://")]
+	if server.TraceID != "0af7651916cd43dd8448eb211c80319c" || server.ParentSpanID != "b7ad6b7169203331" || server.Kind != KindServer {
+		t.Errorf("server span did not continue the caller's trace: %+v", server)
+	}
+	if call.TraceID != server.TraceID || call.ParentSpanID != server.SpanID || call.Status.Code != 2 || len(call.Events) != 2 {
+		t.Errorf("backend span: %+v", call)
+	}
+	if clientSpan.ParentSpanID != call.SpanID || clientSpan.Kind != KindClient {
+		t.Errorf("client span: %+v", clientSpan)
+	}
+	if upstreamParent != "00-"+clientSpan.TraceID+"-"+clientSpan.SpanID+"-01" {
+		t.Errorf("upstream got traceparent %q", upstreamParent)
+	}
+	for _, a := range clientSpan.Attributes {
+		if strings.Contains(fmt.Sprint(a.Value), "hidden") {
+			t.Errorf("client span leaks the query: %v", a)
+		}
+	}
+	if c.header.Get("X-Api-Key") != "secret" {
+		t.Error("export headers not sent")
+	}
+}
+
+func TestUnsampled(t *testing.T) {
+	c, endpoint := newCollector(t)
+	tracer, err := New(Options{Endpoint: endpoint, SampleRatio: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var traced bool
+	handler := tracer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "inner")
+		traced = span != nil
+		span.End()
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	tracer.Shutdown(context.Background())
+	if traced || len(c.byName()) > 0 {
+		t.Errorf("unsampled request traced: %v", c.byName())
+	}
+}
+
+func TestParseTraceParent(t *testing.T) {
+	for h, want := range map[string]bool{
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01": true,
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00": true,
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01": false,
+		"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01": false,
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b71692033-01":   false,
+		"garbage": false,
+	} {
+		if _, _, ok := parseTraceParent(h); ok != want {
+			t.Errorf("parseTraceParent(%q) ok = %v, want %v", h, ok, want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package translate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DeepL translates with the DeepL API. Free-plan keys (ending in ":fx") use
+// the free endpoint automatically.
+type DeepL struct {
+	APIKey  string
+	APIBase string
+	Client  *http.Client
+}
+
+// Name implements Translator
+func (d *DeepL) Name() string { return "DeepL" }
+
+// Translate implements Translator
+func (d *DeepL) Translate(ctx context.Context, html, lang string) (string, error) {
+	base := strings.TrimSuffix(d.APIBase, "/")
+	if base == "" {
+		base = "https://api.deepl.com/v2"
+		if strings.HasSuffix(d.APIKey, ":fx") {
+			base = "https://api-free.deepl.com/v2"
+		}
+	}
+	payload := map[string]interface{}{
+		"text":         []string{html},
+		"target_lang":  deepLLanguage(lang),
+		"tag_handling": "html",
+	}
+	var resp struct {
+		Translations []struct {
+			Text string `json:"text"`
+		} `json:"translations"`
+	}
+	header := http.Header{"Authorization": {"DeepL-Auth-Key " + d.APIKey}}
+	if err := postJSON(ctx, d.Client, base+"/translate", header, payload, &resp); err != nil {
+		return "", err
+	}
+	if len(resp.Translations) == 0 {
+		return "", fmt.Errorf("DeepL returned no translation")
+	}
+	return resp.Translations[0].Text, nil
+}
+
+// LibreTranslate translates with a LibreTranslate server
+type LibreTranslate struct {
+	APIKey  string // Only needed for servers that require one
+	APIBase string
+	Client  *http.Client
+}
+
+// Name implements Translator
+func (l *LibreTranslate) Name() string { return "LibreTranslate" }
+
+// Translate implements Translator
+func (l *LibreTranslate) Translate(ctx context.Context, html, lang string) (string, error) {
+	base := strings.TrimSuffix(l.APIBase, "/")
+	if base == "" {
+		base = "https://libretranslate.com"
+	}
+	payload := map[string]interface{}{
+		"q":      html,
+		"source": "auto",
+		"target": baseLanguage(lang),
+		"format": "html",
+	}
+	if l.APIKey != "" {
+		payload["api_key"] = l.APIKey
+	}
+	var resp struct {
+		TranslatedText string `json:"translatedText"`
+	}
+	if err := postJSON(ctx, l.Client, base+"/translate", nil, payload, &resp); err != nil {
+		return "", err
+	}
+	if resp.TranslatedText == "" {
+		return "", fmt.Errorf("LibreTranslate returned no translation")
+	}
+	return resp.TranslatedText, nil
+}
+
+// deepLRegional are the DeepL target languages that keep their region
+var deepLRegional = map[string]bool{"EN-GB": true, "EN-US": true, "PT-BR": true, "PT-PT": true}
+
+// deepLLanguage maps ?lang= values such as "pt_BR" or "de_DE" to DeepL target
+// codes ("PT-BR", "DE")
+func deepLLanguage(lang string) string {
+	code := strings.ToUpper(strings.ReplaceAll(lang, "_", "-"))
+	if deepLRegional[code] {
+		return code
+	}
+	return strings.ToUpper(baseLanguage(lang))
+}
+
+// baseLanguage returns the language part of a code such as "es_ES" or "en-US"
+func baseLanguage(lang string) string {
+	base, _, _ := strings.Cut(strings.ReplaceAll(lang, "_", "-"), "-")
+	return strings.ToLower(base)
+}
+
+// postJSON sends payload as JSON and decodes the JSON response into out
+func postJSON(ctx context.Context, client *http.Client, url string, header http.Header, payload, out interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("translation service returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,94 @@
+// Package translate sends finished pages to an external machine translation
+// service (DeepL or LibreTranslate) instead of asking the model to translate
+// them, and caches the results per route and language.
+package translate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Translator translates an HTML document into a target language, leaving
+// markup and URLs untouched
+type Translator interface {
+	// Name identifies the service in logs
+	Name() string
+	Translate(ctx context.Context, html, lang string) (string, error)
+}
+
+// New creates the translator for provider ("deepl" or "libretranslate").
+// An empty apiBase uses the provider's public endpoint.
+func New(provider, apiKey, apiBase string) (Translator, error) {
+	client := &http.Client{Timeout: 2 * time.Minute}
+	switch provider {
+	case "deepl":
+		if apiKey == "" {
+			return nil, fmt.Errorf("deepl requires an API key")
+		}
+		return &DeepL{APIKey: apiKey, APIBase: apiBase, Client: client}, nil
+	case "libretranslate":
+		return &LibreTranslate{APIKey: apiKey, APIBase: apiBase, Client: client}, nil
+	}
+	return nil, fmt.Errorf("unknown translation provider %q (use deepl or libretranslate)", provider)
+}
+
+// Cache keeps translated pages per (route, lang) for a while. An entry is only
+// used while the prompt it was generated from is unchanged.
+type Cache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[cacheKey]cacheEntry
+}
+
+type cacheKey struct {
+	route, lang string
+}
+
+type cacheEntry struct {
+	version string // Fingerprint of the prompts the page came from
+	html    string
+	expires time.Time
+}
+
+// NewCache creates a cache whose entries expire after ttl
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{ttl: ttl, entries: map[cacheKey]cacheEntry{}}
+}
+
+// Version fingerprints the prompts a page is generated from
+func Version(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// Get returns the cached translation of route in lang for the given prompt version
+func (c *Cache) Get(route, lang, version string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := cacheKey{route, lang}
+	e, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	if e.version != version || time.Now().After(e.expires) {
+		delete(c.entries, key)
+		return "", false
+	}
+	return e.html, true
+}
+
+// Put stores a translation
+func (c *Cache) Put(route, lang, version, html string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[cacheKey{route, lang}] = cacheEntry{version: version, html: html, expires: time.Now().Add(c.ttl)}
+}
@@ -0,0 +1,184 @@
+package utils
+
+import "strings"
+
+// Harmony is the response format of OpenAI's gpt-oss models. A reply is split
+// into channels: "analysis" holds the chain of thought, "commentary" tool
+// calls, and "final" the answer meant for the user:
+//
+//	<|channel|>analysis<|message|>The user wants...<|end|><|start|>assistant<|channel|>final<|message|><!DOCTYPE html>...<|return|>
+//
+// Servers that drop special tokens from the text leave
+// "analysisThe user wants...assistantfinal<!DOCTYPE html>..." instead.
+const (
+	harmonyChannel  = "<|channel|>"
+	harmonyMessage  = "<|message|>"
+	harmonyAnalysis = "analysis"       // Start of a response without special tokens
+	harmonyFinal    = "assistantfinal" // Where its final channel begins
+)
+
+// harmonyStops end the message of a channel
+var harmonyStops = []string{"<|end|>", "<|return|>", "<|call|>", "<|start|>"}
+
+type harmonyMode int
+
+const (
+	harmonyUndecided harmonyMode = iota // Not enough output yet to tell
+	harmonyPlain                        // Not Harmony; everything passes through
+	harmonyTokens                       // Channels marked with special tokens
+	harmonyStripped                     // Channels without special tokens
+)
+
+// HarmonyFilter passes on only the final channel of a streamed Harmony
+// response, so a gpt-oss model's reasoning never reaches the page. Output that
+// is not Harmony passes through unchanged. The zero value is ready to use.
+type HarmonyFilter struct {
+	mode      harmonyMode
+	buf       string // Received but not yet passed on or discarded
+	inMessage bool   // Between <|message|> and the end of that message
+	final     bool   // The current message belongs to the final channel
+}
+
+// Push accepts the next chunk of model output and returns the part of the
+// final channel that can be passed on now
+func (f *HarmonyFilter) Push(chunk string) string {
+	f.buf += chunk
+	if f.mode == harmonyUndecided {
+		f.decide()
+	}
+
+	switch f.mode {
+	case harmonyPlain:
+		out := f.buf
+		f.buf = ""
+		return out
+	case harmonyStripped:
+		if !f.final {
+			i := strings.Index(f.buf, harmonyFinal)
+			if i == -1 {
+				return ""
+			}
+			f.buf = f.buf[i+len(harmonyFinal):]
+			f.final = true
+		}
+		out := f.buf
+		f.buf = ""
+		return out
+	case harmonyTokens:
+		return f.pushTokens()
+	}
+	return ""
+}
+
+// Flush returns whatever is still held back once the model stops. A response
+// that looked like Harmony but never reached a final channel without special
+// tokens is returned whole rather than lost.
+func (f *HarmonyFilter) Flush() string {
+	out := f.buf
+	f.buf = ""
+	switch f.mode {
+	case harmonyTokens:
+		if !f.inMessage || !f.final {
+			return ""
+		}
+	case harmonyStripped:
+		f.final = true
+	}
+	f.mode = harmonyPlain
+	return out
+}
+
+// decide looks at the start of the output to tell Harmony from anything else
+func (f *HarmonyFilter) decide() {
+	start := strings.TrimLeft(f.buf, " \t\r\n")
+	switch {
+	case start == "", strings.HasPrefix("<|", start), strings.HasPrefix(harmonyAnalysis, start):
+		// Could still go either way
+	case strings.HasPrefix(start, "<|"):
+		f.mode = harmonyTokens
+	case strings.HasPrefix(start, harmonyAnalysis):
+		f.mode = harmonyStripped
+	default:
+		f.mode = harmonyPlain
+	}
+}
+
+// pushTokens consumes channel headers and messages, keeping final ones
+func (f *HarmonyFilter) pushTokens() string {
+	var out strings.Builder
+	for {
+		if !f.inMessage {
+			i := strings.Index(f.buf, harmonyMessage)
+			if i == -1 {
+				return out.String()
+			}
+			f.final = harmonyChannelName(f.buf[:i]) == "final"
+			f.buf = f.buf[i+len(harmonyMessage):]
+			f.inMessage = true
+		}
+
+		end, stop := harmonyStop(f.buf)
+		if end == -1 {
+			// Hold back what may be the start of a stop token
+			keep := heldBackMarker(f.buf)
+			if f.final {
+				out.WriteString(f.buf[:keep])
+			}
+			f.buf = f.buf[keep:]
+			return out.String()
+		}
+		if f.final {
+			out.WriteString(f.buf[:end])
+		}
+		f.buf = f.buf[end+len(stop):]
+		f.inMessage = false
+	}
+}
+
+// harmonyChannelName returns the channel named in a message header, ignoring
+// recipients and constraints ("commentary to=functions.x <|constrain|>json").
+// A message without a channel is taken to be the answer.
+func harmonyChannelName(header string) string {
+	i := strings.LastIndex(header, harmonyChannel)
+	if i == -1 {
+		return "final"
+	}
+	name := header[i+len(harmonyChannel):]
+	if j := strings.IndexAny(name, " <"); j != -1 {
+		name = name[:j]
+	}
+	return name
+}
+
+// harmonyStop finds the first stop token in s
+func harmonyStop(s string) (int, string) {
+	end, stop := -1, ""
+	for _, token := range harmonyStops {
+		if i := strings.Index(s, token); i != -1 && (end == -1 || i < end) {
+			end, stop = i, token
+		}
+	}
+	return end, stop
+}
+
+// heldBackMarker returns how much of s can be passed on without splitting a
+// stop token that continues in the next chunk
+func heldBackMarker(s string) int {
+	i := strings.LastIndexByte(s, '<')
+	if i == -1 {
+		return len(s)
+	}
+	for _, token := range harmonyStops {
+		if strings.HasPrefix(token, s[i:]) {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// FinalChannel returns the final channel of a complete Harmony response, or
+// output unchanged when it is not Harmony
+func FinalChannel(output string) string {
+	var f HarmonyFilter
+	return f.Push(output) + f.Flush()
+}
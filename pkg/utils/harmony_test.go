@@ -0,0 +1,77 @@
+package utils
+
+import "testing"
+
+func TestHarmonyFilter(t *testing.T) {
+	const page = "<!DOCTYPE html><html><body><h1>Hi</h1></body></html>"
+	for _, tc := range []struct {
+		name, in, want string
+	}{
+		{"plain HTML", page, page},
+		{"plain text starting like a tag", "<p>an analysis</p>", "<p>an analysis</p>"},
+		{"plain text starting like analysis", "analyst notes", "analyst notes"},
+		{"empty", "", ""},
+		{
+			"special tokens",
+			"<|channel|>analysis<|message|>The user wants a page.<|end|><|start|>assistant<|channel|>final<|message|>" + page + "<|return|>",
+			page,
+		},
+		{
+			"leading whitespace",
+			"\n <|channel|>analysis<|message|>Thinking<|end|><|start|>assistant<|channel|>final<|message|>" + page + "<|end|>",
+			page,
+		},
+		{
+			"tool calls and constraints",
+			"<|channel|>commentary to=functions.weather <|constrain|>json<|message|>{\"city\":\"Oslo\"}<|call|>" +
+				"<|start|>assistant<|channel|>final<|message|>" + page + "<|return|>",
+			page,
+		},
+		{
+			"several final messages",
+			"<|channel|>final<|message|><p>one</p><|end|><|start|>assistant<|channel|>analysis<|message|>hmm<|end|>" +
+				"<|start|>assistant<|channel|>final<|message|><p>two</p><|return|>",
+			"<p>one</p><p>two</p>",
+		},
+		{"message without a channel", "<|start|>assistant<|message|>" + page + "<|end|>", page},
+		{"unfinished final message", "<|channel|>final<|message|>" + page, page},
+		{"no final channel", "<|channel|>analysis<|message|>Only thinking<|end|>", ""},
+		{"stop token look-alike in the answer", "<|channel|>final<|message|>a <|b|> c<|return|>", "a <|b|> c"},
+		{"stripped special tokens", "analysisThe user wants a page.assistantfinal" + page, page},
+		{"stripped without a final channel", "analysisThe user wants a page.", "analysisThe user wants a page."},
+	} {
+		if got := FinalChannel(tc.in); got != tc.want {
+			t.Errorf("%s: FinalChannel = %q, want %q", tc.name, got, tc.want)
+		}
+		// Streamed in chunks of any size, the result is the same
+		for _, size := range []int{1, 2, 3, 7, 16} {
+			var f HarmonyFilter
+			got := ""
+			for i := 0; i < len(tc.in); i += size {
+				got += f.Push(tc.in[i:min(i+size, len(tc.in))])
+			}
+			if got += f.Flush(); got != tc.want {
+				t.Errorf("%s: in chunks of %d = %q, want %q", tc.name, size, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestHarmonyFilterStreamsTheFinalChannel(t *testing.T) {
+	var f HarmonyFilter
+	for _, step := range []struct{ in, want string }{
+		{"<|channel|>analysis<|message|>Let me think", ""},
+		{"<|end|><|start|>assistant<|channel|>final<|message|><html>", "<html>"},
+		{"<body>Hi</body><|", "<body>Hi</body>"}, // May be the start of a stop token
+		{"ret", ""},
+		{"urn|>", ""},
+		{"ignored", ""},
+	} {
+		if got := f.Push(step.in); got != step.want {
+			t.Errorf("Push(%q) = %q, want %q", step.in, got, step.want)
+		}
+	}
+	if got := f.Flush(); got != "" {
+		t.Errorf("Flush = %q after the final message ended", got)
+	}
+}
@@ -7,17 +7,31 @@ import (
 	"log"
 	"regexp"
 	"strings"
+	"sync"
 )
 
 // codeFenceRE removes markdown code fences like ```html and ```
 var codeFenceRE = regexp.MustCompile("```[a-zA-Z]*\\n?|```")
 
-// Global variable to store reasoning model patterns (can be set from main)
-var ReasoningModelPatterns []string
+// reasoningPatterns are the configured reasoning model patterns; they may
+// change while pages are generated when the configuration is reloaded
+var (
+	reasoningMu       sync.RWMutex
+	reasoningPatterns []string
+)
 
 // SetReasoningModelPatterns sets the global list of reasoning model patterns
 func SetReasoningModelPatterns(patterns []string) {
-	ReasoningModelPatterns = patterns
+	reasoningMu.Lock()
+	defer reasoningMu.Unlock()
+	reasoningPatterns = patterns
+}
+
+// ReasoningModelPatterns returns the global list of reasoning model patterns
+func ReasoningModelPatterns() []string {
+	reasoningMu.RLock()
+	defer reasoningMu.RUnlock()
+	return reasoningPatterns
 }
 
 // SanitizeResponse cleans up model output by removing markdown code fences, inline backticks, and think tags with their content.
@@ -160,8 +174,8 @@ func ShouldSanitize(modelName string, enableThinking bool) bool {
 // IsThinkingEnabledModel checks if the model is one that supports the thinking tag
 func IsThinkingEnabledModel(modelName string) bool {
 	// Use configurable patterns if available
-	if len(ReasoningModelPatterns) > 0 {
-		return IsReasoningModel(modelName, ReasoningModelPatterns)
+	if patterns := ReasoningModelPatterns(); len(patterns) > 0 {
+		return IsReasoningModel(modelName, patterns)
 	}
 
 	// Fallback to hardcoded patterns for backward compatibility
@@ -242,6 +256,9 @@ func ProcessModelOutput(rawOutput string, modelName string, enableThinking bool)
 	// Log the raw output length for debugging
 	log.Printf("Processing model output: %d bytes from model %s", len(rawOutput), modelName)
 	
+	// Keep only the final channel of gpt-oss (Harmony) responses
+	rawOutput = FinalChannel(rawOutput)
+
 	// ALWAYS clean up code fences first - this is about markdown artifacts, not thinking content
 	cleaned := CleanupCodeFences(rawOutput)
 	cleaned = codeFenceRE.ReplaceAllString(cleaned, "")
@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// DefaultScrubMask replaces masked text when no mask is configured
+const DefaultScrubMask = "[redacted]"
+
+// scrubWindow is how much output a ScrubWriter holds back, so a match split
+// across chunks is still found. It bounds the length of a maskable match.
+const scrubWindow = 256
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)
+	phonePattern = regexp.MustCompile(`\+?\(?\d[\d ().-]{7,20}\d`)
+	isoDate      = regexp.MustCompile(`\d{4}-\d{2}-\d{2}`)
+)
+
+// Scrubber masks email addresses, phone numbers, and configured terms in
+// generated output. Prompts that include user-submitted data can make a model
+// echo it back; the scrubber keeps it off the page.
+type Scrubber struct {
+	emails bool
+	phones bool
+	terms  *regexp.Regexp  // Whole words, case-insensitive; nil when none are set
+	allow  map[string]bool // Lowercased matches that are never masked
+	mask   string
+}
+
+// NewScrubber creates a scrubber for the enabled kinds of data. Terms are
+// masked as whole words regardless of case; matches listed in allow (such as
+// the site's own contact address) are kept. An empty mask uses DefaultScrubMask.
+func NewScrubber(emails, phones bool, terms, allow []string, mask string) *Scrubber {
+	if mask == "" {
+		mask = DefaultScrubMask
+	}
+	s := &Scrubber{emails: emails, phones: phones, allow: map[string]bool{}, mask: mask}
+	var quoted []string
+	for _, term := range terms {
+		if term = strings.TrimSpace(term); term != "" {
+			quoted = append(quoted, regexp.QuoteMeta(term))
+		}
+	}
+	if len(quoted) > 0 {
+		s.terms = regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+	}
+	for _, a := range allow {
+		s.allow[strings.ToLower(strings.TrimSpace(a))] = true
+	}
+	return s
+}
+
+// patterns returns the enabled patterns
+func (s *Scrubber) patterns() []*regexp.Regexp {
+	var ps []*regexp.Regexp
+	if s.emails {
+		ps = append(ps, emailPattern)
+	}
+	if s.phones {
+		ps = append(ps, phonePattern)
+	}
+	if s.terms != nil {
+		ps = append(ps, s.terms)
+	}
+	return ps
+}
+
+// Scrub returns text with every match masked and how many were masked
+func (s *Scrubber) Scrub(text string) (string, int) {
+	masked := 0
+	for _, p := range s.patterns() {
+		text = p.ReplaceAllStringFunc(text, func(m string) string {
+			if !s.masks(p, m) {
+				return m
+			}
+			masked++
+			return s.mask
+		})
+	}
+	return text, masked
+}
+
+// masks reports whether a match of p is masked
+func (s *Scrubber) masks(p *regexp.Regexp, m string) bool {
+	if s.allow[strings.ToLower(m)] {
+		return false
+	}
+	if p != phonePattern {
+		return true
+	}
+	// Phone numbers need 9 to 15 digits and a "+", "(", or "-", so CSS values,
+	// SVG coordinates, and dates are left alone
+	if isoDate.MatchString(m) {
+		return false
+	}
+	digits := 0
+	for _, r := range m {
+		if unicode.IsDigit(r) {
+			digits++
+		}
+	}
+	return digits >= 9 && digits <= 15 && strings.ContainsAny(m, "+(-")
+}
+
+// safeCut returns where text can be split without cutting a match in two,
+// at or before want
+func (s *Scrubber) safeCut(text string, want int) int {
+	var matches [][]int
+	for _, p := range s.patterns() {
+		matches = append(matches, p.FindAllStringIndex(text, -1)...)
+	}
+	for {
+		cut := want
+		for _, loc := range matches {
+			if loc[0] < cut && cut < loc[1] {
+				cut = loc[0]
+			}
+		}
+		// Split between words, so a term cannot match half a word
+		for cut > 0 && isWordByte(text[cut-1]) && isWordByte(text[cut]) {
+			cut--
+		}
+		if cut == want {
+			return cut
+		}
+		want = cut
+	}
+}
+
+// isWordByte reports whether b can be part of a word, including UTF-8 bytes
+func isWordByte(b byte) bool {
+	return b >= 0x80 || b == '_' || b == '@' || b == '.' || b == '-' || b == '+' ||
+		('0' <= b && b <= '9') || ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z')
+}
+
+// ScrubWriter masks what is written through it before passing it on. The
+// last few hundred bytes are held back until more output arrives or Close is
+// called, so a match split across chunks is still caught.
+type ScrubWriter struct {
+	s       *Scrubber
+	w       io.Writer
+	pending string
+	Masked  int // Matches masked so far
+}
+
+// Writer returns a ScrubWriter writing to w
+func (s *Scrubber) Writer(w io.Writer) *ScrubWriter {
+	return &ScrubWriter{s: s, w: w}
+}
+
+// Write implements io.Writer
+func (sw *ScrubWriter) Write(p []byte) (int, error) {
+	sw.pending += string(p)
+	if len(sw.pending) <= scrubWindow {
+		return len(p), nil
+	}
+	cut := sw.s.safeCut(sw.pending, len(sw.pending)-scrubWindow)
+	if cut == 0 {
+		// One long run without a break, like an inline data URI, is not held
+		// back forever
+		if len(sw.pending) < 16*scrubWindow {
+			return len(p), nil
+		}
+		cut = len(sw.pending) - scrubWindow
+	}
+	if err := sw.emit(sw.pending[:cut]); err != nil {
+		return 0, err
+	}
+	sw.pending = sw.pending[cut:]
+	return len(p), nil
+}
+
+// Close masks and writes what is held back. It does not close the
+// underlying writer and may be called more than once.
+func (sw *ScrubWriter) Close() error {
+	if sw == nil || sw.pending == "" {
+		return nil
+	}
+	rest := sw.pending
+	sw.pending = ""
+	return sw.emit(rest)
+}
+
+// emit masks text and writes it on
+func (sw *ScrubWriter) emit(text string) error {
+	text, n := sw.s.Scrub(text)
+	sw.Masked += n
+	_, err := io.WriteString(sw.w, text)
+	return err
+}
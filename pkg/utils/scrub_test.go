@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScrubber(t *testing.T) {
+	s := NewScrubber(true, true, []string{"Project Falcon", " acme "}, []string{"Hello@Example.com"}, "")
+	for _, tc := range []struct {
+		in, want string
+		masked   int
+	}{
+		{"Write to jane.doe+news@mail.example.org today", "Write to [redacted] today", 1},
+		{"Contact hello@example.com", "Contact hello@example.com", 0}, // Allowed, whatever the case
+		{"Call +47 912 34 567 or (555) 123-4567", "Call [redacted] or [redacted]", 2},
+		{"Posted 2024-06-01, id 123456789", "Posted 2024-06-01, id 123456789", 0},
+		{`<path d="M10.5 20.25 30.125 40"/>`, `<path d="M10.5 20.25 30.125 40"/>`, 0},
+		{"margin: 0 0 12px 24px; width: 1234.5678px", "margin: 0 0 12px 24px; width: 1234.5678px", 0},
+		{"About PROJECT FALCON and Acme.", "About [redacted] and [redacted].", 2},
+		{"Acmeville and macme stay", "Acmeville and macme stay", 0}, // Whole words only
+	} {
+		got, n := s.Scrub(tc.in)
+		if got != tc.want || n != tc.masked {
+			t.Errorf("Scrub(%q) = %q, %d; want %q, %d", tc.in, got, n, tc.want, tc.masked)
+		}
+	}
+
+	emailsOnly := NewScrubber(true, false, nil, nil, "***")
+	if got, _ := emailsOnly.Scrub("a@b.io +1 (555) 123-4567"); got != "*** +1 (555) 123-4567" {
+		t.Errorf("emails only: %q", got)
+	}
+}
+
+func TestScrubWriter(t *testing.T) {
+	s := NewScrubber(true, true, []string{"Project Falcon"}, nil, "")
+	filler := strings.Repeat("<p>Lorem ipsum dolor sit amet.</p>\n", 20)
+	in := filler + "Mail jane@example.com or call +1 (555) 123-4567 about Project Falcon.\n" + filler
+	want := filler + "Mail [redacted] or call [redacted] about [redacted].\n" + filler
+	for _, size := range []int{1, 5, 64, 300, len(in)} {
+		var out strings.Builder
+		w := s.Writer(&out)
+		for i := 0; i < len(in); i += size {
+			if _, err := w.Write([]byte(in[i:min(i+size, len(in))])); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if out.Len() == 0 && len(in) > scrubWindow {
+			t.Errorf("chunks of %d: nothing written before Close", size)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got := out.String(); got != want || w.Masked != 3 {
+			t.Errorf("chunks of %d: masked %d\n got %q\nwant %q", size, w.Masked, got, want)
+		}
+		if err := w.Close(); err != nil || out.String() != want {
+			t.Errorf("chunks of %d: second Close wrote more", size)
+		}
+	}
+
+	// A long run without a break is not held back forever
+	var out strings.Builder
+	w := s.Writer(&out)
+	w.Write([]byte(strings.Repeat("A", 20*scrubWindow)))
+	if out.Len() == 0 {
+		t.Error("an unbroken run was held back whole")
+	}
+}